@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	x402 "github.com/coinbase/x402/go"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// hexAddress matches a 0x-prefixed, 20-byte hex address.
+var hexAddress = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+
+// parseAddress validates an EVM address given on the command line or by a server: 0x and
+// 40 hex digits, a correct EIP-55 checksum when it mixes upper and lower case, and not the
+// zero address, which no one holds the key of. what names the address in the error.
+func parseAddress(s, what string) (common.Address, error) {
+	if !hexAddress.MatchString(s) {
+		return common.Address{}, fmt.Errorf("invalid %s address %q: want 0x and 40 hex digits", what, s)
+	}
+	addr := common.HexToAddress(s)
+	digits := s[2:]
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) && addr.Hex() != s {
+		return common.Address{}, fmt.Errorf("invalid %s address %s: bad EIP-55 checksum (did you mean %s?)", what, s, addr.Hex())
+	}
+	if addr == (common.Address{}) {
+		return common.Address{}, fmt.Errorf("refusing the zero %s address %s", what, s)
+	}
+	return addr, nil
+}
+
+// checksumAddress returns s in its EIP-55 checksummed form for display, or s unchanged
+// when it is not an EVM address.
+func checksumAddress(s string) string {
+	if !hexAddress.MatchString(s) {
+		return s
+	}
+	return common.HexToAddress(s).Hex()
+}
+
+// checkPayTo validates the recipient of the payment option about to be signed, so a
+// malformed or zero payTo from the server is refused before any funds can move.
+func checkPayTo(selected x402.PaymentRequirements) error {
+	if !strings.HasPrefix(selected.Network, "eip155:") {
+		return nil
+	}
+	_, err := parseAddress(selected.PayTo, "payTo")
+	return err
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// affordability says whether the wallet can cover one accepts entry.
+type affordability struct {
+	Network string `json:"network"`
+	Asset   string `json:"asset"`
+	Amount  string `json:"amount"`
+	// Tier is the entry's price tier, when the server offers tiers.
+	Tier string `json:"tier,omitempty"`
+	// Balance is the wallet's balance of the asset on the network, in atomic units.
+	Balance string `json:"balance,omitempty"`
+	// Affordable is null when the balance could not be checked.
+	Affordable *bool  `json:"affordable"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// tokenBalance returns address's atomic balance of asset on a known network. USDC goes
+// through the balance cache; other tokens are queried with balanceOf directly.
+func tokenBalance(info networkInfo, asset, address string) (string, error) {
+	if strings.EqualFold(asset, info.USDCContract) {
+		_, raw, _, err := usdcBalance(info, address, false)
+		return raw, err
+	}
+	_, raw, err := queryUSDCBalance(info.RPCURL, asset, address)
+	return raw, err
+}
+
+// affordabilityMatrix checks every accepts entry in the payment requirements against the
+// wallet's balance on that entry's network, using balanceOf to look balances up.
+func affordabilityMatrix(requirements []byte, address string, balanceOf func(info networkInfo, asset, address string) (string, error)) ([]affordability, error) {
+	var payReq struct {
+		Accepts []struct {
+			Network string                 `json:"network"`
+			Asset   string                 `json:"asset"`
+			Amount  string                 `json:"amount"`
+			Extra   map[string]interface{} `json:"extra"`
+		} `json:"accepts"`
+	}
+	if err := json.Unmarshal(requirements, &payReq); err != nil {
+		return nil, fmt.Errorf("invalid payment requirements: %w", err)
+	}
+
+	rows := make([]affordability, 0, len(payReq.Accepts))
+	for _, a := range payReq.Accepts {
+		row := affordability{Network: a.Network, Asset: a.Asset, Amount: a.Amount}
+		row.Tier, _ = acceptTier(a.Extra)
+		info, known := networkByChainID(a.Network)
+		amount, validAmount := new(big.Int).SetString(a.Amount, 10)
+		switch {
+		case !known:
+			row.Reason = "unknown network: no RPC to check the balance"
+		case !validAmount:
+			row.Reason = fmt.Sprintf("invalid amount %q", a.Amount)
+		default:
+			raw, err := balanceOf(info, a.Asset, routedAddress(a.Network, address))
+			if err != nil {
+				row.Reason = "balance check failed: " + err.Error()
+				break
+			}
+			ok := !balanceBelow(raw, amount)
+			row.Balance, row.Affordable = raw, &ok
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// printAffordability renders the affordability matrix as a table.
+func printAffordability(rows []affordability) {
+	fmt.Println("\n--- Affordability ---")
+	tiered := false
+	for _, r := range rows {
+		tiered = tiered || r.Tier != ""
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if tiered {
+		fmt.Fprintln(w, "#\t\tNETWORK\tTIER\tCOST\tBALANCE\tNOTE")
+	} else {
+		fmt.Fprintln(w, "#\t\tNETWORK\tCOST\tBALANCE\tNOTE")
+	}
+	for i, r := range rows {
+		req := x402.PaymentRequirements{Amount: r.Amount, Asset: r.Asset, Network: r.Network}
+		symbol, balance := "?", "-"
+		if r.Affordable != nil {
+			symbol = "✗"
+			if *r.Affordable {
+				symbol = "✓"
+			}
+			held := req
+			held.Amount = r.Balance
+			balance = tokenAmount(held)
+		}
+		network := networkName(r.Network)
+		if tiered {
+			network += "\t" + dashIfEmpty(r.Tier)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", i+1, symbol, network, tokenAmount(req), balance, r.Reason)
+	}
+	w.Flush()
+}
+
+// tokenAmount is describeAmount without the atomic units, for table cells.
+func tokenAmount(req x402.PaymentRequirements) string {
+	if decimals, ok := assetDecimals(req); ok {
+		return atomicToHuman(req.Amount, decimals) + " " + assetSymbol(req)
+	}
+	return req.Amount + " (atomic units)"
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// amountFormat controls how token amounts are shown to people: in confirmations,
+// wallet output, and the human-readable fields of JSON output (raw fields are unaffected).
+type amountFormat struct {
+	// Decimals is a fixed number of decimal places (rounded half up), or -1 to show
+	// every significant digit with trailing zeros trimmed down to MinDecimals.
+	Decimals    int
+	MinDecimals int
+	Thousands   string
+	Point       string
+}
+
+// amountFmt is the active format, set by the global --amount-format flag or X402_AMOUNT_FORMAT.
+var amountFmt = amountFormat{Decimals: -1, MinDecimals: 2, Point: "."}
+
+// amountLocales are the separator conventions accepted by locale=.
+var amountLocales = map[string]struct{ thousands, point string }{
+	"plain": {"", "."},
+	"en":    {",", "."},
+	"de":    {".", ","},
+	"es":    {".", ","},
+	"it":    {".", ","},
+	"pt":    {".", ","},
+	"fr":    {" ", ","},
+	"ch":    {"'", "."},
+}
+
+// amountSeparators are the names accepted by thousands= and point=.
+var amountSeparators = map[string]string{
+	"none":       "",
+	"comma":      ",",
+	"dot":        ".",
+	"space":      " ",
+	"apostrophe": "'",
+	"underscore": "_",
+}
+
+// parseAmountFormat parses an --amount-format spec such as "locale=de,decimals=2":
+// comma-separated locale=, decimals=, thousands=, and point= settings. locale= sets
+// both separators; thousands= and point= override it.
+func parseAmountFormat(spec string) (amountFormat, error) {
+	f := amountFmt
+	settings := map[string]string{}
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return f, fmt.Errorf("invalid amount format %q: want key=value", item)
+		}
+		settings[strings.ToLower(key)] = strings.ToLower(value)
+	}
+
+	if name, ok := settings["locale"]; ok {
+		loc, ok := amountLocales[strings.SplitN(strings.ReplaceAll(name, "_", "-"), "-", 2)[0]]
+		if !ok {
+			return f, fmt.Errorf("unknown locale %q (available: plain, en, de, es, it, pt, fr, ch)", name)
+		}
+		f.Thousands, f.Point = loc.thousands, loc.point
+		delete(settings, "locale")
+	}
+	for key, value := range settings {
+		switch key {
+		case "decimals":
+			if value == "auto" {
+				f.Decimals = -1
+				continue
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || n > 18 {
+				return f, fmt.Errorf("invalid decimals %q: want auto or 0-18", value)
+			}
+			f.Decimals = n
+		case "thousands", "point":
+			sep, ok := amountSeparators[value]
+			if !ok {
+				return f, fmt.Errorf("invalid %s separator %q (available: none, comma, dot, space, apostrophe, underscore)", key, value)
+			}
+			if key == "thousands" {
+				f.Thousands = sep
+			} else {
+				f.Point = sep
+			}
+		default:
+			return f, fmt.Errorf("unknown amount format setting %q (available: locale, decimals, thousands, point)", key)
+		}
+	}
+	if f.Point == "" || f.Point == f.Thousands {
+		return f, fmt.Errorf("decimal point %q must be set and differ from the thousands separator", f.Point)
+	}
+	return f, nil
+}
+
+// format renders a raw integer amount with the given number of token decimals.
+func (f amountFormat) format(raw string, decimals int) string {
+	amount, ok := new(big.Int).SetString(raw, 10)
+	if !ok {
+		return raw
+	}
+	sign := ""
+	if amount.Sign() < 0 {
+		sign = "-"
+		amount.Abs(amount)
+	}
+
+	places := decimals
+	if f.Decimals >= 0 && f.Decimals < decimals {
+		// Round half up to the requested number of places.
+		unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals-f.Decimals)), nil)
+		rem := new(big.Int)
+		amount.DivMod(amount, unit, rem)
+		if rem.Lsh(rem, 1).Cmp(unit) >= 0 {
+			amount.Add(amount, big.NewInt(1))
+		}
+		places = f.Decimals
+	}
+
+	divisor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(places)), nil)
+	whole, frac := new(big.Int).DivMod(amount, divisor, new(big.Int))
+	fracStr := ""
+	if places > 0 {
+		fracStr = fmt.Sprintf("%0*s", places, frac.String())
+	}
+	switch {
+	case f.Decimals < 0:
+		keep := min(f.MinDecimals, places)
+		fracStr = strings.TrimRight(fracStr, "0")
+		if len(fracStr) < keep {
+			fracStr += strings.Repeat("0", keep-len(fracStr))
+		}
+	case f.Decimals > places:
+		fracStr += strings.Repeat("0", f.Decimals-places)
+	}
+
+	out := sign + groupThousands(whole.String(), f.Thousands)
+	if fracStr != "" {
+		out += f.Point + fracStr
+	}
+	return out
+}
+
+// groupThousands inserts sep between groups of three digits.
+func groupThousands(digits, sep string) string {
+	if sep == "" || len(digits) <= 3 {
+		return digits
+	}
+	var b strings.Builder
+	head := len(digits) % 3
+	if head > 0 {
+		b.WriteString(digits[:head])
+	}
+	for i := head; i < len(digits); i += 3 {
+		if b.Len() > 0 {
+			b.WriteString(sep)
+		}
+		b.WriteString(digits[i : i+3])
+	}
+	return b.String()
+}
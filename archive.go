@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveIndex is the index file --archive keeps next to the archived responses.
+const archiveIndex = "index.jsonl"
+
+// archiveEntry is one line of the archive index: a purchased response and what paid for it.
+type archiveEntry struct {
+	File        string    `json:"file"` // relative to the archive directory
+	Time        time.Time `json:"time"`
+	Endpoint    string    `json:"endpoint"`
+	Method      string    `json:"method"`
+	URLHash     string    `json:"urlHash"`
+	StatusCode  int       `json:"statusCode"`
+	ContentType string    `json:"contentType,omitempty"`
+	Bytes       int       `json:"bytes"`
+	SHA256      string    `json:"sha256"` // of the body
+	RequestID   string    `json:"requestId,omitempty"`
+	PaymentID   string    `json:"paymentId,omitempty"`
+	Network     string    `json:"network,omitempty"`
+	Asset       string    `json:"asset,omitempty"`
+	Amount      string    `json:"amount,omitempty"`
+	Transaction string    `json:"transaction,omitempty"`
+}
+
+// archiveName is the file a response to endpoint at t is stored as: the first 16 hex
+// digits of the URL's SHA-256, the UTC time, and an extension for the content type, so
+// responses of one URL sort together and in order.
+func archiveName(endpoint, contentType string, t time.Time) (name, urlHash string) {
+	sum := sha256.Sum256([]byte(endpoint))
+	urlHash = hex.EncodeToString(sum[:])[:16]
+	return urlHash + "-" + t.UTC().Format("20060102T150405.000Z") + archiveExt(contentType), urlHash
+}
+
+// archiveExt picks a file extension for a response's content type.
+func archiveExt(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case lineStreamTypes[mediaType]:
+		return ".jsonl"
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return ".json"
+	case mediaType == "text/plain":
+		return ".txt"
+	case mediaType == "text/html":
+		return ".html"
+	case mediaType == "text/csv":
+		return ".csv"
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// archiveResponse stores a paid response's body in dir and appends its entry to the
+// index, which is locked so that concurrent runs can share one archive.
+func archiveResponse(dir string, entry archiveEntry, resp *http.Response, body []byte) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	entry.ContentType = resp.Header.Get("Content-Type")
+	entry.File, entry.URLHash = archiveName(entry.Endpoint, entry.ContentType, entry.Time)
+	entry.StatusCode = resp.StatusCode
+	entry.Bytes = len(body)
+	sum := sha256.Sum256(body)
+	entry.SHA256 = hex.EncodeToString(sum[:])
+
+	// Runs in the same millisecond get a counter rather than overwriting each other.
+	const create = os.O_CREATE | os.O_EXCL | os.O_WRONLY
+	ext := filepath.Ext(entry.File)
+	base := strings.TrimSuffix(entry.File, ext)
+	out, err := os.OpenFile(filepath.Join(dir, entry.File), create, 0644)
+	for n := 2; os.IsExist(err); n++ {
+		entry.File = fmt.Sprintf("%s-%d%s", base, n, ext)
+		out, err = os.OpenFile(filepath.Join(dir, entry.File), create, 0644)
+	}
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, entry.File)
+	if _, err := out.Write(body); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, archiveIndex), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return "", fmt.Errorf("failed to lock the archive index: %w", err)
+	}
+	defer unlockFile(f)
+	line, _ := json.Marshal(entry)
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// artifacts records the current run when --artifacts-dir is set; nil otherwise.
+var artifacts *artifactRecorder
+
+// artifactRecorder writes the evidence of one run into its own directory:
+//
+//	request.json       method, URL, headers, and body sent
+//	probe.json/.body   Step 1 status, headers, and raw body
+//	requirements.json  decoded payment requirements
+//	payment.json       decoded signed payment (PAYMENT-SIGNATURE or X-PAYMENT)
+//	response.json/.body Step 2 status, headers, and raw body
+//	timings.json       per-step durations in milliseconds
+//	result.json        the --json result and exit code
+//
+// With --debug-bundle, credentials are redacted, decoded PAYMENT-RESPONSE headers and
+// environment.json are added, and the directory is zipped into the bundle on close.
+type artifactRecorder struct {
+	dir     string
+	start   time.Time
+	timings map[string]int64
+	result  *jsonResult
+	failed  bool
+	bundle  string // --debug-bundle path; "" when not bundling
+	tempDir bool   // dir was created only for the bundle and is removed after zipping
+}
+
+// httpArtifact is the status and headers of a recorded request or response.
+type httpArtifact struct {
+	Method     string      `json:"method,omitempty"`
+	URL        string      `json:"url,omitempty"`
+	StatusCode int         `json:"statusCode,omitempty"`
+	Headers    http.Header `json:"headers"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// artifactID names the artifacts directory of a run: the --trace-id, else the first 8
+// characters of the request ID, which -H X-Request-ID may have set to something shorter or
+// empty.
+func artifactID(traceID, requestID string) string {
+	switch {
+	case traceID != "":
+		return traceID
+	case len(requestID) > 8:
+		return requestID[:8]
+	case requestID != "":
+		return requestID
+	}
+	return newTraceID()[:8]
+}
+
+// newArtifactRecorder creates <root>/<UTC timestamp>-<id> for this run.
+func newArtifactRecorder(root, id string, result *jsonResult) (*artifactRecorder, error) {
+	start := time.Now()
+	dir := filepath.Join(root, start.UTC().Format("20060102T150405Z")+"-"+id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &artifactRecorder{dir: dir, start: start, timings: map[string]int64{}, result: result}, nil
+}
+
+// write stores one artifact file. Failures are reported once and do not stop the run.
+func (a *artifactRecorder) write(name string, data []byte) {
+	if a == nil {
+		return
+	}
+	if err := os.WriteFile(filepath.Join(a.dir, name), data, 0644); err != nil && !a.failed {
+		a.failed = true
+		fmt.Fprintf(os.Stderr, "Warning: failed to write artifacts to %s: %v\n", a.dir, err)
+	}
+}
+
+// writeJSON stores v as indented JSON.
+func (a *artifactRecorder) writeJSON(name string, v any) {
+	if a == nil {
+		return
+	}
+	out, _ := json.MarshalIndent(v, "", "  ")
+	a.write(name, append(out, '\n'))
+}
+
+// writeRequest records the request sent in Step 1.
+func (a *artifactRecorder) writeRequest(req *http.Request, data string) {
+	if a == nil {
+		return
+	}
+	headers := req.Header
+	if a.bundle != "" {
+		headers = redactHeaders(headers)
+	}
+	a.writeJSON("request.json", httpArtifact{Method: req.Method, URL: req.URL.String(), Headers: headers, Body: data})
+}
+
+// writeResponse records a response as <name>.json and its raw body as <name>.body.
+func (a *artifactRecorder) writeResponse(name string, resp *http.Response, body []byte) {
+	if a == nil {
+		return
+	}
+	headers := resp.Header
+	if a.bundle != "" {
+		headers = redactHeaders(headers)
+		for _, h := range []string{"PAYMENT-RESPONSE", "X-PAYMENT-RESPONSE"} {
+			if decoded, err := base64.StdEncoding.DecodeString(resp.Header.Get(h)); err == nil && len(decoded) > 0 {
+				a.write(name+".payment-response.json", decoded)
+			}
+		}
+	}
+	a.writeJSON(name+".json", httpArtifact{StatusCode: resp.StatusCode, Headers: headers})
+	a.write(name+".body", body)
+}
+
+// mark records how long a step took.
+func (a *artifactRecorder) mark(step string, d time.Duration) {
+	if a == nil {
+		return
+	}
+	a.timings[step] = d.Milliseconds()
+}
+
+// close writes the timings and final result, then the debug bundle if requested.
+func (a *artifactRecorder) close(code int) {
+	if a == nil {
+		return
+	}
+	a.timings["total"] = time.Since(a.start).Milliseconds()
+	a.writeJSON("timings.json", a.timings)
+	a.writeJSON("result.json", struct {
+		*jsonResult
+		ExitCode int `json:"exitCode"`
+	}{a.result, code})
+	if a.bundle == "" {
+		return
+	}
+	a.writeJSON("environment.json", newBundleEnvironment())
+	if err := writeZip(a.bundle, a.dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to write debug bundle %s: %v\n", a.bundle, err)
+	} else if a.tempDir {
+		os.RemoveAll(a.dir)
+	}
+}
+
+// transport wraps rt to record the signed payment header the x402 client sends.
+func (a *artifactRecorder) transport(rt http.RoundTripper) http.RoundTripper {
+	if a == nil {
+		return rt
+	}
+	return onPaymentHeader(rt, func(header string) {
+		if decoded, err := base64.StdEncoding.DecodeString(header); err == nil {
+			a.write("payment.json", decoded)
+		} else {
+			a.write("payment.json", []byte(header))
+		}
+	})
+}
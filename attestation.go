@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+	x402http "github.com/coinbase/x402/go/http"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// facilitatorAttestation is a facilitator's signature over its settle response, carried in
+// the PAYMENT-RESPONSE header as "attestation".
+type facilitatorAttestation struct {
+	Facilitator string `json:"facilitator,omitempty"`
+	Signer      string `json:"signer"`
+	// Signature is EIP-191 (personal_sign) over attestedMessage.
+	Signature string `json:"signature"`
+}
+
+// attestationCheck is how the attestation of an accepted payment's PAYMENT-RESPONSE held up.
+type attestationCheck struct {
+	// Status is "verified" (signed by a trusted facilitator key), "untrusted" (validly
+	// signed, by a key the facilitator does not publish), "invalid" (the signature does not
+	// match the response, or the response is not this payment's), "unchecked" (the trusted
+	// keys could not be fetched), or "absent".
+	Status      string `json:"status"`
+	Facilitator string `json:"facilitator,omitempty"`
+	Signer      string `json:"signer,omitempty"`
+	// KeySource is where the trusted keys came from: "pinned" (--facilitator-keys) or the
+	// facilitator URL whose /supported signers were used.
+	KeySource string `json:"keySource,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// attestedMessage is what an attestation signs: the settle response without its
+// attestation, as compact JSON with the keys of every object, nested ones too, sorted.
+func attestedMessage(fields map[string]json.RawMessage) []byte {
+	rest := make(map[string]any, len(fields))
+	for k, v := range fields {
+		if k == "attestation" {
+			continue
+		}
+		// Decoded into maps, which json.Marshal writes sorted; numbers keep their digits.
+		dec := json.NewDecoder(bytes.NewReader(v))
+		dec.UseNumber()
+		var value any
+		if err := dec.Decode(&value); err != nil {
+			rest[k] = v
+			continue
+		}
+		rest[k] = value
+	}
+	out, _ := json.Marshal(rest)
+	return out
+}
+
+// attestedPayment returns an error unless the attested settle response is a successful
+// settlement of this payment: by payer, on the chosen requirement's network, for its
+// amount when the response states one, with a transaction. A validly signed attestation
+// of another payment must not vouch for this one.
+func attestedPayment(settle []byte, payer string, price x402.PaymentRequirements) error {
+	var resp struct {
+		x402.SettleResponse
+		Amount string `json:"amount"`
+	}
+	if err := json.Unmarshal(settle, &resp); err != nil {
+		return fmt.Errorf("PAYMENT-RESPONSE is not a settle response: %w", err)
+	}
+	switch {
+	case !resp.Success:
+		return errors.New("the attested response is not a successful settlement")
+	case !common.IsHexAddress(resp.Payer) || common.HexToAddress(resp.Payer) != common.HexToAddress(payer):
+		return fmt.Errorf("the attested payer is %q, not this payment's %s", resp.Payer, payer)
+	case canonicalNetwork(string(resp.Network)) != canonicalNetwork(price.Network):
+		return fmt.Errorf("the attested network is %q, not this payment's %s", resp.Network, price.Network)
+	case resp.Amount != "" && resp.Amount != price.Amount:
+		return fmt.Errorf("the attested amount is %s, not this payment's %s", resp.Amount, price.Amount)
+	case resp.Transaction == "":
+		return errors.New("the attested response names no settlement transaction")
+	}
+	return nil
+}
+
+// attestationSigner returns the address that signed the attestation in a decoded
+// PAYMENT-RESPONSE, the attestation itself, and an error when it is malformed or the
+// signature is not the attestation's signer's; a nil attestation means there was none.
+func attestationSigner(settle []byte) (*facilitatorAttestation, string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(settle, &fields); err != nil {
+		return nil, "", fmt.Errorf("PAYMENT-RESPONSE is not a JSON object: %w", err)
+	}
+	raw, ok := fields["attestation"]
+	if !ok {
+		return nil, "", nil
+	}
+	var att facilitatorAttestation
+	if err := json.Unmarshal(raw, &att); err != nil {
+		return &att, "", fmt.Errorf("malformed attestation: %w", err)
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(att.Signature, "0x"))
+	if err != nil || len(sig) != 65 {
+		return &att, "", errors.New("malformed attestation signature")
+	}
+	if sig[64] >= 27 {
+		sig[64] -= 27
+	}
+	pub, err := crypto.SigToPub(accounts.TextHash(attestedMessage(fields)), sig)
+	if err != nil {
+		return &att, "", fmt.Errorf("bad attestation signature: %w", err)
+	}
+	recovered := crypto.PubkeyToAddress(*pub).Hex()
+	if !common.IsHexAddress(att.Signer) || common.HexToAddress(att.Signer).Hex() != recovered {
+		return &att, recovered, fmt.Errorf("the response was signed by %s, not the attestation's signer %s", recovered, att.Signer)
+	}
+	return &att, recovered, nil
+}
+
+// parseFacilitatorKeys parses --facilitator-keys: comma-separated addresses.
+func parseFacilitatorKeys(spec string) ([]string, error) {
+	var keys []string
+	for _, k := range strings.Split(spec, ",") {
+		if k = strings.TrimSpace(k); k == "" {
+			continue
+		}
+		if !common.IsHexAddress(k) {
+			return nil, fmt.Errorf("--facilitator-keys: %q is not an address", k)
+		}
+		keys = append(keys, common.HexToAddress(k).Hex())
+	}
+	return keys, nil
+}
+
+// publishedSigners returns the signer addresses a facilitator's /supported lists for network,
+// under the network itself or its CAIP family (e.g. "eip155:*").
+func publishedSigners(facilitatorURL, network string, timeout time.Duration) ([]string, error) {
+	fc := x402http.NewHTTPFacilitatorClient(&x402http.FacilitatorConfig{URL: facilitatorURL, Timeout: timeout})
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	supported, err := fc.GetSupported(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return signersFor(supported, network), nil
+}
+
+// signersFor picks network's signers out of a /supported response.
+func signersFor(supported x402.SupportedResponse, network string) []string {
+	var signers []string
+	for family, addrs := range supported.Signers {
+		prefix, wildcard := strings.CutSuffix(family, "*")
+		if family == network || (wildcard && strings.HasPrefix(network, prefix)) {
+			signers = append(signers, addrs...)
+		}
+	}
+	return signers
+}
+
+// checkAttestation verifies the attestation of a decoded PAYMENT-RESPONSE to the payment payer
+// made for price, against the pinned keys, or else the keys facilitatorURL publishes. The
+// facilitator the attestation names is reported but never used to find keys: the resource
+// server could name one it runs itself.
+func checkAttestation(settle []byte, payer string, price x402.PaymentRequirements, pinned []string, facilitatorURL string, timeout time.Duration) *attestationCheck {
+	att, signer, err := attestationSigner(settle)
+	if att == nil && err == nil {
+		return &attestationCheck{Status: "absent"}
+	}
+	check := &attestationCheck{Signer: signer}
+	if att != nil {
+		check.Facilitator = att.Facilitator
+	}
+	if err == nil {
+		err = attestedPayment(settle, payer, price)
+	}
+	if err != nil {
+		check.Status, check.Error = "invalid", err.Error()
+		return check
+	}
+	trusted, source := pinned, "pinned"
+	if len(trusted) == 0 {
+		var resp x402.SettleResponse
+		json.Unmarshal(settle, &resp)
+		source = facilitatorURL
+		if trusted, err = publishedSigners(facilitatorURL, string(resp.Network), timeout); err != nil {
+			check.Status, check.KeySource = "unchecked", source
+			check.Error = fmt.Sprintf("fetching the signers %s publishes: %v", facilitatorURL, err)
+			return check
+		}
+	}
+	check.KeySource = source
+	for _, k := range trusted {
+		if common.IsHexAddress(k) && common.HexToAddress(k).Hex() == signer {
+			check.Status = "verified"
+			return check
+		}
+	}
+	check.Status = "untrusted"
+	check.Error = fmt.Sprintf("%s is not among the facilitator keys (%s)", signer, source)
+	return check
+}
+
+// logAttestation prints the attestation check of the payment summary.
+func logAttestation(log func(string, ...interface{}), c *attestationCheck) {
+	switch c.Status {
+	case "verified":
+		source := "pinned with --facilitator-keys"
+		if c.KeySource != "pinned" {
+			source = "published by " + c.KeySource
+		}
+		log("Facilitator attestation: verified (signed by %s, %s)\n", c.Signer, source)
+	case "absent":
+		log("Facilitator attestation: none; the PAYMENT-RESPONSE is unauthenticated\n")
+	default:
+		log("Facilitator attestation: %s: %s\n", c.Status, c.Error)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultBalanceTTL is how long a cached balance is reused; X402_BALANCE_TTL overrides it.
+const defaultBalanceTTL = 30 * time.Second
+
+// refreshBalances bypasses the balance cache for this run (wallet --refresh).
+var refreshBalances bool
+
+// cachedBalance is one balance in balances.json.
+type cachedBalance struct {
+	Raw  string    `json:"raw"`
+	Time time.Time `json:"time"`
+}
+
+// balanceTTL returns the cache lifetime; 0 disables the cache.
+func balanceTTL() time.Duration {
+	if v := os.Getenv("X402_BALANCE_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+	}
+	return defaultBalanceTTL
+}
+
+// balanceCacheKey identifies a token balance: chain, token contract, and holder.
+func balanceCacheKey(info networkInfo, address string) string {
+	return info.ChainID + "/" + strings.ToLower(info.USDCContract) + "/" + strings.ToLower(address)
+}
+
+// usdcBalance is queryUSDCBalance through the balance cache, so repeated wallet calls and
+// pre-payment checks don't hit public RPCs each time. cachedAt is zero for a live query.
+func usdcBalance(info networkInfo, address string, live bool) (human, raw string, cachedAt time.Time, err error) {
+	ttl := balanceTTL()
+	key := balanceCacheKey(info, address)
+	if !live && ttl > 0 {
+		if entry, ok := readBalanceCache()[key]; ok && time.Since(entry.Time) < ttl {
+			return atomicToHuman(entry.Raw, info.Decimals), entry.Raw, entry.Time, nil
+		}
+	}
+	human, raw, err = queryUSDCBalance(info.RPCURL, info.USDCContract, address)
+	if err != nil || ttl == 0 {
+		return human, raw, time.Time{}, err
+	}
+	updateBalanceCache(key, cachedBalance{Raw: raw, Time: time.Now()}, ttl)
+	return human, raw, time.Time{}, nil
+}
+
+// readBalanceCache loads balances.json; the cache is best-effort, so any failure reads as empty.
+func readBalanceCache() map[string]cachedBalance {
+	path, err := stateFile("balances.json")
+	if err != nil {
+		return nil
+	}
+	unlock, err := lockState("balances.json")
+	if err != nil {
+		return nil
+	}
+	defer unlock()
+	return loadBalanceCache(path)
+}
+
+func loadBalanceCache(path string) map[string]cachedBalance {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	if data, err = openLine(data); err != nil {
+		return nil
+	}
+	var cache map[string]cachedBalance
+	json.Unmarshal(data, &cache)
+	return cache
+}
+
+// updateBalanceCache stores one balance and drops entries older than ttl.
+func updateBalanceCache(key string, entry cachedBalance, ttl time.Duration) {
+	path, err := stateFile("balances.json")
+	if err != nil {
+		return
+	}
+	unlock, err := lockState("balances.json")
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	cache := loadBalanceCache(path)
+	if cache == nil {
+		cache = map[string]cachedBalance{}
+	}
+	for k, v := range cache {
+		if time.Since(v.Time) >= ttl {
+			delete(cache, k)
+		}
+	}
+	cache[key] = entry
+	data, _ := json.Marshal(cache)
+	// Balances reveal addresses and holdings, so they follow X402_ENCRYPT_STATE like the ledger.
+	if stateEncryption() {
+		if data, err = sealLine(data); err != nil {
+			return
+		}
+	}
+	tmp := path + ".tmp"
+	if os.MkdirAll(filepath.Dir(path), 0700) != nil || os.WriteFile(tmp, data, 0600) != nil {
+		return
+	}
+	os.Rename(tmp, path)
+}
+
+// cacheAge describes how old a cached balance is, e.g. "cached 12s ago".
+func cacheAge(cachedAt time.Time) string {
+	return "cached " + time.Since(cachedAt).Round(time.Second).String() + " ago"
+}
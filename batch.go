@@ -0,0 +1,504 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+	x402evm "github.com/coinbase/x402/go/mechanisms/evm"
+)
+
+// batchResult is the outcome of one endpoint in a batch run.
+type batchResult struct {
+	// RequestID is the batch run's ID, sent as X-Request-ID to every endpoint.
+	RequestID   string `json:"requestId"`
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	Method      string `json:"method"`
+	Status      string `json:"status"` // "accepted", "rejected", "insufficient_funds", "budget_exceeded", "payment_required" (--dry-run), "free", "no_402", or "error"
+	StatusCode  int    `json:"statusCode,omitempty"`
+	Price       string `json:"price,omitempty"`
+	Amount      string `json:"amount,omitempty"`
+	Asset       string `json:"asset,omitempty"`
+	Network     string `json:"network,omitempty"`
+	LatencyMs   int64  `json:"latencyMs"`
+	Transaction string `json:"transaction,omitempty"`
+	Receipt     string `json:"receipt,omitempty"` // path of the signed receipt of an accepted payment
+	// DuplicateOf names the earlier identical endpoint whose result --dedupe reused instead of paying again.
+	DuplicateOf string `json:"duplicateOf,omitempty"`
+	NoSpend     bool   `json:"noSpend,omitempty"` // --no-spend answered the payment locally
+	Error       string `json:"error,omitempty"`
+	// RejectionReason normalizes the facilitator's reason for a refused payment.
+	RejectionReason *rejectionInfo `json:"rejectionReason,omitempty"`
+
+	chainID string // CAIP-2 network of the paid option, for spend totals
+	body    []byte // the final response body, for script captures
+}
+
+// batchOptions are the settings shared by every endpoint of a batch.
+type batchOptions struct {
+	timeout        time.Duration
+	dryRun         bool
+	trustRedirects bool
+	budgets        []hostBudget
+	mainnet        bool
+	// approve, when set, is asked before each payment; an error refuses it as budget_exceeded.
+	approve func(price x402.PaymentRequirements) error
+	// payMu, when set, serializes the payments of concurrent payEndpoint calls, so that
+	// budgets are checked against a ledger holding the payments before them.
+	payMu *sync.Mutex
+	// ceiling is --max-total-spend, shared by every payment of the invocation.
+	ceiling *spendCeiling
+}
+
+// runBatchCmd pays every endpoint in an endpoints file, one after another.
+func runBatchCmd(args []string) {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	var (
+		insecure bool
+		timeout  time.Duration
+		report   string
+		dryRun   bool
+		trust    bool
+		hosts    string
+		budgets  headerFlags
+		jsonOut  bool
+		mainnet  bool
+		dedupe   bool
+		maxSpend string
+		funds    bool
+		tags     = tagsByEnv()
+	)
+	fs.BoolVar(&insecure, "k", false, "Skip TLS certificate verification")
+	fs.DurationVar(&timeout, "timeout", 30*time.Second, "Per-endpoint timeout")
+	fs.StringVar(&report, "report", "", "Print the results as a report instead of a table: csv, md, or html")
+	fs.BoolVar(&dryRun, "dry-run", false, "Probe every endpoint without paying and print the estimated total cost")
+	fs.BoolVar(&trust, "trust-redirects", false, "Follow redirects to another origin (and pay it if it asks)")
+	fs.StringVar(&hosts, "only-hosts", os.Getenv("X402_ONLY_HOSTS"), "Only ever pay these hosts: comma-separated names or *.domain wildcards (default: $X402_ONLY_HOSTS)")
+	fs.Var(&budgets, "host-budget", "Cap payments to a host per period, e.g. api.example.com=1USDC/day (repeatable; default: $X402_HOST_BUDGETS)")
+	fs.BoolVar(&jsonOut, "json", false, "Output one JSON object per endpoint (NDJSON), then a summary")
+	fs.StringVar(&maxSpend, "max-total-spend", maxTotalSpendByEnv(), "Never pay more than this in total across the batch, e.g. 0.5USDC (default: $X402_MAX_TOTAL_SPEND)")
+	fs.BoolVar(&funds, "check-funds", false, "Before paying anything, probe every endpoint and stop with a per-network shortfall report (exit 4) if the wallet's balances do not cover the estimated total; with --dry-run, compare the estimate with the balances")
+	fs.BoolVar(&signRequests, "sign-requests", signRequests, "Sign each paid request (method, URL, body hash, payment header hash) with the wallet key into the history (default: $X402_SIGN_REQUESTS)")
+	fs.Var(&tags, "tag", "Store key=value with the history record of each payment, e.g. job=nightly-scrape, to filter history by (repeatable; default: $X402_TAGS)")
+	fs.BoolVar(&dedupe, "dedupe", false, "Pay identical requests (method, URL, headers, and body) once and reuse the result for the repeats")
+	fs.BoolVar(&mainnet, "mainnet", mainnetAllowedByEnv(), "Allow payments on mainnet networks (real funds); without it only testnets are paid (default: $X402_ALLOW_MAINNET)")
+	applyFreeRoutes := freeRouteFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli batch [flags] <endpoints.yaml|endpoints.json>\n\n")
+		fmt.Fprintf(os.Stderr, "Pays every endpoint in the file (same format as dashboard) in order and reports\n")
+		fmt.Fprintf(os.Stderr, "status, price, network, latency, and settlement transaction for each.\n")
+		fmt.Fprintf(os.Stderr, "With --dry-run, nothing is paid: the advertised prices are summed per asset and network\n")
+		fmt.Fprintf(os.Stderr, "into the estimated cost of running the batch for real.\n")
+		fmt.Fprintf(os.Stderr, "With --check-funds, that estimate is compared with the wallet's balance on each network\n")
+		fmt.Fprintf(os.Stderr, "before the first payment, and the batch stops with the shortfall if it cannot be covered.\n")
+		fmt.Fprintf(os.Stderr, "Exits 0 when every endpoint was paid (or priced), 3 when the rest were free routes, 4 when\n")
+		fmt.Fprintf(os.Stderr, "--check-funds found the balances short, and 1 otherwise. For inventories of free and paid\n")
+		fmt.Fprintf(os.Stderr, "routes, --treat-free-as-success exits 0 for free routes too, and --fail-on-free exits 1.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.Arg(0) == "" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	if _, ok := reportWriters[report]; report != "" && !ok {
+		fmt.Fprintf(os.Stderr, "Error: unknown report format %q (available: csv, md, html)\n", report)
+		os.Exit(ExitError)
+	}
+	if report != "" && jsonOut {
+		fmt.Fprintln(os.Stderr, "Error: --report and --json cannot be combined")
+		os.Exit(ExitError)
+	}
+	endpoints, err := loadDashboardEndpoints(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+
+	opts := batchOptions{timeout: timeout, dryRun: dryRun, trustRedirects: trust, mainnet: mainnet}
+	if opts.budgets, err = parseHostBudgets(budgets, os.Getenv("X402_HOST_BUDGETS")); err == nil {
+		opts.ceiling, err = newSpendCeiling(maxSpend)
+	}
+	if err == nil {
+		recordTags, err = parseTags(tags)
+	}
+	if err == nil {
+		err = applyFreeRoutes()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+
+	var signer x402evm.ClientEvmSigner
+	if key := privateKeyFromEnv(); key != "" && (!dryRun || funds) {
+		if signer, err = signerFor(key); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to create signer: %v\n", err)
+			os.Exit(ExitError)
+		}
+	}
+
+	transport := &http.Transport{}
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	if funds && signer == nil {
+		fmt.Fprintln(os.Stderr, "Error: --check-funds needs the wallet's key in EVM_PRIVATE_KEY")
+		os.Exit(ExitError)
+	}
+	// Without --dry-run, the endpoints are priced first so that a batch the wallet cannot
+	// cover stops before the first payment rather than halfway through.
+	if funds && !dryRun {
+		estimateOpts := opts
+		estimateOpts.dryRun = true
+		estimates := make([]batchResult, 0, len(endpoints))
+		priced := map[string]bool{}
+		for _, ep := range endpoints {
+			if dedupe && priced[ep.requestKey()] {
+				continue
+			}
+			priced[ep.requestKey()] = true
+			estimates = append(estimates, payEndpoint(mainnetGuard(parseHostAllowlist(hosts).transport(noSpendTransport(transport)), mainnet), nil, ep, estimateOpts))
+		}
+		reportFunds(checkFunds(spendTotals(estimates, "payment_required"), signer.Address(), tokenBalance), report, jsonOut)
+	}
+
+	started := time.Now()
+	results := make([]batchResult, 0, len(endpoints))
+	firsts := map[string]int{} // request key -> index of its first result
+	for i, ep := range endpoints {
+		if report == "" && !jsonOut {
+			fmt.Fprintf(os.Stderr, "[%d/%d] %s %s\n", i+1, len(endpoints), ep.Method, ep.URL)
+		}
+		key := ep.requestKey()
+		if first, seen := firsts[key]; seen {
+			if dedupe {
+				results = append(results, reuseResult(results[first], ep))
+				if jsonOut {
+					line, _ := json.Marshal(redactJSON(results[len(results)-1]))
+					fmt.Println(string(line))
+				}
+				continue
+			}
+			if report == "" && !jsonOut {
+				fmt.Fprintf(os.Stderr, "Warning: same request as %s; paying again (--dedupe pays it once)\n", results[first].Name)
+			}
+		} else {
+			firsts[key] = i
+		}
+		start := time.Now()
+		r := payEndpoint(mainnetGuard(parseHostAllowlist(hosts).transport(noSpendTransport(transport)), mainnet), signer, ep, opts)
+		r.LatencyMs = time.Since(start).Milliseconds()
+		results = append(results, r)
+		if jsonOut {
+			line, _ := json.Marshal(redactJSON(r))
+			fmt.Println(string(line))
+		}
+	}
+
+	switch {
+	case report != "":
+		if err := reportWriters[report](os.Stdout, results); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitError)
+		}
+	case jsonOut:
+		if dryRun {
+			line, _ := json.Marshal(struct {
+				Type      string       `json:"type"`
+				RequestID string       `json:"requestId"`
+				Totals    []spendTotal `json:"totals"`
+			}{"estimate", requestID, spendTotals(results, "payment_required")})
+			fmt.Println(string(line))
+		}
+		line, _ := json.Marshal(redactJSON(newBatchSummary(results, time.Since(started))))
+		fmt.Println(string(line))
+	default:
+		fmt.Println()
+		renderBatch(results)
+		printCategories(results)
+		if dryRun {
+			printEstimate(results)
+		}
+	}
+	if funds && dryRun {
+		reportFunds(checkFunds(spendTotals(results, "payment_required"), signer.Address(), tokenBalance), report, jsonOut)
+	}
+	os.Exit(batchExitCode(results))
+}
+
+// requestKey identifies identical requests for --dedupe.
+func (ep dashboardEndpoint) requestKey() string {
+	return strings.Join(append([]string{strings.ToUpper(ep.Method), ep.URL, ep.body}, ep.headers...), "\x00")
+}
+
+// reuseResult is the result of a repeated endpoint under --dedupe: a copy of the first
+// one's outcome that paid nothing.
+func reuseResult(first batchResult, ep dashboardEndpoint) batchResult {
+	r := first
+	r.Name, r.URL, r.Method = ep.Name, ep.URL, ep.Method
+	r.DuplicateOf = first.Name
+	r.LatencyMs = 0
+	r.Receipt = ""
+	return r
+}
+
+// batchSummary is the last line of `batch --json`, so consumers need not aggregate the results.
+type batchSummary struct {
+	Type       string         `json:"type"` // "summary"
+	RequestID  string         `json:"requestId"`
+	Endpoints  int            `json:"endpoints"`
+	Counts     map[string]int `json:"counts"`           // results per status
+	Spent      []spendTotal   `json:"spent"`            // accepted payments per network and asset
+	Reused     int            `json:"reused,omitempty"` // results --dedupe reused instead of paying
+	Categories map[string]int `json:"categories"`       // results that were paid (or priced), free, or failed
+	DurationMs int64          `json:"durationMs"`
+	ExitCode   int            `json:"exitCode"`
+}
+
+// newBatchSummary sums up the results of a batch run that took duration.
+func newBatchSummary(results []batchResult, duration time.Duration) batchSummary {
+	s := batchSummary{Type: "summary", RequestID: requestID, Endpoints: len(results), Counts: map[string]int{},
+		Categories: resultCategories(results), Spent: spendTotals(results, "accepted"), DurationMs: duration.Milliseconds(),
+		ExitCode: batchExitCode(results)}
+	for _, r := range results {
+		s.Counts[r.Status]++
+		if r.DuplicateOf != "" {
+			s.Reused++
+		}
+	}
+	return s
+}
+
+// payEndpoint probes one endpoint and, when it asks for payment and this is not a dry run,
+// pays it. Redirects to another origin are refused unless trusted.
+func payEndpoint(transport http.RoundTripper, signer x402evm.ClientEvmSigner, ep dashboardEndpoint, opts batchOptions) batchResult {
+	r := batchResult{RequestID: requestID, Name: ep.Name, URL: ep.URL, Method: ep.Method}
+	headers := append(headerFlags{traceHeader + ": " + requestID}, ep.headers...)
+	fail := func(err error) batchResult {
+		r.Status = "error"
+		r.Error = err.Error()
+		return r
+	}
+
+	req, err := newRequest(ep.Method, ep.URL, ep.body, headers)
+	if err != nil {
+		return fail(err)
+	}
+	var hops []redirectHop
+	redirects := newRedirectPolicy(opts.trustRedirects, nil, &hops)
+	resp, err := (&http.Client{Transport: transport, Timeout: opts.timeout, CheckRedirect: redirects.checkRedirect("probe")}).Do(req)
+	if err != nil {
+		return fail(err)
+	}
+	if hop := redirects.blocked(); hop != nil {
+		resp.Body.Close()
+		return fail(fmt.Errorf("redirected to another origin: %s (use --trust-redirects)", hop.To))
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	r.StatusCode = resp.StatusCode
+	r.body = body
+	if resp.StatusCode != http.StatusPaymentRequired {
+		r.Status = "no_402"
+		if resp.StatusCode == http.StatusOK {
+			r.Status = "free"
+		}
+		return r
+	}
+
+	required, err := decodeRequirements(resp, body)
+	if err != nil {
+		return fail(err)
+	}
+	observePrices(ep.URL, required.Accepts)
+	selected, ok := fuzzTarget(required.Accepts)
+	if !ok {
+		selected = required.Accepts[0]
+	}
+	testnet, payableOnTestnet := fuzzTarget(testnetAccepts(required.Accepts))
+	if !opts.mainnet && payableOnTestnet {
+		selected = testnet
+	}
+	r.Price = describeAmount(selected)
+	r.Amount = selected.Amount
+	r.Asset = selected.Asset
+	r.Network = networkName(selected.Network)
+	r.chainID = selected.Network
+	if opts.dryRun {
+		r.Status = "payment_required"
+		return r
+	}
+	if !opts.mainnet && !payableOnTestnet {
+		r.Status = "mainnet_not_allowed"
+		r.Error = mainnetRefusal
+		return r
+	}
+	if err := checkPayTo(selected); err != nil {
+		return fail(err)
+	}
+	if opts.payMu != nil {
+		opts.payMu.Lock()
+		defer opts.payMu.Unlock()
+	}
+	if len(opts.budgets) > 0 {
+		records, err := loadHistory()
+		if err != nil {
+			return fail(fmt.Errorf("cannot check host budgets: %w", err))
+		}
+		if err := checkHostBudgets(opts.budgets, records, ep.URL, selected, time.Now()); err != nil {
+			r.Status = "budget_exceeded"
+			r.Error = err.Error()
+			return r
+		}
+	}
+	if opts.approve != nil {
+		if err := opts.approve(selected); err != nil {
+			r.Status = "budget_exceeded"
+			r.Error = err.Error()
+			return r
+		}
+	}
+	if signer == nil {
+		return fail(fmt.Errorf("%s is required to pay", privateKeyVar()))
+	}
+	if status, err := checkDelegatedSigner(routedAddress(selected.Network, signer.Address()), ep.URL, selected); err != nil {
+		r.Status = status
+		r.Error = err.Error()
+		return r
+	}
+	release, err := opts.ceiling.reserve(selected)
+	if err != nil {
+		r.Status = "budget_exceeded"
+		r.Error = err.Error()
+		return r
+	}
+
+	var sent string
+	client := newPaymentClient(signer, onPaymentHeader(transport, func(h string) { sent = h }), opts.timeout, selectRequirement(selected))
+	client.CheckRedirect = redirects.checkRedirect("payment")
+	req, _ = newRequest(ep.Method, ep.URL, ep.body, headers)
+	paidAt := time.Now()
+	resp, err = client.Do(req)
+	if err != nil {
+		return fail(fmt.Errorf("payment request failed: %w", err))
+	}
+	latency := time.Since(paidAt)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	r.StatusCode = resp.StatusCode
+	r.body = body
+	if hop := redirects.blocked(); hop != nil {
+		return fail(fmt.Errorf("paid request redirected to another origin: %s (use --trust-redirects)", hop.To))
+	}
+	if raw, err := base64.StdEncoding.DecodeString(resp.Header.Get("PAYMENT-RESPONSE")); err == nil {
+		r.Transaction, _, _ = settlementTx(raw)
+	}
+	r.NoSpend = resp.Header.Get(noSpendHeader) != ""
+
+	switch reason := rejectionReason(resp, body); {
+	case resp.StatusCode == http.StatusOK:
+		r.Status = "accepted"
+	case resp.StatusCode == http.StatusPaymentRequired && isInsufficientFunds(reason):
+		r.Status = "insufficient_funds"
+		r.Error = reason
+	case resp.StatusCode == http.StatusPaymentRequired:
+		r.Status = "rejected"
+		r.Error = reason
+	default:
+		r.Status = "error"
+		r.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+	if resp.StatusCode == http.StatusPaymentRequired {
+		release() // a refused payment does not settle
+		r.RejectionReason = normalizeRejection(r.Error)
+	}
+	if r.NoSpend {
+		release() // nor does a rehearsed one
+	}
+
+	if sent != "" && !r.NoSpend {
+		rec := newHistoryRecord(ep.URL, ep.Method, ep.body, sent, resp)
+		rec.Status, rec.Error = r.Status, r.Error
+		rec.LatencyMs = latency.Milliseconds()
+		rec.measureBody(body)
+		statsd.observe(rec)
+		if err := appendHistory(rec); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to record payment history: %v\n", err)
+		}
+		if r.Status == "accepted" {
+			if _, path, err := issueReceipt(rec); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			} else {
+				r.Receipt = path
+			}
+		}
+	}
+	return r
+}
+
+// batchExitCode is 0 when every endpoint was paid (or priced by --dry-run), 3 when the others were free, and 1 otherwise.
+// Under --treat-free-as-success free routes count as paid, and under --fail-on-free as failures.
+func batchExitCode(results []batchResult) int {
+	code := ExitSuccess
+	for _, r := range results {
+		switch resultCategory(r) {
+		case "paid":
+		case "free":
+			switch freeRoutes {
+			case freeRoutesFail:
+				return ExitError
+			case freeRoutesDefault:
+				code = ExitFreeRoute
+			}
+		default:
+			return ExitError
+		}
+	}
+	return code
+}
+
+// renderBatch prints the batch results table.
+func renderBatch(results []batchResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tPRICE\tNETWORK\tLATENCY\tTRANSACTION")
+	for _, r := range results {
+		status := r.Status
+		if r.StatusCode != 0 {
+			status = fmt.Sprintf("%s (%d)", r.Status, r.StatusCode)
+		}
+		if r.DuplicateOf != "" {
+			status += ", reused from " + r.DuplicateOf
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%dms\t%s\n",
+			r.Name, status, dashIfEmpty(r.Price), dashIfEmpty(r.Network), r.LatencyMs, dashIfEmpty(r.Transaction))
+	}
+	w.Flush()
+}
+
+// printEstimate prints what a dry-run batch would cost, per asset and network.
+func printEstimate(results []batchResult) {
+	totals := spendTotals(results, "payment_required")
+	if len(totals) == 0 {
+		fmt.Println("\nEstimated cost: nothing (no endpoint asked for payment)")
+		return
+	}
+	fmt.Println("\nEstimated cost of running this batch:")
+	for _, t := range totals {
+		fmt.Printf("  %s on %s\n", t.Display(), networkName(t.Network))
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"text/tabwriter"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// fundsCheck compares what a batch is estimated to cost on one network and asset with the
+// wallet's balance there.
+type fundsCheck struct {
+	Network  string `json:"network"`
+	Asset    string `json:"asset"`
+	Required string `json:"required"` // atomic units
+	Balance  string `json:"balance,omitempty"`
+	// Shortfall is how much more the wallet needs, in atomic units; absent when it is enough.
+	Shortfall string `json:"shortfall,omitempty"`
+	// Sufficient is null when the balance could not be checked.
+	Sufficient *bool  `json:"sufficient"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// checkFunds looks up the balance behind each estimated total with balanceOf.
+func checkFunds(totals []spendTotal, address string, balanceOf func(info networkInfo, asset, address string) (string, error)) []fundsCheck {
+	checks := make([]fundsCheck, 0, len(totals))
+	for _, t := range totals {
+		c := fundsCheck{Network: t.Network, Asset: t.Asset, Required: t.Amount}
+		info, known := networkByChainID(t.Network)
+		if !known {
+			c.Reason = "unknown network: no RPC to check the balance"
+			checks = append(checks, c)
+			continue
+		}
+		raw, err := balanceOf(info, t.Asset, routedAddress(t.Network, address))
+		if err != nil {
+			c.Reason = "balance check failed: " + err.Error()
+			checks = append(checks, c)
+			continue
+		}
+		required, _ := new(big.Int).SetString(t.Amount, 10)
+		balance, ok := new(big.Int).SetString(raw, 10)
+		if !ok {
+			c.Reason = fmt.Sprintf("invalid balance %q", raw)
+			checks = append(checks, c)
+			continue
+		}
+		sufficient := balance.Cmp(required) >= 0
+		c.Balance, c.Sufficient = raw, &sufficient
+		if !sufficient {
+			c.Shortfall = new(big.Int).Sub(required, balance).String()
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// fundsShort reports whether any balance is known not to cover its total.
+func fundsShort(checks []fundsCheck) bool {
+	for _, c := range checks {
+		if c.Sufficient != nil && !*c.Sufficient {
+			return true
+		}
+	}
+	return false
+}
+
+// printFundsCheck renders the per-network comparison as a table.
+func printFundsCheck(w io.Writer, checks []fundsCheck) {
+	if len(checks) == 0 {
+		fmt.Fprintln(w, "Funds check: nothing to pay (no endpoint asked for payment).")
+		return
+	}
+	fmt.Fprintln(w, "--- Funds check: estimated cost vs. balance ---")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tNETWORK\tCOST\tBALANCE\tSHORTFALL\tNOTE")
+	for _, c := range checks {
+		amount := func(atomic string) string {
+			if atomic == "" {
+				return "-"
+			}
+			return tokenAmount(x402.PaymentRequirements{Network: c.Network, Asset: c.Asset, Amount: atomic})
+		}
+		symbol := "?"
+		if c.Sufficient != nil {
+			symbol = "✗"
+			if *c.Sufficient {
+				symbol = "✓"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", symbol, networkName(c.Network), amount(c.Required), amount(c.Balance), amount(c.Shortfall), c.Reason)
+	}
+	tw.Flush()
+}
+
+// reportFunds prints the --check-funds comparison (an NDJSON line with --json, else a table
+// on stderr) and exits 4 when the wallet falls short.
+func reportFunds(checks []fundsCheck, report string, jsonOut bool) {
+	short := fundsShort(checks)
+	switch {
+	case jsonOut:
+		line, _ := json.Marshal(struct {
+			Type      string       `json:"type"`
+			RequestID string       `json:"requestId"`
+			OK        bool         `json:"ok"`
+			Checks    []fundsCheck `json:"checks"`
+		}{"funds", requestID, !short, checks})
+		fmt.Println(string(line))
+	case short || report == "":
+		printFundsCheck(os.Stderr, checks)
+	}
+	if short {
+		if !jsonOut {
+			fmt.Fprintln(os.Stderr, "Error: the wallet's balances do not cover the batch; nothing was paid. Top up or trim the endpoints.")
+		}
+		os.Exit(ExitInsufficientFunds)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+)
+
+// Free-route policies of batch runs: how an endpoint that answered 200 without asking for
+// payment counts towards the exit code.
+const (
+	freeRoutesDefault = "" // exit 3 when the others were paid
+	freeRoutesSuccess = "success"
+	freeRoutesFail    = "fail"
+)
+
+// freeRoutes is the free-route policy set by --treat-free-as-success or --fail-on-free.
+var freeRoutes = freeRoutesDefault
+
+// freeRouteFlags registers --treat-free-as-success and --fail-on-free on fs; the returned
+// function applies them once fs is parsed.
+func freeRouteFlags(fs *flag.FlagSet) func() error {
+	var asSuccess, fail bool
+	fs.BoolVar(&asSuccess, "treat-free-as-success", false, "Count free routes as passing: exit 0 when every endpoint was paid or free (default: exit 3 when some were free)")
+	fs.BoolVar(&fail, "fail-on-free", false, "Count free routes as failures (exit 1), for inventories where every route should charge")
+	return func() error {
+		switch {
+		case asSuccess && fail:
+			return errors.New("--treat-free-as-success and --fail-on-free cannot be combined")
+		case asSuccess:
+			freeRoutes = freeRoutesSuccess
+		case fail:
+			freeRoutes = freeRoutesFail
+		}
+		return nil
+	}
+}
+
+// resultCategory sorts a batch result into "paid" (paid, or priced by --dry-run), "free",
+// or "failed", the categories the exit code is decided by.
+func resultCategory(r batchResult) string {
+	switch r.Status {
+	case "accepted", "payment_required":
+		return "paid"
+	case "free":
+		return "free"
+	}
+	return "failed"
+}
+
+// resultCategories counts the results per category.
+func resultCategories(results []batchResult) map[string]int {
+	counts := map[string]int{"paid": 0, "free": 0, "failed": 0}
+	for _, r := range results {
+		counts[resultCategory(r)]++
+	}
+	return counts
+}
+
+// printCategories prints the per-category counts under the batch results table.
+func printCategories(results []batchResult) {
+	c := resultCategories(results)
+	fmt.Printf("\n%d paid, %d free, %d failed\n", c["paid"], c["free"], c["failed"])
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+)
+
+// stdinData is the -d value that reads the request body from stdin, as curl's -d @-.
+const stdinData = "@-"
+
+// stdinSpool holds the body of -d @- for the current run; nil otherwise.
+var stdinSpool *stdinBody
+
+// stdinBody is a request body read from stdin without holding it in memory: stdin is
+// copied to a private temporary file as it arrives, and every request (the probe, its
+// retries, and the paid request) streams the body from that file with a known length,
+// so servers and gateways that refuse chunked uploads still accept it.
+type stdinBody struct {
+	path string
+	size int64
+	hash string // hex SHA-256, as bodyHash computes it for inline bodies
+	// keep is set for a file the user named (see upload), which close leaves in place.
+	keep bool
+}
+
+// newStdinBody spools src to a temporary file.
+func newStdinBody(src io.Reader) (*stdinBody, error) {
+	f, err := os.CreateTemp("", "x402-body-*")
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, sum), src)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &stdinBody{path: f.Name(), size: n, hash: hex.EncodeToString(sum.Sum(nil))}, nil
+}
+
+// open returns a new reader of the whole body.
+func (b *stdinBody) open() (io.ReadCloser, error) {
+	return os.Open(b.path)
+}
+
+// attach makes req send the body, and lets it be re-read (GetBody) for the paid retry.
+func (b *stdinBody) attach(req *http.Request) error {
+	body, err := b.open()
+	if err != nil {
+		return err
+	}
+	req.Body = body
+	req.GetBody = b.open
+	req.ContentLength = b.size
+	if b.size == 0 {
+		body.Close()
+		req.Body, req.GetBody = http.NoBody, nil
+	}
+	return nil
+}
+
+// close removes the spool file.
+func (b *stdinBody) close() {
+	if b != nil && !b.keep {
+		os.Remove(b.path)
+	}
+}
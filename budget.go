@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// budgetPeriods are the named periods accepted by --host-budget.
+var budgetPeriods = map[string]time.Duration{
+	"hour":  time.Hour,
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+}
+
+// hostBudget caps what may be paid to matching hosts within a trailing period.
+type hostBudget struct {
+	Host   string   // exact host or *.domain, as in --only-hosts
+	Limit  *big.Rat // in token units, e.g. 1 for 1 USDC
+	Period time.Duration
+	per    string // the period as written
+	spec   string
+}
+
+// parseHostBudget parses "HOST=AMOUNT[SYMBOL]/PERIOD", e.g. "api.foo.com=1USDC/day" or
+// "*.foo.com=0.5/12h". PERIOD is hour, day, week, month, or a duration. The symbol is
+// informational: the limit applies, in token units, to each asset on each network separately.
+func parseHostBudget(spec string) (hostBudget, error) {
+	b := hostBudget{spec: strings.TrimSpace(spec)}
+	host, rest, ok := strings.Cut(b.spec, "=")
+	if !ok {
+		host, rest, ok = strings.Cut(b.spec, ":")
+	}
+	amount, period, ok2 := strings.Cut(rest, "/")
+	if !ok || !ok2 || strings.TrimSpace(host) == "" {
+		return b, fmt.Errorf("invalid host budget %q: want HOST=AMOUNT/PERIOD, e.g. api.example.com=1USDC/day", spec)
+	}
+	b.Host = strings.ToLower(strings.TrimSpace(host))
+
+	amount = strings.TrimSpace(amount)
+	amount = strings.TrimSpace(strings.TrimRight(amount, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"))
+	limit, ok := new(big.Rat).SetString(amount)
+	if !ok || limit.Sign() < 0 {
+		return b, fmt.Errorf("invalid host budget %q: amount %q is not a number", spec, amount)
+	}
+	b.Limit = limit
+
+	period = strings.ToLower(strings.TrimSpace(period))
+	b.per = period
+	if d, ok := budgetPeriods[period]; ok {
+		b.Period = d
+	} else if d, err := time.ParseDuration(period); err == nil && d > 0 {
+		b.Period = d
+	} else {
+		return b, fmt.Errorf("invalid host budget %q: period %q is not hour, day, week, month, or a duration", spec, period)
+	}
+	return b, nil
+}
+
+// parseHostBudgets parses the --host-budget values and the comma-separated X402_HOST_BUDGETS.
+func parseHostBudgets(flags []string, env string) ([]hostBudget, error) {
+	specs := append([]string{}, flags...)
+	if len(specs) == 0 {
+		for _, s := range strings.Split(env, ",") {
+			if strings.TrimSpace(s) != "" {
+				specs = append(specs, s)
+			}
+		}
+	}
+	budgets := make([]hostBudget, 0, len(specs))
+	for _, spec := range specs {
+		b, err := parseHostBudget(spec)
+		if err != nil {
+			return nil, err
+		}
+		budgets = append(budgets, b)
+	}
+	return budgets, nil
+}
+
+// tokenUnits converts an atomic amount to token units using the asset's decimals.
+func tokenUnits(req x402.PaymentRequirements) (*big.Rat, bool) {
+	decimals, ok := assetDecimals(req)
+	if !ok {
+		return nil, false
+	}
+	amount, ok := new(big.Rat).SetString(req.Amount)
+	if !ok {
+		return nil, false
+	}
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	return amount.Quo(amount, new(big.Rat).SetInt(unit)), true
+}
+
+// checkHostBudgets returns an error when paying price to endpoint would take any
+// matching host budget past its limit, counting the accepted and pending payments in the
+// ledger of the same asset on the same network. Pending payments may yet settle.
+func checkHostBudgets(budgets []hostBudget, records []historyRecord, endpoint string, price x402.PaymentRequirements, now time.Time) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	for _, b := range budgets {
+		match := hostAllowlist{b.Host}
+		if !match.allows(u.Hostname()) {
+			continue
+		}
+		spent := new(big.Rat)
+		for _, r := range records {
+			ru, err := url.Parse(r.Endpoint)
+			if (r.Status != "accepted" && r.Status != "pending") || err != nil || now.Sub(r.Time) > b.Period || !match.allows(ru.Hostname()) {
+				continue
+			}
+			if r.Network != price.Network || !strings.EqualFold(r.Asset, price.Asset) {
+				continue
+			}
+			if v, ok := tokenUnits(x402.PaymentRequirements{Network: r.Network, Asset: r.Asset, Amount: r.Amount}); ok {
+				spent.Add(spent, v)
+			}
+		}
+		cost, ok := tokenUnits(price)
+		if !ok {
+			return fmt.Errorf("budget %s: cannot convert the price (%s) to token units", b.spec, describeAmount(price))
+		}
+		if total := new(big.Rat).Add(spent, cost); total.Cmp(b.Limit) > 0 {
+			return fmt.Errorf("budget %s exceeded: %s already paid in the last %s in this asset on %s, this payment adds %s",
+				b.spec, ratString(spent), b.per, networkName(price.Network), ratString(cost))
+		}
+	}
+	return nil
+}
+
+// ratString renders r as a decimal with up to 18 places and no trailing zeros.
+func ratString(r *big.Rat) string {
+	s := r.FloatString(18)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
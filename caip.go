@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// caip2 is a CAIP-2 chain ID, e.g. eip155:8453.
+type caip2 struct {
+	Namespace string
+	Reference string
+}
+
+func (c caip2) String() string { return c.Namespace + ":" + c.Reference }
+
+// caip19 is a CAIP-19 asset ID, e.g. eip155:8453/erc20:0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913.
+type caip19 struct {
+	Chain     caip2
+	Namespace string
+	Reference string
+	TokenID   string // set for non-fungible assets
+}
+
+// The CAIP-2 and CAIP-19 grammars.
+var (
+	caip2Pattern       = regexp.MustCompile(`^([-a-z0-9]{3,8}):([-_a-zA-Z0-9]{1,32})$`)
+	caip19AssetPattern = regexp.MustCompile(`^([-a-z0-9]{3,8}):([-.%a-zA-Z0-9]{1,128})(?:/([-.%a-zA-Z0-9]{1,78}))?$`)
+	decimalChainID     = regexp.MustCompile(`^[1-9][0-9]*$`)
+)
+
+// wellKnownChains names chains servers may offer that this build has no network entry for.
+var wellKnownChains = map[string]string{
+	"eip155:1":        "Ethereum",
+	"eip155:10":       "Optimism",
+	"eip155:56":       "BNB Smart Chain",
+	"eip155:137":      "Polygon",
+	"eip155:42161":    "Arbitrum One",
+	"eip155:11155111": "Sepolia",
+	"solana:5eykt4UsFv8P8NJdTREpY1vzqKqZKvdp": "Solana",
+	"solana:EtWTRABZaYq6iMfeYKouRu166VU2xqa1": "Solana Devnet",
+}
+
+// parseCAIP2 parses a CAIP-2 chain ID. eip155 references must be decimal chain IDs.
+func parseCAIP2(id string) (caip2, error) {
+	m := caip2Pattern.FindStringSubmatch(id)
+	if m == nil {
+		return caip2{}, fmt.Errorf("malformed CAIP-2 chain ID %q: want <namespace>:<reference>, e.g. eip155:8453", id)
+	}
+	c := caip2{Namespace: m[1], Reference: m[2]}
+	if c.Namespace == "eip155" && !decimalChainID.MatchString(c.Reference) {
+		return caip2{}, fmt.Errorf("malformed CAIP-2 chain ID %q: an eip155 reference is a decimal chain ID", id)
+	}
+	return c, nil
+}
+
+// parseCAIP19 parses a CAIP-19 asset ID: <chain ID>/<asset namespace>:<asset reference>[/<token ID>].
+func parseCAIP19(id string) (caip19, error) {
+	chain, asset, ok := strings.Cut(id, "/")
+	if !ok {
+		return caip19{}, fmt.Errorf("malformed CAIP-19 asset ID %q: want <chain ID>/<namespace>:<reference>", id)
+	}
+	c, err := parseCAIP2(chain)
+	if err != nil {
+		return caip19{}, fmt.Errorf("malformed CAIP-19 asset ID %q: %w", id, err)
+	}
+	m := caip19AssetPattern.FindStringSubmatch(asset)
+	if m == nil {
+		return caip19{}, fmt.Errorf("malformed CAIP-19 asset ID %q: want <chain ID>/<namespace>:<reference>", id)
+	}
+	a := caip19{Chain: c, Namespace: m[1], Reference: m[2], TokenID: m[3]}
+	if a.Namespace == "erc20" && !common.IsHexAddress(a.Reference) {
+		return caip19{}, fmt.Errorf("malformed CAIP-19 asset ID %q: an erc20 reference is a token address", id)
+	}
+	return a, nil
+}
+
+// chainName is the friendly name of a chain: a network of this build, a well-known chain,
+// or a description of the namespace and reference.
+func chainName(c caip2) string {
+	if info, ok := networkByChainID(c.String()); ok {
+		return info.Name
+	}
+	if name, ok := wellKnownChains[c.String()]; ok {
+		return name
+	}
+	switch c.Namespace {
+	case "eip155":
+		return "EVM chain " + c.Reference
+	case "solana":
+		return "Solana chain " + c.Reference
+	}
+	return c.Namespace + " chain " + c.Reference
+}
+
+// assetAddress returns the token address of an asset given as an erc20 CAIP-19 ID, or the
+// asset unchanged.
+func assetAddress(asset string) string {
+	if a, err := parseCAIP19(asset); err == nil && a.Namespace == "erc20" {
+		return a.Reference
+	}
+	return asset
+}
+
+// checkIdentifiers validates the network and asset identifiers of an accepts entry. The
+// network is a CAIP-2 chain ID or an x402 v1 network name; the asset is a CAIP-19 asset ID
+// on that chain or, on eip155 chains, a token address.
+func checkIdentifiers(network, asset string) error {
+	var chain caip2
+	if info, ok := lookupNetwork(network); ok {
+		chain, _ = parseCAIP2(info.ChainID)
+	} else {
+		c, err := parseCAIP2(network)
+		if err != nil {
+			return err
+		}
+		chain = c
+	}
+	switch {
+	case asset == "":
+		return nil
+	case strings.Contains(asset, "/"):
+		a, err := parseCAIP19(asset)
+		if err != nil {
+			return err
+		}
+		if a.Chain != chain {
+			return fmt.Errorf("asset %s is on %s, not on the network %s", asset, chainName(a.Chain), chainName(chain))
+		}
+	case chain.Namespace == "eip155" && !common.IsHexAddress(asset):
+		return fmt.Errorf("malformed asset %q: want a token address or a CAIP-19 asset ID", asset)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// supportedScheme is the only payment scheme registered by this build.
+const supportedScheme = "exact"
+
+// capability compares one accepts entry with what this build can pay.
+type capability struct {
+	Scheme  string `json:"scheme"`
+	Network string `json:"network"`
+	Asset   string `json:"asset"`
+	// Chain and Token are the network and asset as names, e.g. "Base" and "USDC".
+	Chain            string `json:"chain,omitempty"`
+	Token            string `json:"token,omitempty"`
+	SchemeSupported  bool   `json:"schemeSupported"`
+	NetworkSupported bool   `json:"networkSupported"`
+	AssetKnown       bool   `json:"assetKnown"`
+	Supported        bool   `json:"supported"`
+	// Tier is the price tier the entry's extra names, if any.
+	Tier   string `json:"tier,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// capabilityMatrix checks every accepts entry in the payment requirements against the
+// schemes and networks registered by newPaymentClient: v2 "exact" on any eip155 network.
+// Assets other than the known USDC contracts are payable only if they implement EIP-3009.
+func capabilityMatrix(requirements []byte) ([]capability, error) {
+	var payReq struct {
+		X402Version int `json:"x402Version"`
+		Accepts     []struct {
+			Scheme  string                 `json:"scheme"`
+			Network string                 `json:"network"`
+			Asset   string                 `json:"asset"`
+			Extra   map[string]interface{} `json:"extra"`
+		} `json:"accepts"`
+	}
+	if err := json.Unmarshal(requirements, &payReq); err != nil {
+		return nil, fmt.Errorf("invalid payment requirements: %w", err)
+	}
+
+	rows := make([]capability, 0, len(payReq.Accepts))
+	for _, a := range payReq.Accepts {
+		c := capability{
+			Scheme:           a.Scheme,
+			Network:          a.Network,
+			Asset:            a.Asset,
+			SchemeSupported:  a.Scheme == supportedScheme,
+			NetworkSupported: strings.HasPrefix(a.Network, "eip155:"),
+		}
+		if info, ok := networkByChainID(a.Network); ok && strings.EqualFold(assetAddress(a.Asset), info.USDCContract) {
+			c.AssetKnown = true
+		}
+		idErr := checkIdentifiers(a.Network, a.Asset)
+		if idErr == nil {
+			c.Chain = networkName(a.Network)
+			c.Token = assetSymbol(x402.PaymentRequirements{Network: a.Network, Asset: a.Asset, Extra: a.Extra})
+		}
+		c.Tier, _ = acceptTier(a.Extra)
+		c.Supported = payReq.X402Version == 2 && c.SchemeSupported && c.NetworkSupported && idErr == nil
+
+		switch {
+		case payReq.X402Version != 2:
+			c.Reason = fmt.Sprintf("x402 version %d is not supported (this build pays x402 v2)", payReq.X402Version)
+		case idErr != nil:
+			c.Reason = "invalid identifier: " + idErr.Error()
+		case !c.SchemeSupported:
+			c.Reason = fmt.Sprintf("scheme %q is not supported (this build supports: %s)", a.Scheme, supportedScheme)
+		case !c.NetworkSupported:
+			c.Reason = fmt.Sprintf("network %q is not supported (this build supports: eip155:* EVM networks)", a.Network)
+		case !c.AssetKnown:
+			c.Reason = "unrecognised asset: payable only if the token implements EIP-3009"
+		}
+		rows = append(rows, c)
+	}
+	return rows, nil
+}
+
+// printCapabilities renders the capability matrix as a table.
+func printCapabilities(rows []capability) {
+	fmt.Println("--- Capabilities: server requires vs. this build supports ---")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tSCHEME\tNETWORK\tASSET\tPAYABLE\tNOTE")
+	for i, c := range rows {
+		asset := c.Asset
+		if c.Token != "" {
+			asset = c.Token
+		}
+		payable := "yes"
+		if !c.Supported {
+			payable = "NO"
+		}
+		fmt.Fprintf(w, "%d\t%s%s\t%s%s\t%s\t%s\t%s\n", i+1,
+			c.Scheme, mark(c.SchemeSupported), networkName(c.Network), mark(c.NetworkSupported),
+			asset, payable, c.Reason)
+	}
+	w.Flush()
+	fmt.Println()
+}
+
+// mark flags an unsupported matrix cell.
+func mark(ok bool) string {
+	if ok {
+		return ""
+	}
+	return " (x)"
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+	x402evm "github.com/coinbase/x402/go/mechanisms/evm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// paymentCheck is one check of `check-payment`.
+type paymentCheck struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+	// Skipped marks a check that cannot be made locally; it does not fail the payment.
+	Skipped bool   `json:"skipped,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// checkPaymentResult is the JSON output of `x402-cli check-payment`.
+type checkPaymentResult struct {
+	Valid       bool                      `json:"valid"`
+	X402Version int                       `json:"x402Version,omitempty"`
+	Payer       string                    `json:"payer,omitempty"`
+	Requirement *x402.PaymentRequirements `json:"requirement,omitempty"`
+	Checks      []paymentCheck            `json:"checks"`
+}
+
+func (r *checkPaymentResult) check(name string, ok bool, format string, args ...any) {
+	r.Checks = append(r.Checks, paymentCheck{Name: name, OK: ok, Detail: fmt.Sprintf(format, args...)})
+}
+
+// canonicalNetwork is the CAIP-2 ID of a network given by name or ID.
+func canonicalNetwork(network string) string {
+	if info, ok := lookupNetwork(network); ok {
+		return info.ChainID
+	}
+	return network
+}
+
+// parseRequirementsFile reads payment requirements as a 402 body or decoded PAYMENT-REQUIRED
+// header, the base64 header value itself, or a single accepts entry. The v1 field
+// maxAmountRequired counts as the amount.
+func parseRequirementsFile(raw []byte) ([]x402.PaymentRequirements, error) {
+	raw = []byte(strings.TrimSpace(string(raw)))
+	if !json.Valid(raw) {
+		decoded, err := base64.StdEncoding.DecodeString(string(raw))
+		if err != nil || !json.Valid(decoded) {
+			return nil, fmt.Errorf("requirements are neither JSON nor a base64 PAYMENT-REQUIRED header")
+		}
+		raw = decoded
+	}
+	var envelope struct {
+		Accepts []json.RawMessage `json:"accepts"`
+	}
+	entries := []json.RawMessage{raw}
+	if json.Unmarshal(raw, &envelope) == nil && envelope.Accepts != nil {
+		entries = envelope.Accepts
+	}
+	var accepts []x402.PaymentRequirements
+	for _, e := range entries {
+		var req x402.PaymentRequirements
+		var v1 struct {
+			MaxAmountRequired string `json:"maxAmountRequired"`
+		}
+		if err := json.Unmarshal(e, &req); err != nil {
+			return nil, fmt.Errorf("invalid payment requirements: %w", err)
+		}
+		json.Unmarshal(e, &v1)
+		if req.Amount == "" {
+			req.Amount = v1.MaxAmountRequired
+		}
+		if req.Scheme != "" {
+			accepts = append(accepts, req)
+		}
+	}
+	if len(accepts) == 0 {
+		return nil, fmt.Errorf("no accepted payment options in the requirements")
+	}
+	return accepts, nil
+}
+
+// checkPayment validates a decoded payment header against the requirements it claims to pay,
+// as a facilitator would before settling, except for what needs the chain: the payer's
+// balance, whether the nonce was used, and smart-wallet signatures.
+func checkPayment(accepts []x402.PaymentRequirements, payment []byte, now time.Time) *checkPaymentResult {
+	r := &checkPaymentResult{}
+	var p struct {
+		X402Version int                       `json:"x402Version"`
+		Scheme      string                    `json:"scheme"`  // v1
+		Network     string                    `json:"network"` // v1
+		Accepted    *x402.PaymentRequirements `json:"accepted"`
+		Payload     map[string]any            `json:"payload"`
+	}
+	if err := json.Unmarshal(payment, &p); err != nil {
+		r.check("structure", false, "not a payment payload: %v", err)
+		return r.finish()
+	}
+	r.X402Version = p.X402Version
+	scheme, network := p.Scheme, p.Network
+	if p.Accepted != nil {
+		scheme, network = p.Accepted.Scheme, p.Accepted.Network
+	}
+	switch {
+	case p.X402Version != 1 && p.X402Version != 2:
+		r.check("structure", false, "unsupported x402Version %d", p.X402Version)
+		return r.finish()
+	case p.X402Version == 2 && p.Accepted == nil:
+		r.check("structure", false, "a v2 payment needs accepted, the requirements it pays")
+		return r.finish()
+	case scheme == "" || network == "" || p.Payload == nil:
+		r.check("structure", false, "scheme, network, and payload are required")
+		return r.finish()
+	}
+	r.check("structure", true, "x402 v%d %s payment on %s", p.X402Version, scheme, networkName(network))
+
+	var req *x402.PaymentRequirements
+	for i, a := range accepts {
+		if a.Scheme != scheme || canonicalNetwork(a.Network) != canonicalNetwork(network) {
+			continue
+		}
+		if p.Accepted != nil && (!strings.EqualFold(a.Asset, p.Accepted.Asset) || !strings.EqualFold(a.PayTo, p.Accepted.PayTo)) {
+			continue
+		}
+		req = &accepts[i]
+		break
+	}
+	if req == nil {
+		r.check("requirement", false, "the requirements offer no %s option on %s%s", scheme, networkName(network), acceptedSuffix(p.Accepted))
+		return r.finish()
+	}
+	r.check("requirement", true, "pays the %s option on %s", req.Scheme, networkName(req.Network))
+	r.Requirement = req
+	if p.Accepted != nil && p.Accepted.Amount != req.Amount {
+		r.check("accepted", false, "accepted names amount %s, the requirements ask %s", p.Accepted.Amount, req.Amount)
+	}
+	if scheme != supportedScheme || !strings.HasPrefix(canonicalNetwork(network), "eip155:") {
+		r.Checks = append(r.Checks, paymentCheck{Name: "payload", Skipped: true, Detail: "only exact payments on EVM networks are checked further"})
+		return r.finish()
+	}
+	if x402evm.IsPermit2Payload(p.Payload) {
+		r.Checks = append(r.Checks, paymentCheck{Name: "payload", Skipped: true, Detail: "Permit2 payloads are not checked further"})
+		return r.finish()
+	}
+	if !x402evm.IsEIP3009Payload(p.Payload) {
+		r.check("payload", false, "payload is neither an EIP-3009 authorization nor Permit2")
+		return r.finish()
+	}
+	evmPayload, _ := x402evm.PayloadFromMap(p.Payload)
+	auth := evmPayload.Authorization
+	r.Payer = auth.From
+
+	value, okValue := new(big.Int).SetString(auth.Value, 10)
+	validAfter, okAfter := new(big.Int).SetString(auth.ValidAfter, 10)
+	validBefore, okBefore := new(big.Int).SetString(auth.ValidBefore, 10)
+	nonce, errNonce := x402evm.HexToBytes(auth.Nonce)
+	switch {
+	case !common.IsHexAddress(auth.From) || !common.IsHexAddress(auth.To):
+		r.check("payload", false, "authorization from and to must be addresses")
+		return r.finish()
+	case !okValue || !okAfter || !okBefore:
+		r.check("payload", false, "authorization value, validAfter, and validBefore must be whole numbers")
+		return r.finish()
+	case errNonce != nil || len(nonce) != 32:
+		r.check("payload", false, "authorization nonce must be 32 bytes of hex")
+		return r.finish()
+	}
+
+	if strings.EqualFold(auth.To, req.PayTo) {
+		r.check("recipient", true, "pays %s", checksumAddress(auth.To))
+	} else {
+		r.check("recipient", false, "pays %s; the requirements ask for %s", auth.To, req.PayTo)
+	}
+	if required, ok := new(big.Int).SetString(req.Amount, 10); ok {
+		switch value.Cmp(required) {
+		case -1:
+			r.check("amount", false, "authorizes %s, less than the %s required", auth.Value, req.Amount)
+		case 0:
+			r.check("amount", true, "authorizes %s", describeAmount(x402.PaymentRequirements{Network: req.Network, Asset: req.Asset, Amount: auth.Value}))
+		default:
+			r.check("amount", true, "authorizes %s, more than the %s required", auth.Value, req.Amount)
+		}
+	} else {
+		r.check("amount", false, "the requirements' amount %q is not a whole number", req.Amount)
+	}
+	unix := big.NewInt(now.Unix())
+	switch {
+	case validAfter.Cmp(unix) > 0:
+		r.check("expiry", false, "not valid until %s", time.Unix(validAfter.Int64(), 0).UTC().Format(time.RFC3339))
+	case validBefore.Cmp(unix) <= 0:
+		r.check("expiry", false, "expired at %s", time.Unix(validBefore.Int64(), 0).UTC().Format(time.RFC3339))
+	default:
+		left := time.Duration(new(big.Int).Sub(validBefore, unix).Int64()) * time.Second
+		r.check("expiry", true, "valid for another %s", left)
+	}
+
+	sig, err := x402evm.HexToBytes(evmPayload.Signature)
+	switch {
+	case err != nil || len(sig) == 0:
+		r.check("signature", false, "missing or malformed signature")
+	case len(sig) != 65:
+		r.Checks = append(r.Checks, paymentCheck{Name: "signature", Skipped: true,
+			Detail: fmt.Sprintf("a %d-byte smart-wallet signature (EIP-1271/6492) can only be verified on-chain", len(sig))})
+	default:
+		digest, err := eip3009Digest(*req, auth)
+		if err != nil {
+			r.check("signature", false, "cannot hash the authorization: %v", err)
+			break
+		}
+		sig = append([]byte(nil), sig...)
+		if sig[64] >= 27 {
+			sig[64] -= 27
+		}
+		pub, err := crypto.SigToPub(digest, sig)
+		if err != nil {
+			r.check("signature", false, "invalid signature: %v", err)
+			break
+		}
+		signer := crypto.PubkeyToAddress(*pub).Hex()
+		if strings.EqualFold(signer, auth.From) {
+			r.check("signature", true, "signed by %s", signer)
+		} else {
+			r.check("signature", false, "signed by %s; the authorization is from %s", signer, auth.From)
+		}
+	}
+	return r.finish()
+}
+
+// finish sets Valid: every check that was made passed.
+func (r *checkPaymentResult) finish() *checkPaymentResult {
+	r.Valid = true
+	for _, c := range r.Checks {
+		r.Valid = r.Valid && (c.OK || c.Skipped)
+	}
+	return r
+}
+
+func acceptedSuffix(accepted *x402.PaymentRequirements) string {
+	if accepted == nil {
+		return ""
+	}
+	return fmt.Sprintf(" paying %s to %s", accepted.Asset, accepted.PayTo)
+}
+
+// eip3009Digest is the EIP-712 digest of a TransferWithAuthorization for the asset of req,
+// with the token's name and version from extra, or the SDK's defaults for the asset.
+func eip3009Digest(req x402.PaymentRequirements, auth x402evm.ExactEIP3009Authorization) ([]byte, error) {
+	chainID, err := x402evm.GetEvmChainId(canonicalNetwork(req.Network))
+	if err != nil {
+		return nil, err
+	}
+	var name, ver string
+	if info, err := x402evm.GetAssetInfo(canonicalNetwork(req.Network), req.Asset); err == nil {
+		name, ver = info.Name, info.Version
+	}
+	if v, ok := req.Extra["name"].(string); ok {
+		name = v
+	}
+	if v, ok := req.Extra["version"].(string); ok {
+		ver = v
+	}
+	td := apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": {
+				{Name: "name", Type: "string"},
+				{Name: "version", Type: "string"},
+				{Name: "chainId", Type: "uint256"},
+				{Name: "verifyingContract", Type: "address"},
+			},
+			"TransferWithAuthorization": {
+				{Name: "from", Type: "address"},
+				{Name: "to", Type: "address"},
+				{Name: "value", Type: "uint256"},
+				{Name: "validAfter", Type: "uint256"},
+				{Name: "validBefore", Type: "uint256"},
+				{Name: "nonce", Type: "bytes32"},
+			},
+		},
+		PrimaryType: "TransferWithAuthorization",
+		Domain: apitypes.TypedDataDomain{
+			Name:              name,
+			Version:           ver,
+			ChainId:           (*math.HexOrDecimal256)(chainID),
+			VerifyingContract: common.HexToAddress(assetAddress(req.Asset)).Hex(),
+		},
+		Message: apitypes.TypedDataMessage{
+			"from":        auth.From,
+			"to":          auth.To,
+			"value":       auth.Value,
+			"validAfter":  auth.ValidAfter,
+			"validBefore": auth.ValidBefore,
+			"nonce":       auth.Nonce,
+		},
+	}
+	digest, _, err := apitypes.TypedDataAndHash(td)
+	return digest, err
+}
+
+// runCheckPaymentCmd validates a payment header made by any x402 client against requirements.
+func runCheckPaymentCmd(args []string) {
+	fs := flag.NewFlagSet("check-payment", flag.ExitOnError)
+	var (
+		reqFile string
+		payment string
+		at      int64
+		jsonOut bool
+	)
+	fs.StringVar(&reqFile, "requirements", "", "File with the payment requirements: a 402 body, the PAYMENT-REQUIRED header (base64 or decoded), or one accepts entry (- for stdin)")
+	fs.StringVar(&payment, "payment", "", "The payment header value (PAYMENT-SIGNATURE or X-PAYMENT, base64), or @file")
+	fs.Int64Var(&at, "at", 0, "Check expiry at this Unix time instead of now, e.g. when the payment was sent")
+	fs.BoolVar(&jsonOut, "json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli check-payment --requirements <req.json> --payment <base64|@file> [--at <unix>] [--json]\n\n")
+		fmt.Fprintf(os.Stderr, "Validates a payment header made by any x402 client, without a network: its structure,\n")
+		fmt.Fprintf(os.Stderr, "the option it pays, recipient, amount, validity window, and the EIP-3009 signature\n")
+		fmt.Fprintf(os.Stderr, "(recovered and compared with the payer). The payer's balance, nonce reuse, and\n")
+		fmt.Fprintf(os.Stderr, "smart-wallet signatures need the chain and are left to the facilitator.\n")
+		fmt.Fprintf(os.Stderr, "Exits 0 when the payment is valid, 1 when it is not.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if reqFile == "" || payment == "" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	fail := func(err error) {
+		if jsonOut {
+			exitJSON(&jsonResult{Version: version, Status: "error", Error: err.Error()}, ExitError)
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+
+	var raw []byte
+	var err error
+	if reqFile == "-" {
+		raw, err = io.ReadAll(os.Stdin)
+	} else {
+		raw, err = os.ReadFile(reqFile)
+	}
+	if err != nil {
+		fail(err)
+	}
+	accepts, err := parseRequirementsFile(raw)
+	if err != nil {
+		fail(err)
+	}
+	if file, ok := strings.CutPrefix(payment, "@"); ok {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			fail(err)
+		}
+		payment = string(b)
+	}
+	header := decodeEchoHeader("payment", payment)
+	if header.Error != "" {
+		fail(fmt.Errorf("payment header: %s", header.Error))
+	}
+	now := time.Now()
+	if at > 0 {
+		now = time.Unix(at, 0)
+	}
+
+	result := checkPayment(accepts, header.Decoded, now)
+	code := ExitSuccess
+	if !result.Valid {
+		code = ExitError
+	}
+	if jsonOut {
+		printJSON(result)
+		os.Exit(code)
+	}
+	for _, c := range result.Checks {
+		mark := "✓"
+		switch {
+		case c.Skipped:
+			mark = "-"
+		case !c.OK:
+			mark = "✗"
+		}
+		fmt.Printf("%s %-12s %s\n", mark, c.Name, c.Detail)
+	}
+	if result.Valid {
+		fmt.Println("\nThe payment is valid (balance and nonce are checked on-chain by the facilitator).")
+	} else {
+		fmt.Println("\nThe payment is not valid.")
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// confirmTimeout is how long a confirmation prompt of the payment flow waits for an answer
+// before it denies (--confirm-timeout); 0 waits forever.
+var confirmTimeout = confirmTimeoutByEnv()
+
+// confirmTimeoutByEnv is the default for --confirm-timeout: $X402_CONFIRM_TIMEOUT, else 2m.
+func confirmTimeoutByEnv() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("X402_CONFIRM_TIMEOUT")); err == nil && d >= 0 {
+		return d
+	}
+	return 2 * time.Minute
+}
+
+// stdinLines delivers the lines of stdin once the first prompt starts reading them; one
+// goroutine reads for the rest of the run, since a read abandoned by a timeout cannot be
+// cancelled.
+var (
+	stdinLines     = make(chan string)
+	stdinLinesOnce sync.Once
+	// stdinPromptExpired is set when a prompt on stdin timed out, so that what was typed
+	// for it is dropped before the next prompt.
+	stdinPromptExpired bool
+)
+
+func readStdinLines() {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		stdinLines <- scanner.Text()
+	}
+	close(stdinLines)
+}
+
+// confirmPrompt asks question on stdout, or where --prompt routes it, and reports whether
+// it was answered yes within confirmTimeout. No answer, end of input, and anything but
+// y/yes deny; a timeout says so, so a prompt left open is never approved once prices or
+// context may have changed.
+func confirmPrompt(question string) bool {
+	if route, target, err := parsePromptRoute(promptVia); err == nil && route == promptPinentry {
+		return pinentryConfirm(target, question)
+	}
+	answer, ok := askPrompt(question, "taking it as no")
+	return ok && strings.HasPrefix(strings.ToLower(answer), "y")
+}
+
+// askPrompt asks question on stdout, or where --prompt routes it, and returns the answer
+// given within confirmTimeout, trimmed; ok is false when there was none or input ended. A
+// timeout is reported with what it is taken as, e.g. "taking it as no".
+func askPrompt(question, onTimeout string) (answer string, ok bool) {
+	route, target, err := parsePromptRoute(promptVia)
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return "", false
+	case route == promptTTY:
+		return ttyAsk(target, question, onTimeout)
+	case route == promptPinentry:
+		return pinentryAsk(target, question, onTimeout)
+	}
+	stdinLinesOnce.Do(func() { go readStdinLines() })
+	// An answer typed after an earlier prompt timed out does not answer this one. Piped
+	// answers to prompts that did not time out are kept, one per prompt.
+	for drained := !stdinPromptExpired; !drained; {
+		select {
+		case _, ok := <-stdinLines:
+			drained = !ok
+		default:
+			drained = true
+		}
+	}
+	stdinPromptExpired = false
+	fmt.Print(question)
+	var expired <-chan time.Time
+	if confirmTimeout > 0 {
+		timer := time.NewTimer(confirmTimeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case answer, ok := <-stdinLines:
+		return strings.TrimSpace(answer), ok
+	case <-expired:
+		stdinPromptExpired = true
+		fmt.Printf("\nNo answer within %s (--confirm-timeout); %s.\n", confirmTimeout, onTimeout)
+		return "", false
+	}
+}
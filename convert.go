@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// conversion is the JSON output of `x402-cli convert`.
+type conversion struct {
+	Atomic   string `json:"atomic"`
+	Amount   string `json:"amount"`
+	Decimals int    `json:"decimals"`
+	Unit     string `json:"unit,omitempty"`
+}
+
+// exactAmount renders an atomic amount in token units with every significant digit and no
+// grouping: unlike atomicToHuman it ignores --amount-format, for scripts to parse.
+func exactAmount(atomic string, decimals int) (string, error) {
+	raw, ok := new(big.Int).SetString(atomic, 10)
+	if !ok || raw.Sign() < 0 || strings.ContainsAny(atomic, "+-") {
+		return "", fmt.Errorf("invalid atomic amount %q: want a non-negative whole number", atomic)
+	}
+	if decimals == 0 {
+		return raw.String(), nil
+	}
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	s := strings.TrimRight(new(big.Rat).SetFrac(raw, unit).FloatString(decimals), "0")
+	return strings.TrimSuffix(s, "."), nil
+}
+
+// splitUnit separates a trailing unit from an amount, as in "1.5USDC" or "1.5 USDC".
+func splitUnit(args []string) (amount, unit string) {
+	amount = strings.TrimSpace(strings.Join(args, " "))
+	i := strings.IndexFunc(amount, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		return amount, ""
+	}
+	return strings.TrimSpace(amount[:i]), strings.TrimSpace(amount[i:])
+}
+
+// conversionDecimals resolves the decimals to convert with: --decimals, else the asset
+// given with --asset and --network, else the unit, which must be USDC.
+func conversionDecimals(decimals int, asset, network, unit string) (int, string, error) {
+	switch {
+	case decimals >= 0:
+		if decimals > 77 {
+			return 0, "", errors.New("--decimals must be 0-77")
+		}
+		return decimals, unit, nil
+	case asset != "":
+		if network == "" {
+			return 0, "", errors.New("--asset needs --network")
+		}
+		info, ok := lookupNetwork(network)
+		if !ok {
+			return 0, "", fmt.Errorf("unknown network %q", network)
+		}
+		req := x402.PaymentRequirements{Network: info.ChainID, Asset: asset}
+		if strings.EqualFold(asset, "USDC") {
+			req.Asset = info.USDCContract
+		}
+		d, ok := assetDecimals(req)
+		if !ok {
+			return 0, "", fmt.Errorf("cannot read the decimals of %s on %s; pass --decimals", asset, info.Name)
+		}
+		return d, assetSymbol(req), nil
+	case strings.EqualFold(unit, "USDC"):
+		return usdcDecimals, "USDC", nil
+	case unit != "":
+		return 0, "", fmt.Errorf("unknown unit %q: pass --decimals (or --asset and --network)", unit)
+	}
+	return 0, "", errors.New("pass --decimals, a USDC unit, or --asset and --network")
+}
+
+// usdcDecimals is the decimals of USDC, the same on every supported network.
+const usdcDecimals = 6
+
+// runConvertCmd converts amounts between atomic units and token units.
+func runConvertCmd(args []string) {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	var (
+		decimals int
+		toAtomic bool
+		asset    string
+		network  string
+		jsonOut  bool
+	)
+	fs.IntVar(&decimals, "decimals", -1, "The token's decimals, e.g. 6 for USDC")
+	fs.BoolVar(&toAtomic, "to-atomic", false, "Convert a token amount (e.g. 1.5 USDC) to atomic units instead")
+	fs.StringVar(&asset, "asset", "", "Read the decimals of this token contract (or USDC) on --network")
+	fs.StringVar(&network, "network", "", "Network of --asset (name such as base-sepolia, or CAIP-2 ID)")
+	fs.BoolVar(&jsonOut, "json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli convert <atomic> --decimals N [--json]\n")
+		fmt.Fprintf(os.Stderr, "       x402-cli convert --to-atomic <amount> [USDC | --decimals N] [--json]\n\n")
+		fmt.Fprintf(os.Stderr, "Converts between the atomic units of x402 amounts and token units, exactly, with the\n")
+		fmt.Fprintf(os.Stderr, "CLI's own conversion: no floating point, no rounding, no thousands separators. An\n")
+		fmt.Fprintf(os.Stderr, "amount with more decimal places than the token has is an error, not rounded.\n\n")
+		fmt.Fprintf(os.Stderr, "  x402-cli convert 1500000 --decimals 6      # 1.5\n")
+		fmt.Fprintf(os.Stderr, "  x402-cli convert --to-atomic 1.5 USDC      # 1500000\n")
+		fmt.Fprintf(os.Stderr, "  x402-cli convert 42 --asset 0x... --network base   # decimals read on-chain\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	// Flags may follow the amount, as in `convert 1500000 --decimals 6`.
+	var words []string
+	for rest := args; ; {
+		fs.Parse(rest)
+		if fs.NArg() == 0 {
+			break
+		}
+		words = append(words, fs.Arg(0))
+		rest = fs.Args()[1:]
+	}
+	if len(words) == 0 {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	amount, unit := splitUnit(words)
+	if amount == "" {
+		fail(fmt.Errorf("invalid amount %q", strings.Join(words, " ")))
+	}
+	if !toAtomic && strings.Contains(amount, ".") {
+		fail(fmt.Errorf("%s is not in atomic units (a whole number); add --to-atomic to convert a token amount", amount))
+	}
+	d, unit, err := conversionDecimals(decimals, asset, network, unit)
+	if err != nil {
+		fail(err)
+	}
+	out := conversion{Decimals: d, Unit: unit}
+	if toAtomic {
+		if out.Atomic, err = humanToAtomic(amount, d); err != nil {
+			fail(err)
+		}
+		out.Amount, _ = exactAmount(out.Atomic, d)
+	} else {
+		if out.Amount, err = exactAmount(amount, d); err != nil {
+			fail(err)
+		}
+		atomic, _ := new(big.Int).SetString(amount, 10)
+		out.Atomic = atomic.String()
+	}
+
+	switch {
+	case jsonOut:
+		printJSON(out)
+	case toAtomic:
+		fmt.Println(out.Atomic)
+	default:
+		fmt.Println(out.Amount)
+	}
+}
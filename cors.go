@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// corsHeader is a payment header that must pass CORS; v1 headers are reported
+// but only needed by x402 v1 clients, so they do not fail the check.
+type corsHeader struct {
+	Name string
+	V1   bool
+}
+
+// corsRequestHeaders are the payment headers a browser x402 client sends.
+var corsRequestHeaders = []corsHeader{{"PAYMENT-SIGNATURE", false}, {"X-PAYMENT", true}}
+
+// corsExposedHeaders are the payment headers a browser x402 client must be able to read.
+var corsExposedHeaders = []corsHeader{{"PAYMENT-REQUIRED", false}, {"PAYMENT-RESPONSE", false}, {"X-PAYMENT-RESPONSE", true}}
+
+// corsCheck is one CORS requirement and whether the server meets it.
+type corsCheck struct {
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Optional bool   `json:"optional,omitempty"`
+	Detail   string `json:"detail"`
+}
+
+// corsResult is the JSON output for `x402-cli cors`.
+type corsResult struct {
+	Endpoint string      `json:"endpoint"`
+	Origin   string      `json:"origin"`
+	Method   string      `json:"method"`
+	OK       bool        `json:"ok"`
+	Checks   []corsCheck `json:"checks"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// runCORSCmd sends an OPTIONS preflight and a simple request with an Origin header
+// and reports whether browsers could send and read the x402 payment headers.
+func runCORSCmd(args []string) {
+	fs := flag.NewFlagSet("cors", flag.ExitOnError)
+	var (
+		insecure bool
+		timeout  time.Duration
+		method   string
+		origin   string
+		jsonOut  bool
+	)
+	fs.BoolVar(&insecure, "k", false, "Skip TLS certificate verification")
+	fs.DurationVar(&timeout, "timeout", 10*time.Second, "Request timeout")
+	fs.StringVar(&method, "X", "GET", "HTTP method the browser client would use")
+	fs.StringVar(&origin, "origin", "https://example.com", "Origin to send, i.e. the site hosting the browser client")
+	fs.BoolVar(&jsonOut, "json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli cors [flags] <url>\n\n")
+		fmt.Fprintf(os.Stderr, "Checks whether browser-based x402 clients can pay the endpoint: the OPTIONS preflight must\n")
+		fmt.Fprintf(os.Stderr, "allow the origin, method, and PAYMENT-SIGNATURE/X-PAYMENT headers, and responses must\n")
+		fmt.Fprintf(os.Stderr, "expose PAYMENT-REQUIRED and PAYMENT-RESPONSE. x402 v1 headers are reported as warnings.\n")
+		fmt.Fprintf(os.Stderr, "Exits 1 when any required check fails.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	endpoint := fs.Arg(0)
+	if endpoint == "" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	method = strings.ToUpper(method)
+
+	transport := &http.Transport{}
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	client := &http.Client{Transport: transport, Timeout: timeout}
+
+	result := &corsResult{Endpoint: endpoint, Origin: origin, Method: method}
+	fail := func(err error) {
+		code, _ := classifyError(err)
+		if jsonOut {
+			result.Error = err.Error()
+			printJSON(result)
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		os.Exit(code)
+	}
+
+	preflight, err := http.NewRequest(http.MethodOptions, endpoint, nil)
+	if err != nil {
+		fail(err)
+	}
+	preflight.Header.Set("Origin", origin)
+	preflight.Header.Set("Access-Control-Request-Method", method)
+	var requested []string
+	for _, h := range corsRequestHeaders {
+		requested = append(requested, strings.ToLower(h.Name))
+	}
+	preflight.Header.Set("Access-Control-Request-Headers", strings.Join(requested, ","))
+	preResp, err := client.Do(preflight)
+	if err != nil {
+		fail(err)
+	}
+	preResp.Body.Close()
+
+	actual, err := newRequest(method, endpoint, "", nil)
+	if err != nil {
+		fail(err)
+	}
+	actual.Header.Set("Origin", origin)
+	actResp, err := client.Do(actual)
+	if err != nil {
+		fail(err)
+	}
+	actResp.Body.Close()
+
+	result.Checks = evaluateCORS(origin, method, preResp.StatusCode, preResp.Header, actResp.Header)
+	result.OK = true
+	for _, c := range result.Checks {
+		result.OK = result.OK && (c.OK || c.Optional)
+	}
+
+	if jsonOut {
+		printJSON(result)
+	} else {
+		fmt.Printf("CORS check for %s %s from origin %s\n\n", method, endpoint, origin)
+		for _, c := range result.Checks {
+			mark := "ok  "
+			if !c.OK && c.Optional {
+				mark = "warn"
+			} else if !c.OK {
+				mark = "FAIL"
+			}
+			fmt.Printf("  [%s] %-36s %s\n", mark, c.Name, c.Detail)
+		}
+		if result.OK {
+			fmt.Println("\nBrowser x402 clients can pay this endpoint.")
+		} else {
+			fmt.Println("\nBrowser x402 clients will fail against this endpoint; fix the CORS configuration above.")
+		}
+	}
+	if !result.OK {
+		os.Exit(ExitError)
+	}
+}
+
+// evaluateCORS checks the preflight response and the actual response headers.
+func evaluateCORS(origin, method string, preflightStatus int, preflight, actual http.Header) []corsCheck {
+	var checks []corsCheck
+
+	checks = append(checks, corsCheck{
+		Name:   "preflight status",
+		OK:     preflightStatus >= 200 && preflightStatus < 300,
+		Detail: fmt.Sprintf("OPTIONS returned %d", preflightStatus),
+	})
+
+	allowOrigin := preflight.Get("Access-Control-Allow-Origin")
+	checks = append(checks, corsCheck{
+		Name:   "origin allowed",
+		OK:     allowOrigin == "*" || allowOrigin == origin,
+		Detail: headerDetail("Access-Control-Allow-Origin", allowOrigin),
+	})
+
+	allowMethods := preflight.Get("Access-Control-Allow-Methods")
+	checks = append(checks, corsCheck{
+		Name: "method " + method + " allowed",
+		// Simple methods need not be listed.
+		OK:     method == "GET" || method == "HEAD" || method == "POST" || headerListHas(allowMethods, method),
+		Detail: headerDetail("Access-Control-Allow-Methods", allowMethods),
+	})
+
+	allowHeaders := preflight.Get("Access-Control-Allow-Headers")
+	for _, h := range corsRequestHeaders {
+		checks = append(checks, corsCheck{
+			Name:     h.Name + " allowed",
+			OK:       headerListHas(allowHeaders, h.Name),
+			Optional: h.V1,
+			Detail:   headerDetail("Access-Control-Allow-Headers", allowHeaders),
+		})
+	}
+
+	actualOrigin := actual.Get("Access-Control-Allow-Origin")
+	checks = append(checks, corsCheck{
+		Name:   "origin allowed on response",
+		OK:     actualOrigin == "*" || actualOrigin == origin,
+		Detail: headerDetail("Access-Control-Allow-Origin", actualOrigin),
+	})
+
+	expose := actual.Get("Access-Control-Expose-Headers")
+	for _, h := range corsExposedHeaders {
+		checks = append(checks, corsCheck{
+			Name:     h.Name + " exposed",
+			OK:       headerListHas(expose, h.Name),
+			Optional: h.V1,
+			Detail:   headerDetail("Access-Control-Expose-Headers", expose),
+		})
+	}
+	return checks
+}
+
+// headerListHas reports whether a comma-separated CORS header list contains name or "*".
+func headerListHas(list, name string) bool {
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "*" || strings.EqualFold(item, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerDetail renders a header value for the report.
+func headerDetail(name, value string) string {
+	if value == "" {
+		return name + " missing"
+	}
+	return name + ": " + value
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// dashboardEndpoint is one entry of the dashboard endpoints file.
+type dashboardEndpoint struct {
+	Name   string `json:"name,omitempty"`
+	URL    string `json:"url"`
+	Method string `json:"method,omitempty"`
+
+	// body and headers are sent by `script` steps; endpoint files have neither.
+	body    string
+	headers headerFlags
+}
+
+// endpointStatus is the latest probe outcome for one endpoint.
+type endpointStatus struct {
+	Name        string    `json:"name"`
+	URL         string    `json:"url"`
+	Method      string    `json:"method"`
+	Status      string    `json:"status"` // "paid", "free", "down", or "error"
+	StatusCode  int       `json:"statusCode,omitempty"`
+	Price       string    `json:"price,omitempty"`
+	Network     string    `json:"network,omitempty"`
+	LatencyMs   int64     `json:"latencyMs"`
+	Error       string    `json:"error,omitempty"`
+	CheckedAt   time.Time `json:"checkedAt"`
+	LastChanged time.Time `json:"lastChanged"`
+}
+
+// runDashboardCmd continuously probes a set of endpoints and renders a live table.
+func runDashboardCmd(args []string) {
+	fs := flag.NewFlagSet("dashboard", flag.ExitOnError)
+	var (
+		insecure bool
+		timeout  time.Duration
+		interval time.Duration
+		snapshot string
+		once     bool
+	)
+	fs.BoolVar(&insecure, "k", false, "Skip TLS certificate verification")
+	fs.DurationVar(&timeout, "timeout", 10*time.Second, "Per-probe timeout")
+	fs.DurationVar(&interval, "interval", 30*time.Second, "Time between probe rounds")
+	fs.StringVar(&snapshot, "snapshot", "", "Write a JSON snapshot of all endpoints to this file after every round")
+	fs.BoolVar(&once, "once", false, "Probe once, print the table, and exit")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli dashboard [flags] <endpoints.yaml|endpoints.json>\n\n")
+		fmt.Fprintf(os.Stderr, "Continuously probes paid endpoints (no payment is sent) and shows availability, price, and last change.\n\n")
+		fmt.Fprintf(os.Stderr, "Endpoints file (YAML subset or JSON array):\n")
+		fmt.Fprintf(os.Stderr, "  endpoints:\n    - name: weather\n      url: https://api.example.com/weather\n      method: GET\n    - https://api.example.com/other\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.Arg(0) == "" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	endpoints, err := loadDashboardEndpoints(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+
+	transport := &http.Transport{}
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	client := &http.Client{Transport: transport, Timeout: timeout}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	statuses := make([]*endpointStatus, len(endpoints))
+	for {
+		var wg sync.WaitGroup
+		for i, ep := range endpoints {
+			wg.Add(1)
+			go func(i int, ep dashboardEndpoint) {
+				defer wg.Done()
+				statuses[i] = probeEndpointStatus(client, ep, statuses[i])
+			}(i, ep)
+		}
+		wg.Wait()
+
+		if !once {
+			fmt.Print("\033[H\033[2J")
+		}
+		renderDashboard(statuses, interval, once)
+		if snapshot != "" {
+			out, _ := json.MarshalIndent(statuses, "", "  ")
+			if err := os.WriteFile(snapshot, out, 0644); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to write snapshot %s: %v\n", snapshot, err)
+			}
+		}
+		if once {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// probeEndpointStatus sends an unpaid request and records status and price,
+// carrying LastChanged forward when nothing observable has changed.
+func probeEndpointStatus(client *http.Client, ep dashboardEndpoint, prev *endpointStatus) *endpointStatus {
+	st := &endpointStatus{Name: ep.Name, URL: ep.URL, Method: ep.Method, CheckedAt: time.Now()}
+
+	start := time.Now()
+	req, err := newRequest(ep.Method, ep.URL, "", nil)
+	if err != nil {
+		st.Status = "error"
+		st.Error = err.Error()
+	} else if resp, err := client.Do(req); err != nil {
+		st.Status = "down"
+		st.Error = err.Error()
+	} else {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		st.StatusCode = resp.StatusCode
+		switch {
+		case resp.StatusCode == http.StatusPaymentRequired:
+			st.Status = "paid"
+			if required, err := decodeRequirements(resp, body); err == nil {
+				observePrices(ep.URL, required.Accepts)
+				st.Price = describeAmount(required.Accepts[0])
+				st.Network = networkName(required.Accepts[0].Network)
+			}
+		case resp.StatusCode < 400:
+			st.Status = "free"
+		default:
+			st.Status = "error"
+			st.Error = resp.Status
+		}
+	}
+	st.LatencyMs = time.Since(start).Milliseconds()
+
+	st.LastChanged = st.CheckedAt
+	if prev != nil && prev.Status == st.Status && prev.Price == st.Price && prev.Network == st.Network {
+		st.LastChanged = prev.LastChanged
+	}
+	return st
+}
+
+// renderDashboard prints the status table.
+func renderDashboard(statuses []*endpointStatus, interval time.Duration, once bool) {
+	fmt.Printf("x402-cli dashboard — %s", time.Now().Format("15:04:05"))
+	if !once {
+		fmt.Printf(" (every %s, Ctrl-C to quit)", interval)
+	}
+	fmt.Print("\n\n")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tPRICE\tNETWORK\tLATENCY\tLAST CHANGE")
+	for _, st := range statuses {
+		price := st.Price
+		if price == "" {
+			price = "-"
+		}
+		network := st.Network
+		if network == "" {
+			network = "-"
+		}
+		status := st.Status
+		if st.StatusCode != 0 {
+			status = fmt.Sprintf("%s (%d)", st.Status, st.StatusCode)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%dms\t%s\n",
+			st.Name, status, price, network, st.LatencyMs, st.LastChanged.Format("15:04:05"))
+	}
+	w.Flush()
+}
+
+// loadDashboardEndpoints reads a JSON array or a small YAML subset listing endpoints.
+func loadDashboardEndpoints(path string) ([]dashboardEndpoint, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var endpoints []dashboardEndpoint
+	if trimmed := strings.TrimSpace(string(raw)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(raw, &endpoints); err != nil {
+			return nil, fmt.Errorf("invalid endpoints file: %w", err)
+		}
+	} else if endpoints, err = parseEndpointsYAML(string(raw)); err != nil {
+		return nil, err
+	}
+
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no endpoints in %s", path)
+	}
+	for i := range endpoints {
+		ep := &endpoints[i]
+		if !strings.HasPrefix(ep.URL, "http://") && !strings.HasPrefix(ep.URL, "https://") {
+			return nil, fmt.Errorf("endpoint %d: invalid url %q", i+1, ep.URL)
+		}
+		if ep.Method == "" {
+			ep.Method = "GET"
+		}
+		ep.Method = strings.ToUpper(ep.Method)
+		if ep.Name == "" {
+			ep.Name = ep.URL
+		}
+	}
+	return endpoints, nil
+}
+
+// parseEndpointsYAML understands a list of "- url" items or "- key: value" maps
+// with name/url/method keys, optionally nested under a top-level "endpoints:" key.
+func parseEndpointsYAML(src string) ([]dashboardEndpoint, error) {
+	var endpoints []dashboardEndpoint
+	var current *dashboardEndpoint
+
+	scanner := bufio.NewScanner(strings.NewReader(src))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || line == "endpoints:" {
+			continue
+		}
+
+		if item, ok := strings.CutPrefix(line, "-"); ok {
+			endpoints = append(endpoints, dashboardEndpoint{})
+			current = &endpoints[len(endpoints)-1]
+			line = strings.TrimSpace(item)
+			if !strings.Contains(line, ": ") && !strings.HasSuffix(line, ":") {
+				current.URL = yamlScalar(line)
+				continue
+			}
+		}
+		if current == nil {
+			return nil, fmt.Errorf("line %d: expected a list item", n)
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", n)
+		}
+		switch strings.TrimSpace(key) {
+		case "name":
+			current.Name = yamlScalar(value)
+		case "url":
+			current.URL = yamlScalar(value)
+		case "method":
+			current.Method = yamlScalar(value)
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %q", n, strings.TrimSpace(key))
+		}
+	}
+	return endpoints, scanner.Err()
+}
+
+// yamlScalar trims whitespace and surrounding quotes from a YAML scalar.
+func yamlScalar(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
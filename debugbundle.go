@@ -0,0 +1,120 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// sensitiveHeaders are redacted from debug bundles; the rest of the exchange is kept as sent.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "Api-Key"}
+
+// redactHeaders returns a copy of h with sensitive header values replaced.
+func redactHeaders(h http.Header) http.Header {
+	out := h.Clone()
+	for _, name := range sensitiveHeaders {
+		if len(out.Values(name)) > 0 {
+			out.Set(name, "[redacted]")
+		}
+	}
+	return out
+}
+
+// redactArgs hides the values of sensitive -H headers and of flags that carry secrets
+// in a command line.
+func redactArgs(args []string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		out[i] = arg
+		name, _, ok := strings.Cut(arg, ":")
+		if !ok {
+			continue
+		}
+		for _, h := range sensitiveHeaders {
+			if strings.EqualFold(strings.TrimSpace(name), h) {
+				out[i] = name + ": [redacted]"
+			}
+		}
+	}
+	return out
+}
+
+// bundleEnvironment summarizes the environment a run used. Secrets are reported only
+// as set or unset.
+type bundleEnvironment struct {
+	Version   string            `json:"version"`
+	GoVersion string            `json:"goVersion"`
+	OS        string            `json:"os"`
+	Arch      string            `json:"arch"`
+	Args      []string          `json:"args"`
+	Profile   string            `json:"profile,omitempty"`
+	Env       map[string]string `json:"env"`
+}
+
+// newBundleEnvironment describes this process for a debug bundle.
+func newBundleEnvironment() bundleEnvironment {
+	env := bundleEnvironment{
+		Version:   version,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Args:      redactArgs(os.Args[1:]),
+		Profile:   profile,
+		Env:       map[string]string{},
+	}
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		upper := strings.ToUpper(name)
+		switch {
+		case strings.HasPrefix(upper, "EVM_PRIVATE_KEY") || upper == "X402_STATE_KEY":
+			env.Env[name] = "[set]"
+		case strings.HasPrefix(upper, "X402_"):
+			env.Env[name] = value
+		case upper == "HTTP_PROXY" || upper == "HTTPS_PROXY" || upper == "NO_PROXY":
+			// Proxy URLs can carry credentials; record only whether one is configured.
+			env.Env[name] = "[set]"
+		}
+	}
+	return env
+}
+
+// writeZip archives every file in dir into a zip at path.
+func writeZip(path, dir string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(f)
+	err = filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: filepath.ToSlash(rel), Method: zip.Deflate, Modified: info.ModTime()})
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(w, src)
+		return err
+	})
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
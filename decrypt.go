@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+)
+
+// Schemes of --decrypt.
+const (
+	decryptECIES = "ecies"
+	decryptAge   = "age"
+)
+
+var decryptSchemes = []string{decryptECIES, decryptAge}
+
+// decryptKeyByEnv is the default for --decrypt-key.
+func decryptKeyByEnv() string {
+	return os.Getenv("X402_DECRYPT_KEY")
+}
+
+// responseDecrypter decrypts paid response bodies encrypted to the payer for --decrypt.
+type responseDecrypter struct {
+	scheme     string
+	eciesKey   *ecies.PrivateKey
+	identities []age.Identity
+}
+
+// newResponseDecrypter loads the key of scheme from keySpec, a key or a file holding it.
+// ecies takes a hex secp256k1 private key and defaults to the wallet's; age takes X25519
+// identities (AGE-SECRET-KEY-1...), as written by age-keygen, and has no default.
+func newResponseDecrypter(scheme, keySpec, walletKey string) (*responseDecrypter, error) {
+	if keySpec != "" {
+		if raw, err := os.ReadFile(keySpec); err == nil {
+			keySpec = string(raw)
+		}
+	}
+	keySpec = strings.TrimSpace(keySpec)
+	d := &responseDecrypter{scheme: scheme}
+	switch scheme {
+	case decryptECIES:
+		if keySpec == "" {
+			if keySpec = walletKey; keySpec == "" {
+				return nil, fmt.Errorf("--decrypt ecies needs --decrypt-key or %s", privateKeyVar())
+			}
+		}
+		key, err := crypto.HexToECDSA(strings.TrimPrefix(keySpec, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("--decrypt-key: invalid secp256k1 private key: %w", err)
+		}
+		d.eciesKey = ecies.ImportECDSA(key)
+	case decryptAge:
+		if keySpec == "" {
+			return nil, errors.New("--decrypt age needs --decrypt-key: an age identity or a file of them")
+		}
+		ids, err := age.ParseIdentities(strings.NewReader(keySpec))
+		if err != nil {
+			return nil, fmt.Errorf("--decrypt-key: %w", err)
+		}
+		d.identities = ids
+	default:
+		return nil, fmt.Errorf("--decrypt must be one of %s", strings.Join(decryptSchemes, ", "))
+	}
+	return d, nil
+}
+
+// decrypt returns the plaintext of body, which may also be base64 encoded (and, for age,
+// ASCII armored).
+func (d *responseDecrypter) decrypt(body []byte) ([]byte, error) {
+	candidates := [][]byte{body}
+	trimmed := bytes.TrimSpace(body)
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if raw, err := enc.DecodeString(string(trimmed)); err == nil && len(raw) > 0 {
+			candidates = append(candidates, raw)
+			break
+		}
+	}
+	var firstErr error
+	for _, c := range candidates {
+		plain, err := d.open(c)
+		if err == nil {
+			return plain, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, fmt.Errorf("cannot decrypt the response with %s: %w", d.scheme, firstErr)
+}
+
+func (d *responseDecrypter) open(ciphertext []byte) ([]byte, error) {
+	if d.scheme == decryptECIES {
+		return d.eciesKey.Decrypt(ciphertext, nil, nil)
+	}
+	var in io.Reader = bytes.NewReader(ciphertext)
+	if bytes.HasPrefix(bytes.TrimSpace(ciphertext), []byte(armor.Header)) {
+		in = armor.NewReader(bytes.NewReader(bytes.TrimSpace(ciphertext)))
+	}
+	r, err := age.Decrypt(in, d.identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// transferSelector is the ERC-20 transfer(address,uint256) function selector.
+const transferSelector = "a9059cbb"
+
+// delegation scopes a sub-key created by `wallet delegate`. Payments signed by the sub-key
+// are refused once it expires, is revoked, pays on a network other than Network or a host
+// outside Hosts, or has paid Max.
+type delegation struct {
+	Name    string    `json:"name"`
+	Address string    `json:"address"`
+	Parent  string    `json:"parent"`
+	Network string    `json:"network,omitempty"`
+	Max     string    `json:"max"` // in token units, e.g. "5" for 5 USDC
+	Hosts   []string  `json:"hosts,omitempty"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	Revoked bool      `json:"revoked,omitempty"`
+}
+
+// delegateResult is the JSON output of `wallet delegate`.
+type delegateResult struct {
+	delegation
+	// PrivateKey and EnvVar are set when the delegation is created.
+	PrivateKey string    `json:"privateKey,omitempty"`
+	EnvVar     string    `json:"envVar,omitempty"`
+	Funding    *txResult `json:"funding,omitempty"`
+}
+
+// delegationsFile is where delegations are recorded, keyed by sub-key address.
+func delegationsFile() (string, error) {
+	return stateFile("delegations.json")
+}
+
+// loadDelegations reads all delegations; a missing file is empty.
+func loadDelegations() (map[string]delegation, error) {
+	all := map[string]delegation{}
+	path, err := delegationsFile()
+	if err != nil {
+		return all, err
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return all, nil
+	}
+	if err != nil {
+		return all, err
+	}
+	if err := json.Unmarshal(raw, &all); err != nil {
+		return all, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return all, nil
+}
+
+// saveDelegations writes all delegations.
+func saveDelegations(all map[string]delegation) error {
+	path, err := delegationsFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	out, _ := json.MarshalIndent(all, "", "  ")
+	return os.WriteFile(path, append(out, '\n'), 0600)
+}
+
+// findDelegation returns the delegation of a signer address, or nil for an ordinary key.
+// An unreadable delegations file is an error, so scoped keys cannot pay unchecked.
+func findDelegation(address string) (*delegation, error) {
+	all, err := loadDelegations()
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := all[strings.ToLower(address)]; ok {
+		return &d, nil
+	}
+	return nil, nil
+}
+
+// deriveDelegateKey derives a sub-key from the parent key, the delegation's name, and its
+// creation time, so the parent can re-derive it but the sub-key reveals nothing of the parent.
+func deriveDelegateKey(parent *ecdsa.PrivateKey, name string, created time.Time) (*ecdsa.PrivateKey, error) {
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(created.Unix()))
+	seed := crypto.Keccak256([]byte("x402-cli delegate v1"), crypto.FromECDSA(parent), []byte(name), ts[:])
+	return crypto.ToECDSA(seed)
+}
+
+// check refuses a payment outside the delegation's bounds, returning the run status to
+// report: "error" when expired or revoked, "host_not_allowed", or "budget_exceeded".
+// Pending payments count as spent, since they may still settle.
+func (d *delegation) check(records []historyRecord, endpoint string, price x402.PaymentRequirements, now time.Time) (string, error) {
+	switch {
+	case d.Revoked:
+		return "error", fmt.Errorf("delegated key %s was revoked", d.Name)
+	case !now.Before(d.Expires):
+		return "error", fmt.Errorf("delegated key %s expired at %s", d.Name, d.Expires.Local().Format(time.RFC3339))
+	}
+	if d.Network != "" {
+		if info, ok := lookupNetwork(d.Network); !ok || info.ChainID != price.Network {
+			return "error", fmt.Errorf("delegated key %s may only pay on %s, not %s", d.Name, d.Network, networkName(price.Network))
+		}
+	}
+	if len(d.Hosts) > 0 {
+		u, err := url.Parse(endpoint)
+		if err != nil || !hostAllowlist(d.Hosts).allows(u.Hostname()) {
+			return "host_not_allowed", fmt.Errorf("delegated key %s may only pay %s", d.Name, strings.Join(d.Hosts, ", "))
+		}
+	}
+	limit, ok := new(big.Rat).SetString(d.Max)
+	if !ok {
+		return "error", fmt.Errorf("delegated key %s has an invalid max %q", d.Name, d.Max)
+	}
+	spent := new(big.Rat)
+	for _, r := range records {
+		if !strings.EqualFold(r.Payer, d.Address) || (r.Status != "accepted" && r.Status != "pending") {
+			continue
+		}
+		if v, ok := tokenUnits(x402.PaymentRequirements{Network: r.Network, Asset: r.Asset, Amount: r.Amount}); ok {
+			spent.Add(spent, v)
+		}
+	}
+	cost, ok := tokenUnits(price)
+	if !ok {
+		return "budget_exceeded", fmt.Errorf("delegated key %s: cannot convert the price (%s) to token units", d.Name, describeAmount(price))
+	}
+	if total := new(big.Rat).Add(spent, cost); total.Cmp(limit) > 0 {
+		return "budget_exceeded", fmt.Errorf("delegated key %s may pay %s in total: %s already paid, this payment adds %s",
+			d.Name, d.Max, ratString(spent), ratString(cost))
+	}
+	return "", nil
+}
+
+// checkDelegatedSigner applies the bounds of a delegated key to a payment it is about to
+// sign. Ordinary keys pass. Errors reading the state refuse, since the bounds cannot be checked.
+func checkDelegatedSigner(address, endpoint string, price x402.PaymentRequirements) (string, error) {
+	d, err := findDelegation(address)
+	if err != nil {
+		return "error", fmt.Errorf("cannot check delegated key bounds: %w", err)
+	}
+	if d == nil {
+		return "", nil
+	}
+	records, err := loadHistory()
+	if err != nil {
+		return "error", fmt.Errorf("cannot check delegated key bounds: %w", err)
+	}
+	return d.check(records, endpoint, price, time.Now())
+}
+
+// transferCalldata ABI-encodes transfer(to, amount).
+func transferCalldata(to common.Address, rawAmount string) []byte {
+	amount, _ := new(big.Int).SetString(rawAmount, 10)
+	data, _ := hex.DecodeString(transferSelector)
+	data = append(data, common.LeftPadBytes(to.Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(amount.Bytes(), 32)...)
+	return data
+}
+
+// runWalletDelegateCmd creates, lists, or revokes delegated sub-keys of the active wallet.
+func runWalletDelegateCmd(args []string) {
+	fs := flag.NewFlagSet("wallet delegate", flag.ExitOnError)
+	var (
+		name    string
+		network string
+		max     string
+		expires time.Duration
+		hosts   string
+		fund    bool
+		autoYes bool
+		list    bool
+		revoke  string
+		jsonOut bool
+	)
+	fs.StringVar(&name, "name", "", "Name of the delegated key; its profile is --profile <name> (default: delegate-<address prefix>)")
+	fs.StringVar(&network, "network", activeWalletMeta().Network, "Network the key pays on, and --fund sends USDC on")
+	fs.StringVar(&max, "max", "", "Most the key may pay in total, e.g. 5USDC (required)")
+	fs.DurationVar(&expires, "expires", 24*time.Hour, "How long the key may pay")
+	fs.StringVar(&hosts, "hosts", "", "Comma-separated hosts (or *.domain wildcards) the key may pay (default: any)")
+	fs.BoolVar(&fund, "fund", false, "Transfer --max USDC from this wallet to the new key")
+	fs.BoolVar(&autoYes, "yes", false, "Send the --fund transfer without prompting")
+	fs.BoolVar(&autoYes, "y", false, "Send the --fund transfer without prompting (shorthand)")
+	fs.BoolVar(&list, "list", false, "List delegated keys")
+	fs.StringVar(&revoke, "revoke", "", "Revoke the named delegated key: the CLI refuses its payments from then on")
+	fs.BoolVar(&jsonOut, "json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli wallet delegate --max <amount> [--expires 24h] [--hosts api.example.com] [--network <name> --fund] [--json]\n")
+		fmt.Fprintf(os.Stderr, "       x402-cli wallet delegate --list | --revoke <name>\n\n")
+		fmt.Fprintf(os.Stderr, "Derives a sub-key of the %s wallet for an agent. The CLI refuses payments signed by the\n", privateKeyVar())
+		fmt.Fprintf(os.Stderr, "sub-key past its expiry, to other hosts, or beyond --max in total. Give the agent only the\n")
+		fmt.Fprintf(os.Stderr, "sub-key; with --fund it holds at most --max USDC, which bounds what a leaked key can lose.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	all, err := loadDelegations()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	switch {
+	case list:
+		listDelegations(all, jsonOut)
+		return
+	case revoke != "":
+		revokeDelegation(all, revoke, jsonOut)
+		return
+	case max == "":
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+
+	limit, err := parseTokenAmount(max)
+	if err != nil || limit.Sign() == 0 {
+		fmt.Fprintf(os.Stderr, "Error: invalid --max %q: want an amount such as 5USDC\n", max)
+		os.Exit(ExitError)
+	}
+	if expires <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: --expires must be positive")
+		os.Exit(ExitError)
+	}
+	var info networkInfo
+	if network != "" {
+		var ok bool
+		if info, ok = networks[network]; !ok {
+			fmt.Fprintf(os.Stderr, "Error: unknown network: %s (available: %s)\n", network, availableNetworks())
+			os.Exit(ExitError)
+		}
+	} else if fund {
+		fmt.Fprintln(os.Stderr, "Error: --fund needs --network")
+		os.Exit(ExitError)
+	}
+	parent, err := loadPrivateKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	parentAddr := crypto.PubkeyToAddress(parent.PublicKey).Hex()
+	if d, ok := all[strings.ToLower(parentAddr)]; ok {
+		fmt.Fprintf(os.Stderr, "Error: this wallet is itself the delegated key %s; delegate from the parent wallet\n", d.Name)
+		os.Exit(ExitError)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	if name == "" {
+		name = "delegate-" + strings.ToLower(parentAddr[2:8]) + "-" + now.Format("0102150405")
+	}
+	for _, d := range all {
+		if d.Name == name {
+			fmt.Fprintf(os.Stderr, "Error: a delegated key named %s already exists\n", name)
+			os.Exit(ExitError)
+		}
+	}
+	key, err := deriveDelegateKey(parent, name, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to derive the delegated key: %v\n", err)
+		os.Exit(ExitError)
+	}
+	d := delegation{
+		Name:    name,
+		Address: crypto.PubkeyToAddress(key.PublicKey).Hex(),
+		Parent:  parentAddr,
+		Network: network,
+		Max:     ratString(limit),
+		Created: now,
+		Expires: now.Add(expires),
+	}
+	for _, h := range strings.Split(hosts, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			d.Hosts = append(d.Hosts, h)
+		}
+	}
+	// The delegation is recorded before the key is shown, so the key is never unscoped.
+	all[strings.ToLower(d.Address)] = d
+	if err := saveDelegations(all); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+
+	saved := profile
+	profile = name
+	result := &delegateResult{delegation: d, PrivateKey: "0x" + hex.EncodeToString(crypto.FromECDSA(key)), EnvVar: privateKeyVar()}
+	profile = saved
+
+	if !jsonOut {
+		fmt.Printf("Delegated key: %s\n", d.Name)
+		fmt.Printf("Address:  %s\n", d.Address)
+		fmt.Printf("Parent:   %s\n", d.Parent)
+		fmt.Printf("Max:      %s in total\n", d.Max)
+		fmt.Printf("Expires:  %s\n", d.Expires.Local().Format(time.RFC3339))
+		fmt.Printf("Hosts:    %s\n", dashIfEmpty(strings.Join(d.Hosts, ", ")))
+		fmt.Printf("\nGive the agent this key as %s and run it with --profile %s:\n%s\n", result.EnvVar, d.Name, result.PrivateKey)
+	}
+
+	if fund {
+		result.Funding = fundDelegate(info, parent, d.Address, limit, autoYes, jsonOut)
+	} else if !jsonOut {
+		if network != "" {
+			fmt.Printf("\nFund it with up to %s USDC on %s (or rerun with --fund).\n", d.Max, info.Name)
+		} else {
+			fmt.Printf("\nFund it with up to %s USDC on the networks it pays on.\n", d.Max)
+		}
+	}
+	if jsonOut {
+		printJSON(result)
+		if result.Funding != nil && result.Funding.Error != "" {
+			os.Exit(ExitError)
+		}
+	}
+}
+
+// fundDelegate transfers amount USDC from the parent wallet to the delegated key.
+func fundDelegate(info networkInfo, parent *ecdsa.PrivateKey, to string, amount *big.Rat, autoYes, jsonOut bool) *txResult {
+	raw, err := humanToAtomic(amount.FloatString(info.Decimals), info.Decimals)
+	result := &txResult{Action: "transfer", Network: info.Name, ChainID: info.ChainID, From: crypto.PubkeyToAddress(parent.PublicKey).Hex(),
+		To: info.USDCContract, Spender: to, Amount: ratString(amount), Raw: raw}
+	fail := func(err error) *txResult {
+		result.Error = err.Error()
+		if !jsonOut {
+			fmt.Fprintf(os.Stderr, "Error: funding failed: %v\n", err)
+			os.Exit(ExitError)
+		}
+		return result
+	}
+	if err != nil {
+		return fail(err)
+	}
+	calldata := transferCalldata(common.HexToAddress(to), raw)
+	result.Calldata = "0x" + hex.EncodeToString(calldata)
+	prepared, err := prepareTx(info, parent, common.HexToAddress(info.USDCContract), calldata)
+	if err != nil {
+		return fail(err)
+	}
+	result.Fee = prepared.fee
+	if !jsonOut {
+		fmt.Printf("\nFunding: %s USDC on %s from %s\n", result.Amount, info.Name, result.From)
+		printFee(prepared.fee)
+	}
+	if gas := checkGas(info, result.From, prepared.fee); gas != nil {
+		result.Warnings = append(result.Warnings, gas)
+		if !jsonOut {
+			fmt.Fprintf(os.Stderr, "Error: funding failed: %v\n", gas)
+			printGasTopUp(os.Stderr, gas)
+			os.Exit(ExitError)
+		}
+		return fail(gas)
+	}
+	if !autoYes {
+		if jsonOut {
+			return fail(errors.New("confirmation required: pass -y to send in JSON mode"))
+		}
+		if !confirmPrompt("\nSend transaction? [y/N] ") {
+			fmt.Println("Not funded.")
+			return result
+		}
+	}
+	txHash, err := broadcastTx(info, parent, prepared)
+	if err != nil {
+		return fail(err)
+	}
+	result.TxHash = txHash
+	if !jsonOut {
+		fmt.Printf("\nTransaction sent: %s\n", txHash)
+	}
+	return result
+}
+
+// listDelegations prints the delegated keys, newest first.
+func listDelegations(all map[string]delegation, jsonOut bool) {
+	entries := make([]delegation, 0, len(all))
+	for _, d := range all {
+		entries = append(entries, d)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Created.After(entries[j].Created) })
+	if jsonOut {
+		printJSON(entries)
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println("No delegated keys. Create one with: x402-cli wallet delegate --max 5USDC")
+		return
+	}
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tADDRESS\tMAX\tEXPIRES\tHOSTS\tSTATE")
+	for _, d := range entries {
+		state := "active"
+		switch {
+		case d.Revoked:
+			state = "revoked"
+		case !now.Before(d.Expires):
+			state = "expired"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", d.Name, d.Address, d.Max, d.Expires.Local().Format(time.RFC3339),
+			dashIfEmpty(strings.Join(d.Hosts, ",")), state)
+	}
+	w.Flush()
+}
+
+// revokeDelegation marks a delegated key revoked. The record is kept, so the key stays scoped.
+func revokeDelegation(all map[string]delegation, name string, jsonOut bool) {
+	for addr, d := range all {
+		if d.Name != name {
+			continue
+		}
+		d.Revoked = true
+		all[addr] = d
+		if err := saveDelegations(all); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitError)
+		}
+		if jsonOut {
+			printJSON(d)
+			return
+		}
+		fmt.Printf("Revoked %s (%s). Move its remaining funds back to the parent wallet with its key.\n", d.Name, d.Address)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Error: no delegated key named %s\n", name)
+	os.Exit(ExitError)
+}
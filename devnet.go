@@ -0,0 +1,459 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	x402evm "github.com/coinbase/x402/go/mechanisms/evm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// devnetFile records the local chain `devnet up` registered; devnetLogFile is the log of
+// the anvil it started.
+const (
+	devnetFile    = "devnet.json"
+	devnetLogFile = "devnet.log"
+)
+
+// mintSelector is the devnet token's mint(address,uint256) function selector.
+const mintSelector = "40c10f19"
+
+// devnet is a local chain set up by `devnet up`: while registered, it is a testnet like the
+// built-in ones, named Name, whose USDC is the devnet token.
+type devnet struct {
+	Name    string `json:"name"`
+	RPCURL  string `json:"rpcUrl"`
+	ChainID string `json:"chainId"`
+	Token   string `json:"token"`
+	Wallet  string `json:"wallet,omitempty"`
+	// PID is the anvil `devnet up` started, which `devnet down` stops; 0 for a node that
+	// was already running.
+	PID int    `json:"pid,omitempty"`
+	Log string `json:"log,omitempty"`
+}
+
+// devnetResult is the JSON output of `devnet up` and `devnet down`.
+type devnetResult struct {
+	*devnet
+	Started  bool   `json:"started,omitempty"`
+	Deployed bool   `json:"deployed,omitempty"`
+	FundedTo string `json:"fundedUsdc,omitempty"`
+	GasTo    string `json:"fundedEth,omitempty"`
+	Stopped  bool   `json:"stopped,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// loadDevnet reads the registered devnet, or nil when there is none.
+func loadDevnet() (*devnet, error) {
+	path, err := stateFile(devnetFile)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var d devnet
+	if err := json.Unmarshal(raw, &d); err != nil || d.Name == "" || !common.IsHexAddress(d.Token) {
+		return nil, fmt.Errorf("invalid %s; run x402-cli devnet down", path)
+	}
+	return &d, nil
+}
+
+// saveDevnet records d as the registered devnet.
+func saveDevnet(d *devnet) error {
+	path, err := stateFile(devnetFile)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	out, _ := json.MarshalIndent(d, "", "  ")
+	return os.WriteFile(path, append(out, '\n'), 0600)
+}
+
+// registerDevnet adds the registered devnet, if any, to the known networks, and to the
+// x402 EVM network configs, so the token's EIP-712 domain is known wherever a payment
+// option on the devnet is built or signed.
+func registerDevnet() error {
+	d, err := loadDevnet()
+	if err != nil || d == nil {
+		return err
+	}
+	id, ok := new(big.Int).SetString(strings.TrimPrefix(d.ChainID, "eip155:"), 10)
+	if !ok {
+		return fmt.Errorf("invalid devnet chain ID %q; run x402-cli devnet down", d.ChainID)
+	}
+	networks[d.Name] = networkInfo{
+		ChainID:       d.ChainID,
+		RPCURL:        d.RPCURL,
+		USDCContract:  d.Token,
+		Decimals:      devnetTokenDecimals,
+		Name:          "Devnet",
+		NativeSymbol:  "ETH",
+		Confirmations: 1,
+		Testnet:       true,
+	}
+	x402evm.NetworkConfigs[d.ChainID] = x402evm.NetworkConfig{
+		ChainID: id,
+		DefaultAsset: x402evm.AssetInfo{
+			Address:  d.Token,
+			Name:     devnetTokenName,
+			Version:  devnetTokenVersion,
+			Decimals: devnetTokenDecimals,
+		},
+	}
+	return nil
+}
+
+// devnetChainID returns the chain ID of the node at rpcURL, or an error when none answers.
+func devnetChainID(rpcURL string) (*big.Int, error) {
+	var id string
+	if err := rpcCall(rpcURL, "eth_chainId", []any{}, &id); err != nil {
+		return nil, err
+	}
+	return parseHexUint(id)
+}
+
+// startAnvil starts anvil on port and waits until it answers; it keeps running after the
+// CLI exits, logging to devnet.log in the config directory.
+func startAnvil(program string, port int, chainID uint64) (*exec.Cmd, string, error) {
+	path, err := exec.LookPath(program)
+	if err != nil {
+		return nil, "", fmt.Errorf("no chain at 127.0.0.1:%d and %s is not installed: install Foundry (https://getfoundry.sh), "+
+			"or start a node yourself (e.g. npx hardhat node) and pass --rpc", port, program)
+	}
+	logPath, err := stateFile(devnetLogFile)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(logPath), 0700); err != nil {
+		return nil, "", err
+	}
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return nil, "", err
+	}
+	defer logFile.Close()
+	cmd := exec.Command(path, "--port", strconv.Itoa(port), "--chain-id", strconv.FormatUint(chainID, 10))
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	if err := cmd.Start(); err != nil {
+		return nil, "", fmt.Errorf("cannot start %s: %w", path, err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	rpcURL := fmt.Sprintf("http://127.0.0.1:%d", port)
+	for deadline := time.Now().Add(15 * time.Second); time.Now().Before(deadline); {
+		select {
+		case err := <-exited:
+			return nil, "", fmt.Errorf("%s exited (%v); see %s", path, err, logPath)
+		case <-time.After(200 * time.Millisecond):
+		}
+		if _, err := devnetChainID(rpcURL); err == nil {
+			return cmd, logPath, nil
+		}
+	}
+	cmd.Process.Kill()
+	return nil, "", fmt.Errorf("%s did not answer on %s within 15s; see %s", path, rpcURL, logPath)
+}
+
+// sendDevTx sends a transaction from one of the node's unlocked accounts and waits until it
+// is mined, returning its receipt.
+func sendDevTx(rpcURL string, tx map[string]string) (*devReceipt, error) {
+	var hash string
+	if err := rpcCall(rpcURL, "eth_sendTransaction", []any{tx}, &hash); err != nil {
+		return nil, err
+	}
+	for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); time.Sleep(250 * time.Millisecond) {
+		var receipt *devReceipt
+		if err := rpcCall(rpcURL, "eth_getTransactionReceipt", []any{hash}, &receipt); err != nil {
+			return nil, err
+		}
+		if receipt == nil {
+			continue
+		}
+		if receipt.Status != "0x1" {
+			return nil, fmt.Errorf("transaction %s reverted", hash)
+		}
+		return receipt, nil
+	}
+	return nil, fmt.Errorf("transaction %s was not mined within 30s (is the node mining?)", hash)
+}
+
+// devReceipt is the part of a transaction receipt `devnet up` reads.
+type devReceipt struct {
+	Status          string `json:"status"`
+	ContractAddress string `json:"contractAddress"`
+}
+
+// deployDevnetToken deploys the devnet token from account and returns its address.
+func deployDevnetToken(rpcURL, account string) (string, error) {
+	code, err := devnetTokenInitCode()
+	if err != nil {
+		return "", err
+	}
+	receipt, err := sendDevTx(rpcURL, map[string]string{"from": account, "data": "0x" + hex.EncodeToString(code)})
+	if err != nil {
+		return "", fmt.Errorf("cannot deploy the devnet token: %w", err)
+	}
+	if !common.IsHexAddress(receipt.ContractAddress) {
+		return "", errors.New("cannot deploy the devnet token: the receipt names no contract")
+	}
+	return common.HexToAddress(receipt.ContractAddress).Hex(), nil
+}
+
+// hasCode reports whether a contract is deployed at address.
+func hasCode(rpcURL, address string) bool {
+	var code string
+	return rpcCall(rpcURL, "eth_getCode", []any{address, "latest"}, &code) == nil && len(strings.TrimPrefix(code, "0x")) > 0
+}
+
+// mintCalldata encodes the devnet token's mint(to, amount).
+func mintCalldata(to common.Address, amount *big.Int) []byte {
+	data, _ := hex.DecodeString(mintSelector)
+	data = append(data, common.LeftPadBytes(to.Bytes(), 32)...)
+	return append(data, common.LeftPadBytes(amount.Bytes(), 32)...)
+}
+
+// topUpDevnet brings wallet's token balance up to usdc and its ETH balance up to eth (both
+// atomic), minting and sending from account, and returns how much of each it added.
+func topUpDevnet(rpcURL, account, token, wallet string, usdc, eth *big.Int) (addedUSDC, addedETH *big.Int, err error) {
+	addedUSDC, addedETH = new(big.Int), new(big.Int)
+	_, raw, err := queryUSDCBalance(rpcURL, token, wallet)
+	if err != nil {
+		return nil, nil, err
+	}
+	if have, _ := new(big.Int).SetString(raw, 10); have != nil && have.Cmp(usdc) < 0 {
+		addedUSDC.Sub(usdc, have)
+		data := "0x" + hex.EncodeToString(mintCalldata(common.HexToAddress(wallet), addedUSDC))
+		if _, err := sendDevTx(rpcURL, map[string]string{"from": account, "to": token, "data": data}); err != nil {
+			return nil, nil, fmt.Errorf("cannot mint devnet USDC: %w", err)
+		}
+	}
+	var balance string
+	if err := rpcCall(rpcURL, "eth_getBalance", []any{wallet, "latest"}, &balance); err != nil {
+		return nil, nil, err
+	}
+	if have, err := parseHexUint(balance); err == nil && have.Cmp(eth) < 0 {
+		addedETH.Sub(eth, have)
+		if _, err := sendDevTx(rpcURL, map[string]string{"from": account, "to": wallet, "value": "0x" + addedETH.Text(16)}); err != nil {
+			return nil, nil, fmt.Errorf("cannot send devnet ETH: %w", err)
+		}
+	}
+	return addedUSDC, addedETH, nil
+}
+
+// runDevnetCmd dispatches `devnet up` and `devnet down`.
+func runDevnetCmd(args []string) {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli devnet up|down [flags]\n\n")
+		fmt.Fprintf(os.Stderr, "  up    Start anvil (or connect to a running node), deploy a mock USDC, fund the wallet,\n")
+		fmt.Fprintf(os.Stderr, "        and register the chain as a network\n")
+		fmt.Fprintf(os.Stderr, "  down  Stop the anvil 'up' started and unregister the network\n")
+	}
+	if len(args) == 0 {
+		usage()
+		os.Exit(ExitError)
+	}
+	switch args[0] {
+	case "up":
+		runDevnetUpCmd(args[1:])
+	case "down":
+		runDevnetDownCmd(args[1:])
+	case "-h", "--help", "help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown devnet command %q\n\n", args[0])
+		usage()
+		os.Exit(ExitError)
+	}
+}
+
+// runDevnetUpCmd sets up a local chain for exercising payments end to end.
+func runDevnetUpCmd(args []string) {
+	fs := flag.NewFlagSet("devnet up", flag.ExitOnError)
+	rpcURL := fs.String("rpc", "", "Use the node at this URL (anvil, hardhat node, ...) instead of 127.0.0.1:--port")
+	port := fs.Int("port", 8545, "Port of the local node, where anvil is started unless a node already answers")
+	chainID := fs.Uint64("chain-id", 31337, "Chain ID of an anvil started by devnet up")
+	anvil := fs.String("anvil", "anvil", "The anvil program to start")
+	name := fs.String("name", "devnet", "Network name the chain is registered under, for --network and wallet --network")
+	address := fs.String("address", "", "Wallet to fund (default: the address of "+privateKeyVar()+")")
+	fund := fs.String("fund", "1000", "Top the wallet's mock USDC up to this amount")
+	gas := fs.String("eth", "1", "Top the wallet's ETH up to this amount, for transactions of its own")
+	jsonOut := fs.Bool("json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli devnet up [--rpc <url> | --port 8545] [--fund 1000] [--name devnet] [--json]\n\n")
+		fmt.Fprintf(os.Stderr, "Sets up a local chain to run the whole pay and settle loop against, e.g. in CI. Unless a\n")
+		fmt.Fprintf(os.Stderr, "node answers on 127.0.0.1:--port (or --rpc names one), anvil is started there and keeps\n")
+		fmt.Fprintf(os.Stderr, "running until 'devnet down'. A mock USDC supporting EIP-3009 (domain name \"USDC\",\n")
+		fmt.Fprintf(os.Stderr, "version \"2\", 6 decimals; anyone can mint) is deployed from the node's first unlocked\n")
+		fmt.Fprintf(os.Stderr, "account, the wallet is topped up with it and with ETH, and the chain is registered as the\n")
+		fmt.Fprintf(os.Stderr, "testnet --name for every command. Running it again reuses the node and the token.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	result := &devnetResult{}
+	fail := func(err error) {
+		if *jsonOut {
+			result.Error = err.Error()
+			printJSON(result)
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		os.Exit(ExitError)
+	}
+	usdc, err := humanToAtomic(*fund, devnetTokenDecimals)
+	if err != nil {
+		fail(fmt.Errorf("--fund: %w", err))
+	}
+	eth, err := humanToAtomic(*gas, 18)
+	if err != nil {
+		fail(fmt.Errorf("--eth: %w", err))
+	}
+	wallet := *address
+	if wallet == "" {
+		if key, err := loadPrivateKey(); err == nil {
+			wallet = crypto.PubkeyToAddress(key.PublicKey).Hex()
+		}
+	} else if _, err := parseAddress(wallet, "address"); err != nil {
+		fail(err)
+	}
+
+	prev, err := loadDevnet()
+	if err != nil {
+		fail(err)
+	}
+	if _, builtIn := networks[*name]; builtIn && (prev == nil || prev.Name != *name) {
+		fail(fmt.Errorf("--name %s is a built-in network", *name))
+	}
+	d := &devnet{Name: *name, RPCURL: *rpcURL, Wallet: wallet}
+	if d.RPCURL == "" {
+		d.RPCURL = fmt.Sprintf("http://127.0.0.1:%d", *port)
+	}
+	id, err := devnetChainID(d.RPCURL)
+	if err != nil {
+		if *rpcURL != "" {
+			fail(fmt.Errorf("no node answers at %s: %w", *rpcURL, err))
+		}
+		cmd, logPath, err := startAnvil(*anvil, *port, *chainID)
+		if err != nil {
+			fail(err)
+		}
+		d.PID, d.Log, result.Started = cmd.Process.Pid, logPath, true
+		if id, err = devnetChainID(d.RPCURL); err != nil {
+			fail(err)
+		}
+	} else if prev != nil && prev.RPCURL == d.RPCURL {
+		d.PID, d.Log = prev.PID, prev.Log
+	}
+	d.ChainID = "eip155:" + id.String()
+	if info, known := networkByChainID(d.ChainID); known && (prev == nil || prev.ChainID != d.ChainID) {
+		fail(fmt.Errorf("the node at %s is on %s (%s), not a local chain", d.RPCURL, info.Name, d.ChainID))
+	}
+
+	var accounts []string
+	if err := rpcCall(d.RPCURL, "eth_accounts", []any{}, &accounts); err != nil || len(accounts) == 0 {
+		fail(fmt.Errorf("the node at %s has no unlocked account to deploy and fund from", d.RPCURL))
+	}
+	if prev != nil && prev.RPCURL == d.RPCURL && prev.ChainID == d.ChainID && hasCode(d.RPCURL, prev.Token) {
+		d.Token = prev.Token
+	} else {
+		if d.Token, err = deployDevnetToken(d.RPCURL, accounts[0]); err != nil {
+			fail(err)
+		}
+		result.Deployed = true
+	}
+	if wallet != "" {
+		want, _ := new(big.Int).SetString(usdc, 10)
+		wantETH, _ := new(big.Int).SetString(eth, 10)
+		addedUSDC, addedETH, err := topUpDevnet(d.RPCURL, accounts[0], d.Token, wallet, want, wantETH)
+		if err != nil {
+			fail(err)
+		}
+		result.FundedTo, result.GasTo = atomicToHuman(addedUSDC.String(), devnetTokenDecimals), weiToUnit(addedETH.String(), 18)
+	}
+	if err := saveDevnet(d); err != nil {
+		fail(err)
+	}
+	result.devnet = d
+
+	if *jsonOut {
+		printJSON(result)
+		return
+	}
+	if result.Started {
+		fmt.Printf("Started anvil (pid %d) on %s; log: %s\n", d.PID, d.RPCURL, d.Log)
+	} else {
+		fmt.Printf("Using the node at %s\n", d.RPCURL)
+	}
+	if result.Deployed {
+		fmt.Printf("Deployed mock USDC at %s\n", d.Token)
+	} else {
+		fmt.Printf("Mock USDC already at %s\n", d.Token)
+	}
+	switch {
+	case wallet == "":
+		fmt.Printf("No wallet funded: set %s or pass --address\n", privateKeyVar())
+	default:
+		fmt.Printf("Wallet %s: topped up by %s USDC and %s ETH (to %s USDC, %s ETH)\n", wallet, result.FundedTo, result.GasTo, *fund, *gas)
+	}
+	fmt.Printf("Registered network %q (%s): pay on it with --network %s, check the wallet with wallet --network %s\n",
+		d.Name, d.ChainID, d.Name, d.Name)
+	fmt.Printf("Settlement needs a facilitator that sends transactions to %s.\n", d.RPCURL)
+}
+
+// runDevnetDownCmd stops the anvil `devnet up` started and unregisters the devnet.
+func runDevnetDownCmd(args []string) {
+	fs := flag.NewFlagSet("devnet down", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli devnet down [--json]\n\n")
+		fmt.Fprintf(os.Stderr, "Stops the anvil 'devnet up' started, if it started one, and unregisters the network.\n")
+		fmt.Fprintf(os.Stderr, "A node that was already running is left alone.\n")
+	}
+	fs.Parse(args)
+
+	d, err := loadDevnet()
+	result := &devnetResult{devnet: d}
+	if err != nil && d == nil {
+		// An unreadable registration is removed all the same.
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	if d != nil && d.PID > 0 {
+		if p, err := os.FindProcess(d.PID); err == nil && p.Kill() == nil {
+			result.Stopped = true
+		}
+	}
+	if path, err := stateFile(devnetFile); err == nil {
+		os.Remove(path)
+	}
+	if *jsonOut {
+		printJSON(result)
+		return
+	}
+	switch {
+	case d == nil:
+		fmt.Println("No devnet was registered.")
+	case result.Stopped:
+		fmt.Printf("Stopped anvil (pid %d) and unregistered network %q.\n", d.PID, d.Name)
+	default:
+		fmt.Printf("Unregistered network %q; the node at %s was left running.\n", d.Name, d.RPCURL)
+	}
+}
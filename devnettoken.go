@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// The devnet token is a mock USDC for `devnet up`: an ERC-20 with 6 decimals and EIP-3009
+// transferWithAuthorization, signed under the EIP-712 domain {name: "USDC", version: "2"}
+// as Base Sepolia's USDC is, so x402 payments and their settlement work against it as they
+// do against the real one. Anyone can mint it. It is written in EVM assembly and assembled
+// by assembleEVM, so the CLI needs no Solidity toolchain and the bytecode can be reviewed
+// here. Storage is laid out as Solidity would lay out
+//
+//	uint256 totalSupply;                                            // slot 0
+//	mapping(address => uint256) balanceOf;                          // slot 1
+//	mapping(address => mapping(bytes32 => bool)) authorizationState; // slot 2
+//
+// and every failed check reverts without data.
+const (
+	devnetTokenName     = "USDC"
+	devnetTokenVersion  = "2"
+	devnetTokenDecimals = 6
+)
+
+// Macros of the token listing; the comments give the stack before -> after.
+const (
+	// [addr] -> [addr & (2^160-1)]
+	evmMaskAddress = ` 0xffffffffffffffffffffffffffffffffffffffff and `
+	// [addr] -> [slot of balanceOf[addr]]
+	evmBalanceSlot = ` 0 mstore 1 0x20 mstore 0x40 0 keccak256 `
+	// [from nonce] -> [slot of authorizationState[from][nonce]]
+	evmAuthSlot = ` 0 mstore 2 0x20 mstore 0x40 0 keccak256 0x20 mstore 0 mstore 0x40 0 keccak256 `
+	// [] -> [EIP-712 domain separator], for the current chain ID
+	evmDomainSeparator = ` $domainTypehash 0 mstore $nameHash 0x20 mstore $versionHash 0x40 mstore
+		chainid 0x60 mstore address 0x80 mstore 0xa0 0 keccak256 `
+	// [from to value] -> []: moves value and logs Transfer
+	evmTransfer = ` dup1` + evmBalanceSlot + `dup1 sload
+		dup5 dup2 lt @fail jumpi
+		dup5 swap1 sub swap1 sstore
+		dup2` + evmBalanceSlot + `dup1 sload dup5 add swap1 sstore
+		dup3 0 mstore $transferEvent 0x20 0 log3 pop `
+	// [word] -> []: returns one ABI word
+	evmReturnWord = ` 0 mstore 0x20 0 return `
+)
+
+// devnetTokenListing is the runtime code of the devnet token.
+const devnetTokenListing = `
+	0 calldataload 0xe0 shr
+	dup1 0x70a08231 eq @balanceOf jumpi
+	dup1 0xe3ee160e eq @transferWithAuthorization jumpi
+	dup1 0xe94a0102 eq @authorizationState jumpi
+	dup1 0xa9059cbb eq @transfer jumpi
+	dup1 0x40c10f19 eq @mint jumpi
+	dup1 0x313ce567 eq @decimals jumpi
+	dup1 0x06fdde03 eq @name jumpi
+	dup1 0x95d89b41 eq @name jumpi ; symbol() is the name too
+	dup1 0x54fd4d50 eq @version jumpi
+	dup1 0x18160ddd eq @totalSupply jumpi
+	dup1 0x3644e515 eq @domainSeparator jumpi
+fail:
+	0 dup1 revert
+
+balanceOf: ; balanceOf(address)
+	4 calldataload` + evmMaskAddress + evmBalanceSlot + `sload` + evmReturnWord + `
+
+transferWithAuthorization: ; (from, to, value, validAfter, validBefore, nonce, v, r, s)
+	0x64 calldataload timestamp gt iszero @fail jumpi
+	0x84 calldataload timestamp lt iszero @fail jumpi
+	0xa4 calldataload 4 calldataload` + evmMaskAddress + evmAuthSlot + `
+	dup1 sload @fail jumpi
+	1 swap1 sstore
+	; keccak256("\x19\x01" || domainSeparator || hashStruct(TransferWithAuthorization))
+	$authorizationTypehash 0 mstore
+	4 calldataload` + evmMaskAddress + `0x20 mstore
+	0x24 calldataload` + evmMaskAddress + `0x40 mstore
+	0x44 calldataload 0x60 mstore
+	0x64 calldataload 0x80 mstore
+	0x84 calldataload 0xa0 mstore
+	0xa4 calldataload 0xc0 mstore
+	0xe0 0 keccak256` + evmDomainSeparator + `
+	0x02 mstore 0x22 mstore 0x19 0 mstore8 1 1 mstore8
+	0x42 0 keccak256
+	; ecrecover(digest, v, r, s) must be from
+	0 mstore 0xc4 calldataload 0x20 mstore 0xe4 calldataload 0x40 mstore 0x104 calldataload 0x60 mstore
+	0 0x80 mstore
+	0x20 0x80 0x80 0 1 gas staticcall iszero @fail jumpi
+	0x80 mload dup1 iszero @fail jumpi
+	4 calldataload` + evmMaskAddress + `eq iszero @fail jumpi
+	0xa4 calldataload 4 calldataload` + evmMaskAddress + `$authorizationUsedEvent 0 0 log3
+	0x44 calldataload 0x24 calldataload` + evmMaskAddress + `4 calldataload` + evmMaskAddress + evmTransfer + `
+	stop
+
+authorizationState: ; authorizationState(address, bytes32)
+	0x24 calldataload 4 calldataload` + evmMaskAddress + evmAuthSlot + `sload` + evmReturnWord + `
+
+transfer: ; transfer(address, uint256)
+	0x24 calldataload 4 calldataload` + evmMaskAddress + `caller` + evmTransfer + `
+	1` + evmReturnWord + `
+
+mint: ; mint(address, uint256)
+	0x24 calldataload
+	0 sload dup2 dup2 add dup1 swap2 gt @fail jumpi
+	0 sstore
+	4 calldataload` + evmMaskAddress + `
+	dup1` + evmBalanceSlot + `dup1 sload dup4 add swap1 sstore
+	swap1 0 mstore 0 $transferEvent 0x20 0 log3
+	stop
+
+decimals:
+	6` + evmReturnWord + `
+
+name:
+	0x20 0 mstore 4 0x20 mstore $name 0x40 mstore 0x60 0 return
+
+version:
+	0x20 0 mstore 1 0x20 mstore $version 0x40 mstore 0x60 0 return
+
+totalSupply:
+	0 sload` + evmReturnWord + `
+
+domainSeparator:
+` + evmDomainSeparator + evmReturnWord
+
+// devnetTokenConstants are the $names of the token listing.
+func devnetTokenConstants() map[string][]byte {
+	leftAligned := func(s string) []byte { return append([]byte(s), make([]byte, 32-len(s))...) }
+	return map[string][]byte{
+		"domainTypehash":         crypto.Keccak256([]byte("EIP712Domain(string name,string version,uint256 chainId,address verifyingContract)")),
+		"nameHash":               crypto.Keccak256([]byte(devnetTokenName)),
+		"versionHash":            crypto.Keccak256([]byte(devnetTokenVersion)),
+		"authorizationTypehash":  crypto.Keccak256([]byte("TransferWithAuthorization(address from,address to,uint256 value,uint256 validAfter,uint256 validBefore,bytes32 nonce)")),
+		"transferEvent":          crypto.Keccak256([]byte("Transfer(address,address,uint256)")),
+		"authorizationUsedEvent": crypto.Keccak256([]byte("AuthorizationUsed(address,bytes32)")),
+		"name":                   leftAligned(devnetTokenName),
+		"version":                leftAligned(devnetTokenVersion),
+	}
+}
+
+// evmOpcodes are the opcodes assembleEVM knows, besides dup1-16 and swap1-16.
+var evmOpcodes = map[string]byte{
+	"stop": 0x00, "add": 0x01, "sub": 0x03, "lt": 0x10, "gt": 0x11, "eq": 0x14, "iszero": 0x15,
+	"and": 0x16, "shr": 0x1c, "keccak256": 0x20, "address": 0x30, "caller": 0x33,
+	"calldataload": 0x35, "timestamp": 0x42, "chainid": 0x46, "pop": 0x50,
+	"mload": 0x51, "mstore": 0x52, "mstore8": 0x53, "sload": 0x54, "sstore": 0x55,
+	"jumpi": 0x57, "gas": 0x5a, "jumpdest": 0x5b, "log3": 0xa3, "return": 0xf3,
+	"staticcall": 0xfa, "revert": 0xfd,
+}
+
+// assembleEVM assembles an EVM assembly listing: opcodes by name; numbers, which are pushed
+// in as few bytes as they fit; $name, which pushes constants[name]; "label:", which marks a
+// jump destination; and @label, which pushes its address. ";" starts a comment.
+func assembleEVM(listing string, constants map[string][]byte) ([]byte, error) {
+	var code []byte
+	labels := map[string]int{}
+	fixups := map[int]string{}
+	push := func(b []byte) {
+		code = append(code, 0x5f+byte(len(b)))
+		code = append(code, b...)
+	}
+	for _, line := range strings.Split(listing, "\n") {
+		line, _, _ = strings.Cut(line, ";")
+		for _, tok := range strings.Fields(line) {
+			switch {
+			case strings.HasSuffix(tok, ":"):
+				labels[strings.TrimSuffix(tok, ":")] = len(code)
+				code = append(code, evmOpcodes["jumpdest"])
+			case strings.HasPrefix(tok, "@"):
+				fixups[len(code)+1] = tok[1:]
+				push([]byte{0, 0})
+			case strings.HasPrefix(tok, "$"):
+				c, ok := constants[tok[1:]]
+				if !ok || len(c) == 0 || len(c) > 32 {
+					return nil, fmt.Errorf("unknown constant %s", tok)
+				}
+				push(c)
+			case tok[0] >= '0' && tok[0] <= '9':
+				n, ok := new(big.Int).SetString(tok, 0)
+				if !ok || n.Sign() < 0 || n.BitLen() > 256 {
+					return nil, fmt.Errorf("bad number %s", tok)
+				}
+				b := n.Bytes()
+				if len(b) == 0 {
+					b = []byte{0}
+				}
+				push(b)
+			case strings.HasPrefix(tok, "dup") || strings.HasPrefix(tok, "swap"):
+				name := strings.TrimRight(tok, "0123456789")
+				n, err := strconv.Atoi(tok[len(name):])
+				if err != nil || n < 1 || n > 16 || (name != "dup" && name != "swap") {
+					return nil, fmt.Errorf("unknown opcode %s", tok)
+				}
+				base := byte(0x80)
+				if name == "swap" {
+					base = 0x90
+				}
+				code = append(code, base+byte(n-1))
+			default:
+				op, ok := evmOpcodes[tok]
+				if !ok {
+					return nil, fmt.Errorf("unknown opcode %s", tok)
+				}
+				code = append(code, op)
+			}
+		}
+	}
+	for at, label := range fixups {
+		dest, ok := labels[label]
+		if !ok {
+			return nil, fmt.Errorf("unknown label %s", label)
+		}
+		code[at], code[at+1] = byte(dest>>8), byte(dest)
+	}
+	return code, nil
+}
+
+// devnetTokenInitCode is the creation code of the devnet token: a constructor that returns
+// the runtime code appended to it.
+func devnetTokenInitCode() ([]byte, error) {
+	runtime, err := assembleEVM(devnetTokenListing, devnetTokenConstants())
+	if err != nil {
+		return nil, err
+	}
+	n := len(runtime)
+	// PUSH2 n, PUSH1 14, PUSH1 0, CODECOPY, PUSH2 n, PUSH1 0, RETURN: 14 bytes.
+	constructor := []byte{0x61, byte(n >> 8), byte(n), 0x60, 0x0e, 0x60, 0x00, 0x39, 0x61, byte(n >> 8), byte(n), 0x60, 0x00, 0xf3}
+	return append(constructor, runtime...), nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// egressPath is one way of reaching the endpoint: "direct", or a fallback through an
+// HTTP proxy ("proxy") or a secondary DNS server ("dns").
+type egressPath struct {
+	Kind string
+	Via  string // proxy URL or DNS server host:port
+}
+
+func (p egressPath) String() string {
+	if p.Via == "" {
+		return p.Kind
+	}
+	return p.Kind + " " + p.Via
+}
+
+// egressAttempt is the probe's outcome over one path.
+type egressAttempt struct {
+	Path      string `json:"path"`
+	Via       string `json:"via,omitempty"`
+	OK        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
+	ErrorType string `json:"errorType,omitempty"`
+}
+
+// egressReport is set when the direct probe failed at the network level and fallbacks were tried.
+type egressReport struct {
+	// Path is the one that succeeded, used for the payment too ("" when none did).
+	Path     string          `json:"path"`
+	Via      string          `json:"via,omitempty"`
+	Attempts []egressAttempt `json:"attempts"`
+	// Skipped is why the fallbacks were not tried: the request was not safe to repeat.
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// record adds the outcome of the probe over p.
+func (r *egressReport) record(p egressPath, err error) {
+	a := egressAttempt{Path: p.Kind, Via: p.Via, OK: err == nil}
+	if err != nil {
+		a.Error = err.Error()
+		_, a.ErrorType = classifyError(err)
+	} else {
+		r.Path, r.Via = p.Kind, p.Via
+	}
+	r.Attempts = append(r.Attempts, a)
+}
+
+// fallbackPaths parses --fallback-proxy and --fallback-dns into the paths tried, in that
+// order, after the direct probe fails.
+func fallbackPaths(proxy, dns string) ([]egressPath, error) {
+	var paths []egressPath
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+			return nil, fmt.Errorf("invalid --fallback-proxy %q: want http://, https://, or socks5://host:port", proxy)
+		}
+		paths = append(paths, egressPath{Kind: "proxy", Via: proxy})
+	}
+	if dns != "" {
+		server := dns
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+		}
+		if host, _, _ := net.SplitHostPort(server); net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("invalid --fallback-dns %q: want an IP address, optionally with :port", dns)
+		}
+		paths = append(paths, egressPath{Kind: "dns", Via: server})
+	}
+	return paths, nil
+}
+
+// transport returns a copy of base that reaches the network over p.
+func (p egressPath) transport(base *http.Transport) *http.Transport {
+	t := base.Clone()
+	switch p.Kind {
+	case "proxy":
+		u, _ := url.Parse(p.Via)
+		t.Proxy = http.ProxyURL(u)
+	case "dns":
+		var d net.Dialer
+		d.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return d.DialContext(ctx, network, p.Via)
+			},
+		}
+		t.DialContext = d.DialContext
+	}
+	return t
+}
+
+// isNetworkError reports whether a request failed before reaching the server: name
+// resolution, connecting, or a timeout. TLS and HTTP errors are not retried over another path.
+func isNetworkError(err error) bool {
+	if _, kind := classifyError(err); kind == "dns" || kind == "timeout" {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fingerprint is the JSON output of `x402-cli fingerprint`: how an x402 endpoint's stack
+// presents itself, without paying it. It holds no secrets and no query strings, so it can
+// be shared as is.
+type fingerprint struct {
+	Endpoint   string    `json:"endpoint"`
+	Method     string    `json:"method"`
+	Time       time.Time `json:"time"`
+	Client     string    `json:"client"`
+	StatusCode int       `json:"statusCode"`
+	Protocol   string    `json:"protocol,omitempty"`
+	TLS        string    `json:"tls,omitempty"`
+	Server     banner    `json:"server"`
+	// Middleware are the frameworks, hosts, and proxies the response headers point to.
+	Middleware  []string             `json:"middleware,omitempty"`
+	X402        *x402Fingerprint     `json:"x402,omitempty"`
+	Facilitator *facilitatorHint     `json:"facilitator,omitempty"`
+	Timing      fingerprintTiming    `json:"timing"`
+	ServerTime  []serverTimingMetric `json:"serverTiming,omitempty"`
+	Error       string               `json:"error,omitempty"`
+}
+
+// banner is what the server says about itself.
+type banner struct {
+	Server    string `json:"server,omitempty"`
+	PoweredBy string `json:"poweredBy,omitempty"`
+	Via       string `json:"via,omitempty"`
+}
+
+// x402Fingerprint is how the endpoint speaks x402.
+type x402Fingerprint struct {
+	Version int `json:"version"`
+	// Transport is where the requirements came from: the PAYMENT-REQUIRED "header" or the "body".
+	Transport string `json:"transport"`
+	// Error is the requirements' error message, which differs between middleware.
+	Error string `json:"error,omitempty"`
+	// Fields are the top-level keys of the requirements, e.g. resource and extensions.
+	Fields []string `json:"fields"`
+	// ExtraKeys are the keys of the accepts entries' extra objects.
+	ExtraKeys []string     `json:"extraKeys,omitempty"`
+	Schemes   []capability `json:"schemes"`
+	// Exposed is Access-Control-Expose-Headers, which browser clients need.
+	Exposed string `json:"exposed,omitempty"`
+}
+
+// facilitatorHint is the facilitator the endpoint names, and where it did.
+type facilitatorHint struct {
+	Identity string `json:"identity"`
+	Source   string `json:"source"`
+}
+
+// fingerprintTiming is the median of the samples, each over a new connection.
+type fingerprintTiming struct {
+	Samples     int   `json:"samples"`
+	DNSMs       int64 `json:"dnsMs"`
+	ConnectMs   int64 `json:"connectMs"`
+	TLSMs       int64 `json:"tlsMs"`
+	FirstByteMs int64 `json:"firstByteMs"`
+	TotalMs     int64 `json:"totalMs"`
+}
+
+// middlewareHeaders maps response headers to the stack they reveal.
+var middlewareHeaders = []struct{ header, hint string }{
+	{"CF-Ray", "Cloudflare"},
+	{"X-Vercel-Id", "Vercel"},
+	{"Fly-Request-Id", "Fly.io"},
+	{"X-Amz-Cf-Id", "Amazon CloudFront"},
+	{"X-Amzn-RequestId", "AWS API Gateway/Lambda"},
+	{"X-Railway-Request-Id", "Railway"},
+	{"X-Render-Origin-Server", "Render"},
+	{"X-Nf-Request-Id", "Netlify"},
+	{"X-Cloud-Trace-Context", "Google Cloud"},
+	{"X-Nextjs-Cache", "Next.js"},
+	{"X-Nextjs-Matched-Path", "Next.js"},
+	{"X-Middleware-Rewrite", "Next.js middleware"},
+	{"X-Envoy-Upstream-Service-Time", "Envoy"},
+	{"X-Kong-Upstream-Latency", "Kong"},
+}
+
+// middlewareHints lists what the response headers reveal about the server's stack, in order
+// and without repeats.
+func middlewareHints(h http.Header) []string {
+	var hints []string
+	add := func(hint string) {
+		for _, seen := range hints {
+			if seen == hint {
+				return
+			}
+		}
+		hints = append(hints, hint)
+	}
+	for _, m := range middlewareHeaders {
+		if h.Get(m.header) != "" {
+			add(m.hint)
+		}
+	}
+	for _, v := range []string{h.Get("Server"), h.Get("X-Powered-By")} {
+		for _, name := range []string{"Express", "Hono", "Next.js", "FastAPI", "uvicorn", "Werkzeug", "nginx", "Caddy", "Cloudflare", "Deno", "Bun"} {
+			if strings.Contains(strings.ToLower(v), strings.ToLower(name)) {
+				add(name)
+			}
+		}
+	}
+	return hints
+}
+
+// facilitatorIdentity looks for the facilitator an unpaid 402 names: an X-Facilitator
+// header, a facilitator key in the accepts entries' extra, or a Server-Timing metric that
+// times it. Which facilitator settles is otherwise only known after paying.
+func facilitatorIdentity(h http.Header, extras []map[string]any, timing []serverTimingMetric) *facilitatorHint {
+	if v := h.Get("X-Facilitator"); v != "" {
+		return &facilitatorHint{Identity: v, Source: "X-Facilitator header"}
+	}
+	for _, extra := range extras {
+		for _, key := range []string{"facilitator", "facilitatorUrl", "facilitatorURL"} {
+			if v, ok := extra[key].(string); ok && v != "" {
+				return &facilitatorHint{Identity: v, Source: "accepts extra." + key}
+			}
+		}
+	}
+	for _, m := range timing {
+		if facilitatorMetric.MatchString(m.Name) && m.Desc != "" {
+			return &facilitatorHint{Identity: m.Desc, Source: "Server-Timing " + m.Name}
+		}
+	}
+	return nil
+}
+
+// describeX402 reads the requirements of a 402 response, or returns nil when it has none.
+func describeX402(resp *http.Response, body []byte) (*x402Fingerprint, []map[string]any) {
+	fp := &x402Fingerprint{Transport: "body", Exposed: resp.Header.Get("Access-Control-Expose-Headers")}
+	raw := body
+	if header := resp.Header.Get("PAYMENT-REQUIRED"); header != "" {
+		decoded, err := base64.StdEncoding.DecodeString(header)
+		if err != nil {
+			return nil, nil
+		}
+		raw, fp.Transport = decoded, "header"
+	}
+	var required map[string]json.RawMessage
+	if json.Unmarshal(raw, &required) != nil || required["accepts"] == nil {
+		return nil, nil
+	}
+	for k := range required {
+		fp.Fields = append(fp.Fields, k)
+	}
+	sort.Strings(fp.Fields)
+	json.Unmarshal(required["x402Version"], &fp.Version)
+	json.Unmarshal(required["error"], &fp.Error)
+	fp.Schemes, _ = capabilityMatrix(raw)
+
+	var accepts []struct {
+		Extra map[string]any `json:"extra"`
+	}
+	json.Unmarshal(required["accepts"], &accepts)
+	var extras []map[string]any
+	seen := map[string]bool{}
+	for _, a := range accepts {
+		extras = append(extras, a.Extra)
+		for k := range a.Extra {
+			if !seen[k] {
+				seen[k] = true
+				fp.ExtraKeys = append(fp.ExtraKeys, k)
+			}
+		}
+	}
+	sort.Strings(fp.ExtraKeys)
+	return fp, extras
+}
+
+// shareableURL drops the credentials, query, and fragment from endpoint.
+func shareableURL(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	u.User, u.RawQuery, u.Fragment = nil, "", ""
+	return u.String()
+}
+
+// timedExchange sends one request over a new connection and measures each phase.
+func timedExchange(client *http.Client, method, endpoint string) (*http.Response, []byte, fingerprintTiming, error) {
+	var t fingerprintTiming
+	var dnsStart, connStart, tlsStart, wrote time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { t.DNSMs = time.Since(dnsStart).Milliseconds() },
+		ConnectStart:         func(string, string) { connStart = time.Now() },
+		ConnectDone:          func(string, string, error) { t.ConnectMs = time.Since(connStart).Milliseconds() },
+		TLSHandshakeStart:    func() { tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.TLSMs = time.Since(tlsStart).Milliseconds() },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { wrote = time.Now() },
+		GotFirstResponseByte: func() { t.FirstByteMs = time.Since(wrote).Milliseconds() },
+	}
+	req, err := newRequest(method, endpoint, "", nil)
+	if err != nil {
+		return nil, nil, t, err
+	}
+	started := time.Now()
+	resp, err := client.Do(req.WithContext(httptrace.WithClientTrace(context.Background(), trace)))
+	if err != nil {
+		return nil, nil, t, err
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	t.TotalMs = time.Since(started).Milliseconds()
+	return resp, body, t, nil
+}
+
+// medianTiming is the per-phase median of samples.
+func medianTiming(samples []fingerprintTiming) fingerprintTiming {
+	median := func(get func(fingerprintTiming) int64) int64 {
+		values := make([]int64, len(samples))
+		for i, s := range samples {
+			values[i] = get(s)
+		}
+		sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+		return values[len(values)/2]
+	}
+	return fingerprintTiming{
+		Samples:     len(samples),
+		DNSMs:       median(func(t fingerprintTiming) int64 { return t.DNSMs }),
+		ConnectMs:   median(func(t fingerprintTiming) int64 { return t.ConnectMs }),
+		TLSMs:       median(func(t fingerprintTiming) int64 { return t.TLSMs }),
+		FirstByteMs: median(func(t fingerprintTiming) int64 { return t.FirstByteMs }),
+		TotalMs:     median(func(t fingerprintTiming) int64 { return t.TotalMs }),
+	}
+}
+
+// runFingerprintCmd probes an endpoint without paying and prints its fingerprint.
+func runFingerprintCmd(args []string) {
+	fs := flag.NewFlagSet("fingerprint", flag.ExitOnError)
+	var (
+		insecure bool
+		timeout  time.Duration
+		method   string
+		samples  int
+		output   string
+	)
+	fs.BoolVar(&insecure, "k", false, "Skip TLS certificate verification")
+	fs.DurationVar(&timeout, "timeout", 10*time.Second, "Request timeout")
+	fs.StringVar(&method, "X", "GET", "HTTP method")
+	fs.IntVar(&samples, "samples", 3, "Unpaid requests to time; the timing is their median")
+	fs.StringVar(&output, "o", "", "Also write the fingerprint to this file")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli fingerprint [flags] <url>\n\n")
+		fmt.Fprintf(os.Stderr, "Probes an x402 endpoint without paying and prints a JSON fingerprint of its stack: the\n")
+		fmt.Fprintf(os.Stderr, "server banner, middleware hints from the response headers, the x402 version and how the\n")
+		fmt.Fprintf(os.Stderr, "requirements are sent, the payment schemes offered, the facilitator when the endpoint names\n")
+		fmt.Fprintf(os.Stderr, "it, and the median timing of --samples requests over new connections. The fingerprint has\n")
+		fmt.Fprintf(os.Stderr, "no query string or credentials, so it can be shared to map how x402 stacks behave.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	endpoint := fs.Arg(0)
+	if endpoint == "" || samples < 1 {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	method = strings.ToUpper(method)
+
+	transport := &http.Transport{DisableKeepAlives: true}
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	client := &http.Client{Transport: transport, Timeout: timeout}
+
+	fp := &fingerprint{Endpoint: shareableURL(endpoint), Method: method, Time: time.Now().UTC(), Client: "x402-cli " + version}
+	var (
+		resp    *http.Response
+		body    []byte
+		timings []fingerprintTiming
+	)
+	for i := 0; i < samples; i++ {
+		r, b, t, err := timedExchange(client, method, endpoint)
+		if err != nil {
+			code, _ := classifyError(err)
+			fp.Error = err.Error()
+			printJSON(fp)
+			os.Exit(code)
+		}
+		if resp == nil {
+			resp, body = r, b
+		}
+		timings = append(timings, t)
+	}
+
+	fp.StatusCode = resp.StatusCode
+	fp.Protocol = resp.Proto
+	if resp.TLS != nil {
+		fp.TLS = tls.VersionName(resp.TLS.Version)
+	}
+	fp.Server = banner{Server: resp.Header.Get("Server"), PoweredBy: resp.Header.Get("X-Powered-By"), Via: resp.Header.Get("Via")}
+	fp.Middleware = middlewareHints(resp.Header)
+	fp.ServerTime = parseServerTiming(resp.Header.Values("Server-Timing"))
+	var extras []map[string]any
+	if resp.StatusCode == http.StatusPaymentRequired {
+		fp.X402, extras = describeX402(resp, body)
+	}
+	fp.Facilitator = facilitatorIdentity(resp.Header, extras, fp.ServerTime)
+	fp.Timing = medianTiming(timings)
+
+	printJSON(fp)
+	if output != "" {
+		data, _ := json.MarshalIndent(fp, "", "  ")
+		if err := os.WriteFile(output, append(data, '\n'), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitError)
+		}
+	}
+	if fp.X402 == nil {
+		fmt.Fprintf(os.Stderr, "Note: %s did not answer with x402 payment requirements (status %d).\n", fp.Endpoint, fp.StatusCode)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// forecastResult projects what --calls requests to one endpoint would cost.
+type forecastResult struct {
+	Endpoint string `json:"endpoint"`
+	Method   string `json:"method"`
+	Calls    int    `json:"calls"`
+	Status   string `json:"status"` // the probe's: "payment_required", "free", "no_402", or "error"
+	Price    string `json:"price,omitempty"`
+	Network  string `json:"network,omitempty"`
+	Asset    string `json:"asset,omitempty"`
+	Amount   string `json:"amount,omitempty"` // per call, atomic units
+	// History is what the ledger knows about paying this endpoint.
+	History forecastHistory `json:"history"`
+	// SuccessRate is the share of payments the endpoint accepted (1 without history).
+	SuccessRate float64 `json:"successRate"`
+	// ExpectedAccepted is how many of the calls are expected to be accepted and settled.
+	ExpectedAccepted float64 `json:"expectedAccepted"`
+	// ExpectedCost is what the expected accepted calls pay; rejected payments do not settle.
+	ExpectedCost    string `json:"expectedCost,omitempty"`    // atomic units
+	ExpectedDisplay string `json:"expectedDisplay,omitempty"` // in token units
+	// MaxCost is what the calls pay if every one is accepted.
+	MaxCost    string `json:"maxCost,omitempty"` // atomic units
+	MaxDisplay string `json:"maxDisplay,omitempty"`
+	// DurationMs is the projected time of running the calls one after another.
+	DurationMs int64  `json:"durationMs,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// forecastHistory summarizes the ledger's resolved payments to one endpoint.
+type forecastHistory struct {
+	Payments     int   `json:"payments"`
+	Accepted     int   `json:"accepted"`
+	AvgLatencyMs int64 `json:"avgLatencyMs,omitempty"`
+}
+
+// endpointHistory summarizes the resolved payments to endpoint in the ledger. Pending
+// payments are skipped, since their outcome is not known yet.
+func endpointHistory(records []historyRecord, endpoint, method string) forecastHistory {
+	var h forecastHistory
+	var latency, timed int64
+	for _, r := range records {
+		if r.Endpoint != endpoint || !strings.EqualFold(r.Method, method) || r.Status == "pending" {
+			continue
+		}
+		h.Payments++
+		if r.Status == "accepted" {
+			h.Accepted++
+			if r.LatencyMs > 0 {
+				latency += r.LatencyMs
+				timed++
+			}
+		}
+	}
+	if timed > 0 {
+		h.AvgLatencyMs = latency / timed
+	}
+	return h
+}
+
+// forecast projects the cost of calls requests at the probed price from the endpoint's history.
+func forecast(f *forecastResult, price x402.PaymentRequirements) {
+	f.SuccessRate = 1
+	if f.History.Payments > 0 {
+		f.SuccessRate = float64(f.History.Accepted) / float64(f.History.Payments)
+	}
+	f.ExpectedAccepted = float64(f.Calls) * f.SuccessRate
+	if f.History.AvgLatencyMs > 0 {
+		f.DurationMs = int64(f.Calls) * f.History.AvgLatencyMs
+	}
+	amount, ok := new(big.Int).SetString(price.Amount, 10)
+	if !ok {
+		return
+	}
+	max := new(big.Int).Mul(amount, big.NewInt(int64(f.Calls)))
+	expected := new(big.Rat).SetInt(max)
+	if f.History.Payments > 0 {
+		expected.Mul(expected, big.NewRat(int64(f.History.Accepted), int64(f.History.Payments)))
+	}
+	f.MaxCost = max.String()
+	f.ExpectedCost = expected.FloatString(0)
+	f.MaxDisplay = describeAmount(x402.PaymentRequirements{Network: price.Network, Asset: price.Asset, Amount: f.MaxCost})
+	f.ExpectedDisplay = describeAmount(x402.PaymentRequirements{Network: price.Network, Asset: price.Asset, Amount: f.ExpectedCost})
+}
+
+// runForecastCmd probes an endpoint's price and projects the cost of calling it --calls times.
+func runForecastCmd(args []string) {
+	fs := flag.NewFlagSet("forecast", flag.ExitOnError)
+	var (
+		endpoint string
+		calls    int
+		method   string
+		insecure bool
+		timeout  time.Duration
+		mainnet  bool
+		jsonOut  bool
+	)
+	fs.StringVar(&endpoint, "endpoint", "", "URL of the paid endpoint (or give it as the argument)")
+	fs.IntVar(&calls, "calls", 1, "Number of calls to forecast")
+	fs.StringVar(&method, "X", "GET", "HTTP method")
+	fs.BoolVar(&insecure, "k", false, "Skip TLS certificate verification")
+	fs.DurationVar(&timeout, "timeout", 30*time.Second, "Probe timeout")
+	fs.BoolVar(&mainnet, "mainnet", mainnetAllowedByEnv(), "Price the mainnet option when the endpoint also offers a testnet (default: $X402_ALLOW_MAINNET)")
+	fs.BoolVar(&jsonOut, "json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli forecast [--calls N] [-X method] [--json] --endpoint <url>\n\n")
+		fmt.Fprintf(os.Stderr, "Probes the endpoint's price without paying and projects the cost of --calls requests,\n")
+		fmt.Fprintf(os.Stderr, "using the share of past payments to it that were accepted (from the history ledger).\n")
+		fmt.Fprintf(os.Stderr, "Rejected payments do not settle, so the expected cost counts only accepted calls.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if endpoint == "" {
+		endpoint = fs.Arg(0)
+	}
+	if endpoint == "" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	if calls < 1 {
+		fmt.Fprintln(os.Stderr, "Error: --calls must be at least 1")
+		os.Exit(ExitError)
+	}
+	method = strings.ToUpper(method)
+
+	transport := &http.Transport{}
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	probe := payEndpoint(transport, nil, dashboardEndpoint{Name: endpoint, URL: endpoint, Method: method},
+		batchOptions{timeout: timeout, dryRun: true, mainnet: mainnet})
+	f := &forecastResult{Endpoint: endpoint, Method: method, Calls: calls, Status: probe.Status, Price: probe.Price,
+		Network: probe.Network, Asset: probe.Asset, Amount: probe.Amount, Error: probe.Error}
+
+	records, err := loadHistory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot read payment history: %v\n", err)
+	}
+	f.History = endpointHistory(records, endpoint, method)
+	if probe.Status == "payment_required" {
+		forecast(f, x402.PaymentRequirements{Network: probe.chainID, Asset: probe.Asset, Amount: probe.Amount})
+	}
+
+	code := ExitSuccess
+	switch probe.Status {
+	case "payment_required":
+	case "free":
+		code = ExitFreeRoute
+	default:
+		code = ExitError
+	}
+	if jsonOut {
+		printJSON(f)
+		os.Exit(code)
+	}
+
+	fmt.Printf("Endpoint: %s %s\n", method, endpoint)
+	switch probe.Status {
+	case "payment_required":
+	case "free":
+		fmt.Println("The endpoint is free: the calls cost nothing.")
+		os.Exit(code)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: cannot price the endpoint: %s\n", dashIfEmpty(f.Error))
+		os.Exit(code)
+	}
+	fmt.Printf("Price:    %s per call on %s\n", f.Price, f.Network)
+	if f.History.Payments > 0 {
+		fmt.Printf("History:  %d of %d payments accepted (%.1f%%)\n", f.History.Accepted, f.History.Payments, 100*f.SuccessRate)
+	} else {
+		fmt.Println("History:  no payments to this endpoint yet; assuming every call is accepted")
+	}
+	fmt.Printf("\nForecast for %d calls:\n", calls)
+	fmt.Printf("  Expected accepted: %.0f\n", f.ExpectedAccepted)
+	fmt.Printf("  Expected cost:     %s\n", f.ExpectedDisplay)
+	fmt.Printf("  Maximum cost:      %s (if every call is accepted)\n", f.MaxDisplay)
+	if f.DurationMs > 0 {
+		fmt.Printf("  Duration:          ~%s one after another (average %dms per paid call)\n",
+			(time.Duration(f.DurationMs) * time.Millisecond).Round(time.Second), f.History.AvgLatencyMs)
+	}
+}
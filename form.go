@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// formContentType is set for --data-urlencode bodies unless -H gives a Content-Type.
+const formContentType = "application/x-www-form-urlencoded"
+
+// encodeFormData builds a form body from --data-urlencode values with curl's semantics:
+//
+//	content       encode content
+//	=content      encode content (which may contain '=' or '@')
+//	name=content  send name= followed by encoded content
+//	@file         encode the contents of file
+//	name@file     send name= followed by the encoded contents of file
+//
+// Parts are joined with '&'. Names are sent as given.
+func encodeFormData(values []string) (string, error) {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		name, content := "", v
+		eq := strings.Index(v, "=")
+		at := strings.Index(v, "@")
+		switch {
+		case eq >= 0 && (at < 0 || eq < at):
+			name, content = v[:eq], v[eq+1:]
+		case at >= 0:
+			raw, err := os.ReadFile(v[at+1:])
+			if err != nil {
+				return "", fmt.Errorf("--data-urlencode: %w", err)
+			}
+			name, content = v[:at], string(raw)
+		}
+		if name != "" {
+			parts = append(parts, name+"="+formEscape(content))
+		} else {
+			parts = append(parts, formEscape(content))
+		}
+	}
+	return strings.Join(parts, "&"), nil
+}
+
+// formEscape percent-encodes everything except RFC 3986 unreserved characters, as curl does.
+func formEscape(s string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&15])
+	}
+	return b.String()
+}
+
+// addQueryParams appends --param key=value pairs to rawURL's query string, percent-encoding
+// both keys and values. An existing query is kept; a fragment stays at the end.
+func addQueryParams(rawURL string, params []string) (string, error) {
+	parts := make([]string, 0, len(params))
+	for _, p := range params {
+		key, value, ok := strings.Cut(p, "=")
+		if !ok || key == "" {
+			return "", fmt.Errorf("--param %q: expected key=value", p)
+		}
+		parts = append(parts, formEscape(key)+"="+formEscape(value))
+	}
+	base, fragment, hasFragment := strings.Cut(rawURL, "#")
+	sep := "?"
+	if strings.Contains(base, "?") {
+		sep = "&"
+		if strings.HasSuffix(base, "?") || strings.HasSuffix(base, "&") {
+			sep = ""
+		}
+	}
+	out := base + sep + strings.Join(parts, "&")
+	if hasFragment {
+		out += "#" + fragment
+	}
+	return out, nil
+}
+
+// hasHeader reports whether a -H value sets the named header.
+func hasHeader(headers headerFlags, name string) bool {
+	for _, h := range headers {
+		if k, _, ok := strings.Cut(h, ":"); ok && strings.EqualFold(strings.TrimSpace(k), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// withNegotiation adds the Accept and Accept-Language headers of --accept and
+// --accept-language to headers, unless -H already sets them.
+func withNegotiation(headers headerFlags, accept, language string) headerFlags {
+	for _, h := range [][2]string{{"Accept", accept}, {"Accept-Language", language}} {
+		if h[1] != "" && !hasHeader(headers, h[0]) {
+			headers = append(headers, h[0]+": "+h[1])
+		}
+	}
+	return headers
+}
+
+// headerValue returns the value a -H flag gives the named header, or "".
+func headerValue(headers headerFlags, name string) string {
+	for _, h := range headers {
+		if k, v, ok := strings.Cut(h, ":"); ok && strings.EqualFold(strings.TrimSpace(k), name) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// payHeaderPolicy is which -H headers --pay-headers re-sends with the paid request (Step 2).
+type payHeaderPolicy struct {
+	mode  string // "all", "none", "only", or "except"
+	names []string
+}
+
+// parsePayHeaders parses --pay-headers: all (the default), none, only=Name,..., or except=Name,....
+func parsePayHeaders(spec string) (payHeaderPolicy, error) {
+	mode, list, hasList := strings.Cut(strings.TrimSpace(spec), "=")
+	p := payHeaderPolicy{mode: strings.ToLower(mode)}
+	switch {
+	case p.mode == "" && !hasList:
+		p.mode = "all"
+	case (p.mode == "all" || p.mode == "none") && !hasList:
+	case (p.mode == "only" || p.mode == "except") && hasList:
+		for _, n := range strings.Split(list, ",") {
+			if n = strings.TrimSpace(n); n != "" {
+				p.names = append(p.names, n)
+			}
+		}
+		if len(p.names) == 0 {
+			return p, fmt.Errorf("--pay-headers %s= needs at least one header name", p.mode)
+		}
+	default:
+		return p, fmt.Errorf("invalid --pay-headers %q: want all, none, only=Name,..., or except=Name,...", spec)
+	}
+	return p, nil
+}
+
+// filter splits headers into those sent with the paid request and the names of those that
+// are not. The request ID header always stays, so both steps can be correlated.
+func (p payHeaderPolicy) filter(headers headerFlags) (kept headerFlags, dropped []string) {
+	for _, h := range headers {
+		k, _, _ := strings.Cut(h, ":")
+		k = strings.TrimSpace(k)
+		listed := slices.ContainsFunc(p.names, func(n string) bool { return strings.EqualFold(n, k) })
+		keep := strings.EqualFold(k, traceHeader)
+		switch p.mode {
+		case "all":
+			keep = true
+		case "only":
+			keep = keep || listed
+		case "except":
+			keep = keep || !listed
+		}
+		if keep {
+			kept = append(kept, h)
+		} else {
+			dropped = append(dropped, k)
+		}
+	}
+	return kept, dropped
+}
+
+// validateJSON checks that body is one JSON value. A syntax error names its line and
+// column and points at it under the offending line.
+func validateJSON(body string) error {
+	if strings.TrimSpace(body) == "" {
+		return errors.New("--validate-json: the request body is empty")
+	}
+	var v any
+	err := json.Unmarshal([]byte(body), &v)
+	var syntax *json.SyntaxError
+	if !errors.As(err, &syntax) {
+		return err
+	}
+	// Offset is just past the offending byte; at the end of the input the error is there.
+	offset := int(syntax.Offset)
+	if offset > 0 && offset <= len(body) && !strings.Contains(syntax.Error(), "end of JSON input") {
+		offset--
+	}
+	start := strings.LastIndex(body[:offset], "\n") + 1
+	end := strings.IndexByte(body[offset:], '\n')
+	if end < 0 {
+		end = len(body)
+	} else {
+		end += offset
+	}
+	line := strings.Count(body[:offset], "\n") + 1
+	column := offset - start + 1
+	return fmt.Errorf("--validate-json: invalid JSON body at line %d, column %d: %v\n  %s\n  %s^",
+		line, column, syntax, body[start:end], strings.Repeat(" ", column-1))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// freeTierPrefixes name the headers servers report an endpoint's free quota with, as
+// <prefix>-Remaining, <prefix>-Limit, and <prefix>-Reset, in order of precedence.
+var freeTierPrefixes = []string{"X-Free-Calls", "X-Free-Tier", "X-Free-Quota", "Free-Quota", "X-Free"}
+
+// freeTier is what a server says is left of an endpoint's free calls.
+type freeTier struct {
+	// Step is "probe" or "payment": the response the quota was read from.
+	Step string `json:"step"`
+	// Header is the header Remaining was read from.
+	Header    string `json:"header"`
+	Remaining int64  `json:"remaining"`
+	Limit     int64  `json:"limit,omitempty"`
+	// Reset is when the quota resets, as sent (seconds or a date).
+	Reset string `json:"reset,omitempty"`
+	// Price is what a call costs once the free calls are used up, when known: the first
+	// payment option of the 402, or the endpoint's last recorded price.
+	Price string `json:"price,omitempty"`
+}
+
+// parseFreeTier reads the free quota from a response's headers, or returns nil. The
+// free-quota headers count on any response; generic rate-limit headers (RateLimit-*,
+// X-RateLimit-*, or the structured RateLimit header) only on one that was served free,
+// since on a paid route they limit the rate, not the free calls.
+func parseFreeTier(step string, h http.Header, servedFree bool) *freeTier {
+	prefixes := freeTierPrefixes
+	if servedFree {
+		prefixes = append(prefixes[:len(prefixes):len(prefixes)], "RateLimit", "X-RateLimit")
+	}
+	for _, p := range prefixes {
+		remaining, err := strconv.ParseInt(strings.TrimSpace(h.Get(p+"-Remaining")), 10, 64)
+		if err != nil || remaining < 0 {
+			continue
+		}
+		f := &freeTier{Step: step, Header: p + "-Remaining", Remaining: remaining, Reset: strings.TrimSpace(h.Get(p + "-Reset"))}
+		f.Limit, _ = strconv.ParseInt(strings.TrimSpace(h.Get(p+"-Limit")), 10, 64)
+		return f
+	}
+	if !servedFree {
+		return nil
+	}
+	// RateLimit: limit=100, remaining=50, reset=30
+	fields := map[string]string{}
+	for _, part := range strings.Split(h.Get("RateLimit"), ",") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(part), "="); ok {
+			fields[strings.ToLower(strings.TrimSpace(k))] = strings.Trim(strings.TrimSpace(v), `"`)
+		}
+	}
+	remaining, err := strconv.ParseInt(fields["remaining"], 10, 64)
+	if err != nil || remaining < 0 {
+		return nil
+	}
+	f := &freeTier{Step: step, Header: "RateLimit", Remaining: remaining, Reset: fields["reset"]}
+	f.Limit, _ = strconv.ParseInt(fields["limit"], 10, 64)
+	return f
+}
+
+// describe renders the quota for human output, e.g. "7 of 10 free calls remaining, then
+// 0.001 USDC each (resets in 3600s)".
+func (f *freeTier) describe() string {
+	var b strings.Builder
+	switch {
+	case f.Remaining == 0:
+		b.WriteString("no free calls remaining")
+	case f.Limit > 0:
+		fmt.Fprintf(&b, "%d of %d free calls remaining", f.Remaining, f.Limit)
+	case f.Remaining == 1:
+		b.WriteString("1 free call remaining")
+	default:
+		fmt.Fprintf(&b, "%d free calls remaining", f.Remaining)
+	}
+	if f.Price != "" {
+		if f.Remaining == 0 {
+			fmt.Fprintf(&b, "; each call costs %s", f.Price)
+		} else {
+			fmt.Fprintf(&b, ", then %s each", f.Price)
+		}
+	}
+	if f.Reset != "" {
+		if _, err := strconv.ParseInt(f.Reset, 10, 64); err == nil {
+			fmt.Fprintf(&b, " (resets in %ss)", f.Reset)
+		} else {
+			fmt.Fprintf(&b, " (resets %s)", f.Reset)
+		}
+	}
+	return b.String()
+}
+
+// lastKnownPrice is the most recently recorded price of endpoint's first payment option, or
+// "" when it was never probed as paid.
+func lastKnownPrice(endpoint string) string {
+	path, err := stateFile(pricesFile)
+	if err != nil {
+		return ""
+	}
+	unlock, err := lockState(pricesFile)
+	if err != nil {
+		return ""
+	}
+	observed, err := readPrices(path)
+	unlock()
+	if err != nil {
+		return ""
+	}
+	series := priceHistory(observed, endpoint)
+	if len(series) == 0 || len(series[0].Changes) == 0 {
+		return ""
+	}
+	s := series[0]
+	return tokenAmount(x402.PaymentRequirements{Network: s.Network, Asset: s.Asset, Amount: s.Changes[len(s.Changes)-1].Amount})
+}
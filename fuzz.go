@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+	x402evm "github.com/coinbase/x402/go/mechanisms/evm"
+	evmsigners "github.com/coinbase/x402/go/signers/evm"
+)
+
+// fuzzCase is one malformed payment and how the server answered it.
+type fuzzCase struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	StatusCode  int    `json:"statusCode,omitempty"`
+	Rejected    bool   `json:"rejected"`
+	Reason      string `json:"reason,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// fuzzResult is the JSON output for `x402-cli fuzz`.
+type fuzzResult struct {
+	Endpoint    string                    `json:"endpoint"`
+	Signer      string                    `json:"signer,omitempty"`
+	Requirement *x402.PaymentRequirements `json:"requirement,omitempty"`
+	OK          bool                      `json:"ok"`
+	Cases       []fuzzCase                `json:"cases"`
+	Error       string                    `json:"error,omitempty"`
+}
+
+// fuzzPayment is a malformed PAYMENT-SIGNATURE header to send.
+type fuzzPayment struct {
+	name, description, header string
+}
+
+// runFuzzCmd sends deliberately malformed payments and checks that the server rejects
+// each one. A 2xx means the server accepted an invalid payment; a 5xx means it failed
+// to handle one. Both fail the check.
+func runFuzzCmd(args []string) {
+	fs := flag.NewFlagSet("fuzz", flag.ExitOnError)
+	var (
+		insecure bool
+		timeout  time.Duration
+		method   string
+		data     string
+		headers  headerFlags
+		wallet   bool
+		jsonOut  bool
+	)
+	fs.BoolVar(&insecure, "k", false, "Skip TLS certificate verification")
+	fs.DurationVar(&timeout, "timeout", 30*time.Second, "Request timeout")
+	fs.StringVar(&method, "X", "GET", "HTTP method")
+	fs.StringVar(&data, "d", "", "Request body")
+	fs.Var(&headers, "H", "Custom header 'Key: Value' (repeatable)")
+	fs.BoolVar(&wallet, "wallet", false, "Sign with the EVM_PRIVATE_KEY wallet instead of the public fixture key")
+	fs.BoolVar(&jsonOut, "json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli fuzz [flags] <url>\n\n")
+		fmt.Fprintf(os.Stderr, "Sends malformed payments (bad base64, tampered signature, wrong network, expired\n")
+		fmt.Fprintf(os.Stderr, "window) and checks that the server rejects each one. None of them can settle.\n")
+		fmt.Fprintf(os.Stderr, "Payments are signed with the public Hardhat/Anvil dev key unless --wallet is given;\n")
+		fmt.Fprintf(os.Stderr, "servers that check the balance first may then reject everything as insufficient funds.\n")
+		fmt.Fprintf(os.Stderr, "The wrong-network payment is always signed with the dev key, for a testnet.\n")
+		fmt.Fprintf(os.Stderr, "Exits 1 when any malformed payment is accepted or causes a server error.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	endpoint := fs.Arg(0)
+	if endpoint == "" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	method = strings.ToUpper(method)
+	if data != "" && method == "GET" {
+		method = "POST"
+	}
+
+	result := &fuzzResult{Endpoint: endpoint}
+	fail := func(err error, code int) {
+		if jsonOut {
+			result.Error = err.Error()
+			printJSON(result)
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		os.Exit(code)
+	}
+
+	transport := &http.Transport{}
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	client := &http.Client{Transport: transport, Timeout: timeout}
+	send := func(paymentHeader string) (*http.Response, []byte, error) {
+		req, err := newRequest(method, endpoint, data, headers)
+		if err != nil {
+			return nil, nil, err
+		}
+		if paymentHeader != "" {
+			req.Header.Set("PAYMENT-SIGNATURE", paymentHeader)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return resp, body, err
+	}
+
+	resp, body, err := send("")
+	if err != nil {
+		code, _ := classifyError(err)
+		fail(err, code)
+	}
+	if resp.StatusCode != http.StatusPaymentRequired {
+		fail(fmt.Errorf("endpoint returned %d, not 402 Payment Required", resp.StatusCode), ExitError)
+	}
+	required, err := decodeRequirements(resp, body)
+	if err != nil {
+		fail(err, ExitError)
+	}
+	if required.X402Version != 2 {
+		fail(fmt.Errorf("fuzzing requires x402 v2 requirements (got version %d)", required.X402Version), ExitUnsupported)
+	}
+	selected, ok := fuzzTarget(required.Accepts)
+	if !ok {
+		fail(fmt.Errorf("no %s payment option on an eip155 network to fuzz", supportedScheme), ExitUnsupported)
+	}
+	result.Requirement = &selected
+
+	key := vectorFixtureKey
+	if wallet {
+		if key = privateKeyFromEnv(); key == "" {
+			fail(fmt.Errorf("%s is required with --wallet", privateKeyVar()), ExitError)
+		}
+	}
+	signer, err := evmsigners.NewClientSignerFromPrivateKey(key)
+	if err != nil {
+		fail(err, ExitError)
+	}
+	result.Signer = signer.Address()
+
+	payments, err := fuzzPayments(signer, selected, required.Resource, time.Now())
+	if err != nil {
+		fail(err, ExitError)
+	}
+
+	if !jsonOut {
+		fmt.Printf("Fuzzing %s %s against %s on %s\n\n", method, endpoint, describeAmount(selected), networkName(selected.Network))
+	}
+	result.OK = true
+	for _, p := range payments {
+		c := fuzzCase{Name: p.name, Description: p.description}
+		resp, body, err := send(p.header)
+		switch {
+		case err != nil:
+			c.Error = err.Error()
+		case resp.StatusCode >= 500:
+			c.StatusCode = resp.StatusCode
+			c.Error = fmt.Sprintf("server error %d: %s", resp.StatusCode, truncate(strings.TrimSpace(string(body)), 200))
+		default:
+			c.StatusCode = resp.StatusCode
+			c.Rejected = resp.StatusCode < 200 || resp.StatusCode >= 300
+			c.Reason = rejectionReason(resp, body)
+		}
+		result.OK = result.OK && c.Rejected
+		result.Cases = append(result.Cases, c)
+
+		if !jsonOut {
+			mark, detail := "ok  ", fmt.Sprintf("rejected with %d", c.StatusCode)
+			switch {
+			case c.Error != "":
+				mark, detail = "FAIL", c.Error
+			case !c.Rejected:
+				mark, detail = "FAIL", fmt.Sprintf("ACCEPTED with %d", c.StatusCode)
+			case c.Reason != "":
+				detail += ": " + c.Reason
+			}
+			fmt.Printf("  [%s] %-20s %s\n", mark, c.Name, detail)
+		}
+	}
+
+	if jsonOut {
+		printJSON(result)
+	} else if result.OK {
+		fmt.Println("\nThe server rejected every malformed payment.")
+	} else {
+		fmt.Println("\nThe server did not reject every malformed payment; see the failures above.")
+	}
+	if !result.OK {
+		os.Exit(ExitError)
+	}
+}
+
+// fuzzTarget picks the first accepts entry this build can sign for.
+func fuzzTarget(accepts []x402.PaymentRequirements) (x402.PaymentRequirements, bool) {
+	for _, a := range accepts {
+		if a.Scheme == supportedScheme && strings.HasPrefix(a.Network, "eip155:") {
+			return a, true
+		}
+	}
+	return x402.PaymentRequirements{}, false
+}
+
+// fuzzPayments builds the malformed payments for the selected requirement. Each signed
+// case starts from a correct authorization and breaks exactly one property of it.
+func fuzzPayments(signer x402evm.ClientEvmSigner, selected x402.PaymentRequirements, resource *x402.ResourceInfo, now time.Time) ([]fuzzPayment, error) {
+	encode := func(p x402.PaymentPayload) string {
+		raw, _ := json.Marshal(p)
+		return base64.StdEncoding.EncodeToString(raw)
+	}
+	authorize := func(validAfter, validBefore time.Time) (x402evm.ExactEIP3009Authorization, error) {
+		nonce, err := x402evm.CreateNonce()
+		return x402evm.ExactEIP3009Authorization{
+			From:        signer.Address(),
+			To:          selected.PayTo,
+			Value:       selected.Amount,
+			ValidAfter:  fmt.Sprint(validAfter.Unix()),
+			ValidBefore: fmt.Sprint(validBefore.Unix()),
+			Nonce:       nonce,
+		}, err
+	}
+	window := time.Duration(selected.MaxTimeoutSeconds) * time.Second
+	if window <= 0 {
+		window = 5 * time.Minute
+	}
+
+	payments := []fuzzPayment{{
+		name:        "bad-base64",
+		description: "PAYMENT-SIGNATURE is not valid base64",
+		header:      "%%not-base64%%",
+	}}
+
+	auth, err := authorize(now.Add(-10*time.Minute), now.Add(window))
+	if err != nil {
+		return nil, err
+	}
+	tampered, err := signEIP3009(signer, selected, resource, auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign payment: %w", err)
+	}
+	sig, _ := x402evm.HexToBytes(tampered.Payload["signature"].(string))
+	sig[10] ^= 0xff
+	tampered.Payload["signature"] = x402evm.BytesToHex(sig)
+	payments = append(payments, fuzzPayment{
+		name:        "tampered-signature",
+		description: "signature bytes altered after signing",
+		header:      encode(tampered),
+	})
+
+	// Sign for a testnet the server did not offer, with that network's USDC. This
+	// authorization is otherwise valid, so it is signed with the public fixture key even
+	// under --wallet and never for a mainnet: settled anywhere, it moves no real funds.
+	fixture, err := evmsigners.NewClientSignerFromPrivateKey(vectorFixtureKey)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(networks))
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		info := networks[name]
+		if info.ChainID == selected.Network || !info.Testnet {
+			continue
+		}
+		other := selected
+		other.Network = info.ChainID
+		other.Asset = info.USDCContract
+		other.Extra = nil
+		if auth, err = authorize(now.Add(-10*time.Minute), now.Add(window)); err != nil {
+			return nil, err
+		}
+		auth.From = fixture.Address()
+		wrong, err := signEIP3009(fixture, other, resource, auth)
+		if err != nil {
+			continue
+		}
+		payments = append(payments, fuzzPayment{
+			name:        "wrong-network",
+			description: "signed for " + info.Name + " instead of " + networkName(selected.Network),
+			header:      encode(wrong),
+		})
+		break
+	}
+
+	if auth, err = authorize(now.Add(-2*time.Hour), now.Add(-time.Hour)); err != nil {
+		return nil, err
+	}
+	expired, err := signEIP3009(signer, selected, resource, auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign payment: %w", err)
+	}
+	payments = append(payments, fuzzPayment{
+		name:        "expired",
+		description: "authorization window ended an hour ago",
+		header:      encode(expired),
+	})
+	return payments, nil
+}
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// historyRecord is one paid request in the local ledger (history.jsonl, one JSON object per
+// line, or history.db; see X402_HISTORY_BACKEND).
+type historyRecord struct {
+	// ID identifies the payment for `x402-cli resolve`.
+	ID          string    `json:"id,omitempty"`
+	Time        time.Time `json:"time"`
+	Endpoint    string    `json:"endpoint"`
+	Method      string    `json:"method"`
+	BodySHA256  string    `json:"bodySha256"`
+	Status      string    `json:"status"`
+	StatusCode  int       `json:"statusCode"`
+	Network     string    `json:"network,omitempty"`
+	Asset       string    `json:"asset,omitempty"`
+	Amount      string    `json:"amount,omitempty"`
+	PayTo       string    `json:"payTo,omitempty"`
+	Payer       string    `json:"payer,omitempty"`
+	Transaction string    `json:"transaction,omitempty"`
+	Nonce       string    `json:"nonce,omitempty"`
+	ValidBefore int64     `json:"validBefore,omitempty"`
+	Profile     string    `json:"profile,omitempty"`
+	TraceID     string    `json:"traceId,omitempty"`
+	// RequestID is the invocation that made the payment (the X-Request-ID it sent).
+	RequestID string `json:"requestId,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// LatencyMs is how long the paid request took, from sending the payment to the response.
+	LatencyMs int64 `json:"latencyMs,omitempty"`
+	// Proof is the wallet's signature over the request, with --sign-requests.
+	Proof *requestProof `json:"proof,omitempty"`
+	// BytesReceived is the size of the paid response body; absent when no body was received.
+	BytesReceived *int64 `json:"bytesReceived,omitempty"`
+	// Suspect marks an accepted payment whose body looks worthless: "empty" or "error".
+	Suspect string `json:"suspect,omitempty"`
+	// Tags are the --tag key=value metadata of the invocation that paid.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// historyEntry is a ledger record as `history --json` prints it, with its network and asset
+// identifiers as names.
+type historyEntry struct {
+	historyRecord
+	Chain string `json:"chain,omitempty"`
+	Token string `json:"token,omitempty"`
+}
+
+func newHistoryEntry(r historyRecord) historyEntry {
+	e := historyEntry{historyRecord: r}
+	if r.Network != "" {
+		e.Chain = networkName(r.Network)
+		e.Token = assetSymbol(x402.PaymentRequirements{Network: r.Network, Asset: r.Asset})
+	}
+	return e
+}
+
+// measureBody records the size of the paid response body and whether an accepted
+// payment's body looks empty or like an error, for `history report`.
+func (rec *historyRecord) measureBody(body []byte) {
+	n := int64(len(body))
+	rec.BytesReceived = &n
+	if rec.StatusCode == http.StatusOK {
+		rec.Suspect = suspectBody(body)
+	}
+}
+
+// suspectBody classifies a 200 body that was paid for: "empty" when it has no content
+// (nothing, or an empty JSON value), "error" when it is a JSON object reporting an error,
+// and "" otherwise.
+func suspectBody(body []byte) string {
+	switch trimmed := strings.TrimSpace(string(body)); trimmed {
+	case "", "{}", "[]", "null", `""`:
+		return "empty"
+	}
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(body, &obj) != nil {
+		return ""
+	}
+	for _, k := range []string{"error", "errors"} {
+		if v, ok := obj[k]; ok {
+			switch strings.TrimSpace(string(v)) {
+			case "null", "false", `""`, "[]", "{}":
+			default:
+				return "error"
+			}
+		}
+	}
+	return ""
+}
+
+// pendingPayment is a payment whose outcome is not final until the run exits.
+type pendingPayment struct {
+	record historyRecord
+	result *jsonResult
+}
+
+// pendingHistory is the current run's payment; exit appends it with the final status.
+var pendingHistory *pendingPayment
+
+// stateFile is the path of a file in the x402-cli config directory.
+func stateFile(name string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "x402-cli", name), nil
+}
+
+// bodyHash identifies a request body without storing it.
+func bodyHash(data string) string {
+	if data == stdinData && stdinSpool != nil {
+		return stdinSpool.hash
+	}
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// newHistoryRecord describes a Step 2 request from the payment header that was sent
+// and the PAYMENT-RESPONSE that came back; resp is nil when no response arrived.
+func newHistoryRecord(endpoint, method, data, paymentHeader string, resp *http.Response) historyRecord {
+	rec := historyRecord{
+		ID:         newTraceID()[:12],
+		Time:       time.Now().UTC(),
+		Endpoint:   endpoint,
+		Method:     method,
+		BodySHA256: bodyHash(data),
+		Profile:    profile,
+		RequestID:  requestID,
+		Tags:       copyTags(recordTags),
+	}
+	var payload x402.PaymentPayload
+	if raw, err := base64.StdEncoding.DecodeString(paymentHeader); err == nil && json.Unmarshal(raw, &payload) == nil {
+		rec.Network = payload.Accepted.Network
+		rec.Asset = payload.Accepted.Asset
+		rec.Amount = payload.Accepted.Amount
+		rec.PayTo = checksumAddress(payload.Accepted.PayTo)
+		rec.Profile = routedProfile(payload.Accepted.Network)
+		if auth, ok := payload.Payload["authorization"].(map[string]interface{}); ok {
+			rec.Payer, _ = auth["from"].(string)
+			rec.Nonce, _ = auth["nonce"].(string)
+			if before, ok := auth["validBefore"].(string); ok {
+				rec.ValidBefore, _ = strconv.ParseInt(before, 10, 64)
+			}
+		}
+	}
+	if signRequests && paymentHeader != "" {
+		if proof, err := newRequestProof(rec, paymentHeader); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot sign the request proof: %v\n", err)
+		} else {
+			rec.Proof = proof
+		}
+	}
+	if resp == nil {
+		return rec
+	}
+	rec.StatusCode = resp.StatusCode
+	if raw, err := base64.StdEncoding.DecodeString(resp.Header.Get("PAYMENT-RESPONSE")); err == nil {
+		rec.Transaction, _, _ = settlementTx(raw)
+	}
+	return rec
+}
+
+// lockState takes an exclusive lock on <name>.lock next to a state file, so that
+// simultaneous CLI invocations never interleave or lose ledger writes. Call the
+// returned function to release it.
+func lockState(name string) (unlock func(), err error) {
+	path, err := stateFile(name + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// appendHistory adds a record to the ledger.
+func appendHistory(rec historyRecord) error {
+	backend, path, err := historyLedger()
+	if err != nil {
+		return err
+	}
+	unlock, err := lockState(historyLock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	line, err := encodeHistoryLine(rec, stateEncryption() || ledgerSealed(backend, path))
+	if err != nil {
+		return err
+	}
+	if backend == historySQLite {
+		return appendHistoryDB(path, line)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadHistory reads every record in the ledger, oldest first; a missing ledger is empty.
+func loadHistory() ([]historyRecord, error) {
+	backend, path, err := historyLedger()
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := lockState(historyLock)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return readLedger(backend, path)
+}
+
+// saveHistory replaces the ledger with records, atomically.
+func saveHistory(records []historyRecord) error {
+	return updateHistory(func([]historyRecord) []historyRecord { return records })
+}
+
+// updateHistory rewrites the ledger with update applied to its current records, holding
+// the ledger lock throughout so that payments appended meanwhile are not lost.
+func updateHistory(update func([]historyRecord) []historyRecord) error {
+	backend, path, err := historyLedger()
+	if err != nil {
+		return err
+	}
+	unlock, err := lockState(historyLock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	records, err := readLedger(backend, path)
+	if err != nil {
+		return err
+	}
+	seal := stateEncryption() || ledgerSealed(backend, path)
+	var lines [][]byte
+	for _, rec := range update(records) {
+		line, err := encodeHistoryLine(rec, seal)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line)
+	}
+	return rewriteLedger(backend, path, lines)
+}
+
+// encodeHistoryLine renders rec as one ledger line, encrypted when seal is set: under
+// X402_ENCRYPT_STATE, or when the ledger already holds encrypted lines.
+func encodeHistoryLine(rec historyRecord, seal bool) ([]byte, error) {
+	line, _ := json.Marshal(rec)
+	if !seal {
+		return line, nil
+	}
+	return sealLine(line)
+}
+
+// readHistory parses the ledger at path; callers hold the ledger lock.
+func readHistory(path string) ([]historyRecord, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []historyRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line, err := openLine(scanner.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		var rec historyRecord
+		if json.Unmarshal(line, &rec) == nil {
+			records = append(records, rec)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// flushHistory appends the current run's payment, if any, with its final status.
+func flushHistory() {
+	if pendingHistory == nil {
+		return
+	}
+	rec := pendingHistory.record
+	rec.Status = pendingHistory.result.Status
+	rec.Error = pendingHistory.result.Error
+	pendingHistory = nil
+	statsd.observe(rec)
+	if err := appendHistory(rec); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record payment history: %v\n", err)
+	}
+	if spec := os.Getenv("X402_HISTORY_RETENTION"); spec != "" {
+		if err := applyRetention(spec, time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: X402_HISTORY_RETENTION: %v\n", err)
+		}
+	}
+}
+
+// parseAge parses a record age such as "90d", "2w", or any time.ParseDuration value.
+func parseAge(spec string) (time.Duration, error) {
+	spec = strings.TrimSpace(spec)
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(spec, suffix); ok {
+			if v, err := strconv.ParseFloat(n, 64); err == nil && v > 0 {
+				return time.Duration(v * float64(unit)), nil
+			}
+		}
+	}
+	d, err := time.ParseDuration(spec)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid age %q: want e.g. 90d, 2w, or 720h", spec)
+	}
+	return d, nil
+}
+
+// expiredRecords counts the records purging at cutoff would remove. Pending payments
+// are kept whatever their age, since `resolve` still needs them.
+func expiredRecords(records []historyRecord, cutoff time.Time) int {
+	n := 0
+	for _, r := range records {
+		if r.Status != "pending" && r.Time.Before(cutoff) {
+			n++
+		}
+	}
+	return n
+}
+
+// purgeHistory removes the records older than cutoff and returns how many it removed.
+func purgeHistory(cutoff time.Time) (int, error) {
+	removed := 0
+	err := updateHistory(func(records []historyRecord) []historyRecord {
+		kept := records[:0]
+		for _, r := range records {
+			if r.Status != "pending" && r.Time.Before(cutoff) {
+				removed++
+				continue
+			}
+			kept = append(kept, r)
+		}
+		return kept
+	})
+	return removed, err
+}
+
+// applyRetention purges records older than the X402_HISTORY_RETENTION age, rewriting
+// the ledger only when something has expired.
+func applyRetention(spec string, now time.Time) error {
+	age, err := parseAge(spec)
+	if err != nil {
+		return err
+	}
+	records, err := loadHistory()
+	if err != nil || expiredRecords(records, now.Add(-age)) == 0 {
+		return err
+	}
+	_, err = purgeHistory(now.Add(-age))
+	return err
+}
+
+// recentPayment returns the latest accepted payment for the same endpoint, method,
+// and body within window, or nil.
+func recentPayment(records []historyRecord, endpoint, method, data string, window time.Duration, now time.Time) *historyRecord {
+	hash := bodyHash(data)
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if now.Sub(r.Time) > window {
+			continue
+		}
+		if r.Status == "accepted" && r.Endpoint == endpoint && r.Method == method && r.BodySHA256 == hash {
+			return &records[i]
+		}
+	}
+	return nil
+}
+
+// runHistoryCmd lists recent payments from the ledger.
+func runHistoryCmd(args []string) {
+	if len(args) > 0 && args[0] == "purge" {
+		runHistoryPurgeCmd(args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "report" {
+		runHistoryReportCmd(args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "verify" {
+		runHistoryVerifyCmd(args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "export" {
+		runHistoryExportCmd(args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "migrate" {
+		runHistoryMigrateCmd(args[1:])
+		return
+	}
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	limit := fs.Int("limit", 20, "Show at most this many recent payments (0 for all)")
+	var tags headerFlags
+	fs.Var(&tags, "tag", "Only show payments tagged key=value, or with the tag key at all (repeatable; all must match)")
+	jsonOut := fs.Bool("json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli history [--limit N] [--tag key=value] [--json]\n")
+		fmt.Fprintf(os.Stderr, "       x402-cli history purge --older-than <age> [--dry-run] [--json]\n")
+		fmt.Fprintf(os.Stderr, "       x402-cli history report --by-endpoint [--since <age>] [--tag key=value] [--json]\n")
+		fmt.Fprintf(os.Stderr, "       x402-cli history verify [--json] <payment id>\n")
+		fmt.Fprintf(os.Stderr, "       x402-cli history export --format ledger|ofx [--since <age>] [--tag key=value] [-o file]\n")
+		fmt.Fprintf(os.Stderr, "       x402-cli history migrate --to jsonl|sqlite [--json]\n\n")
+		fmt.Fprintf(os.Stderr, "Lists paid requests recorded in the local ledger (x402-cli/history.jsonl in the user\n")
+		fmt.Fprintf(os.Stderr, "config directory, or history.db with X402_HISTORY_BACKEND=sqlite), newest last.\n")
+		fmt.Fprintf(os.Stderr, "Request bodies are stored only as SHA-256 hashes.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	records, err := loadHistory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	records = tagFilter(tags).filter(records)
+	if *limit > 0 && len(records) > *limit {
+		records = records[len(records)-*limit:]
+	}
+
+	if *jsonOut {
+		entries := make([]historyEntry, 0, len(records))
+		for _, r := range records {
+			entries = append(entries, newHistoryEntry(r))
+		}
+		printJSON(entries)
+		return
+	}
+	if len(records) == 0 {
+		fmt.Println("No payments recorded yet.")
+		return
+	}
+	// The TAGS column is shown only when some listed payment has tags.
+	tagged := false
+	for _, r := range records {
+		tagged = tagged || len(r.Tags) > 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := "ID\tTIME\tMETHOD\tENDPOINT\tSTATUS\tAMOUNT\tNETWORK\tTRANSACTION"
+	if tagged {
+		header += "\tTAGS"
+	}
+	fmt.Fprintln(w, header)
+	for _, r := range records {
+		amount := "-"
+		if r.Amount != "" {
+			amount = describeAmount(x402.PaymentRequirements{Network: r.Network, Asset: r.Asset, Amount: r.Amount})
+		}
+		network := "-"
+		if r.Network != "" {
+			network = networkName(r.Network)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s", dashIfEmpty(r.ID), r.Time.Local().Format("2006-01-02 15:04:05"),
+			r.Method, redactText(r.Endpoint), r.Status, amount, network, redactText(dashIfEmpty(r.Transaction)))
+		if tagged {
+			fmt.Fprintf(w, "\t%s", dashIfEmpty(formatTags(r.Tags)))
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+}
+
+// purgeResult is the JSON output for `x402-cli history purge`.
+type purgeResult struct {
+	OlderThan string `json:"olderThan"`
+	Cutoff    string `json:"cutoff"`
+	Removed   int    `json:"removed"`
+	Kept      int    `json:"kept"`
+	DryRun    bool   `json:"dryRun,omitempty"`
+}
+
+// runHistoryPurgeCmd deletes ledger records older than --older-than.
+func runHistoryPurgeCmd(args []string) {
+	fs := flag.NewFlagSet("history purge", flag.ExitOnError)
+	olderThan := fs.String("older-than", os.Getenv("X402_HISTORY_RETENTION"), "Remove records older than this age, e.g. 90d, 2w, 720h (default: $X402_HISTORY_RETENTION)")
+	dryRun := fs.Bool("dry-run", false, "Only report how many records would be removed")
+	jsonOut := fs.Bool("json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli history purge --older-than <age> [--dry-run] [--json]\n\n")
+		fmt.Fprintf(os.Stderr, "Removes payment records older than <age> from the local ledger. Pending payments are\n")
+		fmt.Fprintf(os.Stderr, "kept until resolved. Set X402_HISTORY_RETENTION to purge automatically after each payment.\n")
+		fmt.Fprintf(os.Stderr, "Host budgets only count recorded payments: keep at least the longest budget period.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *olderThan == "" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	age, err := parseAge(*olderThan)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --older-than: %v\n", err)
+		os.Exit(ExitError)
+	}
+	cutoff := time.Now().Add(-age)
+	records, err := loadHistory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	result := purgeResult{OlderThan: *olderThan, Cutoff: cutoff.UTC().Format(time.RFC3339), DryRun: *dryRun}
+	result.Removed = expiredRecords(records, cutoff)
+	if !*dryRun && result.Removed > 0 {
+		if result.Removed, err = purgeHistory(cutoff); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to purge the history ledger: %v\n", err)
+			os.Exit(ExitError)
+		}
+	}
+	result.Kept = len(records) - result.Removed
+
+	if *jsonOut {
+		printJSON(result)
+		return
+	}
+	verb := "Removed"
+	if *dryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("%s %d payment record(s) older than %s (before %s); %d kept.\n",
+		verb, result.Removed, *olderThan, cutoff.Local().Format("2006-01-02 15:04:05"), result.Kept)
+}
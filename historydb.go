@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "modernc.org/sqlite" // the "sqlite" database/sql driver, pure Go
+)
+
+// Ledger backends, selected with X402_HISTORY_BACKEND.
+const (
+	historyJSONL  = "jsonl"  // history.jsonl: one JSON object (or sealed line) per line
+	historySQLite = "sqlite" // history.db: one row per record, holding the same line
+)
+
+// historyFiles are the ledger files of each backend in the config directory.
+var historyFiles = map[string]string{historyJSONL: "history.jsonl", historySQLite: "history.db"}
+
+// historyLock names the ledger lock. Both backends share it, so a migration excludes
+// every writer whichever backend it uses.
+const historyLock = "history.jsonl"
+
+// historyBackend returns the backend X402_HISTORY_BACKEND selects: jsonl unless set.
+func historyBackend() (string, error) {
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("X402_HISTORY_BACKEND")))
+	if backend == "" {
+		return historyJSONL, nil
+	}
+	if _, ok := historyFiles[backend]; !ok {
+		return "", fmt.Errorf("X402_HISTORY_BACKEND: unknown backend %q (want jsonl or sqlite)", backend)
+	}
+	return backend, nil
+}
+
+// otherHistoryBackend is the backend a migration from backend goes to.
+func otherHistoryBackend(backend string) string {
+	if backend == historySQLite {
+		return historyJSONL
+	}
+	return historySQLite
+}
+
+// historyLedger returns the selected backend and the path of its ledger. It refuses a
+// backend whose ledger does not exist yet while the other backend's does: budgets and
+// the repeat-payment check would otherwise start from an empty ledger.
+func historyLedger() (backend, path string, err error) {
+	if backend, err = historyBackend(); err != nil {
+		return "", "", err
+	}
+	if path, err = stateFile(historyFiles[backend]); err != nil {
+		return "", "", err
+	}
+	other, err := stateFile(historyFiles[otherHistoryBackend(backend)])
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(other); err == nil {
+			return "", "", fmt.Errorf("X402_HISTORY_BACKEND is %s, but the ledger is in %s: run `x402-cli history migrate --to %s` first",
+				backend, filepath.Base(other), backend)
+		}
+	}
+	return backend, path, nil
+}
+
+// readLedger reads every record in the ledger of backend at path, oldest first.
+func readLedger(backend, path string) ([]historyRecord, error) {
+	if backend != historySQLite {
+		return readHistory(path)
+	}
+	lines, err := historyLines(backend, path)
+	if err != nil {
+		return nil, err
+	}
+	var records []historyRecord
+	for _, raw := range lines {
+		line, err := openLine(raw)
+		if err != nil {
+			return nil, err
+		}
+		var rec historyRecord
+		if json.Unmarshal(line, &rec) == nil {
+			records = append(records, rec)
+		}
+	}
+	return records, nil
+}
+
+// ledgerSealed reports whether the ledger of backend at path holds any sealed line.
+func ledgerSealed(backend, path string) bool {
+	if backend != historySQLite {
+		return stateSealed(path)
+	}
+	lines, _ := historyLines(backend, path)
+	for _, line := range lines {
+		if bytes.HasPrefix(line, []byte(sealedPrefix)) || bytes.HasPrefix(line, []byte(legacySealedPrefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// historyLines returns the ledger's lines as stored, sealed ones still sealed; a missing
+// ledger has none.
+func historyLines(backend, path string) ([][]byte, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if backend == historySQLite {
+		db, err := openHistoryDB(path)
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+		rows, err := db.Query(`SELECT record FROM history ORDER BY seq`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var lines [][]byte
+		for rows.Next() {
+			var line []byte
+			if err := rows.Scan(&line); err != nil {
+				return nil, err
+			}
+			lines = append(lines, line)
+		}
+		return lines, rows.Err()
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines [][]byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			lines = append(lines, bytes.Clone(line))
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// openHistoryDB opens the SQLite ledger at path, creating it and its table when missing.
+// A record is the ledger line history.jsonl would hold, so plaintext records can be
+// queried with json_extract and sealed ones stay sealed.
+func openHistoryDB(path string) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	// Created here so that the ledger is readable only by its owner, as history.jsonl is.
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS history (seq INTEGER PRIMARY KEY AUTOINCREMENT, record TEXT NOT NULL)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot open the history database %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// appendHistoryDB adds one ledger line to the SQLite ledger at path.
+func appendHistoryDB(path string, line []byte) error {
+	db, err := openHistoryDB(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Exec(`INSERT INTO history (record) VALUES (?)`, string(line)); err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// rewriteLedger replaces the ledger of backend at path with lines, atomically.
+func rewriteLedger(backend, path string, lines [][]byte) error {
+	if backend != historySQLite {
+		var buf []byte
+		for _, line := range lines {
+			buf = append(append(buf, line...), '\n')
+		}
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, buf, 0600); err != nil {
+			return err
+		}
+		return os.Rename(tmp, path)
+	}
+	db, err := openHistoryDB(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM history`); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if _, err := tx.Exec(`INSERT INTO history (record) VALUES (?)`, string(line)); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// migrateResult is the JSON output for `x402-cli history migrate`.
+type migrateResult struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Path    string `json:"path"`
+	Records int    `json:"records"`
+}
+
+// migrateHistory copies the ledger of the other backend into to's ledger, which must be
+// missing or empty. Lines are copied as stored, so sealed records need no key and stay
+// sealed. The source ledger is left as it was.
+func migrateHistory(to string) (*migrateResult, error) {
+	if _, ok := historyFiles[to]; !ok {
+		return nil, fmt.Errorf("unknown backend %q (want jsonl or sqlite)", to)
+	}
+	from := otherHistoryBackend(to)
+	src, err := stateFile(historyFiles[from])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := stateFile(historyFiles[to])
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := lockState(historyLock)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if _, err := os.Stat(src); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("there is no %s ledger to migrate (%s)", from, src)
+	}
+	lines, err := historyLines(from, src)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %w", src, err)
+	}
+	existing, err := historyLines(to, dst)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %w", dst, err)
+	}
+	if len(existing) > 0 {
+		return nil, fmt.Errorf("%s already holds %d records; move it aside to migrate into it", dst, len(existing))
+	}
+	if err := rewriteLedger(to, dst, lines); err != nil {
+		return nil, fmt.Errorf("cannot write %s: %w", dst, err)
+	}
+	return &migrateResult{From: from, To: to, Path: dst, Records: len(lines)}, nil
+}
+
+// runHistoryMigrateCmd copies the ledger between the JSONL and SQLite backends.
+func runHistoryMigrateCmd(args []string) {
+	fs := flag.NewFlagSet("history migrate", flag.ExitOnError)
+	to := fs.String("to", "", "Backend to migrate the ledger to: jsonl or sqlite (required)")
+	jsonOut := fs.Bool("json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli history migrate --to jsonl|sqlite [--json]\n\n")
+		fmt.Fprintf(os.Stderr, "Copies the payment ledger from the other backend (history.jsonl or history.db in the\n")
+		fmt.Fprintf(os.Stderr, "user config directory) into the --to backend, whose ledger must be missing or empty.\n")
+		fmt.Fprintf(os.Stderr, "Encrypted records are copied as they are. The old ledger is kept; set\n")
+		fmt.Fprintf(os.Stderr, "X402_HISTORY_BACKEND to the new backend to use it.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *to == "" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+
+	result, err := migrateHistory(strings.ToLower(strings.TrimSpace(*to)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	if *jsonOut {
+		printJSON(result)
+		return
+	}
+	fmt.Printf("Migrated %d payment record(s) from %s to %s (%s).\n", result.Records, result.From, result.To, result.Path)
+	if backend, _ := historyBackend(); backend != result.To {
+		fmt.Printf("Set X402_HISTORY_BACKEND=%s to use it; until then payments are still recorded in %s.\n", result.To, historyFiles[result.From])
+	}
+}
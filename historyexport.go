@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// exportEntry is an accepted payment as `history export` writes it.
+type exportEntry struct {
+	record historyRecord
+	host   string
+	// amount is in token units, or atomic units when the asset's decimals are unknown.
+	amount    string
+	commodity string
+	atomic    bool
+	usdc      bool
+}
+
+// exportEntries turns the accepted payments among records into export entries, oldest first.
+func exportEntries(records []historyRecord) []exportEntry {
+	var entries []exportEntry
+	for _, r := range records {
+		if r.Status != "accepted" || r.Amount == "" {
+			continue
+		}
+		req := x402.PaymentRequirements{Network: r.Network, Asset: r.Asset, Amount: r.Amount}
+		e := exportEntry{record: r, host: r.Endpoint, amount: r.Amount, commodity: assetSymbol(req), atomic: true}
+		if u, err := url.Parse(r.Endpoint); err == nil && u.Host != "" {
+			e.host = u.Host
+		}
+		if decimals, ok := assetDecimals(req); ok {
+			if amount, err := exactAmount(r.Amount, decimals); err == nil {
+				e.amount, e.atomic = amount, false
+			}
+		}
+		e.usdc = e.commodity == "USDC" && !e.atomic
+		entries = append(entries, e)
+	}
+	slices.SortStableFunc(entries, func(a, b exportEntry) int { return a.record.Time.Compare(b.record.Time) })
+	return entries
+}
+
+// fitID is the entry's unique transaction ID for OFX: the ledger ID, else one derived from
+// the record for payments recorded before IDs were.
+func (e exportEntry) fitID() string {
+	if e.record.ID != "" {
+		return e.record.ID
+	}
+	if e.record.Transaction != "" {
+		return e.record.Transaction
+	}
+	sum := sha256.Sum256([]byte(e.record.Time.Format(time.RFC3339Nano) + " " + e.record.Endpoint))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ledgerAccount appends a sub-account named after name (a host or network) to parent,
+// replacing the characters plaintext-accounting tools reserve in account names.
+func ledgerAccount(parent, name string) string {
+	name = strings.NewReplacer(":", "-", ";", "-").Replace(strings.Join(strings.Fields(name), " "))
+	if name == "" {
+		return parent
+	}
+	return parent + ":" + name
+}
+
+// ledgerCommodity quotes a commodity that is not all letters, as ledger and hledger require.
+func ledgerCommodity(c string) string {
+	for _, r := range c {
+		if (r < 'A' || r > 'Z') && (r < 'a' || r > 'z') {
+			return `"` + strings.ReplaceAll(c, `"`, "") + `"`
+		}
+	}
+	return c
+}
+
+// writeLedger writes the entries as ledger/hledger plaintext-accounting transactions: each
+// payment is an expense of its host, paid from an asset account per network. The record's ID, endpoint, payer, transaction, and tags become metadata comments.
+func writeLedger(w io.Writer, entries []exportEntry, expenses, assets string) {
+	for i, e := range entries {
+		r := e.record
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s * %s\n", r.Time.UTC().Format("2006-01-02"), e.host)
+		meta := [][2]string{{"id", r.ID}, {"endpoint", r.Method + " " + redactText(r.Endpoint)},
+			{"payer", r.Payer}, {"payTo", r.PayTo}, {"transaction", r.Transaction}}
+		if e.atomic {
+			meta = append(meta, [2]string{"units", "atomic"})
+		}
+		for _, k := range slices.Sorted(maps.Keys(r.Tags)) {
+			meta = append(meta, [2]string{k, r.Tags[k]})
+		}
+		for _, m := range meta {
+			if m[1] != "" {
+				fmt.Fprintf(w, "    ; %s: %s\n", m[0], strings.ReplaceAll(m[1], "\n", " "))
+			}
+		}
+		fmt.Fprintf(w, "    %s  %s %s\n", ledgerAccount(expenses, e.host), e.amount, ledgerCommodity(e.commodity))
+		fmt.Fprintf(w, "    %s\n", ledgerAccount(assets, networkName(r.Network)))
+	}
+}
+
+// ofxTime formats a time as an OFX date-time in UTC.
+func ofxTime(t time.Time) string {
+	return t.UTC().Format("20060102150405") + ".000[0:GMT]"
+}
+
+// ofxText escapes SGML markup and trims a value to OFX's field length.
+func ofxText(s string, max int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > max {
+		s = s[:max]
+	}
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// writeOFX writes the USDC entries as an OFX 1.02 bank statement per paying wallet, with
+// USDC counted as US dollars; it returns how many entries it left out for being in other
+// tokens, which personal finance tools could only book as dollars by mistake.
+func writeOFX(w io.Writer, entries []exportEntry, now time.Time) (skipped int) {
+	var wallets []string
+	byWallet := map[string][]exportEntry{}
+	for _, e := range entries {
+		if !e.usdc {
+			skipped++
+			continue
+		}
+		payer := dashIfEmpty(e.record.Payer)
+		if byWallet[payer] == nil {
+			wallets = append(wallets, payer)
+		}
+		byWallet[payer] = append(byWallet[payer], e)
+	}
+
+	fmt.Fprint(w, "OFXHEADER:100\nDATA:OFXSGML\nVERSION:102\nSECURITY:NONE\nENCODING:USASCII\nCHARSET:1252\n"+
+		"COMPRESSION:NONE\nOLDFILEUID:NONE\nNEWFILEUID:NONE\n\n")
+	fmt.Fprintf(w, "<OFX>\n<SIGNONMSGSRSV1>\n<SONRS>\n<STATUS>\n<CODE>0\n<SEVERITY>INFO\n</STATUS>\n<DTSERVER>%s\n<LANGUAGE>ENG\n</SONRS>\n</SIGNONMSGSRSV1>\n", ofxTime(now))
+	fmt.Fprint(w, "<BANKMSGSRSV1>\n")
+	for i, wallet := range wallets {
+		txns := byWallet[wallet]
+		fmt.Fprintf(w, "<STMTTRNRS>\n<TRNUID>%d\n<STATUS>\n<CODE>0\n<SEVERITY>INFO\n</STATUS>\n<STMTRS>\n<CURDEF>USD\n", i+1)
+		fmt.Fprintf(w, "<BANKACCTFROM>\n<BANKID>x402\n<ACCTID>%s\n<ACCTTYPE>CHECKING\n</BANKACCTFROM>\n", ofxText(wallet, 22))
+		fmt.Fprintf(w, "<BANKTRANLIST>\n<DTSTART>%s\n<DTEND>%s\n", ofxTime(txns[0].record.Time), ofxTime(txns[len(txns)-1].record.Time))
+		for _, e := range txns {
+			r := e.record
+			memo := r.Method + " " + redactText(r.Endpoint)
+			if r.Transaction != "" {
+				memo += " tx " + r.Transaction
+			}
+			fmt.Fprintf(w, "<STMTTRN>\n<TRNTYPE>DEBIT\n<DTPOSTED>%s\n<TRNAMT>-%s\n<FITID>%s\n<NAME>%s\n<MEMO>%s\n</STMTTRN>\n",
+				ofxTime(r.Time), e.amount, ofxText(e.fitID(), 255), ofxText(e.host, 32), ofxText(memo, 255))
+		}
+		// The ledger does not know the wallet's balance; tools take the transactions and ignore it.
+		fmt.Fprintf(w, "</BANKTRANLIST>\n<LEDGERBAL>\n<BALAMT>0.00\n<DTASOF>%s\n</LEDGERBAL>\n</STMTRS>\n</STMTTRNRS>\n", ofxTime(now))
+	}
+	fmt.Fprint(w, "</BANKMSGSRSV1>\n</OFX>\n")
+	return skipped
+}
+
+// runHistoryExportCmd writes the accepted payments in the ledger in a plaintext-accounting
+// or personal finance format.
+func runHistoryExportCmd(args []string) {
+	fs := flag.NewFlagSet("history export", flag.ExitOnError)
+	format := fs.String("format", "", "Export format: ledger (ledger, hledger) or ofx (OFX 1.02 bank statement)")
+	since := fs.String("since", "", "Only export payments newer than this age, e.g. 30d or 12h")
+	var tags headerFlags
+	fs.Var(&tags, "tag", "Only export payments tagged key=value, or with the tag key at all (repeatable; all must match)")
+	output := fs.String("o", "", "Write the export to this file instead of stdout")
+	expenses := fs.String("expense-account", "Expenses:x402", "ledger: account the payments are booked to; the host is appended")
+	assets := fs.String("asset-account", "Assets:x402", "ledger: account the payments are paid from; the network is appended")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli history export --format ledger|ofx [--since <age>] [--tag key=value] [-o file]\n\n")
+		fmt.Fprintf(os.Stderr, "Exports the accepted payments in the local ledger, oldest first, for plaintext-accounting\n")
+		fmt.Fprintf(os.Stderr, "and personal finance tools. ledger writes one transaction per payment, from\n")
+		fmt.Fprintf(os.Stderr, "<asset-account>:<network> to <expense-account>:<host>, in token units, with the payment\n")
+		fmt.Fprintf(os.Stderr, "ID, endpoint, payer, transaction, and tags as metadata. ofx writes a bank statement per\n")
+		fmt.Fprintf(os.Stderr, "paying wallet with each payment as a debit in US dollars: only USDC payments are\n")
+		fmt.Fprintf(os.Stderr, "exported, and the others are counted on stderr. Dates are in UTC.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *format != "ledger" && *format != "ofx" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+
+	records, err := loadHistory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	if *since != "" {
+		age, err := parseAge(*since)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --since: %v\n", err)
+			os.Exit(ExitError)
+		}
+		cutoff := time.Now().Add(-age)
+		var recent []historyRecord
+		for _, r := range records {
+			if r.Time.After(cutoff) {
+				recent = append(recent, r)
+			}
+		}
+		records = recent
+	}
+	entries := exportEntries(tagFilter(tags).filter(records))
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitError)
+		}
+		defer f.Close()
+		w = f
+	}
+	switch *format {
+	case "ledger":
+		writeLedger(w, entries, *expenses, *assets)
+	case "ofx":
+		if skipped := writeOFX(w, entries, time.Now()); skipped > 0 {
+			fmt.Fprintf(os.Stderr, "Note: %d payment(s) in tokens other than USDC were left out of the OFX export; use --format ledger for them.\n", skipped)
+		}
+	}
+}
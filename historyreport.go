@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// endpointUsage is one endpoint's row of `history report --by-endpoint`: what its accepted
+// payments cost and what they returned.
+type endpointUsage struct {
+	Endpoint string       `json:"endpoint"`
+	Payments int          `json:"payments"`
+	Spent    []spendTotal `json:"spent"`
+	// Measured counts the payments whose response size was recorded; older records have none.
+	Measured      int   `json:"measured"`
+	BytesReceived int64 `json:"bytesReceived"`
+	AvgBytes      int64 `json:"avgBytes"`
+	Empty         int   `json:"empty"`
+	ErrorBodies   int   `json:"errorBodies"`
+}
+
+// suspect reports whether some of the endpoint's paid responses were empty or errors.
+func (u endpointUsage) suspect() bool {
+	return u.Empty+u.ErrorBodies > 0
+}
+
+// usageByEndpoint totals the accepted payments in records per endpoint, most paid first.
+func usageByEndpoint(records []historyRecord) []endpointUsage {
+	byURL := map[string]*endpointUsage{}
+	sums := map[string]map[[2]string]*big.Int{}
+	for _, r := range records {
+		if r.Status != "accepted" {
+			continue
+		}
+		u := byURL[r.Endpoint]
+		if u == nil {
+			u = &endpointUsage{Endpoint: r.Endpoint}
+			byURL[r.Endpoint] = u
+			sums[r.Endpoint] = map[[2]string]*big.Int{}
+		}
+		u.Payments++
+		if amount, ok := new(big.Int).SetString(r.Amount, 10); ok {
+			key := [2]string{r.Network, strings.ToLower(r.Asset)}
+			if sums[r.Endpoint][key] == nil {
+				sums[r.Endpoint][key] = new(big.Int)
+			}
+			sums[r.Endpoint][key].Add(sums[r.Endpoint][key], amount)
+		}
+		if r.BytesReceived != nil {
+			u.Measured++
+			u.BytesReceived += *r.BytesReceived
+		}
+		switch r.Suspect {
+		case "empty":
+			u.Empty++
+		case "error":
+			u.ErrorBodies++
+		}
+	}
+
+	usage := make([]endpointUsage, 0, len(byURL))
+	for endpoint, u := range byURL {
+		for key, sum := range sums[endpoint] {
+			u.Spent = append(u.Spent, spendTotal{Network: key[0], Asset: key[1], Amount: sum.String()})
+		}
+		sort.Slice(u.Spent, func(i, j int) bool { return u.Spent[i].Network+u.Spent[i].Asset < u.Spent[j].Network+u.Spent[j].Asset })
+		if u.Measured > 0 {
+			u.AvgBytes = u.BytesReceived / int64(u.Measured)
+		}
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Payments != usage[j].Payments {
+			return usage[i].Payments > usage[j].Payments
+		}
+		return usage[i].Endpoint < usage[j].Endpoint
+	})
+	return usage
+}
+
+// formatBytes renders a byte count for the report, e.g. "12.3 KB".
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// runHistoryReportCmd reconciles spend with the data received, per endpoint.
+func runHistoryReportCmd(args []string) {
+	fs := flag.NewFlagSet("history report", flag.ExitOnError)
+	byEndpoint := fs.Bool("by-endpoint", false, "Group the report by endpoint")
+	since := fs.String("since", "", "Only count payments newer than this age, e.g. 30d or 12h")
+	var tags headerFlags
+	fs.Var(&tags, "tag", "Only count payments tagged key=value, or with the tag key at all (repeatable; all must match)")
+	jsonOut := fs.Bool("json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli history report --by-endpoint [--since <age>] [--tag key=value] [--json]\n\n")
+		fmt.Fprintf(os.Stderr, "Reconciles what each endpoint was paid with what it returned: accepted payments, total\n")
+		fmt.Fprintf(os.Stderr, "spend, bytes received, and how many paid 200 responses were empty or error bodies, so\n")
+		fmt.Fprintf(os.Stderr, "endpoints charging full price for nothing stand out (marked !). Response sizes are\n")
+		fmt.Fprintf(os.Stderr, "recorded for payments made since this version; older payments count as unmeasured.\n")
+		fmt.Fprintf(os.Stderr, "--tag narrows the report to one job or team's payments (see --tag on payments).\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if !*byEndpoint {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+
+	records, err := loadHistory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	if *since != "" {
+		age, err := parseAge(*since)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --since: %v\n", err)
+			os.Exit(ExitError)
+		}
+		cutoff := time.Now().Add(-age)
+		var recent []historyRecord
+		for _, r := range records {
+			if r.Time.After(cutoff) {
+				recent = append(recent, r)
+			}
+		}
+		records = recent
+	}
+	usage := usageByEndpoint(tagFilter(tags).filter(records))
+
+	if *jsonOut {
+		printJSON(usage)
+		return
+	}
+	if len(usage) == 0 {
+		fmt.Println("No accepted payments recorded.")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tENDPOINT\tPAID\tSPENT\tRECEIVED\tAVG/CALL\tEMPTY\tERRORS")
+	for _, u := range usage {
+		mark := ""
+		if u.suspect() {
+			mark = "!"
+		}
+		var spent []string
+		for _, t := range u.Spent {
+			spent = append(spent, tokenAmount(x402.PaymentRequirements{Network: t.Network, Asset: t.Asset, Amount: t.Amount}))
+		}
+		received, avg := "-", "-"
+		if u.Measured > 0 {
+			received, avg = formatBytes(u.BytesReceived), formatBytes(u.AvgBytes)
+			if u.Measured < u.Payments {
+				received += fmt.Sprintf(" (%d/%d measured)", u.Measured, u.Payments)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%d\t%d\n", mark, redactText(u.Endpoint), u.Payments,
+			dashIfEmpty(strings.Join(spent, " + ")), received, avg, u.Empty, u.ErrorBodies)
+	}
+	w.Flush()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// hostAllowlist restricts which hosts may be paid (--only-hosts or X402_ONLY_HOSTS).
+// An entry is an exact host name or "*.domain" for any subdomain of domain. An empty
+// list allows every host.
+type hostAllowlist []string
+
+// parseHostAllowlist parses a comma-separated list of hosts and wildcard patterns.
+func parseHostAllowlist(spec string) hostAllowlist {
+	var list hostAllowlist
+	for _, h := range strings.Split(spec, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			list = append(list, h)
+		}
+	}
+	return list
+}
+
+// allows reports whether host (a URL's Hostname, without port) may be paid.
+func (l hostAllowlist) allows(host string) bool {
+	if len(l) == 0 {
+		return true
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, pattern := range l {
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// transport wraps rt so that no payment header is ever sent to a host off the list,
+// whatever URL or redirect led there.
+func (l hostAllowlist) transport(rt http.RoundTripper) http.RoundTripper {
+	if len(l) == 0 {
+		return rt
+	}
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		paying := req.Header.Get("PAYMENT-SIGNATURE") != "" || req.Header.Get("X-PAYMENT") != ""
+		if paying && !l.allows(req.URL.Hostname()) {
+			return nil, fmt.Errorf("refusing to pay %s: host is not in --only-hosts (%s)", req.URL.Hostname(), strings.Join(l, ","))
+		}
+		return rt.RoundTrip(req)
+	})
+}
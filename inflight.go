@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// inflightDir is the state directory holding payments whose run has not finished.
+const inflightDir = "inflight"
+
+// inflight is the current run's in-flight payment; nil when nothing is being paid.
+var inflight *inflightPayment
+
+// inflightPayment is the persisted state of a payment in progress, so that a crashed or
+// interrupted run can be finished with `x402-cli resume <id>`. It moves through
+// "requirements" (the 402 was accepted and every check passed), "signed" (the payment
+// header is about to be sent), and "sent" (the paid request went out). The file is
+// removed when the run ends with a final status, and kept while the outcome is unknown.
+type inflightPayment struct {
+	ID       string    `json:"id"` // also the payment's ID in the history ledger
+	State    string    `json:"state"`
+	Updated  time.Time `json:"updated"`
+	Endpoint string    `json:"endpoint"`
+	Method   string    `json:"method"`
+	// Headers are the -H headers the paid request sends.
+	Headers headerFlags `json:"headers,omitempty"`
+	Data    string      `json:"data,omitempty"`
+	// BodyFile holds a -d @- body, copied next to the state file.
+	BodyFile     string                   `json:"bodyFile,omitempty"`
+	Requirements x402.PaymentRequirements `json:"requirements"`
+	// Payment is the signed payment header and PaymentHeader its name.
+	Payment       string `json:"payment,omitempty"`
+	PaymentHeader string `json:"paymentHeader,omitempty"`
+	Profile       string `json:"profile,omitempty"`
+	TraceID       string `json:"traceId,omitempty"`
+	// Tags are the --tag metadata, kept for the ledger record of a resumed payment.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// inflightPath is the state file of the in-flight payment id.
+func inflightPath(id string) (string, error) {
+	return stateFile(filepath.Join(inflightDir, id+".json"))
+}
+
+// newInflight records a payment about to be made in state "requirements".
+func newInflight(endpoint, method, data string, headers headerFlags, price x402.PaymentRequirements, traceID string) (*inflightPayment, error) {
+	p := &inflightPayment{
+		ID:           newTraceID()[:12],
+		State:        "requirements",
+		Endpoint:     endpoint,
+		Method:       method,
+		Headers:      headers,
+		Data:         data,
+		Requirements: price,
+		Profile:      profile,
+		TraceID:      traceID,
+		Tags:         copyTags(recordTags),
+	}
+	if data == stdinData && stdinSpool != nil {
+		path, err := stateFile(filepath.Join(inflightDir, p.ID+".body"))
+		if err != nil {
+			return nil, err
+		}
+		if err := copyBody(stdinSpool, path); err != nil {
+			return nil, fmt.Errorf("cannot save the request body: %w", err)
+		}
+		p.BodyFile = path
+	}
+	return p, p.save()
+}
+
+// copyBody copies a spooled stdin body to path.
+func copyBody(body *stdinBody, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	in, err := body.open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// save writes the state file atomically, encrypted when X402_ENCRYPT_STATE is set.
+func (p *inflightPayment) save() error {
+	path, err := inflightPath(p.ID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	p.Updated = time.Now().UTC()
+	data, _ := json.Marshal(p)
+	if stateEncryption() {
+		if data, err = sealLine(data); err != nil {
+			return err
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// advance moves the payment to state, warning when the state cannot be saved.
+func (p *inflightPayment) advance(state string) {
+	if p == nil {
+		return
+	}
+	p.State = state
+	if err := p.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot save the payment state: %v\n", err)
+	}
+}
+
+// transport wraps rt to record the payment header before it is sent and the send itself.
+func (p *inflightPayment) transport(rt http.RoundTripper) http.RoundTripper {
+	if p == nil {
+		return rt
+	}
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		for _, h := range []string{"PAYMENT-SIGNATURE", "X-PAYMENT"} {
+			if v := req.Header.Get(h); v != "" {
+				p.Payment, p.PaymentHeader = v, h
+				p.advance("signed")
+				resp, err := rt.RoundTrip(req)
+				p.advance("sent")
+				return resp, err
+			}
+		}
+		return rt.RoundTrip(req)
+	})
+}
+
+// finish removes the state when the run ends with the payment's final status, or before
+// anything was signed. A payment whose outcome is unknown is kept for `resume`: status
+// "pending" (the paid request timed out) or "" (no response, e.g. the connection dropped).
+func (p *inflightPayment) finish(status string) {
+	if p == nil || status == "pending" || (status == "" && p.State != "requirements") {
+		return
+	}
+	p.remove()
+}
+
+// remove deletes the state file and any saved body.
+func (p *inflightPayment) remove() {
+	if path, err := inflightPath(p.ID); err == nil {
+		os.Remove(path)
+	}
+	if p.BodyFile != "" {
+		os.Remove(p.BodyFile)
+	}
+}
+
+// loadInflight reads the in-flight payment whose ID starts with id.
+func loadInflight(id string) (*inflightPayment, error) {
+	payments, err := listInflight()
+	if err != nil {
+		return nil, err
+	}
+	var found *inflightPayment
+	for _, p := range payments {
+		if strings.HasPrefix(p.ID, id) {
+			if found != nil {
+				return nil, fmt.Errorf("payment ID %q is ambiguous; use more characters", id)
+			}
+			found = p
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no in-flight payment with ID %q", id)
+	}
+	return found, nil
+}
+
+// listInflight reads every in-flight payment, oldest first.
+func listInflight() ([]*inflightPayment, error) {
+	dir, err := stateFile(inflightDir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var payments []*inflightPayment
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if raw, err = openLine(raw); err != nil {
+			return nil, err
+		}
+		var p inflightPayment
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		payments = append(payments, &p)
+	}
+	sort.Slice(payments, func(i, j int) bool { return payments[i].Updated.Before(payments[j].Updated) })
+	return payments, nil
+}
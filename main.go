@@ -1,35 +1,49 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"maps"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"os"
 	"runtime/debug"
+	"slices"
 	"strings"
 	"time"
 
 	x402 "github.com/coinbase/x402/go"
 	x402http "github.com/coinbase/x402/go/http"
-	evm "github.com/coinbase/x402/go/mechanisms/evm/exact/client"
-	evmsigners "github.com/coinbase/x402/go/signers/evm"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
 var version string
 
 // Exit codes for programmatic use by agents.
 const (
-	ExitSuccess         = 0
-	ExitError           = 1
-	ExitPaymentRejected = 2
-	ExitFreeRoute       = 3
+	ExitSuccess           = 0
+	ExitError             = 1
+	ExitPaymentRejected   = 2
+	ExitFreeRoute         = 3
+	ExitInsufficientFunds = 4
+	ExitDNSError          = 5
+	ExitTLSError          = 6
+	ExitTimeout           = 7
+	ExitFacilitatorError  = 8
+	ExitUnsupported       = 9
+	ExitSLOBreached       = 10
 )
 
 // headerFlags collects multiple -H flags.
@@ -43,20 +57,67 @@ func (h *headerFlags) Set(val string) error {
 
 // jsonResult is the structured output for --json mode.
 type jsonResult struct {
-	Version  string       `json:"version"`
-	Endpoint string       `json:"endpoint"`
-	Method   string       `json:"method"`
-	Status   string       `json:"status"`
-	Probe    *probeResult `json:"probe"`
-	Payment  *payResult   `json:"payment,omitempty"`
-	Error    string       `json:"error,omitempty"`
+	// SchemaVersion is set under --output-schema v2 (see `x402-cli schema`).
+	SchemaVersion int          `json:"schemaVersion,omitempty"`
+	Version       string       `json:"version"`
+	Endpoint      string       `json:"endpoint"`
+	Method        string       `json:"method"`
+	RequestID     string       `json:"requestId"`
+	TraceID       string       `json:"traceId,omitempty"`
+	Status        string       `json:"status"`
+	Probe         *probeResult `json:"probe"`
+	Payment       *payResult   `json:"payment,omitempty"`
+	Error         string       `json:"error,omitempty"`
+	// ErrorType classifies failures: "dns", "tls", "timeout", "facilitator", or "settlement".
+	ErrorType string `json:"errorType,omitempty"`
+	// Simulation is the facilitator verdict when --simulate is set.
+	Simulation *simulationResult `json:"simulation,omitempty"`
+	// Comparison holds each facilitator's verdict when --simulate is given several facilitators.
+	Comparison *facilitatorComparison `json:"facilitatorComparison,omitempty"`
+	// FundingLinks are wallet deep links to top up the signer after an insufficient-funds failure.
+	FundingLinks []fundingLink `json:"fundingLinks,omitempty"`
+	// RejectionReason normalizes the facilitator's reason when the payment was refused.
+	RejectionReason *rejectionInfo `json:"rejectionReason,omitempty"`
+	// ArtifactsDir is where --artifacts-dir recorded this run.
+	ArtifactsDir string `json:"artifactsDir,omitempty"`
+	// DebugBundle is the zip --debug-bundle writes when the run ends.
+	DebugBundle string `json:"debugBundle,omitempty"`
+	// Egress lists the network paths tried when the direct probe failed, and the one used.
+	Egress *egressReport `json:"egress,omitempty"`
+	// RateLimit describes the last 429 response, when either step was rate limited.
+	RateLimit *rateLimitInfo `json:"rateLimit,omitempty"`
+	// FreeTier is the endpoint's free quota, when a response reported it.
+	FreeTier *freeTier `json:"freeTier,omitempty"`
+	// Redirects is the redirect chain of both steps, including a refused cross-origin hop.
+	Redirects []redirectHop `json:"redirects,omitempty"`
+	// PaymentID identifies the payment in the history ledger (see `x402-cli resolve`).
+	PaymentID string `json:"paymentId,omitempty"`
+	// Selection is the option --select smart chose, and why.
+	Selection *selectionResult `json:"selection,omitempty"`
+	// Extract is what --extract, --decode, and --save-as made of the paid response.
+	Extract *extractResult `json:"extract,omitempty"`
+	// Archived is where --archive stored the paid response.
+	Archived string `json:"archived,omitempty"`
+	// Receipt is the path of the signed receipt of an accepted payment (see `x402-cli receipt check`).
+	Receipt string `json:"receipt,omitempty"`
+	// RequirementsChange is how the requirements differed when the paid request got a new 402.
+	RequirementsChange *requirementsChange `json:"requirementsChange,omitempty"`
+	// SLO is how an accepted payment measured up to --slo-latency and --slo-settlement.
+	SLO *sloResult `json:"slo,omitempty"`
+	// Support is the provider's docs and support contact, when the requirements name them.
+	Support *supportContact `json:"support,omitempty"`
 }
 
 type probeResult struct {
 	StatusCode          int              `json:"statusCode"`
 	PaymentRequired     bool             `json:"paymentRequired"`
 	PaymentRequirements *json.RawMessage `json:"paymentRequirements,omitempty"`
-	Body                string           `json:"body,omitempty"`
+	Capabilities        []capability     `json:"capabilities,omitempty"`
+	// Affordability is whether the wallet covers each option, set by --dry-run.
+	Affordability []affordability `json:"affordability,omitempty"`
+	// CachedAt is when the requirements used in place of a probe (--optimistic) were cached.
+	CachedAt *time.Time `json:"cachedAt,omitempty"`
+	Body     string     `json:"body,omitempty"`
 }
 
 type payResult struct {
@@ -65,6 +126,21 @@ type payResult struct {
 	Signer          string           `json:"signer,omitempty"`
 	PaymentResponse *json.RawMessage `json:"paymentResponse,omitempty"`
 	Body            string           `json:"body,omitempty"`
+	Settlement      *settlementCheck `json:"settlement,omitempty"`
+	// NonceRetries counts payments re-signed after a nonce-already-used rejection.
+	NonceRetries int `json:"nonceRetries,omitempty"`
+	// NoSpend is set when --no-spend answered the payment locally instead of sending it.
+	NoSpend bool `json:"noSpend,omitempty"`
+	// SavedTo is where an --on-type save rule wrote the paid response body.
+	SavedTo string `json:"savedTo,omitempty"`
+	// Decrypted is the --decrypt scheme the body was decrypted with; body is the plaintext.
+	Decrypted string `json:"decrypted,omitempty"`
+	// DroppedHeaders are the -H headers --pay-headers kept off the paid request.
+	DroppedHeaders []string `json:"droppedHeaders,omitempty"`
+	// Timing splits the paid request's time between the facilitator and the server.
+	Timing *paymentTiming `json:"timing,omitempty"`
+	// Attestation is how the facilitator's signature on the PAYMENT-RESPONSE held up.
+	Attestation *attestationCheck `json:"attestation,omitempty"`
 }
 
 func main() {
@@ -72,6 +148,114 @@ func main() {
 		version = buildVersion()
 	}
 
+	opts, err := defaultOpts()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+
+	// --profile and --amount-format are global: strip them before subcommand dispatch and flag parsing.
+	// In X402_CLI_OPTS they apply to every subcommand, unless the command line sets them too.
+	optProfile, opts := extractProfileFlag(opts)
+	profile, os.Args = extractProfileFlag(os.Args)
+	if profile == "" {
+		profile = optProfile
+	}
+	if profile == "" {
+		profile = os.Getenv("X402_PROFILE")
+	}
+	optAmountSpec, opts := extractGlobalFlag(opts, "amount-format")
+	amountSpec, args := extractGlobalFlag(os.Args, "amount-format")
+	os.Args = args
+	if amountSpec == "" {
+		amountSpec = optAmountSpec
+	}
+	if amountSpec == "" {
+		amountSpec = os.Getenv("X402_AMOUNT_FORMAT")
+	}
+	if amountSpec != "" {
+		f, err := parseAmountFormat(amountSpec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --amount-format: %v\n", err)
+			os.Exit(ExitError)
+		}
+		amountFmt = f
+	}
+
+	// --no-spend is global as well: every command that pays signs payments but never sends them.
+	optNoSpend, opts := extractGlobalSwitch(opts, "no-spend")
+	noSpend, os.Args = extractGlobalSwitch(os.Args, "no-spend")
+	noSpend = noSpend || optNoSpend || noSpendByEnv()
+
+	// --signers is global too: it routes each network's payments to a profile's key.
+	optSigners, opts := extractGlobalFlag(opts, "signers")
+	signerSpec, args := extractGlobalFlag(os.Args, "signers")
+	os.Args = args
+	if signerSpec == "" {
+		signerSpec = optSigners
+	}
+	if signerSpec == "" {
+		signerSpec = signersByEnv()
+	}
+	if err := applySignerRoutes(signerSpec); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+
+	// --redact is global: it trims output meant for shared logs in every command.
+	optRedact, opts := extractGlobalFlag(opts, "redact")
+	redactSpec, args := extractGlobalFlag(os.Args, "redact")
+	os.Args = args
+	if redactSpec == "" {
+		redactSpec = optRedact
+	}
+	if redactSpec == "" {
+		redactSpec = redactByEnv()
+	}
+	level, err := parseRedact(redactSpec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	redaction = level
+
+	// --statsd is global: every payment a command sends is counted there.
+	optStatsd, opts := extractGlobalFlag(opts, "statsd")
+	statsdAddr, args := extractGlobalFlag(os.Args, "statsd")
+	os.Args = args
+	if statsdAddr == "" {
+		statsdAddr = optStatsd
+	}
+	if statsdAddr == "" {
+		statsdAddr = statsdByEnv()
+	}
+	if statsdAddr != "" {
+		if statsd, err = newStatsdClient(statsdAddr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitError)
+		}
+	}
+
+	// --prompt is global: confirmations and passphrases of every command are asked there.
+	optPrompt, opts := extractGlobalFlag(opts, "prompt")
+	promptSpec, args := extractGlobalFlag(os.Args, "prompt")
+	os.Args = args
+	if promptSpec == "" {
+		promptSpec = optPrompt
+	}
+	if promptSpec != "" {
+		promptVia = promptSpec
+	}
+	if _, _, err := parsePromptRoute(promptVia); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+
+	// A devnet registered by `devnet up` is a network like the built-in ones, for every command.
+	if err := registerDevnet(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+
 	// Handle subcommands before flag parsing.
 	showHelp := false
 	if len(os.Args) > 1 {
@@ -79,6 +263,81 @@ func main() {
 		case "wallet":
 			runWalletCmd(os.Args[2:])
 			return
+		case "dashboard":
+			runDashboardCmd(os.Args[2:])
+			return
+		case "cors":
+			runCORSCmd(os.Args[2:])
+			return
+		case "fingerprint":
+			runFingerprintCmd(os.Args[2:])
+			return
+		case "tui":
+			runTUICmd(os.Args[2:])
+			return
+		case "history":
+			runHistoryCmd(os.Args[2:])
+			return
+		case "price-history":
+			runPriceHistoryCmd(os.Args[2:])
+			return
+		case "resolve":
+			runResolveCmd(os.Args[2:])
+			return
+		case "resume":
+			runResumeCmd(os.Args[2:])
+			return
+		case "flush":
+			runFlushCmd(os.Args[2:])
+			return
+		case "vectors":
+			runVectorsCmd(os.Args[2:])
+			return
+		case "serve":
+			runServeCmd(os.Args[2:])
+			return
+		case "check-payment":
+			runCheckPaymentCmd(os.Args[2:])
+			return
+		case "devnet":
+			runDevnetCmd(os.Args[2:])
+			return
+		case "fuzz":
+			runFuzzCmd(os.Args[2:])
+			return
+		case "batch":
+			runBatchCmd(os.Args[2:])
+			return
+		case "script":
+			runScriptCmd(os.Args[2:])
+			return
+		case "forecast":
+			runForecastCmd(os.Args[2:])
+			return
+		case "receipt":
+			runReceiptCmd(os.Args[2:])
+			return
+		case "sign-typed-data":
+			runSignTypedDataCmd(os.Args[2:])
+			return
+		case "convert":
+			runConvertCmd(os.Args[2:])
+			return
+		case "schema":
+			runSchemaCmd(os.Args[2:])
+			return
+		case "methods":
+			runMethodsCmd(os.Args[2:])
+			return
+		case "ratecard":
+			runRateCardCmd(os.Args[2:])
+			return
+		case "call":
+			// A preset expands to the pay command's flags and URL.
+			os.Args = append([]string{os.Args[0]}, runCallCmd(os.Args[2:])...)
+		case "upload":
+			// An upload is the pay command with the file as its streamed body.
+			os.Args = append([]string{os.Args[0]}, runUploadCmd(os.Args[2:])...)
 		case "version":
 			fmt.Printf("x402-cli %s\n", version)
 			return
@@ -101,6 +360,54 @@ func main() {
 		quiet      bool
 		outputFile string
 		headers    headerFlags
+		waitConfs  bool
+		confs      uint64
+		sloLatency time.Duration
+		sloSettle  time.Duration
+		traceID    string
+		simulate   bool
+		facilURL   string
+		artDir     string
+		formData   headerFlags
+		params     headerFlags
+		tags       = tagsByEnv()
+		retryNonce int
+		strict     bool
+		repeatWin  time.Duration
+		maxWait    time.Duration
+		optimistic time.Duration
+		trustRedir bool
+		onlyHosts  string
+		facKeys    string
+		requireAtt bool
+		hostBudget headerFlags
+		include    bool
+		bundle     string
+		selMode    string
+		payNet     string
+		tier       string
+		prefer     string
+		mainnet    bool
+		extract    string
+		decode     string
+		saveAs     string
+		validJSON  bool
+		accept     string
+		acceptLang string
+		slowPay    time.Duration
+		fbProxy    string
+		fbDNS      string
+		payHdrSpec string
+		archiveDir string
+		maxSpend   string
+		maxAmount  string
+		enqueue    bool
+		sandbox    bool
+		onType     typeRoutes
+		maxPrint   int
+		fullBody   bool
+		decryptAs  string
+		decryptKey = decryptKeyByEnv()
 	)
 
 	flag.BoolVar(&insecure, "insecure", false, "Skip TLS certificate verification")
@@ -110,39 +417,139 @@ func main() {
 	flag.StringVar(&method, "X", "GET", "HTTP method (shorthand)")
 	flag.BoolVar(&showVer, "version", false, "Print version and exit")
 	flag.BoolVar(&skipVerify, "skip-verify", false, "Only send Step 1 (no payment), skip Step 2")
-	flag.StringVar(&data, "data", "", "Request body (implies POST if -X not set)")
+	flag.StringVar(&data, "data", "", "Request body (implies POST if -X not set); @- reads it from stdin")
 	flag.StringVar(&data, "d", "", "Request body (shorthand)")
+	flag.Var(&formData, "data-urlencode", "URL-encode 'name=value' (or 'value', 'name@file') into a form body, as curl does (repeatable)")
+	flag.StringVar(&accept, "accept", "", "Accept header for both steps, e.g. application/json (-H Accept wins)")
+	flag.StringVar(&acceptLang, "accept-language", "", "Accept-Language header for both steps, e.g. de-CH,de;q=0.9 (-H Accept-Language wins)")
+	flag.Var(&params, "param", "Add a query parameter 'key=value' to the URL, percent-encoded (repeatable)")
 	flag.Var(&headers, "H", "Custom header 'Key: Value' (repeatable)")
 	flag.Var(&headers, "header", "Custom header 'Key: Value' (repeatable)")
+	flag.StringVar(&payHdrSpec, "pay-headers", "all", "Which -H headers the paid request (Step 2) re-sends: all, none, only=Name,..., or except=Name,... (X-Request-ID is always sent)")
 	flag.BoolVar(&verbose, "verbose", false, "Show full request/response headers")
 	flag.BoolVar(&verbose, "v", false, "Show full request/response headers (shorthand)")
+	flag.IntVar(&maxPrint, "max-body-print", 0, "Print at most N bytes of each response body (default: 300 for the 402 response, 500 for the paid response)")
+	flag.BoolVar(&fullBody, "full-body", false, "Print response bodies in full")
+	flag.BoolVar(&include, "include", false, "Prefix the printed and saved paid response body with its status line and headers")
+	flag.BoolVar(&include, "i", false, "Prefix the printed and saved paid response body with its status line and headers (shorthand)")
 	flag.BoolVar(&dryRun, "dry-run", false, "Show payment cost and ask for confirmation before paying")
 	flag.BoolVar(&jsonOutput, "json", false, "Output structured JSON (for agents and scripts)")
 	flag.BoolVar(&autoYes, "yes", false, "Auto-confirm payment without prompting")
 	flag.BoolVar(&autoYes, "y", false, "Auto-confirm payment without prompting (shorthand)")
+	flag.DurationVar(&confirmTimeout, "confirm-timeout", confirmTimeout, "Deny a --dry-run confirmation left unanswered this long (0 waits forever; default: $X402_CONFIRM_TIMEOUT or 2m)")
 	flag.BoolVar(&quiet, "quiet", false, "Suppress human-readable output, only print JSON or exit code")
 	flag.BoolVar(&quiet, "q", false, "Suppress human-readable output (shorthand)")
 	flag.StringVar(&outputFile, "output", "", "Save response body to file")
 	flag.StringVar(&outputFile, "o", "", "Save response body to file (shorthand)")
+	flag.StringVar(&extract, "extract", "", "Extract a field from the paid JSON response, e.g. .data.result or .items[0].url (strings are printed raw)")
+	flag.StringVar(&decode, "decode", "", "Decode the (extracted) paid response: base64")
+	flag.StringVar(&archiveDir, "archive", os.Getenv("X402_ARCHIVE"), "Store every paid response in this directory, named by URL hash and time, and index it in index.jsonl (default: $X402_ARCHIVE)")
+	flag.Var(&onType, "on-type", "Route the paid response body by its Content-Type: <mime type>:print|save[=<path>]|discard, e.g. image/*:save or application/json:print; the first match wins (repeatable)")
+	flag.StringVar(&decryptAs, "decrypt", "", "Decrypt the paid response body before it is shown or saved: ecies (with the wallet key or --decrypt-key) or age")
+	flag.StringVar(&decryptKey, "decrypt-key", decryptKey, "Key for --decrypt, or a file holding it: a hex secp256k1 key for ecies, age identities for age (default: $X402_DECRYPT_KEY)")
+	flag.StringVar(&saveAs, "save-as", "", "Write the extracted and decoded paid response to this file instead of printing it")
+	flag.BoolVar(&validJSON, "validate-json", false, "Check that the request body is valid JSON before sending anything; a syntax error exits 1 with its position")
+	flag.DurationVar(&slowPay, "slow-payment", 5*time.Second, "When the paid request takes at least this long, show how much of it the facilitator and the server took")
+	flag.BoolVar(&waitConfs, "wait-confirmations", false, "After payment, wait until the settlement transaction is confirmed on-chain")
+	flag.BoolVar(&enqueue, "enqueue", false, "Sign the payment and queue it instead of sending it, for x402-cli flush to send later; offline, sign against the requirements a probe cached within 24h")
+	flag.BoolVar(&simulate, "simulate", false, "Sign the payment and submit it only to the facilitator's /verify (nothing is paid or sent to the server)")
+	flag.StringVar(&facilURL, "facilitator", x402http.DefaultFacilitatorURL, "Facilitator URL used by --simulate; comma-separate several to compare their verdicts")
+	flag.StringVar(&traceID, "trace-id", "", "Use this as the request ID sent in the X-Request-ID header on both steps (default: a new ID per run; 'auto' is the same)")
+	flag.IntVar(&retryNonce, "nonce-retries", 1, "Re-sign with a fresh nonce and retry this many times when the payment is rejected as a replay (0 disables)")
+	flag.BoolVar(&strict, "strict", false, "Refuse to pay a request already paid within --repeat-window (default: warn and pay)")
+	flag.DurationVar(&repeatWin, "repeat-window", 10*time.Minute, "How far back the payment history is checked for a repeat of the same request (0 disables)")
+	flag.StringVar(&fbProxy, "fallback-proxy", os.Getenv("X402_FALLBACK_PROXY"), "If the probe fails at the network level, retry it through this proxy (http://, https://, or socks5://host:port) and pay over the path that works (default: $X402_FALLBACK_PROXY)")
+	flag.StringVar(&fbDNS, "fallback-dns", os.Getenv("X402_FALLBACK_DNS"), "If the probe fails at the network level, retry it resolving names with this DNS server (IP[:port]), after --fallback-proxy (default: $X402_FALLBACK_DNS)")
+	flag.Var(&tags, "tag", "Store key=value with the history record of each payment, e.g. job=nightly-scrape, to filter history by (repeatable; default: $X402_TAGS)")
+	applyFreeRoutes := freeRouteFlags(flag.CommandLine)
+	flag.StringVar(&outputSchema, "output-schema", outputSchema, "JSON output structure: v1 (unversioned) or v2 (with schemaVersion; see x402-cli schema) (default: $X402_OUTPUT_SCHEMA or v1)")
+	flag.BoolVar(&signRequests, "sign-requests", signRequests, "Sign each paid request (method, URL, body hash, payment header hash) with the wallet key into the history, for disputes; check with history verify (default: $X402_SIGN_REQUESTS)")
+	flag.DurationVar(&optimistic, "optimistic", 0, "For GET and HEAD, pay against the payment requirements cached by a run within this long instead of probing first; re-pays once against new ones if they changed (0 disables)")
+	flag.DurationVar(&maxWait, "max-wait", 0, "On 429 Too Many Requests, wait per Retry-After and retry while the total wait stays within this (0 disables)")
+	flag.StringVar(&facKeys, "facilitator-keys", os.Getenv("X402_FACILITATOR_KEYS"), "Trust only these facilitator signer addresses (comma-separated) when verifying a PAYMENT-RESPONSE attestation; otherwise the keys --facilitator publishes (default: $X402_FACILITATOR_KEYS)")
+	flag.BoolVar(&requireAtt, "require-attestation", false, "Fail (exit 8) unless the PAYMENT-RESPONSE of an accepted payment carries a facilitator attestation that verifies")
+	flag.StringVar(&onlyHosts, "only-hosts", os.Getenv("X402_ONLY_HOSTS"), "Only ever pay these hosts: comma-separated names or *.domain wildcards (default: $X402_ONLY_HOSTS)")
+	flag.Var(&hostBudget, "host-budget", "Cap payments to a host per period, e.g. api.example.com=1USDC/day (repeatable; default: $X402_HOST_BUDGETS)")
+	flag.StringVar(&maxAmount, "max-amount", "", "Refuse a single payment above this price, e.g. 0.01USDC; if the paid request is answered with new requirements, re-pay only within it")
+	flag.StringVar(&maxSpend, "max-total-spend", maxTotalSpendByEnv(), "Hard ceiling on the total this invocation pays, across retries and several URLs, e.g. 0.5USDC (default: $X402_MAX_TOTAL_SPEND)")
+	flag.BoolVar(&sandbox, "sandbox", false, "For untrusted URLs: testnets only, --max-amount 0.01USDC and --max-total-spend 0.05USDC unless given, --only-hosts required, no redirects, and response bodies capped at 10 MiB")
+	flag.BoolVar(&trustRedir, "trust-redirects", false, "Follow redirects to another origin (and pay it if it asks); otherwise only with --dry-run confirmation")
+	flag.StringVar(&selMode, "select", "first", "How to choose among several payment options: first (the SDK default) or smart (funded networks, then fastest settlement in history)")
+	flag.BoolVar(&mainnet, "mainnet", mainnetAllowedByEnv(), "Allow payments on mainnet networks (real funds); without it only testnets are paid (default: $X402_ALLOW_MAINNET)")
+	flag.StringVar(&payNet, "network", "", "Only pay on this network (name such as base, or CAIP-2 ID)")
+	flag.StringVar(&tier, "tier", "", "Pay for this price tier, for servers that offer several in accepts[].extra (tier or quality)")
+	flag.StringVar(&prefer, "prefer", os.Getenv("X402_PREFER_NETWORKS"), "Comma-separated network preference used when several options are offered and --network is not set (default: $X402_PREFER_NETWORKS)")
+	flag.StringVar(&artDir, "artifacts-dir", "", "Write per-run evidence (requests, requirements, signed payment, responses, timings) under this directory")
+	flag.StringVar(&bundle, "debug-bundle", "", "Write a zip of the full exchange, decoded headers, timings, and environment (secrets redacted) to attach to bug reports")
+	flag.Uint64Var(&confs, "confirmations", 0, "Confirmations required by --wait-confirmations (default: per network; implies --wait-confirmations)")
+	flag.DurationVar(&sloLatency, "slo-latency", 0, "Exit 10 when the paid request takes longer than this, e.g. 2s, for running the CLI as an SLO probe")
+	flag.DurationVar(&sloSettle, "slo-settlement", 0, "Exit 10 when settlement takes longer than this from sending the payment, e.g. 10s (implies --wait-confirmations)")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "x402-cli %s — test x402 payment endpoints\n\n", version)
-		fmt.Fprintf(os.Stderr, "Usage:\n  x402-cli [flags] <url>\n  x402-cli wallet [--network <name>] [--json]\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n  x402-cli [flags] <url> [<url>...]\n  x402-cli wallet [--network <name>] [--json]\n  x402-cli tui [flags] <url>\n  x402-cli dashboard [flags] <endpoints.yaml>\n  x402-cli cors [flags] <url>\n  x402-cli fingerprint [flags] <url>\n  x402-cli vectors --amount <atomic> --pay-to 0x...\n  x402-cli serve --echo | --fixtures <routes.yaml> [--listen 127.0.0.1:4020]\n  x402-cli check-payment --requirements <req.json> --payment <base64>\n  x402-cli devnet up|down [--rpc <url>] [--fund 1000]\n  x402-cli fuzz [flags] <url>\n  x402-cli history [--limit N] [--json]\n  x402-cli history purge --older-than 90d\n  x402-cli history report --by-endpoint\n  x402-cli history verify <payment id>\n  x402-cli history export --format ledger|ofx\n  x402-cli history migrate --to jsonl|sqlite\n  x402-cli price-history <url>\n  x402-cli resolve <payment id>\n  x402-cli resume [<payment id>]\n  x402-cli flush [--list] [--json]\n  x402-cli batch [--report csv|md|html] <endpoints.yaml>\n  x402-cli call <preset> [--<variable> <value>]... [flags]\n  x402-cli upload <file> [flags] <url>\n  x402-cli script [--var name=value] <flow.yaml>\n  x402-cli receipt check <receipt.json>...\n  x402-cli forecast --endpoint <url> --calls N\n  x402-cli sign-typed-data <data.json>\n  x402-cli convert <atomic> --decimals 6 | --to-atomic <amount> USDC\n  x402-cli schema [--output-schema v2]\n  x402-cli ratecard --openapi <spec.yaml> [--base-url <url>]\n  x402-cli methods [--methods GET,POST,PUT,DELETE] <url>\n\n")
 		fmt.Fprintf(os.Stderr, "Examples:\n")
 		fmt.Fprintf(os.Stderr, "  x402-cli https://api.example.com/paid-endpoint\n")
 		fmt.Fprintf(os.Stderr, "  x402-cli -k https://podinfo.localhost/api/info\n")
 		fmt.Fprintf(os.Stderr, "  x402-cli -X POST -d '{\"query\": \"hello\"}' -H 'Content-Type: application/json' https://api.example.com/ask\n")
+		fmt.Fprintf(os.Stderr, "  x402-cli --param 'q=hello world' --param lang=en https://api.example.com/search\n")
 		fmt.Fprintf(os.Stderr, "  x402-cli -v --dry-run https://api.example.com/paid-endpoint\n")
 		fmt.Fprintf(os.Stderr, "  x402-cli --json -y -o response.json https://api.example.com/paid-endpoint\n")
 		fmt.Fprintf(os.Stderr, "  x402-cli wallet                          # show address + USDC balances\n")
-		fmt.Fprintf(os.Stderr, "  x402-cli wallet --network base-sepolia   # single network\n\n")
+		fmt.Fprintf(os.Stderr, "  x402-cli wallet --network base-sepolia   # single network\n")
+		fmt.Fprintf(os.Stderr, "  x402-cli tui https://api.example.com/paid-endpoint   # interactive explore-and-pay\n")
+		fmt.Fprintf(os.Stderr, "  x402-cli dashboard --snapshot status.json endpoints.yaml   # live monitoring\n")
+		fmt.Fprintf(os.Stderr, "  x402-cli cors --origin https://app.example.com https://api.example.com/paid   # browser CORS check\n")
+		fmt.Fprintf(os.Stderr, "  x402-cli fingerprint https://api.example.com/paid > stack.json   # shareable x402 stack fingerprint\n")
+		fmt.Fprintf(os.Stderr, "  x402-cli vectors --amount 1000 --pay-to 0x...   # signed payloads for server tests\n")
+		fmt.Fprintf(os.Stderr, "  x402-cli fuzz https://api.example.com/paid-endpoint   # check malformed payments are rejected\n\n")
 		fmt.Fprintf(os.Stderr, "Exit codes:\n")
 		fmt.Fprintf(os.Stderr, "  0  Success (payment accepted or probe completed)\n")
 		fmt.Fprintf(os.Stderr, "  1  Error (network, config, or unexpected failure)\n")
 		fmt.Fprintf(os.Stderr, "  2  Payment rejected by facilitator\n")
-		fmt.Fprintf(os.Stderr, "  3  Route is free (no payment needed)\n\n")
+		fmt.Fprintf(os.Stderr, "  3  Route is free (no payment needed)\n")
+		fmt.Fprintf(os.Stderr, "  4  Payment rejected: insufficient funds\n")
+		fmt.Fprintf(os.Stderr, "  5  DNS resolution failed\n")
+		fmt.Fprintf(os.Stderr, "  6  TLS handshake or certificate error\n")
+		fmt.Fprintf(os.Stderr, "  7  Request timed out\n")
+		fmt.Fprintf(os.Stderr, "  8  Facilitator error (settlement failed, unconfirmed, or facilitator unavailable)\n")
+		fmt.Fprintf(os.Stderr, "  9  Unsupported: no payment option matches a scheme/network this build can pay\n")
+		fmt.Fprintf(os.Stderr, "  10 SLO missed: accepted, but slower than --slo-latency or --slo-settlement\n\n")
 		fmt.Fprintf(os.Stderr, "Environment:\n")
-		fmt.Fprintf(os.Stderr, "  EVM_PRIVATE_KEY    Private key for signing payments (required)\n\n")
+		fmt.Fprintf(os.Stderr, "  EVM_PRIVATE_KEY    Private key for signing payments (required)\n")
+		fmt.Fprintf(os.Stderr, "  EVM_PRIVATE_KEY_<PROFILE>  Private key used with --profile <profile> (e.g. EVM_PRIVATE_KEY_STAGING)\n")
+		fmt.Fprintf(os.Stderr, "  X402_CLI_OPTS      Default flags parsed before the command line, e.g. \"--json -y --timeout 60s\"\n")
+		fmt.Fprintf(os.Stderr, "  X402_PRESETS       Endpoint presets file for 'call' (default: presets.json in the config directory)\n")
+		fmt.Fprintf(os.Stderr, "  X402_PROFILE       Default for --profile\n")
+		fmt.Fprintf(os.Stderr, "  X402_SIGNERS       Default for --signers\n")
+		fmt.Fprintf(os.Stderr, "  X402_AMOUNT_FORMAT Default for --amount-format\n")
+		fmt.Fprintf(os.Stderr, "  X402_REDACT        Default for --redact\n")
+		fmt.Fprintf(os.Stderr, "  X402_PREFER_NETWORKS  Default for --prefer (e.g. base,base-sepolia,avalanche)\n")
+		fmt.Fprintf(os.Stderr, "  X402_ALLOW_MAINNET Set to 1 to allow mainnet payments without --mainnet\n")
+		fmt.Fprintf(os.Stderr, "  X402_NO_SPEND      Set to 1 for --no-spend\n")
+		fmt.Fprintf(os.Stderr, "  X402_ONLY_HOSTS    Default for --only-hosts\n")
+		fmt.Fprintf(os.Stderr, "  X402_HOST_BUDGETS  Default for --host-budget (comma-separated)\n")
+		fmt.Fprintf(os.Stderr, "  X402_ENCRYPT_STATE Encrypt the payment history ledger at rest (key from the OS keychain)\n")
+		fmt.Fprintf(os.Stderr, "  X402_STATE_KEY     Passphrase for the encrypted ledger instead of the OS keychain\n")
+		fmt.Fprintf(os.Stderr, "  X402_PROMPT        Default for --prompt\n")
+		fmt.Fprintf(os.Stderr, "  X402_HISTORY_RETENTION  Purge ledger records older than this (e.g. 90d) after each payment\n")
+		fmt.Fprintf(os.Stderr, "  X402_FALLBACK_PROXY  Default for --fallback-proxy\n")
+		fmt.Fprintf(os.Stderr, "  X402_FALLBACK_DNS  Default for --fallback-dns\n")
+		fmt.Fprintf(os.Stderr, "  X402_ARCHIVE       Default for --archive\n")
+		fmt.Fprintf(os.Stderr, "  X402_MAX_TOTAL_SPEND  Default for --max-total-spend (also for batch and script)\n")
+		fmt.Fprintf(os.Stderr, "  X402_SIGN_REQUESTS Set to 1 for --sign-requests\n")
+		fmt.Fprintf(os.Stderr, "  X402_BALANCE_TTL   How long balances are cached (default 30s; 0 disables)\n")
+		fmt.Fprintf(os.Stderr, "  X402_STATSD        Default for --statsd; X402_STATSD_PREFIX names the metrics (default x402)\n\n")
+		fmt.Fprintf(os.Stderr, "Global flags:\n")
+		fmt.Fprintf(os.Stderr, "  --profile <name>   Sign with EVM_PRIVATE_KEY_<NAME> instead of EVM_PRIVATE_KEY (any subcommand)\n")
+		fmt.Fprintf(os.Stderr, "  --signers <rules>  Pay each network from a profile's key, e.g. base=treasury,avalanche=ops,*=default\n")
+		fmt.Fprintf(os.Stderr, "  --amount-format <spec>  How amounts are shown, e.g. locale=de,decimals=2 (settings: locale=plain|en|de|fr|ch|...,\n")
+		fmt.Fprintf(os.Stderr, "                     decimals=auto|N, thousands=none|comma|dot|space|apostrophe|underscore, point=dot|comma)\n")
+		fmt.Fprintf(os.Stderr, "  --no-spend         Rehearse: sign payments but never send them; they are answered as accepted (pay, batch, script, tui)\n")
+		fmt.Fprintf(os.Stderr, "  --redact standard|strict  Shorten addresses and drop bodies and query strings in output for shared logs\n")
+		fmt.Fprintf(os.Stderr, "  --statsd <host:port>  Send payment counters, spend, and latency to StatsD over UDP (pay, batch, script)\n")
+		fmt.Fprintf(os.Stderr, "  --prompt stdin|tty[:<device>]|pinentry[:<program>]  Ask confirmations and passphrases on stdin, on a TTY\n")
+		fmt.Fprintf(os.Stderr, "                     device (default /dev/tty), or in a pinentry dialog, for GUIs and sandboxes without stdin\n\n")
 		fmt.Fprintf(os.Stderr, "Flags:\n")
 		flag.PrintDefaults()
 	}
@@ -152,7 +559,13 @@ func main() {
 		os.Exit(0)
 	}
 
-	flag.Parse()
+	// X402_CLI_OPTS is parsed first, so the command line overrides its values (repeatable
+	// flags such as -H collect from both).
+	if err := flag.CommandLine.Parse(opts); err == nil && flag.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Error: X402_CLI_OPTS may only contain flags, found %q\n", flag.Arg(0))
+		os.Exit(ExitError)
+	}
+	flag.CommandLine.Parse(os.Args[1:])
 
 	if showVer {
 		if jsonOutput {
@@ -184,6 +597,181 @@ func main() {
 		os.Exit(ExitError)
 	}
 
+	if len(params) > 0 {
+		withParams, err := addQueryParams(endpoint, params)
+		if err != nil {
+			if jsonOutput {
+				exitJSON(&jsonResult{Version: version, Status: "error", Error: err.Error()}, ExitError)
+			}
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitError)
+		}
+		endpoint = withParams
+	}
+
+	fromStdin := data == stdinData
+	if len(formData) > 0 {
+		encoded, err := encodeFormData(formData)
+		if err != nil {
+			if jsonOutput {
+				exitJSON(&jsonResult{Version: version, Status: "error", Error: err.Error()}, ExitError)
+			}
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitError)
+		}
+		if data != "" {
+			data += "&"
+		}
+		data += encoded
+		if !hasHeader(headers, "Content-Type") {
+			headers = append(headers, "Content-Type: "+formContentType)
+		}
+	}
+
+	headers = withNegotiation(headers, accept, acceptLang)
+
+	if fromStdin {
+		var msg string
+		switch {
+		case len(formData) > 0:
+			msg = "-d @- cannot be combined with --data-urlencode"
+		case validJSON:
+			msg = "--validate-json cannot check a body streamed from stdin (-d @-)"
+		case dryRun && !autoYes && uploadBody == nil:
+			msg = "-d @- reads the body from stdin, so --dry-run cannot prompt for confirmation; add -y"
+		case flag.NArg() > 1:
+			msg = "-d @- cannot be used with several URLs"
+		}
+		if msg != "" {
+			if jsonOutput {
+				exitJSON(&jsonResult{Version: version, Status: "error", Error: msg}, ExitError)
+			}
+			fmt.Fprintf(os.Stderr, "Error: %s\n", msg)
+			os.Exit(ExitError)
+		}
+	}
+
+	if validJSON {
+		if err := validateJSON(data); err != nil {
+			if jsonOutput {
+				exitJSON(&jsonResult{Version: version, Status: "error", Error: err.Error()}, ExitError)
+			}
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitError)
+		}
+	}
+
+	if extract != "" {
+		if _, err := parseFieldPath(extract); err != nil {
+			if jsonOutput {
+				exitJSON(&jsonResult{Version: version, Status: "error", Error: err.Error()}, ExitError)
+			}
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitError)
+		}
+	}
+	if decode != "" && !slices.Contains(decodings, decode) {
+		errMsg := fmt.Sprintf("--decode must be one of %s", strings.Join(decodings, ", "))
+		if jsonOutput {
+			exitJSON(&jsonResult{Version: version, Status: "error", Error: errMsg}, ExitError)
+		}
+		fmt.Fprintf(os.Stderr, "Error: %s\n", errMsg)
+		os.Exit(ExitError)
+	}
+
+	if maxPrint < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --max-body-print must not be negative\n")
+		os.Exit(ExitError)
+	}
+	// bodyLimit is how much of a response body human output prints, def bytes unless
+	// --max-body-print or --full-body say otherwise.
+	bodyLimit := func(def int) int {
+		switch {
+		case fullBody:
+			return -1
+		case maxPrint > 0:
+			return maxPrint
+		}
+		return def
+	}
+
+	if !slices.Contains(selectModes, selMode) {
+		errMsg := fmt.Sprintf("--select must be one of %s", strings.Join(selectModes, ", "))
+		if jsonOutput {
+			exitJSON(&jsonResult{Version: version, Status: "error", Error: errMsg}, ExitError)
+		}
+		fmt.Fprintf(os.Stderr, "Error: %s\n", errMsg)
+		os.Exit(ExitError)
+	}
+
+	preferred, err := parseNetworkList(prefer)
+	if err == nil && payNet != "" {
+		var only []string
+		if only, err = parseNetworkList(payNet); err == nil && len(only) != 1 {
+			err = fmt.Errorf("--network takes a single network")
+		}
+		if err == nil {
+			payNet = only[0]
+		}
+	}
+	if err != nil {
+		if jsonOutput {
+			exitJSON(&jsonResult{Version: version, Status: "error", Error: err.Error()}, ExitError)
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+
+	budgets, err := parseHostBudgets(hostBudget, os.Getenv("X402_HOST_BUDGETS"))
+	if err == nil && presetBudget != "" {
+		// A preset's budget applies on top of --host-budget and X402_HOST_BUDGETS.
+		b, perr := parseHostBudget(presetBudget)
+		budgets, err = append(budgets, b), perr
+	}
+	// --sandbox tightens the guards before they are parsed.
+	var responseCap int64
+	if err == nil && sandbox {
+		err = checkSandbox(onlyHosts, explicitFlags("mainnet", "trust-redirects"), flag.NArg())
+		mainnet, trustRedir, responseCap = false, false, sandboxMaxResponse
+		if maxAmount == "" {
+			maxAmount = sandboxMaxAmount
+		}
+		if maxSpend == "" {
+			maxSpend = sandboxMaxSpend
+		}
+	}
+	var ceiling *spendCeiling
+	if err == nil {
+		ceiling, err = newSpendCeiling(maxSpend)
+	}
+	var amountLimit *big.Rat
+	if err == nil {
+		amountLimit, err = parseMaxAmount(maxAmount)
+	}
+	var pinnedKeys []string
+	if err == nil {
+		pinnedKeys, err = parseFacilitatorKeys(facKeys)
+	}
+	if err == nil {
+		err = checkOutputSchema(outputSchema)
+	}
+	if err == nil {
+		recordTags, err = parseTags(tags)
+	}
+	if err == nil {
+		err = applyFreeRoutes()
+	}
+	if err == nil && enqueue && noSpend {
+		err = errors.New("--enqueue cannot be combined with --no-spend")
+	}
+	if err != nil {
+		if jsonOutput {
+			exitJSON(&jsonResult{Version: version, Status: "error", Error: err.Error()}, ExitError)
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+
 	// If -d is set and method was not explicitly changed, default to POST.
 	if data != "" && method == "GET" {
 		method = "POST"
@@ -192,37 +780,152 @@ func main() {
 	// quiet implies no human-readable output (JSON still prints).
 	log := func(format string, a ...any) {
 		if !quiet && !jsonOutput {
-			fmt.Printf(format, a...)
+			fmt.Print(redactText(fmt.Sprintf(format, a...)))
 		}
 	}
 	logln := func(a ...any) {
 		if !quiet && !jsonOutput {
-			fmt.Println(a...)
+			fmt.Print(redactText(fmt.Sprintln(a...)))
 		}
 	}
 
-	privateKey := os.Getenv("EVM_PRIVATE_KEY")
+	privateKey := privateKeyFromEnv()
+
+	// The key is loaded before anything is paid, so a bad one costs nothing.
+	var decrypter *responseDecrypter
+	if decryptAs != "" {
+		if decrypter, err = newResponseDecrypter(decryptAs, decryptKey, privateKey); err != nil {
+			if jsonOutput {
+				exitJSON(&jsonResult{Version: version, Status: "error", Error: err.Error()}, ExitError)
+			}
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitError)
+		}
+	}
 
 	transport := &http.Transport{}
 	if insecure {
 		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	}
+	var payHdrs payHeaderPolicy
+	fallbacks, err := fallbackPaths(fbProxy, fbDNS)
+	if err == nil {
+		payHdrs, err = parsePayHeaders(payHdrSpec)
+	}
+	if err != nil {
+		if jsonOutput {
+			exitJSON(&jsonResult{Version: version, Status: "error", Error: err.Error()}, ExitError)
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
 
 	// Build JSON result for --json mode.
+	if traceID == "auto" {
+		traceID = newTraceID()
+	}
+	switch {
+	case traceID != "":
+		requestID = traceID
+	case hasHeader(headers, traceHeader):
+		// -H X-Request-ID: ... names the request ID itself.
+		requestID = headerValue(headers, traceHeader)
+	}
+	if !hasHeader(headers, traceHeader) {
+		headers = append(headers, traceHeader+": "+requestID)
+	}
+
+	// Several URLs are requested concurrently, as a batch without an endpoints file.
+	if flag.NArg() > 1 {
+		urls := flag.Args()
+		for i, u := range urls {
+			if len(params) > 0 {
+				if withParams, err := addQueryParams(u, params); err == nil {
+					urls[i] = withParams
+				}
+			}
+		}
+		runMultiURL(urls, method, data, headers, insecure, onlyHosts,
+			batchOptions{timeout: timeout, dryRun: dryRun, trustRedirects: trustRedir, budgets: budgets, mainnet: mainnet, ceiling: ceiling}, jsonOutput, quiet)
+	}
+
 	result := &jsonResult{
-		Version:  version,
-		Endpoint: endpoint,
-		Method:   method,
+		Version:   version,
+		Endpoint:  endpoint,
+		Method:    method,
+		RequestID: requestID,
+		TraceID:   traceID,
+	}
+
+	if artDir != "" || bundle != "" {
+		id := artifactID(traceID, requestID)
+		root := artDir
+		if root == "" {
+			tmp, err := os.MkdirTemp("", "x402-debug-")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: failed to create debug bundle directory: %v\n", err)
+				os.Exit(ExitError)
+			}
+			root = tmp
+		}
+		recorder, err := newArtifactRecorder(root, id, result)
+		if err != nil {
+			if jsonOutput {
+				result.Status = "error"
+				result.Error = "failed to create artifacts directory: " + err.Error()
+				exitJSON(result, ExitError)
+			}
+			fmt.Fprintf(os.Stderr, "Error: failed to create artifacts directory: %v\n", err)
+			os.Exit(ExitError)
+		}
+		artifacts = recorder
+		if artDir != "" {
+			result.ArtifactsDir = recorder.dir
+		}
+		if bundle != "" {
+			recorder.bundle = bundle
+			recorder.tempDir = artDir == ""
+			result.DebugBundle = bundle
+		}
 	}
 
 	log("x402-cli %s\n", version)
+	log("Request ID: %s\n", requestID)
 	log("Endpoint: %s\n", endpoint)
-	log("Method:   %s\n\n", method)
+	log("Method:   %s\n", method)
+	if result.ArtifactsDir != "" {
+		log("Artifacts: %s\n", artifacts.dir)
+	}
+	if bundle != "" {
+		log("Debug bundle: %s\n", bundle)
+	}
+	log("\n")
 
 	// --- Step 1: Request without payment → expect 402 ---
 	logln("--- Step 1: Request without payment ---")
 
-	plainClient := &http.Client{Transport: transport, Timeout: timeout}
+	// A redirect to another origin is never paid without --trust-redirects or confirmation.
+	var confirm func(from, to string) bool
+	if dryRun && !autoYes && !jsonOutput {
+		confirm = confirmRedirect
+	}
+	redirects := newRedirectPolicy(trustRedir, confirm, &result.Redirects)
+	redirects.none = sandbox
+
+	plainClient := &http.Client{Transport: capResponses(transport, responseCap), Timeout: timeout, CheckRedirect: redirects.checkRedirect("probe")}
+	if fromStdin && uploadBody != nil {
+		stdinSpool = uploadBody
+	} else if fromStdin {
+		if stdinSpool, err = newStdinBody(os.Stdin); err != nil {
+			result.Status = "error"
+			result.Error = "cannot spool the request body: " + err.Error()
+			if jsonOutput {
+				exitJSON(result, ExitError)
+			}
+			fmt.Fprintf(os.Stderr, "Error: %s\n", result.Error)
+			exit(ExitError)
+		}
+	}
 	req, err := newRequest(method, endpoint, data, headers)
 	if err != nil {
 		if jsonOutput {
@@ -231,35 +934,121 @@ func main() {
 			exitJSON(result, ExitError)
 		}
 		fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
-		os.Exit(ExitError)
+		exit(ExitError)
 	}
 
 	if verbose && !quiet && !jsonOutput {
 		dumpRequest(req)
 	}
+	artifacts.writeRequest(req, data)
+
+	// rateLimited waits out a 429 if --max-wait allows it, reporting whether to retry.
+	var waited time.Duration
+	rateLimited := func(step string, resp *http.Response) bool {
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return false
+		}
+		retries, waitedMs := 0, int64(0)
+		if result.RateLimit != nil {
+			retries, waitedMs = result.RateLimit.Retries, result.RateLimit.WaitedMs
+		}
+		result.RateLimit = newRateLimitInfo(step, resp)
+		result.RateLimit.Retries, result.RateLimit.WaitedMs = retries, waitedMs
+		wait, ok := rateLimitWait(resp, waited, maxWait, time.Now())
+		if !ok || !retrySafety(method, req.Header, step == "payment", resp, nil).Safe {
+			return false
+		}
+		log("Rate limited (429); retrying in %s...\n", wait)
+		time.Sleep(wait)
+		waited += wait
+		result.RateLimit.Retries++
+		result.RateLimit.WaitedMs = waited.Milliseconds()
+		return true
+	}
 
-	resp, err := plainClient.Do(req)
+	var probeWait serverWait
+	started := time.Now()
+	// With --optimistic, cached requirements stand in for the probe and the payment is the
+	// only request sent; requirementsChanged allows one fresh start if they are stale.
+	cached := lookupRequirements(method, endpoint, optimistic)
+	requirementsChanged := false
+	var resp *http.Response
+	if cached != nil {
+		log("Using the payment requirements %s (--optimistic); not probing.\n", cacheAge(cached.Time))
+		resp = cached.response(req)
+	} else {
+		resp, err = plainClient.Do(req.WithContext(probeWait.trace(req.Context())))
+	}
+	for err == nil && rateLimited("probe", resp) {
+		resp.Body.Close()
+		req, _ = newRequest(method, endpoint, data, headers)
+		resp, err = plainClient.Do(req.WithContext(probeWait.trace(req.Context())))
+	}
+	// A network-level failure is retried over the fallback paths; the first that works is kept
+	// for the payment, so "works from my laptop, fails from CI" shows which egress is broken.
+	if err != nil && len(fallbacks) > 0 && isNetworkError(err) {
+		result.Egress = &egressReport{}
+		result.Egress.record(egressPath{Kind: "direct"}, err)
+		if retry := retrySafety(method, req.Header, false, nil, err); !retry.Safe {
+			result.Egress.Skipped = retry.Reason
+			log("Probe failed (%v); not retrying via the fallbacks: %s.\n", err, retry.Reason)
+			fallbacks = nil
+		}
+		for _, p := range fallbacks {
+			log("Probe failed (%v); retrying via %s...\n", err, p)
+			t := p.transport(transport)
+			plainClient.Transport = capResponses(t, responseCap)
+			req, _ = newRequest(method, endpoint, data, headers)
+			resp, err = plainClient.Do(req.WithContext(probeWait.trace(req.Context())))
+			for err == nil && rateLimited("probe", resp) {
+				resp.Body.Close()
+				req, _ = newRequest(method, endpoint, data, headers)
+				resp, err = plainClient.Do(req.WithContext(probeWait.trace(req.Context())))
+			}
+			result.Egress.record(p, err)
+			if err == nil {
+				log("Probe succeeded via %s; paying over the same path.\n", p)
+				transport = t
+				break
+			}
+		}
+	}
+	// Offline, --enqueue signs against the requirements an earlier probe of the endpoint cached.
+	if err != nil && enqueue && isNetworkError(err) {
+		if c := cachedRequirementsFor(method, endpoint, enqueueMaxAge); c != nil {
+			log("Probe failed (%v); queueing against the payment requirements %s.\n", err, cacheAge(c.Time))
+			cached, resp, err = c, c.response(req), nil
+		}
+	}
 	if err != nil {
+		code, kind := classifyError(err)
 		if jsonOutput {
 			result.Status = "error"
 			result.Error = err.Error()
-			exitJSON(result, ExitError)
+			result.ErrorType = kind
+			exitJSON(result, code)
 		}
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(ExitError)
+		exit(code)
 	}
 	body, _ := io.ReadAll(resp.Body)
 	resp.Body.Close()
+	artifacts.mark("probe", time.Since(started))
+	artifacts.writeResponse("probe", resp, body)
 
 	if verbose && !quiet && !jsonOutput {
 		dumpResponse(resp, body)
 	}
 
+probed:
 	// Build probe result.
 	probe := &probeResult{
 		StatusCode:      resp.StatusCode,
 		PaymentRequired: resp.StatusCode == http.StatusPaymentRequired,
 	}
+	if cached != nil {
+		probe.CachedAt = &cached.Time
+	}
 	if payReqHeader := resp.Header.Get("PAYMENT-REQUIRED"); payReqHeader != "" {
 		if decoded, err := base64.StdEncoding.DecodeString(payReqHeader); err == nil {
 			raw := json.RawMessage(decoded)
@@ -272,28 +1061,79 @@ func main() {
 	if !jsonOutput {
 		log("Status: %d\n", resp.StatusCode)
 		if !verbose {
-			log("Body: %s\n\n", truncate(string(body), 300))
+			log("Body: %s\n\n", shownBody(body, bodyLimit(300)))
 		}
 	}
 	probe.Body = string(body)
 	result.Probe = probe
 
+	if hop := redirects.blocked(); hop != nil {
+		exitRedirectBlocked(result, hop, jsonOutput, log)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		exitRateLimited(result, jsonOutput, log)
+	}
 	if resp.StatusCode != http.StatusPaymentRequired {
 		logln("Endpoint did not return 402 Payment Required.")
 		if resp.StatusCode == http.StatusOK {
 			logln("The endpoint is accessible without payment (free route).")
+			if result.FreeTier = parseFreeTier("probe", resp.Header, true); result.FreeTier != nil {
+				result.FreeTier.Price = lastKnownPrice(endpoint)
+				log("Free tier: %s\n", result.FreeTier.describe())
+			}
 			saveOutput(outputFile, body)
 			result.Status = "free"
+			code := ExitFreeRoute
+			switch freeRoutes {
+			case freeRoutesSuccess:
+				code = ExitSuccess
+			case freeRoutesFail:
+				code = ExitError
+			}
 			if jsonOutput {
-				exitJSON(result, ExitFreeRoute)
+				exitJSON(result, code)
 			}
-			os.Exit(ExitFreeRoute)
+			exit(code)
 		}
 		result.Status = "no_402"
 		if jsonOutput {
 			exitJSON(result, ExitSuccess)
 		}
-		os.Exit(ExitSuccess)
+		exit(ExitSuccess)
+	}
+
+	requirements := body
+	if probe.PaymentRequirements != nil {
+		requirements = *probe.PaymentRequirements
+	}
+	artifacts.write("requirements.json", requirements)
+	// A failed payment comes with the provider's contact, when the requirements name one.
+	if result.Support = parseSupportContact(requirements); result.Support != nil && !jsonOutput && !quiet {
+		supportNote = supportHint(result.Support)
+	}
+	if result.FreeTier = parseFreeTier("probe", resp.Header, false); result.FreeTier != nil {
+		if required, err := decodeRequirements(resp, body); err == nil && len(required.Accepts) > 0 {
+			result.FreeTier.Price = tokenAmount(required.Accepts[0])
+		}
+		log("Free tier: %s\n", result.FreeTier.describe())
+	}
+	if required, err := decodeRequirements(resp, body); err == nil && cached == nil {
+		observePrices(endpoint, required.Accepts)
+		if optimistic > 0 && optimisticMethod(method) {
+			rememberRequirements(method, endpoint, resp, body, optimistic)
+		} else if enqueue {
+			rememberRequirements(method, endpoint, resp, body, enqueueMaxAge)
+		}
+	}
+	if caps, err := capabilityMatrix(requirements); err == nil {
+		probe.Capabilities = caps
+		if !quiet && !jsonOutput {
+			printCapabilities(caps)
+		}
+	}
+	payable := len(probe.Capabilities) == 0
+	for _, c := range probe.Capabilities {
+		payable = payable || c.Supported
 	}
 
 	if skipVerify {
@@ -302,42 +1142,65 @@ func main() {
 		if jsonOutput {
 			exitJSON(result, ExitSuccess)
 		}
-		os.Exit(ExitSuccess)
+		exit(ExitSuccess)
+	}
+
+	if !payable {
+		logln("None of the server's payment options can be paid by this build (see capabilities above).")
+		result.Status = "unsupported"
+		var reasons []string
+		for _, c := range probe.Capabilities {
+			if !slices.Contains(reasons, c.Reason) {
+				reasons = append(reasons, c.Reason)
+			}
+		}
+		result.Error = "no supported payment option: " + strings.Join(reasons, "; ")
+		if jsonOutput {
+			exitJSON(result, ExitUnsupported)
+		}
+		exit(ExitUnsupported)
 	}
 
 	// --- Dry-run: show cost and confirm ---
 	if dryRun && !autoYes {
+		var payer string
+		if key, err := loadPrivateKey(); err == nil {
+			payer = crypto.PubkeyToAddress(key.PublicKey).Hex()
+			probe.Affordability, _ = affordabilityMatrix(requirements, payer, tokenBalance)
+		}
 		if jsonOutput {
 			// In JSON mode, dry-run without -y just returns the requirements.
 			result.Status = "payment_required"
 			exitJSON(result, ExitSuccess)
 		}
-		printPaymentSummary(body)
-		fmt.Print("\nProceed with payment? [y/N] ")
-		scanner := bufio.NewScanner(os.Stdin)
-		if !scanner.Scan() || !strings.HasPrefix(strings.ToLower(strings.TrimSpace(scanner.Text())), "y") {
+		printPaymentSummary(requirements)
+		if payer != "" {
+			fmt.Printf("\nPaying from: %s\n", describeWallet(payer))
+			printAffordability(probe.Affordability)
+		}
+		if !confirmPrompt("\nProceed with payment? [y/N] ") {
 			fmt.Println("Aborted.")
-			os.Exit(ExitSuccess)
+			exit(ExitSuccess)
 		}
 		fmt.Println()
 	}
 
 	// --- Step 2: Request with x402 payment ---
 	if privateKey == "" {
-		errMsg := "EVM_PRIVATE_KEY is required for Step 2 (payment)"
+		errMsg := privateKeyVar() + " is required for Step 2 (payment)"
 		if jsonOutput {
 			result.Status = "error"
 			result.Error = errMsg
 			exitJSON(result, ExitError)
 		}
 		fmt.Fprintln(os.Stderr, "\nError: "+errMsg+".")
-		fmt.Fprintln(os.Stderr, "Set it with: export EVM_PRIVATE_KEY=0x...")
-		os.Exit(ExitError)
+		fmt.Fprintf(os.Stderr, "Set it with: export %s=0x...\n", privateKeyVar())
+		exit(ExitError)
 	}
 
 	logln("--- Step 2: Request with x402 payment ---")
 
-	evmSigner, err := evmsigners.NewClientSignerFromPrivateKey(privateKey)
+	evmSigner, err := signerFor(privateKey)
 	if err != nil {
 		if jsonOutput {
 			result.Status = "error"
@@ -345,35 +1208,385 @@ func main() {
 			exitJSON(result, ExitError)
 		}
 		fmt.Fprintf(os.Stderr, "Failed to create signer: %v\n", err)
-		os.Exit(ExitError)
+		exit(ExitError)
 	}
 	log("Signer: %s\n", evmSigner.Address())
+	log("Paying from: %s\n", describeWallet(evmSigner.Address()))
+	if profile != "" {
+		log("Profile: %s (%s)\n", profile, privateKeyVar())
+	}
+	for _, network := range slices.Sorted(maps.Keys(routedSigners)) {
+		log("Signer for %s: %s (%s)\n", networkName(network), routedSigners[network].Address(), profileKeyVar(routedProfiles[network]))
+	}
 
-	x402Client := x402.Newx402Client().
-		Register("eip155:*", evm.NewExactEvmScheme(evmSigner))
+	// Pre-flight: refuse to sign if the wallet cannot cover any accepted option.
+	if short := checkBalance(evmSigner.Address(), requirements); short != "" && noSpend {
+		log("Insufficient funds (ignored by --no-spend): %s\n", short)
+	} else if short != "" {
+		log("Insufficient funds: %s\n", short)
+		logln("Fund the signer wallet and retry. Check balances with: x402-cli wallet")
+		result.Status = "insufficient_funds"
+		result.Error = short
+		result.FundingLinks = fundingLinks(evmSigner.Address(), requirements)
+		printFundingLinks(log, result.FundingLinks)
+		if jsonOutput {
+			exitJSON(result, ExitInsufficientFunds)
+		}
+		exit(ExitInsufficientFunds)
+	}
 
-	httpClient := x402http.WrapHTTPClientWithPayment(
-		&http.Client{Transport: transport, Timeout: timeout},
-		x402http.Newx402HTTPClient(x402Client),
-	)
+	// --network, --prefer, --select smart, and the mainnet guard choose among the offered
+	// options; otherwise the SDK pays the first one it supports.
+	var chosen *x402.PaymentRequirements
+	var clientOpts []x402.ClientOption
+	if required, err := decodeRequirements(resp, body); err == nil {
+		accepts, err := orderAccepts(required.Accepts, payNet, preferred)
+		if err == nil && tier != "" {
+			accepts, err = filterTier(accepts, tier)
+		}
+		if err != nil {
+			log("%s.\n", err)
+			result.Status = "unsupported"
+			result.Error = err.Error()
+			if jsonOutput {
+				exitJSON(result, ExitUnsupported)
+			}
+			exit(ExitUnsupported)
+		}
+		guarded := false
+		if !mainnet {
+			testnets := testnetAccepts(accepts)
+			if _, ok := fuzzTarget(testnets); !ok {
+				if _, ok := fuzzTarget(accepts); ok {
+					log("Refusing to pay: %s.\n", mainnetRefusal)
+					result.Status = "mainnet_not_allowed"
+					result.Error = mainnetRefusal
+					if jsonOutput {
+						exitJSON(result, ExitError)
+					}
+					exit(ExitError)
+				}
+			}
+			guarded = len(testnets) < len(accepts)
+			accepts = testnets
+		}
+		switch {
+		case selMode == "smart":
+			records, _ := loadHistory()
+			if sel, req, ok := smartRequirement(evmSigner.Address(), accepts, records); ok {
+				result.Selection = sel
+				chosen = &req
+			}
+		case payNet != "" || tier != "" || len(preferred) > 0 || guarded:
+			req, ok := fuzzTarget(accepts)
+			if !ok {
+				break
+			}
+			reason := "preferred network"
+			switch {
+			case payNet != "":
+				reason = "--network " + networkName(payNet)
+			case tier != "":
+				reason = "--tier " + tier
+			case len(preferred) == 0:
+				reason = "testnet only; pass --mainnet to pay on mainnet"
+			}
+			result.Selection = &selectionResult{Mode: "first", Network: req.Network, Asset: req.Asset, Amount: req.Amount, Reason: reason}
+			chosen = &req
+		}
+		if chosen == nil && (payNet != "" || tier != "") {
+			msg := fmt.Sprintf("no option on %s can be paid by this build", networkName(payNet))
+			if payNet == "" {
+				msg = fmt.Sprintf("no option in the %q tier can be paid by this build", tier)
+			}
+			log("%s.\n", msg)
+			result.Status = "unsupported"
+			result.Error = msg
+			if jsonOutput {
+				exitJSON(result, ExitUnsupported)
+			}
+			exit(ExitUnsupported)
+		}
+		if chosen != nil {
+			clientOpts = append(clientOpts, selectRequirement(*chosen))
+			result.Selection.Tier, _ = acceptTier(chosen.Extra)
+			if result.Selection.Tier != "" {
+				log("Selected: %s on %s, tier %s (%s)\n", describeAmount(*chosen), networkName(chosen.Network), describeTier(chosen.Extra), result.Selection.Reason)
+			} else {
+				log("Selected: %s on %s (%s)\n", describeAmount(*chosen), networkName(chosen.Network), result.Selection.Reason)
+			}
+		}
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+	allowedHosts := parseHostAllowlist(onlyHosts)
+	if u, err := url.Parse(endpoint); err == nil && !allowedHosts.allows(u.Hostname()) {
+		log("Refusing to pay %s: it is not in --only-hosts (%s).\n", u.Hostname(), strings.Join(allowedHosts, ","))
+		result.Status = "host_not_allowed"
+		result.Error = fmt.Sprintf("host %s is not in --only-hosts", u.Hostname())
+		if jsonOutput {
+			exitJSON(result, ExitError)
+		}
+		exit(ExitError)
+	}
 
-	req2, _ := newRequestWithContext(ctx, method, endpoint, data, headers)
-	resp2, err := httpClient.Do(req2)
+	var price x402.PaymentRequirements
+	if chosen != nil {
+		price = *chosen
+	} else if required, err := decodeRequirements(resp, body); err == nil {
+		price, _ = fuzzTarget(required.Accepts)
+	}
+
+	// --simulate signs the same option a real payment would, with the same routed signer.
+	if simulate {
+		var urls []string
+		for _, u := range strings.Split(facilURL, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				urls = append(urls, u)
+			}
+		}
+		simSigner := routedSigner(price.Network, evmSigner)
+		if len(urls) > 1 {
+			runFacilitatorComparison(result, simSigner, resp, body, price, urls, timeout, jsonOutput, log)
+		}
+		runSimulation(result, simSigner, resp, body, price, facilURL, timeout, jsonOutput, log)
+	}
+	if len(budgets) > 0 {
+		records, err := loadHistory()
+		if err != nil {
+			// Budgets cannot be enforced without the ledger, so refuse rather than overspend.
+			log("Refusing to pay: cannot check host budgets: %v\n", err)
+			result.Status = "error"
+			result.Error = "cannot check host budgets: " + err.Error()
+			if jsonOutput {
+				exitJSON(result, ExitError)
+			}
+			exit(ExitError)
+		}
+		if err := checkHostBudgets(budgets, records, endpoint, price, time.Now()); err != nil {
+			log("Refusing to pay: %v\n", err)
+			result.Status = "budget_exceeded"
+			result.Error = err.Error()
+			if jsonOutput {
+				exitJSON(result, ExitError)
+			}
+			exit(ExitError)
+		}
+	}
+
+	payerAddr := routedAddress(price.Network, evmSigner.Address())
+
+	if err := checkMaxAmount(amountLimit, price); err != nil {
+		log("Refusing to pay: %v\n", err)
+		result.Status = "budget_exceeded"
+		result.Error = err.Error()
+		if jsonOutput {
+			exitJSON(result, ExitError)
+		}
+		exit(ExitError)
+	}
+
+	releaseCeiling, err := ceiling.reserve(price)
 	if err != nil {
+		log("Refusing to pay: %v\n", err)
+		result.Status = "budget_exceeded"
+		result.Error = err.Error()
 		if jsonOutput {
-			result.Status = "error"
-			result.Error = "payment request failed: " + err.Error()
 			exitJSON(result, ExitError)
 		}
-		fmt.Fprintf(os.Stderr, "Payment request failed: %v\n", err)
-		os.Exit(ExitError)
+		exit(ExitError)
 	}
-	defer resp2.Body.Close()
 
-	body2, _ := io.ReadAll(resp2.Body)
+	if err := checkPayTo(price); err != nil {
+		log("Refusing to pay: %v\n", err)
+		result.Status = "error"
+		result.Error = err.Error()
+		if jsonOutput {
+			exitJSON(result, ExitError)
+		}
+		exit(ExitError)
+	}
+
+	// A key from `wallet delegate` only pays within the bounds it was created with.
+	if status, err := checkDelegatedSigner(payerAddr, endpoint, price); err != nil {
+		log("Refusing to pay: %v\n", err)
+		result.Status = status
+		result.Error = err.Error()
+		if jsonOutput {
+			exitJSON(result, ExitError)
+		}
+		exit(ExitError)
+	}
+
+	// Guard against agents stuck in retry loops paying for the same request twice.
+	if repeatWin > 0 {
+		records, err := loadHistory()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot check payment history for repeats: %v\n", err)
+		}
+		if prev := recentPayment(records, endpoint, method, data, repeatWin, time.Now()); prev != nil {
+			msg := fmt.Sprintf("%s %s was already paid at %s (transaction %s)",
+				method, endpoint, prev.Time.Local().Format(time.RFC3339), dashIfEmpty(prev.Transaction))
+			if strict {
+				log("Refusing to pay again: %s. Wait %s or rerun without --strict.\n", msg, repeatWin)
+				result.Status = "duplicate"
+				result.Error = "duplicate payment: " + msg
+				if jsonOutput {
+					exitJSON(result, ExitError)
+				}
+				exit(ExitError)
+			}
+			fmt.Fprintf(os.Stderr, "Warning: %s; paying again.\n", msg)
+		}
+	}
+
+	paidHeaders, dropped := payHdrs.filter(headers)
+	// The payment's progress is saved so that an interrupted run can be finished with `resume`.
+	if !noSpend {
+		if p, err := newInflight(endpoint, method, data, paidHeaders, price, traceID); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot save the payment state (resume will not be possible): %v\n", err)
+		} else {
+			inflight = p
+			log("Payment ID: %s (if this run is interrupted, finish it with: x402-cli resume %s)\n", p.ID, p.ID)
+		}
+	}
+
+	if enqueue {
+		err := errors.New("cannot save the payment state")
+		if inflight != nil {
+			err = enqueuePayment(inflight, evmSigner, queuedRequirements(cached, resp, body), allowedHosts, mainnet, timeout, clientOpts...)
+		}
+		if err != nil {
+			log("Cannot queue the payment: %v\n", err)
+			result.Status = "error"
+			result.Error = "cannot queue the payment: " + err.Error()
+			if jsonOutput {
+				exitJSON(result, ExitError)
+			}
+			exit(ExitError)
+		}
+		log("Queued payment %s; send it with: x402-cli flush\n", inflight.ID)
+		result.Status = queuedState
+		result.PaymentID = inflight.ID
+		inflight = nil
+		if jsonOutput {
+			exitJSON(result, ExitSuccess)
+		}
+		exit(ExitSuccess)
+	}
+
+	var sentPayment string
+	httpClient := newPaymentClient(evmSigner, cached.transport(onPaymentHeader(inflight.transport(artifacts.transport(allowedHosts.transport(mainnetGuard(noSpendTransport(capResponses(transport, responseCap)), mainnet)))), func(header string) {
+		sentPayment = header
+	})), timeout, clientOpts...)
+	httpClient.CheckRedirect = redirects.checkRedirect("payment")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+maxWait)
+	defer cancel()
+
+	var (
+		resp2    *http.Response
+		body2    []byte
+		retries  int
+		streamed bool
+	)
+	if len(dropped) > 0 {
+		log("Not re-sending with the paid request (--pay-headers): %s\n", strings.Join(dropped, ", "))
+	}
+	var paidWait serverWait
+	started = time.Now()
+	for {
+		req2, _ := newRequestWithContext(paidWait.trace(ctx), method, endpoint, data, paidHeaders)
+		resp2, err = httpClient.Do(req2)
+		if err != nil {
+			code, kind := classifyError(err)
+			if kind == "timeout" && sentPayment != "" {
+				exitPending(result, endpoint, method, data, sentPayment, traceID, jsonOutput, log)
+			}
+			if jsonOutput {
+				result.Status = "error"
+				result.Error = "payment request failed: " + err.Error()
+				result.ErrorType = kind
+				exitJSON(result, code)
+			}
+			fmt.Fprintf(os.Stderr, "Payment request failed: %v\n", err)
+			exit(code)
+		}
+		if streamed = resp2.StatusCode == http.StatusOK && isLineStream(resp2) && decrypter == nil; streamed {
+			// NDJSON results of long-running jobs are passed on line by line as they arrive.
+			log("Streaming %s response:\n", resp2.Header.Get("Content-Type"))
+			var head []byte
+			if include {
+				head = responseHead(resp2)
+			}
+			body2 = streamPaidBody(resp2, head, outputFile, !quiet && !jsonOutput && redaction == redactOff)
+		} else {
+			body2, _ = io.ReadAll(resp2.Body)
+		}
+		resp2.Body.Close()
+		if rateLimited("payment", resp2) {
+			continue
+		}
+
+		// A replayed nonce means stale state, not a bad payment: sign again with a fresh one.
+		if resp2.StatusCode != http.StatusPaymentRequired || retries >= retryNonce ||
+			!isNonceReplay(rejectionReason(resp2, body2)) || !retrySafety(method, req2.Header, true, resp2, nil).Safe {
+			break
+		}
+		retries++
+		log("Payment rejected: nonce already used. Retrying with a fresh nonce (%d/%d)...\n", retries, retryNonce)
+	}
+	// The payment was refused for requirements that changed since the probe (or since they
+	// were cached): show what changed, and re-pay against the new ones when that was asked
+	// for; the refusal is then the probe, and everything from there is checked again.
+	if resp2.StatusCode == http.StatusPaymentRequired && !requirementsChanged {
+		if changes := requirementsDiff(resp, body, resp2, body2); len(changes) > 0 {
+			result.RequirementsChange = &requirementsChange{Changes: changes}
+			log("Payment refused: the payment requirements changed after the probe:\n")
+			for _, c := range changes {
+				log("  %s\n", c)
+			}
+			if cached == nil && amountLimit == nil {
+				log("Not paying again; pass --max-amount to re-pay automatically when the new price is within it.\n")
+			}
+		}
+	}
+	if result.RequirementsChange != nil && !requirementsChanged && (cached != nil || amountLimit != nil) {
+		log("Paying against the new requirements...\n")
+		result.RequirementsChange.Retried = true
+		releaseCeiling()
+		if inflight != nil {
+			inflight.remove()
+			inflight = nil
+		}
+		result.Selection = nil
+		sentPayment = ""
+		cached, requirementsChanged = nil, true
+		resp, body = resp2, body2
+		started = time.Now()
+		goto probed
+	}
+	// A refused payment does not settle, and a rehearsed one was never sent: neither counts
+	// toward --max-total-spend.
+	if resp2.StatusCode == http.StatusPaymentRequired || resp2.Header.Get(noSpendHeader) != "" {
+		releaseCeiling()
+	}
+	paidIn := time.Since(started)
+	artifacts.mark("payment", paidIn)
+	artifacts.writeResponse("response", resp2, body2)
+	if hop := redirects.blocked(); hop != nil {
+		exitRedirectBlocked(result, hop, jsonOutput, log)
+	}
+
+	// --decrypt: the artifacts keep the body as received; everything below sees the plaintext.
+	var decryptErr error
+	decrypted := false
+	if decrypter != nil && resp2.StatusCode == http.StatusOK && resp2.Header.Get(noSpendHeader) == "" {
+		if plain, err := decrypter.decrypt(body2); err != nil {
+			decryptErr = err
+		} else {
+			body2, decrypted = plain, true
+		}
+	}
 
 	if verbose && !quiet && !jsonOutput {
 		dumpResponse(resp2, body2)
@@ -381,10 +1594,15 @@ func main() {
 
 	// Build payment result.
 	pay := &payResult{
-		StatusCode: resp2.StatusCode,
-		Accepted:   resp2.StatusCode == http.StatusOK,
-		Signer:     evmSigner.Address(),
-		Body:       string(body2),
+		StatusCode:     resp2.StatusCode,
+		Accepted:       resp2.StatusCode == http.StatusOK,
+		Signer:         payerAddr,
+		Body:           string(body2),
+		NonceRetries:   retries,
+		DroppedHeaders: dropped,
+	}
+	if decrypted {
+		pay.Decrypted = decryptAs
 	}
 	if payRespHeader := resp2.Header.Get("PAYMENT-RESPONSE"); payRespHeader != "" {
 		if decoded, err := base64.StdEncoding.DecodeString(payRespHeader); err == nil {
@@ -396,40 +1614,261 @@ func main() {
 		}
 	}
 	result.Payment = pay
+	pay.NoSpend = resp2.Header.Get(noSpendHeader) != ""
+	// A rehearsed payment was answered locally, so its timing measures nothing.
+	if !pay.NoSpend {
+		pay.Timing = newPaymentTiming(paidIn, paidWait.duration(), probeWait.duration(), resp2.Header, slowPay)
+	}
+	// The paid response's count is the newer one; the price stays the probe's.
+	if ft := parseFreeTier("payment", resp2.Header, false); ft != nil {
+		if result.FreeTier != nil {
+			ft.Price = result.FreeTier.Price
+		}
+		result.FreeTier = ft
+	}
+	if pay.NoSpend {
+		log("--no-spend: the payment was signed but not sent; the response is made up.\n")
+	}
+	// A rehearsed payment spent nothing, so it stays out of the ledger that budgets count.
+	if sentPayment != "" && !pay.NoSpend {
+		rec := newHistoryRecord(endpoint, method, data, sentPayment, resp2)
+		if inflight != nil {
+			rec.ID = inflight.ID
+		}
+		rec.TraceID = traceID
+		rec.LatencyMs = time.Since(started).Milliseconds()
+		rec.measureBody(body2)
+		pendingHistory = &pendingPayment{record: rec, result: result}
+		result.PaymentID = rec.ID
+	}
+
+	// --on-type decides by the paid response's Content-Type what happens to its body.
+	var route *typeRoute
+	contentType := resp2.Header.Get("Content-Type")
+	if !streamed {
+		route = onType.match(contentType)
+	}
+	if route != nil && route.Action == routeSave && len(body2) > 0 {
+		path := route.savePath(contentType, outputFile, requestID)
+		if err := os.WriteFile(path, body2, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to write to %s: %v\n", path, err)
+		} else {
+			pay.SavedTo = path
+		}
+	}
 
 	if !jsonOutput {
+		if pay.Timing != nil && pay.Timing.Slow {
+			printTiming(log, pay.Timing)
+		}
 		log("Status: %d\n", resp2.StatusCode)
-		if !verbose {
-			log("Body: %s\n\n", truncate(string(body2), 500))
+		switch {
+		case verbose || streamed:
+		case route != nil && route.Action == routePrint && redaction == redactOff:
+			if include {
+				os.Stdout.Write(responseHead(resp2))
+			}
+			os.Stdout.Write(body2)
+			fmt.Println()
+		case route != nil && route.Action == routeSave && pay.SavedTo != "":
+			log("Body: %d bytes of %s saved to %s\n\n", len(body2), contentType, pay.SavedTo)
+		case route != nil && route.Action == routeDiscard:
+			log("Body: %d bytes of %s discarded\n\n", len(body2), contentType)
+		case include:
+			log("\n%s%s\n\n", responseHead(resp2), shownBody(body2, bodyLimit(500)))
+		default:
+			log("Body: %s\n\n", shownBody(body2, bodyLimit(500)))
 		}
 	}
 
-	// Save response body to file if -o is set.
-	saveOutput(outputFile, body2)
+	// Save response body to file if -o is set (a streamed body was written as it arrived).
+	switch {
+	case streamed || (pay.SavedTo != "" && pay.SavedTo == outputFile):
+	case include:
+		saveOutput(outputFile, append(responseHead(resp2), body2...))
+	default:
+		saveOutput(outputFile, body2)
+	}
 
 	switch resp2.StatusCode {
 	case http.StatusOK:
 		logln("Payment accepted!")
 		result.Status = "accepted"
+		// A PAYMENT-RESPONSE is only the resource server's word unless the facilitator signed it.
+		if pay.PaymentResponse != nil && !pay.NoSpend {
+			// The attestation must be of this payment: the option it was sent for, else the one chosen.
+			var sent x402.PaymentPayload
+			if raw, err := base64.StdEncoding.DecodeString(sentPayment); err == nil {
+				json.Unmarshal(raw, &sent)
+			}
+			paid := sent.Accepted
+			if paid.Network == "" {
+				paid = price
+			}
+			check := checkAttestation(*pay.PaymentResponse, payerAddr, paid, pinnedKeys, strings.Split(facilURL, ",")[0], timeout)
+			if check.Status != "absent" || requireAtt {
+				pay.Attestation = check
+				logAttestation(log, check)
+			}
+		}
+		if requireAtt && !pay.NoSpend && (pay.Attestation == nil || pay.Attestation.Status != "verified") {
+			result.Status = "error"
+			result.Error = "--require-attestation: the PAYMENT-RESPONSE is not attested by a trusted facilitator key; the payment may or may not have settled"
+			result.ErrorType = "facilitator"
+			log("Error: %s\n", result.Error)
+			if jsonOutput {
+				exitJSON(result, ExitFacilitatorError)
+			}
+			exit(ExitFacilitatorError)
+		}
+		var settledIn time.Duration
+		if (waitConfs || confs > 0 || sloSettle > 0) && !pay.NoSpend {
+			started = time.Now()
+			check, err := verifySettlement(pay, confs, timeout, log)
+			artifacts.mark("settlement", time.Since(started))
+			settledIn = paidIn + time.Since(started)
+			pay.Settlement = check
+			if err != nil {
+				log("Settlement verification failed: %v\n", err)
+				result.Status = "error"
+				result.Error = err.Error()
+				result.ErrorType = "settlement"
+				if jsonOutput {
+					exitJSON(result, ExitFacilitatorError)
+				}
+				exit(ExitFacilitatorError)
+			}
+		}
+		if pendingHistory != nil {
+			if _, path, err := issueReceipt(pendingHistory.record); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			} else {
+				result.Receipt = path
+				log("Receipt: %s\n", path)
+			}
+		}
+		if archiveDir != "" && !pay.NoSpend {
+			entry := archiveEntry{Time: time.Now(), Endpoint: endpoint, Method: method, RequestID: requestID, PaymentID: result.PaymentID}
+			if pendingHistory != nil {
+				rec := pendingHistory.record
+				entry.Network, entry.Asset, entry.Amount, entry.Transaction = rec.Network, rec.Asset, rec.Amount, rec.Transaction
+			}
+			if path, err := archiveResponse(archiveDir, entry, resp2, body2); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: cannot archive the response: %v\n", err)
+			} else {
+				result.Archived = path
+				log("Archived: %s\n", path)
+			}
+		}
+		if decryptErr != nil {
+			// The payment went through (status stays "accepted"); only the decryption failed.
+			result.Error = decryptErr.Error()
+			if jsonOutput {
+				exitJSON(result, ExitError)
+			}
+			fmt.Fprintf(os.Stderr, "Error: %v\n", decryptErr)
+			exit(ExitError)
+		}
+		if extract != "" || decode != "" || saveAs != "" {
+			out, value, err := transformBody(body2, extract, decode)
+			result.Extract = &extractResult{Path: extract, Decode: decode, Value: value, Bytes: len(out)}
+			if err == nil && saveAs != "" {
+				if err = os.WriteFile(saveAs, out, 0644); err == nil {
+					result.Extract.SavedAs = saveAs
+				}
+			}
+			if err != nil {
+				// The payment went through (status stays "accepted"); only the post-processing failed.
+				result.Error = err.Error()
+				if jsonOutput {
+					exitJSON(result, ExitError)
+				}
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				exit(ExitError)
+			}
+			switch {
+			case saveAs != "":
+				log("Saved %d bytes to %s\n", len(out), saveAs)
+			case jsonOutput:
+			case quiet:
+				os.Stdout.Write(out)
+				if decode == "" {
+					fmt.Println()
+				}
+			default:
+				fmt.Printf("Extracted:\n%s\n", out)
+			}
+		}
+		// The payment went through either way; a missed objective only fails the probe.
+		if (sloLatency > 0 || sloSettle > 0) && !pay.NoSpend {
+			result.SLO = checkSLO(paidIn, sloLatency, settledIn, sloSettle)
+			if msg := result.SLO.message(); msg != "" {
+				result.Error = msg
+				log("%s\n", msg)
+				if jsonOutput {
+					exitJSON(result, ExitSLOBreached)
+				}
+				exit(ExitSLOBreached)
+			}
+		}
 		if jsonOutput {
 			exitJSON(result, ExitSuccess)
 		}
-		os.Exit(ExitSuccess)
+		exit(ExitSuccess)
 	case http.StatusPaymentRequired:
-		logln("Payment was rejected. Check wallet balance and facilitator logs.")
+		reason := rejectionReason(resp2, body2)
+		result.RejectionReason = normalizeRejection(reason)
+		if isInsufficientFunds(reason) {
+			log("Payment was rejected: insufficient funds (%s).\n", reason)
+			logln("Fund the signer wallet and retry. Check balances with: x402-cli wallet")
+			result.Status = "insufficient_funds"
+			result.Error = reason
+			result.FundingLinks = fundingLinks(evmSigner.Address(), requirements)
+			printFundingLinks(log, result.FundingLinks)
+			if jsonOutput {
+				exitJSON(result, ExitInsufficientFunds)
+			}
+			exit(ExitInsufficientFunds)
+		}
+		if isFacilitatorFailure(reason) {
+			log("Facilitator error: %s\n", reason)
+			result.Status = "error"
+			result.Error = reason
+			result.ErrorType = "facilitator"
+			if jsonOutput {
+				exitJSON(result, ExitFacilitatorError)
+			}
+			exit(ExitFacilitatorError)
+		}
+		log("Payment was rejected (%s): %s.\n", result.RejectionReason.Code, result.RejectionReason.Message)
+		if reason != "" && result.RejectionReason.Code != "unknown" {
+			log("Facilitator reason: %s\n", reason)
+		}
 		result.Status = "rejected"
+		result.Error = reason
 		if jsonOutput {
 			exitJSON(result, ExitPaymentRejected)
 		}
-		os.Exit(ExitPaymentRejected)
+		exit(ExitPaymentRejected)
+	case http.StatusTooManyRequests:
+		exitRateLimited(result, jsonOutput, log)
 	default:
 		log("Unexpected status %d.\n", resp2.StatusCode)
 		result.Status = "error"
 		result.Error = fmt.Sprintf("unexpected status %d", resp2.StatusCode)
+		code := ExitError
+		// A paid request that fails server-side with a settlement reason points at the facilitator.
+		if reason := rejectionReason(resp2, body2); resp2.StatusCode >= 500 && reason != "" {
+			result.Error += ": " + reason
+			result.ErrorType = "facilitator"
+			result.RejectionReason = normalizeRejection(reason)
+			log("Facilitator error (%s): %s.\n", result.RejectionReason.Code, result.RejectionReason.Message)
+			code = ExitFacilitatorError
+		}
 		if jsonOutput {
-			exitJSON(result, ExitError)
+			exitJSON(result, code)
 		}
-		os.Exit(ExitError)
+		exit(code)
 	}
 }
 
@@ -445,35 +1884,136 @@ func saveOutput(outputFile string, body []byte) {
 
 // exitJSON marshals the result to stdout and exits.
 func exitJSON(result *jsonResult, code int) {
-	out, _ := json.MarshalIndent(result, "", "  ")
+	if result.RequestID == "" {
+		result.RequestID = requestID
+	}
+	if outputSchema == "v2" {
+		result.SchemaVersion = schemaVersion
+	}
+	out, _ := json.MarshalIndent(redactJSON(result), "", "  ")
 	fmt.Println(string(out))
+	exit(code)
+}
+
+// exitPending reports a paid request that timed out before any response: the payment
+// was signed and sent, so it may still settle. It is recorded as pending for `resolve`.
+func exitPending(result *jsonResult, endpoint, method, data, payment, traceID string, jsonOutput bool, log func(string, ...any)) {
+	rec := newHistoryRecord(endpoint, method, data, payment, nil)
+	if inflight != nil {
+		rec.ID = inflight.ID
+	}
+	rec.TraceID = traceID
+	pendingHistory = &pendingPayment{record: rec, result: result}
+	result.PaymentID = rec.ID
+	result.Status = "pending"
+	result.ErrorType = "timeout"
+	result.Error = "timed out waiting for the paid response; the payment may still settle"
+	log("The paid request timed out after the payment was sent; it may still settle.\n")
+	log("Check whether it did with: x402-cli resolve %s\n", rec.ID)
+	log("or send the same signed payment again with: x402-cli resume %s\n", rec.ID)
+	if jsonOutput {
+		exitJSON(result, ExitTimeout)
+	}
+	exit(ExitTimeout)
+}
+
+// exitRedirectBlocked reports a cross-origin redirect that was not followed.
+func exitRedirectBlocked(result *jsonResult, hop *redirectHop, jsonOutput bool, log func(string, ...any)) {
+	result.Status = "redirect_blocked"
+	if !hop.CrossOrigin {
+		result.Error = fmt.Sprintf("%s request redirected (%d): %s", hop.Step, hop.StatusCode, hop.To)
+		log("Not following the redirect from %s to %s: --sandbox follows no redirects.\n", hop.From, hop.To)
+		if jsonOutput {
+			exitJSON(result, ExitError)
+		}
+		exit(ExitError)
+	}
+	result.Error = fmt.Sprintf("%s request redirected (%d) to another origin: %s", hop.Step, hop.StatusCode, hop.To)
+	log("Not following the redirect from %s to %s: it leaves the original origin.\n", hop.From, hop.To)
+	log("Rerun with --trust-redirects (or --dry-run to confirm interactively) to follow it and pay there.\n")
+	if jsonOutput {
+		exitJSON(result, ExitError)
+	}
+	exit(ExitError)
+}
+
+// exitRateLimited reports a 429 that --max-wait did not (or could not) wait out.
+func exitRateLimited(result *jsonResult, jsonOutput bool, log func(string, ...any)) {
+	info := result.RateLimit
+	result.Status = "rate_limited"
+	result.ErrorType = "rate_limit"
+	result.Error = "rate limited (429 Too Many Requests)"
+	if info.RetryAfter != "" {
+		result.Error += "; Retry-After: " + info.RetryAfter
+	}
+	log("Rate limited (429 Too Many Requests) during the %s request.\n", info.Step)
+	if info.RetryAfter != "" {
+		log("Retry-After: %s\n", info.RetryAfter)
+	}
+	if info.Remaining != "" || info.Limit != "" {
+		log("Rate limit: %s of %s remaining (reset: %s)\n", dashIfEmpty(info.Remaining), dashIfEmpty(info.Limit), dashIfEmpty(info.Reset))
+	}
+	if info.Retries == 0 {
+		log("Retry later, or pass --max-wait to wait and retry automatically.\n")
+	}
+	if jsonOutput {
+		exitJSON(result, ExitError)
+	}
+	exit(ExitError)
+}
+
+// exit records the run's payment history and artifacts, then exits.
+func exit(code int) {
+	status := ""
+	if pendingHistory != nil {
+		status = pendingHistory.result.Status
+	}
+	inflight.finish(status)
+	if code != ExitSuccess && code != ExitFreeRoute && supportNote != "" {
+		fmt.Fprint(os.Stderr, supportNote)
+	}
+	flushHistory()
+	artifacts.close(code)
+	stdinSpool.close()
 	os.Exit(code)
 }
 
+// traceHeader carries the request ID on both requests.
+const traceHeader = "X-Request-ID"
+
+// requestID identifies this invocation across its JSON output, NDJSON lines, history
+// records, and X-Request-ID headers; --trace-id replaces it.
+var requestID = newTraceID()
+
+// newTraceID returns a random 128-bit hex correlation ID.
+func newTraceID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 // newRequest creates an HTTP request with optional body and custom headers.
 func newRequest(method, url, data string, headers headerFlags) (*http.Request, error) {
-	var bodyReader io.Reader
-	if data != "" {
-		bodyReader = strings.NewReader(data)
-	}
-	req, err := http.NewRequest(method, url, bodyReader)
-	if err != nil {
-		return nil, err
-	}
-	applyHeaders(req, headers)
-	return req, nil
+	return newRequestWithContext(context.Background(), method, url, data, headers)
 }
 
 // newRequestWithContext creates an HTTP request with context, optional body and custom headers.
+// With -d @-, the body is streamed from the file stdin was spooled to (see stdinBody).
 func newRequestWithContext(ctx context.Context, method, url, data string, headers headerFlags) (*http.Request, error) {
+	streamed := data == stdinData && stdinSpool != nil
 	var bodyReader io.Reader
-	if data != "" {
+	if data != "" && !streamed {
 		bodyReader = strings.NewReader(data)
 	}
 	req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
 	if err != nil {
 		return nil, err
 	}
+	if streamed {
+		if err := stdinSpool.attach(req); err != nil {
+			return nil, fmt.Errorf("cannot read the request body from stdin: %w", err)
+		}
+	}
 	applyHeaders(req, headers)
 	return req, nil
 }
@@ -489,6 +2029,16 @@ func applyHeaders(req *http.Request, headers headerFlags) {
 
 // dumpRequest prints the full HTTP request in verbose mode.
 func dumpRequest(req *http.Request) {
+	if redaction != redactOff {
+		fmt.Printf("→ Request:\n%s %s\n", req.Method, redactURL(req.URL.String()))
+		for k, vals := range redactHeaderValues(req.Header) {
+			for _, v := range vals {
+				fmt.Printf("  %s: %s\n", k, v)
+			}
+		}
+		fmt.Println()
+		return
+	}
 	dump, err := httputil.DumpRequestOut(req, true)
 	if err != nil {
 		return
@@ -499,27 +2049,134 @@ func dumpRequest(req *http.Request) {
 // dumpResponse prints the full HTTP response in verbose mode.
 func dumpResponse(resp *http.Response, body []byte) {
 	fmt.Printf("← Response:\n%s %s\n", resp.Proto, resp.Status)
-	for k, vals := range resp.Header {
+	header := resp.Header
+	if redaction != redactOff {
+		header = redactHeaderValues(header)
+	}
+	for k, vals := range header {
 		for _, v := range vals {
 			fmt.Printf("  %s: %s\n", k, v)
 		}
 	}
-	fmt.Printf("\n%s\n\n", string(body))
+	fmt.Printf("\n%s\n\n", shownBody(body, -1))
+}
+
+// rejectionReason extracts the facilitator's rejection reason from a Step 2 402 response.
+// It checks the PAYMENT-REQUIRED error field, then PAYMENT-RESPONSE errorReason, then a JSON body
+// error (settlement failures are reported as {"error": ..., "details": ...}).
+func rejectionReason(resp *http.Response, body []byte) string {
+	var required struct {
+		Error string `json:"error"`
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(resp.Header.Get("PAYMENT-REQUIRED")); err == nil {
+		if json.Unmarshal(decoded, &required) == nil && required.Error != "" {
+			return required.Error
+		}
+	}
+
+	var settle struct {
+		ErrorReason string `json:"errorReason"`
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(resp.Header.Get("PAYMENT-RESPONSE")); err == nil {
+		if json.Unmarshal(decoded, &settle) == nil && settle.ErrorReason != "" {
+			return settle.ErrorReason
+		}
+	}
+
+	var bodyErr struct {
+		Error   string `json:"error"`
+		Details string `json:"details"`
+	}
+	if json.Unmarshal(body, &bodyErr) != nil {
+		return ""
+	}
+	switch {
+	case bodyErr.Error == "":
+		return bodyErr.Details
+	case bodyErr.Details == "":
+		return bodyErr.Error
+	default:
+		return bodyErr.Error + ": " + bodyErr.Details
+	}
 }
 
-// printPaymentSummary extracts and displays the cost from a 402 response.
-func printPaymentSummary(body []byte) {
+// classifyError maps a transport error to an exit code and error type so shell
+// retries can distinguish DNS, TLS, and timeout failures from other errors.
+func classifyError(err error) (int, string) {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ExitDNSError, "dns"
+	}
+
+	var (
+		certErr     *tls.CertificateVerificationError
+		recordErr   tls.RecordHeaderError
+		unknownAuth x509.UnknownAuthorityError
+		hostErr     x509.HostnameError
+		invalidCert x509.CertificateInvalidError
+	)
+	if errors.As(err, &certErr) || errors.As(err, &recordErr) || errors.As(err, &unknownAuth) ||
+		errors.As(err, &hostErr) || errors.As(err, &invalidCert) {
+		return ExitTLSError, "tls"
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ExitTimeout, "timeout"
+	}
+
+	if strings.Contains(strings.ToLower(err.Error()), "facilitator") {
+		return ExitFacilitatorError, "facilitator"
+	}
+	return ExitError, ""
+}
+
+// isFacilitatorFailure reports whether a rejection reason comes from the facilitator
+// failing (settlement error, unreachable facilitator) rather than the payment being invalid.
+func isFacilitatorFailure(reason string) bool {
+	r := strings.ToLower(reason)
+	return strings.HasPrefix(r, "settlement failed") || strings.Contains(r, "facilitator")
+}
+
+// printFundingLinks lists wallet links for topping up the signer.
+func printFundingLinks(log func(string, ...any), links []fundingLink) {
+	if len(links) == 0 {
+		return
+	}
+	log("\nFund from a mobile wallet:\n")
+	for _, l := range links {
+		log("  %s (%s, %s):\n    %s\n", l.Wallet, l.Network, l.Amount, l.URL)
+	}
+}
+
+// isInsufficientFunds reports whether a rejection reason indicates the payer lacks balance.
+func isInsufficientFunds(reason string) bool {
+	r := strings.ToLower(reason)
+	return strings.Contains(r, "insufficient_funds") ||
+		strings.Contains(r, "insufficient_balance") ||
+		strings.Contains(r, "insufficient funds") ||
+		strings.Contains(r, "insufficient balance")
+}
+
+// isNonceReplay reports whether a rejection reason says the authorization nonce was already used.
+func isNonceReplay(reason string) bool {
+	r := strings.ToLower(reason)
+	return strings.Contains(r, "nonce_already_used") ||
+		strings.Contains(r, "nonce already used") ||
+		strings.Contains(r, "authorization is used")
+}
+
+// printPaymentSummary displays the cost of each accepted option in the payment requirements.
+func printPaymentSummary(requirements []byte) {
 	fmt.Println("\n--- Payment Summary ---")
 
 	var payInfo struct {
 		Accepts []struct {
-			Amount  string `json:"amount"`
-			Asset   string `json:"asset"`
-			Network string `json:"network"`
-			PayTo   string `json:"payTo"`
-			Extra   struct {
-				Name string `json:"name"`
-			} `json:"extra"`
+			Amount  string                 `json:"amount"`
+			Asset   string                 `json:"asset"`
+			Network string                 `json:"network"`
+			PayTo   string                 `json:"payTo"`
+			Extra   map[string]interface{} `json:"extra"`
 		} `json:"accepts"`
 		Resource struct {
 			URL         string `json:"url"`
@@ -527,18 +2184,21 @@ func printPaymentSummary(body []byte) {
 		} `json:"resource"`
 	}
 
-	if err := json.Unmarshal(body, &payInfo); err == nil {
+	if err := json.Unmarshal(requirements, &payInfo); err == nil {
 		if payInfo.Resource.URL != "" {
 			fmt.Printf("Resource: %s\n", payInfo.Resource.URL)
 		}
 		for _, a := range payInfo.Accepts {
-			assetName := a.Extra.Name
-			if assetName == "" {
-				assetName = a.Asset
+			req := x402.PaymentRequirements{Amount: a.Amount, Asset: a.Asset, Network: a.Network, Extra: a.Extra}
+			fmt.Printf("Cost:     %s\n", describeAmount(req))
+			if tier := describeTier(a.Extra); tier != "" {
+				fmt.Printf("Tier:     %s\n", tier)
+			}
+			fmt.Printf("Network:  %s\n", networkName(a.Network))
+			if err := checkIdentifiers(a.Network, a.Asset); err != nil {
+				fmt.Printf("Invalid:  %v\n", err)
 			}
-			fmt.Printf("Cost:     %s %s (atomic units)\n", a.Amount, assetName)
-			fmt.Printf("Network:  %s\n", a.Network)
-			fmt.Printf("Pay to:   %s\n", a.PayTo)
+			fmt.Printf("Pay to:   %s\n", checksumAddress(a.PayTo))
 		}
 	}
 }
@@ -551,12 +2211,18 @@ func buildVersion() string {
 }
 
 func printBase64Header(name, value string) {
-	fmt.Printf("%s: %s...\n", name, truncate(value, 60))
+	if redaction != redactOff && isPaymentHeader(name) {
+		fmt.Printf("%s: [redacted]\n", name)
+		return
+	}
+	if redaction == redactOff {
+		fmt.Printf("%s: %s...\n", name, truncate(value, 60))
+	}
 	if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
 		var pretty json.RawMessage
 		if json.Unmarshal(decoded, &pretty) == nil {
 			indented, _ := json.MarshalIndent(pretty, "  ", "  ")
-			fmt.Printf("%s (decoded):\n  %s\n", name, string(indented))
+			fmt.Printf("%s (decoded):\n  %s\n", name, redactText(string(indented)))
 		}
 	}
 }
@@ -0,0 +1,4613 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	x402 "github.com/coinbase/x402/go"
+	x402evm "github.com/coinbase/x402/go/mechanisms/evm"
+	evmsigners "github.com/coinbase/x402/go/signers/evm"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+func TestRejectionReason(t *testing.T) {
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		body    string
+		want    string
+	}{
+		{
+			name:    "payment-required error field",
+			headers: map[string]string{"PAYMENT-REQUIRED": b64(`{"x402Version":2,"error":"invalid_exact_evm_insufficient_balance","accepts":[]}`)},
+			want:    "invalid_exact_evm_insufficient_balance",
+		},
+		{
+			name:    "payment-response errorReason",
+			headers: map[string]string{"PAYMENT-RESPONSE": b64(`{"success":false,"errorReason":"transaction_failed"}`)},
+			want:    "transaction_failed",
+		},
+		{
+			name: "json body error and details",
+			body: `{"error":"Settlement failed","details":"insufficient_funds"}`,
+			want: "Settlement failed: insufficient_funds",
+		},
+		{
+			name: "json body details only",
+			body: `{"details":"nonce already used"}`,
+			want: "nonce already used",
+		},
+		{
+			name:    "header details do not leak into body",
+			headers: map[string]string{"PAYMENT-REQUIRED": b64(`{"details":"stale"}`)},
+			body:    `{"message":"nope"}`,
+			want:    "",
+		},
+		{
+			name: "non-json body",
+			body: "<html>Payment Required</html>",
+			want: "",
+		},
+		{
+			name: "empty headers and body",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			for k, v := range tt.headers {
+				resp.Header.Set(k, v)
+			}
+			if got := rejectionReason(resp, []byte(tt.body)); got != tt.want {
+				t.Errorf("rejectionReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsInsufficientFunds(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   bool
+	}{
+		{"insufficient_funds", true},
+		{"invalid_exact_evm_insufficient_balance", true},
+		{"Settlement failed: Insufficient Funds", true},
+		{"invalid_exact_evm_payload_signature", false},
+		{"transaction_failed", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := isInsufficientFunds(tt.reason); got != tt.want {
+			t.Errorf("isInsufficientFunds(%q) = %v, want %v", tt.reason, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeRejection(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   string
+	}{
+		{"invalid_exact_evm_payload_signature", "invalid_signature"},
+		{"signature_invalid", "invalid_signature"},
+		{"invalid_exact_evm_payload_authorization_valid_before", "expired"},
+		{"payment_expired", "expired"},
+		{"invalid_exact_evm_payload_authorization_valid_after", "not_yet_valid"},
+		{"Settlement failed: Insufficient Funds", "insufficient_funds"},
+		{"invalid_exact_evm_insufficient_balance", "insufficient_funds"},
+		{"invalid_exact_evm_payload_authorization_value_insufficient", "amount_mismatch"},
+		{"invalid_exact_evm_nonce_already_used", "nonce_used"},
+		{"invalid_exact_evm_payload_recipient_mismatch", "recipient_mismatch"},
+		{"unsupported_network", "unsupported_network"},
+		{"network_mismatch", "unsupported_network"},
+		{"unsupported_scheme", "unsupported_scheme"},
+		{"transaction_failed", "facilitator_error"},
+		{"invalid_payment", "invalid_payload"},
+		{"something odd", "unknown"},
+		{"", "unknown"},
+	}
+
+	for _, tt := range tests {
+		got := normalizeRejection(tt.reason)
+		if got.Code != tt.want {
+			t.Errorf("normalizeRejection(%q).Code = %q, want %q", tt.reason, got.Code, tt.want)
+		}
+		if got.Message == "" {
+			t.Errorf("normalizeRejection(%q) has no message", tt.reason)
+		}
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	wrap := func(err error) error { return &url.Error{Op: "Get", URL: "https://api.example.com", Err: err} }
+
+	tests := []struct {
+		name string
+		err  error
+		code int
+		kind string
+	}{
+		{"dns", wrap(&net.DNSError{Err: "no such host", Name: "api.example.com", IsNotFound: true}), ExitDNSError, "dns"},
+		{"tls", wrap(x509.UnknownAuthorityError{}), ExitTLSError, "tls"},
+		{"timeout", wrap(context.DeadlineExceeded), ExitTimeout, "timeout"},
+		{"facilitator", errors.New("failed to create V2 payment: facilitator unavailable"), ExitFacilitatorError, "facilitator"},
+		{"other", wrap(errors.New("connection refused")), ExitError, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, kind := classifyError(tt.err)
+			if code != tt.code || kind != tt.kind {
+				t.Errorf("classifyError() = (%d, %q), want (%d, %q)", code, kind, tt.code, tt.kind)
+			}
+		})
+	}
+}
+
+func TestSettlementTx(t *testing.T) {
+	tests := []struct {
+		name        string
+		response    string
+		wantTx      string
+		wantNetwork string
+		wantErr     bool
+	}{
+		{"settled", `{"success":true,"transaction":"0xabc","network":"eip155:84532"}`, "0xabc", "eip155:84532", false},
+		{"no transaction", `{"success":false,"network":"eip155:84532"}`, "", "", true},
+		{"not json", `nope`, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, network, err := settlementTx([]byte(tt.response))
+			if (err != nil) != tt.wantErr || tx != tt.wantTx || network != tt.wantNetwork {
+				t.Errorf("settlementTx() = (%q, %q, %v), want (%q, %q, err %v)", tx, network, err, tt.wantTx, tt.wantNetwork, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvaluateCORS(t *testing.T) {
+	good := http.Header{
+		"Access-Control-Allow-Origin":  {"*"},
+		"Access-Control-Allow-Methods": {"GET, POST, OPTIONS"},
+		"Access-Control-Allow-Headers": {"Content-Type, PAYMENT-SIGNATURE, X-PAYMENT"},
+	}
+	goodActual := http.Header{
+		"Access-Control-Allow-Origin":   {"https://app.example.com"},
+		"Access-Control-Expose-Headers": {"PAYMENT-REQUIRED, PAYMENT-RESPONSE, X-PAYMENT-RESPONSE"},
+	}
+	v2Only := http.Header{
+		"Access-Control-Allow-Origin":  {"*"},
+		"Access-Control-Allow-Headers": {"payment-signature"},
+	}
+	v2OnlyActual := http.Header{
+		"Access-Control-Allow-Origin":   {"*"},
+		"Access-Control-Expose-Headers": {"payment-required,payment-response"},
+	}
+
+	tests := []struct {
+		name      string
+		method    string
+		status    int
+		preflight http.Header
+		actual    http.Header
+		wantOK    bool
+	}{
+		{"fully configured", "GET", 204, good, goodActual, true},
+		{"v2 headers only", "GET", 204, v2Only, v2OnlyActual, true},
+		{"no cors headers", "GET", 204, http.Header{}, http.Header{}, false},
+		{"preflight rejected", "GET", 405, good, goodActual, false},
+		{"put not allowed", "PUT", 204, good, goodActual, false},
+		{"payment-required not exposed", "GET", 204, good, http.Header{"Access-Control-Allow-Origin": {"*"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok := true
+			for _, c := range evaluateCORS("https://app.example.com", tt.method, tt.status, tt.preflight, tt.actual) {
+				ok = ok && (c.OK || c.Optional)
+			}
+			if ok != tt.wantOK {
+				t.Errorf("evaluateCORS() ok = %v, want %v", ok, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestExtractProfileFlag(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		wantProfile string
+		wantArgs    []string
+	}{
+		{"none", []string{"x402-cli", "wallet"}, "", []string{"x402-cli", "wallet"}},
+		{"before subcommand", []string{"x402-cli", "--profile", "staging", "wallet"}, "staging", []string{"x402-cli", "wallet"}},
+		{"after subcommand", []string{"x402-cli", "wallet", "--json", "-profile=ci-bot"}, "ci-bot", []string{"x402-cli", "wallet", "--json"}},
+		{"after terminator", []string{"x402-cli", "--", "--profile", "x"}, "", []string{"x402-cli", "--", "--profile", "x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotProfile, gotArgs := extractProfileFlag(tt.args)
+			if gotProfile != tt.wantProfile || strings.Join(gotArgs, " ") != strings.Join(tt.wantArgs, " ") {
+				t.Errorf("extractProfileFlag() = (%q, %q), want (%q, %q)", gotProfile, gotArgs, tt.wantProfile, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestPrivateKeyVar(t *testing.T) {
+	defer func(p string) { profile = p }(profile)
+
+	tests := []struct {
+		profile string
+		want    string
+	}{
+		{"", "EVM_PRIVATE_KEY"},
+		{"staging", "EVM_PRIVATE_KEY_STAGING"},
+		{"ci-bot", "EVM_PRIVATE_KEY_CI_BOT"},
+	}
+
+	for _, tt := range tests {
+		profile = tt.profile
+		if got := privateKeyVar(); got != tt.want {
+			t.Errorf("privateKeyVar() with profile %q = %q, want %q", tt.profile, got, tt.want)
+		}
+	}
+}
+
+func TestSignerRoutes(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []signerRoute
+		wantErr string
+	}{
+		{"", nil, ""},
+		{"base=treasury, avalanche-fuji=ops,*=default", []signerRoute{
+			{Network: "eip155:8453", Profile: "treasury"},
+			{Network: "eip155:43113", Profile: "ops"},
+			{Network: "*", Profile: ""},
+		}, ""},
+		{"eip155:10=op", []signerRoute{{Network: "eip155:10", Profile: "op"}}, ""},
+		{"base", nil, "want network=profile"},
+		{"solana=sol", nil, "only EVM"},
+		{"solana:5eykt4UsFv8P8NJdTREpY1vzqKqZKvdp=sol", nil, "only EVM"},
+		{"mars=x", nil, "unknown network"},
+		{"base=a,eip155:8453=b", nil, "routed twice"},
+	}
+	for _, tt := range tests {
+		got, err := parseSignerRoutes(tt.spec)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseSignerRoutes(%q) error = %v, want %q", tt.spec, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("parseSignerRoutes(%q) = (%v, %v), want %v", tt.spec, got, err, tt.want)
+		}
+	}
+
+	defer func(p string) { profile = p }(profile)
+	defer func() { clear(routedSigners); clear(routedProfiles) }()
+	t.Setenv("EVM_PRIVATE_KEY_OPS", "0x59c6995e998f97a5a0044966f0945389dc9e86dae88c7a8412f4603b6b78690d")
+	t.Setenv("EVM_PRIVATE_KEY_CI", "")
+	if err := applySignerRoutes("base-sepolia=ci"); err == nil || !strings.Contains(err.Error(), "EVM_PRIVATE_KEY_CI is required") {
+		t.Errorf("missing routed key: err = %v", err)
+	}
+	profile = "staging"
+	if err := applySignerRoutes("base-sepolia=ops,*=default"); err != nil {
+		t.Fatal(err)
+	}
+	if profile != "" {
+		t.Errorf("a * route should replace the active profile, got %q", profile)
+	}
+	if got := routedAddress("eip155:84532", "0xdefault"); got != "0x70997970C51812dc3A010C7d01b50e0d17dc79C8" {
+		t.Errorf("routedAddress(base-sepolia) = %s", got)
+	}
+	if got := routedAddress("eip155:8453", "0xdefault"); got != "0xdefault" {
+		t.Errorf("routedAddress(base) = %s, want the default", got)
+	}
+	if routedProfile("eip155:84532") != "ops" || routedProfile("eip155:8453") != "" {
+		t.Errorf("routedProfile = %q, %q", routedProfile("eip155:84532"), routedProfile("eip155:8453"))
+	}
+}
+
+func TestVerifyPayment(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantValid  bool
+		wantReason string
+		wantErr    bool
+	}{
+		{"valid", 200, `{"isValid":true,"payer":"0xabc"}`, true, "", false},
+		{"invalid in 200", 200, `{"isValid":false,"invalidReason":"invalid_signature"}`, false, "invalid_signature", false},
+		{"invalid as 400", 400, `{"isValid":false,"invalidReason":"insufficient_funds"}`, false, "insufficient_funds", false},
+		{"server error", 500, `oops`, false, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/verify" {
+					t.Errorf("unexpected path %s", r.URL.Path)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			payload := []byte(`{"x402Version":2,"payload":{}}`)
+			got, err := verifyPayment(context.Background(), srv.URL, 5*time.Second, payload, []byte(`{}`))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyPayment() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (got.IsValid != tt.wantValid || got.InvalidReason != tt.wantReason) {
+				t.Errorf("verifyPayment() = %+v, want valid %v reason %q", got, tt.wantValid, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestCAIPIdentifiers(t *testing.T) {
+	const usdc = "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+	tests := []struct {
+		network, asset string
+		name           string // networkName of network
+		valid          bool
+	}{
+		{"eip155:84532", usdc, "Base Sepolia", true},
+		{"base-sepolia", usdc, "Base Sepolia", true},
+		{"eip155:84532", "eip155:84532/erc20:" + usdc, "Base Sepolia", true},
+		{"eip155:1", "0x2222222222222222222222222222222222222222", "Ethereum", true},
+		{"eip155:999999", "", "EVM chain 999999", true},
+		{"solana:5eykt4UsFv8P8NJdTREpY1vzqKqZKvdp", "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v", "Solana", true},
+		{"solana:5eykt4UsFv8P8NJdTREpY1vzqKqZKvdp", "solana:5eykt4UsFv8P8NJdTREpY1vzqKqZKvdp/token:EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v", "Solana", true},
+		{"eip155:8453", "eip155:84532/erc20:" + usdc, "Base", false}, // asset on another chain
+		{"eip155:84532", "eip155:84532/erc20:0xUSDC", "Base Sepolia", false},
+		{"eip155:84532", "USDC", "Base Sepolia", false},
+		{"eip155:0x14a34", usdc, "eip155:0x14a34", false},
+		{"Base", usdc, "Base", false},
+		{"eip155", usdc, "eip155", false},
+		{"", usdc, "", false},
+	}
+	for _, tt := range tests {
+		err := checkIdentifiers(tt.network, tt.asset)
+		if (err == nil) != tt.valid {
+			t.Errorf("checkIdentifiers(%q, %q) = %v, want valid %v", tt.network, tt.asset, err, tt.valid)
+		}
+		if got := networkName(tt.network); got != tt.name {
+			t.Errorf("networkName(%q) = %q, want %q", tt.network, got, tt.name)
+		}
+	}
+
+	if got := assetSymbol(x402.PaymentRequirements{Network: "eip155:84532", Asset: "eip155:84532/erc20:" + usdc}); got != "USDC" {
+		t.Errorf("assetSymbol of a CAIP-19 USDC asset = %q, want USDC", got)
+	}
+	a, err := parseCAIP19("eip155:1/erc721:0x06012c8cf97BEaD5deAe237070F9587f8E7A266d/771769")
+	if err != nil || a.Chain.String() != "eip155:1" || a.Namespace != "erc721" || a.TokenID != "771769" {
+		t.Errorf("parseCAIP19 of an NFT = %+v, %v", a, err)
+	}
+
+	caps, err := capabilityMatrix([]byte(`{"x402Version":2,"accepts":[{"scheme":"exact","network":"eip155:84532","asset":"` + usdc + `"},{"scheme":"exact","network":"eip155:base","asset":"` + usdc + `"}]}`))
+	if err != nil || len(caps) != 2 {
+		t.Fatalf("capabilityMatrix = %+v, %v", caps, err)
+	}
+	if caps[0].Chain != "Base Sepolia" || caps[0].Token != "USDC" || !caps[0].Supported {
+		t.Errorf("valid identifiers: %+v", caps[0])
+	}
+	if caps[1].Supported || !strings.HasPrefix(caps[1].Reason, "invalid identifier") {
+		t.Errorf("malformed network: %+v", caps[1])
+	}
+}
+
+func TestCapabilityMatrix(t *testing.T) {
+	tests := []struct {
+		name          string
+		requirements  string
+		wantSupported []bool
+		wantKnown     []bool
+	}{
+		{"usdc on base sepolia", `{"x402Version":2,"accepts":[{"scheme":"exact","network":"eip155:84532","asset":"0x036CbD53842c5426634e7929541eC2318f3dCF7e"}]}`, []bool{true}, []bool{true}},
+		{"unknown evm token", `{"x402Version":2,"accepts":[{"scheme":"exact","network":"eip155:1","asset":"0x2222222222222222222222222222222222222222"}]}`, []bool{true}, []bool{false}},
+		{"mixed", `{"x402Version":2,"accepts":[{"scheme":"upto","network":"eip155:8453","asset":"0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913"},{"scheme":"exact","network":"solana:5eykt4UsFv8P8NJdTREpY1vzqKqZKvdp","asset":"EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v"}]}`, []bool{false, false}, []bool{true, false}},
+		{"v1 requirements", `{"x402Version":1,"accepts":[{"scheme":"exact","network":"base-sepolia","asset":"0x036CbD53842c5426634e7929541eC2318f3dCF7e"}]}`, []bool{false}, []bool{false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := capabilityMatrix([]byte(tt.requirements))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != len(tt.wantSupported) {
+				t.Fatalf("got %d rows, want %d", len(rows), len(tt.wantSupported))
+			}
+			for i, r := range rows {
+				if r.Supported != tt.wantSupported[i] || r.AssetKnown != tt.wantKnown[i] {
+					t.Errorf("row %d = supported %v known %v, want %v %v", i, r.Supported, r.AssetKnown, tt.wantSupported[i], tt.wantKnown[i])
+				}
+				if !r.Supported && r.Reason == "" {
+					t.Errorf("row %d: unsupported without a reason", i)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildVectors(t *testing.T) {
+	signer, err := evmsigners.NewClientSignerFromPrivateKey(vectorFixtureKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := networks["base-sepolia"]
+	payTo := "0x1111111111111111111111111111111111111111"
+	set, err := buildVectors(signer, info, "1000", payTo, "https://example.com/paid", vectorValidBefore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := buildVectors(signer, info, "1000", payTo, "https://example.com/paid", vectorValidBefore)
+
+	want := map[string]struct{ expect, value string }{
+		"valid":        {"accept", "1000"},
+		"expired":      {"reject", "1000"},
+		"wrong-amount": {"reject", "999"},
+		"replayed":     {"reject", "1000"},
+	}
+	headers := map[string]string{}
+	for i, v := range set.Vectors {
+		headers[v.Name] = v.Header
+		if v.Header != again.Vectors[i].Header {
+			t.Errorf("%s: vectors are not deterministic", v.Name)
+		}
+		w, ok := want[v.Name]
+		if !ok {
+			t.Errorf("unexpected vector %q", v.Name)
+			continue
+		}
+		if v.Expect != w.expect {
+			t.Errorf("%s: expect = %q, want %q", v.Name, v.Expect, w.expect)
+		}
+
+		auth := v.Payload.Payload["authorization"].(map[string]interface{})
+		if auth["value"] != w.value {
+			t.Errorf("%s: value = %v, want %s", v.Name, auth["value"], w.value)
+		}
+		hash, err := x402evm.HashEIP3009Authorization(x402evm.ExactEIP3009Authorization{
+			From:        auth["from"].(string),
+			To:          auth["to"].(string),
+			Value:       auth["value"].(string),
+			ValidAfter:  auth["validAfter"].(string),
+			ValidBefore: auth["validBefore"].(string),
+			Nonce:       auth["nonce"].(string),
+		}, chainIDOf(info), info.USDCContract, "USDC", "2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, _ := x402evm.HexToBytes(v.Payload.Payload["signature"].(string))
+		if ok, err := x402evm.VerifyEOASignature(hash, sig, common.HexToAddress(set.Signer)); !ok || err != nil {
+			t.Errorf("%s: signature does not recover to %s (%v)", v.Name, set.Signer, err)
+		}
+	}
+	if len(headers) != len(want) {
+		t.Errorf("got %d vectors, want %d", len(headers), len(want))
+	}
+	if headers["replayed"] != headers["valid"] {
+		t.Error("replayed vector should repeat the valid payload")
+	}
+	if headers["expired"] == headers["valid"] || headers["wrong-amount"] == headers["valid"] {
+		t.Error("negative vectors should differ from the valid payload")
+	}
+
+	// --wallet vectors carry a short window; the expired vector stays expired.
+	short, err := buildVectors(signer, info, "1000", payTo, "https://example.com/paid", 1700000300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range short.Vectors {
+		want := "1700000300"
+		if v.Name == "expired" {
+			want = "946684800"
+		}
+		if got := v.Payload.Payload["authorization"].(map[string]interface{})["validBefore"]; got != want {
+			t.Errorf("%s: validBefore = %v, want %s", v.Name, got, want)
+		}
+	}
+}
+
+func TestEchoServer(t *testing.T) {
+	required, err := echoRequirements(networks["base-sepolia"], "1000", "0x1111111111111111111111111111111111111111")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(echoHandler(required))
+	defer srv.Close()
+
+	// Unpaid requests get the requirements, in the header and the body.
+	resp, err := http.Get(srv.URL + "/paid?q=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	decoded, _ := base64.StdEncoding.DecodeString(resp.Header.Get("PAYMENT-REQUIRED"))
+	var got x402.PaymentRequired
+	if resp.StatusCode != http.StatusPaymentRequired || json.Unmarshal(decoded, &got) != nil || len(got.Accepts) != 1 || got.Accepts[0].Amount != "1000" || got.Resource.URL != srv.URL+"/paid?q=1" {
+		t.Fatalf("unpaid request: status %d, requirements %s", resp.StatusCode, decoded)
+	}
+
+	// Paid requests get their payment headers back, decoded where they are base64 JSON.
+	req, _ := http.NewRequest("POST", srv.URL+"/paid", nil)
+	req.Header.Set("PAYMENT-SIGNATURE", base64.StdEncoding.EncodeToString([]byte(`{"x402Version":2}`)))
+	req.Header.Set("X-PAYMENT", "not base64!")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var echoed echoResult
+	if err := json.NewDecoder(resp.Body).Decode(&echoed); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("paid request: status %d, %v", resp.StatusCode, err)
+	}
+	if echoed.Method != "POST" || len(echoed.Payments) != 2 {
+		t.Fatalf("echoed %+v", echoed)
+	}
+	if p := echoed.Payments[0]; p.Name != "PAYMENT-SIGNATURE" || string(p.Decoded) != `{"x402Version":2}` || p.Error != "" {
+		t.Errorf("PAYMENT-SIGNATURE echoed as %+v", p)
+	}
+	if p := echoed.Payments[1]; p.Name != "X-PAYMENT" || p.Decoded != nil || p.Error == "" {
+		t.Errorf("malformed X-PAYMENT echoed as %+v", p)
+	}
+}
+
+func TestServeFixtures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	os.WriteFile(path, []byte(`routes:
+  - path: /ok
+  - path: /mainnet
+    network: base
+    amount: 250000
+  - path: /reject
+    failure: always-reject
+    reason: insufficient_funds
+  - path: /flaky
+    failure: flaky
+    failRate: 1
+  - path: /slow
+    failure: slow-settle # settles late
+    settleDelay: 50ms
+`), 0600)
+	routes, err := loadServeFixtures(path, networks["base-sepolia"], "1000", "0x1111111111111111111111111111111111111111")
+	if err != nil || len(routes) != 5 {
+		t.Fatalf("loadServeFixtures = (%d routes, %v)", len(routes), err)
+	}
+	if got := routes[1].required.Accepts[0]; got.Network != networks["base"].ChainID || got.Amount != "250000" || got.Asset != networks["base"].USDCContract {
+		t.Errorf("/mainnet requires %+v", got)
+	}
+	srv := httptest.NewServer(fixturesHandler(routes))
+	defer srv.Close()
+
+	signer, err := evmsigners.NewClientSignerFromPrivateKey(vectorFixtureKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pay := func(route string) (*http.Response, []byte) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + route)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		decoded, _ := base64.StdEncoding.DecodeString(resp.Header.Get("PAYMENT-REQUIRED"))
+		var required x402.PaymentRequired
+		if resp.StatusCode != http.StatusPaymentRequired || json.Unmarshal(decoded, &required) != nil || len(required.Accepts) != 1 {
+			t.Fatalf("%s unpaid: status %d, requirements %s", route, resp.StatusCode, decoded)
+		}
+		payload, _, err := signPayment(context.Background(), signer, &required, required.Accepts[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, _ := http.NewRequest("GET", srv.URL+route, nil)
+		req.Header.Set("PAYMENT-SIGNATURE", base64.StdEncoding.EncodeToString(payload))
+		if resp, err = http.DefaultClient.Do(req); err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	resp, body := pay("/ok")
+	var receipt fixtureReceipt
+	if resp.StatusCode != http.StatusOK || json.Unmarshal(body, &receipt) != nil || receipt.Amount != "1000" || !strings.EqualFold(receipt.Payer, "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266") {
+		t.Fatalf("/ok paid: status %d, body %s", resp.StatusCode, body)
+	}
+	var settle x402.SettleResponse
+	decoded, _ := base64.StdEncoding.DecodeString(resp.Header.Get("PAYMENT-RESPONSE"))
+	if json.Unmarshal(decoded, &settle) != nil || !settle.Success || settle.Transaction != receipt.Transaction {
+		t.Errorf("/ok PAYMENT-RESPONSE = %s", decoded)
+	}
+
+	if resp, body = pay("/reject"); resp.StatusCode != http.StatusPaymentRequired || rejectionReason(resp, body) != "insufficient_funds" {
+		t.Errorf("/reject: status %d, reason %q", resp.StatusCode, rejectionReason(resp, body))
+	}
+	if resp, body = pay("/flaky"); resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("PAYMENT-RESPONSE") != "" {
+		t.Errorf("/flaky: status %d, body %s", resp.StatusCode, body)
+	}
+	start := time.Now()
+	if resp, _ = pay("/slow"); resp.StatusCode != http.StatusOK || time.Since(start) < 50*time.Millisecond || resp.Header.Get("Server-Timing") != "settle;dur=50" {
+		t.Errorf("/slow: status %d after %s, Server-Timing %q", resp.StatusCode, time.Since(start), resp.Header.Get("Server-Timing"))
+	}
+
+	// A payment for another route's requirements is refused before the route's mode applies.
+	resp, _ = pay("/ok")
+	req, _ := http.NewRequest("GET", srv.URL+"/mainnet", nil)
+	req.Header.Set("PAYMENT-SIGNATURE", resp.Request.Header.Get("PAYMENT-SIGNATURE"))
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPaymentRequired {
+		t.Errorf("a payment for /ok on /mainnet: status %d", resp.StatusCode)
+	}
+
+	if resp, err = http.Get(srv.URL + "/missing"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown route: %v, %v", resp.StatusCode, err)
+	}
+
+	for _, bad := range []string{
+		"- path: /x\n  failure: sometimes\n",
+		"- path: /x\n  failure: flaky\n  failRate: 2\n",
+		"- path: /x\n  network: nowhere\n",
+		"- path: /x\n- path: /x\n",
+		"- path: x\n",
+		"- path: /x\n  color: red\n",
+	} {
+		os.WriteFile(path, []byte(bad), 0600)
+		if _, err := loadServeFixtures(path, networks["base-sepolia"], "1000", "0x1111111111111111111111111111111111111111"); err == nil {
+			t.Errorf("fixtures %q: no error", bad)
+		}
+	}
+}
+
+func TestFuzzPayments(t *testing.T) {
+	signer, err := evmsigners.NewClientSignerFromPrivateKey(vectorFixtureKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := networks["base-sepolia"]
+	selected := x402.PaymentRequirements{
+		Scheme:            "exact",
+		Network:           info.ChainID,
+		Asset:             info.USDCContract,
+		Amount:            "1000",
+		PayTo:             "0x1111111111111111111111111111111111111111",
+		MaxTimeoutSeconds: 60,
+		Extra:             map[string]interface{}{"name": "USDC", "version": "2"},
+	}
+	now := time.Now()
+	payments, err := fuzzPayments(signer, selected, nil, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := map[string]x402.PaymentPayload{}
+	for _, p := range payments {
+		var payload x402.PaymentPayload
+		raw, err := base64.StdEncoding.DecodeString(p.header)
+		if err == nil {
+			err = json.Unmarshal(raw, &payload)
+		}
+		if p.name == "bad-base64" {
+			if err == nil {
+				t.Error("bad-base64: header decoded successfully")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", p.name, err)
+		}
+		decoded[p.name] = payload
+	}
+	for _, name := range []string{"tampered-signature", "wrong-network", "expired"} {
+		if _, ok := decoded[name]; !ok {
+			t.Errorf("missing %s case", name)
+		}
+	}
+
+	if n := decoded["wrong-network"].Accepted.Network; n == selected.Network || !isTestnet(n) {
+		t.Errorf("wrong-network: network = %s, want a different testnet", n)
+	}
+	if from := decoded["wrong-network"].Payload["authorization"].(map[string]interface{})["from"]; from != "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266" {
+		t.Errorf("wrong-network: signed by %v, want the fixture key", from)
+	}
+	auth := decoded["expired"].Payload["authorization"].(map[string]interface{})
+	if before, _ := strconv.ParseInt(auth["validBefore"].(string), 10, 64); before >= now.Unix() {
+		t.Errorf("expired: validBefore %d is not in the past", before)
+	}
+
+	tampered := decoded["tampered-signature"].Payload
+	auth = tampered["authorization"].(map[string]interface{})
+	hash, err := x402evm.HashEIP3009Authorization(x402evm.ExactEIP3009Authorization{
+		From:        auth["from"].(string),
+		To:          auth["to"].(string),
+		Value:       auth["value"].(string),
+		ValidAfter:  auth["validAfter"].(string),
+		ValidBefore: auth["validBefore"].(string),
+		Nonce:       auth["nonce"].(string),
+	}, chainIDOf(info), info.USDCContract, "USDC", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, _ := x402evm.HexToBytes(tampered["signature"].(string))
+	if ok, _ := x402evm.VerifyEOASignature(hash, sig, common.HexToAddress(signer.Address())); ok {
+		t.Error("tampered-signature: signature still verifies")
+	}
+}
+
+func TestArtifactRecorder(t *testing.T) {
+	var none *artifactRecorder
+	none.write("x", nil) // disabled recorder must be a no-op
+	none.close(0)
+
+	for _, c := range []struct{ trace, request, want string }{
+		{"t-1", "0123456789abcdef", "t-1"},
+		{"", "0123456789abcdef", "01234567"},
+		{"", "abc", "abc"},
+	} {
+		if got := artifactID(c.trace, c.request); got != c.want {
+			t.Errorf("artifactID(%q, %q) = %q, want %q", c.trace, c.request, got, c.want)
+		}
+	}
+	if got := artifactID("", ""); len(got) != 8 {
+		t.Errorf("artifactID with no request ID = %q, want a generated 8-character ID", got)
+	}
+
+	result := &jsonResult{Version: "test", Status: "accepted"}
+	a, err := newArtifactRecorder(t.TempDir(), "run1", result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.write("requirements.json", []byte(`{"x402Version":2}`))
+	a.writeResponse("probe", &http.Response{StatusCode: 402, Header: http.Header{"Payment-Required": {"abc"}}}, []byte("body"))
+	a.mark("probe", 1500*time.Millisecond)
+
+	rt := a.transport(roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200}, nil
+	}))
+	req, _ := http.NewRequest("GET", "https://example.com", nil)
+	req.Header.Set("PAYMENT-SIGNATURE", base64.StdEncoding.EncodeToString([]byte(`{"signed":true}`)))
+	rt.RoundTrip(req)
+	a.close(ExitSuccess)
+
+	want := map[string]string{
+		"requirements.json": `{"x402Version":2}`,
+		"probe.body":        "body",
+		"payment.json":      `{"signed":true}`,
+	}
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(a.dir, name))
+		if err != nil || string(got) != content {
+			t.Errorf("%s = %q (%v), want %q", name, got, err, content)
+		}
+	}
+	var timings map[string]int64
+	raw, _ := os.ReadFile(filepath.Join(a.dir, "timings.json"))
+	if json.Unmarshal(raw, &timings) != nil || timings["probe"] != 1500 {
+		t.Errorf("timings.json = %s, want probe 1500", raw)
+	}
+	var final struct {
+		Status   string `json:"status"`
+		ExitCode int    `json:"exitCode"`
+	}
+	raw, _ = os.ReadFile(filepath.Join(a.dir, "result.json"))
+	if json.Unmarshal(raw, &final) != nil || final.Status != "accepted" || final.ExitCode != ExitSuccess {
+		t.Errorf("result.json = %s", raw)
+	}
+}
+
+func TestDebugBundle(t *testing.T) {
+	t.Setenv("EVM_PRIVATE_KEY", "0xsecret")
+	bundle := filepath.Join(t.TempDir(), "bundle.zip")
+	a, err := newArtifactRecorder(t.TempDir(), "run1", &jsonResult{Status: "accepted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.bundle, a.tempDir = bundle, true
+
+	req, _ := http.NewRequest("GET", "https://example.com", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Accept", "application/json")
+	a.writeRequest(req, "")
+	settle := base64.StdEncoding.EncodeToString([]byte(`{"success":true}`))
+	a.writeResponse("response", &http.Response{StatusCode: 200, Header: http.Header{"Payment-Response": {settle}}}, []byte("ok"))
+	a.close(ExitSuccess)
+
+	zr, err := zip.OpenReader(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(b)
+	}
+	for _, name := range []string{"request.json", "response.json", "response.body", "response.payment-response.json", "timings.json", "result.json", "environment.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("bundle is missing %s", name)
+		}
+	}
+	if strings.Contains(files["request.json"], "Bearer secret") || !strings.Contains(files["request.json"], "application/json") {
+		t.Errorf("request.json not redacted as expected: %s", files["request.json"])
+	}
+	if files["response.payment-response.json"] != `{"success":true}` {
+		t.Errorf("decoded PAYMENT-RESPONSE = %q", files["response.payment-response.json"])
+	}
+	if strings.Contains(files["environment.json"], "0xsecret") {
+		t.Errorf("environment.json leaks the private key: %s", files["environment.json"])
+	}
+	if _, err := os.Stat(a.dir); !os.IsNotExist(err) {
+		t.Errorf("temporary bundle directory %s was not removed", a.dir)
+	}
+
+	args := redactArgs([]string{"-H", "Authorization: Bearer x", "-H", "Accept: */*", "https://a.example"})
+	if args[1] != "Authorization: [redacted]" || args[3] != "Accept: */*" || args[4] != "https://a.example" {
+		t.Errorf("redactArgs = %q", args)
+	}
+}
+
+func TestDescribeAmount(t *testing.T) {
+	base := networks["base"]
+	tests := []struct {
+		name string
+		req  x402.PaymentRequirements
+		want string
+	}{
+		{"known USDC", x402.PaymentRequirements{Network: base.ChainID, Asset: base.USDCContract, Amount: "1000"},
+			"0.001 USDC (1000 atomic units)"},
+		{"extra decimals", x402.PaymentRequirements{Network: "eip155:999999", Asset: "0xabc", Amount: "1500000000000000000",
+			Extra: map[string]interface{}{"name": "DAI", "decimals": float64(18)}}, "1.50 DAI (1500000000000000000 atomic units)"},
+		{"extra decimals string", x402.PaymentRequirements{Network: "eip155:999999", Asset: "0xabc", Amount: "250",
+			Extra: map[string]interface{}{"name": "PTS", "decimals": "2"}}, "2.50 PTS (250 atomic units)"},
+		{"unknown decimals", x402.PaymentRequirements{Network: "eip155:999999", Asset: "0xabc", Amount: "1000",
+			Extra: map[string]interface{}{"name": "TOKEN"}}, "1000 TOKEN (atomic units)"},
+	}
+
+	for _, tt := range tests {
+		if got := describeAmount(tt.req); got != tt.want {
+			t.Errorf("%s: describeAmount = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeFormData(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "msg.txt")
+	os.WriteFile(file, []byte("a&b c"), 0644)
+
+	tests := []struct {
+		values []string
+		want   string
+	}{
+		{[]string{"q=hello world"}, "q=hello%20world"},
+		{[]string{"q=a&b=c"}, "q=a%26b%3Dc"},
+		{[]string{"=x=y@z"}, "x%3Dy%40z"},
+		{[]string{"plain text"}, "plain%20text"},
+		{[]string{"name=café", "n=1"}, "name=caf%C3%A9&n=1"},
+		{[]string{"msg@" + file}, "msg=a%26b%20c"},
+		{[]string{"@" + file}, "a%26b%20c"},
+		{[]string{"k=-._~"}, "k=-._~"},
+	}
+
+	for _, tt := range tests {
+		got, err := encodeFormData(tt.values)
+		if err != nil || got != tt.want {
+			t.Errorf("encodeFormData(%q) = (%q, %v), want %q", tt.values, got, err, tt.want)
+		}
+	}
+	if _, err := encodeFormData([]string{"f@/nonexistent/file"}); err == nil {
+		t.Error("encodeFormData with a missing file succeeded")
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"  --json -y\t--timeout 60s ", []string{"--json", "-y", "--timeout", "60s"}},
+		{`-H 'Accept: application/json' -H "X-Org: a \"b\""`, []string{"-H", "Accept: application/json", "-H", `X-Org: a "b"`}},
+		{`--data-urlencode q=a\ b ''`, []string{"--data-urlencode", "q=a b", ""}},
+		{`"a\nb" 'c\d'`, []string{`a\nb`, `c\d`}},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.in)
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("splitArgs(%q) = (%q, %v), want %q", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{`--json 'open`, `-H "open`, `trailing\`} {
+		if _, err := splitArgs(bad); err == nil {
+			t.Errorf("splitArgs(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestPresetArgs(t *testing.T) {
+	p := preset{
+		URL:     "https://api.example.com/weather?city={{city}}",
+		Method:  "POST",
+		Headers: []string{"X-Units: {{ units }}"},
+		Body:    `{"city": "{{city}}", "units": "{{units}}"}`,
+	}
+	if got := p.variables(); !slices.Equal(got, []string{"city", "units"}) {
+		t.Errorf("variables = %q", got)
+	}
+
+	got, err := presetArgs(p, []string{"--city", `Berlin "Mitte"&x=1`, "--units=metric", "--json", "-y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"-X", "POST",
+		"-H", "X-Units: metric",
+		"-d", `{"city": "Berlin \"Mitte\"\u0026x=1", "units": "metric"}`,
+		"--json", "-y",
+		"https://api.example.com/weather?city=Berlin%20%22Mitte%22%26x%3D1",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("presetArgs =\n%q\nwant\n%q", got, want)
+	}
+
+	if _, err := presetArgs(p, []string{"--city", "Berlin"}); err == nil || !strings.Contains(err.Error(), "--units") {
+		t.Errorf("missing variable: err = %v", err)
+	}
+	if _, err := presetArgs(preset{URL: "ftp://{{host}}/"}, []string{"--host", "x"}); err == nil {
+		t.Error("presetArgs accepted a non-http URL")
+	}
+}
+
+func TestParseSupportContact(t *testing.T) {
+	tests := []struct {
+		name         string
+		requirements string
+		want         *supportContact
+	}{
+		{"none", `{"x402Version":2,"accepts":[{"scheme":"exact","extra":{"name":"USDC"}}]}`, nil},
+		{"extensions", `{"x402Version":2,"extensions":{"support":{"email":"help@example.com","docs_url":"https://docs.example.com"}},"accepts":[]}`,
+			&supportContact{Docs: "https://docs.example.com", Email: "help@example.com"}},
+		{"resource and extra", `{"resource":{"url":"https://api.example.com/x","documentation":"https://example.com/docs"},` +
+			`"accepts":[{"extra":{"supportUrl":"https://example.com/help","contactEmail":"mailto:ops@example.com"}}]}`,
+			&supportContact{Docs: "https://example.com/docs", Email: "ops@example.com", URL: "https://example.com/help"}},
+		{"not contacts", `{"support":"call us","docs":"ftp://example.com","email":"not an email"}`, nil},
+		{"malformed", `not json`, nil},
+	}
+	for _, tt := range tests {
+		got := parseSupportContact([]byte(tt.requirements))
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("%s: parseSupportContact = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTypeRoutes(t *testing.T) {
+	var routes typeRoutes
+	for _, rule := range []string{"image/png:save=logo.png", "image/*:save=out/", "application/json:print", "*/*:discard"} {
+		if err := routes.Set(rule); err != nil {
+			t.Fatalf("Set(%q): %v", rule, err)
+		}
+	}
+	for _, bad := range []string{"image/*", "image:save", "*/png:save", "text/plain:open", "text/plain:print=x"} {
+		if err := new(typeRoutes).Set(bad); err == nil {
+			t.Errorf("Set(%q) was accepted", bad)
+		}
+	}
+
+	tests := []struct {
+		contentType, action, path string
+	}{
+		{"image/png", "save", "logo.png"},
+		{"image/jpeg", "save", filepath.Join("out", "x402-response-abcdef12.jpg")},
+		{"Application/JSON; charset=utf-8", "print", ""},
+		{"text/csv", "discard", ""},
+		{"", "discard", ""},
+	}
+	for _, tt := range tests {
+		r := routes.match(tt.contentType)
+		if r == nil || r.Action != tt.action {
+			t.Errorf("match(%q) = %+v, want %s", tt.contentType, r, tt.action)
+			continue
+		}
+		if tt.path != "" {
+			if got := r.savePath(tt.contentType, "", "abcdef1234567890"); got != tt.path {
+				t.Errorf("savePath for %q = %q, want %q", tt.contentType, got, tt.path)
+			}
+		}
+	}
+
+	// A bare save writes to -o when given.
+	var bare typeRoutes
+	bare.Set("text/*:save")
+	if got := bare.match("text/plain").savePath("text/plain", "out.txt", "abcdef12"); got != "out.txt" {
+		t.Errorf("bare save path = %q, want -o", got)
+	}
+	if got := bare.match("text/plain").savePath("text/plain", "", "abcdef12"); got != "x402-response-abcdef12.txt" {
+		t.Errorf("bare save path = %q", got)
+	}
+	if (typeRoutes{}).match("text/plain") != nil {
+		t.Error("no rules matched a type")
+	}
+}
+
+func TestTransformBody(t *testing.T) {
+	body := []byte(`{"data":{"result":"sunny","image":"aGVsbG8=","items":[{"url":"https://a"},{"url":"https://b"}],"n":{"x": 1},"a key":true}}`)
+	tests := []struct {
+		path, decode string
+		want         string
+		wantErr      bool
+	}{
+		{".data.result", "", "sunny", false},
+		{".data.items[1].url", "", "https://b", false},
+		{".data.items[-1].url", "", "https://b", false},
+		{".data.n", "", `{"x":1}`, false},
+		{`.data["a key"]`, "", "true", false},
+		{".data.image", "base64", "hello", false},
+		{"", "", string(body), false},
+		{".data.missing", "", "", true},
+		{".data.items[2]", "", "", true},
+		{".data.result.x", "", "", true},
+		{".data.result", "base64", "", true},
+		{"data", "", "", true},
+		{".data.items[x]", "", "", true},
+	}
+	for _, tt := range tests {
+		got, _, err := transformBody(body, tt.path, tt.decode)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && string(got) != tt.want) {
+			t.Errorf("transformBody(%q, %q) = (%q, %v), want %q (error %v)", tt.path, tt.decode, got, err, tt.want, tt.wantErr)
+		}
+	}
+	if _, _, err := transformBody([]byte("not json"), ".a", ""); err == nil {
+		t.Error("transformBody accepted a non-JSON body")
+	}
+}
+
+func TestWithNegotiation(t *testing.T) {
+	got := withNegotiation(headerFlags{"X-Org: a"}, "application/json", "de-CH")
+	if len(got) != 3 || got[1] != "Accept: application/json" || got[2] != "Accept-Language: de-CH" {
+		t.Errorf("withNegotiation() = %q", got)
+	}
+	got = withNegotiation(headerFlags{"accept: text/csv"}, "application/json", "")
+	if len(got) != 1 || got[0] != "accept: text/csv" {
+		t.Errorf("-H Accept should win: %q", got)
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	tests := []struct {
+		body string
+		want string // substring of the error; "" for valid
+	}{
+		{`{"a": [1, 2]}`, ""},
+		{`"text"`, ""},
+		{"", "body is empty"},
+		{`{"a": 1,}`, "line 1, column 9"},
+		{"{\n  \"b\": [1, 2,]\n}", "line 2, column 14"},
+		{`{"a": `, "line 1, column 7: unexpected end of JSON input"},
+		{`{"a": 1} x`, "line 1, column 10"},
+	}
+	for _, tt := range tests {
+		err := validateJSON(tt.body)
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("validateJSON(%q) = %v, want %q", tt.body, err, tt.want)
+		}
+	}
+	err := validateJSON("{\"a\": 1,}")
+	if lines := strings.Split(err.Error(), "\n"); len(lines) != 3 || lines[2] != "          ^" {
+		t.Errorf("pointer = %q", err.Error())
+	}
+}
+
+func TestAddQueryParams(t *testing.T) {
+	tests := []struct {
+		url    string
+		params []string
+		want   string
+	}{
+		{"https://api.example.com/search", []string{"q=hello world", "lang=en"}, "https://api.example.com/search?q=hello%20world&lang=en"},
+		{"https://api.example.com/search?page=2", []string{"q=a&b=c"}, "https://api.example.com/search?page=2&q=a%26b%3Dc"},
+		{"https://api.example.com/search?", []string{"q="}, "https://api.example.com/search?q="},
+		{"https://api.example.com/doc#top", []string{"id=#1"}, "https://api.example.com/doc?id=%231#top"},
+		{"https://api.example.com/", []string{"a b=café"}, "https://api.example.com/?a%20b=caf%C3%A9"},
+	}
+	for _, tt := range tests {
+		got, err := addQueryParams(tt.url, tt.params)
+		if err != nil || got != tt.want {
+			t.Errorf("addQueryParams(%q, %q) = (%q, %v), want %q", tt.url, tt.params, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"novalue", "=x"} {
+		if _, err := addQueryParams("https://api.example.com/", []string{bad}); err == nil {
+			t.Errorf("addQueryParams accepted %q", bad)
+		}
+	}
+}
+
+func TestIsNonceReplay(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   bool
+	}{
+		{"invalid_exact_evm_nonce_already_used", true},
+		{"nonce already used: 0xabc", true},
+		{"settlement failed: execution reverted: FiatTokenV2: authorization is used or canceled", true},
+		{"invalid_exact_evm_insufficient_balance", false},
+		{"invalid_exact_evm_failed_to_check_nonce", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isNonceReplay(tt.reason); got != tt.want {
+			t.Errorf("isNonceReplay(%q) = %v, want %v", tt.reason, got, tt.want)
+		}
+	}
+}
+
+func TestRecentPayment(t *testing.T) {
+	now := time.Now()
+	records := []historyRecord{
+		{Time: now.Add(-time.Hour), Endpoint: "https://a/x", Method: "GET", BodySHA256: bodyHash(""), Status: "accepted", Transaction: "0x1"},
+		{Time: now.Add(-2 * time.Minute), Endpoint: "https://a/x", Method: "POST", BodySHA256: bodyHash(`{"q":1}`), Status: "accepted", Transaction: "0x2"},
+		{Time: now.Add(-time.Minute), Endpoint: "https://a/x", Method: "GET", BodySHA256: bodyHash(""), Status: "rejected"},
+	}
+
+	tests := []struct {
+		name         string
+		method, data string
+		window       time.Duration
+		wantTx       string
+	}{
+		{"same body in window", "POST", `{"q":1}`, 10 * time.Minute, "0x2"},
+		{"different body", "POST", `{"q":2}`, 10 * time.Minute, ""},
+		{"outside window", "GET", "", 10 * time.Minute, ""},
+		{"rejected payments do not count", "GET", "", 5 * time.Minute, ""},
+		{"wide window", "GET", "", 2 * time.Hour, "0x1"},
+	}
+	for _, tt := range tests {
+		got := recentPayment(records, "https://a/x", tt.method, tt.data, tt.window, now)
+		gotTx := ""
+		if got != nil {
+			gotTx = got.Transaction
+		}
+		if gotTx != tt.wantTx {
+			t.Errorf("%s: recentPayment = %q, want %q", tt.name, gotTx, tt.wantTx)
+		}
+	}
+}
+
+func TestHistoryLedger(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	if records, err := loadHistory(); err != nil || len(records) != 0 {
+		t.Fatalf("empty ledger: loadHistory = (%v, %v)", records, err)
+	}
+
+	payload := base64.StdEncoding.EncodeToString([]byte(`{"x402Version":2,"payload":{"authorization":{"from":"0xPAYER"}},` +
+		`"accepted":{"scheme":"exact","network":"eip155:8453","asset":"0xUSDC","amount":"1000","payTo":"0xSELLER"}}`))
+	resp := &http.Response{StatusCode: 200, Header: http.Header{}}
+	resp.Header.Set("PAYMENT-RESPONSE", base64.StdEncoding.EncodeToString([]byte(`{"success":true,"transaction":"0xTX","network":"eip155:8453"}`)))
+	rec := newHistoryRecord("https://a/x", "GET", "", payload, resp)
+	pendingHistory = &pendingPayment{record: rec, result: &jsonResult{Status: "accepted"}}
+	flushHistory()
+
+	records, err := loadHistory()
+	if err != nil || len(records) != 1 {
+		t.Fatalf("loadHistory = (%v, %v), want 1 record", records, err)
+	}
+	got := records[0]
+	if got.Status != "accepted" || got.Amount != "1000" || got.Payer != "0xPAYER" || got.Transaction != "0xTX" || got.PayTo != "0xSELLER" || got.RequestID != requestID {
+		t.Errorf("recorded %+v", got)
+	}
+	if pendingHistory != nil {
+		t.Error("flushHistory should clear the pending payment")
+	}
+}
+
+func TestConcurrentHistory(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	const writers = 50
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := appendHistory(historyRecord{ID: strconv.Itoa(i), Status: "accepted"}); err != nil {
+				t.Error(err)
+			}
+			if i%10 == 0 {
+				// A concurrent rewrite (as resolve does) must not drop appends in flight.
+				err := updateHistory(func(records []historyRecord) []historyRecord { return records })
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	records, err := loadHistory()
+	if err != nil || len(records) != writers {
+		t.Fatalf("loadHistory = %d records (%v), want %d", len(records), err, writers)
+	}
+}
+
+func TestEncryptedHistory(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("X402_STATE_KEY", "correct horse")
+
+	// A record written before encryption was enabled stays readable.
+	if err := appendHistory(historyRecord{ID: "plain", Endpoint: "https://old.example/x"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("X402_ENCRYPT_STATE", "1")
+	if err := appendHistory(historyRecord{ID: "sealed", Endpoint: "https://secret.example/x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	path, _ := stateFile("history.jsonl")
+	raw, _ := os.ReadFile(path)
+	if strings.Contains(string(raw), "secret.example") || !strings.Contains(string(raw), sealedPrefix) {
+		t.Errorf("ledger is not encrypted:\n%s", raw)
+	}
+	records, err := loadHistory()
+	if err != nil || len(records) != 2 || records[1].Endpoint != "https://secret.example/x" {
+		t.Fatalf("loadHistory = (%+v, %v)", records, err)
+	}
+
+	if salt, err := os.ReadFile(filepath.Join(filepath.Dir(path), stateSaltFile)); err != nil || len(salt) != 16 {
+		t.Errorf("state salt = %x, %v", salt, err)
+	}
+
+	// Once encrypted, the ledger stays encrypted when encryption is turned off.
+	t.Setenv("X402_ENCRYPT_STATE", "")
+	if err := appendHistory(historyRecord{ID: "after", Endpoint: "https://later.example/x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateHistory(func(records []historyRecord) []historyRecord { return records }); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ = os.ReadFile(path)
+	if strings.Contains(string(raw), "later.example") || strings.Contains(string(raw), "old.example") {
+		t.Errorf("an encrypted ledger was written in plaintext:\n%s", raw)
+	}
+
+	// Lines sealed under the SHA-256 key of earlier versions stay readable.
+	gcm, _ := newStateCipher(legacyStateKey("correct horse"))
+	nonce := make([]byte, gcm.NonceSize())
+	legacy := legacySealedPrefix + base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(`{"id":"legacy"}`), nil))
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	f.WriteString(legacy + "\n")
+	f.Close()
+	if records, err := loadHistory(); err != nil || len(records) != 4 || records[3].ID != "legacy" {
+		t.Errorf("loadHistory with a legacy line = (%+v, %v)", records, err)
+	}
+
+	t.Setenv("X402_STATE_KEY", "wrong")
+	if _, err := loadHistory(); err == nil || !strings.Contains(err.Error(), "wrong key") {
+		t.Errorf("loadHistory with the wrong key: err = %v", err)
+	}
+}
+
+func TestSQLiteHistory(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("X402_STATE_KEY", "correct horse")
+
+	for _, id := range []string{"a", "b"} {
+		if err := appendHistory(historyRecord{ID: id, Status: "accepted", Endpoint: "https://secret.example/x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// With the JSONL ledger in place, the SQLite backend refuses to start from an empty one.
+	t.Setenv("X402_HISTORY_BACKEND", "sqlite")
+	if _, err := loadHistory(); err == nil || !strings.Contains(err.Error(), "history migrate --to sqlite") {
+		t.Fatalf("sqlite backend before migrating: err = %v", err)
+	}
+	result, err := migrateHistory(historySQLite)
+	if err != nil || result.From != historyJSONL || result.Records != 2 {
+		t.Fatalf("migrateHistory = (%+v, %v)", result, err)
+	}
+	if _, err := migrateHistory(historySQLite); err == nil || !strings.Contains(err.Error(), "already holds") {
+		t.Errorf("migrating into a non-empty ledger: err = %v", err)
+	}
+
+	t.Setenv("X402_ENCRYPT_STATE", "1")
+	if err := appendHistory(historyRecord{ID: "c", Status: "pending", Endpoint: "https://secret.example/y"}); err != nil {
+		t.Fatal(err)
+	}
+	err = updateHistory(func(records []historyRecord) []historyRecord {
+		records[2].Status = "accepted"
+		return records
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := loadHistory()
+	if err != nil || len(records) != 3 || records[0].ID != "a" || records[2].ID != "c" || records[2].Status != "accepted" {
+		t.Fatalf("loadHistory = (%+v, %v)", records, err)
+	}
+
+	path, _ := stateFile("history.db")
+	lines, err := historyLines(historySQLite, path)
+	if err != nil || len(lines) != 3 {
+		t.Fatalf("historyLines = (%d, %v)", len(lines), err)
+	}
+	for _, line := range lines {
+		if strings.Contains(string(line), "secret.example") {
+			t.Errorf("the SQLite ledger holds a plaintext record: %s", line)
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("history.db mode = %v, %v", info.Mode(), err)
+	}
+
+	// Back to JSONL: the sealed lines are copied as they are.
+	jsonl, _ := stateFile("history.jsonl")
+	os.Remove(jsonl)
+	if result, err := migrateHistory(historyJSONL); err != nil || result.Records != 3 {
+		t.Fatalf("migrateHistory back = (%+v, %v)", result, err)
+	}
+	t.Setenv("X402_HISTORY_BACKEND", "jsonl")
+	if records, err := loadHistory(); err != nil || len(records) != 3 || records[2].Status != "accepted" {
+		t.Errorf("loadHistory after migrating back = (%+v, %v)", records, err)
+	}
+
+	t.Setenv("X402_HISTORY_BACKEND", "postgres")
+	if _, err := loadHistory(); err == nil {
+		t.Error("an unknown backend should be refused")
+	}
+}
+
+func TestPurgeHistory(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	for _, tt := range []struct {
+		spec string
+		want time.Duration
+	}{
+		{"90d", 90 * 24 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{"1.5d", 36 * time.Hour},
+		{"720h", 720 * time.Hour},
+	} {
+		if got, err := parseAge(tt.spec); err != nil || got != tt.want {
+			t.Errorf("parseAge(%q) = (%v, %v), want %v", tt.spec, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "d", "-5d", "soon"} {
+		if _, err := parseAge(bad); err == nil {
+			t.Errorf("parseAge(%q) should fail", bad)
+		}
+	}
+
+	now := time.Now()
+	old := now.Add(-100 * 24 * time.Hour)
+	if err := saveHistory([]historyRecord{
+		{ID: "old", Time: old, Status: "accepted"},
+		{ID: "stuck", Time: old, Status: "pending"},
+		{ID: "new", Time: now, Status: "accepted"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyRetention("90d", now); err != nil {
+		t.Fatal(err)
+	}
+	records, _ := loadHistory()
+	var ids []string
+	for _, r := range records {
+		ids = append(ids, r.ID)
+	}
+	if !slices.Equal(ids, []string{"stuck", "new"}) {
+		t.Errorf("after a 90d retention the ledger holds %v, want [stuck new]", ids)
+	}
+}
+
+func TestRankAccepts(t *testing.T) {
+	records := []historyRecord{
+		{Status: "accepted", Network: "eip155:8453", LatencyMs: 3000},
+		{Status: "accepted", Network: "eip155:8453", LatencyMs: 1000},
+		{Status: "accepted", Network: "eip155:8453", LatencyMs: 2000},
+		{Status: "accepted", Network: "eip155:43114", LatencyMs: 500},
+		{Status: "rejected", Network: "eip155:43114", LatencyMs: 1},
+	}
+	latencies := settlementLatencies(records)
+	if latencies["eip155:8453"] != 2*time.Second || latencies["eip155:43114"] != 500*time.Millisecond {
+		t.Fatalf("settlementLatencies = %v", latencies)
+	}
+
+	cand := func(index, funded int, network string) acceptCandidate {
+		return acceptCandidate{req: x402.PaymentRequirements{Network: network}, index: index, funded: funded, latency: latencies[network]}
+	}
+	tests := []struct {
+		name       string
+		candidates []acceptCandidate
+		want       []int
+	}{
+		{"funded first", []acceptCandidate{cand(0, -1, "eip155:43114"), cand(1, 1, "eip155:8453")}, []int{1, 0}},
+		{"faster settlement", []acceptCandidate{cand(0, 1, "eip155:8453"), cand(1, 1, "eip155:43114")}, []int{1, 0}},
+		{"measured before unknown", []acceptCandidate{cand(0, 0, "eip155:84532"), cand(1, 0, "eip155:8453")}, []int{1, 0}},
+		{"server order on ties", []acceptCandidate{cand(0, 0, "eip155:1"), cand(1, 0, "eip155:2")}, []int{0, 1}},
+	}
+	for _, tt := range tests {
+		var got []int
+		for _, c := range rankAccepts(tt.candidates) {
+			got = append(got, c.index)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: rankAccepts order = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAffordabilityMatrix(t *testing.T) {
+	requirements := `{"x402Version":2,"accepts":[
+		{"network":"eip155:84532","asset":"0x036CbD53842c5426634e7929541eC2318f3dCF7e","amount":"1000"},
+		{"network":"eip155:8453","asset":"0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913","amount":"1000"},
+		{"network":"eip155:43114","asset":"0xB97EF9Ef8734C71904D8002F8b6Bc66Dd9c48a6E","amount":"1000"},
+		{"network":"eip155:999999","asset":"0x2222222222222222222222222222222222222222","amount":"1000"}]}`
+	balances := map[string]string{"eip155:84532": "5000", "eip155:8453": "999"}
+	balanceOf := func(info networkInfo, asset, address string) (string, error) {
+		if raw, ok := balances[info.ChainID]; ok {
+			return raw, nil
+		}
+		return "", errors.New("rpc unavailable")
+	}
+
+	rows, err := affordabilityMatrix([]byte(requirements), "0x1111111111111111111111111111111111111111", balanceOf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("got %d rows, want 4", len(rows))
+	}
+	for i, want := range []string{"true", "false", "unknown", "unknown"} {
+		got := "unknown"
+		if rows[i].Affordable != nil {
+			got = strconv.FormatBool(*rows[i].Affordable)
+		}
+		if got != want {
+			t.Errorf("row %d affordable = %s, want %s", i, got, want)
+		}
+		if want == "unknown" && rows[i].Reason == "" {
+			t.Errorf("row %d: unchecked without a reason", i)
+		}
+	}
+	if rows[1].Balance != "999" {
+		t.Errorf("row 1 balance = %q, want 999", rows[1].Balance)
+	}
+}
+
+func TestOrderAccepts(t *testing.T) {
+	accepts := []x402.PaymentRequirements{
+		{Network: "eip155:43114"}, {Network: "eip155:84532"}, {Network: "eip155:8453"}, {Network: "eip155:1"},
+	}
+	networksOf := func(reqs []x402.PaymentRequirements) []string {
+		var out []string
+		for _, r := range reqs {
+			out = append(out, r.Network)
+		}
+		return out
+	}
+
+	preferred, err := parseNetworkList("base, base-sepolia")
+	if err != nil || !slices.Equal(preferred, []string{"eip155:8453", "eip155:84532"}) {
+		t.Fatalf("parseNetworkList = (%v, %v)", preferred, err)
+	}
+	if _, err := parseNetworkList("base,solana"); err == nil {
+		t.Error("parseNetworkList should reject an unknown network")
+	}
+
+	got, _ := orderAccepts(accepts, "", preferred)
+	if want := []string{"eip155:8453", "eip155:84532", "eip155:43114", "eip155:1"}; !slices.Equal(networksOf(got), want) {
+		t.Errorf("preferred order = %v, want %v", networksOf(got), want)
+	}
+	got, _ = orderAccepts(accepts, "eip155:43114", preferred)
+	if want := []string{"eip155:43114"}; !slices.Equal(networksOf(got), want) {
+		t.Errorf("--network order = %v, want %v", networksOf(got), want)
+	}
+	if _, err := orderAccepts(accepts, "eip155:10", nil); err == nil {
+		t.Error("orderAccepts should fail when the network is not offered")
+	}
+}
+
+func TestMainnetGuard(t *testing.T) {
+	for network, want := range map[string]bool{
+		"eip155:84532": true, "base-sepolia": true, "eip155:43113": true,
+		"eip155:8453": false, "avalanche": false, "eip155:999999": false, "": false,
+	} {
+		if got := isTestnet(network); got != want {
+			t.Errorf("isTestnet(%q) = %v, want %v", network, got, want)
+		}
+	}
+	kept := testnetAccepts([]x402.PaymentRequirements{{Network: "eip155:8453"}, {Network: "eip155:84532"}})
+	if len(kept) != 1 || kept[0].Network != "eip155:84532" {
+		t.Errorf("testnetAccepts = %+v", kept)
+	}
+
+	sent := 0
+	rt := roundTripFunc(func(*http.Request) (*http.Response, error) {
+		sent++
+		return &http.Response{StatusCode: 200}, nil
+	})
+	pay := func(payload string, allowed bool) error {
+		req, _ := http.NewRequest("GET", "https://api.example.com/x", nil)
+		if payload != "" {
+			req.Header.Set("PAYMENT-SIGNATURE", base64.StdEncoding.EncodeToString([]byte(payload)))
+		}
+		_, err := mainnetGuard(rt, allowed).RoundTrip(req)
+		return err
+	}
+	tests := []struct {
+		name    string
+		payload string
+		allowed bool
+		wantErr bool
+	}{
+		{"probe without payment", "", false, false},
+		{"v2 testnet", `{"accepted":{"network":"eip155:84532"}}`, false, false},
+		{"v1 testnet", `{"network":"base-sepolia"}`, false, false},
+		{"v2 mainnet", `{"accepted":{"network":"eip155:8453"}}`, false, true},
+		{"v1 mainnet", `{"network":"base"}`, false, true},
+		{"mainnet allowed", `{"accepted":{"network":"eip155:8453"}}`, true, false},
+	}
+	for _, tt := range tests {
+		before := sent
+		err := pay(tt.payload, tt.allowed)
+		if (err != nil) != tt.wantErr || (sent > before) == tt.wantErr {
+			t.Errorf("%s: err = %v, sent = %v", tt.name, err, sent > before)
+		}
+	}
+}
+
+func TestNoSpendTransport(t *testing.T) {
+	sent := 0
+	rt := roundTripFunc(func(*http.Request) (*http.Response, error) {
+		sent++
+		return &http.Response{StatusCode: 402}, nil
+	})
+	defer func(saved bool) { noSpend = saved }(noSpend)
+	payment := base64.StdEncoding.EncodeToString([]byte(`{"accepted":{"network":"eip155:84532"},"payload":{"authorization":{"from":"0xPAYER"}}}`))
+
+	noSpend = false
+	req, _ := http.NewRequest("GET", "https://api.example.com/x", nil)
+	req.Header.Set("PAYMENT-SIGNATURE", payment)
+	if resp, _ := noSpendTransport(rt).RoundTrip(req); resp.StatusCode != 402 || sent != 1 {
+		t.Fatalf("without --no-spend the payment must be sent: status %d, sent %d", resp.StatusCode, sent)
+	}
+
+	noSpend = true
+	probe, _ := http.NewRequest("GET", "https://api.example.com/x", nil)
+	if resp, _ := noSpendTransport(rt).RoundTrip(probe); resp.StatusCode != 402 || sent != 2 {
+		t.Errorf("the unpaid probe must still be sent: status %d, sent %d", resp.StatusCode, sent)
+	}
+	resp, err := noSpendTransport(rt).RoundTrip(req)
+	if err != nil || resp.StatusCode != 200 || sent != 2 || resp.Header.Get(noSpendHeader) == "" {
+		t.Fatalf("--no-spend payment: resp = %+v, err = %v, sent %d", resp, err, sent)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(resp.Header.Get("PAYMENT-RESPONSE"))
+	var settle x402.SettleResponse
+	if json.Unmarshal(raw, &settle) != nil || !settle.Success || settle.Payer != "0xPAYER" || settle.Network != "eip155:84532" {
+		t.Errorf("made-up PAYMENT-RESPONSE = %s", raw)
+	}
+
+	for _, tt := range []struct {
+		args []string
+		on   bool
+		rest int
+	}{
+		{[]string{"x402-cli", "--no-spend", "batch", "f.yaml"}, true, 3},
+		{[]string{"x402-cli", "batch", "--no-spend=false", "f.yaml"}, false, 3},
+		{[]string{"x402-cli", "-y", "--", "--no-spend"}, false, 4},
+	} {
+		if on, rest := extractGlobalSwitch(tt.args, "no-spend"); on != tt.on || len(rest) != tt.rest {
+			t.Errorf("extractGlobalSwitch(%q) = %v, %q", tt.args, on, rest)
+		}
+	}
+}
+
+func TestWriteCSVReport(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeCSVReport(&buf, []batchResult{
+		{Name: "weather", URL: "https://a/w", Method: "GET", Status: "accepted", StatusCode: 200, Price: "0.01 USDC",
+			Amount: "10000", Asset: "0xUSDC", Network: "Base", LatencyMs: 812, Transaction: "0xTX"},
+		{Name: "quote, daily", URL: "https://a/q", Method: "POST", Status: "rejected", StatusCode: 402, Error: `bad "sig"`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "name,url,method,status,status_code,price,amount,asset,network,latency_ms,transaction,error\n" +
+		"weather,https://a/w,GET,accepted,200,0.01 USDC,10000,0xUSDC,Base,812,0xTX,\n" +
+		`"quote, daily",https://a/q,POST,rejected,402,,,,,0,,"bad ""sig"""` + "\n"
+	if buf.String() != want {
+		t.Errorf("CSV report:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestBatchRequestID(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get(traceHeader))
+	}))
+	defer srv.Close()
+
+	r := payEndpoint(http.DefaultTransport, nil, dashboardEndpoint{URL: srv.URL, Method: "GET"}, batchOptions{timeout: 5 * time.Second})
+	if r.Status != "free" || r.RequestID != requestID {
+		t.Errorf("payEndpoint = %+v, want free with request ID %s", r, requestID)
+	}
+	if len(got) != 1 || got[0] != requestID {
+		t.Errorf("%s headers = %q, want [%s]", traceHeader, got, requestID)
+	}
+}
+
+func TestRunScript(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	required := base64.StdEncoding.EncodeToString([]byte(`{"x402Version":2,"resource":{"url":"http://x/paid"},"accepts":[{"scheme":"exact",` +
+		`"network":"eip155:84532","asset":"0x036CbD53842c5426634e7929541eC2318f3dCF7e","amount":"20000","payTo":"0x1111111111111111111111111111111111111111",` +
+		`"maxTimeoutSeconds":60,"extra":{"name":"USDC","version":"2"}}]}`))
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.RequestURI())
+		switch r.URL.Path {
+		case "/search":
+			w.Write([]byte(`{"results":[{"id":"a b","n":2}]}`))
+		case "/paid":
+			w.Header().Set("PAYMENT-REQUIRED", required)
+			w.WriteHeader(http.StatusPaymentRequired)
+		default:
+			w.Write([]byte(`{"ok":true}`))
+		}
+	}))
+	defer srv.Close()
+
+	flow, err := parseScriptYAML(`budget: 0.01USDC
+steps:
+  - name: search
+    url: ` + srv.URL + `/search?q={{query}}
+    capture: id=$.results[0].id
+    capture: n=$.results[0].n
+  - name: detail
+    url: ` + srv.URL + `/items/{{id}}?n={{n}}
+  - name: paid
+    url: ` + srv.URL + `/paid
+  - name: never
+    url: ` + srv.URL + `/never
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit, _ := parseTokenAmount(flow.Budget)
+	results, spent := runScript(flow, map[string]string{"query": "x&y"}, limit, http.DefaultTransport, nil, batchOptions{timeout: 5 * time.Second}, nil)
+
+	if len(results) != 3 {
+		t.Fatalf("ran %d steps, want 3 (stop at the budget): %+v", len(results), results)
+	}
+	if results[0].Captures["id"] != "a b" || results[0].Captures["n"] != "2" {
+		t.Errorf("captures = %v", results[0].Captures)
+	}
+	if want := []string{"/search?q=x%26y", "/items/a%20b?n=2", "/paid"}; !slices.Equal(paths, want) {
+		t.Errorf("requested %q, want %q", paths, want)
+	}
+	if results[2].Status != "budget_exceeded" || !strings.Contains(results[2].Error, "script budget 0.01 exceeded") {
+		t.Errorf("paid step = %s (%s), want budget_exceeded", results[2].Status, results[2].Error)
+	}
+	if spent.Sign() != 0 {
+		t.Errorf("spent = %s, want 0", ratString(spent))
+	}
+
+	if _, err := parseScriptYAML("steps:\n  - url: http://x\n    capture: nope\n"); err == nil {
+		t.Error("parseScriptYAML accepted a capture without a path")
+	}
+	st := scriptStep{URL: "http://x/{{missing}}"}
+	if _, err := st.endpoint(map[string]string{}); err == nil {
+		t.Error("endpoint accepted an undefined variable")
+	}
+}
+
+func TestPayURLs(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.URL.Path] = r.Header.Get("Accept")
+		mu.Unlock()
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	urls := []string{srv.URL + "/a", srv.URL + "/missing", srv.URL + "/c"}
+	results := payURLs(urls, "GET", "", headerFlags{"Accept: application/json"}, http.DefaultTransport, nil, batchOptions{timeout: 5 * time.Second})
+	for i, want := range []string{"free", "no_402", "free"} {
+		if results[i].URL != urls[i] || results[i].Status != want {
+			t.Errorf("results[%d] = %s %s, want %s %s", i, results[i].URL, results[i].Status, urls[i], want)
+		}
+	}
+	if len(seen) != 3 || seen["/c"] != "application/json" {
+		t.Errorf("requests = %v", seen)
+	}
+
+	fs := flag.NewFlagSet("x402-cli", flag.ContinueOnError)
+	fs.Bool("json", false, "")
+	fs.String("extract", "", "")
+	fs.Bool("simulate", false, "")
+	fs.Parse([]string{"--json", "--simulate", "--extract", ".a"})
+	if got := unsupportedMultiFlags(fs); !slices.Equal(got, []string{"--extract", "--simulate"}) {
+		t.Errorf("unsupportedMultiFlags() = %q", got)
+	}
+}
+
+func TestBatchExitCode(t *testing.T) {
+	tests := []struct {
+		statuses []string
+		want     int
+	}{
+		{[]string{"accepted", "accepted"}, ExitSuccess},
+		{[]string{"accepted", "free"}, ExitFreeRoute},
+		{[]string{"payment_required", "accepted"}, ExitSuccess},
+		{[]string{"free", "rejected", "accepted"}, ExitError},
+		{[]string{"error"}, ExitError},
+	}
+	for _, tt := range tests {
+		var results []batchResult
+		for _, s := range tt.statuses {
+			results = append(results, batchResult{Status: s})
+		}
+		if got := batchExitCode(results); got != tt.want {
+			t.Errorf("batchExitCode(%v) = %d, want %d", tt.statuses, got, tt.want)
+		}
+	}
+}
+
+func TestSpendTotals(t *testing.T) {
+	const usdc = "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+	results := []batchResult{
+		{Status: "accepted", Amount: "10000", Asset: usdc, chainID: "eip155:84532"},
+		{Status: "accepted", Amount: "2500", Asset: strings.ToLower(usdc), chainID: "eip155:84532"},
+		{Status: "rejected", Amount: "99999", Asset: usdc, chainID: "eip155:84532"},
+		{Status: "free"},
+		{Status: "payment_required", Amount: "1000", Asset: usdc, chainID: "eip155:84532"},
+		{Status: "payment_required", Amount: "7", Asset: "0xOther", chainID: "eip155:8453"},
+	}
+	if estimate := spendTotals(results, "payment_required"); len(estimate) != 2 ||
+		estimate[0].Network != "eip155:8453" || estimate[0].Amount != "7" || estimate[1].Amount != "1000" {
+		t.Errorf("estimated totals = %+v", estimate)
+	}
+	totals := spendTotals(results, "accepted")
+	if len(totals) != 1 || totals[0].Amount != "12500" {
+		t.Fatalf("spendTotals = %+v, want one total of 12500", totals)
+	}
+	if got := totals[0].Display(); !strings.HasPrefix(got, "0.0125 USDC") {
+		t.Errorf("Display() = %q", got)
+	}
+}
+
+func TestBatchSummary(t *testing.T) {
+	const usdc = "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+	results := []batchResult{
+		{Status: "accepted", Amount: "10000", Asset: usdc, chainID: "eip155:84532"},
+		{Status: "accepted", Amount: "2500", Asset: usdc, chainID: "eip155:84532"},
+		{Status: "rejected", Amount: "99999", Asset: usdc, chainID: "eip155:84532"},
+	}
+	s := newBatchSummary(results, 1500*time.Millisecond)
+	if s.Type != "summary" || s.Endpoints != 3 || s.Counts["accepted"] != 2 || s.Counts["rejected"] != 1 ||
+		len(s.Spent) != 1 || s.Spent[0].Amount != "12500" || s.DurationMs != 1500 || s.ExitCode != ExitError {
+		t.Errorf("newBatchSummary() = %+v", s)
+	}
+	if line, _ := json.Marshal(newBatchSummary(nil, 0)); !strings.Contains(string(line), `"counts":{},"spent":[]`) {
+		t.Errorf("empty summary = %s", line)
+	}
+}
+
+func TestBatchDedupe(t *testing.T) {
+	const usdc = "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+	a := dashboardEndpoint{Name: "a", URL: "https://a/x", Method: "GET"}
+	b := dashboardEndpoint{Name: "b", URL: "https://a/x", Method: "get"}
+	if a.requestKey() != b.requestKey() {
+		t.Error("the same request under another name should have the same key")
+	}
+	for _, other := range []dashboardEndpoint{
+		{URL: "https://a/x", Method: "POST"},
+		{URL: "https://a/x", Method: "GET", body: "q=1"},
+		{URL: "https://a/x", Method: "GET", headers: headerFlags{"Accept: text/csv"}},
+	} {
+		if other.requestKey() == a.requestKey() {
+			t.Errorf("%+v should differ from %+v", other, a)
+		}
+	}
+
+	first := batchResult{Name: "a", URL: a.URL, Method: "GET", Status: "accepted", Amount: "1000", Asset: usdc,
+		chainID: "eip155:84532", LatencyMs: 40, Transaction: "0xTX", Receipt: "r.json"}
+	reused := reuseResult(first, b)
+	if reused.Name != "b" || reused.DuplicateOf != "a" || reused.Transaction != "0xTX" || reused.LatencyMs != 0 || reused.Receipt != "" {
+		t.Errorf("reuseResult() = %+v", reused)
+	}
+	results := []batchResult{first, reused}
+	if totals := spendTotals(results, "accepted"); len(totals) != 1 || totals[0].Amount != "1000" {
+		t.Errorf("a reused result must not count as spent: %+v", totals)
+	}
+	if s := newBatchSummary(results, 0); s.Counts["accepted"] != 2 || s.Reused != 1 {
+		t.Errorf("summary = %+v", s)
+	}
+}
+
+func TestMarkdownAndHTMLReports(t *testing.T) {
+	results := []batchResult{
+		{URL: "https://a/w", Method: "GET", Status: "accepted", Price: "0.01 USDC", Network: "Base", Transaction: "0xTX"},
+		{URL: "https://a/<b>", Method: "GET", Status: "rejected", Error: "bad | sig"},
+	}
+
+	var md bytes.Buffer
+	if err := writeMarkdownReport(&md, results); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"**1 of 2 endpoints passed.**", "| pass | https://a/w | GET | accepted |", `rejected: bad \| sig`, "Nothing was paid."} {
+		if !strings.Contains(md.String(), want) {
+			t.Errorf("Markdown report missing %q:\n%s", want, md.String())
+		}
+	}
+
+	var page bytes.Buffer
+	if err := writeHTMLReport(&page, results); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(page.String(), "<b>") || !strings.Contains(page.String(), "https://a/&lt;b&gt;") {
+		t.Errorf("HTML report does not escape endpoint URLs:\n%s", page.String())
+	}
+	if !strings.Contains(page.String(), `<td class="fail">FAIL</td>`) {
+		t.Errorf("HTML report missing failed row:\n%s", page.String())
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"3", 3 * time.Second, true},
+		{" 0 ", 0, true},
+		{"Fri, 02 Jan 2026 15:04:35 GMT", 30 * time.Second, true},
+		{"Fri, 02 Jan 2026 15:00:00 GMT", 0, true},
+		{"-5", 0, false},
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q) = (%v, %v), want (%v, %v)", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestRateLimitWait(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	resp.Header.Set("Retry-After", "2")
+	resp.Header.Set("X-RateLimit-Remaining", "0")
+
+	if wait, ok := rateLimitWait(resp, 0, 5*time.Second, time.Now()); wait != 2*time.Second || !ok {
+		t.Errorf("first wait = (%v, %v), want (2s, true)", wait, ok)
+	}
+	if _, ok := rateLimitWait(resp, 4*time.Second, 5*time.Second, time.Now()); ok {
+		t.Error("wait past --max-wait should not be allowed")
+	}
+	if _, ok := rateLimitWait(resp, 0, 0, time.Now()); ok {
+		t.Error("--max-wait 0 should never wait")
+	}
+	resp.Header.Del("Retry-After")
+	if wait, _ := rateLimitWait(resp, 0, time.Minute, time.Now()); wait != defaultRetryAfter {
+		t.Errorf("wait without Retry-After = %v, want %v", wait, defaultRetryAfter)
+	}
+	if info := newRateLimitInfo("probe", resp); info.Remaining != "0" || info.Step != "probe" {
+		t.Errorf("newRateLimitInfo = %+v", info)
+	}
+}
+
+func TestRedirectPolicy(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusPaymentRequired)
+	}))
+	defer other.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/paid", http.StatusFound)
+		case "/elsewhere":
+			http.Redirect(w, r, other.URL+"/paid", http.StatusTemporaryRedirect)
+		default:
+			w.WriteHeader(http.StatusPaymentRequired)
+		}
+	}))
+	defer origin.Close()
+
+	tests := []struct {
+		name        string
+		path        string
+		trust       bool
+		confirm     func(from, to string) bool
+		wantStatus  int
+		wantBlocked bool
+	}{
+		{"same origin is followed", "/moved", false, nil, http.StatusPaymentRequired, false},
+		{"cross origin is refused", "/elsewhere", false, nil, http.StatusTemporaryRedirect, true},
+		{"declined confirmation", "/elsewhere", false, func(_, _ string) bool { return false }, http.StatusTemporaryRedirect, true},
+		{"confirmed", "/elsewhere", false, func(_, _ string) bool { return true }, http.StatusPaymentRequired, false},
+		{"trusted", "/elsewhere", true, nil, http.StatusPaymentRequired, false},
+	}
+	for _, tt := range tests {
+		var hops []redirectHop
+		policy := newRedirectPolicy(tt.trust, tt.confirm, &hops)
+		client := &http.Client{CheckRedirect: policy.checkRedirect("probe")}
+		resp, err := client.Get(origin.URL + tt.path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.wantStatus)
+		}
+		if blocked := policy.blocked() != nil; blocked != tt.wantBlocked {
+			t.Errorf("%s: blocked = %v, want %v (hops %+v)", tt.name, blocked, tt.wantBlocked, hops)
+		}
+		if len(hops) != 1 || hops[0].CrossOrigin != (tt.path == "/elsewhere") {
+			t.Errorf("%s: recorded hops %+v", tt.name, hops)
+		}
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	tests := []struct {
+		name      string
+		onlyHosts string
+		explicit  []string
+		urls      int
+		wantErr   string
+	}{
+		{"allowlisted", "api.example.com", nil, 1, ""},
+		{"no allowlist", "", nil, 1, "requires --only-hosts"},
+		{"mainnet", "api.example.com", []string{"mainnet"}, 1, "--mainnet"},
+		{"trusted redirects", "api.example.com", []string{"trust-redirects"}, 1, "--trust-redirects"},
+		{"several URLs", "api.example.com", nil, 2, "single URL"},
+	}
+	for _, tt := range tests {
+		err := checkSandbox(tt.onlyHosts, tt.explicit, tt.urls)
+		if (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: checkSandbox = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, "/paid", http.StatusFound)
+			return
+		}
+		w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	defer srv.Close()
+
+	// Under --sandbox even a same-origin redirect is refused.
+	var hops []redirectHop
+	policy := newRedirectPolicy(false, nil, &hops)
+	policy.none = true
+	client := &http.Client{Transport: capResponses(http.DefaultTransport, 10), CheckRedirect: policy.checkRedirect("probe")}
+	resp, err := client.Get(srv.URL + "/moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || policy.blocked() == nil {
+		t.Errorf("same-origin redirect under --sandbox: status %d, hops %+v", resp.StatusCode, hops)
+	}
+
+	// Bodies are cut at the cap.
+	resp, err = client.Get(srv.URL + "/paid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if len(body) != 10 {
+		t.Errorf("capped body is %d bytes, want 10", len(body))
+	}
+}
+
+func TestHostAllowlist(t *testing.T) {
+	list := parseHostAllowlist(" API.example.com, *.trusted.io ,")
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"api.example.com", true},
+		{"API.Example.com.", true},
+		{"evil.example.com", false},
+		{"a.trusted.io", true},
+		{"a.b.trusted.io", true},
+		{"trusted.io", false},
+		{"nottrusted.io", false},
+	}
+	for _, tt := range tests {
+		if got := list.allows(tt.host); got != tt.want {
+			t.Errorf("allows(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+	if !parseHostAllowlist("").allows("anything.example") {
+		t.Error("an empty allowlist should allow every host")
+	}
+
+	var reached []string
+	rt := list.transport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		reached = append(reached, req.URL.Host)
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+	for _, target := range []string{"https://evil.example.com/x", "https://a.trusted.io:8443/x"} {
+		req, _ := http.NewRequest("GET", target, nil)
+		req.Header.Set("PAYMENT-SIGNATURE", "signed")
+		rt.RoundTrip(req)
+	}
+	probe, _ := http.NewRequest("GET", "https://evil.example.com/x", nil)
+	rt.RoundTrip(probe)
+	if want := []string{"a.trusted.io:8443", "evil.example.com"}; !slices.Equal(reached, want) {
+		t.Errorf("requests sent to %v, want %v (payments to other hosts must be refused, unpaid requests pass)", reached, want)
+	}
+}
+
+func TestParseHostBudget(t *testing.T) {
+	tests := []struct {
+		spec       string
+		wantHost   string
+		wantLimit  string
+		wantPeriod time.Duration
+		wantErr    bool
+	}{
+		{"api.foo.com=1USDC/day", "api.foo.com", "1", 24 * time.Hour, false},
+		{"api.foo.com: 1 USDC/day", "api.foo.com", "1", 24 * time.Hour, false},
+		{"*.Foo.com=0.25/12h", "*.foo.com", "1/4", 12 * time.Hour, false},
+		{"api.foo.com=1/fortnight", "", "", 0, true},
+		{"api.foo.com=lots/day", "", "", 0, true},
+		{"api.foo.com=1", "", "", 0, true},
+		{"=1/day", "", "", 0, true},
+	}
+	for _, tt := range tests {
+		b, err := parseHostBudget(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseHostBudget(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if b.Host != tt.wantHost || b.Limit.RatString() != tt.wantLimit || b.Period != tt.wantPeriod {
+			t.Errorf("parseHostBudget(%q) = %s %s %s", tt.spec, b.Host, b.Limit.RatString(), b.Period)
+		}
+	}
+}
+
+func TestCheckHostBudgets(t *testing.T) {
+	const usdc = "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+	now := time.Now()
+	paid := func(endpoint, amount string, ago time.Duration, status string) historyRecord {
+		return historyRecord{Time: now.Add(-ago), Endpoint: endpoint, Status: status, Network: "eip155:84532", Asset: usdc, Amount: amount}
+	}
+	records := []historyRecord{
+		paid("https://api.foo.com/a", "600000", time.Hour, "accepted"),
+		paid("https://api.foo.com/b", "300000", 2*time.Hour, "accepted"),
+		paid("https://api.foo.com/a", "900000", 3*time.Hour, "rejected"),
+		paid("https://api.foo.com/a", "900000", 48*time.Hour, "accepted"),
+		paid("https://other.com/a", "900000", time.Hour, "accepted"),
+		// Budgets are per asset and network: Base mainnet USDC does not count against Base Sepolia's.
+		{Time: now.Add(-time.Hour), Endpoint: "https://api.foo.com/a", Status: "accepted", Network: "eip155:8453", Asset: networks["base"].USDCContract, Amount: "900000"},
+	}
+	budget, _ := parseHostBudget("api.foo.com=1USDC/day")
+	price := func(amount string) x402.PaymentRequirements {
+		return x402.PaymentRequirements{Network: "eip155:84532", Asset: usdc, Amount: amount}
+	}
+
+	if err := checkHostBudgets([]hostBudget{budget}, records, "https://api.foo.com/c", price("100000"), now); err != nil {
+		t.Errorf("0.9 spent + 0.1 should fit a 1 USDC/day budget: %v", err)
+	}
+	err := checkHostBudgets([]hostBudget{budget}, records, "https://api.foo.com/c", price("100001"), now)
+	if err == nil || !strings.Contains(err.Error(), "0.9 already paid in the last day") {
+		t.Errorf("over budget: err = %v", err)
+	}
+	if err := checkHostBudgets([]hostBudget{budget}, records, "https://other.com/c", price("5000000"), now); err != nil {
+		t.Errorf("other hosts are not capped: %v", err)
+	}
+	// A pending payment may still settle, so it counts against the budget.
+	records = append(records, paid("https://api.foo.com/d", "100000", time.Minute, "pending"))
+	if err := checkHostBudgets([]hostBudget{budget}, records, "https://api.foo.com/c", price("1"), now); err == nil {
+		t.Error("a pending payment was not counted")
+	}
+}
+
+func TestDelegationCheck(t *testing.T) {
+	const usdc = "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+	now := time.Now()
+	price := func(amount string) x402.PaymentRequirements {
+		return x402.PaymentRequirements{Network: "eip155:84532", Asset: usdc, Amount: amount}
+	}
+	records := []historyRecord{
+		{Payer: "0xABC", Status: "accepted", Network: "eip155:84532", Asset: usdc, Amount: "3000000"},
+		{Payer: "0xabc", Status: "pending", Network: "eip155:84532", Asset: usdc, Amount: "1000000"},
+		{Payer: "0xabc", Status: "rejected", Network: "eip155:84532", Asset: usdc, Amount: "9000000"},
+		{Payer: "0xother", Status: "accepted", Network: "eip155:84532", Asset: usdc, Amount: "9000000"},
+	}
+	base := delegation{Name: "agent", Address: "0xabc", Max: "5", Hosts: []string{"api.example.com"}, Expires: now.Add(time.Hour)}
+
+	tests := []struct {
+		name     string
+		mutate   func(d *delegation)
+		endpoint string
+		amount   string
+		status   string
+	}{
+		{"within bounds", nil, "https://api.example.com/x", "1000000", ""},
+		{"over max", nil, "https://api.example.com/x", "1000001", "budget_exceeded"},
+		{"other host", nil, "https://evil.example/x", "1", "host_not_allowed"},
+		{"any host", func(d *delegation) { d.Hosts = nil }, "https://evil.example/x", "1", ""},
+		{"expired", func(d *delegation) { d.Expires = now }, "https://api.example.com/x", "1", "error"},
+		{"revoked", func(d *delegation) { d.Revoked = true }, "https://api.example.com/x", "1", "error"},
+		{"its network", func(d *delegation) { d.Network = "base-sepolia" }, "https://api.example.com/x", "1", ""},
+		{"other network", func(d *delegation) { d.Network = "base" }, "https://api.example.com/x", "1", "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := base
+			if tt.mutate != nil {
+				tt.mutate(&d)
+			}
+			status, err := d.check(records, tt.endpoint, price(tt.amount), now)
+			if status != tt.status || (err == nil) != (tt.status == "") {
+				t.Errorf("check() = %q, %v; want status %q", status, err, tt.status)
+			}
+		})
+	}
+
+	parent, _ := crypto.GenerateKey()
+	created := now.Truncate(time.Second)
+	a, _ := deriveDelegateKey(parent, "agent", created)
+	b, _ := deriveDelegateKey(parent, "agent", created)
+	c, _ := deriveDelegateKey(parent, "other", created)
+	if !a.Equal(b) || a.Equal(c) || a.Equal(parent) {
+		t.Error("deriveDelegateKey should be deterministic per parent, name, and creation time")
+	}
+}
+
+func TestReceipt(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("EVM_PRIVATE_KEY", "0xac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80")
+	const signer = "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"
+
+	rec := historyRecord{ID: "abc123", Time: time.Now(), Endpoint: "https://api.example.com/x", Method: "GET",
+		Network: "eip155:84532", Asset: "0xUSDC", Amount: "1000", PayTo: "0xSELLER", Transaction: "0xTX"}
+	r, path, err := issueReceipt(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Signer != signer || filepath.Base(path) != "abc123.json" {
+		t.Fatalf("issueReceipt() = %+v at %s", r, path)
+	}
+	if c := checkReceipt(path, ""); !c.Valid || c.Recovered != signer {
+		t.Errorf("checkReceipt(issued) = %+v", c)
+	}
+	if c := checkReceipt(path, "0x0000000000000000000000000000000000000001"); c.Valid {
+		t.Error("checkReceipt should refuse a receipt from another --signer")
+	}
+
+	tampered := *r
+	tampered.Amount = "1"
+	out, _ := json.Marshal(tampered)
+	file := filepath.Join(t.TempDir(), "tampered.json")
+	os.WriteFile(file, out, 0600)
+	if c := checkReceipt(file, ""); c.Valid || !strings.Contains(c.Error, "not the receipt's signer") {
+		t.Errorf("checkReceipt(tampered) = %+v", c)
+	}
+}
+
+func TestForecast(t *testing.T) {
+	const usdc = "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+	records := []historyRecord{
+		{Endpoint: "https://a/x", Method: "GET", Status: "accepted", LatencyMs: 100},
+		{Endpoint: "https://a/x", Method: "GET", Status: "accepted", LatencyMs: 300},
+		{Endpoint: "https://a/x", Method: "GET", Status: "accepted"},
+		{Endpoint: "https://a/x", Method: "GET", Status: "rejected"},
+		{Endpoint: "https://a/x", Method: "GET", Status: "pending"},
+		{Endpoint: "https://a/x", Method: "POST", Status: "rejected"},
+		{Endpoint: "https://a/y", Method: "GET", Status: "rejected"},
+	}
+	h := endpointHistory(records, "https://a/x", "GET")
+	if h != (forecastHistory{Payments: 4, Accepted: 3, AvgLatencyMs: 200}) {
+		t.Fatalf("endpointHistory() = %+v", h)
+	}
+
+	f := &forecastResult{Calls: 1000, History: h}
+	forecast(f, x402.PaymentRequirements{Network: "eip155:84532", Asset: usdc, Amount: "1000"})
+	if f.SuccessRate != 0.75 || f.ExpectedAccepted != 750 || f.ExpectedCost != "750000" || f.MaxCost != "1000000" || f.DurationMs != 200000 {
+		t.Errorf("forecast() = %+v", f)
+	}
+
+	f = &forecastResult{Calls: 10}
+	forecast(f, x402.PaymentRequirements{Network: "eip155:84532", Asset: usdc, Amount: "1000"})
+	if f.SuccessRate != 1 || f.ExpectedCost != "10000" || f.DurationMs != 0 {
+		t.Errorf("forecast() without history = %+v", f)
+	}
+}
+
+func TestPaymentTiming(t *testing.T) {
+	metrics := parseServerTiming([]string{`app;dur=40, verify;dur=120.5`, `cdn-cache, x;dur=1;desc="settle on base"`})
+	if len(metrics) != 4 || metrics[1].Dur != 120.5 || metrics[2].Name != "cdn-cache" || metrics[3].Desc != "settle on base" {
+		t.Fatalf("parseServerTiming() = %+v", metrics)
+	}
+
+	tests := []struct {
+		name          string
+		header        http.Header
+		facilitatorMs int64
+		serverMs      int64
+		source        string
+	}{
+		{"estimate", http.Header{}, 4500, 500, "estimate"},
+		{"server timing", http.Header{"Server-Timing": {"app;dur=200, verify;dur=300, settle;dur=3000"}}, 3300, 1700, "server-timing"},
+		{"reported above the wait", http.Header{"Server-Timing": {"facilitator;dur=9000"}}, 5000, 0, "server-timing"},
+	}
+	for _, tt := range tests {
+		timing := newPaymentTiming(6*time.Second, 5*time.Second, 500*time.Millisecond, tt.header, 5*time.Second)
+		if timing.FacilitatorMs != tt.facilitatorMs || timing.ServerMs != tt.serverMs || timing.Source != tt.source ||
+			timing.ClientMs != 1000 || !timing.Slow {
+			t.Errorf("%s: newPaymentTiming() = %+v", tt.name, timing)
+		}
+	}
+	if timing := newPaymentTiming(time.Second, 0, 0, http.Header{}, 5*time.Second); timing.Slow {
+		t.Error("a 1s payment is not slow at --slow-payment 5s")
+	}
+}
+
+func TestCheckSLO(t *testing.T) {
+	tests := []struct {
+		name                        string
+		latency, latencyLimit       time.Duration
+		settlement, settlementLimit time.Duration
+		breached                    string
+	}{
+		{"within both", time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second, ""},
+		{"slow request", 3 * time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second, "latency"},
+		{"slow settlement", time.Second, 2 * time.Second, 12 * time.Second, 10 * time.Second, "settlement"},
+		{"both", 3 * time.Second, 2 * time.Second, 12 * time.Second, 10 * time.Second, "latency,settlement"},
+		{"no latency limit", time.Minute, 0, 5 * time.Second, 10 * time.Second, ""},
+		{"at the limit", 2 * time.Second, 2 * time.Second, 0, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := checkSLO(tt.latency, tt.latencyLimit, tt.settlement, tt.settlementLimit)
+			if got := strings.Join(s.Breached, ","); got != tt.breached {
+				t.Errorf("breached = %q, want %q", got, tt.breached)
+			}
+			if (s.message() == "") != (tt.breached == "") {
+				t.Errorf("message = %q", s.message())
+			}
+		})
+	}
+}
+
+func TestPendingPaymentRecord(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	nonce := "0x" + strings.Repeat("ab", 32)
+	payload := base64.StdEncoding.EncodeToString([]byte(`{"x402Version":2,"payload":{"authorization":{"from":"0xPAYER","nonce":"` + nonce +
+		`","validBefore":"1900000000"}},"accepted":{"scheme":"exact","network":"eip155:84532","asset":"0xUSDC","amount":"1000","payTo":"0xSELLER"}}`))
+	rec := newHistoryRecord("https://a/x", "GET", "", payload, nil)
+	if rec.ID == "" || rec.Nonce != nonce || rec.ValidBefore != 1900000000 || rec.StatusCode != 0 || rec.Payer != "0xPAYER" {
+		t.Fatalf("newHistoryRecord without a response = %+v", rec)
+	}
+
+	rec.Status = "pending"
+	other := rec
+	other.ID = "other"
+	if err := saveHistory([]historyRecord{rec, other}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := loadHistory()
+	if err != nil || len(records) != 2 || records[0].ID != rec.ID || records[0].Status != "pending" {
+		t.Fatalf("loadHistory after saveHistory = (%+v, %v)", records, err)
+	}
+
+	if _, err := settlementStatus(historyRecord{ID: "x", Network: "eip155:84532", Payer: "0xPAYER", Asset: "0xUSDC"}, time.Now()); err == nil {
+		t.Error("settlementStatus should refuse a record without a valid authorization")
+	}
+}
+
+func TestStreamLines(t *testing.T) {
+	pr, pw := io.Pipe()
+	out := make(chan string, 4)
+	var file bytes.Buffer
+	done := make(chan []byte)
+	go func() {
+		body, _ := streamLines(pr, writerFunc(func(p []byte) { out <- string(p) }), &file)
+		done <- body
+	}()
+
+	pw.Write([]byte(`{"n":1}` + "\n" + `{"n":`))
+	select {
+	case line := <-out:
+		if line != `{"n":1}`+"\n" {
+			t.Errorf("first line = %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("first line was not written before the stream ended")
+	}
+	pw.Write([]byte(`2}`))
+	pw.Close()
+
+	body := <-done
+	if want := `{"n":1}` + "\n" + `{"n":2}`; string(body) != want || file.String() != want {
+		t.Errorf("streamLines returned %q and wrote %q, want %q", body, file.String(), want)
+	}
+}
+
+// writerFunc adapts a function to io.Writer.
+type writerFunc func([]byte)
+
+func (f writerFunc) Write(p []byte) (int, error) { f(p); return len(p), nil }
+
+func TestIsLineStream(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"application/x-ndjson":             true,
+		"application/jsonl; charset=utf-8": true,
+		"application/json":                 false,
+		"text/plain":                       false,
+		"":                                 false,
+	} {
+		resp := &http.Response{Header: http.Header{"Content-Type": {contentType}}}
+		if got := isLineStream(resp); got != want {
+			t.Errorf("isLineStream(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+}
+
+func TestResponseHead(t *testing.T) {
+	resp := &http.Response{Proto: "HTTP/1.1", Status: "200 OK", Header: http.Header{}}
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Set("Payment-Response", "eyJ9")
+	want := "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nPayment-Response: eyJ9\r\n\r\n"
+	if got := string(responseHead(resp)); got != want {
+		t.Errorf("responseHead = %q, want %q", got, want)
+	}
+}
+
+func TestHashTypedData(t *testing.T) {
+	data := `{
+		"types": {
+			"EIP712Domain": [
+				{"name": "name", "type": "string"},
+				{"name": "version", "type": "string"},
+				{"name": "chainId", "type": "uint256"},
+				{"name": "verifyingContract", "type": "address"}
+			],
+			"Mail": [{"name": "from", "type": "address"}, {"name": "contents", "type": "string"}]
+		},
+		"primaryType": "Mail",
+		"domain": {"name": "Test", "version": "1", "chainId": 84532, "verifyingContract": "0x036CbD53842c5426634e7929541eC2318f3dCF7e"},
+		"message": {"from": "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266", "contents": "hello"}
+	}`
+	out, digest, err := hashTypedData([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.PrimaryType != "Mail" || out.ChainID != "eip155:84532" || out.Digest != "0x"+common.Bytes2Hex(digest) {
+		t.Errorf("hashTypedData = %+v", out)
+	}
+	for _, c := range []struct {
+		chainID string
+		mainnet bool
+		ok      bool
+	}{
+		{"eip155:84532", false, true},
+		{"eip155:8453", false, false},
+		{"eip155:999999", false, false},
+		{"", false, false},
+		{"eip155:8453", true, true},
+		{"", true, true},
+	} {
+		if err := checkTypedDataChain(c.chainID, c.mainnet); (err == nil) != c.ok {
+			t.Errorf("checkTypedDataChain(%q, %v) = %v", c.chainID, c.mainnet, err)
+		}
+	}
+
+	// The digest must be what the SDK's signer signs for the same payload.
+	key, _ := crypto.HexToECDSA(vectorFixtureKey[2:])
+	sig, _ := crypto.Sign(digest, key)
+	sig[64] += 27
+	signer, _ := evmsigners.NewClientSignerFromPrivateKey(vectorFixtureKey)
+	want, err := signer.SignTypedData(context.Background(),
+		x402evm.TypedDataDomain{Name: "Test", Version: "1", ChainID: big.NewInt(84532), VerifyingContract: "0x036CbD53842c5426634e7929541eC2318f3dCF7e"},
+		map[string][]x402evm.TypedDataField{"Mail": {{Name: "from", Type: "address"}, {Name: "contents", Type: "string"}}},
+		"Mail",
+		map[string]interface{}{"from": "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266", "contents": "hello"},
+	)
+	if err != nil || !bytes.Equal(sig, want) {
+		t.Errorf("signature %x, SDK signer %x (%v)", sig, want, err)
+	}
+
+	for _, bad := range []string{`not json`, `{"types": {"EIP712Domain": []}, "domain": {}, "message": {}}`, `{"primaryType": "Mail", "types": {"Mail": []}}`} {
+		if _, _, err := hashTypedData([]byte(bad)); err == nil {
+			t.Errorf("hashTypedData(%q) should fail", bad)
+		}
+	}
+
+	// Token-moving messages are recognized by type name, or by their fields when a contract verifies them.
+	contract := "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+	for _, c := range []struct {
+		name    string
+		td      apitypes.TypedData
+		flagged bool
+	}{
+		{"mail", apitypes.TypedData{PrimaryType: "Mail", Types: apitypes.Types{"Mail": {{Name: "from", Type: "address"}, {Name: "contents", Type: "string"}}}, Domain: apitypes.TypedDataDomain{VerifyingContract: contract}}, false},
+		{"permit by name", apitypes.TypedData{PrimaryType: "Permit", Types: apitypes.Types{"Permit": {}}}, true},
+		{"renamed approval", apitypes.TypedData{PrimaryType: "Order", Types: apitypes.Types{"Order": {{Name: "spender", Type: "address"}, {Name: "deadline", Type: "uint256"}}}, Domain: apitypes.TypedDataDomain{VerifyingContract: contract}}, true},
+		{"nested amount", apitypes.TypedData{PrimaryType: "Batch", Types: apitypes.Types{
+			"Batch": {{Name: "items", Type: "Item[]"}},
+			"Item":  {{Name: "token", Type: "address"}, {Name: "amount", Type: "uint256"}},
+		}, Domain: apitypes.TypedDataDomain{VerifyingContract: contract}}, true},
+		{"no contract", apitypes.TypedData{PrimaryType: "Order", Types: apitypes.Types{"Order": {{Name: "amount", Type: "uint256"}}}}, false},
+	} {
+		if got := movesTokens(&c.td); got != c.flagged {
+			t.Errorf("%s: movesTokens = %v, want %v", c.name, got, c.flagged)
+		}
+	}
+}
+
+func TestFallbackPaths(t *testing.T) {
+	paths, err := fallbackPaths("socks5://10.0.0.1:1080", "1.1.1.1")
+	if err != nil || len(paths) != 2 || paths[0].Kind != "proxy" || paths[1].Via != "1.1.1.1:53" {
+		t.Fatalf("fallbackPaths = %v, %v", paths, err)
+	}
+	if paths, err := fallbackPaths("", "[2606:4700::1111]:5353"); err != nil || paths[0].Via != "[2606:4700::1111]:5353" {
+		t.Errorf("fallbackPaths with IPv6 DNS = %v, %v", paths, err)
+	}
+	for _, bad := range [][2]string{{"ftp://proxy", ""}, {"proxy:8080", ""}, {"", "dns.example.com"}} {
+		if _, err := fallbackPaths(bad[0], bad[1]); err == nil {
+			t.Errorf("fallbackPaths(%q, %q) should fail", bad[0], bad[1])
+		}
+	}
+
+	// The proxy path sends the request through the proxy, which reaches the endpoint.
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Via", "proxy")
+		w.WriteHeader(http.StatusPaymentRequired)
+	}))
+	defer proxy.Close()
+	_, err = (&http.Client{Transport: &http.Transport{}}).Get("http://x402-fallback.invalid/")
+	if err == nil || !isNetworkError(err) {
+		t.Fatalf("direct request error = %v, want a network error", err)
+	}
+	p := egressPath{Kind: "proxy", Via: proxy.URL}
+	resp, err := (&http.Client{Transport: p.transport(&http.Transport{})}).Get("http://x402-fallback.invalid/")
+	if err != nil || resp.Header.Get("X-Via") != "proxy" {
+		t.Fatalf("request via proxy = %v, %v", resp, err)
+	}
+	resp.Body.Close()
+
+	var r egressReport
+	r.record(egressPath{Kind: "direct"}, &net.DNSError{Err: "no such host", Name: "x402-fallback.invalid", IsNotFound: true})
+	r.record(p, nil)
+	if r.Path != "proxy" || r.Via != proxy.URL || len(r.Attempts) != 2 || r.Attempts[0].ErrorType != "dns" || !r.Attempts[1].OK {
+		t.Errorf("egressReport = %+v", r)
+	}
+	if isNetworkError(errors.New("x509: certificate signed by unknown authority")) {
+		t.Error("a TLS error is not a network-level failure")
+	}
+}
+
+func TestPayHeaders(t *testing.T) {
+	headers := headerFlags{"Authorization: Bearer x", "X-Debug: 1", "content-type: application/json", "X-Request-ID: abc"}
+	tests := []struct {
+		spec    string
+		dropped []string
+	}{
+		{"", nil},
+		{"all", nil},
+		{"none", []string{"Authorization", "X-Debug", "content-type"}},
+		{"only=Authorization, Content-Type", []string{"X-Debug"}},
+		{"except=x-debug", []string{"X-Debug"}},
+	}
+	for _, tt := range tests {
+		p, err := parsePayHeaders(tt.spec)
+		if err != nil {
+			t.Fatalf("parsePayHeaders(%q): %v", tt.spec, err)
+		}
+		kept, dropped := p.filter(headers)
+		if !slices.Equal(dropped, tt.dropped) || len(kept)+len(dropped) != len(headers) {
+			t.Errorf("%q: kept %v, dropped %v, want dropped %v", tt.spec, kept, dropped, tt.dropped)
+		}
+		if !hasHeader(kept, traceHeader) {
+			t.Errorf("%q dropped the request ID header", tt.spec)
+		}
+	}
+	for _, bad := range []string{"some", "only=", "only", "all=X"} {
+		if _, err := parsePayHeaders(bad); err == nil {
+			t.Errorf("parsePayHeaders(%q) should fail", bad)
+		}
+	}
+}
+
+func TestArchiveResponse(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "corpus")
+	at := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	resp := &http.Response{StatusCode: 200, Header: http.Header{"Content-Type": {"application/json; charset=utf-8"}}}
+	entry := archiveEntry{Time: at, Endpoint: "https://api.example.com/data?q=1", Method: "GET", PaymentID: "abc"}
+	first, err := archiveResponse(dir, entry, resp, []byte(`{"a":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := archiveResponse(dir, entry, resp, []byte(`{"a":2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, hash := archiveName(entry.Endpoint, "application/json", at)
+	if filepath.Base(first) != name || !strings.HasPrefix(name, hash+"-20260501T120000.000Z") {
+		t.Errorf("first archived as %s, want %s", first, name)
+	}
+	if filepath.Base(second) != strings.TrimSuffix(name, ".json")+"-2.json" {
+		t.Errorf("second archived as %s, want a counter suffix", second)
+	}
+	if got, _ := os.ReadFile(second); string(got) != `{"a":2}` {
+		t.Errorf("second body = %s", got)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, archiveIndex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	var e archiveEntry
+	if len(lines) != 2 || json.Unmarshal([]byte(lines[1]), &e) != nil {
+		t.Fatalf("index = %s", raw)
+	}
+	if e.File != filepath.Base(second) || e.URLHash != hash || e.Bytes != 7 || e.PaymentID != "abc" || len(e.SHA256) != 64 {
+		t.Errorf("index entry = %+v", e)
+	}
+
+	for ct, want := range map[string]string{"application/x-ndjson": ".jsonl", "text/plain; charset=utf-8": ".txt", "application/vnd.api+json": ".json", "": ".bin"} {
+		if got := archiveExt(ct); got != want {
+			t.Errorf("archiveExt(%q) = %s, want %s", ct, got, want)
+		}
+	}
+}
+
+func TestSpendCeiling(t *testing.T) {
+	price := x402.PaymentRequirements{Network: "eip155:84532", Asset: "0x036CbD53842c5426634e7929541eC2318f3dCF7e", Amount: "20000"} // 0.02 USDC
+	c, err := newSpendCeiling("0.05USDC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := c.reserve(price)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.reserve(price); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.reserve(price); err == nil || !strings.Contains(err.Error(), "0.04 already paid") {
+		t.Fatalf("third reserve = %v, want the ceiling exceeded", err)
+	}
+	first()
+	first() // releasing twice must not free more
+	if _, err := c.reserve(price); err != nil {
+		t.Errorf("reserve after a release: %v", err)
+	}
+	if _, err := c.reserve(price); err == nil {
+		t.Error("a double release freed the ceiling twice")
+	}
+	if release, err := (*spendCeiling)(nil).reserve(price); err != nil || release == nil {
+		t.Errorf("nil ceiling = %v", err)
+	}
+	if _, err := newSpendCeiling("lots"); err == nil {
+		t.Error("newSpendCeiling accepted a non-number")
+	}
+
+	// Across several URLs, payments stop at the ceiling.
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	defer func(saved bool) { noSpend = saved }(noSpend)
+	noSpend = true
+	required := base64.StdEncoding.EncodeToString([]byte(`{"x402Version":2,"resource":{"url":"http://x/paid"},"accepts":[{"scheme":"exact",` +
+		`"network":"eip155:84532","asset":"0x036CbD53842c5426634e7929541eC2318f3dCF7e","amount":"20000","payTo":"0x1111111111111111111111111111111111111111",` +
+		`"maxTimeoutSeconds":60,"extra":{"name":"USDC","version":"2"}}]}`))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("PAYMENT-REQUIRED", required)
+		w.WriteHeader(http.StatusPaymentRequired)
+	}))
+	defer srv.Close()
+	signer, _ := evmsigners.NewClientSignerFromPrivateKey(vectorFixtureKey)
+	c, _ = newSpendCeiling("0.05")
+	results := payURLs([]string{srv.URL + "/a", srv.URL + "/b", srv.URL + "/c"}, "GET", "", nil, noSpendTransport(http.DefaultTransport), signer,
+		batchOptions{timeout: 5 * time.Second, ceiling: c})
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Status]++
+	}
+	if counts["accepted"] != 2 || counts["budget_exceeded"] != 1 {
+		t.Errorf("statuses = %v, want 2 accepted and 1 budget_exceeded", counts)
+	}
+}
+
+func TestStdinBody(t *testing.T) {
+	payload := strings.Repeat(`{"row":1}`+"\n", 1000)
+	spool, err := newStdinBody(strings.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved *stdinBody) { stdinSpool = saved }(stdinSpool)
+	stdinSpool = spool
+	defer spool.close()
+
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.ContentLength != int64(len(payload)) {
+			t.Errorf("Content-Length = %d, want %d", r.ContentLength, len(payload))
+		}
+		got = append(got, string(body))
+	}))
+	defer srv.Close()
+	for range 2 {
+		req, err := newRequest("POST", srv.URL, stdinData, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if len(got) != 2 || got[0] != payload || got[1] != payload {
+		t.Errorf("server got %d bodies, want the payload twice", len(got))
+	}
+	if bodyHash(stdinData) != bodyHash(payload) {
+		t.Error("the hash of a stdin body must match the same inline body")
+	}
+
+	spool.close()
+	if _, err := os.Stat(spool.path); !os.IsNotExist(err) {
+		t.Errorf("spool file left behind: %v", err)
+	}
+}
+
+func TestInflightPayment(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	price := x402.PaymentRequirements{Scheme: "exact", Network: "eip155:84532", Asset: "0xUSDC", Amount: "1000", PayTo: "0xSELLER"}
+	p, err := newInflight("https://a/x", "POST", `{"q":1}`, headerFlags{"Authorization: Bearer x"}, price, "trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The payment header is saved before the request goes out.
+	var seen string
+	rt := p.transport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		got, err := loadInflight(p.ID[:6])
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen = got.State + " " + got.Payment
+		return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+	}))
+	req, _ := http.NewRequest("POST", "https://a/x", nil)
+	req.Header.Set("PAYMENT-SIGNATURE", "sig")
+	rt.RoundTrip(req)
+	if seen != "signed sig" {
+		t.Errorf("state while sending = %q, want %q", seen, "signed sig")
+	}
+
+	got, err := loadInflight(p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != "sent" || got.Data != `{"q":1}` || got.Headers[0] != "Authorization: Bearer x" || got.Requirements.Amount != "1000" || got.PaymentHeader != "PAYMENT-SIGNATURE" {
+		t.Errorf("loaded %+v", got)
+	}
+
+	// An unknown outcome keeps the state; a final status removes it.
+	p.finish("pending")
+	p.finish("")
+	if list, _ := listInflight(); len(list) != 1 {
+		t.Fatalf("after unknown outcomes: %d in-flight payments, want 1", len(list))
+	}
+	p.finish("accepted")
+	if list, _ := listInflight(); len(list) != 0 {
+		t.Errorf("after accepted: %d in-flight payments, want 0", len(list))
+	}
+	if _, err := loadInflight(p.ID); err == nil {
+		t.Error("loadInflight should fail for a finished payment")
+	}
+
+	// A payment that was never signed is not worth resuming.
+	q, _ := newInflight("https://a/y", "GET", "", nil, price, "")
+	q.finish("")
+	if list, _ := listInflight(); len(list) != 0 {
+		t.Errorf("unsigned payment kept: %d in-flight payments", len(list))
+	}
+}
+
+func TestRecordPrices(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	const usdc = "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+	offer := func(amount string) []x402.PaymentRequirements {
+		return []x402.PaymentRequirements{
+			{Scheme: "exact", Network: "eip155:84532", Asset: usdc, Amount: amount},
+			{Scheme: "exact", Network: "eip155:43113", Asset: "0x5425890298aed601595a70AB815c96711a31Bc65", Amount: "5000"},
+		}
+	}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, amount := range []string{"1000", "1000", "1500", "1500", "1200"} {
+		if err := recordPrices("https://a/x", offer(amount), start.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recordPrices("https://b/y", offer("9"), start)
+
+	path, _ := stateFile(pricesFile)
+	observed, err := readPrices(path)
+	if err != nil || len(observed) != 6 {
+		t.Fatalf("readPrices = (%d observations, %v), want 6: only changes are recorded", len(observed), err)
+	}
+
+	series := priceHistory(observed, "https://a/x")
+	if len(series) != 2 {
+		t.Fatalf("priceHistory = %d options, want 2", len(series))
+	}
+	changes := series[0].Changes
+	if len(changes) != 3 || changes[1].Amount != "1500" || !changes[2].Time.Equal(start.Add(4*time.Hour)) {
+		t.Fatalf("changes = %+v", changes)
+	}
+	if changes[0].ChangePct != nil || *changes[1].ChangePct != 50 || *changes[2].ChangePct != -20 {
+		t.Errorf("change percentages = %v, %v, %v; want none, +50, -20", changes[0].ChangePct, *changes[1].ChangePct, *changes[2].ChangePct)
+	}
+	if series[0].Current != changes[2].Price || len(series[1].Changes) != 1 {
+		t.Errorf("series = %+v", series)
+	}
+
+	if got := sparkline([]string{"1000", "1500", "1200"}); got != "▁█▃" {
+		t.Errorf("sparkline = %q, want %q", got, "▁█▃")
+	}
+	if got := sparkline([]string{"7", "7"}); got != "▁▁" {
+		t.Errorf("flat sparkline = %q", got)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	const addr = "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+	const hash = "0xabababababababababababababababababababababababababababababababab"
+	tests := []struct {
+		level redactLevel
+		in    string
+		want  string
+	}{
+		{redactOff, "paid " + addr + " at https://api.example.com/v1/x?key=s", "paid " + addr + " at https://api.example.com/v1/x?key=s"},
+		{redactStandard, "paid " + addr + " at https://api.example.com/v1/x?key=s#f", "paid 0x036C…CF7e at https://api.example.com/v1/x"},
+		{redactStandard, "tx " + hash, "tx " + hash},
+		{redactStrict, "tx " + hash + " to " + addr, "tx 0xabab…abab to 0x036C…CF7e"},
+		{redactStrict, "https://user:pw@api.example.com/v1/x?key=s", "https://api.example.com"},
+	}
+	defer func() { redaction = redactOff }()
+	for _, tt := range tests {
+		redaction = tt.level
+		if got := redactText(tt.in); got != tt.want {
+			t.Errorf("redactText(%q) at level %d = %q, want %q", tt.in, tt.level, got, tt.want)
+		}
+	}
+
+	redaction = redactStandard
+	out, _ := json.Marshal(redactJSON(struct {
+		Amount string `json:"amount"`
+		PayTo  string `json:"payTo"`
+		Body   string `json:"body"`
+	}{"1000", addr, `{"secret":true}`}))
+	if want := `{"amount":"1000","body":"[redacted: 15 bytes]","payTo":"0x036C…CF7e"}`; string(out) != want {
+		t.Errorf("redactJSON = %s, want %s", out, want)
+	}
+
+	h := redactHeaderValues(http.Header{"Payment-Signature": {"eyJ"}, "Payment-Required": {"eyJhYmMi"}, "X-Wallet": {addr}})
+	if h.Get("Payment-Signature") != "[redacted]" || h.Get("Payment-Required") != "[base64: 8 bytes]" || h.Get("X-Wallet") != "0x036C…CF7e" {
+		t.Errorf("redactHeaderValues = %v", h)
+	}
+
+	for _, s := range []string{"", "off", "Standard", "strict"} {
+		if _, err := parseRedact(s); err != nil {
+			t.Errorf("parseRedact(%q): %v", s, err)
+		}
+	}
+	if _, err := parseRedact("loose"); err == nil {
+		t.Error("parseRedact(\"loose\") succeeded")
+	}
+}
+
+func TestRetrySafety(t *testing.T) {
+	dial := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	read := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	keyed := http.Header{"Idempotency-Key": {"abc"}}
+	tests := []struct {
+		name        string
+		method      string
+		header      http.Header
+		paymentSent bool
+		status      int
+		err         error
+		want        bool
+	}{
+		{"get timeout", "GET", nil, false, 0, context.DeadlineExceeded, true},
+		{"head reset", "head", nil, false, 0, read, true},
+		{"post timeout", "POST", nil, false, 0, context.DeadlineExceeded, false},
+		{"post reset", "POST", nil, false, 0, read, false},
+		{"post with idempotency key", "POST", keyed, false, 0, read, true},
+		{"post not connected", "POST", nil, false, 0, dial, true},
+		{"post dns", "POST", nil, false, 0, &net.DNSError{Err: "no such host", Name: "x"}, true},
+		{"post refused 429", "POST", nil, false, http.StatusTooManyRequests, nil, true},
+		{"paid 402", "POST", nil, true, http.StatusPaymentRequired, nil, true},
+		{"paid get timeout", "GET", nil, true, 0, context.DeadlineExceeded, false},
+		{"paid get 500", "GET", keyed, true, http.StatusInternalServerError, nil, false},
+		{"put 502", "PUT", nil, false, http.StatusBadGateway, nil, true},
+	}
+	for _, tt := range tests {
+		var resp *http.Response
+		if tt.status != 0 {
+			resp = &http.Response{StatusCode: tt.status}
+		}
+		got := retrySafety(tt.method, tt.header, tt.paymentSent, resp, tt.err)
+		if got.Safe != tt.want || got.Reason == "" {
+			t.Errorf("%s: retrySafety = %+v, want safe %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFileBody(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "model.bin")
+	if err := os.WriteFile(path, []byte("abc"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	body, err := newFileBody(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body.size != 3 {
+		t.Errorf("size = %d, want 3", body.size)
+	}
+	// SHA-256("abc"), base64-encoded as RFC 9530 requires.
+	if got, want := contentDigest(body.hash), "sha-256=:ungWv48Bz+pBQUDeXa4iI7ADYaOWF3qctBD/YfIAFa0=:"; got != want {
+		t.Errorf("contentDigest = %q, want %q", got, want)
+	}
+	req, _ := http.NewRequest("POST", "http://example.com", nil)
+	if err := body.attach(req); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(req.Body)
+	req.Body.Close()
+	if string(got) != "abc" || req.ContentLength != 3 || req.GetBody == nil {
+		t.Errorf("attach: body %q, length %d", got, req.ContentLength)
+	}
+	body.close()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("close removed the uploaded file: %v", err)
+	}
+
+	if _, err := newFileBody(dir); err == nil {
+		t.Error("newFileBody accepted a directory")
+	}
+
+	for name, want := range map[string]string{"a.json": "application/json", "model.bin": "application/octet-stream"} {
+		p := filepath.Join(dir, name)
+		os.WriteFile(p, []byte{0, 1, 2}, 0600)
+		if got := uploadContentType(p); got != want {
+			t.Errorf("uploadContentType(%s) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestPaymentMetrics(t *testing.T) {
+	tests := []struct {
+		rec  historyRecord
+		want []string
+	}{
+		{historyRecord{Status: "accepted", Network: "eip155:84532", Asset: "0x036CbD53842c5426634e7929541eC2318f3dCF7e", Amount: "1500", LatencyMs: 42},
+			[]string{"payments.attempted:1|c", "payments.accepted:1|c", "spend.base_sepolia.usdc:0.0015|c", "payment.latency:42|ms"}},
+		{historyRecord{Status: "insufficient_funds", Network: "eip155:84532", Amount: "1500"},
+			[]string{"payments.attempted:1|c", "payments.insufficient_funds:1|c"}},
+		{historyRecord{Status: "accepted", Network: "eip155:10", Asset: "0xToken", Amount: "7"},
+			[]string{"payments.attempted:1|c", "payments.accepted:1|c", "spend.eip155_10.0xtoken_atomic:7|c"}},
+		{historyRecord{}, []string{"payments.attempted:1|c", "payments.unknown:1|c"}},
+	}
+	for _, tt := range tests {
+		if got := paymentMetrics(tt.rec); !slices.Equal(got, tt.want) {
+			t.Errorf("paymentMetrics(%+v) = %q, want %q", tt.rec, got, tt.want)
+		}
+	}
+	if got := metricName("Base Sepolia"); got != "base_sepolia" {
+		t.Errorf("metricName = %q", got)
+	}
+	if _, err := newStatsdClient("localhost"); err == nil {
+		t.Error("newStatsdClient accepted an address without a port")
+	}
+}
+
+func TestOptimisticRequirements(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	const endpoint = "https://api.example.com/data"
+	required := func(amount string) (*http.Response, []byte) {
+		body := []byte(`{"x402Version":2,"accepts":[{"scheme":"exact","network":"eip155:84532","amount":"` + amount + `"}]}`)
+		header := http.Header{}
+		header.Set("Content-Type", "application/json")
+		return &http.Response{StatusCode: http.StatusPaymentRequired, Header: header}, body
+	}
+
+	resp, body := required("1000")
+	rememberRequirements("GET", endpoint, resp, body, time.Minute)
+	if lookupRequirements("POST", endpoint, time.Minute) != nil {
+		t.Error("a POST used the cached requirements")
+	}
+	if lookupRequirements("GET", endpoint, 0) != nil {
+		t.Error("the cache was used with --optimistic off")
+	}
+	cached := lookupRequirements("GET", endpoint, time.Minute)
+	if cached == nil {
+		t.Fatal("no cached requirements for GET")
+	}
+
+	// Unpaid requests are answered from the cache; paid ones go to the server.
+	var sent int
+	rt := cached.transport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		sent++
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}))
+	req, _ := http.NewRequest("GET", endpoint, nil)
+	if got, _ := rt.RoundTrip(req); got.StatusCode != http.StatusPaymentRequired || sent != 0 {
+		t.Errorf("unpaid request: status %d, %d sent", got.StatusCode, sent)
+	}
+	req.Header.Set("PAYMENT-SIGNATURE", "e30=")
+	if got, _ := rt.RoundTrip(req); got.StatusCode != http.StatusOK || sent != 1 {
+		t.Errorf("paid request: status %d, %d sent", got.StatusCode, sent)
+	}
+
+	was := cached.response(nil)
+	if resp, body := required("2000"); len(requirementsDiff(was, []byte(cached.Body), resp, body)) == 0 {
+		t.Error("a new price did not read as changed")
+	}
+}
+
+func TestQueuedPayment(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	const endpoint = "https://api.example.com/data"
+
+	// Requirements cached for --enqueue are found within the queueing window only.
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	rememberRequirements("GET", endpoint, &http.Response{StatusCode: http.StatusPaymentRequired, Header: header},
+		[]byte(`{"x402Version":2,"accepts":[{"scheme":"exact","network":"eip155:84532","amount":"1000"}]}`), enqueueMaxAge)
+	if cachedRequirementsFor("GET", endpoint, enqueueMaxAge) == nil {
+		t.Error("no requirements cached for --enqueue")
+	}
+	if cachedRequirementsFor("GET", endpoint, 0) != nil {
+		t.Error("requirements were used with no maximum age")
+	}
+
+	// Paid requests are captured, not sent.
+	var name, payment string
+	rt := queueTransport(func(h, v string) { name, payment = h, v })
+	req, _ := http.NewRequest("GET", endpoint, nil)
+	if _, err := rt.RoundTrip(req); err == nil {
+		t.Error("an unpaid request was answered while queueing")
+	}
+	req.Header.Set("PAYMENT-SIGNATURE", "sig")
+	if got, err := rt.RoundTrip(req); err != nil || got.StatusCode != http.StatusAccepted || name != "PAYMENT-SIGNATURE" || payment != "sig" {
+		t.Errorf("paid request: %v, captured %s=%q", err, name, payment)
+	}
+
+	// A queued payment whose authorization expired is dropped unsent.
+	price := x402.PaymentRequirements{Scheme: "exact", Network: "eip155:84532", Asset: "0xUSDC", Amount: "1000", PayTo: "0xSELLER"}
+	p, err := newInflight(endpoint, "GET", "", nil, price, "trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.PaymentHeader, p.State = "PAYMENT-SIGNATURE", queuedState
+	p.Payment = base64.StdEncoding.EncodeToString([]byte(`{"x402Version":2,"payload":{"authorization":{"from":"0xPAYER","nonce":"0x01","validBefore":"1000000000"}},` +
+		`"accepted":{"scheme":"exact","network":"eip155:84532","asset":"0xUSDC","amount":"1000","payTo":"0xSELLER"}}`))
+	if err := p.save(); err != nil {
+		t.Fatal(err)
+	}
+	result, code, _ := resumePayment(p, time.Second, false, resumeGuards{})
+	if result.Status != "expired" || code != ExitPaymentRejected || result.Resent {
+		t.Errorf("expired queued payment: status %q, exit %d, resent %v", result.Status, code, result.Resent)
+	}
+	if list, _ := listInflight(); len(list) != 0 {
+		t.Errorf("%d in-flight payments after the expired one was flushed, want 0", len(list))
+	}
+
+	// An unsigned payment on a mainnet is not signed without --mainnet.
+	p, err = newInflight(endpoint, "GET", "", nil, x402.PaymentRequirements{Scheme: "exact", Network: "eip155:8453", Amount: "1000"}, "trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, code, _ = resumePayment(p, time.Second, false, resumeGuards{})
+	if result.Status != "error" || code != ExitError || !strings.Contains(result.Error, "mainnet") {
+		t.Errorf("unsigned mainnet payment: status %q, exit %d, error %q", result.Status, code, result.Error)
+	}
+}
+
+func TestRequirementsDiff(t *testing.T) {
+	const usdc = "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+	required := func(accepts string) (*http.Response, []byte) {
+		header := http.Header{}
+		header.Set("Content-Type", "application/json")
+		return &http.Response{StatusCode: http.StatusPaymentRequired, Header: header}, []byte(`{"x402Version":2,"accepts":[` + accepts + `]}`)
+	}
+	option := func(network, amount, payTo string) string {
+		return `{"scheme":"exact","network":"` + network + `","asset":"` + usdc + `","amount":"` + amount + `","payTo":"` + payTo + `","maxTimeoutSeconds":60}`
+	}
+	const alice, bob = "0x1111111111111111111111111111111111111111", "0x2222222222222222222222222222222222222222"
+	paidResp, paidBody := required(option("eip155:84532", "1000", alice))
+
+	tests := []struct {
+		name    string
+		accepts string
+		want    []string
+	}{
+		{"same", option("eip155:84532", "1000", alice), nil},
+		{"price bump", option("eip155:84532", "2000", alice), []string{"price of USDC on Base Sepolia: 0.001 USDC -> 0.002 USDC"}},
+		{"new recipient", option("eip155:84532", "1000", bob), []string{"recipient of USDC on Base Sepolia: " + alice + " -> " + bob}},
+		{"option swapped", strings.Replace(option("eip155:8453", "1000", alice), usdc, "0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913", 1), []string{"new option: 0.001 USDC on Base", "option withdrawn: 0.001 USDC on Base Sepolia"}},
+		{"timeout", strings.Replace(option("eip155:84532", "1000", alice), "60", "30", 1), []string{"other payment details changed (timeout or extra)"}},
+	}
+	for _, tt := range tests {
+		resp, body := required(tt.accepts)
+		if got := requirementsDiff(paidResp, paidBody, resp, body); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: requirementsDiff = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	refusal := &http.Response{StatusCode: http.StatusPaymentRequired, Header: http.Header{}}
+	if got := requirementsDiff(paidResp, paidBody, refusal, []byte(`{"error":"invalid signature"}`)); got != nil {
+		t.Errorf("a refusal without requirements read as changed: %q", got)
+	}
+
+	limit, err := parseMaxAmount("0.0015USDC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	price := x402.PaymentRequirements{Network: "eip155:84532", Asset: usdc, Amount: "1000"}
+	if err := checkMaxAmount(limit, price); err != nil {
+		t.Errorf("0.001 USDC refused under --max-amount 0.0015: %v", err)
+	}
+	price.Amount = "2000"
+	if err := checkMaxAmount(limit, price); err == nil {
+		t.Error("0.002 USDC allowed under --max-amount 0.0015")
+	}
+	if err := checkMaxAmount(nil, price); err != nil {
+		t.Errorf("no --max-amount refused a price: %v", err)
+	}
+	if _, err := parseMaxAmount("lots"); err == nil {
+		t.Error("parseMaxAmount accepted a non-number")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	h := http.Header{}
+	h.Set("Server", "cloudflare")
+	h.Set("CF-Ray", "8a1b2c3d4e5f-AMS")
+	h.Set("X-Powered-By", "Express")
+	if got, want := strings.Join(middlewareHints(h), ","), "Cloudflare,Express"; got != want {
+		t.Errorf("middlewareHints = %q, want %q", got, want)
+	}
+
+	body := []byte(`{"x402Version":2,"error":"Payment required","resource":{"url":"https://api.example.com/data"},` +
+		`"accepts":[{"scheme":"exact","network":"eip155:84532","asset":"0x036CbD53842c5426634e7929541eC2318f3dCF7e",` +
+		`"amount":"1000","extra":{"name":"USDC","version":"2","facilitator":"https://x402.org/facilitator"}}]}`)
+	resp := &http.Response{StatusCode: http.StatusPaymentRequired, Header: http.Header{}}
+	resp.Header.Set("PAYMENT-REQUIRED", base64.StdEncoding.EncodeToString(body))
+	fp, extras := describeX402(resp, nil)
+	if fp == nil {
+		t.Fatal("describeX402 found no requirements")
+	}
+	if fp.Version != 2 || fp.Transport != "header" || fp.Error != "Payment required" || len(fp.Schemes) != 1 || !fp.Schemes[0].Supported {
+		t.Errorf("describeX402 = %+v", fp)
+	}
+	if got := strings.Join(fp.Fields, ","); got != "accepts,error,resource,x402Version" {
+		t.Errorf("fields = %q", got)
+	}
+	if got := strings.Join(fp.ExtraKeys, ","); got != "facilitator,name,version" {
+		t.Errorf("extra keys = %q", got)
+	}
+	if f := facilitatorIdentity(http.Header{}, extras, nil); f == nil || f.Identity != "https://x402.org/facilitator" {
+		t.Errorf("facilitatorIdentity = %+v", f)
+	}
+	timing := []serverTimingMetric{{Name: "settle", Dur: 12, Desc: "cdp"}}
+	if f := facilitatorIdentity(http.Header{}, nil, timing); f == nil || f.Identity != "cdp" {
+		t.Errorf("facilitatorIdentity from Server-Timing = %+v", f)
+	}
+	if f := facilitatorIdentity(http.Header{}, nil, nil); f != nil {
+		t.Errorf("facilitatorIdentity without hints = %+v", f)
+	}
+	if fp, _ := describeX402(&http.Response{Header: http.Header{}}, []byte("<html>")); fp != nil {
+		t.Errorf("describeX402 of a non-x402 body = %+v", fp)
+	}
+
+	if got := shareableURL("https://user:pw@api.example.com/data?key=secret#top"); got != "https://api.example.com/data" {
+		t.Errorf("shareableURL = %q", got)
+	}
+	m := medianTiming([]fingerprintTiming{{TotalMs: 30}, {TotalMs: 10}, {TotalMs: 20}})
+	if m.Samples != 3 || m.TotalMs != 20 {
+		t.Errorf("medianTiming = %+v", m)
+	}
+}
+
+func TestCheckFunds(t *testing.T) {
+	const usdc = "0x036cbd53842c5426634e7929541ec2318f3dcf7e"
+	balances := map[string]string{"eip155:84532": "1500"}
+	balanceOf := func(info networkInfo, asset, address string) (string, error) {
+		if raw, ok := balances[info.ChainID]; ok {
+			return raw, nil
+		}
+		return "", errors.New("rpc unreachable")
+	}
+	totals := []spendTotal{
+		{Network: "eip155:84532", Asset: usdc, Amount: "2500"},
+		{Network: "eip155:43113", Asset: usdc, Amount: "10"},
+		{Network: "eip155:999999", Asset: usdc, Amount: "10"},
+	}
+	checks := checkFunds(totals, "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266", balanceOf)
+	if len(checks) != 3 {
+		t.Fatalf("got %d checks, want 3", len(checks))
+	}
+	if c := checks[0]; c.Sufficient == nil || *c.Sufficient || c.Balance != "1500" || c.Shortfall != "1000" {
+		t.Errorf("short network: %+v", c)
+	}
+	if c := checks[1]; c.Sufficient != nil || !strings.Contains(c.Reason, "rpc unreachable") {
+		t.Errorf("failed balance check: %+v", c)
+	}
+	if c := checks[2]; c.Sufficient != nil || !strings.Contains(c.Reason, "unknown network") {
+		t.Errorf("unknown network: %+v", c)
+	}
+	if !fundsShort(checks) {
+		t.Error("fundsShort = false with a shortfall")
+	}
+
+	balances["eip155:84532"] = "2500"
+	checks = checkFunds(totals[:1], "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266", balanceOf)
+	if fundsShort(checks) || checks[0].Shortfall != "" {
+		t.Errorf("exact balance: %+v", checks[0])
+	}
+}
+
+func TestRequestProof(t *testing.T) {
+	t.Setenv("EVM_PRIVATE_KEY", "0xac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80")
+	rec := historyRecord{Endpoint: "https://api.example.com/data", Method: "POST", BodySHA256: bodyHash(`{"q":1}`), Network: "eip155:84532"}
+	proof, err := newRequestProof(rec, "eyJ4NDAyVmVyc2lvbiI6Mn0=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Proof = proof
+	if proof.Signer != "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266" {
+		t.Errorf("signer = %s", proof.Signer)
+	}
+	if signer, err := verifyRequestProof(rec); err != nil || signer != proof.Signer {
+		t.Errorf("verifyRequestProof = %s, %v", signer, err)
+	}
+
+	tampered := rec
+	tampered.Endpoint = "https://api.example.com/other"
+	if _, err := verifyRequestProof(tampered); err == nil {
+		t.Error("a proof verified for another URL")
+	}
+	tampered = rec
+	tampered.Proof = &requestProof{PaymentSHA256: strings.Repeat("0", 64), Signer: proof.Signer, Signature: proof.Signature}
+	if _, err := verifyRequestProof(tampered); err == nil {
+		t.Error("a proof verified for another payment header")
+	}
+	if _, err := verifyRequestProof(historyRecord{}); err == nil {
+		t.Error("a record without a proof verified")
+	}
+}
+
+func TestTiers(t *testing.T) {
+	option := func(amount string, extra map[string]interface{}) x402.PaymentRequirements {
+		return x402.PaymentRequirements{Scheme: "exact", Network: "eip155:84532", Amount: amount, Extra: extra}
+	}
+	accepts := []x402.PaymentRequirements{
+		option("1000", map[string]interface{}{"tier": "sd", "tierDescription": "480p"}),
+		option("5000", map[string]interface{}{"quality": "hd"}),
+	}
+	if tier, desc := acceptTier(accepts[0].Extra); tier != "sd" || desc != "480p" {
+		t.Errorf("acceptTier = %q, %q", tier, desc)
+	}
+	if got := describeTier(accepts[1].Extra); got != "hd" {
+		t.Errorf("describeTier = %q", got)
+	}
+	if tier, _ := acceptTier(map[string]interface{}{"name": "USDC"}); tier != "" {
+		t.Errorf("acceptTier without a tier = %q", tier)
+	}
+
+	only, err := filterTier(accepts, "HD")
+	if err != nil || len(only) != 1 || only[0].Amount != "5000" {
+		t.Errorf("filterTier(HD) = %v, %v", only, err)
+	}
+	if _, err := filterTier(accepts, "4k"); err == nil || !strings.Contains(err.Error(), "offered: sd, hd") {
+		t.Errorf("filterTier(4k) error = %v", err)
+	}
+	if _, err := filterTier([]x402.PaymentRequirements{option("1", nil)}, "hd"); err == nil || !strings.Contains(err.Error(), "no price tiers") {
+		t.Errorf("filterTier without tiers error = %v", err)
+	}
+
+	caps, err := capabilityMatrix([]byte(`{"x402Version":2,"accepts":[{"scheme":"exact","network":"eip155:84532","extra":{"tier":"sd"}},{"scheme":"exact","network":"eip155:84532"}]}`))
+	if err != nil || caps[0].Tier != "sd" || caps[1].Tier != "" {
+		t.Errorf("capabilityMatrix tiers = %+v, %v", caps, err)
+	}
+}
+
+func TestUsageByEndpoint(t *testing.T) {
+	for body, want := range map[string]string{
+		``: "empty", ` {} `: "empty", `null`: "empty",
+		`{"error":"upstream timeout"}`: "error", `{"errors":[{"message":"bad"}]}`: "error",
+		`{"error":null,"data":1}`: "", `{"data":{"v":1}}`: "", `plain text`: "",
+	} {
+		if got := suspectBody([]byte(body)); got != want {
+			t.Errorf("suspectBody(%q) = %q, want %q", body, got, want)
+		}
+	}
+
+	accepted := func(endpoint, amount, body string) historyRecord {
+		rec := historyRecord{Endpoint: endpoint, Status: "accepted", StatusCode: http.StatusOK,
+			Network: "eip155:84532", Asset: "0x036CbD53842c5426634e7929541eC2318f3dCF7e", Amount: amount}
+		rec.measureBody([]byte(body))
+		return rec
+	}
+	records := []historyRecord{
+		accepted("https://a/x", "1000", `{"data":"0123456789"}`),
+		accepted("https://a/x", "1000", `{}`),
+		accepted("https://b/y", "500", `{"error":"quota"}`),
+		{Endpoint: "https://a/x", Status: "accepted", Network: "eip155:84532", Asset: "0x036CbD53842c5426634e7929541eC2318f3dCF7e", Amount: "1000"},
+		{Endpoint: "https://c/z", Status: "rejected", Amount: "1000"},
+	}
+	usage := usageByEndpoint(records)
+	if len(usage) != 2 {
+		t.Fatalf("got %d endpoints, want 2: %+v", len(usage), usage)
+	}
+	a := usage[0]
+	if a.Endpoint != "https://a/x" || a.Payments != 3 || a.Measured != 2 || a.BytesReceived != 23 || a.AvgBytes != 11 || a.Empty != 1 || !a.suspect() {
+		t.Errorf("a = %+v", a)
+	}
+	if len(a.Spent) != 1 || a.Spent[0].Amount != "3000" {
+		t.Errorf("a spent = %+v", a.Spent)
+	}
+	if b := usage[1]; b.ErrorBodies != 1 || b.Spent[0].Amount != "500" {
+		t.Errorf("b = %+v", b)
+	}
+}
+
+func TestFacilitatorAttestation(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	facilitator := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	other, _ := crypto.GenerateKey()
+	attest := func(settle string, signer *ecdsa.PrivateKey) []byte {
+		var fields map[string]json.RawMessage
+		json.Unmarshal([]byte(settle), &fields)
+		sig, _ := crypto.Sign(accounts.TextHash(attestedMessage(fields)), signer)
+		sig[64] += 27
+		att, _ := json.Marshal(facilitatorAttestation{Signer: crypto.PubkeyToAddress(signer.PublicKey).Hex(), Signature: "0x" + hex.EncodeToString(sig)})
+		fields["attestation"] = att
+		out, _ := json.Marshal(fields)
+		return out
+	}
+	const settle = `{"success":true,"transaction":"0xabab","network":"eip155:84532","payer":"0x1111111111111111111111111111111111111111"}`
+	const payer = "0x1111111111111111111111111111111111111111"
+	price := x402.PaymentRequirements{Network: "eip155:84532", Amount: "1000"}
+
+	if got := checkAttestation([]byte(settle), payer, price, []string{facilitator}, "", time.Second); got.Status != "absent" {
+		t.Errorf("no attestation: status %q", got.Status)
+	}
+	signed := attest(settle, key)
+	if got := checkAttestation(signed, payer, price, []string{facilitator}, "", time.Second); got.Status != "verified" || got.KeySource != "pinned" {
+		t.Errorf("pinned key: %+v", got)
+	}
+	if got := checkAttestation(attest(settle, other), payer, price, []string{facilitator}, "", time.Second); got.Status != "untrusted" {
+		t.Errorf("another key: status %q", got.Status)
+	}
+	tampered := strings.Replace(string(signed), "0xabab", "0xcdcd", 1)
+	if got := checkAttestation([]byte(tampered), payer, price, []string{facilitator}, "", time.Second); got.Status != "invalid" {
+		t.Errorf("tampered response: status %q", got.Status)
+	}
+
+	// A validly signed attestation of another payment does not vouch for this one.
+	if got := checkAttestation(signed, "0x2222222222222222222222222222222222222222", price, []string{facilitator}, "", time.Second); got.Status != "invalid" {
+		t.Errorf("another payer: %+v", got)
+	}
+	if got := checkAttestation(signed, payer, x402.PaymentRequirements{Network: "eip155:8453", Amount: "1000"}, []string{facilitator}, "", time.Second); got.Status != "invalid" {
+		t.Errorf("another network: %+v", got)
+	}
+	for name, other := range map[string]string{
+		"another amount":    `{"success":true,"transaction":"0xabab","network":"eip155:84532","payer":"` + payer + `","amount":"2000"}`,
+		"no transaction":    `{"success":true,"transaction":"","network":"eip155:84532","payer":"` + payer + `"}`,
+		"failed settlement": `{"success":false,"transaction":"0xabab","network":"eip155:84532","payer":"` + payer + `"}`,
+	} {
+		if got := checkAttestation(attest(other, key), payer, price, []string{facilitator}, "", time.Second); got.Status != "invalid" {
+			t.Errorf("%s: %+v", name, got)
+		}
+	}
+	withAmount := `{"success":true,"transaction":"0xabab","network":"eip155:84532","payer":"` + payer + `","amount":"1000"}`
+	if got := checkAttestation(attest(withAmount, key), payer, price, []string{facilitator}, "", time.Second); got.Status != "verified" {
+		t.Errorf("attested amount: %+v", got)
+	}
+	// Nested objects are signed with sorted keys too.
+	var nested map[string]json.RawMessage
+	json.Unmarshal([]byte(`{"z":{"b":1,"a":[{"d":2,"c":1.50}]},"attestation":{},"y":true}`), &nested)
+	if got := string(attestedMessage(nested)); got != `{"y":true,"z":{"a":[{"c":1.50,"d":2}],"b":1}}` {
+		t.Errorf("attestedMessage = %s", got)
+	}
+
+	// Without pinned keys, the facilitator's published signers are trusted.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/supported" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(x402.SupportedResponse{Kinds: []x402.SupportedKind{}, Extensions: []string{}, Signers: map[string][]string{"eip155:*": {facilitator}}})
+	}))
+	defer srv.Close()
+	if got := checkAttestation(signed, payer, price, nil, srv.URL, time.Second); got.Status != "verified" || got.KeySource != srv.URL {
+		t.Errorf("published key: %+v", got)
+	}
+	if got := signersFor(x402.SupportedResponse{Signers: map[string][]string{"solana:*": {"abc"}}}, "eip155:84532"); len(got) != 0 {
+		t.Errorf("signersFor matched another family: %v", got)
+	}
+	if _, err := parseFacilitatorKeys("0x123"); err == nil {
+		t.Error("parseFacilitatorKeys accepted a short address")
+	}
+}
+
+func TestRecordTags(t *testing.T) {
+	t.Setenv("X402_TAGS", "team=data, job=adhoc")
+	tags, err := parseTags(append(tagsByEnv(), "job=nightly-scrape"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatTags(tags); got != "job=nightly-scrape,team=data" {
+		t.Errorf("a --tag did not override $X402_TAGS: %s", got)
+	}
+	for _, bad := range []string{"job", "job=", "=x", "a b=c"} {
+		if _, err := parseTags([]string{bad}); err == nil {
+			t.Errorf("parseTags accepted %q", bad)
+		}
+	}
+
+	recordTags = tags
+	defer func() { recordTags = nil }()
+	rec := newHistoryRecord("https://a/x", "GET", "", "", nil)
+	recordTags["job"] = "changed"
+	if rec.Tags["job"] != "nightly-scrape" || rec.Tags["team"] != "data" {
+		t.Errorf("record tags = %v", rec.Tags)
+	}
+
+	records := []historyRecord{
+		{Endpoint: "a", Tags: map[string]string{"job": "nightly-scrape", "team": "data"}},
+		{Endpoint: "b", Tags: map[string]string{"job": "backfill", "team": "data"}},
+		{Endpoint: "c"},
+	}
+	tests := []struct {
+		filter tagFilter
+		want   string
+	}{
+		{nil, "abc"},
+		{tagFilter{"team=data"}, "ab"},
+		{tagFilter{"team=data", "job=backfill"}, "b"},
+		{tagFilter{"job"}, "ab"},
+		{tagFilter{"team=web"}, ""},
+	}
+	for _, tt := range tests {
+		var got string
+		for _, r := range tt.filter.filter(records) {
+			got += r.Endpoint
+		}
+		if got != tt.want {
+			t.Errorf("filter %v kept %q, want %q", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	amounts := []struct {
+		atomic   string
+		decimals int
+		want     string
+	}{
+		{"1500000", 6, "1.5"},
+		{"1", 6, "0.000001"},
+		{"0", 6, "0"},
+		{"000100", 2, "1"},
+		{"1000000000000000000000001", 24, "1.000000000000000000000001"},
+		{"42", 0, "42"},
+	}
+	for _, tt := range amounts {
+		got, err := exactAmount(tt.atomic, tt.decimals)
+		if err != nil || got != tt.want {
+			t.Errorf("exactAmount(%s, %d) = %q, %v; want %q", tt.atomic, tt.decimals, got, err, tt.want)
+		}
+		if back, err := humanToAtomic(got, tt.decimals); err != nil || strings.TrimLeft(back, "0") != strings.TrimLeft(tt.atomic, "0") {
+			t.Errorf("humanToAtomic(%s, %d) = %q, %v; want %s", got, tt.decimals, back, err, tt.atomic)
+		}
+	}
+	for _, bad := range []string{"-1", "1.5", "+2", "1e6", ""} {
+		if _, err := exactAmount(bad, 6); err == nil {
+			t.Errorf("exactAmount accepted %q", bad)
+		}
+	}
+
+	if amount, unit := splitUnit([]string{"1.5USDC"}); amount != "1.5" || unit != "USDC" {
+		t.Errorf("splitUnit(1.5USDC) = %q, %q", amount, unit)
+	}
+	if amount, unit := splitUnit([]string{"1.5", "USDC"}); amount != "1.5" || unit != "USDC" {
+		t.Errorf("splitUnit(1.5 USDC) = %q, %q", amount, unit)
+	}
+	if d, unit, err := conversionDecimals(-1, "", "", "usdc"); err != nil || d != 6 || unit != "USDC" {
+		t.Errorf("USDC unit: %d, %q, %v", d, unit, err)
+	}
+	if d, _, err := conversionDecimals(-1, "USDC", "base-sepolia", ""); err != nil || d != 6 {
+		t.Errorf("--asset USDC --network base-sepolia: %d, %v", d, err)
+	}
+	if _, _, err := conversionDecimals(-1, "", "", "ETH"); err == nil {
+		t.Error("an unknown unit without --decimals was accepted")
+	}
+	if _, _, err := conversionDecimals(-1, "", "", ""); err == nil {
+		t.Error("no decimals at all was accepted")
+	}
+}
+
+func TestResultSchema(t *testing.T) {
+	s := resultSchema()
+	props := s["properties"].(map[string]any)
+	if v := props["schemaVersion"].(map[string]any)["const"]; v != schemaVersion {
+		t.Errorf("schemaVersion const = %v", v)
+	}
+	if !slices.Contains(s["required"].([]string), "schemaVersion") {
+		t.Error("schemaVersion is not required")
+	}
+
+	// Every field a result can carry is described, nested ones under $defs.
+	affordable := true
+	result := &jsonResult{
+		SchemaVersion: schemaVersion, Version: "v", Status: "accepted",
+		Probe:              &probeResult{StatusCode: 402, Affordability: []affordability{{Network: "n", Affordable: &affordable}}},
+		Payment:            &payResult{Accepted: true, Attestation: &attestationCheck{Status: "verified"}},
+		RequirementsChange: &requirementsChange{Changes: []string{"x"}},
+	}
+	var out map[string]json.RawMessage
+	raw, _ := json.Marshal(result)
+	json.Unmarshal(raw, &out)
+	for k := range out {
+		if _, ok := props[k]; !ok {
+			t.Errorf("field %q is not in the schema", k)
+		}
+	}
+	defs := s["$defs"].(map[string]any)
+	for _, name := range []string{"probeResult", "payResult", "affordability", "attestationCheck", "requirementsChange"} {
+		if _, ok := defs[name]; !ok {
+			t.Errorf("$defs has no %s", name)
+		}
+	}
+	// probe is always written, as null before the probe ran.
+	if _, ok := props["probe"].(map[string]any)["anyOf"]; !ok {
+		t.Error("probe is not nullable")
+	}
+	affordableSchema := defs["affordability"].(map[string]any)["properties"].(map[string]any)["affordable"].(map[string]any)
+	if _, ok := affordableSchema["anyOf"]; !ok {
+		t.Error("affordability.affordable (a *bool without omitempty) is not nullable")
+	}
+
+	for _, v := range []string{"v1", "v2"} {
+		if err := checkOutputSchema(v); err != nil {
+			t.Errorf("checkOutputSchema(%s): %v", v, err)
+		}
+	}
+	if err := checkOutputSchema("v3"); err == nil {
+		t.Error("checkOutputSchema accepted v3")
+	}
+}
+
+func TestRateCard(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	api, err := parseOpenAPI(`openapi: 3.0.3
+info:
+  title: Weather API
+  version: "1.0"
+servers:
+  - url: https://api.example.com
+paths:
+  /weather:
+    get:
+      summary: Current weather
+      responses:
+        "200":
+          description: OK
+    post:
+      summary: "Forecast"
+  "/users/{id}":
+    delete:
+      responses:
+        "204":
+          summary: not the operation's
+components: {}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if api.Title != "Weather API" || api.Server != "https://api.example.com" {
+		t.Errorf("title, server = %q, %q", api.Title, api.Server)
+	}
+	var ops []string
+	for _, op := range api.Operations {
+		ops = append(ops, op.Method+" "+op.Path+" "+op.Summary)
+	}
+	if got, want := strings.Join(ops, "|"), "GET /weather Current weather|POST /weather Forecast|DELETE /users/{id} "; got != want {
+		t.Errorf("YAML operations = %q, want %q", got, want)
+	}
+
+	api, err = parseOpenAPI(`{"info":{"title":"T"},"paths":{"/b":{"post":{"summary":"B"},"parameters":[]},"/a":{"get":{}}}}`)
+	if err != nil || len(api.Operations) != 2 || api.Operations[0].Path != "/a" || api.Operations[1].Summary != "B" {
+		t.Errorf("JSON operations = %+v, %v", api, err)
+	}
+	if _, err := parseOpenAPI("openapi: 3.0.3\npaths: {}\n"); err == nil {
+		t.Error("a spec without operations was accepted")
+	}
+
+	if path, missing := expandPath("/users/{id}/posts/{post}", map[string]string{"id": "42"}); missing != "post" || path != "/users/42/posts/{post}" {
+		t.Errorf("expandPath = %q, missing %q", path, missing)
+	}
+
+	body := []byte(`{"x402Version":2,"accepts":[{"scheme":"exact","network":"eip155:84532",` +
+		`"asset":"0x036CbD53842c5426634e7929541eC2318f3dCF7e","amount":"1500","payTo":"0x0000000000000000000000000000000000000001"}]}`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Header().Set("PAYMENT-REQUIRED", base64.StdEncoding.EncodeToString(body))
+			w.WriteHeader(http.StatusPaymentRequired)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	paid := priceOperation(srv.Client(), srv.URL, apiOperation{Path: "/weather", Method: "GET"}, nil)
+	if paid.Status != "paid" || len(paid.Prices) != 1 || paid.Prices[0].Price != "0.0015 USDC" || paid.Prices[0].Network != "Base Sepolia" {
+		t.Errorf("paid operation = %+v", paid)
+	}
+	free := priceOperation(srv.Client(), srv.URL, apiOperation{Path: "/weather", Method: "POST"}, nil)
+	skipped := priceOperation(srv.Client(), srv.URL, apiOperation{Path: "/users/{id}", Method: "DELETE"}, nil)
+	if free.Status != "free" || skipped.Status != "skipped" {
+		t.Errorf("free, skipped = %+v, %+v", free, skipped)
+	}
+
+	var b strings.Builder
+	writeRateCardMarkdown(&b, rateCard{Title: "Weather API", BaseURL: srv.URL, Operations: []rateCardEntry{paid, free, skipped}})
+	for _, want := range []string{"# Weather API pricing", "| GET | `/weather` | 0.0015 USDC | Base Sepolia |", "| POST | `/weather` | Free |", "- DELETE `/users/{id}`: no --path-param for {id}"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("rate card lacks %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestMethodPrices(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	required := func(amount string) string {
+		return base64.StdEncoding.EncodeToString([]byte(`{"x402Version":2,"accepts":[{"scheme":"exact","network":"eip155:84532",` +
+			`"asset":"0x036CbD53842c5426634e7929541eC2318f3dCF7e","amount":"` + amount + `","payTo":"0x0000000000000000000000000000000000000001"}]}`))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("PAYMENT-REQUIRED", required("1000"))
+			w.WriteHeader(http.StatusPaymentRequired)
+		case http.MethodPost:
+			w.Header().Set("PAYMENT-REQUIRED", required("5000"))
+			w.WriteHeader(http.StatusPaymentRequired)
+		case http.MethodPut:
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+
+	var got []methodPrice
+	for _, m := range []string{"GET", "POST", "PUT", "DELETE"} {
+		got = append(got, probeMethod(srv.Client(), srv.URL, m, nil))
+	}
+	want := []struct{ status, price string }{{"paid", "0.001 USDC"}, {"paid", "0.005 USDC"}, {"free", ""}, {"blocked", ""}}
+	for i, w := range want {
+		price := ""
+		if len(got[i].Prices) > 0 {
+			price = got[i].Prices[0].Price
+		}
+		if got[i].Status != w.status || price != w.price {
+			t.Errorf("%s: %s %q, want %s %q", got[i].Method, got[i].Status, price, w.status, w.price)
+		}
+	}
+	if !pricesDiffer(got) {
+		t.Error("GET and POST at different prices do not differ")
+	}
+	if pricesDiffer([]methodPrice{got[0], got[0], got[2]}) {
+		t.Error("the same price read as different")
+	}
+}
+
+func TestFreeRoutePolicy(t *testing.T) {
+	defer func() { freeRoutes = freeRoutesDefault }()
+	results := []batchResult{{Status: "accepted"}, {Status: "free"}, {Status: "payment_required"}}
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{nil, ExitFreeRoute},
+		{[]string{"--treat-free-as-success"}, ExitSuccess},
+		{[]string{"--fail-on-free"}, ExitError},
+	}
+	for _, tt := range tests {
+		freeRoutes = freeRoutesDefault
+		fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+		apply := freeRouteFlags(fs)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		if err := apply(); err != nil {
+			t.Fatal(err)
+		}
+		if got := batchExitCode(results); got != tt.want {
+			t.Errorf("%v: batchExitCode = %d, want %d", tt.args, got, tt.want)
+		}
+	}
+
+	freeRoutes = freeRoutesSuccess
+	if got := batchExitCode(append(results, batchResult{Status: "no_402"})); got != ExitError {
+		t.Errorf("--treat-free-as-success let a failure pass: exit %d", got)
+	}
+	fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+	apply := freeRouteFlags(fs)
+	fs.Parse([]string{"--treat-free-as-success", "--fail-on-free"})
+	if err := apply(); err == nil {
+		t.Error("--treat-free-as-success with --fail-on-free was accepted")
+	}
+
+	s := newBatchSummary(append(results, batchResult{Status: "rejected"}), 0)
+	if s.Categories["paid"] != 2 || s.Categories["free"] != 1 || s.Categories["failed"] != 1 {
+		t.Errorf("categories = %v", s.Categories)
+	}
+}
+
+func TestHistoryExport(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+	records := []historyRecord{
+		{ID: "b2", Time: at.Add(time.Hour), Endpoint: "https://api.example.com/data?q=1", Method: "GET", Status: "accepted",
+			Network: "eip155:84532", Asset: "0x036CbD53842c5426634e7929541eC2318f3dCF7e", Amount: "1500",
+			Payer: "0xPayer", Transaction: "0xabc", Tags: map[string]string{"job": "nightly"}},
+		{ID: "a1", Time: at, Endpoint: "http://localhost:4020/x", Method: "POST", Status: "accepted",
+			Network: "eip155:999999", Asset: "0xToken", Amount: "42", Payer: "0xPayer"},
+		{ID: "c3", Time: at, Endpoint: "https://api.example.com/data", Method: "GET", Status: "rejected",
+			Network: "eip155:84532", Asset: "0x036CbD53842c5426634e7929541eC2318f3dCF7e", Amount: "1000"},
+	}
+	entries := exportEntries(records)
+	if len(entries) != 2 || entries[0].record.ID != "a1" || entries[1].record.ID != "b2" {
+		t.Fatalf("exportEntries = %+v, want the two accepted payments, oldest first", entries)
+	}
+
+	var ledger strings.Builder
+	writeLedger(&ledger, entries, "Expenses:x402", "Assets:x402")
+	for _, want := range []string{
+		"2026-03-01 * localhost:4020\n",
+		"    ; units: atomic\n",
+		`    Expenses:x402:localhost-4020  42 "0xToken"` + "\n",
+		"2026-03-01 * api.example.com\n",
+		"    ; endpoint: GET https://api.example.com/data?q=1\n",
+		"    ; transaction: 0xabc\n",
+		"    ; job: nightly\n",
+		"    Expenses:x402:api.example.com  0.0015 USDC\n",
+		"    Assets:x402:Base Sepolia\n",
+	} {
+		if !strings.Contains(ledger.String(), want) {
+			t.Errorf("ledger export lacks %q:\n%s", want, ledger.String())
+		}
+	}
+
+	var ofx strings.Builder
+	if skipped := writeOFX(&ofx, entries, at.Add(2*time.Hour)); skipped != 1 {
+		t.Errorf("writeOFX skipped %d, want the non-USDC payment", skipped)
+	}
+	for _, want := range []string{"OFXHEADER:100\n", "<CURDEF>USD\n", "<ACCTID>0xPayer\n", "<TRNAMT>-0.0015\n",
+		"<FITID>b2\n", "<NAME>api.example.com\n", "<DTPOSTED>20260301133000.000[0:GMT]\n", "</OFX>\n"} {
+		if !strings.Contains(ofx.String(), want) {
+			t.Errorf("OFX export lacks %q:\n%s", want, ofx.String())
+		}
+	}
+	if strings.Contains(ofx.String(), "<FITID>a1") {
+		t.Error("OFX export includes a payment that is not in USDC")
+	}
+}
+
+func TestShownBody(t *testing.T) {
+	body := []byte(strings.Repeat("a", 600))
+	if got := shownBody(body, 500); !strings.HasPrefix(got, strings.Repeat("a", 500)+"...") || !strings.Contains(got, "100 more bytes") {
+		t.Errorf("shownBody(600 bytes, 500) = %q, want the first 500 bytes and a note of the 100 left out", got)
+	}
+	if got := shownBody(body, -1); got != string(body) {
+		t.Errorf("shownBody(body, -1) = %d bytes, want all %d", len(got), len(body))
+	}
+	if got := shownBody([]byte("short"), 300); got != "short" {
+		t.Errorf("shownBody(short, 300) = %q, want it whole", got)
+	}
+}
+
+func TestSharedSigner(t *testing.T) {
+	a, err := signerFor(vectorFixtureKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := signerFor(strings.TrimPrefix(vectorFixtureKey, "0x"))
+	if a != b {
+		t.Error("signerFor unlocked the same key twice")
+	}
+	if _, err := signerFor("0xnot-a-key"); err == nil {
+		t.Error("signerFor accepted an invalid key")
+	}
+
+	domain := x402evm.TypedDataDomain{Name: "USDC", Version: "2", ChainID: big.NewInt(84532), VerifyingContract: "0x036CbD53842c5426634e7929541eC2318f3dCF7e"}
+	types := map[string][]x402evm.TypedDataField{"Auth": {{Name: "nonce", Type: "bytes32"}}}
+	sign := func(nonce string) error {
+		raw, _ := x402evm.HexToBytes(nonce)
+		_, err := a.SignTypedData(context.Background(), domain, types, "Auth", map[string]any{"nonce": raw})
+		return err
+	}
+
+	// Concurrent payments each sign their own nonce.
+	var wg sync.WaitGroup
+	errs := make([]error, 16)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nonce, _ := x402evm.CreateNonce()
+			errs[i] = sign(nonce)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("concurrent signing: %v", err)
+		}
+	}
+
+	nonce, _ := x402evm.CreateNonce()
+	if err := sign(nonce); err != nil {
+		t.Fatal(err)
+	}
+	if err := sign(nonce); err == nil {
+		t.Error("the shared signer signed the same nonce twice")
+	}
+}
+
+func TestResponseDecrypter(t *testing.T) {
+	plaintext := []byte(`{"secret":"paid data"}`)
+
+	key, _ := crypto.HexToECDSA(vectorFixtureKey[2:])
+	sealed, err := ecies.Encrypt(rand.Reader, ecies.ImportECDSAPublic(&key.PublicKey), plaintext, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := newResponseDecrypter(decryptECIES, "", vectorFixtureKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range [][]byte{sealed, []byte(base64.StdEncoding.EncodeToString(sealed) + "\n")} {
+		if got, err := d.decrypt(body); err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("ecies decrypt = %q, %v", got, err)
+		}
+	}
+	other, _ := crypto.GenerateKey()
+	wrong, _ := newResponseDecrypter(decryptECIES, hex.EncodeToString(crypto.FromECDSA(other)), vectorFixtureKey)
+	if _, err := wrong.decrypt(sealed); err == nil {
+		t.Error("ecies decrypted with the wrong key")
+	}
+
+	id, _ := age.GenerateX25519Identity()
+	var armored bytes.Buffer
+	aw := armor.NewWriter(&armored)
+	w, _ := age.Encrypt(aw, id.Recipient())
+	w.Write(plaintext)
+	w.Close()
+	aw.Close()
+	file := filepath.Join(t.TempDir(), "identity.txt")
+	os.WriteFile(file, []byte("# created by age-keygen\n"+id.String()+"\n"), 0600)
+	d, err = newResponseDecrypter(decryptAge, file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := d.decrypt(armored.Bytes()); err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("age decrypt = %q, %v", got, err)
+	}
+	if _, err := d.decrypt(plaintext); err == nil {
+		t.Error("age decrypted a body that is not encrypted")
+	}
+
+	for _, tt := range []struct{ scheme, key string }{{decryptAge, ""}, {decryptECIES, "0xnot-a-key"}, {"rsa", "x"}} {
+		if _, err := newResponseDecrypter(tt.scheme, tt.key, ""); err == nil {
+			t.Errorf("newResponseDecrypter(%q, %q) should fail", tt.scheme, tt.key)
+		}
+	}
+}
+
+func TestParseFreeTier(t *testing.T) {
+	header := func(kv ...string) http.Header {
+		h := http.Header{}
+		for i := 0; i < len(kv); i += 2 {
+			h.Set(kv[i], kv[i+1])
+		}
+		return h
+	}
+	tests := []struct {
+		name       string
+		header     http.Header
+		servedFree bool
+		want       *freeTier
+	}{
+		{"free headers", header("X-Free-Calls-Remaining", "7", "X-Free-Calls-Limit", "10", "X-Free-Calls-Reset", "3600"), false,
+			&freeTier{Step: "probe", Header: "X-Free-Calls-Remaining", Remaining: 7, Limit: 10, Reset: "3600"}},
+		{"rate limit on a free response", header("X-RateLimit-Remaining", "3"), true,
+			&freeTier{Step: "probe", Header: "X-RateLimit-Remaining", Remaining: 3}},
+		{"rate limit on a paid response", header("X-RateLimit-Remaining", "3"), false, nil},
+		{"structured", header("RateLimit", `limit=100, remaining=0, reset=30`), true,
+			&freeTier{Step: "probe", Header: "RateLimit", Remaining: 0, Limit: 100, Reset: "30"}},
+		{"malformed", header("X-Free-Remaining", "many"), true, nil},
+		{"none", header(), true, nil},
+	}
+	for _, tt := range tests {
+		got := parseFreeTier("probe", tt.header, tt.servedFree)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("%s: parseFreeTier = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	f := &freeTier{Remaining: 7, Limit: 10, Price: "0.001 USDC", Reset: "3600"}
+	if got, want := f.describe(), "7 of 10 free calls remaining, then 0.001 USDC each (resets in 3600s)"; got != want {
+		t.Errorf("describe = %q, want %q", got, want)
+	}
+	f = &freeTier{Remaining: 0, Price: "0.001 USDC"}
+	if got, want := f.describe(), "no free calls remaining; each call costs 0.001 USDC"; got != want {
+		t.Errorf("describe = %q, want %q", got, want)
+	}
+}
+
+func TestCheckPayment(t *testing.T) {
+	signer, _ := evmsigners.NewClientSignerFromPrivateKey(vectorFixtureKey)
+	set, err := buildVectors(signer, networks["base-sepolia"], "1000", "0x1111111111111111111111111111111111111111", "https://example.com/paid", vectorValidBefore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := json.Marshal(set.PaymentRequired)
+	accepts, err := parseRequirementsFile([]byte(base64.StdEncoding.EncodeToString(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1767225600, 0)
+	failed := func(r *checkPaymentResult) []string {
+		var names []string
+		for _, c := range r.Checks {
+			if !c.OK && !c.Skipped {
+				names = append(names, c.Name)
+			}
+		}
+		return names
+	}
+
+	payloads := map[string][]byte{}
+	for _, v := range set.Vectors {
+		payloads[v.Name], _ = json.Marshal(v.Payload)
+	}
+	for name, want := range map[string][]string{"valid": nil, "expired": {"expiry"}, "wrong-amount": {"amount"}} {
+		got := checkPayment(accepts, payloads[name], now)
+		if !slices.Equal(failed(got), want) || got.Valid != (want == nil) {
+			t.Errorf("%s: failed checks %v (valid %v), want %v", name, failed(got), got.Valid, want)
+		}
+		if got.Payer != signer.Address() {
+			t.Errorf("%s: payer %s, want %s", name, got.Payer, signer.Address())
+		}
+	}
+
+	// Changing what was signed breaks the signature.
+	tampered := strings.Replace(string(payloads["valid"]), `"0x1111111111111111111111111111111111111111"`, `"0x2222222222222222222222222222222222222222"`, 1)
+	if got := failed(checkPayment(accepts, []byte(tampered), now)); !slices.Contains(got, "signature") {
+		t.Errorf("tampered payment: failed checks %v, want signature among them", got)
+	}
+	if got := checkPayment(accepts, []byte(`{"x402Version":3}`), now); got.Valid || got.Checks[0].Name != "structure" {
+		t.Errorf("unsupported version: %+v", got)
+	}
+	if _, err := parseRequirementsFile([]byte("not requirements")); err == nil {
+		t.Error("parseRequirementsFile accepted garbage")
+	}
+}
+
+func TestConfirmPrompt(t *testing.T) {
+	defer func(via string, timeout time.Duration) { promptVia, confirmTimeout = via, timeout }(promptVia, confirmTimeout)
+	promptVia = ""
+	lines := make(chan string, 4)
+	stdinLinesOnce.Do(func() {}) // answers come from lines, not the test's stdin
+	stdinLines = lines
+	defer func() {
+		// Later prompts see stdin at EOF, as go test gives it.
+		stdinLines = make(chan string)
+		close(stdinLines)
+	}()
+
+	// Piped answers answer one prompt each.
+	confirmTimeout = time.Second
+	lines <- "y"
+	lines <- "no"
+	lines <- "yes"
+	for i, want := range []bool{true, false, true} {
+		if got := confirmPrompt("Pay? [y/N] "); got != want {
+			t.Errorf("piped answer %d: confirmPrompt = %v, want %v", i+1, got, want)
+		}
+	}
+	lines <- " q "
+	if answer, ok := askPrompt("Select option [1-2] (q to quit): ", "quitting"); !ok || answer != "q" {
+		t.Errorf("askPrompt = %q, %v", answer, ok)
+	}
+
+	// An unanswered prompt denies, and an answer typed for it late does not answer the next.
+	confirmTimeout = 20 * time.Millisecond
+	if confirmPrompt("Pay? [y/N] ") {
+		t.Error("an unanswered prompt approved")
+	}
+	lines <- "no"
+	confirmTimeout = time.Second
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		lines <- "y"
+	}()
+	if !confirmPrompt("Pay? [y/N] ") {
+		t.Error("a late answer to a timed-out prompt answered the next one")
+	}
+}
+
+func TestPromptRoutes(t *testing.T) {
+	for spec, want := range map[string][2]string{
+		"":                   {"stdin", ""},
+		"stdin":              {"stdin", ""},
+		"tty":                {"tty", "/dev/tty"},
+		"tty:/dev/pts/3":     {"tty", "/dev/pts/3"},
+		"pinentry":           {"pinentry", "pinentry"},
+		"pinentry:/x/pin-qt": {"pinentry", "/x/pin-qt"},
+	} {
+		route, target, err := parsePromptRoute(spec)
+		if err != nil || route != want[0] || target != want[1] {
+			t.Errorf("parsePromptRoute(%q) = %q, %q, %v; want %q, %q", spec, route, target, err, want[0], want[1])
+		}
+	}
+	for _, spec := range []string{"gui", "stdin:x"} {
+		if _, _, err := parsePromptRoute(spec); err == nil {
+			t.Errorf("parsePromptRoute(%q) accepted", spec)
+		}
+	}
+
+	defer func(via string) { promptVia = via }(promptVia)
+	dir := t.TempDir()
+	for answer, want := range map[string]bool{"yes\n": true, "y": true, "no\n": false, "": false} {
+		device := filepath.Join(dir, "tty")
+		os.WriteFile(device, []byte(answer), 0o600)
+		promptVia = "tty:" + device
+		if got := confirmPrompt("Pay? [y/N] "); got != want {
+			t.Errorf("tty answer %q: confirmPrompt = %v, want %v", answer, got, want)
+		}
+		if written, _ := os.ReadFile(device); !strings.HasSuffix(string(written), "Pay? [y/N] ") {
+			t.Errorf("tty answer %q: question not written to the device: %q", answer, written)
+		}
+	}
+	device := filepath.Join(dir, "tty")
+	os.WriteFile(device, []byte(" 2 \n"), 0o600)
+	promptVia = "tty:" + device
+	if answer, ok := askPrompt("Select option [1-3]: ", "quitting"); !ok || answer != "2" {
+		t.Errorf("tty askPrompt = %q, %v", answer, ok)
+	}
+	os.WriteFile(device, []byte("hunter2\nhunter3\n"), 0o600)
+	promptVia = "tty:" + device
+	if _, err := promptPassphrase("Ledger", true); err == nil || !strings.Contains(err.Error(), "do not match") {
+		t.Errorf("mismatched repeat: err = %v", err)
+	}
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake pinentry is a shell script")
+	}
+	script := filepath.Join(dir, "pinentry")
+	os.WriteFile(script, []byte(`#!/bin/sh
+echo "OK Pleased to meet you"
+while read -r command rest; do
+	case "$command" in
+	SETDESC) echo "$rest" >> "$0.log"; echo OK ;;
+	CONFIRM) echo "$FAKE_PINENTRY_CONFIRM" ;;
+	GETPIN) echo "# a comment"; echo "D s3cr%25t"; echo OK ;;
+	BYE) echo OK; exit 0 ;;
+	*) echo OK ;;
+	esac
+done
+`), 0o755)
+	promptVia = "pinentry:" + script
+	for answer, want := range map[string]bool{
+		"OK":                                    true,
+		"ERR 83886194 Not confirmed <Pinentry>": false,
+		"ERR 83886142 Timeout <Pinentry>":       false,
+	} {
+		t.Setenv("FAKE_PINENTRY_CONFIRM", answer)
+		if got := confirmPrompt("\nProceed with payment? [y/N] "); got != want {
+			t.Errorf("pinentry %q: confirmPrompt = %v, want %v", answer, got, want)
+		}
+	}
+	if log, _ := os.ReadFile(script + ".log"); !strings.HasPrefix(string(log), "Proceed with payment?\n") {
+		t.Errorf("pinentry description = %q", log)
+	}
+	if pass, err := promptPassphrase("Ledger", false); err != nil || pass != "s3cr%t" {
+		t.Errorf("pinentry passphrase = %q, %v", pass, err)
+	}
+	if answer, ok := askPrompt("Select option [1-3]: ", "quitting"); !ok || answer != "s3cr%t" {
+		t.Errorf("pinentry askPrompt = %q, %v", answer, ok)
+	}
+}
+
+func TestDevnet(t *testing.T) {
+	code, err := assembleEVM("loop: 0 @loop jumpi ; comment\n0x0100 $c swap2", map[string][]byte{"c": {0xab}})
+	if want := []byte{0x5b, 0x60, 0x00, 0x61, 0x00, 0x00, 0x57, 0x61, 0x01, 0x00, 0x60, 0xab, 0x91}; err != nil || !bytes.Equal(code, want) {
+		t.Fatalf("assembleEVM = %x, %v; want %x", code, err, want)
+	}
+	for _, bad := range []string{"nosuchop", "@missing", "$missing", "dup17"} {
+		if _, err := assembleEVM(bad, nil); err == nil {
+			t.Errorf("assembleEVM(%q) accepted", bad)
+		}
+	}
+
+	init, err := devnetTokenInitCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime := init[14:]
+	if size := int(init[1])<<8 | int(init[2]); size != len(runtime) || init[7] != 0x39 || init[13] != 0xf3 {
+		t.Fatalf("constructor %x returns %d bytes of %d", init[:14], size, len(runtime))
+	}
+	for _, sig := range []string{"balanceOf(address)", "transfer(address,uint256)", "mint(address,uint256)", "decimals()", "name()", "symbol()",
+		"version()", "totalSupply()", "DOMAIN_SEPARATOR()", "authorizationState(address,bytes32)",
+		"transferWithAuthorization(address,address,uint256,uint256,uint256,bytes32,uint8,bytes32,bytes32)"} {
+		if !bytes.Contains(runtime, append([]byte{0x63}, crypto.Keccak256([]byte(sig))[:4]...)) {
+			t.Errorf("the devnet token does not dispatch %s", sig)
+		}
+	}
+	for i := 0; i < len(runtime); i++ {
+		op := runtime[i]
+		if op == 0x61 && i+3 < len(runtime) && runtime[i+3] == 0x57 {
+			if dest := int(runtime[i+1])<<8 | int(runtime[i+2]); dest >= len(runtime) || runtime[dest] != 0x5b {
+				t.Errorf("jump at %d to %d, not a JUMPDEST", i+3, dest)
+			}
+		}
+		if op >= 0x60 && op <= 0x7f {
+			i += int(op - 0x5f)
+		}
+	}
+	to := common.HexToAddress("0x70997970C51812dc3A010C7d01b50e0d17dc79C8")
+	if got := hex.EncodeToString(mintCalldata(to, big.NewInt(1_000_000))); got != "40c10f19"+
+		"00000000000000000000000070997970c51812dc3a010c7d01b50e0d17dc79c8"+
+		"00000000000000000000000000000000000000000000000000000000000f4240" {
+		t.Errorf("mintCalldata = %s", got)
+	}
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	if err := registerDevnet(); err != nil {
+		t.Fatalf("registerDevnet without a devnet: %v", err)
+	}
+	token := "0x5FbDB2315678afecb367f032d93F642f64180aa3"
+	if err := saveDevnet(&devnet{Name: "devnet-test", RPCURL: "http://127.0.0.1:1", ChainID: "eip155:31337", Token: token}); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(networks, "devnet-test")
+	defer delete(x402evm.NetworkConfigs, "eip155:31337")
+	if err := registerDevnet(); err != nil {
+		t.Fatal(err)
+	}
+	info, ok := lookupNetwork("eip155:31337")
+	if !ok || !isTestnet("devnet-test") || info.USDCContract != token {
+		t.Fatalf("devnet not registered: %+v", info)
+	}
+	required, err := echoRequirements(info, "1000", to.Hex())
+	if err != nil || required.Accepts[0].Extra["name"] != "USDC" || required.Accepts[0].Extra["version"] != "2" {
+		t.Errorf("echoRequirements on the devnet = %+v, %v", required.Accepts, err)
+	}
+	if got := assetSymbol(x402.PaymentRequirements{Network: "eip155:31337", Asset: token}); got != "USDC" {
+		t.Errorf("assetSymbol = %s", got)
+	}
+}
+
+func TestParseEndpointsYAML(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    []dashboardEndpoint
+		wantErr string
+	}{
+		{
+			name: "url items",
+			src:  "- https://a.example/x\n- 'https://b.example:8443/y'\n",
+			want: []dashboardEndpoint{{URL: "https://a.example/x"}, {URL: "https://b.example:8443/y"}},
+		},
+		{
+			name: "maps under endpoints",
+			src: `# watched endpoints
+endpoints:
+  - name: "weather" # the cheap one
+    url: https://a.example/weather#today
+    method: post
+
+  - url: https://b.example/quote
+`,
+			want: []dashboardEndpoint{
+				{Name: "weather", URL: "https://a.example/weather#today", Method: "post"},
+				{URL: "https://b.example/quote"},
+			},
+		},
+		{
+			name: "key on the item line only",
+			src:  "-\n  url: https://a.example/x\n",
+			want: []dashboardEndpoint{{URL: "https://a.example/x"}},
+		},
+		{name: "empty", src: "endpoints:\n# none yet\n"},
+		{name: "key before any item", src: "url: https://a.example/x\n", wantErr: "line 1: expected a list item"},
+		{name: "not key: value", src: "- url: https://a.example/x\n  weather\n", wantErr: "line 2: expected key: value"},
+		{name: "unknown key", src: "- url: https://a.example/x\n  price: 0.01\n", wantErr: `line 2: unknown key "price"`},
+	}
+	for _, tt := range tests {
+		got, err := parseEndpointsYAML(tt.src)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !slices.EqualFunc(got, tt.want, func(a, b dashboardEndpoint) bool {
+			return a.Name == b.Name && a.URL == b.URL && a.Method == b.Method
+		}) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	dir := t.TempDir()
+	load := func(src string) ([]dashboardEndpoint, error) {
+		path := filepath.Join(dir, "endpoints.yaml")
+		if err := os.WriteFile(path, []byte(src), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return loadDashboardEndpoints(path)
+	}
+	if eps, err := load("- url: https://a.example/x\n  method: post\n"); err != nil || eps[0].Method != "POST" || eps[0].Name != "https://a.example/x" {
+		t.Errorf("loadDashboardEndpoints defaults = %+v, %v", eps, err)
+	}
+	if eps, err := load(`[{"name":"a","url":"https://a.example/x"}]`); err != nil || len(eps) != 1 || eps[0].Method != "GET" {
+		t.Errorf("loadDashboardEndpoints JSON = %+v, %v", eps, err)
+	}
+	for _, src := range []string{"endpoints:\n", "- ftp://a.example/x\n", "- url:\n", "[{\"url\": ", "- name: a\n  nme: b\n"} {
+		if _, err := load(src); err == nil {
+			t.Errorf("loadDashboardEndpoints(%q) succeeded", src)
+		}
+	}
+}
+
+func TestWalletAllowances(t *testing.T) {
+	owner := common.HexToAddress("0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266")
+	spenderA := common.HexToAddress("0x70997970C51812dc3A010C7d01b50e0d17dc79C8")
+	spenderB := common.HexToAddress("0x3C44CdDdB6a900fa2b585dd299e03d12FA4293BC")
+	topic := func(a common.Address) string { return common.BytesToHash(a.Bytes()).Hex() }
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		reply := func(result string) { w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":` + result + `}`)) }
+		switch req.Method {
+		case "eth_blockNumber":
+			reply(`"0x61a8"`) // 25000
+		case "eth_getLogs":
+			var filter struct {
+				Address   string   `json:"address"`
+				FromBlock string   `json:"fromBlock"`
+				ToBlock   string   `json:"toBlock"`
+				Topics    []string `json:"topics"`
+			}
+			json.Unmarshal(req.Params[0], &filter)
+			if filter.Address != networks["base-sepolia"].USDCContract || len(filter.Topics) != 2 || filter.Topics[0] != approvalTopic || filter.Topics[1] != topic(owner) {
+				t.Errorf("eth_getLogs filter = %+v", filter)
+			}
+			ranges = append(ranges, filter.FromBlock+"-"+filter.ToBlock)
+			// B is approved twice, and a log without a spender topic is skipped.
+			logs := map[string]string{
+				"0x0":    `[{"topics":["` + approvalTopic + `","` + topic(owner) + `","` + topic(spenderB) + `"]}]`,
+				"0x2710": `[{"topics":["` + approvalTopic + `","` + topic(owner) + `"]},{"topics":["` + approvalTopic + `","` + topic(owner) + `","` + topic(spenderA) + `"]}]`,
+				"0x4e20": `[{"topics":["` + approvalTopic + `","` + topic(owner) + `","` + topic(spenderB) + `"]}]`,
+			}[filter.FromBlock]
+			if logs == "" {
+				logs = `[]`
+			}
+			reply(logs)
+		case "eth_call":
+			var call struct{ To, Data string }
+			json.Unmarshal(req.Params[0], &call)
+			want := allowanceSelector + hex.EncodeToString(common.LeftPadBytes(owner.Bytes(), 32))
+			if !strings.HasPrefix(strings.ToLower(call.Data), want) {
+				t.Errorf("allowance call data = %s", call.Data)
+			}
+			allowance := "0x" + strings.Repeat("0", 64)
+			if strings.HasSuffix(strings.ToLower(call.Data), strings.ToLower(spenderA.Hex()[2:])) {
+				allowance = "0x" + strings.Repeat("0", 58) + "0f4240" // 1 USDC
+			}
+			reply(`"` + allowance + `"`)
+		}
+	}))
+	defer srv.Close()
+	info := networks["base-sepolia"]
+	info.RPCURL = srv.URL
+
+	spenders, err := approvalSpenders(info, owner, 25000)
+	if err != nil || !slices.Equal(spenders, []common.Address{spenderB, spenderA}) {
+		t.Errorf("approvalSpenders = %v, %v; want B then A, once each", spenders, err)
+	}
+	if want := []string{"0x0-0x270f", "0x2710-0x4e1f", "0x4e20-0x61a8"}; !slices.Equal(ranges, want) {
+		t.Errorf("eth_getLogs ranges = %v, want %v", ranges, want)
+	}
+	ranges = nil
+	if _, err := approvalSpenders(info, owner, 100); err != nil || !slices.Equal(ranges, []string{"0x6144-0x61a8"}) {
+		t.Errorf("a 100-block scan queried %v (%v)", ranges, err)
+	}
+	if raw, err := queryAllowance(info, owner, spenderA); err != nil || raw != "1000000" {
+		t.Errorf("queryAllowance(A) = %s, %v", raw, err)
+	}
+	if raw, err := queryAllowance(info, owner, spenderB); err != nil || raw != "0" {
+		t.Errorf("queryAllowance(B) = %s, %v", raw, err)
+	}
+
+	// Revoking is approve(spender, 0).
+	want := "095ea7b3" + "00000000000000000000000070997970c51812dc3a010c7d01b50e0d17dc79c8" + strings.Repeat("0", 64)
+	if got := hex.EncodeToString(approveCalldata(spenderA, "0")); got != want {
+		t.Errorf("revoke calldata = %s, want %s", got, want)
+	}
+	if got := hex.EncodeToString(approveCalldata(spenderA, "1000000")); got != want[:len(want)-6]+"0f4240" {
+		t.Errorf("approve calldata = %s", got)
+	}
+}
+
+func TestPermitVector(t *testing.T) {
+	// A permit of 1 USDC on Base Sepolia from the first Anvil account to the second, nonce 0,
+	// deadline 1700000000, with hashes computed independently from its EIP-712 encoding.
+	const (
+		key             = "0xac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80"
+		owner           = "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"
+		spender         = "0x70997970C51812dc3A010C7d01b50e0d17dc79C8"
+		usdc            = "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+		domainSeparator = "0x71f17a3b2ff373b803d70a5a07c046c1a2bc8e89c09ef722fcb047abe94c9818"
+		messageHash     = "0xc9612b1f92c543b5ddd2f9063f52b6a30939780587748aebeef985ca52cbe26b"
+		digest          = "0xcd80d8f3f70cca2730065c11d51e7f6efe1bb21c70148c47727bb950dc4dfbc6"
+	)
+	out, _, err := hashTypedData([]byte(`{
+		"types": {
+			"EIP712Domain": [
+				{"name": "name", "type": "string"},
+				{"name": "version", "type": "string"},
+				{"name": "chainId", "type": "uint256"},
+				{"name": "verifyingContract", "type": "address"}
+			],
+			"Permit": [
+				{"name": "owner", "type": "address"},
+				{"name": "spender", "type": "address"},
+				{"name": "value", "type": "uint256"},
+				{"name": "nonce", "type": "uint256"},
+				{"name": "deadline", "type": "uint256"}
+			]
+		},
+		"primaryType": "Permit",
+		"domain": {"name": "USDC", "version": "2", "chainId": 84532, "verifyingContract": "` + usdc + `"},
+		"message": {"owner": "` + owner + `", "spender": "` + spender + `", "value": "1000000", "nonce": "0", "deadline": "1700000000"}
+	}`))
+	if err != nil || out.DomainSeparator != domainSeparator || out.MessageHash != messageHash || out.Digest != digest {
+		t.Fatalf("hashTypedData = %+v, %v", out, err)
+	}
+
+	// wallet permit signs the same digest.
+	signer, _ := evmsigners.NewClientSignerFromPrivateKey(key)
+	value, deadline := big.NewInt(1_000_000), big.NewInt(1_700_000_000)
+	sig, err := signer.SignTypedData(context.Background(),
+		x402evm.TypedDataDomain{Name: "USDC", Version: "2", ChainID: chainIDOf(networks["base-sepolia"]), VerifyingContract: usdc},
+		map[string][]x402evm.TypedDataField{"Permit": {
+			{Name: "owner", Type: "address"},
+			{Name: "spender", Type: "address"},
+			{Name: "value", Type: "uint256"},
+			{Name: "nonce", Type: "uint256"},
+			{Name: "deadline", Type: "uint256"},
+		}},
+		"Permit",
+		map[string]interface{}{"owner": owner, "spender": spender, "value": value, "nonce": new(big.Int), "deadline": deadline},
+	)
+	if err != nil || len(sig) != 65 {
+		t.Fatalf("SignTypedData = %x, %v", sig, err)
+	}
+	pub, err := crypto.SigToPub(common.FromHex(digest), append(append([]byte{}, sig[:64]...), sig[64]-27))
+	if err != nil || crypto.PubkeyToAddress(*pub).Hex() != owner {
+		t.Fatalf("the permit signature does not recover %s from the vector digest (%v)", owner, err)
+	}
+
+	want := "d505accf" +
+		"000000000000000000000000f39fd6e51aad88f6f4ce6ab8827279cfffb92266" +
+		"00000000000000000000000070997970c51812dc3a010c7d01b50e0d17dc79c8" +
+		"00000000000000000000000000000000000000000000000000000000000f4240" +
+		"000000000000000000000000000000000000000000000000000000006553f100" +
+		hex.EncodeToString(common.LeftPadBytes(sig[64:], 32)) +
+		hex.EncodeToString(sig[:64])
+	if got := hex.EncodeToString(permitCalldata(common.HexToAddress(owner), common.HexToAddress(spender), value, deadline, sig)); got != want {
+		t.Errorf("permitCalldata =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWalletNonce(t *testing.T) {
+	const (
+		usdc  = "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+		payer = "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"
+		used  = "0x0000000000000000000000000000000000000000000000000000000000000001"
+		fresh = "0x00000000000000000000000000000000000000000000000000000000000000ff"
+	)
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		var call struct{ To, Data string }
+		json.Unmarshal(req.Params[0], &call)
+		calls = append(calls, call.To+" "+call.Data)
+		result := "0x" + strings.Repeat("0", 64)
+		switch {
+		case strings.HasSuffix(call.Data, used[2:]):
+			result = used
+		case strings.HasSuffix(call.Data, "ee"):
+			w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"message":"execution reverted"}}`))
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"` + result + `"}`))
+	}))
+	defer srv.Close()
+
+	for nonce, want := range map[string]bool{used: true, fresh: false} {
+		got, err := authorizationUsed(srv.URL, usdc, payer, common.FromHex(nonce))
+		if err != nil || got != want {
+			t.Errorf("authorizationUsed(%s) = %v, %v; want %v", nonce, got, err, want)
+		}
+	}
+	wantCall := usdc + " " + authorizationStateSelector + "000000000000000000000000f39fd6e51aad88f6f4ce6ab8827279cfffb92266" + used[2:]
+	if !slices.ContainsFunc(calls, func(c string) bool { return strings.EqualFold(c, wantCall) }) {
+		t.Errorf("eth_call = %q, want %q among them", calls, wantCall)
+	}
+	if _, err := authorizationUsed(srv.URL, usdc, payer, common.FromHex("0x"+strings.Repeat("e", 64))); err == nil {
+		t.Error("authorizationUsed ignored an RPC error")
+	}
+
+	// The authorization comes from a v2 payment's accepted option, or a v1 payment's network.
+	v2 := base64.StdEncoding.EncodeToString([]byte(`{"x402Version":2,"accepted":{"network":"eip155:84532","asset":"` + usdc + `"},` +
+		`"payload":{"authorization":{"from":"` + payer + `","nonce":"` + used + `"}}}`))
+	if auth, err := decodePaymentAuthorization(v2); err != nil || auth.Network != "eip155:84532" || auth.Asset != usdc || auth.From != payer || auth.Nonce != used {
+		t.Errorf("v2 authorization = %+v, %v", auth, err)
+	}
+	v1 := base64.StdEncoding.EncodeToString([]byte(`{"x402Version":1,"network":"base-sepolia","payload":{"authorization":{"from":"` + payer + `","nonce":"` + used + `"}}}`))
+	if auth, err := decodePaymentAuthorization(v1); err != nil || auth.Network != "base-sepolia" || !strings.EqualFold(auth.Asset, usdc) {
+		t.Errorf("v1 authorization = %+v, %v", auth, err)
+	}
+	for _, bad := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte(`{"accepted":{"network":"eip155:84532"}}`))} {
+		if _, err := decodePaymentAuthorization(bad); err == nil {
+			t.Errorf("decodePaymentAuthorization(%q) accepted", bad)
+		}
+	}
+
+	var out bytes.Buffer
+	writeNonceResult(&out, &nonceResult{ChainID: "eip155:84532", Token: usdc, Authorizer: payer, Nonce: used, Used: true})
+	if got := out.String(); !strings.Contains(got, "Authorizer: "+payer) || !strings.Contains(got, "USED on-chain") {
+		t.Errorf("used nonce output:\n%s", got)
+	}
+	out.Reset()
+	writeNonceResult(&out, &nonceResult{ChainID: "eip155:84532", Token: usdc, Authorizer: payer, Nonce: fresh})
+	if got := out.String(); !strings.Contains(got, "Nonce:      "+fresh) || !strings.Contains(got, "Nonce is unused") {
+		t.Errorf("unused nonce output:\n%s", got)
+	}
+	raw, _ := json.Marshal(nonceResult{Network: "base-sepolia", ChainID: "eip155:84532", Token: usdc, Authorizer: payer, Nonce: used, Used: true})
+	if want := `{"network":"base-sepolia","chainId":"eip155:84532","token":"` + usdc + `","authorizer":"` + payer + `","nonce":"` + used + `","used":true}`; string(raw) != want {
+		t.Errorf("JSON output = %s", raw)
+	}
+}
+
+func TestCompareFacilitators(t *testing.T) {
+	facilitator := func(status int, body string) string {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				PaymentPayload      json.RawMessage `json:"paymentPayload"`
+				PaymentRequirements json.RawMessage `json:"paymentRequirements"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if r.URL.Path != "/verify" || string(req.PaymentRequirements) != `{"scheme":"exact"}` {
+				t.Errorf("verify request %s with requirements %s", r.URL.Path, req.PaymentRequirements)
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL
+	}
+	accept := facilitator(200, `{"isValid":true,"payer":"0xabc"}`)
+	accept2 := facilitator(200, `{"isValid":true,"payer":"0xabc"}`)
+	reject := facilitator(400, `{"isValid":false,"invalidReason":"invalid_signature","invalidMessage":"bad v"}`)
+	down := facilitator(500, `oops`)
+
+	payload := []byte(`{"x402Version":2,"payload":{}}`)
+	selected := x402.PaymentRequirements{Scheme: "exact", Network: "eip155:84532", Amount: "1000"}
+	compare := func(urls ...string) *facilitatorComparison {
+		return compareFacilitators(context.Background(), urls, 5*time.Second, payload, []byte(`{"scheme":"exact"}`), selected)
+	}
+
+	if c := compare(accept, accept2); !c.Agree || !c.Verdicts[0].Valid || c.Verdicts[1].Payer != "0xabc" {
+		t.Errorf("two accepting facilitators: %+v", c)
+	}
+	if c := compare(reject, reject); !c.Agree || c.Verdicts[0].Valid {
+		t.Errorf("two rejecting facilitators: %+v", c)
+	}
+
+	c := compare(reject, accept, down)
+	if c.Agree {
+		t.Error("a reject, an accept, and an error agree")
+	}
+	if len(c.Verdicts) != 3 || c.Verdicts[0].Facilitator != reject || c.Verdicts[1].Facilitator != accept || c.Verdicts[2].Facilitator != down {
+		t.Fatalf("verdicts are not in the order asked: %+v", c.Verdicts)
+	}
+	if v := c.Verdicts[0]; v.Valid || v.InvalidReason != "invalid_signature: bad v" || v.Amount != "1000" {
+		t.Errorf("rejecting verdict = %+v", v)
+	}
+	if v := c.Verdicts[2]; v.Error == "" || v.Valid {
+		t.Errorf("unreachable facilitator verdict = %+v", v)
+	}
+	if c := compare(accept, down); c.Agree {
+		t.Error("an accept and an error agree")
+	}
+
+	var out bytes.Buffer
+	writeComparison(&out, c)
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "FACILITATOR") || !strings.Contains(lines[0], "VERDICT") {
+		t.Fatalf("comparison table:\n%s", out.String())
+	}
+	for i, want := range [][]string{
+		{reject, "reject", "invalid_signature: bad v"},
+		{accept, "accept"},
+		{down, "error", "facilitator verify failed"},
+	} {
+		if fields := strings.Fields(lines[i+1]); len(fields) < len(want) || !slices.Equal(fields[:2], want[:2]) || !strings.Contains(lines[i+1], want[len(want)-1]) {
+			t.Errorf("row %d = %q, want %q", i+1, lines[i+1], want)
+		}
+	}
+	if col := strings.Index(lines[0], "VERDICT"); col < 0 || strings.Index(lines[1], "reject") != col {
+		t.Errorf("columns are not aligned:\n%s", out.String())
+	}
+}
+
+func TestSignPaymentSelected(t *testing.T) {
+	signer, err := evmsigners.NewClientSignerFromPrivateKey(vectorFixtureKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	option := func(network string) x402.PaymentRequirements {
+		info := networks[network]
+		return x402.PaymentRequirements{
+			Scheme:            "exact",
+			Network:           info.ChainID,
+			Asset:             info.USDCContract,
+			Amount:            "1000",
+			PayTo:             "0x1111111111111111111111111111111111111111",
+			MaxTimeoutSeconds: 60,
+			Extra:             map[string]interface{}{"name": "USDC", "version": "2"},
+		}
+	}
+	required := &x402.PaymentRequired{X402Version: 2, Accepts: []x402.PaymentRequirements{option("base"), option("base-sepolia")}}
+
+	// The chosen option is signed, not the first one the SDK supports.
+	payload, requirements, err := signPayment(context.Background(), signer, required, required.Accepts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signed x402.PaymentPayload
+	var selected x402.PaymentRequirements
+	if err := json.Unmarshal(payload, &signed); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(requirements, &selected); err != nil {
+		t.Fatal(err)
+	}
+	if signed.Accepted.Network != networks["base-sepolia"].ChainID || selected.Network != networks["base-sepolia"].ChainID {
+		t.Errorf("signed for %s with requirements for %s, want base-sepolia", signed.Accepted.Network, selected.Network)
+	}
+
+	if _, _, err := signPayment(context.Background(), signer, required, x402.PaymentRequirements{}); err == nil {
+		t.Error("signed without a selected option")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// mainnetAllowedByEnv reports whether X402_ALLOW_MAINNET permits real spends without --mainnet.
+func mainnetAllowedByEnv() bool {
+	on, _ := strconv.ParseBool(os.Getenv("X402_ALLOW_MAINNET"))
+	return on
+}
+
+// isTestnet reports whether network (a CAIP-2 ID, or a v1 name such as base-sepolia) is
+// a known testnet. Unknown chains count as mainnets, so the guard never lets an
+// unrecognized network through.
+func isTestnet(network string) bool {
+	info, ok := lookupNetwork(network)
+	return ok && info.Testnet
+}
+
+// testnetAccepts returns the options on testnets, in the server's order.
+func testnetAccepts(accepts []x402.PaymentRequirements) []x402.PaymentRequirements {
+	var out []x402.PaymentRequirements
+	for _, a := range accepts {
+		if isTestnet(a.Network) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// mainnetRefusal is the error for a payment the mainnet guard stops.
+const mainnetRefusal = "payment would be on mainnet: pass --mainnet (or set X402_ALLOW_MAINNET=1) to spend real funds"
+
+// mainnetGuard wraps rt so that, unless allowed, no payment signed for a mainnet ever
+// leaves the process, whichever option the x402 client ended up selecting.
+func mainnetGuard(rt http.RoundTripper, allowed bool) http.RoundTripper {
+	if allowed {
+		return rt
+	}
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		for _, h := range []string{"PAYMENT-SIGNATURE", "X-PAYMENT"} {
+			header := req.Header.Get(h)
+			if header == "" {
+				continue
+			}
+			// v2 payloads carry the accepted option; v1 payloads name the network at the top level.
+			var payload struct {
+				Network  string `json:"network"`
+				Accepted struct {
+					Network string `json:"network"`
+				} `json:"accepted"`
+			}
+			raw, err := base64.StdEncoding.DecodeString(header)
+			if err != nil || json.Unmarshal(raw, &payload) != nil {
+				return nil, errors.New(mainnetRefusal)
+			}
+			network := payload.Accepted.Network
+			if network == "" {
+				network = payload.Network
+			}
+			if !isTestnet(network) {
+				return nil, errors.New(mainnetRefusal)
+			}
+		}
+		return rt.RoundTrip(req)
+	})
+}
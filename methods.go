@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// methodPrice is what one HTTP method of an endpoint costs.
+type methodPrice struct {
+	Method string `json:"method"`
+	// Status is "paid", "free", "blocked" (answered with an error status other than 402, e.g.
+	// 405 Method Not Allowed), or "error" (no answer, or malformed payment requirements).
+	Status     string       `json:"status"`
+	StatusCode int          `json:"statusCode,omitempty"`
+	Prices     []rateOption `json:"prices,omitempty"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// methodsResult is the JSON output of `x402-cli methods`.
+type methodsResult struct {
+	Endpoint string        `json:"endpoint"`
+	Methods  []methodPrice `json:"methods"`
+	// Differ is set when the paid methods do not all cost the same.
+	Differ bool `json:"differ"`
+}
+
+// runMethodsCmd probes an endpoint with each HTTP method and compares what they cost.
+func runMethodsCmd(args []string) {
+	fs := flag.NewFlagSet("methods", flag.ExitOnError)
+	var (
+		methods  string
+		headers  headerFlags
+		jsonOut  bool
+		insecure bool
+		timeout  time.Duration
+	)
+	fs.StringVar(&methods, "methods", "GET,POST,PUT,DELETE", "Comma-separated HTTP methods to probe")
+	fs.Var(&headers, "H", "Custom header 'Key: Value' sent with every probe (repeatable)")
+	fs.BoolVar(&jsonOut, "json", false, "Output JSON")
+	fs.BoolVar(&insecure, "k", false, "Skip TLS certificate verification")
+	fs.DurationVar(&timeout, "timeout", 10*time.Second, "Per-probe timeout")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli methods [--methods GET,POST,PUT,DELETE] [--json] <url>\n\n")
+		fmt.Fprintf(os.Stderr, "Sends the URL once per HTTP method, without a body or a payment, and reports which\n")
+		fmt.Fprintf(os.Stderr, "methods are free, paid (with their prices), or blocked, since providers often price\n")
+		fmt.Fprintf(os.Stderr, "methods differently. A free route acts on the request: probe PUT and DELETE only on\n")
+		fmt.Fprintf(os.Stderr, "endpoints where that is harmless, or narrow --methods.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	endpoint := fs.Arg(0)
+	if endpoint == "" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		fail(fmt.Errorf("%q is not an http(s) URL", endpoint))
+	}
+	var list []string
+	for _, m := range strings.Split(methods, ",") {
+		if m = strings.ToUpper(strings.TrimSpace(m)); m != "" {
+			list = append(list, m)
+		}
+	}
+	if len(list) == 0 {
+		fail(fmt.Errorf("--methods names no method"))
+	}
+
+	transport := &http.Transport{}
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	client := &http.Client{Transport: transport, Timeout: timeout}
+
+	out := methodsResult{Endpoint: endpoint, Methods: make([]methodPrice, len(list))}
+	var wg sync.WaitGroup
+	for i, m := range list {
+		wg.Add(1)
+		go func(i int, m string) {
+			defer wg.Done()
+			out.Methods[i] = probeMethod(client, endpoint, m, headers)
+		}(i, m)
+	}
+	wg.Wait()
+	out.Differ = pricesDiffer(out.Methods)
+
+	failed := 0
+	for _, m := range out.Methods {
+		if m.Status == "error" && m.StatusCode == 0 {
+			failed++
+		}
+	}
+	code := ExitSuccess
+	if failed == len(out.Methods) {
+		code = ExitError
+	}
+
+	if jsonOut {
+		printJSON(out)
+		os.Exit(code)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tSTATUS\tCODE\tPRICE\tNETWORK")
+	for _, m := range out.Methods {
+		price, network, status := "-", "-", "-"
+		if m.StatusCode != 0 {
+			status = fmt.Sprint(m.StatusCode)
+		}
+		if len(m.Prices) > 0 {
+			var prices, networks []string
+			for _, p := range m.Prices {
+				prices, networks = append(prices, p.Price), append(networks, p.Network)
+			}
+			price, network = strings.Join(prices, " | "), strings.Join(networks, " | ")
+		} else if m.Error != "" && m.Status == "error" {
+			price = m.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", m.Method, m.Status, status, price, network)
+	}
+	w.Flush()
+	if out.Differ {
+		fmt.Println("\nThe paid methods are priced differently.")
+	}
+	os.Exit(code)
+}
+
+// probeMethod sends method to endpoint without a body or payment and records what it charges.
+func probeMethod(client *http.Client, endpoint, method string, headers headerFlags) methodPrice {
+	m := methodPrice{Method: method}
+	req, err := newRequest(method, endpoint, "", headers)
+	if err != nil {
+		m.Status, m.Error = "error", err.Error()
+		return m
+	}
+	e := priceRequest(client, req)
+	m.Status, m.StatusCode, m.Prices, m.Error = e.Status, e.StatusCode, e.Prices, e.Error
+	if m.Status == "error" && m.StatusCode >= 400 && m.StatusCode != http.StatusPaymentRequired {
+		m.Status = "blocked"
+	}
+	return m
+}
+
+// pricesDiffer reports whether the paid methods are not all offered at the same prices.
+func pricesDiffer(methods []methodPrice) bool {
+	var first []rateOption
+	seen := false
+	for _, m := range methods {
+		if m.Status != "paid" {
+			continue
+		}
+		if !seen {
+			first, seen = m.Prices, true
+			continue
+		}
+		if len(m.Prices) != len(first) {
+			return true
+		}
+		for i := range first {
+			if m.Prices[i].Amount != first[i].Amount || m.Prices[i].Asset != first[i].Asset || m.Prices[i].Network != first[i].Network {
+				return true
+			}
+		}
+	}
+	return false
+}
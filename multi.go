@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	x402evm "github.com/coinbase/x402/go/mechanisms/evm"
+)
+
+// multiConcurrency is how many of several URL arguments are requested at once.
+const multiConcurrency = 8
+
+// multiFlags are the pay command's flags that apply when several URLs are given.
+var multiFlags = []string{
+	"k", "insecure", "timeout", "X", "method", "d", "data", "data-urlencode", "validate-json", "param",
+	"H", "header", "accept", "accept-language", "dry-run", "json", "y", "yes", "q", "quiet",
+	"trace-id", "trust-redirects", "only-hosts", "host-budget", "max-total-spend", "mainnet",
+	"treat-free-as-success", "fail-on-free",
+}
+
+// multiResult is the JSON output for several URL arguments.
+type multiResult struct {
+	RequestID string        `json:"requestId"`
+	Results   []batchResult `json:"results"` // in argument order
+	Summary   batchSummary  `json:"summary"`
+}
+
+// unsupportedMultiFlags lists the flags set on the command line that several URLs cannot honor.
+func unsupportedMultiFlags(fs *flag.FlagSet) []string {
+	var out []string
+	fs.Visit(func(f *flag.Flag) {
+		if !slices.Contains(multiFlags, f.Name) {
+			out = append(out, "--"+f.Name)
+		}
+	})
+	sort.Strings(out)
+	return out
+}
+
+// payURLs probes, and unless opts.dryRun pays, every URL concurrently. Payments are made one
+// at a time while payMu is set, so budgets are checked against an up-to-date ledger.
+func payURLs(urls []string, method, data string, headers headerFlags, transport http.RoundTripper, signer x402evm.ClientEvmSigner, opts batchOptions) []batchResult {
+	results := make([]batchResult, len(urls))
+	sem := make(chan struct{}, multiConcurrency)
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			start := time.Now()
+			ep := dashboardEndpoint{Name: u, URL: u, Method: method, body: data, headers: headers}
+			results[i] = payEndpoint(transport, signer, ep, opts)
+			results[i].LatencyMs = time.Since(start).Milliseconds()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// runMultiURL handles `x402-cli [flags] <url> <url>...` and exits.
+func runMultiURL(urls []string, method, data string, headers headerFlags, insecure bool, onlyHosts string, opts batchOptions, jsonOutput, quiet bool) {
+	fail := func(msg string) {
+		if jsonOutput {
+			exitJSON(&jsonResult{Version: version, Status: "error", Error: msg}, ExitError)
+		}
+		fmt.Fprintf(os.Stderr, "Error: %s\n", msg)
+		os.Exit(ExitError)
+	}
+	if bad := unsupportedMultiFlags(flag.CommandLine); len(bad) > 0 {
+		fail(fmt.Sprintf("%s cannot be used with several URLs (use batch or one URL per run)", strings.Join(bad, ", ")))
+	}
+	for _, u := range urls {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			fail(fmt.Sprintf("not an http(s) URL: %q", u))
+		}
+	}
+
+	var signer x402evm.ClientEvmSigner
+	if key := privateKeyFromEnv(); key != "" && !opts.dryRun {
+		var err error
+		if signer, err = signerFor(key); err != nil {
+			fail("failed to create signer: " + err.Error())
+		}
+		if d, err := findDelegation(signer.Address()); err != nil || d != nil || len(opts.budgets) > 0 {
+			opts.payMu = &sync.Mutex{}
+		}
+	}
+	transport := &http.Transport{}
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	started := time.Now()
+	results := payURLs(urls, method, data, headers,
+		mainnetGuard(parseHostAllowlist(onlyHosts).transport(noSpendTransport(transport)), opts.mainnet), signer, opts)
+	code := batchExitCode(results)
+	if jsonOutput {
+		printJSON(multiResult{RequestID: requestID, Results: results, Summary: newBatchSummary(results, time.Since(started))})
+		os.Exit(code)
+	}
+	if !quiet {
+		renderBatch(results)
+		printCategories(results)
+		if opts.dryRun {
+			printEstimate(results)
+		}
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// noSpend is set by the global --no-spend flag or X402_NO_SPEND: every command runs as
+// usual, but signed payments never leave the process and are answered as accepted.
+var noSpend bool
+
+// noSpendHeader marks a paid response that --no-spend made up.
+const noSpendHeader = "X-X402-No-Spend"
+
+// noSpendByEnv reports whether X402_NO_SPEND turns on --no-spend.
+func noSpendByEnv() bool {
+	on, _ := strconv.ParseBool(os.Getenv("X402_NO_SPEND"))
+	return on
+}
+
+// noSpendTransport wraps rt so that, under --no-spend, a request carrying a payment is
+// answered locally with a 200 and a successful settlement instead of being sent. Requests
+// without a payment, such as the unpaid probe, still reach the server.
+func noSpendTransport(rt http.RoundTripper) http.RoundTripper {
+	if !noSpend {
+		return rt
+	}
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		header := req.Header.Get("PAYMENT-SIGNATURE")
+		if header == "" {
+			header = req.Header.Get("X-PAYMENT")
+		}
+		if header == "" {
+			return rt.RoundTrip(req)
+		}
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		settle := x402.SettleResponse{Success: true}
+		var payload x402.PaymentPayload
+		if raw, err := base64.StdEncoding.DecodeString(header); err == nil && json.Unmarshal(raw, &payload) == nil {
+			settle.Network = x402.Network(payload.Accepted.Network)
+			if auth, ok := payload.Payload["authorization"].(map[string]interface{}); ok {
+				settle.Payer, _ = auth["from"].(string)
+			}
+		}
+		raw, _ := json.Marshal(settle)
+		body := "{}"
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: http.StatusOK,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header: http.Header{
+				"Content-Type":     {"application/json"},
+				"Payment-Response": {base64.StdEncoding.EncodeToString(raw)},
+				noSpendHeader:      {"1"},
+			},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cachedRequirements is one endpoint's 402 response in requirements.json, kept for
+// --optimistic so a later run can pay without probing first.
+type cachedRequirements struct {
+	// Header is the PAYMENT-REQUIRED header, empty for a server that sends requirements in the body.
+	Header      string    `json:"header,omitempty"`
+	ContentType string    `json:"contentType,omitempty"`
+	Body        string    `json:"body,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// optimisticMethod reports whether --optimistic applies to method: only requests that are
+// safe to send with a payment the server may refuse, GET and HEAD.
+func optimisticMethod(method string) bool {
+	method = strings.ToUpper(method)
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// requirementsCacheKey identifies an endpoint's requirements: method and URL.
+func requirementsCacheKey(method, endpoint string) string {
+	return strings.ToUpper(method) + " " + endpoint
+}
+
+// lookupRequirements returns the requirements cached for method and endpoint within maxAge,
+// or nil.
+func lookupRequirements(method, endpoint string, maxAge time.Duration) *cachedRequirements {
+	if !optimisticMethod(method) {
+		return nil
+	}
+	return cachedRequirementsFor(method, endpoint, maxAge)
+}
+
+// cachedRequirementsFor returns the requirements cached for method and endpoint within maxAge,
+// or nil, whatever the method.
+func cachedRequirementsFor(method, endpoint string, maxAge time.Duration) *cachedRequirements {
+	if maxAge <= 0 {
+		return nil
+	}
+	path, err := stateFile("requirements.json")
+	if err != nil {
+		return nil
+	}
+	unlock, err := lockState("requirements.json")
+	if err != nil {
+		return nil
+	}
+	defer unlock()
+	entry, ok := loadRequirementsCache(path)[requirementsCacheKey(method, endpoint)]
+	if !ok || time.Since(entry.Time) > maxAge {
+		return nil
+	}
+	return &entry
+}
+
+func loadRequirementsCache(path string) map[string]cachedRequirements {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	if data, err = openLine(data); err != nil {
+		return nil
+	}
+	var cache map[string]cachedRequirements
+	json.Unmarshal(data, &cache)
+	return cache
+}
+
+// rememberRequirements caches a 402 response for method and endpoint and drops entries
+// older than maxAge. The cache is best-effort: a failure only costs the next run a probe.
+func rememberRequirements(method, endpoint string, resp *http.Response, body []byte, maxAge time.Duration) {
+	path, err := stateFile("requirements.json")
+	if err != nil {
+		return
+	}
+	unlock, err := lockState("requirements.json")
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	cache := loadRequirementsCache(path)
+	if cache == nil {
+		cache = map[string]cachedRequirements{}
+	}
+	for k, v := range cache {
+		if time.Since(v.Time) > maxAge {
+			delete(cache, k)
+		}
+	}
+	cache[requirementsCacheKey(method, endpoint)] = cachedRequirements{
+		Header:      resp.Header.Get("PAYMENT-REQUIRED"),
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        string(body),
+		Time:        time.Now(),
+	}
+	data, _ := json.Marshal(cache)
+	if stateEncryption() {
+		if data, err = sealLine(data); err != nil {
+			return
+		}
+	}
+	tmp := path + ".tmp"
+	if os.MkdirAll(filepath.Dir(path), 0700) != nil || os.WriteFile(tmp, data, 0600) != nil {
+		return
+	}
+	os.Rename(tmp, path)
+}
+
+// response rebuilds the cached 402 as the answer to req.
+func (c *cachedRequirements) response(req *http.Request) *http.Response {
+	header := http.Header{}
+	if c.Header != "" {
+		header.Set("PAYMENT-REQUIRED", c.Header)
+	}
+	if c.ContentType != "" {
+		header.Set("Content-Type", c.ContentType)
+	}
+	return &http.Response{
+		Status:        "402 Payment Required",
+		StatusCode:    http.StatusPaymentRequired,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}
+}
+
+// transport wraps rt so that requests without a payment are answered with the cached 402
+// instead of being sent: the payment client signs against the cached requirements, and only
+// the paid request goes over the network.
+func (c *cachedRequirements) transport(rt http.RoundTripper) http.RoundTripper {
+	if c == nil {
+		return rt
+	}
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("PAYMENT-SIGNATURE") != "" || req.Header.Get("X-PAYMENT") != "" {
+			return rt.RoundTrip(req)
+		}
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return c.response(req), nil
+	})
+}
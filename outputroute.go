@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Actions of --on-type rules.
+const (
+	routePrint   = "print"
+	routeSave    = "save"
+	routeDiscard = "discard"
+)
+
+// commonExtensions picks the usual extension where the system MIME table lists several.
+var commonExtensions = map[string]string{
+	"application/json": ".json",
+	"application/pdf":  ".pdf",
+	"image/jpeg":       ".jpg",
+	"image/png":        ".png",
+	"text/csv":         ".csv",
+	"text/html":        ".html",
+	"text/plain":       ".txt",
+}
+
+// typeRoute is one --on-type rule: what to do with a paid response whose Content-Type
+// matches Pattern (type/subtype, type/*, or */*).
+type typeRoute struct {
+	Pattern string
+	Action  string
+	// Path is where save writes: a file, or a directory (ending in /) to name the file in.
+	Path string
+}
+
+// typeRoutes collects --on-type rules; the first that matches wins.
+type typeRoutes []typeRoute
+
+func (r *typeRoutes) String() string {
+	var rules []string
+	for _, t := range *r {
+		rule := t.Pattern + ":" + t.Action
+		if t.Path != "" {
+			rule += "=" + t.Path
+		}
+		rules = append(rules, rule)
+	}
+	return strings.Join(rules, ", ")
+}
+
+// Set parses a rule such as image/*:save, image/png:save=out/, or application/json:print.
+func (r *typeRoutes) Set(v string) error {
+	pattern, action, ok := strings.Cut(v, ":")
+	if !ok {
+		return fmt.Errorf("--on-type %q: want <mime type>:<action>, e.g. image/*:save", v)
+	}
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	major, minor, ok := strings.Cut(pattern, "/")
+	if !ok || major == "" || minor == "" || (major == "*" && minor != "*") {
+		return fmt.Errorf("--on-type %q: %q is not a MIME type pattern such as image/png, image/*, or */*", v, pattern)
+	}
+	action, path, _ := strings.Cut(action, "=")
+	switch action {
+	case routePrint, routeDiscard:
+		if path != "" {
+			return fmt.Errorf("--on-type %q: only save takes a path", v)
+		}
+	case routeSave:
+	default:
+		return fmt.Errorf("--on-type %q: action must be print, save[=<path>], or discard", v)
+	}
+	*r = append(*r, typeRoute{Pattern: pattern, Action: action, Path: path})
+	return nil
+}
+
+// match returns the first rule for contentType, or nil.
+func (r typeRoutes) match(contentType string) *typeRoute {
+	if len(r) == 0 {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "application/octet-stream"
+	}
+	major, _, _ := strings.Cut(mediaType, "/")
+	for i, t := range r {
+		if t.Pattern == "*/*" || t.Pattern == mediaType || t.Pattern == major+"/*" {
+			return &r[i]
+		}
+	}
+	return nil
+}
+
+// savePath is where a save rule writes a response of contentType: its path, -o, or a file
+// named after the request ID with an extension for the type, in the rule's directory if
+// it names one.
+func (t *typeRoute) savePath(contentType, outputFile, id string) string {
+	if t.Path != "" && !strings.HasSuffix(t.Path, "/") && !strings.HasSuffix(t.Path, string(os.PathSeparator)) {
+		return t.Path
+	}
+	if t.Path == "" && outputFile != "" {
+		return outputFile
+	}
+	ext := ".bin"
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if e, ok := commonExtensions[mediaType]; ok {
+			ext = e
+		} else if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return filepath.Join(t.Path, "x402-response-"+id+ext)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+	x402http "github.com/coinbase/x402/go/http"
+	x402evm "github.com/coinbase/x402/go/mechanisms/evm"
+	evm "github.com/coinbase/x402/go/mechanisms/evm/exact/client"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// decodeRequirements parses the payment requirements from a 402 response.
+// The v2 PAYMENT-REQUIRED header takes precedence over a v1-style JSON body.
+func decodeRequirements(resp *http.Response, body []byte) (*x402.PaymentRequired, error) {
+	raw := body
+	if header := resp.Header.Get("PAYMENT-REQUIRED"); header != "" {
+		decoded, err := base64.StdEncoding.DecodeString(header)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PAYMENT-REQUIRED header: %w", err)
+		}
+		raw = decoded
+	}
+
+	var required x402.PaymentRequired
+	if err := json.Unmarshal(raw, &required); err != nil {
+		return nil, fmt.Errorf("invalid payment requirements: %w", err)
+	}
+	if len(required.Accepts) == 0 {
+		return nil, fmt.Errorf("no accepted payment options in response")
+	}
+	return &required, nil
+}
+
+// newPaymentClient returns an HTTP client that answers 402 responses with a signed x402 payment.
+func newPaymentClient(signer x402evm.ClientEvmSigner, transport http.RoundTripper, timeout time.Duration, opts ...x402.ClientOption) *http.Client {
+	x402Client := x402.Newx402Client(opts...).
+		Register("eip155:*", evm.NewExactEvmScheme(signer))
+	// Exact networks win over the wildcard, so --signers routes take precedence.
+	for network, s := range routedSigners {
+		x402Client.Register(x402.Network(network), evm.NewExactEvmScheme(s))
+	}
+
+	return x402http.WrapHTTPClientWithPayment(
+		&http.Client{Transport: transport, Timeout: timeout},
+		x402http.Newx402HTTPClient(x402Client),
+	)
+}
+
+// onPaymentHeader wraps rt and calls fn with each payment header (PAYMENT-SIGNATURE or
+// X-PAYMENT) the x402 client sends.
+func onPaymentHeader(rt http.RoundTripper, fn func(header string)) http.RoundTripper {
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		for _, h := range []string{"PAYMENT-SIGNATURE", "X-PAYMENT"} {
+			if v := req.Header.Get(h); v != "" {
+				fn(v)
+			}
+		}
+		return rt.RoundTrip(req)
+	})
+}
+
+// roundTripFunc adapts a function to http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// selectRequirement returns a client option that pays the given accepts entry,
+// falling back to the first supported option if the server no longer offers it.
+func selectRequirement(want x402.PaymentRequirements) x402.ClientOption {
+	return x402.WithPaymentSelector(func(reqs []x402.PaymentRequirementsView) x402.PaymentRequirementsView {
+		for _, r := range reqs {
+			tier, _ := acceptTier(r.GetExtra())
+			wantTier, _ := acceptTier(want.Extra)
+			if r.GetScheme() == want.Scheme && r.GetNetwork() == want.Network &&
+				strings.EqualFold(r.GetAsset(), want.Asset) && r.GetAmount() == want.Amount && tier == wantTier {
+				return r
+			}
+		}
+		return reqs[0]
+	})
+}
+
+// describeAmount renders an atomic amount, adding the human-readable value whenever
+// the asset's decimals are known (see assetDecimals).
+func describeAmount(req x402.PaymentRequirements) string {
+	if decimals, ok := assetDecimals(req); ok {
+		return fmt.Sprintf("%s %s (%s atomic units)", atomicToHuman(req.Amount, decimals), assetSymbol(req), req.Amount)
+	}
+	return fmt.Sprintf("%s %s (atomic units)", req.Amount, assetName(req))
+}
+
+// decimalsSelector is the ERC-20 decimals() function selector.
+const decimalsSelector = "0x313ce567"
+
+// tokenDecimals caches decimals() lookups by "<network>/<asset>"; failures are not cached.
+var tokenDecimals sync.Map
+
+// assetDecimals returns the decimals of a requirement's asset: the network's USDC,
+// extra.decimals, or decimals() read on-chain for other tokens on supported networks.
+func assetDecimals(req x402.PaymentRequirements) (int, bool) {
+	info, known := networkByChainID(req.Network)
+	asset := assetAddress(req.Asset)
+	if known && strings.EqualFold(asset, info.USDCContract) {
+		return info.Decimals, true
+	}
+	switch d := req.Extra["decimals"].(type) {
+	case float64:
+		if d >= 0 && d <= 77 && d == float64(int(d)) {
+			return int(d), true
+		}
+	case string:
+		if n, err := strconv.Atoi(d); err == nil && n >= 0 && n <= 77 {
+			return n, true
+		}
+	}
+	if !known || !common.IsHexAddress(asset) {
+		return 0, false
+	}
+
+	key := req.Network + "/" + strings.ToLower(asset)
+	if d, ok := tokenDecimals.Load(key); ok {
+		return d.(int), true
+	}
+	word, err := ethCall(info.RPCURL, asset, decimalsSelector)
+	if err != nil {
+		return 0, false
+	}
+	v, err := parseHexUint(word)
+	if err != nil || v.Cmp(big.NewInt(77)) > 0 {
+		return 0, false
+	}
+	tokenDecimals.Store(key, int(v.Int64()))
+	return int(v.Int64()), true
+}
+
+// assetSymbol returns "USDC" for the network's known USDC contract, else assetName.
+func assetSymbol(req x402.PaymentRequirements) string {
+	if info, ok := networkByChainID(req.Network); ok && strings.EqualFold(assetAddress(req.Asset), info.USDCContract) {
+		return "USDC"
+	}
+	return assetName(req)
+}
+
+// assetName returns the asset's display name from extra.name, or its address.
+func assetName(req x402.PaymentRequirements) string {
+	if name, ok := req.Extra["name"].(string); ok && name != "" {
+		return name
+	}
+	return req.Asset
+}
+
+// networkName returns the friendly name of a CAIP-2 network or x402 v1 network name, or
+// the ID itself when it is malformed.
+func networkName(chainID string) string {
+	if info, ok := lookupNetwork(chainID); ok {
+		return info.Name
+	}
+	if c, err := parseCAIP2(chainID); err == nil {
+		return chainName(c)
+	}
+	return chainID
+}
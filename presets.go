@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// preset is a named endpoint in presets.json, called with `x402-cli call <name>`.
+// URL, headers, and body may contain {{variable}} placeholders filled from --<variable>.
+type preset struct {
+	Description string   `json:"description,omitempty"`
+	URL         string   `json:"url"`
+	Method      string   `json:"method,omitempty"`
+	Headers     []string `json:"headers,omitempty"`
+	Body        string   `json:"body,omitempty"`
+	// Budget caps payments to the preset's host, e.g. "1USDC/day", on top of --host-budget.
+	Budget string `json:"budget,omitempty"`
+}
+
+// presetVar matches a {{variable}} placeholder.
+var presetVar = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_-]+)\s*\}\}`)
+
+// presetBudget is the budget of the preset being called, added to the host budgets.
+var presetBudget string
+
+// presetsFile is where presets are defined: X402_PRESETS, or presets.json in the config directory.
+func presetsFile() (string, error) {
+	if path := os.Getenv("X402_PRESETS"); path != "" {
+		return path, nil
+	}
+	return stateFile("presets.json")
+}
+
+// loadPresets reads all presets; a missing file is empty.
+func loadPresets() (map[string]preset, error) {
+	presets := map[string]preset{}
+	path, err := presetsFile()
+	if err != nil {
+		return presets, err
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return presets, nil
+	}
+	if err != nil {
+		return presets, err
+	}
+	if err := json.Unmarshal(raw, &presets); err != nil {
+		return presets, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return presets, nil
+}
+
+// variables lists the placeholders used by the preset, in order of first use.
+func (p preset) variables() []string {
+	var names []string
+	for _, s := range append([]string{p.URL, p.Body}, p.Headers...) {
+		for _, m := range presetVar.FindAllStringSubmatch(s, -1) {
+			if !slices.Contains(names, m[1]) {
+				names = append(names, m[1])
+			}
+		}
+	}
+	return names
+}
+
+// presetArgs turns `call <name> [--<variable> <value>]... [flags]` into the arguments of
+// the pay command. Arguments that don't name one of the preset's variables are passed on
+// as flags, after the preset's own, so they can override its method or add headers.
+func presetArgs(p preset, args []string) ([]string, error) {
+	vars := p.variables()
+	values := map[string]string{}
+	var passthrough []string
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if !strings.HasPrefix(args[i], "--") || !slices.Contains(vars, name) {
+			passthrough = append(passthrough, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--%s needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		values[name] = value
+	}
+	var missing []string
+	for _, v := range vars {
+		if _, ok := values[v]; !ok {
+			missing = append(missing, "--"+v)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+
+	// Values are percent-encoded in the URL and JSON-escaped in a JSON body, so they
+	// cannot change the request's structure.
+	target := fillPreset(p.URL, values, formEscape)
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("preset url %q is not an http(s) URL", p.URL)
+	}
+	var out []string
+	if p.Method != "" {
+		out = append(out, "-X", p.Method)
+	}
+	for _, h := range p.Headers {
+		out = append(out, "-H", fillPreset(h, values, func(s string) string { return s }))
+	}
+	if p.Body != "" {
+		escape := func(s string) string { return s }
+		if b := strings.TrimSpace(p.Body); strings.HasPrefix(b, "{") || strings.HasPrefix(b, "[") {
+			escape = jsonEscape
+		}
+		out = append(out, "-d", fillPreset(p.Body, values, escape))
+	}
+	out = append(out, passthrough...)
+	return append(out, target), nil
+}
+
+// fillPreset replaces the placeholders in s with their escaped values.
+func fillPreset(s string, values map[string]string, escape func(string) string) string {
+	return presetVar.ReplaceAllStringFunc(s, func(m string) string {
+		return escape(values[presetVar.FindStringSubmatch(m)[1]])
+	})
+}
+
+// jsonEscape escapes s for use inside a JSON string literal.
+func jsonEscape(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted[1 : len(quoted)-1])
+}
+
+// runCallCmd resolves `x402-cli call <preset> ...` to the pay command's arguments and the
+// preset's budget. Without a preset name it lists the presets and exits.
+func runCallCmd(args []string) []string {
+	presets, err := loadPresets()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "--list" {
+		path, _ := presetsFile()
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli call <preset> [--<variable> <value>]... [flags]\n\n")
+		fmt.Fprintf(os.Stderr, "Calls a named endpoint from %s (set X402_PRESETS to use another file).\n", path)
+		fmt.Fprintf(os.Stderr, "Other flags are those of the pay command (see x402-cli help), e.g. --json -y.\n\n")
+		if len(presets) == 0 {
+			fmt.Fprintf(os.Stderr, "No presets defined.\n")
+			os.Exit(ExitError)
+		}
+		names := make([]string, 0, len(presets))
+		for name := range presets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PRESET\tVARIABLES\tBUDGET\tDESCRIPTION")
+		for _, name := range names {
+			p := presets[name]
+			var vars []string
+			for _, v := range p.variables() {
+				vars = append(vars, "--"+v)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, dashIfEmpty(strings.Join(vars, " ")), dashIfEmpty(p.Budget), p.Description)
+		}
+		w.Flush()
+		os.Exit(ExitSuccess)
+	}
+
+	p, ok := presets[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: unknown preset %q (run 'x402-cli call' to list presets)\n", args[0])
+		os.Exit(ExitError)
+	}
+	out, err := presetArgs(p, args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: preset %s: %v\n", args[0], err)
+		os.Exit(ExitError)
+	}
+	if p.Budget != "" {
+		u, _ := url.Parse(out[len(out)-1])
+		presetBudget = u.Hostname() + "=" + p.Budget
+	}
+	return out
+}
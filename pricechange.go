@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// requirementsChange is what changed when the paid request was answered with a 402 carrying
+// other requirements than the ones paid, and whether the CLI paid again.
+type requirementsChange struct {
+	Changes []string `json:"changes"`
+	// Retried is set when the new requirements were gone through again to pay them; the
+	// checks, --max-amount among them, can still refuse.
+	Retried bool `json:"retried"`
+}
+
+// requirementsDiff lists how the requirements of a 402 to the paid request differ from the
+// ones the payment was made against; it is empty when they are the same, or when the new
+// response carries no requirements (a refusal of the payment itself).
+func requirementsDiff(oldResp *http.Response, oldBody []byte, newResp *http.Response, newBody []byte) []string {
+	now, err := decodeRequirements(newResp, newBody)
+	if err != nil {
+		return nil
+	}
+	was, err := decodeRequirements(oldResp, oldBody)
+	if err != nil {
+		return []string{"the server now sends payment requirements the CLI can read"}
+	}
+	return diffAccepts(was.Accepts, now.Accepts)
+}
+
+// diffAccepts describes, per scheme, network, and asset, the options whose price or
+// recipient changed, and the options added or withdrawn.
+func diffAccepts(paid, fresh []x402.PaymentRequirements) []string {
+	key := func(a x402.PaymentRequirements) string {
+		return a.Scheme + " " + a.Network + " " + strings.ToLower(a.Asset)
+	}
+	where := func(a x402.PaymentRequirements) string {
+		return fmt.Sprintf("%s on %s", assetSymbol(a), networkName(a.Network))
+	}
+	before := map[string]x402.PaymentRequirements{}
+	for _, a := range paid {
+		before[key(a)] = a
+	}
+	var changes []string
+	seen := map[string]bool{}
+	for _, a := range fresh {
+		k := key(a)
+		seen[k] = true
+		b, ok := before[k]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("new option: %s on %s", tokenAmount(a), networkName(a.Network)))
+			continue
+		}
+		if b.Amount != a.Amount {
+			changes = append(changes, fmt.Sprintf("price of %s: %s -> %s", where(a), tokenAmount(b), tokenAmount(a)))
+		}
+		if !strings.EqualFold(b.PayTo, a.PayTo) {
+			changes = append(changes, fmt.Sprintf("recipient of %s: %s -> %s", where(a), checksumAddress(b.PayTo), checksumAddress(a.PayTo)))
+		}
+	}
+	for _, b := range paid {
+		if !seen[key(b)] {
+			changes = append(changes, fmt.Sprintf("option withdrawn: %s on %s", tokenAmount(b), networkName(b.Network)))
+		}
+	}
+	if len(changes) == 0 {
+		was, _ := json.Marshal(paid)
+		now, _ := json.Marshal(fresh)
+		if !bytes.Equal(was, now) {
+			changes = append(changes, "other payment details changed (timeout or extra)")
+		}
+	}
+	return changes
+}
+
+// parseMaxAmount parses --max-amount, e.g. "0.01" or "0.01USDC"; "" means no limit.
+func parseMaxAmount(spec string) (*big.Rat, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	limit, err := parseTokenAmount(spec)
+	if err != nil {
+		return nil, fmt.Errorf("--max-amount: %w", err)
+	}
+	return limit, nil
+}
+
+// checkMaxAmount refuses a price above --max-amount. A nil limit allows everything.
+func checkMaxAmount(limit *big.Rat, price x402.PaymentRequirements) error {
+	if limit == nil {
+		return nil
+	}
+	cost, ok := tokenUnits(price)
+	if !ok {
+		return fmt.Errorf("--max-amount %s: cannot convert the price (%s) to token units", ratString(limit), describeAmount(price))
+	}
+	if cost.Cmp(limit) > 0 {
+		return fmt.Errorf("the price %s is above --max-amount %s", tokenAmount(price), ratString(limit))
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// pricesFile is the state file recording the prices endpoints asked for over time.
+const pricesFile = "prices.jsonl"
+
+// priceObservation is one line of prices.jsonl: the price an endpoint started asking for
+// one payment option at Time. Only changes are recorded, so each line stays in effect
+// until the next one for the same option.
+type priceObservation struct {
+	Time     time.Time `json:"time"`
+	Endpoint string    `json:"endpoint"`
+	Scheme   string    `json:"scheme"`
+	Network  string    `json:"network"`
+	Asset    string    `json:"asset"`
+	Amount   string    `json:"amount"`
+}
+
+// option identifies the payment option an observation prices.
+func (o priceObservation) option() string {
+	return o.Scheme + " " + o.Network + " " + strings.ToLower(o.Asset)
+}
+
+// observePrices records the accepts entries of a 402 from endpoint whose price differs from
+// the last one recorded. Failures only warn: price tracking never stops a request.
+func observePrices(endpoint string, accepts []x402.PaymentRequirements) {
+	if err := recordPrices(endpoint, accepts, time.Now()); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record prices: %v\n", err)
+	}
+}
+
+// recordPrices appends the changed prices of endpoint's accepts entries at now.
+func recordPrices(endpoint string, accepts []x402.PaymentRequirements, now time.Time) error {
+	if len(accepts) == 0 {
+		return nil
+	}
+	path, err := stateFile(pricesFile)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockState(pricesFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	observed, err := readPrices(path)
+	if err != nil {
+		return err
+	}
+	last := map[string]string{}
+	for _, o := range observed {
+		if o.Endpoint == endpoint {
+			last[o.option()] = o.Amount
+		}
+	}
+	var buf []byte
+	for _, a := range accepts {
+		o := priceObservation{Time: now.UTC(), Endpoint: endpoint, Scheme: a.Scheme, Network: a.Network, Asset: a.Asset, Amount: a.Amount}
+		if prev, ok := last[o.option()]; ok && prev == o.Amount {
+			continue
+		}
+		last[o.option()] = o.Amount
+		line, _ := json.Marshal(o)
+		if stateEncryption() {
+			if line, err = sealLine(line); err != nil {
+				return err
+			}
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readPrices parses prices.jsonl, oldest first; callers hold its lock.
+func readPrices(path string) ([]priceObservation, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var observed []priceObservation
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, err := openLine(scanner.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		var o priceObservation
+		if json.Unmarshal(line, &o) == nil {
+			observed = append(observed, o)
+		}
+	}
+	return observed, scanner.Err()
+}
+
+// priceChange is one price an option asked for, in `price-history` output.
+type priceChange struct {
+	Time   time.Time `json:"time"`
+	Amount string    `json:"amount"` // atomic units
+	Price  string    `json:"price"`
+	// ChangePct is the change from the previous price, in percent; absent for the first.
+	ChangePct *float64 `json:"changePct,omitempty"`
+}
+
+// priceSeries is the price history of one payment option of an endpoint.
+type priceSeries struct {
+	Scheme  string        `json:"scheme"`
+	Network string        `json:"network"`
+	Asset   string        `json:"asset"`
+	Current string        `json:"current"`
+	Changes []priceChange `json:"changes"`
+}
+
+// priceHistory groups endpoint's observations by payment option, in first-seen order.
+func priceHistory(observed []priceObservation, endpoint string) []priceSeries {
+	var series []priceSeries
+	index := map[string]int{}
+	for _, o := range observed {
+		if o.Endpoint != endpoint {
+			continue
+		}
+		i, ok := index[o.option()]
+		if !ok {
+			i = len(series)
+			index[o.option()] = i
+			series = append(series, priceSeries{Scheme: o.Scheme, Network: o.Network, Asset: o.Asset})
+		}
+		s := &series[i]
+		price := x402.PaymentRequirements{Scheme: o.Scheme, Network: o.Network, Asset: o.Asset, Amount: o.Amount}
+		c := priceChange{Time: o.Time, Amount: o.Amount, Price: describeAmount(price)}
+		if n := len(s.Changes); n > 0 {
+			c.ChangePct = percentChange(s.Changes[n-1].Amount, o.Amount)
+		}
+		s.Changes = append(s.Changes, c)
+		s.Current = c.Price
+	}
+	return series
+}
+
+// percentChange is the change from one amount to the next in percent, or nil when the
+// first is not a positive number.
+func percentChange(from, to string) *float64 {
+	o, ok1 := new(big.Rat).SetString(from)
+	n, ok2 := new(big.Rat).SetString(to)
+	if !ok1 || !ok2 || o.Sign() <= 0 {
+		return nil
+	}
+	pct, _ := new(big.Rat).Quo(new(big.Rat).Sub(n, o), o).Float64()
+	pct *= 100
+	return &pct
+}
+
+// sparkline renders amounts (atomic units) as block characters, lowest to highest.
+func sparkline(amounts []string) string {
+	const blocks = "▁▂▃▄▅▆▇█"
+	levels := []rune(blocks)
+	values := make([]float64, len(amounts))
+	lo, hi := 0.0, 0.0
+	for i, a := range amounts {
+		r, ok := new(big.Rat).SetString(a)
+		if ok {
+			values[i], _ = r.Float64()
+		}
+		if i == 0 || values[i] < lo {
+			lo = values[i]
+		}
+		if i == 0 || values[i] > hi {
+			hi = values[i]
+		}
+	}
+	var b strings.Builder
+	for _, v := range values {
+		level := 0
+		if hi > lo {
+			level = int((v - lo) / (hi - lo) * float64(len(levels)-1))
+		}
+		b.WriteRune(levels[level])
+	}
+	return b.String()
+}
+
+// runPriceHistoryCmd shows how an endpoint's prices changed over time.
+func runPriceHistoryCmd(args []string) {
+	fs := flag.NewFlagSet("price-history", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli price-history [--json] <url>\n\n")
+		fmt.Fprintf(os.Stderr, "Shows the prices an endpoint asked for over time, per payment option, as recorded\n")
+		fmt.Fprintf(os.Stderr, "by every probe (the pay command, batch, dashboard, and tui). Only changes are stored,\n")
+		fmt.Fprintf(os.Stderr, "so each price stays in effect until the next one.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	endpoint := fs.Arg(0)
+
+	path, err := stateFile(pricesFile)
+	var observed []priceObservation
+	if err == nil {
+		var unlock func()
+		if unlock, err = lockState(pricesFile); err == nil {
+			observed, err = readPrices(path)
+			unlock()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	series := priceHistory(observed, endpoint)
+	if *jsonOut {
+		if series == nil {
+			series = []priceSeries{}
+		}
+		printJSON(series)
+		return
+	}
+	if len(series) == 0 {
+		fmt.Printf("No prices recorded for %s yet.\n", endpoint)
+		return
+	}
+	fmt.Printf("Prices of %s\n", endpoint)
+	for _, s := range series {
+		amounts := make([]string, len(s.Changes))
+		for i, c := range s.Changes {
+			amounts[i] = c.Amount
+		}
+		fmt.Printf("\n%s on %s (%s): %s  now %s\n", assetSymbol(x402.PaymentRequirements{Network: s.Network, Asset: s.Asset}),
+			networkName(s.Network), s.Scheme, sparkline(amounts), s.Current)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  SINCE\tPRICE\tCHANGE")
+		for _, c := range s.Changes {
+			change := "-"
+			if c.ChangePct != nil {
+				change = fmt.Sprintf("%+.1f%%", *c.ChangePct)
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\n", c.Time.Local().Format("2006-01-02 15:04:05"), c.Price, change)
+		}
+		w.Flush()
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// profile selects which private key environment variable is used; it is set by the
+// global --profile flag or X402_PROFILE.
+var profile string
+
+// extractProfileFlag removes a global --profile flag from args, wherever it appears,
+// so it works with every subcommand.
+func extractProfileFlag(args []string) (string, []string) {
+	return extractGlobalFlag(args, "profile")
+}
+
+// extractGlobalFlag removes a global --<flag> <value> from args, wherever it appears.
+func extractGlobalFlag(args []string, flag string) (string, []string) {
+	value := ""
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			out = append(out, args[i:]...)
+			break
+		}
+		name, v, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != flag {
+			out = append(out, arg)
+			continue
+		}
+		if hasValue {
+			value = v
+		} else if i+1 < len(args) {
+			value = args[i+1]
+			i++
+		}
+	}
+	return value, out
+}
+
+// extractGlobalSwitch removes a global boolean --<flag> (or --<flag>=<bool>) from args.
+func extractGlobalSwitch(args []string, flag string) (bool, []string) {
+	on := false
+	out := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			out = append(out, args[i:]...)
+			break
+		}
+		name, v, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != flag {
+			out = append(out, arg)
+			continue
+		}
+		on = true
+		if hasValue {
+			on, _ = strconv.ParseBool(v)
+		}
+	}
+	return on, out
+}
+
+// privateKeyVar is the environment variable holding the signing key: EVM_PRIVATE_KEY,
+// or EVM_PRIVATE_KEY_<PROFILE> (upper-cased, dashes as underscores) under a profile.
+func privateKeyVar() string {
+	return profileKeyVar(profile)
+}
+
+// profileKeyVar is the environment variable holding the key of profile p.
+func profileKeyVar(p string) string {
+	if p == "" {
+		return "EVM_PRIVATE_KEY"
+	}
+	return "EVM_PRIVATE_KEY_" + strings.ToUpper(strings.ReplaceAll(p, "-", "_"))
+}
+
+// privateKeyFromEnv reads the signing key for the active profile. A profile never
+// falls back to EVM_PRIVATE_KEY, so a missing profile key cannot pay from the default wallet.
+func privateKeyFromEnv() string {
+	return os.Getenv(privateKeyVar())
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Routes of --prompt.
+const (
+	promptStdin    = "stdin"
+	promptTTY      = "tty"
+	promptPinentry = "pinentry"
+)
+
+// promptVia is where confirmations and passphrase prompts are asked (--prompt): stdin,
+// tty[:<device>], or pinentry[:<program>]. The last two let a GUI or an agent sandbox
+// that does not own stdin embed the CLI and still answer its prompts.
+var promptVia = os.Getenv("X402_PROMPT")
+
+// parsePromptRoute splits a --prompt value into its route and the device or program of it.
+func parsePromptRoute(spec string) (route, target string, err error) {
+	route, target, _ = strings.Cut(strings.TrimSpace(spec), ":")
+	switch route {
+	case "", promptStdin:
+		if target == "" {
+			return promptStdin, "", nil
+		}
+	case promptTTY:
+		return route, cmp.Or(target, "/dev/tty"), nil
+	case promptPinentry:
+		return route, cmp.Or(target, "pinentry"), nil
+	}
+	return "", "", fmt.Errorf("--prompt must be stdin, tty[:<device>], or pinentry[:<program>], not %q", spec)
+}
+
+// promptsElsewhere reports whether --prompt routes prompts away from stdin.
+func promptsElsewhere() bool {
+	route, _, err := parsePromptRoute(promptVia)
+	return err == nil && route != promptStdin
+}
+
+// openTTY opens device to read answers from and to write prompts to.
+func openTTY(device string) (in, out *os.File, err error) {
+	if in, err = os.Open(device); err != nil {
+		return nil, nil, fmt.Errorf("--prompt: %w", err)
+	}
+	if out, err = os.OpenFile(device, os.O_WRONLY|os.O_APPEND, 0); err != nil {
+		in.Close()
+		return nil, nil, fmt.Errorf("--prompt: %w", err)
+	}
+	return in, out, nil
+}
+
+// ttyAsk asks question on device, like askPrompt does on stdin. The device is opened for
+// this prompt alone, so nothing typed for an earlier one can answer it.
+func ttyAsk(device, question, onTimeout string) (string, bool) {
+	in, out, err := openTTY(device)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return "", false
+	}
+	// Closing the device also ends a read abandoned by a timeout.
+	defer out.Close()
+	defer in.Close()
+	fmt.Fprint(out, question)
+
+	answers := make(chan string, 1)
+	go func() {
+		line, err := bufio.NewReader(in).ReadString('\n')
+		if line == "" && err != nil {
+			close(answers)
+			return
+		}
+		answers <- line
+	}()
+	var expired <-chan time.Time
+	if confirmTimeout > 0 {
+		timer := time.NewTimer(confirmTimeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case answer, ok := <-answers:
+		return strings.TrimSpace(answer), ok
+	case <-expired:
+		fmt.Fprintf(out, "\nNo answer within %s (--confirm-timeout); %s.\n", confirmTimeout, onTimeout)
+		return "", false
+	}
+}
+
+// ttyPassphrase asks for a passphrase on device, without echoing it when the device is a
+// terminal; repeat asks for it twice, for a passphrase that is being set.
+func ttyPassphrase(device, description string, repeat bool) (string, error) {
+	in, out, err := openTTY(device)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	defer in.Close()
+	lines := bufio.NewReader(in)
+	read := func(label string) (string, error) {
+		fmt.Fprint(out, label)
+		if term.IsTerminal(int(in.Fd())) {
+			pass, err := term.ReadPassword(int(in.Fd()))
+			fmt.Fprintln(out)
+			return string(pass), err
+		}
+		line, err := lines.ReadString('\n')
+		if line == "" && err != nil {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	fmt.Fprintf(out, "%s\n", description)
+	pass, err := read("Passphrase: ")
+	if err != nil || !repeat {
+		return pass, err
+	}
+	again, err := read("Repeat: ")
+	if err != nil {
+		return "", err
+	}
+	if again != pass {
+		return "", errors.New("the passphrases do not match")
+	}
+	return pass, nil
+}
+
+// pinentry is a conversation with a pinentry program (as GnuPG ships for GTK, Qt, macOS,
+// and curses) over the Assuan protocol: a command per line, answered by data lines and
+// then OK, or by ERR with an error code.
+type pinentry struct {
+	cmd    *exec.Cmd
+	in     io.WriteCloser
+	out    *bufio.Reader
+	cancel context.CancelFunc
+}
+
+// pinentryError is an ERR answer of pinentry.
+type pinentryError struct {
+	code int
+	text string
+}
+
+func (e *pinentryError) Error() string { return "pinentry: " + e.text }
+
+// timedOut reports whether the error is GPG_ERR_TIMEOUT, sent when SETTIMEOUT ran out.
+func (e *pinentryError) timedOut() bool { return e.code&0xffff == 62 }
+
+// startPinentry starts program and sets it up; when timeout is set, the dialog closes by
+// itself after it and the program is killed shortly after that.
+func startPinentry(program string, timeout time.Duration) (*pinentry, error) {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout+5*time.Second)
+	}
+	cmd := exec.CommandContext(ctx, program)
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("--prompt: cannot start %s: %w", program, err)
+	}
+	p := &pinentry{cmd: cmd, in: in, out: bufio.NewReader(out), cancel: cancel}
+	setup := []string{"SETTITLE x402-cli"}
+	if tty := os.Getenv("GPG_TTY"); tty != "" {
+		// The curses pinentry needs to be told the terminal to draw on.
+		setup = append(setup, "OPTION ttyname="+tty, "OPTION ttytype="+cmp.Or(os.Getenv("TERM"), "dumb"))
+	}
+	if timeout > 0 {
+		setup = append(setup, "SETTIMEOUT "+strconv.Itoa(int((timeout+time.Second-1)/time.Second)))
+	}
+	if _, err := p.response(); err != nil {
+		p.close()
+		return nil, err
+	}
+	for _, command := range setup {
+		if _, err := p.call(command); err != nil && !strings.HasPrefix(command, "OPTION ") {
+			p.close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// call sends command and returns the data pinentry answered with.
+func (p *pinentry) call(command string) (string, error) {
+	if _, err := fmt.Fprintf(p.in, "%s\n", command); err != nil {
+		return "", fmt.Errorf("pinentry: %w", err)
+	}
+	return p.response()
+}
+
+// response reads pinentry's answer to a command, up to its OK or ERR line.
+func (p *pinentry) response() (string, error) {
+	var data strings.Builder
+	for {
+		line, err := p.out.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("pinentry exited: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return data.String(), nil
+		case strings.HasPrefix(line, "ERR "):
+			codeText, text, _ := strings.Cut(line[len("ERR "):], " ")
+			code, _ := strconv.Atoi(codeText)
+			return "", &pinentryError{code: code, text: text}
+		case strings.HasPrefix(line, "D "):
+			data.WriteString(assuanUnescape(line[len("D "):]))
+		}
+		// Status (S) and comment (#) lines carry nothing that was asked for.
+	}
+}
+
+func (p *pinentry) close() {
+	p.call("BYE")
+	p.in.Close()
+	p.cmd.Wait()
+	p.cancel()
+}
+
+// assuanEscape percent-escapes the characters an Assuan command line cannot hold.
+func assuanEscape(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// assuanUnescape decodes the %XX escapes of an Assuan data line.
+func assuanUnescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// pinentryConfirm asks question as a pinentry Yes/No dialog. The question and the answer
+// are also printed, so the output reads as it does when asked on stdin.
+func pinentryConfirm(program, question string) bool {
+	fmt.Print(question)
+	yes := func() bool {
+		p, err := startPinentry(program, confirmTimeout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+			return false
+		}
+		defer p.close()
+		description := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(question), "[y/N]"))
+		for _, command := range []string{"SETDESC " + assuanEscape(description), "SETOK Yes", "SETCANCEL No"} {
+			if _, err := p.call(command); err != nil {
+				fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+				return false
+			}
+		}
+		_, err = p.call("CONFIRM")
+		var perr *pinentryError
+		switch {
+		case errors.As(err, &perr) && perr.timedOut():
+			fmt.Printf("\nNo answer within %s (--confirm-timeout); taking it as no.\n", confirmTimeout)
+		case err != nil && !errors.As(err, &perr):
+			fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+		}
+		return err == nil
+	}()
+	if yes {
+		fmt.Println("yes")
+	} else {
+		fmt.Println("no")
+	}
+	return yes
+}
+
+// pinentryAsk asks question in a pinentry entry dialog, which hides what is typed as it
+// would a passphrase. The question and the answer are printed, as pinentryConfirm does.
+func pinentryAsk(program, question, onTimeout string) (string, bool) {
+	fmt.Print(question)
+	answer, err := func() (string, error) {
+		p, err := startPinentry(program, confirmTimeout)
+		if err != nil {
+			return "", err
+		}
+		defer p.close()
+		description := strings.TrimSpace(question)
+		for _, command := range []string{"SETDESC " + assuanEscape(description), "SETPROMPT >"} {
+			if _, err := p.call(command); err != nil {
+				return "", err
+			}
+		}
+		return p.call("GETPIN")
+	}()
+	var perr *pinentryError
+	switch {
+	case errors.As(err, &perr) && perr.timedOut():
+		fmt.Printf("\nNo answer within %s (--confirm-timeout); %s.\n", confirmTimeout, onTimeout)
+		return "", false
+	case err != nil:
+		if !errors.As(err, &perr) {
+			fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+		}
+		fmt.Println()
+		return "", false
+	}
+	answer = strings.TrimSpace(answer)
+	fmt.Println(answer)
+	return answer, true
+}
+
+// promptPassphrase asks for a passphrase where --prompt routes prompts, which must be a TTY
+// device or pinentry; repeat asks for it twice, for a passphrase that is being set.
+func promptPassphrase(description string, repeat bool) (string, error) {
+	route, target, err := parsePromptRoute(promptVia)
+	if err != nil {
+		return "", err
+	}
+	var pass string
+	switch route {
+	case promptTTY:
+		if pass, err = ttyPassphrase(target, description, repeat); err != nil {
+			return "", err
+		}
+	case promptPinentry:
+		p, err := startPinentry(target, 0)
+		if err != nil {
+			return "", err
+		}
+		defer p.close()
+		commands := []string{"SETDESC " + assuanEscape(description), "SETPROMPT Passphrase:"}
+		if repeat {
+			commands = append(commands, "SETREPEAT Repeat:", "SETREPEATERROR "+assuanEscape("The passphrases do not match"))
+		}
+		for _, command := range commands {
+			if _, err := p.call(command); err != nil {
+				return "", err
+			}
+		}
+		if pass, err = p.call("GETPIN"); err != nil {
+			return "", err
+		}
+	default:
+		return "", errors.New("no passphrase prompt: --prompt is stdin")
+	}
+	if pass == "" {
+		return "", errors.New("no passphrase given")
+	}
+	return pass, nil
+}
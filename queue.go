@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+	x402evm "github.com/coinbase/x402/go/mechanisms/evm"
+)
+
+// queuedState is the in-flight state of a payment --enqueue signed but did not send; `flush`
+// sends it like `resume` sends an interrupted one.
+const queuedState = "queued"
+
+// enqueueMaxAge is how old the cached requirements --enqueue signs against may be when the
+// probe cannot reach the server.
+const enqueueMaxAge = 24 * time.Hour
+
+// queuedRequirements is the 402 a payment is queued against: the cached one, or the probe's.
+func queuedRequirements(cached *cachedRequirements, resp *http.Response, body []byte) *cachedRequirements {
+	if cached != nil {
+		return cached
+	}
+	return &cachedRequirements{
+		Header:      resp.Header.Get("PAYMENT-REQUIRED"),
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        string(body),
+		Time:        time.Now(),
+	}
+}
+
+// queueTransport answers paid requests locally instead of sending them, handing the signed
+// payment header to capture. Unpaid requests never reach it: the requirements are replayed.
+func queueTransport(capture func(name, value string)) http.RoundTripper {
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		for _, h := range []string{"PAYMENT-SIGNATURE", "X-PAYMENT"} {
+			if v := req.Header.Get(h); v != "" {
+				capture(h, v)
+				return &http.Response{
+					Status:     "202 Accepted",
+					StatusCode: http.StatusAccepted,
+					Proto:      "HTTP/1.1",
+					ProtoMajor: 1,
+					ProtoMinor: 1,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader("")),
+					Request:    req,
+				}, nil
+			}
+		}
+		return nil, errors.New("not sending an unpaid request while queueing a payment")
+	})
+}
+
+// enqueuePayment signs p against required without sending anything, and saves the signed
+// payment in state "queued". The host allowlist and the mainnet guard apply as when paying.
+func enqueuePayment(p *inflightPayment, signer x402evm.ClientEvmSigner, required *cachedRequirements, hosts hostAllowlist, mainnet bool, timeout time.Duration, opts ...x402.ClientOption) error {
+	var name, payment string
+	rt := queueTransport(func(h, v string) { name, payment = h, v })
+	client := newPaymentClient(signer, required.transport(hosts.transport(mainnetGuard(rt, mainnet))), timeout, opts...)
+	req, err := newRequest(p.Method, p.Endpoint, p.Data, p.Headers)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if payment == "" {
+		return errors.New("no payment was signed")
+	}
+	p.Payment, p.PaymentHeader, p.State = payment, name, queuedState
+	return p.save()
+}
+
+// flushResult is the JSON output of `x402-cli flush`.
+type flushResult struct {
+	Payments []*resumeResult `json:"payments"`
+	ExitCode int             `json:"exitCode"`
+}
+
+// runFlushCmd sends the payments --enqueue queued, oldest first.
+func runFlushCmd(args []string) {
+	fs := flag.NewFlagSet("flush", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "Output JSON")
+	timeout := fs.Duration("timeout", 30*time.Second, "Per-payment request timeout")
+	list := fs.Bool("list", false, "List the queued payments without sending them")
+	hosts := fs.String("only-hosts", os.Getenv("X402_ONLY_HOSTS"), "Only ever pay these hosts: comma-separated names or *.domain wildcards (default: $X402_ONLY_HOSTS)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli flush [--list] [--json]\n\n")
+		fmt.Fprintf(os.Stderr, "Sends the payments queued with --enqueue, oldest first. Each was signed when it was\n")
+		fmt.Fprintf(os.Stderr, "queued and is only valid until its authorization expires (the server's maxTimeoutSeconds\n")
+		fmt.Fprintf(os.Stderr, "after signing); an expired one is dropped unpaid. A payment whose outcome is unknown\n")
+		fmt.Fprintf(os.Stderr, "stays in-flight for `x402-cli resume`.\n")
+		fmt.Fprintf(os.Stderr, "Exits 0 when every queued payment was accepted, 7 when the outcome of one is still\n")
+		fmt.Fprintf(os.Stderr, "unknown, and otherwise with the exit code of the first that failed.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	guards, err := newResumeGuards(*hosts, nil, maxTotalSpendByEnv())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	payments, err := listInflight()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	var queued []*inflightPayment
+	for _, p := range payments {
+		if p.State == queuedState {
+			queued = append(queued, p)
+		}
+	}
+
+	if *list {
+		results := make([]*resumeResult, 0, len(queued))
+		for _, p := range queued {
+			results = append(results, &resumeResult{ID: p.ID, Endpoint: p.Endpoint, Method: p.Method, State: p.State, Status: queuedState})
+		}
+		if *jsonOut {
+			printJSON(flushResult{Payments: results})
+			return
+		}
+		if len(queued) == 0 {
+			fmt.Println("No queued payments.")
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tQUEUED\tMETHOD\tENDPOINT\tAMOUNT")
+		for _, p := range queued {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.ID, p.Updated.Local().Format("2006-01-02 15:04:05"), p.Method, p.Endpoint, describeAmount(p.Requirements))
+		}
+		w.Flush()
+		return
+	}
+
+	out := flushResult{Payments: []*resumeResult{}}
+	for _, p := range queued {
+		if !*jsonOut {
+			fmt.Fprintf(os.Stderr, "Sending queued payment %s: %s %s\n", p.ID, p.Method, p.Endpoint)
+		}
+		result, code, msg := resumePayment(p, *timeout, false, guards)
+		result.Body = ""
+		out.Payments = append(out.Payments, result)
+		switch {
+		case code == ExitTimeout:
+			out.ExitCode = ExitTimeout
+		case code != ExitSuccess && out.ExitCode == ExitSuccess:
+			out.ExitCode = code
+		}
+		if *jsonOut {
+			continue
+		}
+		switch {
+		case result.Error != "":
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", result.Status, result.Error)
+		case msg != "":
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", result.Status, msg)
+		default:
+			fmt.Fprintf(os.Stderr, "  %s\n", result.Status)
+		}
+	}
+	stdinSpool.close()
+
+	if *jsonOut {
+		printJSON(out)
+	} else if len(queued) == 0 {
+		fmt.Println("No queued payments.")
+	}
+	os.Exit(out.ExitCode)
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// apiOperation is one path and method of an OpenAPI spec.
+type apiOperation struct {
+	Path    string
+	Method  string
+	Summary string
+}
+
+// rateCardEntry is one operation of `x402-cli ratecard`, as priced by the deployment.
+type rateCardEntry struct {
+	Path    string `json:"path"`
+	Method  string `json:"method"`
+	Summary string `json:"summary,omitempty"`
+	// Status is "paid", "free", "error", or "skipped" (a path parameter without --path-param).
+	Status     string       `json:"status"`
+	StatusCode int          `json:"statusCode,omitempty"`
+	Prices     []rateOption `json:"prices,omitempty"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// rateOption is one way an operation can be paid.
+type rateOption struct {
+	Price   string `json:"price"`
+	Amount  string `json:"amount"` // atomic units
+	Asset   string `json:"asset"`
+	Network string `json:"network"`
+	Scheme  string `json:"scheme"`
+	Tier    string `json:"tier,omitempty"`
+}
+
+// rateCard is the JSON output of `x402-cli ratecard`.
+type rateCard struct {
+	Title      string          `json:"title,omitempty"`
+	BaseURL    string          `json:"baseUrl"`
+	Generated  time.Time       `json:"generated"`
+	Operations []rateCardEntry `json:"operations"`
+}
+
+// openAPIMethods are the operation keys of an OpenAPI path item, in the order they are listed.
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// runRateCardCmd probes every operation of an OpenAPI spec and prints its price list.
+func runRateCardCmd(args []string) {
+	fs := flag.NewFlagSet("ratecard", flag.ExitOnError)
+	var (
+		spec       string
+		baseURL    string
+		format     string
+		output     string
+		insecure   bool
+		timeout    time.Duration
+		pathParams headerFlags
+	)
+	fs.StringVar(&spec, "openapi", "", "OpenAPI 3 spec (YAML or JSON) listing the operations to price")
+	fs.StringVar(&baseURL, "base-url", "", "Deployment to probe, e.g. https://staging.example.com (default: the spec's first servers url)")
+	fs.StringVar(&format, "format", "md", "Output format: md or json")
+	fs.StringVar(&output, "o", "", "Write the rate card to this file instead of stdout")
+	fs.BoolVar(&insecure, "k", false, "Skip TLS certificate verification")
+	fs.DurationVar(&timeout, "timeout", 10*time.Second, "Per-probe timeout")
+	fs.Var(&pathParams, "path-param", "Value to probe a path parameter with, 'name=value' (repeatable); operations with other parameters are skipped")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli ratecard --openapi <spec.yaml> [--base-url <url>] [--format md|json]\n\n")
+		fmt.Fprintf(os.Stderr, "Probes every operation of an OpenAPI spec against a deployment (no payment is sent) and\n")
+		fmt.Fprintf(os.Stderr, "prints a customer-facing rate card of paths, methods, and prices. Run it against your own\n")
+		fmt.Fprintf(os.Stderr, "API before launch to check that what it charges is what you mean to publish.\n\n")
+		fmt.Fprintf(os.Stderr, "  x402-cli ratecard --openapi openapi.yaml --base-url https://staging.example.com > PRICING.md\n")
+		fmt.Fprintf(os.Stderr, "  x402-cli ratecard --openapi openapi.json --path-param id=42 --format json\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	if spec == "" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	if format != "md" && format != "json" {
+		fail(fmt.Errorf("--format must be md or json"))
+	}
+	raw, err := os.ReadFile(spec)
+	if err != nil {
+		fail(err)
+	}
+	api, err := parseOpenAPI(string(raw))
+	if err != nil {
+		fail(fmt.Errorf("%s: %w", spec, err))
+	}
+	if baseURL == "" {
+		baseURL = api.Server
+	}
+	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
+		fail(fmt.Errorf("--base-url must be an http(s) URL (the spec names no absolute server)"))
+	}
+	values := map[string]string{}
+	for _, p := range pathParams {
+		name, value, ok := strings.Cut(p, "=")
+		if !ok || name == "" {
+			fail(fmt.Errorf("--path-param %q: want name=value", p))
+		}
+		values[name] = value
+	}
+
+	transport := &http.Transport{}
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	client := &http.Client{Transport: transport, Timeout: timeout}
+
+	card := rateCard{Title: api.Title, BaseURL: strings.TrimRight(baseURL, "/"), Generated: time.Now().UTC()}
+	card.Operations = make([]rateCardEntry, len(api.Operations))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 8)
+	for i, op := range api.Operations {
+		wg.Add(1)
+		go func(i int, op apiOperation) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			card.Operations[i] = priceOperation(client, card.BaseURL, op, values)
+		}(i, op)
+	}
+	wg.Wait()
+
+	w := io.Writer(os.Stdout)
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			fail(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if format == "json" {
+		out, _ := json.MarshalIndent(card, "", "  ")
+		fmt.Fprintln(w, string(out))
+	} else {
+		writeRateCardMarkdown(w, card)
+	}
+}
+
+// pathParam matches an OpenAPI path template parameter such as {id}.
+var pathParam = regexp.MustCompile(`\{([^}/]+)\}`)
+
+// expandPath fills the path's template parameters from values, reporting the first one
+// without a value.
+func expandPath(path string, values map[string]string) (string, string) {
+	missing := ""
+	expanded := pathParam.ReplaceAllStringFunc(path, func(m string) string {
+		name := m[1 : len(m)-1]
+		if v, ok := values[name]; ok {
+			return v
+		}
+		if missing == "" {
+			missing = name
+		}
+		return m
+	})
+	return expanded, missing
+}
+
+// priceOperation sends the operation without a body or payment and records what it charges.
+func priceOperation(client *http.Client, baseURL string, op apiOperation, values map[string]string) rateCardEntry {
+	e := rateCardEntry{Path: op.Path, Method: op.Method, Summary: op.Summary}
+	path, missing := expandPath(op.Path, values)
+	if missing != "" {
+		e.Status = "skipped"
+		e.Error = fmt.Sprintf("no --path-param for {%s}", missing)
+		return e
+	}
+	req, err := newRequest(op.Method, baseURL+path, "", nil)
+	if err != nil {
+		e.Status, e.Error = "error", err.Error()
+		return e
+	}
+	priced := priceRequest(client, req)
+	e.Status, e.StatusCode, e.Prices, e.Error = priced.Status, priced.StatusCode, priced.Prices, priced.Error
+	return e
+}
+
+// priceRequest sends req, which carries no payment, and records what it charges.
+func priceRequest(client *http.Client, req *http.Request) rateCardEntry {
+	var e rateCardEntry
+	resp, err := client.Do(req)
+	if err != nil {
+		e.Status, e.Error = "error", err.Error()
+		return e
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	e.StatusCode = resp.StatusCode
+	switch {
+	case resp.StatusCode == http.StatusPaymentRequired:
+		required, err := decodeRequirements(resp, body)
+		if err != nil {
+			e.Status, e.Error = "error", err.Error()
+			return e
+		}
+		observePrices(req.URL.String(), required.Accepts)
+		e.Status = "paid"
+		for _, a := range required.Accepts {
+			tier, _ := acceptTier(a.Extra)
+			e.Prices = append(e.Prices, rateOption{
+				Price: priceLabel(a), Amount: a.Amount, Asset: a.Asset,
+				Network: networkName(a.Network), Scheme: a.Scheme, Tier: tier,
+			})
+		}
+	case resp.StatusCode < 400:
+		e.Status = "free"
+	default:
+		e.Status, e.Error = "error", resp.Status
+	}
+	return e
+}
+
+// priceLabel renders an amount for customers: in token units when the decimals are known,
+// without the atomic amount describeAmount adds for developers.
+func priceLabel(req x402.PaymentRequirements) string {
+	if decimals, ok := assetDecimals(req); ok {
+		return fmt.Sprintf("%s %s", atomicToHuman(req.Amount, decimals), assetSymbol(req))
+	}
+	return fmt.Sprintf("%s %s (atomic units)", req.Amount, assetName(req))
+}
+
+// writeRateCardMarkdown renders the rate card as a Markdown price list.
+func writeRateCardMarkdown(w io.Writer, card rateCard) error {
+	cell := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+	}
+	title := card.Title
+	if title == "" {
+		title = "API"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s pricing\n\n", title)
+	fmt.Fprintf(&b, "Prices of %s as of %s, paid per request with [x402](https://x402.org).\n\n",
+		card.BaseURL, card.Generated.Format("2006-01-02"))
+	fmt.Fprintf(&b, "| Method | Path | Price | Network | Description |\n")
+	fmt.Fprintf(&b, "|--------|------|-------|---------|-------------|\n")
+	var unpriced []rateCardEntry
+	for _, e := range card.Operations {
+		var prices, networks []string
+		switch e.Status {
+		case "paid":
+			for _, p := range e.Prices {
+				price := p.Price
+				if p.Tier != "" {
+					price += " (" + p.Tier + ")"
+				}
+				prices = append(prices, cell(price))
+				networks = append(networks, cell(p.Network))
+			}
+		case "free":
+			prices, networks = []string{"Free"}, []string{"-"}
+		default:
+			unpriced = append(unpriced, e)
+			continue
+		}
+		fmt.Fprintf(&b, "| %s | `%s` | %s | %s | %s |\n", e.Method, cell(e.Path),
+			strings.Join(prices, "<br>"), strings.Join(networks, "<br>"), cell(e.Summary))
+	}
+	if len(unpriced) > 0 {
+		fmt.Fprintf(&b, "\n## Not priced\n\n")
+		for _, e := range unpriced {
+			fmt.Fprintf(&b, "- %s `%s`: %s\n", e.Method, e.Path, e.Error)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// openAPISpec is what ratecard needs from an OpenAPI spec.
+type openAPISpec struct {
+	Title      string
+	Server     string
+	Operations []apiOperation
+}
+
+// parseOpenAPI reads the title, first server, and operations of a JSON or YAML OpenAPI spec.
+// Operations are sorted by path, then in the spec's method order.
+func parseOpenAPI(src string) (*openAPISpec, error) {
+	var api *openAPISpec
+	var err error
+	if strings.HasPrefix(strings.TrimSpace(src), "{") {
+		api, err = parseOpenAPIJSON(src)
+	} else {
+		api, err = parseOpenAPIYAML(src)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(api.Operations) == 0 {
+		return nil, fmt.Errorf("no operations under paths")
+	}
+	sort.SliceStable(api.Operations, func(i, j int) bool { return api.Operations[i].Path < api.Operations[j].Path })
+	return api, nil
+}
+
+func parseOpenAPIJSON(src string) (*openAPISpec, error) {
+	var doc struct {
+		Info struct {
+			Title string `json:"title"`
+		} `json:"info"`
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal([]byte(src), &doc); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI spec: %w", err)
+	}
+	api := &openAPISpec{Title: doc.Info.Title}
+	if len(doc.Servers) > 0 {
+		api.Server = doc.Servers[0].URL
+	}
+	for path, item := range doc.Paths {
+		for _, m := range openAPIMethods {
+			raw, ok := item[m]
+			if !ok {
+				continue
+			}
+			var op struct {
+				Summary string `json:"summary"`
+			}
+			json.Unmarshal(raw, &op)
+			api.Operations = append(api.Operations, apiOperation{Path: path, Method: strings.ToUpper(m), Summary: op.Summary})
+		}
+	}
+	return api, nil
+}
+
+// parseOpenAPIYAML understands the block-style YAML OpenAPI specs are written in: info.title,
+// the url of the first servers item, and the method keys (with their summary) of each path.
+func parseOpenAPIYAML(src string) (*openAPISpec, error) {
+	api := &openAPISpec{}
+	var section, path string
+	var current *apiOperation
+	pathIndent, methodIndent, fieldIndent := -1, -1, -1
+
+	scanner := bufio.NewScanner(strings.NewReader(src))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		key, value, _ := strings.Cut(strings.TrimPrefix(trimmed, "- "), ":")
+		key, value = yamlScalar(key), strings.TrimSpace(value)
+
+		if indent == 0 {
+			section = key
+			continue
+		}
+		switch section {
+		case "info":
+			if key == "title" && api.Title == "" {
+				api.Title = yamlScalar(value)
+			}
+		case "servers":
+			if key == "url" && api.Server == "" {
+				api.Server = yamlScalar(value)
+			}
+		case "paths":
+			if pathIndent < 0 {
+				pathIndent = indent
+			}
+			switch {
+			case indent == pathIndent:
+				if !strings.HasPrefix(key, "/") {
+					return nil, fmt.Errorf("line %d: expected a path, found %q", n, key)
+				}
+				path, current, methodIndent = key, nil, -1
+			case path == "":
+				return nil, fmt.Errorf("line %d: expected a path", n)
+			case methodIndent < 0 || indent == methodIndent:
+				methodIndent, fieldIndent = indent, -1
+				current = nil
+				if m := strings.ToLower(key); slices.Contains(openAPIMethods, m) {
+					api.Operations = append(api.Operations, apiOperation{Path: path, Method: strings.ToUpper(m)})
+					current = &api.Operations[len(api.Operations)-1]
+				}
+			case current != nil:
+				// Only the operation's own summary, not one nested in its examples.
+				if fieldIndent < 0 {
+					fieldIndent = indent
+				}
+				if indent == fieldIndent && key == "summary" {
+					current.Summary = yamlScalar(value)
+				}
+			}
+		}
+	}
+	return api, scanner.Err()
+}
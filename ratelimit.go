@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultRetryAfter is the wait used when a 429 response has no usable Retry-After.
+const defaultRetryAfter = time.Second
+
+// rateLimitInfo describes the last 429 Too Many Requests response and any waiting done for it.
+type rateLimitInfo struct {
+	// Step is "probe" or "payment": the request that was rate limited.
+	Step string `json:"step"`
+	// RetryAfter is the raw Retry-After header (seconds or an HTTP date).
+	RetryAfter string `json:"retryAfter,omitempty"`
+	// Limit, Remaining, and Reset are the RateLimit-* (or X-RateLimit-*) headers, as sent.
+	Limit     string `json:"limit,omitempty"`
+	Remaining string `json:"remaining,omitempty"`
+	Reset     string `json:"reset,omitempty"`
+	// Retries counts requests repeated after waiting; WaitedMs is the total time waited.
+	Retries  int   `json:"retries"`
+	WaitedMs int64 `json:"waitedMs"`
+}
+
+// newRateLimitInfo records the rate-limit headers of a 429 response.
+func newRateLimitInfo(step string, resp *http.Response) *rateLimitInfo {
+	header := func(name string) string {
+		if v := resp.Header.Get("RateLimit-" + name); v != "" {
+			return v
+		}
+		return resp.Header.Get("X-RateLimit-" + name)
+	}
+	return &rateLimitInfo{
+		Step:       step,
+		RetryAfter: resp.Header.Get("Retry-After"),
+		Limit:      header("Limit"),
+		Remaining:  header("Remaining"),
+		Reset:      header("Reset"),
+	}
+}
+
+// parseRetryAfter reads a Retry-After value: delay seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// rateLimitWait returns how long to wait before retrying a 429 response, and false when
+// that wait would take the total past maxWait.
+func rateLimitWait(resp *http.Response, waited, maxWait time.Duration, now time.Time) (time.Duration, bool) {
+	wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	if !ok {
+		wait = defaultRetryAfter
+	}
+	return wait, waited+wait <= maxWait
+}
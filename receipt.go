@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// receipt is the evidence of one accepted payment that the CLI signs with the paying key.
+type receipt struct {
+	Version     int       `json:"version"`
+	ID          string    `json:"id"`
+	RequestID   string    `json:"requestId,omitempty"`
+	Time        time.Time `json:"time"`
+	Endpoint    string    `json:"endpoint"`
+	Method      string    `json:"method"`
+	BodySHA256  string    `json:"bodySha256"`
+	Network     string    `json:"network"`
+	Asset       string    `json:"asset"`
+	Amount      string    `json:"amount"`
+	PayTo       string    `json:"payTo"`
+	Transaction string    `json:"transaction,omitempty"`
+	Signer      string    `json:"signer"`
+}
+
+// signedReceipt is a receipt and its EIP-191 (personal_sign) signature over the compact
+// JSON of the receipt's fields, in the order above.
+type signedReceipt struct {
+	receipt
+	Signature string `json:"signature"`
+}
+
+// receiptCheck is the result of `receipt check` for one file.
+type receiptCheck struct {
+	File      string `json:"file"`
+	Valid     bool   `json:"valid"`
+	ID        string `json:"id,omitempty"`
+	Signer    string `json:"signer,omitempty"`
+	Amount    string `json:"amount,omitempty"`
+	Price     string `json:"price,omitempty"`
+	Endpoint  string `json:"endpoint,omitempty"`
+	Recovered string `json:"recovered,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// message is what the signature covers.
+func (r receipt) message() []byte {
+	out, _ := json.Marshal(r)
+	return out
+}
+
+// newReceipt builds and signs the receipt of an accepted payment from its ledger record.
+func newReceipt(rec historyRecord) (*signedReceipt, error) {
+	key, err := loadProfileKey(routedProfile(rec.Network))
+	if err != nil {
+		return nil, err
+	}
+	r := receipt{
+		Version:     1,
+		ID:          rec.ID,
+		RequestID:   rec.RequestID,
+		Time:        rec.Time.UTC(),
+		Endpoint:    rec.Endpoint,
+		Method:      rec.Method,
+		BodySHA256:  rec.BodySHA256,
+		Network:     rec.Network,
+		Asset:       rec.Asset,
+		Amount:      rec.Amount,
+		PayTo:       checksumAddress(rec.PayTo),
+		Transaction: rec.Transaction,
+		Signer:      crypto.PubkeyToAddress(key.PublicKey).Hex(),
+	}
+	sig, err := crypto.Sign(accounts.TextHash(r.message()), key)
+	if err != nil {
+		return nil, err
+	}
+	sig[64] += 27 // personal_sign's v
+	return &signedReceipt{receipt: r, Signature: "0x" + hex.EncodeToString(sig)}, nil
+}
+
+// verify returns the address that signed the receipt, and an error unless it is the receipt's signer.
+func (s signedReceipt) verify() (string, error) {
+	sig, err := hex.DecodeString(strings.TrimPrefix(s.Signature, "0x"))
+	if err != nil || len(sig) != 65 {
+		return "", errors.New("malformed signature")
+	}
+	if sig[64] >= 27 {
+		sig[64] -= 27
+	}
+	pub, err := crypto.SigToPub(accounts.TextHash(s.message()), sig)
+	if err != nil {
+		return "", fmt.Errorf("bad signature: %w", err)
+	}
+	recovered := crypto.PubkeyToAddress(*pub).Hex()
+	if !common.IsHexAddress(s.Signer) || common.HexToAddress(s.Signer).Hex() != recovered {
+		return recovered, fmt.Errorf("signed by %s, not the receipt's signer %s", recovered, s.Signer)
+	}
+	return recovered, nil
+}
+
+// saveReceipt writes the receipt to receipts/<id>.json in the config directory.
+func saveReceipt(r *signedReceipt) (string, error) {
+	path, err := stateFile(filepath.Join("receipts", r.ID+".json"))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	out, _ := json.MarshalIndent(r, "", "  ")
+	return path, os.WriteFile(path, append(out, '\n'), 0600)
+}
+
+// issueReceipt signs and saves the receipt of an accepted payment, returning it and its path.
+func issueReceipt(rec historyRecord) (*signedReceipt, string, error) {
+	r, err := newReceipt(rec)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot sign receipt: %w", err)
+	}
+	path, err := saveReceipt(r)
+	if err != nil {
+		return r, "", fmt.Errorf("cannot save receipt: %w", err)
+	}
+	return r, path, nil
+}
+
+// checkReceipt verifies one receipt file ("-" reads stdin). A non-empty signer must match.
+func checkReceipt(file, signer string) receiptCheck {
+	c := receiptCheck{File: file}
+	var raw []byte
+	var err error
+	if file == "-" {
+		raw, err = io.ReadAll(os.Stdin)
+	} else {
+		raw, err = os.ReadFile(file)
+	}
+	if err != nil {
+		c.Error = err.Error()
+		return c
+	}
+	var s signedReceipt
+	if err := json.Unmarshal(raw, &s); err != nil {
+		c.Error = "not a receipt: " + err.Error()
+		return c
+	}
+	if s.Version != 1 {
+		c.Error = fmt.Sprintf("unsupported receipt version %d", s.Version)
+		return c
+	}
+	c.ID, c.Signer, c.Amount, c.Endpoint = s.ID, s.Signer, s.Amount, s.Endpoint
+	c.Price = describeAmount(x402.PaymentRequirements{Network: s.Network, Asset: s.Asset, Amount: s.Amount})
+	if c.Recovered, err = s.verify(); err != nil {
+		c.Error = err.Error()
+		return c
+	}
+	if signer != "" && !strings.EqualFold(signer, c.Recovered) {
+		c.Error = fmt.Sprintf("signed by %s, not %s", c.Recovered, signer)
+		return c
+	}
+	c.Valid = true
+	return c
+}
+
+// runReceiptCmd dispatches the receipt subcommands.
+func runReceiptCmd(args []string) {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli receipt check [--signer 0x...] [--json] <receipt.json>...\n\n")
+		fmt.Fprintf(os.Stderr, "Accepted payments are receipted in receipts/ in the config directory.\n")
+		os.Exit(ExitError)
+	}
+	fs := flag.NewFlagSet("receipt check", flag.ExitOnError)
+	var (
+		signer  string
+		jsonOut bool
+	)
+	fs.StringVar(&signer, "signer", "", "Also require the receipt to be signed by this address")
+	fs.BoolVar(&jsonOut, "json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli receipt check [--signer 0x...] [--json] <receipt.json>...\n\n")
+		fmt.Fprintf(os.Stderr, "Verifies payment receipts signed by x402-cli (\"-\" reads one from stdin). A receipt is valid\n")
+		fmt.Fprintf(os.Stderr, "when its signature recovers to its signer, the wallet that paid. Exits 1 if any is invalid.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	if signer != "" {
+		if _, err := parseAddress(signer, "--signer"); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitError)
+		}
+	}
+
+	code := ExitSuccess
+	var checks []receiptCheck
+	for _, file := range fs.Args() {
+		c := checkReceipt(file, signer)
+		if !c.Valid {
+			code = ExitError
+		}
+		checks = append(checks, c)
+	}
+	if jsonOut {
+		printJSON(checks)
+		os.Exit(code)
+	}
+	for _, c := range checks {
+		if c.Valid {
+			fmt.Printf("%s: valid, payment %s of %s to %s signed by %s\n", c.File, c.ID, c.Price, c.Endpoint, c.Recovered)
+		} else {
+			fmt.Printf("%s: INVALID: %s\n", c.File, c.Error)
+		}
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// recordTags are the --tag metadata stored with every history record of this invocation,
+// for attributing spend to jobs and teams that share a wallet.
+var recordTags map[string]string
+
+// tagKey is the form of a tag name: letters, digits, and . _ - (no = or ,).
+var tagKey = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// tagsByEnv is the default for --tag: $X402_TAGS, comma-separated key=value pairs.
+func tagsByEnv() headerFlags {
+	var tags headerFlags
+	for _, t := range strings.Split(os.Getenv("X402_TAGS"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// parseTags parses --tag key=value flags; a later value of a key replaces an earlier one,
+// so a --tag overrides the same key from $X402_TAGS.
+func parseTags(specs []string) (map[string]string, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	tags := map[string]string{}
+	for _, spec := range specs {
+		key, value, ok := strings.Cut(spec, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("--tag %q: want key=value", spec)
+		}
+		if !tagKey.MatchString(key) {
+			return nil, fmt.Errorf("--tag %q: the key may only contain letters, digits, '.', '_', and '-'", spec)
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+// copyTags returns a copy of tags, so records do not share the invocation's map.
+func copyTags(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(tags))
+	for k, v := range tags {
+		out[k] = v
+	}
+	return out
+}
+
+// formatTags renders tags as "key=value,..." in key order.
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// tagFilter selects history records by tag for `history` and `history report`: every
+// key=value must match, and a bare key matches any record that has the tag.
+type tagFilter []string
+
+// match reports whether rec has every tag of the filter.
+func (f tagFilter) match(rec historyRecord) bool {
+	for _, spec := range f {
+		key, value, hasValue := strings.Cut(spec, "=")
+		got, ok := rec.Tags[key]
+		if !ok || (hasValue && got != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// filter keeps the records that match f.
+func (f tagFilter) filter(records []historyRecord) []historyRecord {
+	if len(f) == 0 {
+		return records
+	}
+	var kept []historyRecord
+	for _, r := range records {
+		if f.match(r) {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// redactLevel is --redact: what is left out of output meant for shared logs.
+type redactLevel int
+
+const (
+	redactOff redactLevel = iota
+	// redactStandard truncates addresses, drops bodies, hides credential and payment
+	// headers, and removes URL query strings; amounts, networks, and statuses are kept.
+	redactStandard
+	// redactStrict also reduces URLs to their origin and truncates transaction hashes,
+	// nonces, and other 32-byte values.
+	redactStrict
+)
+
+// redaction is the run's --redact level.
+var redaction redactLevel
+
+// redactByEnv is the default for --redact.
+func redactByEnv() string {
+	return os.Getenv("X402_REDACT")
+}
+
+// parseRedact parses --redact: "", "off", "standard", or "strict".
+func parseRedact(s string) (redactLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "off", "none":
+		return redactOff, nil
+	case "standard":
+		return redactStandard, nil
+	case "strict":
+		return redactStrict, nil
+	}
+	return redactOff, fmt.Errorf("invalid --redact %q: want standard or strict", s)
+}
+
+var (
+	addressPattern = regexp.MustCompile(`0x[0-9a-fA-F]{40}\b`)
+	hashPattern    = regexp.MustCompile(`0x[0-9a-fA-F]{64}\b`)
+	urlPattern     = regexp.MustCompile(`https?://[^\s"'<>]+`)
+)
+
+// redactText applies the redaction level to free text: addresses become 0x1234…abcd,
+// URLs lose their query string (strict: everything after the origin), and under strict
+// 32-byte hex values (transaction hashes, nonces) are shortened the same way.
+func redactText(s string) string {
+	if redaction == redactOff {
+		return s
+	}
+	if redaction == redactStrict {
+		s = hashPattern.ReplaceAllStringFunc(s, shortHex)
+	}
+	s = addressPattern.ReplaceAllStringFunc(s, shortHex)
+	return urlPattern.ReplaceAllStringFunc(s, redactURL)
+}
+
+// shortHex keeps the first and last four hex digits of a hex value.
+func shortHex(h string) string {
+	return h[:6] + "…" + h[len(h)-4:]
+}
+
+// redactURL removes a URL's query string and fragment, and under strict its path.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.RawQuery, u.Fragment, u.User = "", "", nil
+	if redaction == redactStrict {
+		u.Path, u.RawPath = "", ""
+	}
+	return u.String()
+}
+
+// redactBody describes a body that redaction leaves out.
+func redactBody(body []byte) string {
+	return fmt.Sprintf("[redacted: %d bytes]", len(body))
+}
+
+// bodyFields are the JSON output fields that hold request or response bodies.
+var bodyFields = map[string]bool{"body": true}
+
+// redactJSON applies the redaction level to a JSON output value: body fields are
+// dropped and every string goes through redactText.
+func redactJSON(v any) any {
+	if redaction == redactOff {
+		return v
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var tree any
+	if json.Unmarshal(raw, &tree) != nil {
+		return v
+	}
+	return redactTree(tree)
+}
+
+func redactTree(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if bodyFields[k] {
+				if s, ok := child.(string); ok {
+					v[k] = redactBody([]byte(s))
+				}
+				continue
+			}
+			v[k] = redactTree(child)
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = redactTree(child)
+		}
+	case string:
+		return redactText(v)
+	}
+	return v
+}
+
+// redactHeaderValues returns a copy of h with credential and payment headers hidden, the
+// base64 x402 headers (shown decoded elsewhere) left out, and the rest redacted as text.
+func redactHeaderValues(h http.Header) http.Header {
+	out := redactHeaders(h)
+	for name, vals := range out {
+		if isPaymentHeader(name) {
+			out[name] = []string{"[redacted]"}
+			continue
+		}
+		if isEncodedHeader(name) {
+			for i, v := range vals {
+				vals[i] = fmt.Sprintf("[base64: %d bytes]", len(v))
+			}
+			continue
+		}
+		for i, v := range vals {
+			vals[i] = redactText(v)
+		}
+	}
+	return out
+}
+
+// isPaymentHeader reports whether name carries a signed payment, which is a bearer authorization.
+func isPaymentHeader(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Payment-Signature", "X-Payment":
+		return true
+	}
+	return false
+}
+
+// isEncodedHeader reports whether name is a base64 x402 header, which hides its addresses
+// from redactText.
+func isEncodedHeader(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Payment-Required", "Payment-Response", "X-Payment-Response":
+		return true
+	}
+	return false
+}
+
+// shownBody is a body as human output shows it: its first n bytes (all of it when n is
+// negative) with a note of what was cut, or a note under redaction.
+func shownBody(body []byte, n int) string {
+	if redaction != redactOff {
+		return redactBody(body)
+	}
+	if n < 0 || len(body) <= n {
+		return string(body)
+	}
+	return fmt.Sprintf("%s... [%d more bytes; --max-body-print or --full-body shows more]", truncate(string(body), n), len(body)-n)
+}
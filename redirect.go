@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxRedirects matches net/http's default redirect limit.
+const maxRedirects = 10
+
+// redirectHop is one redirect followed (or refused) during a request.
+type redirectHop struct {
+	// Step is "probe" or "payment".
+	Step        string `json:"step"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	StatusCode  int    `json:"statusCode"`
+	CrossOrigin bool   `json:"crossOrigin"`
+	Followed    bool   `json:"followed"`
+}
+
+// redirectPolicy decides which redirects to follow. Same-origin redirects are always
+// followed; a redirect to another origin is followed only when trusted or confirmed,
+// because the x402 client would otherwise pay whatever the new origin asks for.
+type redirectPolicy struct {
+	trust bool
+	// none refuses every redirect, same-origin ones too (--sandbox).
+	none bool
+	// confirm asks whether to follow a cross-origin redirect; nil refuses them.
+	confirm  func(from, to string) bool
+	approved map[string]bool
+	hops     *[]redirectHop
+}
+
+func newRedirectPolicy(trust bool, confirm func(from, to string) bool, hops *[]redirectHop) *redirectPolicy {
+	return &redirectPolicy{trust: trust, confirm: confirm, approved: map[string]bool{}, hops: hops}
+}
+
+// checkRedirect returns an http.Client CheckRedirect function for one step.
+func (p *redirectPolicy) checkRedirect(step string) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return errors.New("stopped after 10 redirects")
+		}
+		prev := via[len(via)-1]
+		hop := redirectHop{Step: step, From: prev.URL.String(), To: req.URL.String()}
+		if req.Response != nil {
+			hop.StatusCode = req.Response.StatusCode
+		}
+		from, to := urlOrigin(via[0].URL), urlOrigin(req.URL)
+		hop.CrossOrigin = from != to
+		hop.Followed = !p.none && (!hop.CrossOrigin || p.trust || p.approved[to])
+		if !hop.Followed && !p.none && p.confirm != nil && p.confirm(from, to) {
+			p.approved[to] = true
+			hop.Followed = true
+		}
+		*p.hops = append(*p.hops, hop)
+		if !hop.Followed {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+}
+
+// blocked returns the last refused redirect, if any.
+func (p *redirectPolicy) blocked() *redirectHop {
+	hops := *p.hops
+	if len(hops) > 0 && !hops[len(hops)-1].Followed {
+		return &hops[len(hops)-1]
+	}
+	return nil
+}
+
+// urlOrigin is the scheme://host[:port] of u.
+func urlOrigin(u *url.URL) string {
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// confirmRedirect asks on stdin whether to follow a cross-origin redirect.
+func confirmRedirect(from, to string) bool {
+	return confirmPrompt(fmt.Sprintf("\n%s redirects to another origin, %s.\nFollow it (and pay it if it asks for payment)? [y/N] ", from, to))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// rejectionInfo is a facilitator's rejection reason normalized across facilitators and SDK
+// versions, which word the same failure differently ("invalid_exact_evm_payload_signature",
+// "signature_invalid", "Invalid signature").
+type rejectionInfo struct {
+	// Code is one of the rejectionRules codes, or "unknown".
+	Code string `json:"code"`
+	// Message explains the failure and what to do about it.
+	Message string `json:"message"`
+}
+
+// rejectionRules map reason substrings (lowercase) to normalized codes. The first match wins,
+// so specific reasons come before the generic ones they contain (e.g. "..._payload_signature").
+var rejectionRules = []struct {
+	code    string
+	match   []string
+	message string
+}{
+	{"insufficient_funds", []string{"insufficient_funds", "insufficient_balance", "insufficient funds", "insufficient balance"},
+		"the wallet does not hold enough of the asset; fund it and retry (x402-cli wallet shows balances)"},
+	{"nonce_used", []string{"nonce_already_used", "nonce already used", "authorization is used"},
+		"the authorization's nonce was already used: the payment was replayed or already settled; retry to sign a fresh one"},
+	{"expired", []string{"valid_before", "expired"},
+		"the authorization expired before the facilitator checked it; check the local clock and retry"},
+	{"not_yet_valid", []string{"valid_after", "not yet valid"},
+		"the authorization is not valid yet; the local clock may be ahead of the chain's"},
+	{"invalid_signature", []string{"signature"},
+		"the facilitator could not verify the signature; the signing domain (token name, version, chain ID) may not match the asset (compare with x402-cli sign-typed-data)"},
+	{"recipient_mismatch", []string{"recipient_mismatch", "payto"},
+		"the signed recipient does not match the server's payTo"},
+	{"amount_mismatch", []string{"authorization_value", "insufficient_amount", "client_amount", "required_amount", "amount mismatch"},
+		"the signed amount does not match what the server requires"},
+	{"unsupported_network", []string{"network_mismatch", "unsupported_network", "no_facilitator_for_network", "unsupported network"},
+		"the facilitator does not support this network, or the payment was signed for another one"},
+	{"unsupported_scheme", []string{"scheme_mismatch", "unsupported_scheme", "invalid_exact_evm_scheme", "unsupported scheme"},
+		"the facilitator does not support this payment scheme"},
+	{"undeployed_wallet", []string{"undeployed_smart_wallet"},
+		"the paying smart wallet is not deployed on this network yet"},
+	{"facilitator_error", []string{"facilitator", "settlement failed", "settlement_failed", "transaction_failed", "failed_to_", "transaction_state"},
+		"the facilitator failed to verify or settle the payment; the payment itself may be valid, retry later"},
+	{"invalid_payload", []string{"invalid_payment", "payload", "invalid_version"},
+		"the facilitator could not parse the payment payload"},
+}
+
+// normalizeRejection classifies a rejection reason as reported by the server or facilitator.
+func normalizeRejection(reason string) *rejectionInfo {
+	if strings.TrimSpace(reason) == "" {
+		return &rejectionInfo{Code: "unknown", Message: "the payment was rejected without a reason; check the wallet balance and the facilitator's logs"}
+	}
+	r := strings.ToLower(reason)
+	for _, rule := range rejectionRules {
+		for _, m := range rule.match {
+			if strings.Contains(r, m) {
+				return &rejectionInfo{Code: rule.code, Message: rule.message}
+			}
+		}
+	}
+	return &rejectionInfo{Code: "unknown", Message: "unrecognized reason " + strconv.Quote(reason)}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// reportWriters render batch results for --report.
+var reportWriters = map[string]func(io.Writer, []batchResult) error{
+	"csv":  writeCSVReport,
+	"md":   writeMarkdownReport,
+	"html": writeHTMLReport,
+}
+
+// csvReportHeader lists the columns of a CSV batch report.
+var csvReportHeader = []string{"name", "url", "method", "status", "status_code", "price", "amount", "asset", "network", "latency_ms", "transaction", "error"}
+
+// writeCSVReport writes one row per endpoint, for spreadsheets and accounting imports.
+func writeCSVReport(w io.Writer, results []batchResult) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvReportHeader)
+	for _, r := range results {
+		cw.Write([]string{
+			r.Name, r.URL, r.Method, r.Status, fmt.Sprint(r.StatusCode), r.Price, r.Amount,
+			r.Asset, r.Network, fmt.Sprint(r.LatencyMs), r.Transaction, r.Error,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// reportPassed reports whether an endpoint counts as passing: paid, free, or (with --dry-run) priced.
+func reportPassed(r batchResult) bool {
+	return r.Status == "accepted" || r.Status == "free" || r.Status == "payment_required"
+}
+
+// spendTotal is the amount paid (or, with --dry-run, advertised) in one asset on one network.
+type spendTotal struct {
+	Network string `json:"network"`
+	Asset   string `json:"asset"`
+	Amount  string `json:"amount"` // atomic units
+}
+
+// Display is the total in token units.
+func (t spendTotal) Display() string {
+	return describeAmount(x402.PaymentRequirements{Network: t.Network, Asset: t.Asset, Amount: t.Amount})
+}
+
+// spendTotals sums the amounts of results with the given status per network and asset:
+// "accepted" for what was spent, "payment_required" for what a dry run would spend.
+func spendTotals(results []batchResult, status string) []spendTotal {
+	sums := map[[2]string]*big.Int{}
+	for _, r := range results {
+		amount, ok := new(big.Int).SetString(r.Amount, 10)
+		// A result --dedupe reused paid nothing itself.
+		if r.Status != status || !ok || r.DuplicateOf != "" {
+			continue
+		}
+		key := [2]string{r.chainID, strings.ToLower(r.Asset)}
+		if sums[key] == nil {
+			sums[key] = new(big.Int)
+		}
+		sums[key].Add(sums[key], amount)
+	}
+	totals := make([]spendTotal, 0, len(sums))
+	for key, sum := range sums {
+		totals = append(totals, spendTotal{Network: key[0], Asset: key[1], Amount: sum.String()})
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		if totals[i].Network != totals[j].Network {
+			return totals[i].Network < totals[j].Network
+		}
+		return totals[i].Asset < totals[j].Asset
+	})
+	return totals
+}
+
+// writeMarkdownReport renders a report suitable for pasting into a PR or issue.
+func writeMarkdownReport(w io.Writer, results []batchResult) error {
+	cell := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+	}
+	passed := 0
+	for _, r := range results {
+		if reportPassed(r) {
+			passed++
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# x402-cli batch report\n\n")
+	fmt.Fprintf(&b, "Generated %s by x402-cli %s. **%d of %d endpoints passed.**\n\n",
+		time.Now().UTC().Format(time.RFC3339), version, passed, len(results))
+	fmt.Fprintf(&b, "| Result | Endpoint | Method | Status | Price | Network | Latency | Transaction |\n")
+	fmt.Fprintf(&b, "|--------|----------|--------|--------|-------|---------|---------|-------------|\n")
+	for _, r := range results {
+		result := "FAIL"
+		if reportPassed(r) {
+			result = "pass"
+		}
+		status := r.Status
+		if r.Error != "" {
+			status += ": " + r.Error
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s | %dms | %s |\n", result, cell(r.URL), r.Method,
+			cell(status), cell(r.Price), cell(r.Network), r.LatencyMs, cell(r.Transaction))
+	}
+
+	fmt.Fprintf(&b, "\n## Total spend\n\n")
+	totals := spendTotals(results, "accepted")
+	if len(totals) == 0 {
+		fmt.Fprintf(&b, "Nothing was paid.\n")
+	}
+	for _, t := range totals {
+		fmt.Fprintf(&b, "- %s on %s\n", t.Display(), networkName(t.Network))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// htmlReport is a self-contained page with the same content as the Markdown report.
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"passed":      reportPassed,
+	"networkName": networkName,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>x402-cli batch report</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3rem 0.6rem; text-align: left; }
+.pass { color: #1a7f37; } .fail { color: #cf222e; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>x402-cli batch report</h1>
+<p>Generated {{.Generated}} by x402-cli {{.Version}}. <strong>{{.Passed}} of {{len .Results}} endpoints passed.</strong></p>
+<table>
+<tr><th>Result</th><th>Endpoint</th><th>Method</th><th>Status</th><th>Price</th><th>Network</th><th>Latency</th><th>Transaction</th></tr>
+{{- range .Results}}
+<tr>{{if passed .}}<td class="pass">pass</td>{{else}}<td class="fail">FAIL</td>{{end}}<td>{{.URL}}</td><td>{{.Method}}</td><td>{{.Status}}{{if .Error}}: {{.Error}}{{end}}</td><td>{{or .Price "-"}}</td><td>{{or .Network "-"}}</td><td>{{.LatencyMs}}ms</td><td>{{or .Transaction "-"}}</td></tr>
+{{- end}}
+</table>
+<h2>Total spend</h2>
+{{- if .Totals}}
+<ul>
+{{- range .Totals}}
+<li>{{.Display}} on {{networkName .Network}}</li>
+{{- end}}
+</ul>
+{{- else}}
+<p>Nothing was paid.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+// writeHTMLReport renders a standalone HTML report for sending to API providers.
+func writeHTMLReport(w io.Writer, results []batchResult) error {
+	passed := 0
+	for _, r := range results {
+		if reportPassed(r) {
+			passed++
+		}
+	}
+	return htmlReport.Execute(w, struct {
+		Generated string
+		Version   string
+		Passed    int
+		Results   []batchResult
+		Totals    []spendTotal
+	}{time.Now().UTC().Format(time.RFC3339), version, passed, results, spendTotals(results, "accepted")})
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// signRequests adds a requestProof to every ledger record of a paid request (--sign-requests).
+var signRequests = signRequestsByEnv()
+
+// signRequestsByEnv is the default for --sign-requests: X402_SIGN_REQUESTS set to 1 or true.
+func signRequestsByEnv() bool {
+	v := strings.ToLower(os.Getenv("X402_SIGN_REQUESTS"))
+	return v == "1" || v == "true"
+}
+
+// requestProof is the paying wallet's signature over what a paid request asked for, so the
+// request can be proven to a provider who disputes it.
+type requestProof struct {
+	// PaymentSHA256 is the hex SHA-256 of the payment header that was sent.
+	PaymentSHA256 string `json:"paymentSha256"`
+	Signer        string `json:"signer"`
+	// Signature is EIP-191 (personal_sign) over requestMessage.
+	Signature string `json:"signature"`
+}
+
+// requestMessage is what a requestProof signs: the compact JSON of the request's method,
+// URL, body hash, and payment header hash, in that order.
+func requestMessage(method, endpoint, bodySHA256, paymentSHA256 string) []byte {
+	out, _ := json.Marshal(struct {
+		Version       int    `json:"version"`
+		Method        string `json:"method"`
+		URL           string `json:"url"`
+		BodySHA256    string `json:"bodySha256"`
+		PaymentSHA256 string `json:"paymentSha256"`
+	}{1, method, endpoint, bodySHA256, paymentSHA256})
+	return out
+}
+
+// newRequestProof signs rec's request, with paymentHeader as sent, using the key that paid.
+func newRequestProof(rec historyRecord, paymentHeader string) (*requestProof, error) {
+	key, err := loadProfileKey(routedProfile(rec.Network))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(paymentHeader))
+	p := &requestProof{PaymentSHA256: hex.EncodeToString(sum[:]), Signer: crypto.PubkeyToAddress(key.PublicKey).Hex()}
+	sig, err := crypto.Sign(accounts.TextHash(requestMessage(rec.Method, rec.Endpoint, rec.BodySHA256, p.PaymentSHA256)), key)
+	if err != nil {
+		return nil, err
+	}
+	sig[64] += 27 // personal_sign's v
+	p.Signature = "0x" + hex.EncodeToString(sig)
+	return p, nil
+}
+
+// verifyRequestProof returns the address that signed rec's proof, and an error unless it
+// is the proof's signer.
+func verifyRequestProof(rec historyRecord) (string, error) {
+	p := rec.Proof
+	if p == nil {
+		return "", errors.New("the payment has no request proof (it was made without --sign-requests)")
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(p.Signature, "0x"))
+	if err != nil || len(sig) != 65 {
+		return "", errors.New("malformed signature")
+	}
+	if sig[64] >= 27 {
+		sig[64] -= 27
+	}
+	pub, err := crypto.SigToPub(accounts.TextHash(requestMessage(rec.Method, rec.Endpoint, rec.BodySHA256, p.PaymentSHA256)), sig)
+	if err != nil {
+		return "", fmt.Errorf("bad signature: %w", err)
+	}
+	recovered := crypto.PubkeyToAddress(*pub).Hex()
+	if !common.IsHexAddress(p.Signer) || common.HexToAddress(p.Signer).Hex() != recovered {
+		return recovered, fmt.Errorf("signed by %s, not the proof's signer %s", recovered, p.Signer)
+	}
+	return recovered, nil
+}
+
+// proofCheck is the JSON output of `history verify`.
+type proofCheck struct {
+	ID            string `json:"id"`
+	Valid         bool   `json:"valid"`
+	Method        string `json:"method,omitempty"`
+	Endpoint      string `json:"endpoint,omitempty"`
+	BodySHA256    string `json:"bodySha256,omitempty"`
+	PaymentSHA256 string `json:"paymentSha256,omitempty"`
+	Signer        string `json:"signer,omitempty"`
+	Signature     string `json:"signature,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// runHistoryVerifyCmd checks the request proof of a ledger record.
+func runHistoryVerifyCmd(args []string) {
+	fs := flag.NewFlagSet("history verify", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli history verify [--json] <payment id>\n\n")
+		fmt.Fprintf(os.Stderr, "Verifies the request proof of a payment made with --sign-requests: the paying wallet's\n")
+		fmt.Fprintf(os.Stderr, "signature over the method, URL, body SHA-256, and payment header SHA-256. Share the\n")
+		fmt.Fprintf(os.Stderr, "--json output with a provider to settle what was actually requested; they can hash the\n")
+		fmt.Fprintf(os.Stderr, "body and payment header they received and compare. Exits 1 unless the proof is valid.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	id := strings.ToLower(strings.TrimSpace(fs.Arg(0)))
+	if id == "" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	check := &proofCheck{ID: id}
+	done := func() {
+		if *jsonOut {
+			printJSON(check)
+		} else if check.Valid {
+			fmt.Printf("Valid request proof for %s: %s %s\n", check.ID, check.Method, redactText(check.Endpoint))
+			fmt.Printf("  body sha256:    %s\n  payment sha256: %s\n  signed by:      %s\n", check.BodySHA256, check.PaymentSHA256, redactText(check.Signer))
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", check.Error)
+		}
+		if !check.Valid {
+			os.Exit(ExitError)
+		}
+	}
+
+	records, err := loadHistory()
+	if err != nil {
+		check.Error = err.Error()
+		done()
+	}
+	var found *historyRecord
+	for i, r := range records {
+		if r.ID != "" && strings.HasPrefix(r.ID, id) {
+			if found != nil && found.ID != r.ID {
+				check.Error = fmt.Sprintf("payment ID %q is ambiguous; use more characters", id)
+				done()
+			}
+			found = &records[i]
+		}
+	}
+	if found == nil {
+		check.Error = fmt.Sprintf("no payment with ID %q in the history", id)
+		done()
+	}
+	check.ID, check.Method, check.Endpoint, check.BodySHA256 = found.ID, found.Method, found.Endpoint, found.BodySHA256
+	if found.Proof != nil {
+		check.PaymentSHA256, check.Signer, check.Signature = found.Proof.PaymentSHA256, found.Proof.Signer, found.Proof.Signature
+	}
+	if _, err := verifyRequestProof(*found); err != nil {
+		check.Error = err.Error()
+	} else {
+		check.Valid = true
+	}
+	done()
+}
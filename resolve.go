@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// resolveResult is the JSON output for `x402-cli resolve`.
+type resolveResult struct {
+	ID       string         `json:"id"`
+	Status   string         `json:"status"` // "accepted" (settled), "pending", "unsettled", or the recorded status
+	Settled  bool           `json:"settled"`
+	Payment  *historyRecord `json:"payment,omitempty"`
+	Error    string         `json:"error,omitempty"`
+	Resolved bool           `json:"resolved"` // the ledger record was updated by this check
+}
+
+// runResolveCmd checks on-chain whether a pending payment settled and updates the ledger.
+func runResolveCmd(args []string) {
+	fs := flag.NewFlagSet("resolve", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli resolve [--json] <payment id>\n\n")
+		fmt.Fprintf(os.Stderr, "Checks whether a payment recorded as pending (the paid request timed out) settled,\n")
+		fmt.Fprintf(os.Stderr, "by reading its EIP-3009 authorization nonce on-chain, and updates the history ledger.\n")
+		fmt.Fprintf(os.Stderr, "Payment IDs are shown by `x402-cli history` and as paymentId in --json output.\n")
+		fmt.Fprintf(os.Stderr, "Exits 0 when settled, 7 while still pending, and 2 when the authorization expired unused.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	id := strings.ToLower(strings.TrimSpace(fs.Arg(0)))
+	if id == "" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	result := &resolveResult{ID: id}
+	fail := func(msg string) {
+		if *jsonOut {
+			result.Status = "error"
+			result.Error = msg
+			printJSON(result)
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", msg)
+		}
+		os.Exit(ExitError)
+	}
+
+	records, err := loadHistory()
+	if err != nil {
+		fail(err.Error())
+	}
+	idx := -1
+	for i, r := range records {
+		if r.ID != "" && strings.HasPrefix(r.ID, id) {
+			if idx >= 0 {
+				fail(fmt.Sprintf("payment ID %q is ambiguous; use more characters", id))
+			}
+			idx = i
+		}
+	}
+	if idx < 0 {
+		fail(fmt.Sprintf("no payment with ID %q in the history ledger", id))
+	}
+	rec := &records[idx]
+	result.ID = rec.ID
+	result.Payment = rec
+
+	if rec.Status == "pending" {
+		status, err := settlementStatus(*rec, time.Now())
+		if err != nil {
+			fail(err.Error())
+		}
+		if status != "pending" {
+			rec.Status = status
+			rec.Error = ""
+			err := updateHistory(func(current []historyRecord) []historyRecord {
+				for i := range current {
+					if current[i].ID == rec.ID {
+						current[i] = *rec
+					}
+				}
+				return current
+			})
+			if err != nil {
+				fail(fmt.Sprintf("failed to update the history ledger: %v", err))
+			}
+			result.Resolved = true
+		}
+	}
+	result.Status = rec.Status
+	result.Settled = rec.Status == "accepted"
+
+	code := ExitSuccess
+	switch rec.Status {
+	case "pending":
+		code = ExitTimeout
+	case "unsettled":
+		code = ExitPaymentRejected
+	}
+	if *jsonOut {
+		printJSON(result)
+		os.Exit(code)
+	}
+
+	fmt.Printf("Payment:  %s\n", rec.ID)
+	fmt.Printf("Request:  %s %s\n", rec.Method, rec.Endpoint)
+	fmt.Printf("Paid at:  %s\n", rec.Time.Local().Format("2006-01-02 15:04:05"))
+	if rec.Nonce != "" {
+		fmt.Printf("Nonce:    %s\n", rec.Nonce)
+	}
+	switch rec.Status {
+	case "accepted":
+		fmt.Println("\nSettled: the authorization has been used on-chain.")
+	case "pending":
+		fmt.Printf("\nStill pending: the authorization is unused but valid until %s.\n", time.Unix(rec.ValidBefore, 0).Local().Format("2006-01-02 15:04:05"))
+	case "unsettled":
+		fmt.Println("\nNot settled: the authorization expired unused, so it can no longer be charged.")
+	default:
+		fmt.Printf("\nRecorded status: %s (not pending; nothing to resolve).\n", rec.Status)
+	}
+	os.Exit(code)
+}
+
+// settlementStatus decides whether a pending payment settled: "accepted" when its nonce
+// was used on-chain, "unsettled" when it is unused and can no longer be used, else "pending".
+func settlementStatus(rec historyRecord, now time.Time) (string, error) {
+	info, ok := lookupNetwork(rec.Network)
+	if !ok {
+		return "", fmt.Errorf("cannot check settlement on unsupported network %s", rec.Network)
+	}
+	nonce := common.FromHex(rec.Nonce)
+	if len(nonce) != 32 || !common.IsHexAddress(rec.Payer) || !common.IsHexAddress(rec.Asset) {
+		return "", fmt.Errorf("payment %s has no EIP-3009 authorization to check", rec.ID)
+	}
+	used, err := authorizationUsed(info.RPCURL, rec.Asset, rec.Payer, nonce)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case used:
+		return "accepted", nil
+	case rec.ValidBefore > 0 && now.Unix() >= rec.ValidBefore:
+		return "unsettled", nil
+	}
+	return "pending", nil
+}
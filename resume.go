@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// resumeResult is the JSON output for `x402-cli resume <id>`.
+type resumeResult struct {
+	ID       string `json:"id"`
+	Endpoint string `json:"endpoint"`
+	Method   string `json:"method"`
+	// State is where the interrupted run stopped: "requirements", "signed", or "sent"; or
+	// "queued" for a payment --enqueue signed for `flush` to send.
+	State string `json:"state"`
+	// Status is "accepted", "rejected", "insufficient_funds", "unsettled" (the authorization
+	// expired unused), "expired" (a queued payment was not flushed in time), "pending" (still
+	// unknown; resume again), or "error".
+	Status string `json:"status"`
+	// Resent is set when the saved signed payment was sent again.
+	Resent bool `json:"resent"`
+	// Settled is set when the authorization has been used on-chain.
+	Settled         bool           `json:"settled"`
+	StatusCode      int            `json:"statusCode,omitempty"`
+	Body            string         `json:"body,omitempty"`
+	Transaction     string         `json:"transaction,omitempty"`
+	Receipt         string         `json:"receipt,omitempty"` // path of the signed receipt of an accepted payment
+	RejectionReason *rejectionInfo `json:"rejectionReason,omitempty"`
+	Error           string         `json:"error,omitempty"`
+}
+
+// runResumeCmd finishes a payment whose run was interrupted, from its saved state.
+func runResumeCmd(args []string) {
+	fs := flag.NewFlagSet("resume", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "Output JSON")
+	output := fs.String("o", "", "Save the paid response body to a file")
+	timeout := fs.Duration("timeout", 30*time.Second, "Request timeout")
+	force := fs.Bool("force", false, "Send a signed request again even when it is not safe to repeat (e.g. a POST the server may have acted on)")
+	hosts := fs.String("only-hosts", os.Getenv("X402_ONLY_HOSTS"), "Only ever pay these hosts: comma-separated names or *.domain wildcards (default: $X402_ONLY_HOSTS)")
+	var budgets headerFlags
+	fs.Var(&budgets, "host-budget", "Cap payments to a host per period, e.g. api.example.com=1USDC/day (repeatable; default: $X402_HOST_BUDGETS)")
+	maxSpend := fs.String("max-total-spend", maxTotalSpendByEnv(), "Never pay more than this, e.g. 0.5USDC (default: $X402_MAX_TOTAL_SPEND)")
+	mainnet := fs.Bool("mainnet", mainnetAllowedByEnv(), "Allow signing an unsigned payment on a mainnet network (real funds) (default: $X402_ALLOW_MAINNET)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli resume [flags] [<payment id>]\n\n")
+		fmt.Fprintf(os.Stderr, "Finishes a payment whose run crashed, was interrupted, or timed out waiting for the\n")
+		fmt.Fprintf(os.Stderr, "paid response, from the state the run saved. A payment that was signed is never signed\n")
+		fmt.Fprintf(os.Stderr, "again: its authorization is checked on-chain and, if still unused and valid, the same\n")
+		fmt.Fprintf(os.Stderr, "signed payment is sent again, so it settles at most once. One that was not signed yet\n")
+		fmt.Fprintf(os.Stderr, "is signed and sent for the option the run chose, on a mainnet only with --mainnet. A\n")
+		fmt.Fprintf(os.Stderr, "signed request that is not safe to repeat (a POST without an Idempotency-Key, which the\n")
+		fmt.Fprintf(os.Stderr, "server may have acted on) is only sent again with --force. Without an ID, lists the\n")
+		fmt.Fprintf(os.Stderr, "in-flight payments.\n")
+		fmt.Fprintf(os.Stderr, "Exits 0 when paid, 2 when rejected or expired unused, 4 on insufficient funds, and 7\n")
+		fmt.Fprintf(os.Stderr, "while the outcome is still unknown.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	id := strings.ToLower(strings.TrimSpace(fs.Arg(0)))
+	if id == "" {
+		listInflightPayments(*jsonOut)
+		return
+	}
+	result := &resumeResult{ID: id}
+	fail := func(msg string) {
+		if *jsonOut {
+			result.Status = "error"
+			result.Error = msg
+			printJSON(result)
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", msg)
+		}
+		stdinSpool.close()
+		os.Exit(ExitError)
+	}
+	finish := func(code int, msg string) {
+		if *jsonOut {
+			printJSON(result)
+		} else if msg != "" {
+			fmt.Fprintln(os.Stderr, msg)
+		}
+		stdinSpool.close()
+		os.Exit(code)
+	}
+
+	guards, err := newResumeGuards(*hosts, budgets, *maxSpend)
+	if err != nil {
+		fail(err.Error())
+	}
+	guards.mainnet = *mainnet
+	p, err := loadInflight(id)
+	if err != nil {
+		fail(err.Error())
+	}
+	if !*jsonOut {
+		fmt.Fprintf(os.Stderr, "Resuming payment %s (%s): %s %s\n", p.ID, p.State, p.Method, p.Endpoint)
+	}
+	result, code, msg := resumePayment(p, *timeout, *force, guards)
+	if result.Status == "error" && msg == "" {
+		fail(result.Error)
+	}
+	if result.StatusCode == http.StatusOK {
+		saveOutput(*output, []byte(result.Body))
+		if !*jsonOut {
+			if result.Receipt != "" {
+				fmt.Fprintf(os.Stderr, "Receipt: %s\n", result.Receipt)
+			}
+			fmt.Fprintln(os.Stderr, "Payment accepted!")
+			os.Stdout.WriteString(result.Body)
+		}
+	}
+	finish(code, msg)
+}
+
+// resumeGuards are the limits a resumed payment is held to, as the pay command's are:
+// --only-hosts, --host-budget, --max-total-spend, and --mainnet.
+type resumeGuards struct {
+	hosts   hostAllowlist
+	budgets []hostBudget
+	ceiling *spendCeiling
+	// mainnet allows signing a payment on a mainnet.
+	mainnet bool
+}
+
+// newResumeGuards parses the guard flags of resume and flush.
+func newResumeGuards(hosts string, budgets []string, maxSpend string) (resumeGuards, error) {
+	g := resumeGuards{hosts: parseHostAllowlist(hosts)}
+	var err error
+	if g.budgets, err = parseHostBudgets(budgets, os.Getenv("X402_HOST_BUDGETS")); err != nil {
+		return g, err
+	}
+	g.ceiling, err = newSpendCeiling(maxSpend)
+	return g, err
+}
+
+// resumePayment finishes the in-flight payment p: a signed payment is sent again while its
+// authorization is unused and valid, and an unsigned one is signed and sent, once it passes
+// guards. It returns the outcome, the exit code, and a message for the user; the outcome is
+// recorded in the ledger.
+func resumePayment(p *inflightPayment, timeout time.Duration, force bool, guards resumeGuards) (*resumeResult, int, string) {
+	result := &resumeResult{ID: p.ID, Endpoint: p.Endpoint, Method: p.Method, State: p.State}
+	fail := func(msg string) (*resumeResult, int, string) {
+		result.Status = "error"
+		result.Error = msg
+		return result, ExitError, ""
+	}
+	profile = p.Profile
+	stdinSpool.close()
+	stdinSpool = nil
+	if p.BodyFile != "" {
+		f, err := os.Open(p.BodyFile)
+		if err != nil {
+			return fail(fmt.Sprintf("cannot read the saved request body: %v", err))
+		}
+		stdinSpool, err = newStdinBody(f)
+		f.Close()
+		if err != nil {
+			return fail(fmt.Sprintf("cannot read the saved request body: %v", err))
+		}
+	}
+
+	// record writes the outcome to the history ledger under the payment's ID.
+	record := func(resp *http.Response, body []byte) historyRecord {
+		rec := newHistoryRecord(p.Endpoint, p.Method, p.Data, p.Payment, resp)
+		rec.ID, rec.TraceID, rec.Tags = p.ID, p.TraceID, p.Tags
+		rec.Status, rec.Error = result.Status, result.Error
+		if resp != nil {
+			rec.measureBody(body)
+		}
+		err := updateHistory(func(records []historyRecord) []historyRecord {
+			for i := range records {
+				if records[i].ID == rec.ID {
+					records[i] = rec
+					return records
+				}
+			}
+			return append(records, rec)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to record payment history: %v\n", err)
+		}
+		return rec
+	}
+
+	// A queued payment was never sent: it only has to be sent within its validity window.
+	queued := p.State == queuedState
+	if queued {
+		if rec := newHistoryRecord(p.Endpoint, p.Method, p.Data, p.Payment, nil); rec.ValidBefore > 0 && time.Now().Unix() >= rec.ValidBefore {
+			result.Status = "expired"
+			result.Error = "the queued authorization expired before it was sent"
+			p.remove()
+			return result, ExitPaymentRejected, "The queued payment expired before it could be sent, so nothing was paid."
+		}
+	}
+
+	// A signed payment is only sent again while its authorization is unused and valid.
+	if p.Payment != "" && !queued {
+		status, err := settlementStatus(newHistoryRecord(p.Endpoint, p.Method, p.Data, p.Payment, nil), time.Now())
+		if err != nil {
+			return fail(fmt.Sprintf("cannot check whether the payment settled: %v", err))
+		}
+		switch status {
+		case "accepted":
+			result.Status, result.Settled = "accepted", true
+			record(nil, nil)
+			p.remove()
+			return result, ExitSuccess, "The payment already settled on-chain, but its response was lost; it is not sent again."
+		case "unsettled":
+			result.Status = "unsettled"
+			result.Error = "the signed authorization expired unused"
+			record(nil, nil)
+			p.remove()
+			return result, ExitPaymentRejected, "The signed authorization expired unused, so nothing was paid. Run the original command again to pay anew."
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := newRequestWithContext(ctx, p.Method, p.Endpoint, p.Data, p.Headers)
+	if err != nil {
+		return fail(err.Error())
+	}
+	if u, err := url.Parse(p.Endpoint); err == nil && !guards.hosts.allows(u.Hostname()) {
+		return fail(fmt.Sprintf("refusing to pay %s: host is not in --only-hosts (%s)", u.Hostname(), strings.Join(guards.hosts, ",")))
+	}
+	var client *http.Client
+	release := func() {}
+	if p.Payment != "" {
+		// The same authorization settles at most once, but the request itself may have been
+		// acted on already.
+		if retry := retrySafety(p.Method, req.Header, false, nil, nil); !retry.Safe && !force && !queued {
+			return fail(fmt.Sprintf("not sending the payment's request again: %s; check with the server, then rerun with --force to send it anyway", retry.Reason))
+		}
+		result.Resent = !queued
+		req.Header.Set(p.PaymentHeader, p.Payment)
+		client = &http.Client{Transport: guards.hosts.transport(p.transport(http.DefaultTransport)), Timeout: timeout}
+	} else {
+		if !guards.mainnet && !isTestnet(p.Requirements.Network) {
+			return fail("refusing to pay: " + mainnetRefusal)
+		}
+		signer, err := signerFor(privateKeyFromEnv())
+		if err != nil {
+			return fail(fmt.Sprintf("%s: %v", privateKeyVar(), err))
+		}
+		if len(guards.budgets) > 0 {
+			records, err := loadHistory()
+			if err != nil {
+				return fail(fmt.Sprintf("cannot check host budgets: %v", err))
+			}
+			if err := checkHostBudgets(guards.budgets, records, p.Endpoint, p.Requirements, time.Now()); err != nil {
+				return fail(err.Error())
+			}
+		}
+		if _, err := checkDelegatedSigner(routedAddress(p.Requirements.Network, signer.Address()), p.Endpoint, p.Requirements); err != nil {
+			return fail(err.Error())
+		}
+		if release, err = guards.ceiling.reserve(p.Requirements); err != nil {
+			return fail(err.Error())
+		}
+		client = newPaymentClient(signer, samePaymentOption(p.Requirements, guards.hosts.transport(mainnetGuard(p.transport(http.DefaultTransport), guards.mainnet))), timeout, selectRequirement(p.Requirements))
+	}
+	// A redirect to another origin is never followed with the payment.
+	var hops []redirectHop
+	redirects := newRedirectPolicy(false, nil, &hops)
+	client.CheckRedirect = redirects.checkRedirect("payment")
+	resp, err := client.Do(req)
+	if err != nil {
+		if p.Payment == "" {
+			return fail(err.Error())
+		}
+		result.Status = "pending"
+		result.Error = "paid request failed: " + err.Error()
+		return result, ExitTimeout, fmt.Sprintf("The paid request failed (%v); the outcome is still unknown. Resume again later.", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	result.StatusCode = resp.StatusCode
+	if hop := redirects.blocked(); hop != nil {
+		// The payment reached the endpoint, which answered with the redirect, so it may yet
+		// settle: the state is kept for another try.
+		result.Status = "error"
+		result.Error = "paid request redirected to another origin: " + hop.To
+		return result, ExitError, fmt.Sprintf("The paid request was redirected to another origin (%s), which is not followed; the payment state is kept. Resume again later.", hop.To)
+	}
+	if raw, err := base64.StdEncoding.DecodeString(resp.Header.Get("PAYMENT-RESPONSE")); err == nil {
+		result.Transaction, _, _ = settlementTx(raw)
+	}
+
+	switch reason := rejectionReason(resp, body); {
+	case resp.StatusCode == http.StatusOK:
+		result.Status, result.Settled = "accepted", true
+		result.Body = string(body)
+		rec := record(resp, body)
+		p.remove()
+		if _, path, err := issueReceipt(rec); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		} else {
+			result.Receipt = path
+		}
+		return result, ExitSuccess, ""
+	case resp.StatusCode == http.StatusPaymentRequired && p.Payment != "" && isNonceReplay(reason):
+		// The authorization was used meanwhile: the interrupted request settled after all.
+		result.Status, result.Settled = "accepted", true
+		record(nil, nil)
+		p.remove()
+		return result, ExitSuccess, "The payment settled meanwhile, but its response was lost; it is not sent again."
+	case resp.StatusCode == http.StatusPaymentRequired:
+		release() // a refused payment does not settle
+		result.Error = reason
+		result.RejectionReason = normalizeRejection(reason)
+		result.Status = "rejected"
+		code := ExitPaymentRejected
+		if isInsufficientFunds(reason) {
+			result.Status, code = "insufficient_funds", ExitInsufficientFunds
+		}
+		record(resp, body)
+		p.remove()
+		return result, code, fmt.Sprintf("Payment was rejected (%s): %s.", result.RejectionReason.Code, result.RejectionReason.Message)
+	default:
+		// The payment may or may not have settled, so the state is kept for another try.
+		result.Status = "error"
+		result.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+		return result, ExitError, fmt.Sprintf("Unexpected status %d; the payment state is kept. Resume again later.", resp.StatusCode)
+	}
+}
+
+// samePaymentOption wraps rt to refuse sending a payment for any option but want, so that
+// resuming never pays for something else when the server has changed its prices.
+func samePaymentOption(want x402.PaymentRequirements, rt http.RoundTripper) http.RoundTripper {
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		for _, h := range []string{"PAYMENT-SIGNATURE", "X-PAYMENT"} {
+			v := req.Header.Get(h)
+			if v == "" {
+				continue
+			}
+			var payload x402.PaymentPayload
+			raw, err := base64.StdEncoding.DecodeString(v)
+			if err == nil {
+				err = json.Unmarshal(raw, &payload)
+			}
+			got := payload.Accepted
+			if err != nil || got.Network != want.Network || !strings.EqualFold(got.Asset, want.Asset) || got.Amount != want.Amount {
+				return nil, fmt.Errorf("the server no longer offers the saved payment option (%s); run the original command again", describeAmount(want))
+			}
+		}
+		return rt.RoundTrip(req)
+	})
+}
+
+// listInflightPayments prints the payments that `resume` can finish.
+func listInflightPayments(jsonOut bool) {
+	payments, err := listInflight()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	if jsonOut {
+		if payments == nil {
+			payments = []*inflightPayment{}
+		}
+		// Secrets stay out of the listing: the signed payment is a bearer authorization,
+		// and headers and bodies may carry credentials.
+		for _, p := range payments {
+			p.Payment, p.Headers, p.Data = "", nil, ""
+		}
+		printJSON(payments)
+		return
+	}
+	if len(payments) == 0 {
+		fmt.Println("No in-flight payments.")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUPDATED\tSTATE\tMETHOD\tENDPOINT\tAMOUNT")
+	for _, p := range payments {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", p.ID, p.Updated.Local().Format("2006-01-02 15:04:05"),
+			p.State, p.Method, p.Endpoint, describeAmount(p.Requirements))
+	}
+	w.Flush()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// retryDecision is whether a request may be sent again after an attempt failed, and why.
+type retryDecision struct {
+	Safe   bool
+	Reason string
+}
+
+// idempotentMethods are the methods whose repetition has the effect of a single request
+// (RFC 9110, section 9.2.2).
+var idempotentMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodOptions: true,
+	http.MethodTrace: true, http.MethodPut: true, http.MethodDelete: true,
+}
+
+// retrySafety classifies a request for every retry the CLI makes (--max-wait, nonce
+// retries, egress fallbacks, and resume) after an attempt that ended with resp or err.
+// Sending it again is safe when the server cannot have acted on the attempt: it refused it
+// (429, or 402 for the payment), or the request never reached it. Otherwise it is safe only
+// for an idempotent request (GET, HEAD, and the other idempotent methods, or any request
+// with an Idempotency-Key header) with no payment submitted: a payment already sent may
+// settle, and a repeated POST may act twice.
+func retrySafety(method string, header http.Header, paymentSent bool, resp *http.Response, err error) retryDecision {
+	switch {
+	case resp != nil && resp.StatusCode == http.StatusTooManyRequests:
+		return retryDecision{true, "the server refused it (429)"}
+	case resp != nil && resp.StatusCode == http.StatusPaymentRequired:
+		return retryDecision{true, "the server refused it (402)"}
+	case err != nil && notDelivered(err):
+		return retryDecision{true, "it never reached the server"}
+	case paymentSent:
+		return retryDecision{false, "a payment was already submitted with it and may settle"}
+	case idempotentMethods[strings.ToUpper(method)]:
+		return retryDecision{true, strings.ToUpper(method) + " is idempotent"}
+	case header.Get("Idempotency-Key") != "":
+		return retryDecision{true, "it carries an Idempotency-Key"}
+	}
+	return retryDecision{false, strings.ToUpper(method) + " is not idempotent and the server may have acted on it"}
+}
+
+// notDelivered reports whether err proves the request never reached the server: the name
+// did not resolve, the connection was not made, or the TLS handshake failed.
+func notDelivered(err error) bool {
+	if _, kind := classifyError(err); kind == "dns" || kind == "tls" {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+)
+
+// The limits --sandbox sets when they are not given.
+const (
+	sandboxMaxAmount = "0.01USDC"
+	sandboxMaxSpend  = "0.05USDC"
+	// sandboxMaxResponse caps every response body read under --sandbox.
+	sandboxMaxResponse = 10 << 20
+)
+
+// checkSandbox validates --sandbox against the rest of the command line: it needs a host
+// allowlist and a single URL, and refuses the flags that would loosen it.
+func checkSandbox(onlyHosts string, explicit []string, urls int) error {
+	for _, name := range explicit {
+		if name == "mainnet" || name == "trust-redirects" {
+			return fmt.Errorf("--sandbox cannot be combined with --%s", name)
+		}
+	}
+	if len(parseHostAllowlist(onlyHosts)) == 0 {
+		return errors.New("--sandbox requires --only-hosts (or $X402_ONLY_HOSTS) naming the hosts that may be paid")
+	}
+	if urls > 1 {
+		return errors.New("--sandbox pays a single URL")
+	}
+	return nil
+}
+
+// explicitFlags lists the command-line flags that were set, of those named.
+func explicitFlags(names ...string) []string {
+	var set []string
+	flag.Visit(func(f *flag.Flag) {
+		if slices.Contains(names, f.Name) {
+			set = append(set, f.Name)
+		}
+	})
+	return set
+}
+
+// capResponses truncates response bodies read through rt to limit bytes, warning once
+// when it does; a limit of 0 leaves them whole.
+func capResponses(rt http.RoundTripper, limit int64) http.RoundTripper {
+	if limit <= 0 {
+		return rt
+	}
+	var warn sync.Once
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = &cappedBody{ReadCloser: resp.Body, left: limit, truncated: func() {
+			warn.Do(func() {
+				fmt.Fprintf(os.Stderr, "Warning: the response from %s is larger than %d bytes; it was truncated.\n", req.URL.Host, limit)
+			})
+		}}
+		return resp, nil
+	})
+}
+
+// cappedBody ends a response body after a number of bytes.
+type cappedBody struct {
+	io.ReadCloser
+	left      int64
+	truncated func()
+}
+
+func (b *cappedBody) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		// Only a body that goes on past the cap was truncated.
+		var one [1]byte
+		if n, _ := b.ReadCloser.Read(one[:]); n > 0 {
+			b.truncated()
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	return n, err
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// schemaVersion is the version of the pay command's JSON output under --output-schema v2.
+// Within a version fields are only ever added: none is removed, renamed, or changes type.
+const schemaVersion = 2
+
+// outputSchema selects the pay command's JSON structure (--output-schema): "v1", the
+// unversioned output, or "v2", which carries schemaVersion and follows `x402-cli schema`.
+var outputSchema = outputSchemaByEnv()
+
+// outputSchemaByEnv is the default for --output-schema: $X402_OUTPUT_SCHEMA, else v1.
+func outputSchemaByEnv() string {
+	if v := os.Getenv("X402_OUTPUT_SCHEMA"); v != "" {
+		return v
+	}
+	return "v1"
+}
+
+// checkOutputSchema validates --output-schema.
+func checkOutputSchema(v string) error {
+	if v != "v1" && v != "v2" {
+		return fmt.Errorf("--output-schema %q: want v1 or v2", v)
+	}
+	return nil
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// jsonSchema builds the JSON Schema (draft 2020-12) of values of t as encoding/json writes
+// them, with named struct types under $defs. It follows the json tags, so the schema and
+// the output cannot drift apart.
+func jsonSchema(t reflect.Type, title string) map[string]any {
+	defs := map[string]any{}
+	root := schemaOf(t, defs)
+	if ref, ok := root["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/$defs/")
+		root = defs[name].(map[string]any)
+		delete(defs, name)
+	}
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["title"] = title
+	if len(defs) > 0 {
+		root["$defs"] = defs
+	}
+	return root
+}
+
+// schemaOf returns the schema of t, adding the structs it refers to to defs.
+func schemaOf(t reflect.Type, defs map[string]any) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == rawType:
+		return map[string]any{}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), defs)}
+	case reflect.Struct:
+		name := t.Name()
+		if name == "" {
+			return structSchema(t, defs)
+		}
+		if _, ok := defs[name]; !ok {
+			defs[name] = map[string]any{} // placeholder for recursive types
+			defs[name] = structSchema(t, defs)
+		}
+		return map[string]any{"$ref": "#/$defs/" + name}
+	}
+	return map[string]any{}
+}
+
+// structSchema is the object schema of a struct: its json-tagged fields, embedded structs'
+// fields inlined, and those without omitempty required (and nullable if they can be nil).
+func structSchema(t reflect.Type, defs map[string]any) map[string]any {
+	props := map[string]any{}
+	var required []string
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" {
+				ft := f.Type
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					walk(ft)
+					continue
+				}
+			}
+			if !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = schemaOf(f.Type, defs)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+				// Without omitempty, a nil pointer, slice, or map is written as null.
+				switch f.Type.Kind() {
+				case reflect.Pointer, reflect.Slice, reflect.Map:
+					if f.Type != rawType {
+						props[name] = map[string]any{"anyOf": []any{props[name], map[string]any{"type": "null"}}}
+					}
+				}
+			}
+		}
+	}
+	walk(t)
+	s := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// resultSchema is the JSON Schema of the pay command's --json output under --output-schema v2.
+func resultSchema() map[string]any {
+	s := jsonSchema(reflect.TypeOf(jsonResult{}), "x402-cli pay command result")
+	props := s["properties"].(map[string]any)
+	props["schemaVersion"] = map[string]any{"type": "integer", "const": schemaVersion}
+	s["required"] = append(s["required"].([]string), "schemaVersion")
+	return s
+}
+
+// runSchemaCmd prints the JSON Schema of the pay command's JSON output.
+func runSchemaCmd(args []string) {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	version := fs.String("output-schema", "v2", "Output schema version to describe")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli schema [--output-schema v2]\n\n")
+		fmt.Fprintf(os.Stderr, "Prints the JSON Schema (draft 2020-12) of the pay command's --json output under\n")
+		fmt.Fprintf(os.Stderr, "--output-schema v2. Within a schema version fields are only added, never removed,\n")
+		fmt.Fprintf(os.Stderr, "renamed, or retyped, so integrations should ignore fields they do not know. Validate\n")
+		fmt.Fprintf(os.Stderr, "against it in CI to catch a breaking upgrade before an agent does.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *version != "v2" {
+		fmt.Fprintf(os.Stderr, "Error: only the v2 output has a schema (v1 is the unversioned output)\n")
+		os.Exit(ExitError)
+	}
+	out, _ := json.MarshalIndent(resultSchema(), "", "  ")
+	fmt.Println(string(out))
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+	x402evm "github.com/coinbase/x402/go/mechanisms/evm"
+)
+
+// scriptFlow is a script file: requests run in order, with a combined budget.
+type scriptFlow struct {
+	// Budget caps the total paid by all steps, in token units, e.g. "0.05USDC".
+	Budget string       `json:"budget,omitempty"`
+	Steps  []scriptStep `json:"steps"`
+}
+
+// scriptStep is one request of a script. URL, headers, and body may use {{variable}}
+// placeholders, filled from --var and from earlier steps' captures.
+type scriptStep struct {
+	Name    string   `json:"name,omitempty"`
+	URL     string   `json:"url"`
+	Method  string   `json:"method,omitempty"`
+	Headers []string `json:"headers,omitempty"`
+	Body    string   `json:"body,omitempty"`
+	// Capture maps a variable name to a path into the step's JSON response, e.g. "$.data.id".
+	Capture map[string]string `json:"capture,omitempty"`
+}
+
+// scriptResult is the outcome of one step.
+type scriptResult struct {
+	Step int `json:"step"`
+	batchResult
+	Captures map[string]string `json:"captures,omitempty"`
+}
+
+// loadScript reads a script file in JSON or the YAML subset parseScriptYAML understands.
+func loadScript(path string) (*scriptFlow, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	flow := &scriptFlow{}
+	if strings.HasPrefix(strings.TrimSpace(string(raw)), "{") {
+		if err := json.Unmarshal(raw, flow); err != nil {
+			return nil, fmt.Errorf("invalid script: %w", err)
+		}
+	} else if flow, err = parseScriptYAML(string(raw)); err != nil {
+		return nil, err
+	}
+	if len(flow.Steps) == 0 {
+		return nil, fmt.Errorf("no steps in %s", path)
+	}
+	for i := range flow.Steps {
+		st := &flow.Steps[i]
+		if st.URL == "" {
+			return nil, fmt.Errorf("step %d: url is required", i+1)
+		}
+		if st.Name == "" {
+			st.Name = fmt.Sprintf("step %d", i+1)
+		}
+		for name, path := range st.Capture {
+			if _, err := parseFieldPath(capturePath(path)); err != nil {
+				return nil, fmt.Errorf("step %d: capture %s: %v", i+1, name, err)
+			}
+		}
+	}
+	return flow, nil
+}
+
+// parseScriptYAML understands a top-level "budget:" and a "steps:" list of maps with
+// name/url/method/body keys, plus repeatable "header: Key: Value" and
+// "capture: variable=path" lines.
+func parseScriptYAML(src string) (*scriptFlow, error) {
+	flow := &scriptFlow{}
+	var current *scriptStep
+
+	scanner := bufio.NewScanner(strings.NewReader(src))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || line == "steps:" {
+			continue
+		}
+		if item, ok := strings.CutPrefix(line, "-"); ok {
+			flow.Steps = append(flow.Steps, scriptStep{})
+			current = &flow.Steps[len(flow.Steps)-1]
+			line = strings.TrimSpace(item)
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", n)
+		}
+		key = strings.TrimSpace(key)
+		if current == nil {
+			if key != "budget" {
+				return nil, fmt.Errorf("line %d: unknown key %q (expected budget or steps)", n, key)
+			}
+			flow.Budget = yamlScalar(value)
+			continue
+		}
+		switch key {
+		case "name":
+			current.Name = yamlScalar(value)
+		case "url":
+			current.URL = yamlScalar(value)
+		case "method":
+			current.Method = yamlScalar(value)
+		case "body":
+			current.Body = yamlScalar(value)
+		case "header":
+			current.Headers = append(current.Headers, yamlScalar(value))
+		case "capture":
+			name, path, ok := strings.Cut(yamlScalar(value), "=")
+			if !ok || strings.TrimSpace(name) == "" {
+				return nil, fmt.Errorf("line %d: capture must be variable=path, e.g. id=$.data.id", n)
+			}
+			if current.Capture == nil {
+				current.Capture = map[string]string{}
+			}
+			current.Capture[strings.TrimSpace(name)] = strings.TrimSpace(path)
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %q", n, key)
+		}
+	}
+	return flow, scanner.Err()
+}
+
+// capturePath turns a JSONPath-style capture ($.data.id) into an --extract path (.data.id).
+func capturePath(path string) string {
+	path = strings.TrimSpace(path)
+	if rest, ok := strings.CutPrefix(path, "$"); ok {
+		if strings.HasPrefix(rest, ".") {
+			return rest
+		}
+		return "." + rest
+	}
+	return path
+}
+
+// endpoint fills the step's placeholders from vars. Values are percent-encoded in the URL
+// and JSON-escaped in a JSON body, as in presets.
+func (st scriptStep) endpoint(vars map[string]string) (dashboardEndpoint, error) {
+	var missing []string
+	for _, v := range (preset{URL: st.URL, Body: st.Body, Headers: st.Headers}).variables() {
+		if _, ok := vars[v]; !ok {
+			missing = append(missing, v)
+		}
+	}
+	if len(missing) > 0 {
+		return dashboardEndpoint{}, fmt.Errorf("undefined variable %s (set it with --var or capture it in an earlier step)", strings.Join(missing, ", "))
+	}
+	ep := dashboardEndpoint{Name: st.Name, URL: fillPreset(st.URL, vars, formEscape), Method: strings.ToUpper(st.Method)}
+	if !strings.HasPrefix(ep.URL, "http://") && !strings.HasPrefix(ep.URL, "https://") {
+		return ep, fmt.Errorf("invalid url %q", ep.URL)
+	}
+	for _, h := range st.Headers {
+		ep.headers = append(ep.headers, fillPreset(h, vars, func(s string) string { return s }))
+	}
+	if st.Body != "" {
+		escape := func(s string) string { return s }
+		if b := strings.TrimSpace(st.Body); strings.HasPrefix(b, "{") || strings.HasPrefix(b, "[") {
+			escape = jsonEscape
+		}
+		ep.body = fillPreset(st.Body, vars, escape)
+	}
+	if ep.Method == "" {
+		ep.Method = "GET"
+		if ep.body != "" {
+			ep.Method = "POST"
+		}
+	}
+	return ep, nil
+}
+
+// parseTokenAmount parses a budget amount such as "0.05", "0.05USDC", or "1 USDC" in token units.
+func parseTokenAmount(s string) (*big.Rat, error) {
+	amount := strings.TrimSpace(strings.TrimRight(strings.TrimSpace(s), "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"))
+	limit, ok := new(big.Rat).SetString(amount)
+	if !ok || limit.Sign() < 0 {
+		return nil, fmt.Errorf("invalid budget %q: want an amount such as 0.05USDC", s)
+	}
+	return limit, nil
+}
+
+// runScript runs the steps in order, stopping at the first that is neither paid nor free.
+// A step is only paid while the total stays within limit (nil for no limit).
+func runScript(flow *scriptFlow, vars map[string]string, limit *big.Rat, transport http.RoundTripper, signer x402evm.ClientEvmSigner, opts batchOptions, onStep func(scriptResult)) ([]scriptResult, *big.Rat) {
+	spent := new(big.Rat)
+	opts.approve = func(price x402.PaymentRequirements) error {
+		if limit == nil {
+			return nil
+		}
+		cost, ok := tokenUnits(price)
+		if !ok {
+			return fmt.Errorf("script budget %s: cannot convert the price (%s) to token units", ratString(limit), describeAmount(price))
+		}
+		if total := new(big.Rat).Add(spent, cost); total.Cmp(limit) > 0 {
+			return fmt.Errorf("script budget %s exceeded: %s already paid, this step adds %s", ratString(limit), ratString(spent), ratString(cost))
+		}
+		return nil
+	}
+
+	var results []scriptResult
+	for i, st := range flow.Steps {
+		res := scriptResult{Step: i + 1}
+		ep, err := st.endpoint(vars)
+		if err != nil {
+			res.batchResult = batchResult{RequestID: requestID, Name: st.Name, URL: st.URL, Method: st.Method, Status: "error", Error: err.Error()}
+		} else {
+			start := time.Now()
+			res.batchResult = payEndpoint(transport, signer, ep, opts)
+			res.LatencyMs = time.Since(start).Milliseconds()
+		}
+		if res.Status == "accepted" {
+			if cost, ok := tokenUnits(x402.PaymentRequirements{Network: res.chainID, Asset: res.Asset, Amount: res.Amount}); ok {
+				spent.Add(spent, cost)
+			}
+		}
+		if res.Status == "accepted" || res.Status == "free" {
+			names := make([]string, 0, len(st.Capture))
+			for name := range st.Capture {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				value, _, err := transformBody(res.body, capturePath(st.Capture[name]), "")
+				if err != nil {
+					res.Status = "error"
+					res.Error = fmt.Sprintf("capture %s: %v", name, err)
+					break
+				}
+				if res.Captures == nil {
+					res.Captures = map[string]string{}
+				}
+				res.Captures[name] = string(value)
+				vars[name] = string(value)
+			}
+		}
+		results = append(results, res)
+		if onStep != nil {
+			onStep(res)
+		}
+		if res.Status != "accepted" && res.Status != "free" {
+			break
+		}
+	}
+	return results, spent
+}
+
+// runScriptCmd runs a multi-step paid workflow from a script file.
+func runScriptCmd(args []string) {
+	fs := flag.NewFlagSet("script", flag.ExitOnError)
+	var (
+		insecure bool
+		timeout  time.Duration
+		trust    bool
+		hosts    string
+		budgets  headerFlags
+		varFlags headerFlags
+		budget   string
+		jsonOut  bool
+		mainnet  bool
+		maxSpend string
+		tags     = tagsByEnv()
+	)
+	fs.BoolVar(&insecure, "k", false, "Skip TLS certificate verification")
+	fs.DurationVar(&timeout, "timeout", 30*time.Second, "Per-step timeout")
+	fs.Var(&varFlags, "var", "Set a variable for {{name}} placeholders, name=value (repeatable)")
+	fs.StringVar(&budget, "budget", "", "Cap the total paid by all steps, e.g. 0.05USDC (default: the script's budget)")
+	fs.StringVar(&maxSpend, "max-total-spend", maxTotalSpendByEnv(), "Hard ceiling on the total paid, on top of --budget (default: $X402_MAX_TOTAL_SPEND)")
+	fs.BoolVar(&trust, "trust-redirects", false, "Follow redirects to another origin (and pay it if it asks)")
+	fs.StringVar(&hosts, "only-hosts", os.Getenv("X402_ONLY_HOSTS"), "Only ever pay these hosts: comma-separated names or *.domain wildcards (default: $X402_ONLY_HOSTS)")
+	fs.Var(&budgets, "host-budget", "Cap payments to a host per period, e.g. api.example.com=1USDC/day (repeatable; default: $X402_HOST_BUDGETS)")
+	fs.Var(&tags, "tag", "Store key=value with the history record of each payment, e.g. job=nightly-scrape, to filter history by (repeatable; default: $X402_TAGS)")
+	fs.BoolVar(&jsonOut, "json", false, "Output one JSON object per step (NDJSON), then a summary")
+	fs.BoolVar(&mainnet, "mainnet", mainnetAllowedByEnv(), "Allow payments on mainnet networks (real funds); without it only testnets are paid (default: $X402_ALLOW_MAINNET)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli script [flags] <flow.yaml|flow.json>\n\n")
+		fmt.Fprintf(os.Stderr, "Runs the script's requests in order, paying each one that asks for payment. Values\n")
+		fmt.Fprintf(os.Stderr, "captured from a response (capture: id=$.data.id) fill {{id}} in later steps' URL,\n")
+		fmt.Fprintf(os.Stderr, "headers, and body. A step is not paid if the total would exceed the script's budget.\n")
+		fmt.Fprintf(os.Stderr, "Stops at the first step that is neither paid nor free; exits 0 when every step ran, and 1 otherwise.\n\n")
+		fmt.Fprintf(os.Stderr, "Example flow.yaml:\n")
+		fmt.Fprintf(os.Stderr, "  budget: 0.05USDC\n")
+		fmt.Fprintf(os.Stderr, "  steps:\n")
+		fmt.Fprintf(os.Stderr, "    - name: search\n")
+		fmt.Fprintf(os.Stderr, "      url: https://api.example.com/search?q={{query}}\n")
+		fmt.Fprintf(os.Stderr, "      capture: id=$.results[0].id\n")
+		fmt.Fprintf(os.Stderr, "    - name: detail\n")
+		fmt.Fprintf(os.Stderr, "      url: https://api.example.com/items/{{id}}\n")
+		fmt.Fprintf(os.Stderr, "      header: Accept: application/json\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.Arg(0) == "" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	flow, err := loadScript(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	vars := map[string]string{}
+	for _, v := range varFlags {
+		name, value, ok := strings.Cut(v, "=")
+		if !ok || name == "" {
+			fmt.Fprintf(os.Stderr, "Error: --var %q: expected name=value\n", v)
+			os.Exit(ExitError)
+		}
+		vars[name] = value
+	}
+	if budget == "" {
+		budget = flow.Budget
+	}
+	var limit *big.Rat
+	if budget != "" {
+		if limit, err = parseTokenAmount(budget); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitError)
+		}
+	}
+
+	opts := batchOptions{timeout: timeout, trustRedirects: trust, mainnet: mainnet}
+	if opts.budgets, err = parseHostBudgets(budgets, os.Getenv("X402_HOST_BUDGETS")); err == nil {
+		opts.ceiling, err = newSpendCeiling(maxSpend)
+	}
+	if err == nil {
+		recordTags, err = parseTags(tags)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	var signer x402evm.ClientEvmSigner
+	if key := privateKeyFromEnv(); key != "" {
+		if signer, err = signerFor(key); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to create signer: %v\n", err)
+			os.Exit(ExitError)
+		}
+	}
+	transport := &http.Transport{}
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	onStep := func(r scriptResult) {
+		if jsonOut {
+			line, _ := json.Marshal(redactJSON(r))
+			fmt.Println(string(line))
+			return
+		}
+		status := r.Status
+		if r.StatusCode != 0 {
+			status = fmt.Sprintf("%s (%d)", r.Status, r.StatusCode)
+		}
+		fmt.Printf("[%d/%d] %s: %s %s — %s", r.Step, len(flow.Steps), r.Name, r.Method, r.URL, status)
+		if r.Price != "" && r.Status == "accepted" {
+			fmt.Printf(", paid %s", r.Price)
+		}
+		fmt.Println()
+		names := make([]string, 0, len(r.Captures))
+		for name := range r.Captures {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("       %s = %s\n", name, truncate(r.Captures[name], 100))
+		}
+		if r.Error != "" {
+			fmt.Printf("       error: %s\n", r.Error)
+		}
+	}
+	results, spent := runScript(flow, vars, limit, mainnetGuard(parseHostAllowlist(hosts).transport(noSpendTransport(transport)), mainnet), signer, opts, onStep)
+
+	completed := 0
+	for _, r := range results {
+		if r.Status == "accepted" || r.Status == "free" {
+			completed++
+		}
+	}
+	code := ExitSuccess
+	if completed < len(flow.Steps) {
+		code = ExitError
+	}
+	if jsonOut {
+		summary := struct {
+			Type      string `json:"type"`
+			RequestID string `json:"requestId"`
+			Steps     int    `json:"steps"`
+			Completed int    `json:"completed"`
+			Spent     string `json:"spent"`
+			Budget    string `json:"budget,omitempty"`
+		}{"summary", requestID, len(flow.Steps), completed, ratString(spent), budget}
+		line, _ := json.Marshal(redactJSON(summary))
+		fmt.Println(string(line))
+		os.Exit(code)
+	}
+	fmt.Printf("\nSpent: %s", ratString(spent))
+	if limit != nil {
+		fmt.Printf(" of %s budget", ratString(limit))
+	}
+	fmt.Println()
+	os.Exit(code)
+}
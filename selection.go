@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// selectModes are the values of --select.
+var selectModes = []string{"first", "smart"}
+
+// selectionResult explains which payment option --select smart chose.
+type selectionResult struct {
+	Mode    string `json:"mode"`
+	Network string `json:"network"`
+	Asset   string `json:"asset"`
+	Amount  string `json:"amount"`
+	// Tier is the chosen option's price tier, when the server offers tiers.
+	Tier   string `json:"tier,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// acceptCandidate is a payable option with what is known about its chances.
+type acceptCandidate struct {
+	req     x402.PaymentRequirements
+	index   int           // position in the server's accepts
+	funded  int           // 1 funded, 0 unknown, -1 balance too low
+	latency time.Duration // median settlement latency from history; 0 when unknown
+	cost    *big.Rat      // price in token units; nil when decimals are unknown
+}
+
+// settlementLatencies returns the median paid-request latency per network, from the
+// accepted payments in the ledger that recorded one.
+func settlementLatencies(records []historyRecord) map[string]time.Duration {
+	samples := map[string][]int64{}
+	for _, r := range records {
+		if r.Status == "accepted" && r.LatencyMs > 0 && r.Network != "" {
+			samples[r.Network] = append(samples[r.Network], r.LatencyMs)
+		}
+	}
+	medians := make(map[string]time.Duration, len(samples))
+	for network, ms := range samples {
+		slices.Sort(ms)
+		medians[network] = time.Duration(ms[len(ms)/2]) * time.Millisecond
+	}
+	return medians
+}
+
+// rankAccepts orders the payable options most likely to succeed fastest first: options
+// the wallet can fund, then lower median settlement latency (networks never paid on
+// come after measured ones), then the lower price, then the server's order.
+func rankAccepts(candidates []acceptCandidate) []acceptCandidate {
+	ranked := slices.Clone(candidates)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.funded != b.funded {
+			return a.funded > b.funded
+		}
+		if (a.latency > 0) != (b.latency > 0) {
+			return a.latency > 0
+		}
+		if a.latency != b.latency {
+			return a.latency < b.latency
+		}
+		if a.cost != nil && b.cost != nil && a.cost.Cmp(b.cost) != 0 {
+			return a.cost.Cmp(b.cost) < 0
+		}
+		return a.index < b.index
+	})
+	return ranked
+}
+
+// smartRequirement picks the option to pay among accepts for --select smart, using the
+// wallet's (cached) balances and settlement latencies from the history ledger.
+func smartRequirement(address string, accepts []x402.PaymentRequirements, records []historyRecord) (*selectionResult, x402.PaymentRequirements, bool) {
+	latencies := settlementLatencies(records)
+	var candidates []acceptCandidate
+	for i, a := range accepts {
+		if a.Scheme != supportedScheme || !strings.HasPrefix(a.Network, "eip155:") {
+			continue
+		}
+		c := acceptCandidate{req: a, index: i, latency: latencies[a.Network]}
+		c.cost, _ = tokenUnits(a)
+		if info, ok := networkByChainID(a.Network); ok && strings.EqualFold(a.Asset, info.USDCContract) {
+			amount, _ := new(big.Int).SetString(a.Amount, 10)
+			if _, raw, _, err := usdcBalance(info, routedAddress(a.Network, address), false); err == nil && amount != nil {
+				c.funded = 1
+				if balanceBelow(raw, amount) {
+					c.funded = -1
+				}
+			}
+		}
+		candidates = append(candidates, c)
+	}
+	if len(candidates) == 0 {
+		return nil, x402.PaymentRequirements{}, false
+	}
+	best := rankAccepts(candidates)[0]
+
+	var reasons []string
+	switch best.funded {
+	case 1:
+		reasons = append(reasons, "funded")
+	case -1:
+		reasons = append(reasons, "no option is funded")
+	}
+	if best.latency > 0 {
+		reasons = append(reasons, fmt.Sprintf("median settlement %s", best.latency.Round(10*time.Millisecond)))
+	} else {
+		reasons = append(reasons, "no settlement history")
+	}
+	sel := &selectionResult{
+		Mode:    "smart",
+		Network: best.req.Network,
+		Asset:   best.req.Asset,
+		Amount:  best.req.Amount,
+		Reason:  strings.Join(reasons, ", "),
+	}
+	return sel, best.req, true
+}
+
+// parseNetworkList resolves a comma-separated list of network names (base, avalanche, ...)
+// or CAIP-2 IDs (eip155:8453) to CAIP-2 IDs, keeping the order.
+func parseNetworkList(spec string) ([]string, error) {
+	var ids []string
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch info, ok := networks[name]; {
+		case name == "":
+			continue
+		case ok:
+			ids = append(ids, info.ChainID)
+		case strings.HasPrefix(name, "eip155:"):
+			ids = append(ids, name)
+		default:
+			return nil, fmt.Errorf("unknown network %q (available: %s, or a CAIP-2 ID such as eip155:8453)", name, availableNetworks())
+		}
+	}
+	return ids, nil
+}
+
+// orderAccepts restricts accepts to network when one is given, and otherwise moves the
+// options on preferred networks to the front, in preference order. Options on networks
+// that are not listed keep the server's order after them.
+func orderAccepts(accepts []x402.PaymentRequirements, network string, preferred []string) ([]x402.PaymentRequirements, error) {
+	if network != "" {
+		var only []x402.PaymentRequirements
+		for _, a := range accepts {
+			if a.Network == network {
+				only = append(only, a)
+			}
+		}
+		if len(only) == 0 {
+			return nil, fmt.Errorf("the server does not accept payment on %s", networkName(network))
+		}
+		return only, nil
+	}
+	rank := func(a x402.PaymentRequirements) int {
+		if i := slices.Index(preferred, a.Network); i >= 0 {
+			return i
+		}
+		return len(preferred)
+	}
+	ordered := slices.Clone(accepts)
+	sort.SliceStable(ordered, func(i, j int) bool { return rank(ordered[i]) < rank(ordered[j]) })
+	return ordered, nil
+}
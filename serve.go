@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+
+	x402 "github.com/coinbase/x402/go"
+	x402evm "github.com/coinbase/x402/go/mechanisms/evm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// echoPaymentHeaders are the request headers x402 clients put payments in: v2 and v1.
+var echoPaymentHeaders = []string{"PAYMENT-SIGNATURE", "X-PAYMENT"}
+
+// echoHeader is one payment header as the echo server received it.
+type echoHeader struct {
+	Name    string          `json:"name"`
+	Raw     string          `json:"raw"`
+	Decoded json.RawMessage `json:"decoded,omitempty"`
+	Error   string          `json:"error,omitempty"` // why Raw is not base64-encoded JSON
+}
+
+// echoResult is the response body of `serve --echo` to a paid request.
+type echoResult struct {
+	Method   string              `json:"method"`
+	Path     string              `json:"path"`
+	Payments []echoHeader        `json:"payments"`
+	Headers  map[string][]string `json:"headers"`
+}
+
+// runServeCmd runs a local x402 server for debugging clients.
+func runServeCmd(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	var (
+		echo     bool
+		fixtures string
+		listen   string
+		network  string
+		amount   string
+		payTo    string
+	)
+	fs.BoolVar(&echo, "echo", false, "Answer paid requests with the payment headers they carried, decoded")
+	fs.StringVar(&fixtures, "fixtures", "", "Serve the routes of this YAML or JSON file, each with its own price, network, asset, and failure mode")
+	fs.StringVar(&listen, "listen", "127.0.0.1:4020", "Address to listen on")
+	fs.StringVar(&network, "network", "base-sepolia", "Network of the payment requirements (with --fixtures, of routes that name none)")
+	fs.StringVar(&amount, "amount", "1000", "Required amount in atomic units, e.g. 1000 = 0.001 USDC (with --fixtures, of routes that name none)")
+	fs.StringVar(&payTo, "pay-to", "0x000000000000000000000000000000000000dEaD", "Recipient address the requirements name (with --fixtures, of routes that name none)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli serve --echo [--listen 127.0.0.1:4020] [--network base-sepolia] [--amount <atomic>]\n")
+		fmt.Fprintf(os.Stderr, "       x402-cli serve --fixtures <routes.yaml> [--listen 127.0.0.1:4020]\n\n")
+		fmt.Fprintf(os.Stderr, "Runs a local x402 server for debugging clients. With --echo, unpaid requests get a 402\n")
+		fmt.Fprintf(os.Stderr, "with exact-scheme requirements, and requests carrying PAYMENT-SIGNATURE or X-PAYMENT get\n")
+		fmt.Fprintf(os.Stderr, "200 with the headers they carried, the payment ones decoded, so payments built by any\n")
+		fmt.Fprintf(os.Stderr, "x402 SDK can be inspected. No facilitator is involved and nothing is ever settled.\n\n")
+		fmt.Fprintf(os.Stderr, "With --fixtures, each route of the file has its own requirements and failure mode.\n")
+		fmt.Fprintf(os.Stderr, "Payments are checked offline as check-payment does; valid ones get 200 with a made-up\n")
+		fmt.Fprintf(os.Stderr, "transaction in PAYMENT-RESPONSE, unless the route fails them:\n\n")
+		fmt.Fprintf(os.Stderr, "  routes:\n")
+		fmt.Fprintf(os.Stderr, "    - path: /weather\n")
+		fmt.Fprintf(os.Stderr, "      amount: 1000\n")
+		fmt.Fprintf(os.Stderr, "    - path: /premium\n")
+		fmt.Fprintf(os.Stderr, "      network: base\n")
+		fmt.Fprintf(os.Stderr, "      amount: 250000\n")
+		fmt.Fprintf(os.Stderr, "      failure: always-reject   # reason: insufficient_funds\n")
+		fmt.Fprintf(os.Stderr, "    - path: /flaky\n")
+		fmt.Fprintf(os.Stderr, "      failure: flaky           # failRate: 0.5 of paid requests get 503\n")
+		fmt.Fprintf(os.Stderr, "    - path: /slow\n")
+		fmt.Fprintf(os.Stderr, "      failure: slow-settle     # settleDelay: 10s before answering\n\n")
+		fmt.Fprintf(os.Stderr, "Route keys: path, network, asset (token address; default USDC), amount, payTo, failure,\n")
+		fmt.Fprintf(os.Stderr, "reason, failRate, settleDelay. A JSON file holds an array of the same objects.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	fail := func(msg string) {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", msg)
+		os.Exit(ExitError)
+	}
+	if !echo && fixtures == "" {
+		fail("serve needs a mode: --echo or --fixtures <file>")
+	}
+	if echo && fixtures != "" {
+		fail("--echo and --fixtures cannot be combined")
+	}
+	info, ok := lookupNetwork(network)
+	if !ok {
+		fail(fmt.Sprintf("unknown network: %s (available: %s)", network, availableNetworks()))
+	}
+	if _, err := parseAddress(payTo, "pay-to"); err != nil {
+		fail(err.Error())
+	}
+	if v, ok := new(big.Int).SetString(amount, 10); !ok || v.Sign() <= 0 {
+		fail(fmt.Sprintf("invalid amount: %s (atomic units, e.g. 1000)", amount))
+	}
+	if fixtures != "" {
+		routes, err := loadServeFixtures(fixtures, info, amount, common.HexToAddress(payTo).Hex())
+		if err != nil {
+			fail(err.Error())
+		}
+		fmt.Fprintf(os.Stderr, "Serving %d fixture route(s) on http://%s\n", len(routes), listen)
+		for _, route := range routes {
+			fmt.Fprintf(os.Stderr, "  %s  %s\n", route.Path, route.describe())
+		}
+		if err := http.ListenAndServe(listen, fixturesHandler(routes)); err != nil {
+			fail(err.Error())
+		}
+		return
+	}
+	required, err := echoRequirements(info, amount, common.HexToAddress(payTo).Hex())
+	if err != nil {
+		fail(err.Error())
+	}
+
+	fmt.Fprintf(os.Stderr, "Echoing payment headers on http://%s (%s, %s atomic units)\n", listen, info.Name, amount)
+	if err := http.ListenAndServe(listen, echoHandler(required)); err != nil {
+		fail(err.Error())
+	}
+}
+
+// echoRequirements is the 402 the echo server answers unpaid requests with.
+func echoRequirements(info networkInfo, amount, payTo string) (x402.PaymentRequired, error) {
+	return serveRequirements(info, info.USDCContract, amount, payTo)
+}
+
+// serveRequirements is a 402 asking for amount of asset on info's network, exact scheme.
+func serveRequirements(info networkInfo, assetAddress, amount, payTo string) (x402.PaymentRequired, error) {
+	asset, err := x402evm.GetAssetInfo(info.ChainID, assetAddress)
+	if err != nil {
+		return x402.PaymentRequired{}, err
+	}
+	return x402.PaymentRequired{
+		X402Version: 2,
+		Error:       "Payment required",
+		Accepts: []x402.PaymentRequirements{{
+			Scheme:            supportedScheme,
+			Network:           info.ChainID,
+			Asset:             assetAddress,
+			Amount:            amount,
+			PayTo:             payTo,
+			MaxTimeoutSeconds: 300,
+			Extra:             map[string]interface{}{"name": asset.Name, "version": asset.Version},
+		}},
+	}, nil
+}
+
+// echoHandler asks unpaid requests for payment and echoes the headers of paid ones.
+func echoHandler(required x402.PaymentRequired) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out := echoResult{Method: r.Method, Path: r.URL.RequestURI(), Headers: r.Header}
+		for _, name := range echoPaymentHeaders {
+			if v := r.Header.Get(name); v != "" {
+				out.Payments = append(out.Payments, decodeEchoHeader(name, v))
+			}
+		}
+		fmt.Fprintf(os.Stderr, "%s %s: %d payment header(s)\n", r.Method, out.Path, len(out.Payments))
+
+		w.Header().Set("Content-Type", "application/json")
+		if len(out.Payments) == 0 {
+			writePaymentRequired(w, r, required)
+			return
+		}
+		body, _ := json.MarshalIndent(out, "", "  ")
+		w.Write(append(body, '\n'))
+	})
+}
+
+// writePaymentRequired answers r with a 402 carrying required, for r's URL.
+func writePaymentRequired(w http.ResponseWriter, r *http.Request, required x402.PaymentRequired) {
+	required.Resource = &x402.ResourceInfo{URL: "http://" + r.Host + r.URL.RequestURI()}
+	encoded, _ := json.Marshal(required)
+	w.Header().Set("PAYMENT-REQUIRED", base64.StdEncoding.EncodeToString(encoded))
+	w.WriteHeader(http.StatusPaymentRequired)
+	w.Write(encoded)
+}
+
+// decodeEchoHeader decodes a payment header: base64 (standard or URL-safe) of a JSON object.
+func decodeEchoHeader(name, value string) echoHeader {
+	h := echoHeader{Name: name, Raw: value}
+	value = strings.TrimSpace(value)
+	raw, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		if raw, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "=")); err != nil {
+			h.Error = "not base64: " + err.Error()
+			return h
+		}
+	}
+	if !json.Valid(raw) {
+		h.Error = "decoded header is not JSON"
+		return h
+	}
+	h.Decoded = raw
+	return h
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Failure modes of a fixture route. Every mode checks the payment first, so a malformed
+// payment is refused whichever the route uses.
+const (
+	fixtureAlwaysReject = "always-reject" // refuse the payment with a 402 naming reason
+	fixtureFlaky        = "flaky"         // answer a share (failRate) of paid requests with 503
+	fixtureSlowSettle   = "slow-settle"   // answer paid requests after settleDelay
+)
+
+// Route defaults for the failure modes.
+const (
+	fixtureDefaultReason      = "rejected_by_fixture"
+	fixtureDefaultFailRate    = 0.5
+	fixtureDefaultSettleDelay = 10 * time.Second
+)
+
+// serveFixture is one route of a `serve --fixtures` file.
+type serveFixture struct {
+	Path        string  `json:"path"`
+	Network     string  `json:"network,omitempty"`
+	Asset       string  `json:"asset,omitempty"`
+	Amount      string  `json:"amount,omitempty"`
+	PayTo       string  `json:"payTo,omitempty"`
+	Failure     string  `json:"failure,omitempty"`
+	Reason      string  `json:"reason,omitempty"`      // always-reject: the errorReason reported
+	FailRate    float64 `json:"failRate,omitempty"`    // flaky: share of paid requests that fail
+	SettleDelay string  `json:"settleDelay,omitempty"` // slow-settle: e.g. 10s
+
+	required x402.PaymentRequired
+	delay    time.Duration
+}
+
+// describe summarizes the route for the startup banner.
+func (f *serveFixture) describe() string {
+	s := describeAmount(f.required.Accepts[0]) + " on " + networkName(f.required.Accepts[0].Network)
+	switch f.Failure {
+	case fixtureAlwaysReject:
+		s += ", always rejected (" + f.Reason + ")"
+	case fixtureFlaky:
+		s += fmt.Sprintf(", flaky (%g of paid requests fail)", f.FailRate)
+	case fixtureSlowSettle:
+		s += fmt.Sprintf(", settles after %s", f.delay)
+	}
+	return s
+}
+
+// loadServeFixtures reads the routes of a fixtures file: a JSON array of routes or YAML in
+// the same shape, optionally under a top-level "routes:" key. Routes that name no network,
+// amount, or payTo get the ones given.
+func loadServeFixtures(path string, network networkInfo, amount, payTo string) ([]serveFixture, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var routes []serveFixture
+	if trimmed := strings.TrimSpace(string(raw)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(raw, &routes); err != nil {
+			return nil, fmt.Errorf("invalid fixtures file: %w", err)
+		}
+	} else if routes, err = parseFixturesYAML(string(raw)); err != nil {
+		return nil, err
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("no routes in %s", path)
+	}
+
+	seen := map[string]bool{}
+	for i := range routes {
+		route := &routes[i]
+		if err := route.resolve(network, amount, payTo); err != nil {
+			return nil, fmt.Errorf("route %d (%s): %w", i+1, route.Path, err)
+		}
+		if seen[route.Path] {
+			return nil, fmt.Errorf("route %d: %s is listed twice", i+1, route.Path)
+		}
+		seen[route.Path] = true
+	}
+	return routes, nil
+}
+
+// resolve validates the route, fills in its defaults, and builds its requirements.
+func (f *serveFixture) resolve(network networkInfo, amount, payTo string) error {
+	if !strings.HasPrefix(f.Path, "/") {
+		return fmt.Errorf("path must start with /")
+	}
+	info := network
+	if f.Network != "" {
+		var ok bool
+		if info, ok = lookupNetwork(f.Network); !ok {
+			return fmt.Errorf("unknown network: %s (available: %s)", f.Network, availableNetworks())
+		}
+	}
+	asset := info.USDCContract
+	if f.Asset != "" {
+		addr, err := parseAddress(f.Asset, "asset")
+		if err != nil {
+			return err
+		}
+		asset = addr.Hex()
+	}
+	if f.Amount == "" {
+		f.Amount = amount
+	}
+	if v, ok := new(big.Int).SetString(f.Amount, 10); !ok || v.Sign() <= 0 {
+		return fmt.Errorf("invalid amount: %s (atomic units, e.g. 1000)", f.Amount)
+	}
+	if f.PayTo == "" {
+		f.PayTo = payTo
+	}
+	recipient, err := parseAddress(f.PayTo, "payTo")
+	if err != nil {
+		return err
+	}
+
+	switch f.Failure {
+	case "":
+	case fixtureAlwaysReject:
+		if f.Reason == "" {
+			f.Reason = fixtureDefaultReason
+		}
+	case fixtureFlaky:
+		if f.FailRate == 0 {
+			f.FailRate = fixtureDefaultFailRate
+		}
+		if f.FailRate < 0 || f.FailRate > 1 {
+			return fmt.Errorf("failRate must be between 0 and 1, got %g", f.FailRate)
+		}
+	case fixtureSlowSettle:
+		f.delay = fixtureDefaultSettleDelay
+		if f.SettleDelay != "" {
+			if f.delay, err = time.ParseDuration(f.SettleDelay); err != nil || f.delay <= 0 {
+				return fmt.Errorf("invalid settleDelay %q (e.g. 10s)", f.SettleDelay)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown failure %q (want %s, %s, or %s)", f.Failure, fixtureAlwaysReject, fixtureFlaky, fixtureSlowSettle)
+	}
+
+	f.required, err = serveRequirements(info, asset, f.Amount, recipient.Hex())
+	return err
+}
+
+// parseFixturesYAML understands a list of "- key: value" maps with the serveFixture keys,
+// optionally nested under a top-level "routes:" key.
+func parseFixturesYAML(src string) ([]serveFixture, error) {
+	var routes []serveFixture
+	var current *serveFixture
+
+	scanner := bufio.NewScanner(strings.NewReader(src))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || line == "routes:" {
+			continue
+		}
+
+		if item, ok := strings.CutPrefix(line, "-"); ok {
+			routes = append(routes, serveFixture{})
+			current = &routes[len(routes)-1]
+			if line = strings.TrimSpace(item); line == "" {
+				continue
+			}
+		}
+		if current == nil {
+			return nil, fmt.Errorf("line %d: expected a list item", n)
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", n)
+		}
+		value = yamlScalar(value)
+		switch strings.TrimSpace(key) {
+		case "path":
+			current.Path = value
+		case "network":
+			current.Network = value
+		case "asset":
+			current.Asset = value
+		case "amount":
+			current.Amount = value
+		case "payTo":
+			current.PayTo = value
+		case "failure":
+			current.Failure = value
+		case "reason":
+			current.Reason = value
+		case "failRate":
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid failRate %q", n, value)
+			}
+			current.FailRate = rate
+		case "settleDelay":
+			current.SettleDelay = value
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %q", n, strings.TrimSpace(key))
+		}
+	}
+	return routes, scanner.Err()
+}
+
+// fixtureReceipt is the response body of a fixture route to a paid request.
+type fixtureReceipt struct {
+	Path        string `json:"path"`
+	Network     string `json:"network"`
+	Asset       string `json:"asset"`
+	Amount      string `json:"amount"`
+	Payer       string `json:"payer"`
+	Transaction string `json:"transaction"`
+}
+
+// fixturesHandler serves the fixture routes: unpaid requests get the route's 402, paid ones
+// are checked offline and then settled or failed as the route says. Nothing is ever settled
+// on-chain; the transaction is a hash of the payment header.
+func fixturesHandler(routes []serveFixture) http.Handler {
+	byPath := make(map[string]*serveFixture, len(routes))
+	for i := range routes {
+		byPath[routes[i].Path] = &routes[i]
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		route, ok := byPath[r.URL.Path]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "%s %s: no such route\n", r.Method, r.URL.Path)
+			writeFixtureError(w, http.StatusNotFound, "no fixture route for "+r.URL.Path, "")
+			return
+		}
+
+		var payment echoHeader
+		for _, name := range echoPaymentHeaders {
+			if v := r.Header.Get(name); v != "" {
+				payment = decodeEchoHeader(name, v)
+				break
+			}
+		}
+		if payment.Name == "" {
+			fmt.Fprintf(os.Stderr, "%s %s: payment required\n", r.Method, r.URL.Path)
+			writePaymentRequired(w, r, route.required)
+			return
+		}
+		if payment.Error != "" {
+			refuseFixturePayment(w, r, route, "invalid_payload", payment.Name+" is "+payment.Error)
+			return
+		}
+		check := checkPayment(route.required.Accepts, payment.Decoded, time.Now())
+		if !check.Valid {
+			refuseFixturePayment(w, r, route, "invalid_payload", failedCheck(check))
+			return
+		}
+
+		switch route.Failure {
+		case fixtureAlwaysReject:
+			refuseFixturePayment(w, r, route, route.Reason, "the route rejects every payment")
+			return
+		case fixtureFlaky:
+			if rand.Float64() < route.FailRate {
+				fmt.Fprintf(os.Stderr, "%s %s: flaky failure, not settled\n", r.Method, r.URL.Path)
+				writeFixtureError(w, http.StatusServiceUnavailable, "flaky fixture route failed", "the payment was not settled")
+				return
+			}
+		case fixtureSlowSettle:
+			select {
+			case <-time.After(route.delay):
+			case <-r.Context().Done():
+				return
+			}
+			w.Header().Set("Server-Timing", fmt.Sprintf("settle;dur=%d", route.delay.Milliseconds()))
+		}
+
+		accepted := route.required.Accepts[0]
+		tx := crypto.Keccak256Hash([]byte(payment.Raw)).Hex()
+		settle, _ := json.Marshal(x402.SettleResponse{Success: true, Payer: check.Payer, Transaction: tx, Network: x402.Network(accepted.Network)})
+		w.Header().Set("PAYMENT-RESPONSE", base64.StdEncoding.EncodeToString(settle))
+		fmt.Fprintf(os.Stderr, "%s %s: paid by %s, settled as %s\n", r.Method, r.URL.Path, check.Payer, tx)
+		body, _ := json.MarshalIndent(fixtureReceipt{
+			Path:        r.URL.Path,
+			Network:     accepted.Network,
+			Asset:       accepted.Asset,
+			Amount:      accepted.Amount,
+			Payer:       common.HexToAddress(check.Payer).Hex(),
+			Transaction: tx,
+		}, "", "  ")
+		w.Write(append(body, '\n'))
+	})
+}
+
+// refuseFixturePayment answers a paid request with a 402 naming why the payment was refused,
+// in PAYMENT-REQUIRED and PAYMENT-RESPONSE as facilitator-backed servers do.
+func refuseFixturePayment(w http.ResponseWriter, r *http.Request, route *serveFixture, reason, message string) {
+	fmt.Fprintf(os.Stderr, "%s %s: payment refused: %s (%s)\n", r.Method, r.URL.Path, reason, message)
+	settle, _ := json.Marshal(x402.SettleResponse{ErrorReason: reason, ErrorMessage: message, Network: x402.Network(route.required.Accepts[0].Network)})
+	w.Header().Set("PAYMENT-RESPONSE", base64.StdEncoding.EncodeToString(settle))
+	required := route.required
+	required.Error = reason
+	writePaymentRequired(w, r, required)
+}
+
+// writeFixtureError writes a JSON error body in the {"error", "details"} shape servers use
+// for settlement failures.
+func writeFixtureError(w http.ResponseWriter, status int, msg, details string) {
+	body, _ := json.Marshal(struct {
+		Error   string `json:"error"`
+		Details string `json:"details,omitempty"`
+	}{msg, details})
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// failedCheck describes the first check a payment failed.
+func failedCheck(r *checkPaymentResult) string {
+	for _, c := range r.Checks {
+		if !c.OK && !c.Skipped {
+			return c.Name + ": " + c.Detail
+		}
+	}
+	return "invalid payment"
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// confirmationPollInterval is how often the receipt and head block are polled.
+const confirmationPollInterval = 2 * time.Second
+
+// settlementCheck is the on-chain verification of a settlement transaction.
+type settlementCheck struct {
+	Transaction   string `json:"transaction"`
+	Network       string `json:"network"`
+	BlockNumber   uint64 `json:"blockNumber,omitempty"`
+	Confirmations uint64 `json:"confirmations"`
+	Required      uint64 `json:"required"`
+	Success       bool   `json:"success"`
+}
+
+// settlementTx extracts the transaction hash and network from a decoded PAYMENT-RESPONSE.
+func settlementTx(paymentResponse []byte) (string, string, error) {
+	var settle struct {
+		Transaction string `json:"transaction"`
+		Network     string `json:"network"`
+	}
+	if err := json.Unmarshal(paymentResponse, &settle); err != nil {
+		return "", "", fmt.Errorf("invalid PAYMENT-RESPONSE: %w", err)
+	}
+	if settle.Transaction == "" {
+		return "", "", fmt.Errorf("PAYMENT-RESPONSE has no settlement transaction")
+	}
+	return settle.Transaction, settle.Network, nil
+}
+
+// waitForConfirmations polls until txHash is mined successfully and buried under
+// required blocks (the inclusion block counts as the first confirmation).
+func waitForConfirmations(ctx context.Context, info networkInfo, txHash string, required uint64) (*settlementCheck, error) {
+	check := &settlementCheck{Transaction: txHash, Network: info.ChainID, Required: required}
+	for {
+		var receipt *struct {
+			Status      string `json:"status"`
+			BlockNumber string `json:"blockNumber"`
+		}
+		if err := rpcCall(info.RPCURL, "eth_getTransactionReceipt", []any{txHash}, &receipt); err != nil {
+			return check, err
+		}
+		if receipt != nil {
+			if receipt.Status != "0x1" {
+				return check, fmt.Errorf("settlement transaction %s reverted", txHash)
+			}
+			block, err := parseHexUint(receipt.BlockNumber)
+			if err != nil {
+				return check, err
+			}
+			var headHex string
+			if err := rpcCall(info.RPCURL, "eth_blockNumber", []any{}, &headHex); err != nil {
+				return check, err
+			}
+			head, err := parseHexUint(headHex)
+			if err != nil {
+				return check, err
+			}
+			check.BlockNumber = block.Uint64()
+			if head.Uint64() >= check.BlockNumber {
+				check.Confirmations = head.Uint64() - check.BlockNumber + 1
+			}
+			if check.Confirmations >= required {
+				check.Success = true
+				return check, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return check, fmt.Errorf("timed out waiting for %d confirmations of %s (have %d)",
+				required, txHash, check.Confirmations)
+		case <-time.After(confirmationPollInterval):
+		}
+	}
+}
+
+// verifySettlement waits for the settlement transaction in pay's PAYMENT-RESPONSE to reach
+// the required confirmation depth; zero uses the network default.
+func verifySettlement(pay *payResult, required uint64, timeout time.Duration, log func(string, ...any)) (*settlementCheck, error) {
+	if pay.PaymentResponse == nil {
+		return nil, fmt.Errorf("no PAYMENT-RESPONSE header to verify")
+	}
+	txHash, network, err := settlementTx(*pay.PaymentResponse)
+	if err != nil {
+		return nil, err
+	}
+	info, ok := lookupNetwork(network)
+	if !ok {
+		return nil, fmt.Errorf("cannot verify settlement on unsupported network %s", network)
+	}
+	if required == 0 {
+		required = info.Confirmations
+	}
+
+	log("Waiting for %d confirmation(s) of %s on %s...\n", required, txHash, info.Name)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	check, err := waitForConfirmations(ctx, info, txHash, required)
+	if err == nil {
+		log("Settlement confirmed in block %d (%d confirmations).\n", check.BlockNumber, check.Confirmations)
+	}
+	return check, err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+
+	x402evm "github.com/coinbase/x402/go/mechanisms/evm"
+	evmsigners "github.com/coinbase/x402/go/signers/evm"
+)
+
+// sharedSigners are the signers unlocked so far, by the SHA-256 of their private key, so
+// that every payment a process makes with one key is signed by the same instance.
+var (
+	sharedSignersMu sync.Mutex
+	sharedSigners   = map[[32]byte]*sharedSigner{}
+)
+
+// sharedSigner is a signer safe to share across concurrent payments. The underlying key is
+// only read; each payment draws its own random authorization nonce, and the signer refuses
+// to sign a nonce it has signed before, so concurrent payments can never share one.
+// `vectors` and `fuzz` sign their deliberately replayed authorizations with signers of
+// their own.
+type sharedSigner struct {
+	x402evm.ClientEvmSigner
+
+	mu     sync.Mutex
+	nonces map[string]bool
+}
+
+// signerFor returns the shared signer for a hex private key, unlocking it on first use.
+func signerFor(privateKey string) (x402evm.ClientEvmSigner, error) {
+	id := sha256.Sum256([]byte(strings.ToLower(strings.TrimPrefix(strings.TrimSpace(privateKey), "0x"))))
+	sharedSignersMu.Lock()
+	defer sharedSignersMu.Unlock()
+	if s, ok := sharedSigners[id]; ok {
+		return s, nil
+	}
+	signer, err := evmsigners.NewClientSignerFromPrivateKey(strings.TrimSpace(privateKey))
+	if err != nil {
+		return nil, err
+	}
+	s := &sharedSigner{ClientEvmSigner: signer, nonces: map[string]bool{}}
+	sharedSigners[id] = s
+	return s, nil
+}
+
+// SignTypedData signs like the underlying signer, after checking that the message's nonce,
+// if it has one, was not signed before by this process.
+func (s *sharedSigner) SignTypedData(ctx context.Context, domain x402evm.TypedDataDomain, types map[string][]x402evm.TypedDataField,
+	primaryType string, message map[string]any) ([]byte, error) {
+	if nonce, ok := message["nonce"]; ok {
+		key := fmt.Sprintf("%v/%s/%v", domain.ChainID, strings.ToLower(domain.VerifyingContract), nonce)
+		s.mu.Lock()
+		seen := s.nonces[key]
+		s.nonces[key] = true
+		s.mu.Unlock()
+		if seen {
+			return nil, fmt.Errorf("refusing to sign nonce %v twice", nonce)
+		}
+	}
+	return s.ClientEvmSigner.SignTypedData(ctx, domain, types, primaryType, message)
+}
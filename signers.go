@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	x402evm "github.com/coinbase/x402/go/mechanisms/evm"
+)
+
+// routedSigners are the --signers keys by CAIP-2 network. A network without a route is
+// paid by the active profile, which a "*" route sets.
+var routedSigners = map[string]x402evm.ClientEvmSigner{}
+
+// routedProfiles are the profiles of routedSigners, by CAIP-2 network.
+var routedProfiles = map[string]string{}
+
+// signersByEnv is the default for --signers.
+func signersByEnv() string {
+	return os.Getenv("X402_SIGNERS")
+}
+
+// signerRoute sends the payments on one network ("*" for every other) to a profile's key.
+type signerRoute struct {
+	Network string // CAIP-2, or "*"
+	Profile string // "" is EVM_PRIVATE_KEY
+}
+
+// parseSignerRoutes parses --signers, e.g. "base=treasury,avalanche=ops,*=default":
+// network names or CAIP-2 IDs, each mapped to a profile ("default" is EVM_PRIVATE_KEY).
+func parseSignerRoutes(spec string) ([]signerRoute, error) {
+	var routes []signerRoute
+	seen := map[string]bool{}
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		network, name, ok := strings.Cut(field, "=")
+		network, name = strings.TrimSpace(network), strings.TrimSpace(name)
+		if !ok || network == "" || name == "" {
+			return nil, fmt.Errorf("invalid --signers rule %q: want network=profile", field)
+		}
+		r := signerRoute{Network: network, Profile: name}
+		if name == "default" {
+			r.Profile = ""
+		}
+		if network != "*" {
+			info, ok := lookupNetwork(network)
+			switch {
+			case ok:
+				r.Network = info.ChainID
+			case strings.HasPrefix(network, "eip155:"):
+			case strings.HasPrefix(network, "solana") || strings.Contains(network, ":"):
+				return nil, fmt.Errorf("invalid --signers rule %q: this build signs only EVM (eip155) networks", field)
+			default:
+				return nil, fmt.Errorf("invalid --signers rule %q: unknown network %q", field, network)
+			}
+		}
+		if seen[r.Network] {
+			return nil, fmt.Errorf("invalid --signers: %s is routed twice", network)
+		}
+		seen[r.Network] = true
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// applySignerRoutes loads the key of every route, so that a missing key fails the run
+// before anything is paid. A "*" route replaces the active profile.
+func applySignerRoutes(spec string) error {
+	routes, err := parseSignerRoutes(spec)
+	if err != nil {
+		return err
+	}
+	for _, r := range routes {
+		if r.Network == "*" {
+			profile = r.Profile
+			continue
+		}
+		key := os.Getenv(profileKeyVar(r.Profile))
+		if key == "" {
+			return fmt.Errorf("--signers: %s is required to pay on %s", profileKeyVar(r.Profile), networkName(r.Network))
+		}
+		signer, err := signerFor(key)
+		if err != nil {
+			return fmt.Errorf("--signers: %s: %w", profileKeyVar(r.Profile), err)
+		}
+		routedSigners[r.Network] = signer
+		routedProfiles[r.Network] = r.Profile
+	}
+	return nil
+}
+
+// routedProfile is the profile that pays on network.
+func routedProfile(network string) string {
+	if p, ok := routedProfiles[network]; ok {
+		return p
+	}
+	return profile
+}
+
+// routedSigner is the signer that pays on network: its routed signer, else signer.
+func routedSigner(network string, signer x402evm.ClientEvmSigner) x402evm.ClientEvmSigner {
+	if s, ok := routedSigners[network]; ok {
+		return s
+	}
+	return signer
+}
+
+// routedAddress is the wallet that pays on network: its routed signer, else address.
+func routedAddress(network, address string) string {
+	if s, ok := routedSigners[network]; ok {
+		return s.Address()
+	}
+	return address
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// typedDataSignature is the JSON output of `sign-typed-data`.
+type typedDataSignature struct {
+	Signer      string `json:"signer,omitempty"`
+	PrimaryType string `json:"primaryType"`
+	// ChainID is the CAIP-2 network of the domain's chainId; empty when the domain has none.
+	ChainID         string `json:"chainId,omitempty"`
+	DomainSeparator string `json:"domainSeparator"`
+	MessageHash     string `json:"messageHash"`         // hashStruct of the message
+	Digest          string `json:"digest"`              // keccak256(0x1901 || domainSeparator || messageHash), what is signed
+	Signature       string `json:"signature,omitempty"` // r || s || v, with v 27 or 28
+}
+
+// tokenMovingTypes are primary types whose signature alone lets its holder move or approve
+// the signer's tokens: EIP-2612 and Permit2 permits, and EIP-3009 authorizations.
+var tokenMovingTypes = map[string]bool{
+	"Permit":                    true,
+	"PermitSingle":              true,
+	"PermitBatch":               true,
+	"PermitTransferFrom":        true,
+	"PermitBatchTransferFrom":   true,
+	"TransferWithAuthorization": true,
+	"ReceiveWithAuthorization":  true,
+}
+
+// tokenMovingFields are the message fields shown before a token-moving type is signed.
+var tokenMovingFields = []string{"from", "to", "spender", "value", "amount", "deadline", "validBefore", "sigDeadline"}
+
+// tokenMovingFieldNames are the fields that mark a message for a contract as moving or
+// approving tokens, whatever its primary type is called.
+var tokenMovingFieldNames = map[string]bool{"spender": true, "value": true, "to": true, "amount": true}
+
+// movesTokens reports whether td may let its signature's holder move or approve tokens:
+// its primary type is a known permit or authorization, or its domain names a contract and
+// the primary type, or a struct it contains, has a spender, value, to, or amount field.
+func movesTokens(td *apitypes.TypedData) bool {
+	if tokenMovingTypes[td.PrimaryType] {
+		return true
+	}
+	if td.Domain.VerifyingContract == "" {
+		return false
+	}
+	seen := map[string]bool{}
+	pending := []string{td.PrimaryType}
+	for len(pending) > 0 {
+		name := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		for _, field := range td.Types[name] {
+			if tokenMovingFieldNames[field.Name] {
+				return true
+			}
+			inner, _, _ := strings.Cut(field.Type, "[") // a struct array's element type
+			if td.Types[inner] != nil {
+				pending = append(pending, inner)
+			}
+		}
+	}
+	return false
+}
+
+// hashTypedData computes the EIP-712 domain separator, message hash, and digest of an
+// eth_signTypedData_v4 payload.
+func hashTypedData(raw []byte) (*typedDataSignature, []byte, error) {
+	out, digest, _, err := parseTypedData(raw)
+	return out, digest, err
+}
+
+// parseTypedData is hashTypedData that also returns the decoded payload.
+func parseTypedData(raw []byte) (*typedDataSignature, []byte, *apitypes.TypedData, error) {
+	var td apitypes.TypedData
+	if err := json.Unmarshal(raw, &td); err != nil {
+		return nil, nil, nil, fmt.Errorf("not EIP-712 typed data: %w", err)
+	}
+	if td.PrimaryType == "" {
+		return nil, nil, nil, fmt.Errorf("not EIP-712 typed data: primaryType is missing")
+	}
+	if _, ok := td.Types["EIP712Domain"]; !ok {
+		return nil, nil, nil, fmt.Errorf("not EIP-712 typed data: types.EIP712Domain is missing")
+	}
+	domain, err := td.HashStruct("EIP712Domain", td.Domain.Map())
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("cannot hash domain: %w", err)
+	}
+	message, err := td.HashStruct(td.PrimaryType, td.Message)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("cannot hash %s message: %w", td.PrimaryType, err)
+	}
+	digest := crypto.Keccak256(append(append([]byte{0x19, 0x01}, domain...), message...))
+	var chainID string
+	if td.Domain.ChainId != nil {
+		chainID = "eip155:" + (*big.Int)(td.Domain.ChainId).String()
+	}
+	return &typedDataSignature{
+		PrimaryType:     td.PrimaryType,
+		ChainID:         chainID,
+		DomainSeparator: domain.String(),
+		MessageHash:     message.String(),
+		Digest:          hexutil.Encode(digest),
+	}, digest, &td, nil
+}
+
+// checkTypedDataChain refuses to sign for a mainnet chainId, or a domain without one, which
+// is valid on every chain, unless mainnet is set; unknown chains count as mainnets.
+func checkTypedDataChain(chainID string, mainnet bool) error {
+	switch {
+	case mainnet:
+	case chainID == "":
+		return errors.New("the domain has no chainId, so the signature is valid on every chain, mainnets included: pass --mainnet (or set X402_ALLOW_MAINNET=1) to sign it")
+	case !isTestnet(chainID):
+		return fmt.Errorf("the domain is for %s, a mainnet: pass --mainnet (or set X402_ALLOW_MAINNET=1) to sign for real funds", networkName(chainID))
+	}
+	return nil
+}
+
+// runSignTypedDataCmd signs an arbitrary EIP-712 payload with the configured wallet.
+func runSignTypedDataCmd(args []string) {
+	fs := flag.NewFlagSet("sign-typed-data", flag.ExitOnError)
+	var (
+		hashOnly bool
+		jsonOut  bool
+		mainnet  bool
+		autoYes  bool
+	)
+	fs.BoolVar(&hashOnly, "hash-only", false, "Print the domain separator, message hash, and digest without signing")
+	fs.BoolVar(&jsonOut, "json", false, "Output JSON")
+	fs.BoolVar(&mainnet, "mainnet", mainnetAllowedByEnv(), "Sign for a mainnet chainId (or a domain without one) (default: $X402_ALLOW_MAINNET)")
+	fs.BoolVar(&autoYes, "yes", false, "Sign permits and transfer authorizations without prompting for confirmation")
+	fs.BoolVar(&autoYes, "y", false, "Sign permits and transfer authorizations without prompting for confirmation (shorthand)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli sign-typed-data [--hash-only] [--mainnet] [-y] [--json] <data.json>\n\n")
+		fmt.Fprintf(os.Stderr, "Signs an EIP-712 payload in eth_signTypedData_v4 form ({types, primaryType, domain,\n")
+		fmt.Fprintf(os.Stderr, "message}; \"-\" reads stdin) with the EVM_PRIVATE_KEY wallet and prints the signature and\n")
+		fmt.Fprintf(os.Stderr, "the hashes it covers, to compare with what a facilitator or scheme computes.\n")
+		fmt.Fprintf(os.Stderr, "Only testnet domains are signed without --mainnet. A permit or transfer authorization\n")
+		fmt.Fprintf(os.Stderr, "lets whoever holds its signature move the wallet's tokens, so it is signed only once\n")
+		fmt.Fprintf(os.Stderr, "confirmed, or with -y. So is any message for a contract with a spender, value, to, or\n")
+		fmt.Fprintf(os.Stderr, "amount field, whatever its type is named.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	fail := func(msg string) {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", msg)
+		os.Exit(ExitError)
+	}
+
+	var raw []byte
+	var err error
+	if file := fs.Arg(0); file == "-" {
+		raw, err = io.ReadAll(os.Stdin)
+	} else {
+		raw, err = os.ReadFile(file)
+	}
+	if err != nil {
+		fail(err.Error())
+	}
+	out, digest, td, err := parseTypedData(raw)
+	if err != nil {
+		fail(err.Error())
+	}
+	if !hashOnly {
+		if err := checkTypedDataChain(out.ChainID, mainnet); err != nil {
+			fail(err.Error())
+		}
+		if movesTokens(td) && !autoYes {
+			if jsonOut {
+				fail(fmt.Sprintf("%s lets its holder move the wallet's tokens: pass -y to sign it in JSON mode", out.PrimaryType))
+			}
+			fmt.Printf("%s lets whoever holds this signature move or approve the wallet's tokens.\n", out.PrimaryType)
+			fmt.Printf("  token:    %s on %s\n", td.Domain.VerifyingContract, networkName(out.ChainID))
+			for _, field := range tokenMovingFields {
+				if v, ok := td.Message[field]; ok {
+					fmt.Printf("  %-9s %v\n", field+":", v)
+				}
+			}
+			if !confirmPrompt("Sign it? [y/N] ") {
+				fmt.Println("Aborted.")
+				os.Exit(ExitError)
+			}
+		}
+		key, err := loadPrivateKey()
+		if err != nil {
+			fail(err.Error())
+		}
+		sig, err := crypto.Sign(digest, key)
+		if err != nil {
+			fail("failed to sign: " + err.Error())
+		}
+		sig[64] += 27 // eth_signTypedData's v
+		out.Signer = crypto.PubkeyToAddress(key.PublicKey).Hex()
+		out.Signature = "0x" + hex.EncodeToString(sig)
+	}
+
+	if jsonOut {
+		printJSON(out)
+		return
+	}
+	fmt.Printf("Primary type:     %s\n", out.PrimaryType)
+	fmt.Printf("Domain separator: %s\n", out.DomainSeparator)
+	fmt.Printf("Message hash:     %s\n", out.MessageHash)
+	fmt.Printf("Digest:           %s\n", out.Digest)
+	if !hashOnly {
+		fmt.Printf("Signer:           %s\n", out.Signer)
+		fmt.Printf("Signature:        %s\n", out.Signature)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+	x402http "github.com/coinbase/x402/go/http"
+	x402evm "github.com/coinbase/x402/go/mechanisms/evm"
+	evm "github.com/coinbase/x402/go/mechanisms/evm/exact/client"
+)
+
+// simulationResult is the facilitator's verdict on a payment that was signed but never sent
+// to the resource server.
+type simulationResult struct {
+	Facilitator   string `json:"facilitator"`
+	Scheme        string `json:"scheme"`
+	Network       string `json:"network"`
+	Asset         string `json:"asset"`
+	Amount        string `json:"amount"`
+	Valid         bool   `json:"valid"`
+	InvalidReason string `json:"invalidReason,omitempty"`
+	Payer         string `json:"payer,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// facilitatorComparison is the JSON output of --simulate with several facilitators.
+type facilitatorComparison struct {
+	Agree    bool                `json:"agree"`
+	Verdicts []*simulationResult `json:"verdicts"`
+}
+
+// newSimulationResult records a facilitator's verdict on the selected requirement.
+func newSimulationResult(facilitatorURL string, selected x402.PaymentRequirements, verdict *x402.VerifyResponse) *simulationResult {
+	sim := &simulationResult{
+		Facilitator: facilitatorURL,
+		Scheme:      selected.Scheme,
+		Network:     selected.Network,
+		Asset:       selected.Asset,
+		Amount:      selected.Amount,
+	}
+	if verdict != nil {
+		sim.Valid = verdict.IsValid
+		sim.InvalidReason = verdict.InvalidReason
+		sim.Payer = verdict.Payer
+		if verdict.InvalidMessage != "" {
+			sim.InvalidReason += ": " + verdict.InvalidMessage
+		}
+	}
+	return sim
+}
+
+// signPayment signs a v2 payment payload for the selected accepts entry, returning the
+// payload and requirements as the JSON bytes facilitators expect.
+func signPayment(ctx context.Context, signer x402evm.ClientEvmSigner, required *x402.PaymentRequired, selected x402.PaymentRequirements) (payload, requirements []byte, err error) {
+	if required.X402Version != 2 {
+		return nil, nil, fmt.Errorf("signing without sending requires x402 v2 requirements (got version %d)", required.X402Version)
+	}
+	if selected.Scheme == "" {
+		return nil, nil, errors.New("no offered option can be paid by this build")
+	}
+	client := x402.Newx402Client().Register("eip155:*", evm.NewExactEvmScheme(signer))
+	signed, err := client.CreatePaymentPayload(ctx, selected, required.Resource, required.Extensions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign payment: %w", err)
+	}
+	payload, _ = json.Marshal(signed)
+	requirements, _ = json.Marshal(selected)
+	return payload, requirements, nil
+}
+
+// verifyPayment asks a facilitator to verify a signed payment. Rejections reported as
+// non-200 responses are folded into an invalid verdict rather than an error.
+func verifyPayment(ctx context.Context, facilitatorURL string, timeout time.Duration, payload, requirements []byte) (*x402.VerifyResponse, error) {
+	fc := x402http.NewHTTPFacilitatorClient(&x402http.FacilitatorConfig{URL: facilitatorURL, Timeout: timeout})
+	verdict, err := fc.Verify(ctx, payload, requirements)
+	var verifyErr *x402.VerifyError
+	if errors.As(err, &verifyErr) && verifyErr.InvalidReason != x402.ErrInvalidResponse {
+		return &x402.VerifyResponse{
+			IsValid:        false,
+			InvalidReason:  verifyErr.InvalidReason,
+			InvalidMessage: verifyErr.InvalidMessage,
+			Payer:          verifyErr.Payer,
+		}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("facilitator verify failed: %w", err)
+	}
+	return verdict, nil
+}
+
+// runSimulation handles --simulate in place of Step 2: it signs a payment for selected,
+// submits it only to the facilitator's /verify endpoint, and exits 0 when the facilitator
+// would accept it, or 4 or 2 when it would reject it.
+func runSimulation(result *jsonResult, signer x402evm.ClientEvmSigner, resp *http.Response, body []byte,
+	selected x402.PaymentRequirements, facilitatorURL string, timeout time.Duration, jsonOutput bool, log func(string, ...any)) {
+	fail := func(err error, code int, kind string) {
+		log("Simulation failed: %v\n", err)
+		result.Status = "error"
+		result.Error = err.Error()
+		result.ErrorType = kind
+		if jsonOutput {
+			exitJSON(result, code)
+		}
+		exit(code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	required, err := decodeRequirements(resp, body)
+	if err != nil {
+		fail(err, ExitError, "")
+	}
+	payload, requirements, err := signPayment(ctx, signer, required, selected)
+	if err != nil {
+		fail(err, ExitError, "")
+	}
+
+	artifacts.write("payment.json", payload)
+	log("Simulating %s on %s against facilitator %s (verify only, nothing is settled)...\n",
+		describeAmount(selected), networkName(selected.Network), facilitatorURL)
+	verdict, err := verifyPayment(ctx, facilitatorURL, timeout, payload, requirements)
+	if err != nil {
+		code, kind := classifyError(err)
+		if kind == "" {
+			code, kind = ExitFacilitatorError, "facilitator"
+		}
+		fail(err, code, kind)
+	}
+
+	sim := newSimulationResult(facilitatorURL, selected, verdict)
+	result.Simulation = sim
+
+	code := ExitSuccess
+	switch {
+	case sim.Valid:
+		log("Simulation: the facilitator would ACCEPT this payment.\n")
+		result.Status = "simulated"
+	case isInsufficientFunds(sim.InvalidReason):
+		log("Simulation: the facilitator would REJECT this payment: %s\n", sim.InvalidReason)
+		result.Status = "insufficient_funds"
+		result.Error = sim.InvalidReason
+		code = ExitInsufficientFunds
+	default:
+		log("Simulation: the facilitator would REJECT this payment: %s\n", sim.InvalidReason)
+		result.Status = "rejected"
+		result.Error = sim.InvalidReason
+		code = ExitPaymentRejected
+	}
+	if jsonOutput {
+		exitJSON(result, code)
+	}
+	exit(code)
+}
+
+// runFacilitatorComparison submits one signed payment to several facilitators' /verify
+// endpoints and reports whether their verdicts agree. It exits 0 when all accept, 2 when
+// all reject, and 8 when they disagree or any facilitator could not be reached.
+func runFacilitatorComparison(result *jsonResult, signer x402evm.ClientEvmSigner, resp *http.Response, body []byte,
+	selected x402.PaymentRequirements, facilitatorURLs []string, timeout time.Duration, jsonOutput bool, log func(string, ...any)) {
+	finish := func(code int) {
+		if jsonOutput {
+			exitJSON(result, code)
+		}
+		exit(code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	required, err := decodeRequirements(resp, body)
+	var payload, requirements []byte
+	if err == nil {
+		payload, requirements, err = signPayment(ctx, signer, required, selected)
+	}
+	if err != nil {
+		log("Simulation failed: %v\n", err)
+		result.Status = "error"
+		result.Error = err.Error()
+		finish(ExitError)
+	}
+
+	artifacts.write("payment.json", payload)
+	log("Comparing %d facilitators on %s on %s (verify only, nothing is settled)...\n\n",
+		len(facilitatorURLs), describeAmount(selected), networkName(selected.Network))
+	cmp := compareFacilitators(ctx, facilitatorURLs, timeout, payload, requirements, selected)
+	result.Comparison = cmp
+	if !jsonOutput {
+		writeComparison(os.Stdout, cmp)
+	}
+
+	switch {
+	case !cmp.Agree:
+		log("\nFacilitators DISAGREE on this payment.\n")
+		result.Status = "error"
+		result.Error = "facilitator verdicts differ"
+		result.ErrorType = "facilitator"
+		finish(ExitFacilitatorError)
+	case cmp.Verdicts[0].Valid:
+		log("\nAll facilitators would ACCEPT this payment.\n")
+		result.Status = "simulated"
+		finish(ExitSuccess)
+	default:
+		log("\nAll facilitators would REJECT this payment.\n")
+		result.Status = "rejected"
+		result.Error = cmp.Verdicts[0].InvalidReason
+		finish(ExitPaymentRejected)
+	}
+}
+
+// compareFacilitators asks each facilitator to verify the same signed payment, all at once,
+// and returns their verdicts in the order of facilitatorURLs. They agree when every one was
+// reached and all accept or all reject.
+func compareFacilitators(ctx context.Context, facilitatorURLs []string, timeout time.Duration,
+	payload, requirements []byte, selected x402.PaymentRequirements) *facilitatorComparison {
+	verdicts := make([]*simulationResult, len(facilitatorURLs))
+	var wg sync.WaitGroup
+	for i, u := range facilitatorURLs {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			verdict, err := verifyPayment(ctx, u, timeout, payload, requirements)
+			verdicts[i] = newSimulationResult(u, selected, verdict)
+			if err != nil {
+				verdicts[i].Error = err.Error()
+			}
+		}(i, u)
+	}
+	wg.Wait()
+
+	cmp := &facilitatorComparison{Agree: true, Verdicts: verdicts}
+	for _, v := range verdicts {
+		if v.Error != "" || v.Valid != verdicts[0].Valid {
+			cmp.Agree = false
+		}
+	}
+	return cmp
+}
+
+// writeComparison prints the verdicts of a comparison as a table, one facilitator a row.
+func writeComparison(w io.Writer, cmp *facilitatorComparison) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FACILITATOR\tVERDICT\tREASON")
+	for _, v := range cmp.Verdicts {
+		verdict, reason := "accept", v.InvalidReason
+		switch {
+		case v.Error != "":
+			verdict, reason = "error", v.Error
+		case !v.Valid:
+			verdict = "reject"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", v.Facilitator, verdict, reason)
+	}
+	tw.Flush()
+}
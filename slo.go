@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sloResult is how a paid run measured up to --slo-latency and --slo-settlement.
+type sloResult struct {
+	// LatencyMs is the paid request's time, from sending the payment to the response.
+	LatencyMs      int64 `json:"latencyMs"`
+	LatencyLimitMs int64 `json:"latencyLimitMs,omitempty"`
+	// SettlementMs is the time from sending the payment to its settlement being confirmed on-chain.
+	SettlementMs      int64 `json:"settlementMs,omitempty"`
+	SettlementLimitMs int64 `json:"settlementLimitMs,omitempty"`
+	// Breached lists the objectives the run missed: "latency" and "settlement".
+	Breached []string `json:"breached,omitempty"`
+}
+
+// checkSLO compares the measured latency and settlement time with their limits; a zero
+// limit or an unmeasured settlement is not checked.
+func checkSLO(latency, latencyLimit, settlement, settlementLimit time.Duration) *sloResult {
+	s := &sloResult{
+		LatencyMs:         latency.Milliseconds(),
+		LatencyLimitMs:    latencyLimit.Milliseconds(),
+		SettlementMs:      settlement.Milliseconds(),
+		SettlementLimitMs: settlementLimit.Milliseconds(),
+	}
+	if latencyLimit > 0 && latency > latencyLimit {
+		s.Breached = append(s.Breached, "latency")
+	}
+	if settlementLimit > 0 && settlement > settlementLimit {
+		s.Breached = append(s.Breached, "settlement")
+	}
+	return s
+}
+
+// message describes the missed objectives, or is empty when all were met.
+func (s *sloResult) message() string {
+	var missed []string
+	ms := func(v int64) string { return (time.Duration(v) * time.Millisecond).String() }
+	for _, b := range s.Breached {
+		switch b {
+		case "latency":
+			missed = append(missed, fmt.Sprintf("the paid request took %s (--slo-latency %s)", ms(s.LatencyMs), ms(s.LatencyLimitMs)))
+		case "settlement":
+			missed = append(missed, fmt.Sprintf("settlement took %s (--slo-settlement %s)", ms(s.SettlementMs), ms(s.SettlementLimitMs)))
+		}
+	}
+	if len(missed) == 0 {
+		return ""
+	}
+	return "SLO missed: " + strings.Join(missed, "; ")
+}
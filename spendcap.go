@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// spendCeiling is --max-total-spend: a hard cap on what one invocation may pay in total,
+// across retries, every endpoint of a batch or of several URLs, and every script step.
+// Unlike --host-budget it does not read the ledger; it counts this process's payments.
+type spendCeiling struct {
+	limit *big.Rat
+	mu    sync.Mutex
+	spent *big.Rat // payments sent and not refused, in token units
+}
+
+// maxTotalSpendByEnv is the default for --max-total-spend.
+func maxTotalSpendByEnv() string {
+	return os.Getenv("X402_MAX_TOTAL_SPEND")
+}
+
+// newSpendCeiling parses --max-total-spend, e.g. "0.5" or "0.5USDC"; "" means no ceiling.
+func newSpendCeiling(spec string) (*spendCeiling, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	limit, err := parseTokenAmount(spec)
+	if err != nil {
+		return nil, fmt.Errorf("--max-total-spend: %w", err)
+	}
+	return &spendCeiling{limit: limit, spent: new(big.Rat)}, nil
+}
+
+// reserve counts a payment at price against the ceiling before it is sent, or refuses it
+// when the total would exceed the ceiling. Call release when the payment was refused by
+// the server and cannot settle. A nil ceiling allows everything.
+func (c *spendCeiling) reserve(price x402.PaymentRequirements) (release func(), err error) {
+	if c == nil {
+		return func() {}, nil
+	}
+	cost, ok := tokenUnits(price)
+	if !ok {
+		return nil, fmt.Errorf("--max-total-spend %s: cannot convert the price (%s) to token units", ratString(c.limit), describeAmount(price))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if total := new(big.Rat).Add(c.spent, cost); total.Cmp(c.limit) > 0 {
+		return nil, fmt.Errorf("--max-total-spend %s exceeded: %s already paid in this run, this payment adds %s",
+			ratString(c.limit), ratString(c.spent), ratString(cost))
+	}
+	c.spent.Add(c.spent, cost)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			c.spent.Sub(c.spent, cost)
+			c.mu.Unlock()
+		})
+	}, nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// sealedPrefix marks a ledger line encrypted by sealLine, as
+// x402enc:v2:<base64 salt>:<base64 nonce and ciphertext>. Plaintext lines written before
+// encryption was enabled stay readable, and so do lines of legacySealedPrefix.
+const sealedPrefix = "x402enc:v2:"
+
+// legacySealedPrefix marks a line sealed under a key hashed from the secret with SHA-256,
+// before the scrypt key derivation; such lines are only read.
+const legacySealedPrefix = "x402enc:v1:"
+
+// stateSaltFile holds the random scrypt salt of this installation's state key.
+const stateSaltFile = "state.salt"
+
+// scrypt parameters of the state key: N=2^15, r=8, p=1, about 32 MiB and tens of
+// milliseconds, paid once per run and salt.
+const (
+	stateKeyScryptN = 1 << 15
+	stateKeyScryptR = 8
+	stateKeyScryptP = 1
+)
+
+// keychainService and keychainAccount name the state key in the OS keychain.
+const (
+	keychainService = "x402-cli"
+	keychainAccount = "state-key"
+)
+
+// keychainSecretCache holds the keychain secret once read, so a run asks the keychain once.
+var keychainSecretCache string
+
+// statePassphraseCache holds the passphrase asked through --prompt, so a run asks once.
+var statePassphraseCache string
+
+// stateKeyCache holds the keys derived in this run, by secret and salt.
+var stateKeyCache = map[string][]byte{}
+
+// stateEncryption reports whether local state is written encrypted (X402_ENCRYPT_STATE).
+func stateEncryption() bool {
+	on, _ := strconv.ParseBool(os.Getenv("X402_ENCRYPT_STATE"))
+	return on
+}
+
+// stateSecret returns the secret local state is encrypted under: X402_STATE_KEY or, when
+// that is unset, a secret in the OS keychain, which is created when create is set. When
+// --prompt routes prompts to a TTY or pinentry, a passphrase asked there takes the place of
+// a keychain that has no secret or cannot be reached.
+func stateSecret(create bool) (string, error) {
+	secret := os.Getenv("X402_STATE_KEY")
+	if secret == "" {
+		secret = statePassphraseCache
+	}
+	if secret == "" {
+		var err error
+		if secret, err = keychainSecret(create && !promptsElsewhere()); err != nil {
+			if !promptsElsewhere() {
+				return "", err
+			}
+			if secret, err = promptPassphrase("Passphrase of the encrypted x402-cli payment ledger", create); err != nil {
+				return "", fmt.Errorf("state passphrase: %w", err)
+			}
+			statePassphraseCache = secret
+		}
+	}
+	return secret, nil
+}
+
+// stateKey derives the AES-256 key for local state from secret and salt with scrypt, so a
+// passphrase in X402_STATE_KEY cannot be guessed quickly from a copied ledger.
+func stateKey(secret string, salt []byte) ([]byte, error) {
+	cacheKey := secret + "\x00" + string(salt)
+	if key, ok := stateKeyCache[cacheKey]; ok {
+		return key, nil
+	}
+	key, err := scrypt.Key([]byte(secret), salt, stateKeyScryptN, stateKeyScryptR, stateKeyScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	stateKeyCache[cacheKey] = key
+	return key, nil
+}
+
+// legacyStateKey is the key of lines sealed before scrypt: SHA-256 of the secret.
+func legacyStateKey(secret string) []byte {
+	sum := sha256.Sum256([]byte("x402-cli state v1\x00" + secret))
+	return sum[:]
+}
+
+// stateSalt returns the salt new state is sealed with, creating it on first use.
+func stateSalt() ([]byte, error) {
+	path, err := stateFile(stateSaltFile)
+	if err != nil {
+		return nil, err
+	}
+	if salt, err := os.ReadFile(path); err == nil && len(salt) >= 16 {
+		return salt, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if errors.Is(err, os.ErrExist) {
+		// Another run created it first.
+		return os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(salt); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return salt, f.Close()
+}
+
+// stateSealed reports whether the state file at path holds any sealed line. A ledger that
+// was encrypted stays encrypted when it is written again, even once X402_ENCRYPT_STATE is
+// unset, rather than being rewritten or extended in plaintext.
+func stateSealed(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if bytes.HasPrefix(scanner.Bytes(), []byte(sealedPrefix)) || bytes.HasPrefix(scanner.Bytes(), []byte(legacySealedPrefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// keychainSecret reads the state secret from the macOS Keychain or the Secret Service
+// (secret-tool) on Linux, generating and storing a random one if create is set.
+func keychainSecret(create bool) (string, error) {
+	if keychainSecretCache != "" {
+		return keychainSecretCache, nil
+	}
+	var lookup, store *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		lookup = exec.Command("security", "find-generic-password", "-s", keychainService, "-a", keychainAccount, "-w")
+	case "linux", "freebsd", "openbsd", "netbsd":
+		lookup = exec.Command("secret-tool", "lookup", "service", keychainService, "account", keychainAccount)
+	default:
+		return "", fmt.Errorf("no OS keychain support on %s; set X402_STATE_KEY", runtime.GOOS)
+	}
+	if out, err := lookup.Output(); err == nil && len(bytes.TrimSpace(out)) > 0 {
+		keychainSecretCache = string(bytes.TrimSpace(out))
+		return keychainSecretCache, nil
+	}
+	if !create {
+		return "", errors.New("the state key is not in the OS keychain; set X402_STATE_KEY")
+	}
+
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	secret := hex.EncodeToString(buf)
+	switch runtime.GOOS {
+	case "darwin":
+		// Given as a command on stdin of `security -i`, the secret stays out of the process
+		// list, where `-w <secret>` would show it to every user.
+		store = exec.Command("security", "-i")
+		store.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -s %s -a %s -w %s\n", keychainService, keychainAccount, secret))
+	default:
+		store = exec.Command("secret-tool", "store", "--label=x402-cli state key", "service", keychainService, "account", keychainAccount)
+		store.Stdin = strings.NewReader(secret)
+	}
+	if out, err := store.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to store the state key in the OS keychain (%v: %s); set X402_STATE_KEY", err, bytes.TrimSpace(out))
+	}
+	keychainSecretCache = secret
+	return secret, nil
+}
+
+// sealLine encrypts one ledger line with AES-256-GCM.
+func sealLine(line []byte) ([]byte, error) {
+	secret, err := stateSecret(true)
+	if err != nil {
+		return nil, err
+	}
+	salt, err := stateSalt()
+	if err != nil {
+		return nil, fmt.Errorf("state key salt: %w", err)
+	}
+	key, err := stateKey(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newStateCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	sealed := gcm.Seal(nonce, nonce, line, nil)
+	return []byte(sealedPrefix + base64.StdEncoding.EncodeToString(salt) + ":" + base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// openLine decrypts a line written by sealLine; other lines are returned as is.
+func openLine(line []byte) ([]byte, error) {
+	encoded, ok := bytes.CutPrefix(line, []byte(sealedPrefix))
+	legacy, isLegacy := bytes.CutPrefix(line, []byte(legacySealedPrefix))
+	if !ok && !isLegacy {
+		return line, nil
+	}
+	secret, err := stateSecret(false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt local state: %w", err)
+	}
+	var key []byte
+	if isLegacy {
+		key, encoded = legacyStateKey(secret), legacy
+	} else {
+		saltText, rest, found := bytes.Cut(encoded, []byte(":"))
+		salt, err := base64.StdEncoding.DecodeString(string(saltText))
+		if !found || err != nil || len(salt) == 0 {
+			return nil, errors.New("cannot decrypt local state: malformed record")
+		}
+		if key, err = stateKey(secret, salt); err != nil {
+			return nil, fmt.Errorf("cannot decrypt local state: %w", err)
+		}
+		encoded = rest
+	}
+	gcm, err := newStateCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("cannot decrypt local state: malformed record")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("cannot decrypt local state: wrong key")
+	}
+	return plain, nil
+}
+
+func newStateCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
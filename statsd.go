@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// statsd is the run's --statsd client; nil when metrics are off.
+var statsd *statsdClient
+
+// statsdByEnv is the default for --statsd.
+func statsdByEnv() string {
+	return os.Getenv("X402_STATSD")
+}
+
+// statsdClient sends StatsD metrics over UDP. Sending never blocks or fails a run: a
+// metric that cannot be delivered is dropped, as StatsD intends.
+type statsdClient struct {
+	conn   net.Conn
+	prefix string
+}
+
+// newStatsdClient connects to a StatsD server at addr (host:port). Metric names start
+// with X402_STATSD_PREFIX, "x402" by default.
+func newStatsdClient(addr string) (*statsdClient, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid --statsd %q: want host:port", addr)
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("--statsd: %w", err)
+	}
+	prefix := os.Getenv("X402_STATSD_PREFIX")
+	if prefix == "" {
+		prefix = "x402"
+	}
+	return &statsdClient{conn: conn, prefix: strings.TrimSuffix(prefix, ".")}, nil
+}
+
+// observe reports a payment that was sent, as recorded in the history ledger.
+func (c *statsdClient) observe(rec historyRecord) {
+	if c == nil {
+		return
+	}
+	c.send(paymentMetrics(rec))
+}
+
+// paymentMetrics are the StatsD lines for one payment: counters of payments attempted
+// and by status, the amount spent when accepted (in whole tokens, per network and
+// asset), and the paid request's latency as a timer.
+func paymentMetrics(rec historyRecord) []string {
+	lines := []string{"payments.attempted:1|c"}
+	status := rec.Status
+	if status == "" {
+		status = "unknown"
+	}
+	lines = append(lines, "payments."+metricName(status)+":1|c")
+	if rec.Status == "accepted" && rec.Amount != "" {
+		req := x402.PaymentRequirements{Network: rec.Network, Asset: rec.Asset, Amount: rec.Amount}
+		amount, unit := rec.Amount, metricName(assetSymbol(req))+"_atomic"
+		if decimals, ok := assetDecimals(req); ok {
+			if raw, ok := new(big.Int).SetString(rec.Amount, 10); ok {
+				amount = ratString(new(big.Rat).SetFrac(raw, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
+				unit = metricName(assetSymbol(req))
+			}
+		}
+		lines = append(lines, fmt.Sprintf("spend.%s.%s:%s|c", metricName(networkName(rec.Network)), unit, amount))
+	}
+	if rec.LatencyMs > 0 {
+		lines = append(lines, fmt.Sprintf("payment.latency:%d|ms", rec.LatencyMs))
+	}
+	return lines
+}
+
+// send writes lines, prefixed, in one datagram.
+func (c *statsdClient) send(lines []string) {
+	var b strings.Builder
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(c.prefix + "." + line)
+	}
+	c.conn.Write([]byte(b.String()))
+}
+
+// metricName makes s usable as a StatsD name segment: lowercase letters, digits, and
+// underscores, e.g. "Base Sepolia" becomes "base_sepolia".
+func metricName(s string) string {
+	return strings.Trim(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '_'
+	}, s), "_")
+}
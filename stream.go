@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+)
+
+// lineStreamTypes are the Content-Types of line-delimited JSON bodies, which are
+// written out line by line as they arrive instead of after EOF.
+var lineStreamTypes = map[string]bool{
+	"application/x-ndjson":     true,
+	"application/ndjson":       true,
+	"application/jsonl":        true,
+	"application/x-jsonl":      true,
+	"application/jsonlines":    true,
+	"application/x-jsonlines":  true,
+	"application/json-seq":     true,
+	"application/stream+json":  true,
+	"application/x-json-lines": true,
+}
+
+// isLineStream reports whether resp carries NDJSON / JSON Lines.
+func isLineStream(resp *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return err == nil && lineStreamTypes[mediaType]
+}
+
+// streamLines copies r to each writer one line at a time, as soon as each line
+// arrives, and returns everything read.
+func streamLines(r io.Reader, outs ...io.Writer) ([]byte, error) {
+	var all []byte
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			all = append(all, line...)
+			for _, w := range outs {
+				w.Write(line)
+			}
+		}
+		if err == io.EOF {
+			return all, nil
+		}
+		if err != nil {
+			return all, err
+		}
+	}
+}
+
+// streamPaidBody writes a line-delimited paid response to stdout (when toStdout) and
+// to outputFile (when set) while it downloads, after head (see --include), and returns
+// the whole body.
+func streamPaidBody(resp *http.Response, head []byte, outputFile string, toStdout bool) []byte {
+	var outs []io.Writer
+	if toStdout {
+		outs = append(outs, os.Stdout)
+	}
+	if outputFile != "" {
+		f, err := os.Create(outputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to write to %s: %v\n", outputFile, err)
+		} else {
+			defer f.Close()
+			outs = append(outs, f)
+		}
+	}
+	for _, w := range outs {
+		w.Write(head)
+	}
+	body, err := streamLines(resp.Body, outs...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: response stream ended early: %v\n", err)
+	}
+	return body
+}
+
+// responseHead renders a response's status line and headers as curl -i shows them.
+func responseHead(resp *http.Response) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s\r\n", resp.Proto, resp.Status)
+	resp.Header.Write(&b)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
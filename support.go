@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// supportContact is where the provider says to turn when a payment fails, as named in the
+// payment requirements.
+type supportContact struct {
+	Docs  string `json:"docs,omitempty"`
+	Email string `json:"email,omitempty"`
+	URL   string `json:"url,omitempty"` // a support page or form
+}
+
+// supportNote is printed to stderr when a run that failed has a support contact.
+var supportNote string
+
+// Keys providers name their docs and support contact with, compared without case, "-", or "_".
+var (
+	docsKeys    = []string{"docs", "docsurl", "documentation", "documentationurl", "apidocs"}
+	supportKeys = []string{"support", "supportemail", "supporturl", "contact", "contactemail", "contacturl", "email", "helpurl"}
+)
+
+// parseSupportContact looks for a docs URL and support contact in the requirements: at the
+// top level, in resource, in extensions (or their support or contact object), and in each
+// accepts entry's extra. The first of each found wins.
+func parseSupportContact(requirements []byte) *supportContact {
+	var envelope struct {
+		Resource   map[string]any   `json:"resource"`
+		Extensions map[string]any   `json:"extensions"`
+		Accepts    []map[string]any `json:"accepts"`
+	}
+	var top map[string]any
+	if json.Unmarshal(requirements, &top) != nil || json.Unmarshal(requirements, &envelope) != nil {
+		return nil
+	}
+	sources := []map[string]any{top, envelope.Resource, envelope.Extensions}
+	for _, key := range []string{"support", "contact"} {
+		if m, ok := lookupKey(envelope.Extensions, key).(map[string]any); ok {
+			sources = append(sources, m)
+		}
+	}
+	for _, a := range envelope.Accepts {
+		if extra, ok := a["extra"].(map[string]any); ok {
+			sources = append(sources, extra)
+		}
+	}
+
+	c := &supportContact{}
+	for _, m := range sources {
+		for _, k := range slices.Sorted(maps.Keys(m)) {
+			s, ok := m[k].(string)
+			if !ok {
+				continue
+			}
+			key := normalizeKey(k)
+			switch {
+			case slices.Contains(docsKeys, key) && c.Docs == "" && isWebURL(s):
+				c.Docs = s
+			case slices.Contains(supportKeys, key) && isEmail(s) && c.Email == "":
+				c.Email = strings.TrimPrefix(s, "mailto:")
+			case slices.Contains(supportKeys, key) && isWebURL(s) && c.URL == "":
+				c.URL = s
+			}
+		}
+	}
+	if *c == (supportContact{}) {
+		return nil
+	}
+	return c
+}
+
+// describe renders the contact for a one-line hint.
+func (c *supportContact) describe() string {
+	var parts []string
+	if c.Email != "" {
+		parts = append(parts, c.Email)
+	}
+	if c.URL != "" {
+		parts = append(parts, c.URL)
+	}
+	if c.Docs != "" {
+		parts = append(parts, "docs: "+c.Docs)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// supportHint is the note shown after a failed run; include the request ID when writing.
+func supportHint(c *supportContact) string {
+	return fmt.Sprintf("Provider support: %s (mention request ID %s)\n", c.describe(), requestID)
+}
+
+func lookupKey(m map[string]any, key string) any {
+	for k, v := range m {
+		if normalizeKey(k) == key {
+			return v
+		}
+	}
+	return nil
+}
+
+func normalizeKey(k string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(k))
+}
+
+func isWebURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+func isEmail(s string) bool {
+	s = strings.TrimPrefix(s, "mailto:")
+	local, domain, ok := strings.Cut(s, "@")
+	return ok && local != "" && strings.Contains(domain, ".") && !strings.ContainsAny(s, " /")
+}
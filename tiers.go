@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// tierKeys are the accepts[].extra keys servers name a price tier with, in order of precedence.
+var tierKeys = []string{"tier", "quality"}
+
+// acceptTier returns the price tier an accepts entry's extra names, and its description;
+// both are empty for an entry without tiers.
+func acceptTier(extra map[string]interface{}) (tier, description string) {
+	for _, k := range tierKeys {
+		if v, ok := extra[k].(string); ok && v != "" {
+			tier = v
+			break
+		}
+	}
+	if tier == "" {
+		return "", ""
+	}
+	for _, k := range []string{"tierDescription", "description"} {
+		if v, ok := extra[k].(string); ok && v != "" {
+			return tier, v
+		}
+	}
+	return tier, ""
+}
+
+// describeTier renders a tier for the payment summary, e.g. "hd (1080p frames)".
+func describeTier(extra map[string]interface{}) string {
+	tier, description := acceptTier(extra)
+	if description != "" {
+		return tier + " (" + description + ")"
+	}
+	return tier
+}
+
+// filterTier keeps the accepts entries of tier (case-insensitive) for --tier.
+func filterTier(accepts []x402.PaymentRequirements, tier string) ([]x402.PaymentRequirements, error) {
+	var only []x402.PaymentRequirements
+	var offered []string
+	for _, a := range accepts {
+		t, _ := acceptTier(a.Extra)
+		if strings.EqualFold(t, tier) {
+			only = append(only, a)
+		}
+		if t != "" && !containsFold(offered, t) {
+			offered = append(offered, t)
+		}
+	}
+	if len(only) > 0 {
+		return only, nil
+	}
+	if len(offered) == 0 {
+		return nil, fmt.Errorf("the server offers no price tiers, so --tier %s cannot be paid", tier)
+	}
+	return nil, fmt.Errorf("the server offers no %q tier (offered: %s)", tier, strings.Join(offered, ", "))
+}
+
+// containsFold reports whether list contains s, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
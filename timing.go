@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// facilitatorMetric matches the Server-Timing metrics that time the facilitator.
+var facilitatorMetric = regexp.MustCompile(`(?i)facilitator|verify|settle`)
+
+// serverWait measures the time from a request being written to its first response byte:
+// the server's processing, without connection setup or body transfer. It keeps the last
+// request's, which for the x402 client is the paid one.
+type serverWait struct {
+	mu    sync.Mutex
+	wrote time.Time
+	last  time.Duration
+}
+
+// trace returns ctx with the hooks that measure requests made with it.
+func (w *serverWait) trace(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			w.mu.Lock()
+			w.wrote = time.Now()
+			w.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			w.mu.Lock()
+			w.last = time.Since(w.wrote)
+			w.mu.Unlock()
+		},
+	})
+}
+
+// duration is the last measured wait.
+func (w *serverWait) duration() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.last
+}
+
+// serverTimingMetric is one metric of a Server-Timing response header.
+type serverTimingMetric struct {
+	Name string  `json:"name"`
+	Dur  float64 `json:"dur,omitempty"` // milliseconds
+	Desc string  `json:"desc,omitempty"`
+}
+
+// parseServerTiming parses Server-Timing header values, e.g. `verify;dur=120, settle;dur=2300;desc="base"`.
+func parseServerTiming(values []string) []serverTimingMetric {
+	var metrics []serverTimingMetric
+	for _, v := range values {
+		for _, entry := range strings.Split(v, ",") {
+			parts := strings.Split(entry, ";")
+			m := serverTimingMetric{Name: strings.TrimSpace(parts[0])}
+			if m.Name == "" {
+				continue
+			}
+			for _, p := range parts[1:] {
+				k, val, _ := strings.Cut(strings.TrimSpace(p), "=")
+				switch strings.ToLower(k) {
+				case "dur":
+					m.Dur, _ = strconv.ParseFloat(val, 64)
+				case "desc":
+					m.Desc = strings.Trim(val, `"`)
+				}
+			}
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// paymentTiming breaks down how long the paid request (Step 2) took, to tell a slow
+// resource server from a slow facilitator.
+type paymentTiming struct {
+	// TotalMs is all of Step 2: the SDK's unpaid request, signing, and the paid request.
+	TotalMs int64 `json:"totalMs"`
+	// PaidWaitMs is the paid request's time to first byte, once sent; ProbeWaitMs is the same
+	// for the unpaid Step 1 request, the server's time without a payment to verify and settle.
+	PaidWaitMs  int64 `json:"paidWaitMs"`
+	ProbeWaitMs int64 `json:"probeWaitMs"`
+	// FacilitatorMs and ServerMs split PaidWaitMs between the facilitator and the server itself.
+	FacilitatorMs int64 `json:"facilitatorMs"`
+	ServerMs      int64 `json:"serverMs"`
+	// ClientMs is the rest of TotalMs: the unpaid round trip, signing, connections, and transfer.
+	ClientMs int64 `json:"clientMs"`
+	// Source is "server-timing" when the server reported its facilitator time in a
+	// Server-Timing header, or "estimate" when it is the paid wait minus the probe's.
+	Source       string               `json:"source"`
+	ServerTiming []serverTimingMetric `json:"serverTiming,omitempty"`
+	// Slow is set when TotalMs reached --slow-payment.
+	Slow bool `json:"slow"`
+}
+
+// newPaymentTiming attributes the paid request's time to the facilitator and the server.
+func newPaymentTiming(total, paidWait, probeWait time.Duration, header http.Header, slow time.Duration) *paymentTiming {
+	t := &paymentTiming{
+		TotalMs:      total.Milliseconds(),
+		PaidWaitMs:   paidWait.Milliseconds(),
+		ProbeWaitMs:  probeWait.Milliseconds(),
+		ServerTiming: parseServerTiming(header.Values("Server-Timing")),
+		Slow:         total >= slow,
+		Source:       "estimate",
+	}
+	var reported float64
+	for _, m := range t.ServerTiming {
+		if facilitatorMetric.MatchString(m.Name) || facilitatorMetric.MatchString(m.Desc) {
+			reported += m.Dur
+			t.Source = "server-timing"
+		}
+	}
+	if t.Source == "server-timing" {
+		t.FacilitatorMs = min(int64(reported), t.PaidWaitMs)
+	} else {
+		t.FacilitatorMs = max(t.PaidWaitMs-t.ProbeWaitMs, 0)
+	}
+	t.ServerMs = t.PaidWaitMs - t.FacilitatorMs
+	t.ClientMs = max(t.TotalMs-t.PaidWaitMs, 0)
+	return t
+}
+
+// printTiming explains where a slow payment's time went.
+func printTiming(log func(string, ...any), t *paymentTiming) {
+	ms := func(v int64) string { return (time.Duration(v) * time.Millisecond).String() }
+	log("Slow payment: %s in total.\n", ms(t.TotalMs))
+	how := "paid minus unpaid response time"
+	if t.Source == "server-timing" {
+		how = "reported by the server in Server-Timing"
+	}
+	log("  Facilitator (verify and settle): %s (%s)\n", ms(t.FacilitatorMs), how)
+	log("  Resource server:                 %s\n", ms(t.ServerMs))
+	log("  Client and network:              %s (unpaid round trip, signing, connections, transfer)\n", ms(t.ClientMs))
+	switch {
+	case t.FacilitatorMs > t.ServerMs && t.FacilitatorMs > t.ClientMs:
+		log("Most of the time was spent in the facilitator; include the request ID when reporting it to the API provider.\n")
+	case t.ServerMs > t.ClientMs:
+		log("Most of the time was spent in the resource server itself.\n")
+	default:
+		log("Most of the time was spent on the client side or the network.\n")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// decodings are the values of --decode.
+var decodings = []string{"base64"}
+
+// extractResult reports what --extract, --decode, and --save-as did with the paid response.
+type extractResult struct {
+	Path   string `json:"path,omitempty"`
+	Decode string `json:"decode,omitempty"`
+	// Value is the extracted JSON value, before --decode.
+	Value   json.RawMessage `json:"value,omitempty"`
+	SavedAs string          `json:"savedAs,omitempty"`
+	Bytes   int             `json:"bytes"`
+}
+
+// parseFieldPath parses a jq-style path such as .data.items[0].url or .["a key"] into
+// object keys (string) and array indexes (int; negative counts from the end). "." is the
+// whole document.
+func parseFieldPath(path string) ([]any, error) {
+	if !strings.HasPrefix(path, ".") {
+		return nil, fmt.Errorf("invalid --extract path %q: must start with '.', e.g. .data.result", path)
+	}
+	var steps []any
+	rest := path
+	for rest != "" && rest != "." {
+		switch {
+		case strings.HasPrefix(rest, `.["`) || strings.HasPrefix(rest, `["`):
+			rest = strings.TrimPrefix(strings.TrimPrefix(rest, "."), "[")
+			end := strings.Index(rest, `"]`)
+			if end < 0 {
+				return nil, fmt.Errorf("invalid --extract path %q: unterminated [\"...\"]", path)
+			}
+			key, err := strconv.Unquote(rest[:end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid --extract path %q: %v", path, err)
+			}
+			steps = append(steps, key)
+			rest = rest[end+2:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			n, err := strconv.Atoi(rest[1:max(end, 1)])
+			if end < 0 || err != nil {
+				return nil, fmt.Errorf("invalid --extract path %q: array index must be [N]", path)
+			}
+			steps = append(steps, n)
+			rest = rest[end+1:]
+		case strings.HasPrefix(rest, ".["):
+			rest = rest[1:]
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid --extract path %q: empty field name", path)
+			}
+			steps = append(steps, rest[:end])
+			rest = rest[end:]
+		default:
+			return nil, fmt.Errorf("invalid --extract path %q at %q", path, rest)
+		}
+	}
+	return steps, nil
+}
+
+// extractField returns the JSON value at path in body.
+func extractField(body []byte, path string) (json.RawMessage, error) {
+	steps, err := parseFieldPath(path)
+	if err != nil {
+		return nil, err
+	}
+	value := json.RawMessage(bytes.TrimSpace(body))
+	if !json.Valid(value) {
+		return nil, fmt.Errorf("--extract: the response is not JSON")
+	}
+	at := "." // the part of path walked so far, for errors
+	for _, step := range steps {
+		switch step := step.(type) {
+		case string:
+			var obj map[string]json.RawMessage
+			if json.Unmarshal(value, &obj) != nil {
+				return nil, fmt.Errorf("--extract %s: %s is not an object", path, at)
+			}
+			v, ok := obj[step]
+			if !ok {
+				return nil, fmt.Errorf("--extract %s: %s has no field %q", path, at, step)
+			}
+			value = v
+			at = strings.TrimSuffix(at, ".") + "." + step
+		case int:
+			var arr []json.RawMessage
+			if json.Unmarshal(value, &arr) != nil {
+				return nil, fmt.Errorf("--extract %s: %s is not an array", path, at)
+			}
+			i := step
+			if i < 0 {
+				i += len(arr)
+			}
+			if i < 0 || i >= len(arr) {
+				return nil, fmt.Errorf("--extract %s: index %d is out of range (%s has %d elements)", path, step, at, len(arr))
+			}
+			value = arr[i]
+			at = strings.TrimSuffix(at, ".") + fmt.Sprintf("[%d]", step)
+		}
+	}
+	return value, nil
+}
+
+// transformBody runs the response pipeline: extract the field at path (when set), take
+// strings as their raw text and other values as JSON, then decode them (when set).
+func transformBody(body []byte, path, decode string) ([]byte, json.RawMessage, error) {
+	out := body
+	var value json.RawMessage
+	if path != "" {
+		var err error
+		if value, err = extractField(body, path); err != nil {
+			return nil, nil, err
+		}
+		var s string
+		if json.Unmarshal(value, &s) == nil {
+			out = []byte(s)
+		} else {
+			var compact bytes.Buffer
+			json.Compact(&compact, value)
+			out = compact.Bytes()
+		}
+	}
+	if decode == "base64" {
+		text := strings.TrimSpace(string(out))
+		var decoded []byte
+		var err error
+		for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+			if decoded, err = enc.DecodeString(text); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, value, fmt.Errorf("--decode base64: %v", err)
+		}
+		out = decoded
+	}
+	return out, value, nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+// tuiPageLines is the number of body lines shown per page in the response viewer.
+const tuiPageLines = 20
+
+// runTUICmd runs an interactive session: probe, pick an accepts option,
+// review wallet balances, confirm, pay, and page through the response.
+func runTUICmd(args []string) {
+	fs := flag.NewFlagSet("tui", flag.ExitOnError)
+	var (
+		insecure bool
+		timeout  time.Duration
+		method   string
+		data     string
+		headers  headerFlags
+		mainnet  bool
+		trust    bool
+		hosts    string
+		budgets  headerFlags
+		maxSpend string
+		maxPrice string
+		sandbox  bool
+		strict   bool
+		window   time.Duration
+	)
+	fs.BoolVar(&insecure, "k", false, "Skip TLS certificate verification")
+	fs.DurationVar(&timeout, "timeout", 30*time.Second, "Request timeout")
+	fs.StringVar(&method, "X", "GET", "HTTP method")
+	fs.StringVar(&data, "d", "", "Request body (implies POST if -X not set)")
+	fs.Var(&headers, "H", "Custom header 'Key: Value' (repeatable)")
+	fs.BoolVar(&mainnet, "mainnet", mainnetAllowedByEnv(), "Allow payments on mainnet networks (real funds) (default: $X402_ALLOW_MAINNET)")
+	fs.BoolVar(&trust, "trust-redirects", false, "Follow redirects to another origin (and pay it if it asks)")
+	fs.StringVar(&hosts, "only-hosts", os.Getenv("X402_ONLY_HOSTS"), "Only ever pay these hosts: comma-separated names or *.domain wildcards (default: $X402_ONLY_HOSTS)")
+	fs.Var(&budgets, "host-budget", "Cap payments to a host per period, e.g. api.example.com=1USDC/day (repeatable; default: $X402_HOST_BUDGETS)")
+	fs.StringVar(&maxSpend, "max-total-spend", maxTotalSpendByEnv(), "Never pay more than this in this session, e.g. 0.5USDC (default: $X402_MAX_TOTAL_SPEND)")
+	fs.StringVar(&maxPrice, "max-amount", "", "Refuse a payment option above this price, e.g. 0.01USDC")
+	fs.BoolVar(&sandbox, "sandbox", false, "For untrusted URLs: testnets only, --max-amount 0.01USDC and --max-total-spend 0.05USDC unless given, --only-hosts required, no redirects, and response bodies capped at 10 MiB")
+	fs.BoolVar(&strict, "strict", false, "Refuse to pay a request already paid within --repeat-window (default: warn and pay)")
+	fs.DurationVar(&window, "repeat-window", 10*time.Minute, "How far back the payment history is checked for a repeat of the same request (0 disables)")
+	fs.DurationVar(&confirmTimeout, "confirm-timeout", confirmTimeout, "Quit when a prompt is left unanswered this long (0 waits forever; default: $X402_CONFIRM_TIMEOUT or 2m)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli tui [flags] <url>\n\n")
+		fmt.Fprintf(os.Stderr, "Interactively explore a paid endpoint: probe, choose a payment option, confirm, and view the response.\n")
+		fmt.Fprintf(os.Stderr, "Prompts are asked where --prompt routes them and time out after --confirm-timeout.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	endpoint := fs.Arg(0)
+	if endpoint == "" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	if data != "" && method == "GET" {
+		method = "POST"
+	}
+	// The same guards as a plain pay: nothing reaches the confirmation that pay would refuse.
+	allowedHosts := parseHostAllowlist(hosts)
+	hostBudgets, err := parseHostBudgets(budgets, os.Getenv("X402_HOST_BUDGETS"))
+	var responseCap int64
+	if err == nil && sandbox {
+		var explicit []string
+		fs.Visit(func(f *flag.Flag) { explicit = append(explicit, f.Name) })
+		err = checkSandbox(hosts, explicit, fs.NArg())
+		mainnet, trust, responseCap = false, false, sandboxMaxResponse
+		maxPrice = cmp.Or(maxPrice, sandboxMaxAmount)
+		maxSpend = cmp.Or(maxSpend, sandboxMaxSpend)
+	}
+	var ceiling *spendCeiling
+	if err == nil {
+		ceiling, err = newSpendCeiling(maxSpend)
+	}
+	var amountLimit *big.Rat
+	if err == nil {
+		amountLimit, err = parseMaxAmount(maxPrice)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+
+	baseTransport := &http.Transport{}
+	if insecure {
+		baseTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	transport := capResponses(baseTransport, responseCap)
+
+	// --- Probe ---
+	fmt.Printf("Probing %s %s ...\n\n", method, endpoint)
+	req, err := newRequest(method, endpoint, data, headers)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
+		os.Exit(ExitError)
+	}
+	var hops []redirectHop
+	redirects := newRedirectPolicy(trust, nil, &hops)
+	redirects.none = sandbox
+	resp, err := (&http.Client{Transport: transport, Timeout: timeout, CheckRedirect: redirects.checkRedirect("probe")}).Do(req)
+	if err != nil {
+		code, _ := classifyError(err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(code)
+	}
+	if hop := redirects.blocked(); hop != nil {
+		resp.Body.Close()
+		fmt.Fprintf(os.Stderr, "Error: redirected to another origin: %s (use --trust-redirects)\n", hop.To)
+		os.Exit(ExitError)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPaymentRequired {
+		fmt.Printf("Status: %d (no payment required)\n\n", resp.StatusCode)
+		viewBody(body)
+		return
+	}
+
+	required, err := decodeRequirements(resp, body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	observePrices(endpoint, required.Accepts)
+	if required.Resource != nil && required.Resource.Description != "" {
+		fmt.Printf("Resource: %s\n\n", required.Resource.Description)
+	}
+
+	// --- Wallet ---
+	privateKey := privateKeyFromEnv()
+	if privateKey == "" {
+		printAccepts(required.Accepts, "")
+		fmt.Fprintf(os.Stderr, "\n%s is not set; cannot pay. Set it with: export %s=0x...\n", privateKeyVar(), privateKeyVar())
+		os.Exit(ExitError)
+	}
+	signer, err := signerFor(privateKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create signer: %v\n", err)
+		os.Exit(ExitError)
+	}
+	fmt.Printf("Wallet: %s\n\n", signer.Address())
+	printAccepts(required.Accepts, signer.Address())
+
+	// --- Select ---
+	choice := 0
+	if len(required.Accepts) > 1 {
+		choice = -1
+		for choice < 0 {
+			answer, ok := askPrompt(fmt.Sprintf("\nSelect option [1-%d] (q to quit): ", len(required.Accepts)), "quitting")
+			if !ok || answer == "q" {
+				fmt.Println("Aborted.")
+				return
+			}
+			if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(required.Accepts) {
+				choice = n - 1
+			}
+		}
+	}
+	selected := required.Accepts[choice]
+	if !mainnet && !isTestnet(selected.Network) {
+		fmt.Fprintf(os.Stderr, "Error: %s.\n", mainnetRefusal)
+		os.Exit(ExitError)
+	}
+
+	if err := checkPayTo(selected); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	if err := checkMaxAmount(amountLimit, selected); err != nil {
+		fmt.Fprintf(os.Stderr, "Refusing to pay: %v\n", err)
+		os.Exit(ExitError)
+	}
+	if u, err := url.Parse(endpoint); err == nil && !allowedHosts.allows(u.Hostname()) {
+		fmt.Fprintf(os.Stderr, "Refusing to pay %s: it is not in --only-hosts (%s).\n", u.Hostname(), strings.Join(allowedHosts, ","))
+		os.Exit(ExitError)
+	}
+	if len(hostBudgets) > 0 || window > 0 {
+		records, err := loadHistory()
+		if err == nil && len(hostBudgets) > 0 {
+			err = checkHostBudgets(hostBudgets, records, endpoint, selected, time.Now())
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Refusing to pay: %v\n", err)
+			os.Exit(ExitError)
+		}
+		// Guard against paying for the same request twice, as pay does.
+		if prev := recentPayment(records, endpoint, method, data, window, time.Now()); window > 0 && prev != nil {
+			msg := fmt.Sprintf("%s %s was already paid at %s (transaction %s)",
+				method, endpoint, prev.Time.Local().Format(time.RFC3339), dashIfEmpty(prev.Transaction))
+			if strict {
+				fmt.Fprintf(os.Stderr, "Refusing to pay again: %s. Wait %s or rerun without --strict.\n", msg, window)
+				os.Exit(ExitError)
+			}
+			fmt.Fprintf(os.Stderr, "Warning: %s; paying again.\n", msg)
+		}
+	}
+	if _, err := checkDelegatedSigner(routedAddress(selected.Network, signer.Address()), endpoint, selected); err != nil {
+		fmt.Fprintf(os.Stderr, "Refusing to pay: %v\n", err)
+		os.Exit(ExitError)
+	}
+
+	// --- Confirm ---
+	fmt.Printf("\nPay %s on %s to %s?\n", describeAmount(selected), networkName(selected.Network), checksumAddress(selected.PayTo))
+	fmt.Printf("Paying from: %s\n", describeWallet(routedAddress(selected.Network, signer.Address())))
+	if !confirmPrompt("Confirm payment [y/N]: ") {
+		fmt.Println("Aborted.")
+		return
+	}
+
+	// --- Pay ---
+	release, err := ceiling.reserve(selected)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Refusing to pay: %v\n", err)
+		os.Exit(ExitError)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var sent string
+	payClient := newPaymentClient(signer, onPaymentHeader(allowedHosts.transport(mainnetGuard(noSpendTransport(transport), mainnet)), func(h string) { sent = h }),
+		timeout, selectRequirement(selected))
+	payClient.CheckRedirect = redirects.checkRedirect("payment")
+	payReq, _ := newRequestWithContext(ctx, method, endpoint, data, headers)
+	paidAt := time.Now()
+	payResp, err := payClient.Do(payReq)
+	if err != nil {
+		code, _ := classifyError(err)
+		fmt.Fprintf(os.Stderr, "Payment request failed: %v\n", err)
+		os.Exit(code)
+	}
+	defer payResp.Body.Close()
+	payBody, _ := io.ReadAll(payResp.Body)
+	if hop := redirects.blocked(); hop != nil {
+		fmt.Fprintf(os.Stderr, "Error: paid request redirected to another origin: %s (use --trust-redirects)\n", hop.To)
+		os.Exit(ExitError)
+	}
+	if payResp.StatusCode == http.StatusPaymentRequired {
+		release() // a refused payment does not settle
+	}
+	if sent != "" && payResp.Header.Get(noSpendHeader) == "" {
+		rec := newHistoryRecord(endpoint, method, data, sent, payResp)
+		rec.Status = "accepted"
+		if payResp.StatusCode != http.StatusOK {
+			rec.Status = "rejected"
+		}
+		rec.LatencyMs = time.Since(paidAt).Milliseconds()
+		rec.measureBody(payBody)
+		if err := appendHistory(rec); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to record payment history: %v\n", err)
+		}
+		if payResp.StatusCode == http.StatusOK {
+			if _, _, err := issueReceipt(rec); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+		}
+	}
+
+	fmt.Printf("\nStatus: %d\n", payResp.StatusCode)
+	if header := payResp.Header.Get("PAYMENT-RESPONSE"); header != "" {
+		printBase64Header("PAYMENT-RESPONSE", header)
+	}
+	switch payResp.StatusCode {
+	case http.StatusOK:
+		fmt.Print("Payment accepted!\n\n")
+	case http.StatusPaymentRequired:
+		rej := normalizeRejection(rejectionReason(payResp, payBody))
+		fmt.Printf("Payment was rejected (%s): %s.\n\n", rej.Code, rej.Message)
+	}
+	viewBody(payBody)
+}
+
+// printAccepts lists the accepts entries, with the wallet's balance on each network when known.
+func printAccepts(accepts []x402.PaymentRequirements, address string) {
+	fmt.Println("Payment options:")
+	for i, a := range accepts {
+		fmt.Printf("  [%d] %s on %s (%s)\n", i+1, describeAmount(a), networkName(a.Network), a.Scheme)
+		if address == "" {
+			continue
+		}
+		info, ok := networkByChainID(a.Network)
+		if !ok || !strings.EqualFold(a.Asset, info.USDCContract) {
+			continue
+		}
+		if balance, _, err := queryUSDCBalance(info.RPCURL, info.USDCContract, routedAddress(a.Network, address)); err == nil {
+			fmt.Printf("      wallet balance: %s USDC\n", balance)
+		} else {
+			fmt.Printf("      wallet balance: error: %v\n", err)
+		}
+	}
+}
+
+// viewBody pages through a response body, pretty-printing JSON. Under --prompt pinentry it
+// is printed whole: a dialog for every page would only be in the way.
+func viewBody(body []byte) {
+	text := string(body)
+	var pretty json.RawMessage
+	if json.Unmarshal(body, &pretty) == nil {
+		if indented, err := json.MarshalIndent(pretty, "", "  "); err == nil {
+			text = string(indented)
+		}
+	}
+
+	lines := strings.Split(text, "\n")
+	if route, _, _ := parsePromptRoute(promptVia); route == promptPinentry {
+		fmt.Println(text)
+		return
+	}
+	for start := 0; start < len(lines); start += tuiPageLines {
+		end := min(start+tuiPageLines, len(lines))
+		fmt.Println(strings.Join(lines[start:end], "\n"))
+		if end == len(lines) {
+			return
+		}
+		answer, ok := askPrompt(fmt.Sprintf("-- %d/%d lines, Enter for more, q to quit -- ", end, len(lines)), "quitting")
+		if !ok || answer == "q" {
+			return
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// uploadBody is the file `upload` sends as the request body; nil otherwise.
+var uploadBody *stdinBody
+
+// newFileBody streams the file at path as a request body without copying it, the way
+// stdinBody streams a spooled stdin.
+func newFileBody(path string) (*stdinBody, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	sum := sha256.New()
+	n, err := io.Copy(sum, f)
+	if err != nil {
+		return nil, err
+	}
+	return &stdinBody{path: path, size: n, hash: hex.EncodeToString(sum.Sum(nil)), keep: true}, nil
+}
+
+// contentDigest is the Content-Digest header value (RFC 9530) of a body's hex SHA-256.
+func contentDigest(hash string) string {
+	raw, _ := hex.DecodeString(hash)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(raw) + ":"
+}
+
+// uploadContentType guesses the media type of the file at path: from its extension, else
+// from its first bytes.
+func uploadContentType(path string) string {
+	if t := mime.TypeByExtension(filepath.Ext(path)); t != "" {
+		return t
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	return http.DetectContentType(head[:n])
+}
+
+// runUploadCmd expands `upload <file> [flags] <url>` to the pay command's flags: the file
+// is the streamed body, with its Content-Type and a Content-Digest checksum.
+func runUploadCmd(args []string) []string {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli upload <file> [--content-type <type>] [flags] <url>\n\n")
+		fmt.Fprintf(os.Stderr, "Uploads a file to a paid endpoint, e.g. storage or inference accepting large binaries.\n")
+		fmt.Fprintf(os.Stderr, "The file is streamed from disk with its Content-Length for the probe and the paid\n")
+		fmt.Fprintf(os.Stderr, "request, with a Content-Type (--content-type, else guessed from the extension or the\n")
+		fmt.Fprintf(os.Stderr, "first bytes) and a Content-Digest sha-256 checksum, so the server can verify what it\n")
+		fmt.Fprintf(os.Stderr, "stores. The request is a POST unless -X says otherwise; other flags are those of the\n")
+		fmt.Fprintf(os.Stderr, "pay command (see x402-cli help), e.g. --json -y.\n")
+		os.Exit(ExitError)
+	}
+	path := args[0]
+	contentType, rest := extractGlobalFlag(args[1:], "content-type")
+	for _, arg := range rest {
+		if arg == "--" {
+			break
+		}
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "-") && (name == "d" || name == "data" || name == "data-urlencode") {
+			fmt.Fprintf(os.Stderr, "Error: upload sends %s as the request body; it cannot be combined with %s\n", path, arg)
+			os.Exit(ExitError)
+		}
+	}
+
+	body, err := newFileBody(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	if contentType == "" {
+		contentType = uploadContentType(path)
+	}
+	uploadBody = body
+	// -H headers given on the command line come later, so they override these.
+	return append([]string{"-d", stdinData, "-H", "Content-Type: " + contentType, "-H", "Content-Digest: " + contentDigest(body.hash)}, rest...)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+	x402evm "github.com/coinbase/x402/go/mechanisms/evm"
+	evmsigners "github.com/coinbase/x402/go/signers/evm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// vectorFixtureKey is the well-known first Hardhat/Anvil development key. Vectors are
+// signed with it unless --wallet is given, so they are reproducible on any machine.
+const vectorFixtureKey = "0xac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80"
+
+// Fixed validity windows keep vectors byte-for-byte reproducible.
+const (
+	vectorValidBefore   = 4102444800 // 2100-01-01T00:00:00Z
+	vectorExpiredBefore = 946684800  // 2000-01-01T00:00:00Z
+)
+
+// vectorWalletWindow is how long vectors signed with a real --wallet key stay valid, so a
+// leaked vectors file cannot be settled long after the test run it was made for.
+const vectorWalletWindow = 5 * time.Minute
+
+// testVector is one signed payment and the verdict a correct server must reach.
+type testVector struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Expect      string              `json:"expect"`
+	Header      string              `json:"header"`
+	Payload     x402.PaymentPayload `json:"payload"`
+}
+
+// vectorSet is the JSON output of `x402-cli vectors`.
+type vectorSet struct {
+	Network         string               `json:"network"`
+	ChainID         string               `json:"chainId"`
+	Signer          string               `json:"signer"`
+	FixtureKey      bool                 `json:"fixtureKey"`
+	PaymentRequired x402.PaymentRequired `json:"paymentRequired"`
+	Vectors         []testVector         `json:"vectors"`
+}
+
+// runVectorsCmd prints deterministic signed payment payloads for server test suites.
+func runVectorsCmd(args []string) {
+	fs := flag.NewFlagSet("vectors", flag.ExitOnError)
+	var (
+		network  string
+		amount   string
+		payTo    string
+		resource string
+		wallet   bool
+		mainnet  bool
+	)
+	fs.StringVar(&network, "network", "base-sepolia", "Network to sign for")
+	fs.StringVar(&amount, "amount", "", "Required amount in atomic units, e.g. 1000 = 0.001 USDC (required)")
+	fs.StringVar(&payTo, "pay-to", "", "Recipient address the server requires (required)")
+	fs.StringVar(&resource, "resource", "https://example.com/paid", "Resource URL recorded in the payloads")
+	fs.BoolVar(&wallet, "wallet", false, "Sign with the EVM_PRIVATE_KEY wallet instead of the public fixture key")
+	fs.BoolVar(&mainnet, "mainnet", mainnetAllowedByEnv(), "Allow --wallet on a mainnet network, signing authorizations for real funds (default: $X402_ALLOW_MAINNET)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli vectors --amount <atomic> --pay-to 0x... [--network base-sepolia] [--wallet [--mainnet]]\n\n")
+		fmt.Fprintf(os.Stderr, "Prints JSON test vectors for x402 server middleware: the 402 requirements and signed\n")
+		fmt.Fprintf(os.Stderr, "PAYMENT-SIGNATURE payloads that must be accepted (valid) or rejected (expired,\n")
+		fmt.Fprintf(os.Stderr, "wrong-amount, replayed). Output is deterministic for the same flags and key.\n")
+		fmt.Fprintf(os.Stderr, "The default key is the public Hardhat/Anvil development key; never fund it on mainnet.\n")
+		fmt.Fprintf(os.Stderr, "Vectors signed with --wallet are valid for %s only, and need --mainnet on a mainnet.\n\n", vectorWalletWindow)
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if amount == "" || payTo == "" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	fail := func(msg string) {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", msg)
+		os.Exit(ExitError)
+	}
+
+	info, ok := lookupNetwork(network)
+	if !ok {
+		fail(fmt.Sprintf("unknown network: %s (available: %s)", network, availableNetworks()))
+	}
+	if _, err := parseAddress(payTo, "pay-to"); err != nil {
+		fail(err.Error())
+	}
+	if v, ok := new(big.Int).SetString(amount, 10); !ok || v.Sign() <= 0 {
+		fail(fmt.Sprintf("invalid amount: %s (atomic units, e.g. 1000)", amount))
+	}
+
+	key, validBefore := vectorFixtureKey, int64(vectorValidBefore)
+	if wallet {
+		// A wallet-signed "valid" vector is a real authorization anyone holding the output can settle.
+		if !info.Testnet && !mainnet {
+			fail(fmt.Sprintf("--wallet on %s signs authorizations for real funds: pass --mainnet (or set X402_ALLOW_MAINNET=1), or use a testnet", info.Name))
+		}
+		if key = privateKeyFromEnv(); key == "" {
+			fail(privateKeyVar() + " is required with --wallet")
+		}
+		validBefore = time.Now().Add(vectorWalletWindow).Unix()
+	}
+	signer, err := evmsigners.NewClientSignerFromPrivateKey(key)
+	if err != nil {
+		fail(err.Error())
+	}
+
+	set, err := buildVectors(signer, info, amount, common.HexToAddress(payTo).Hex(), resource, validBefore)
+	if err != nil {
+		fail(err.Error())
+	}
+	set.FixtureKey = !wallet
+	printJSON(set)
+}
+
+// buildVectors signs the valid, expired, wrong-amount, and replayed vectors; all but expired
+// are valid until validBefore. Nonces are derived from the vector name, so the same inputs
+// always produce the same signatures.
+func buildVectors(signer x402evm.ClientEvmSigner, info networkInfo, amount, payTo, resource string, validBefore int64) (*vectorSet, error) {
+	asset, err := x402evm.GetAssetInfo(info.ChainID, info.USDCContract)
+	if err != nil {
+		return nil, err
+	}
+	required := x402.PaymentRequirements{
+		Scheme:            supportedScheme,
+		Network:           info.ChainID,
+		Asset:             info.USDCContract,
+		Amount:            amount,
+		PayTo:             payTo,
+		MaxTimeoutSeconds: 300,
+		Extra:             map[string]interface{}{"name": asset.Name, "version": asset.Version},
+	}
+	res := &x402.ResourceInfo{URL: resource}
+	set := &vectorSet{
+		Network: info.Name,
+		ChainID: info.ChainID,
+		Signer:  signer.Address(),
+		PaymentRequired: x402.PaymentRequired{
+			X402Version: 2,
+			Error:       "Payment required",
+			Resource:    res,
+			Accepts:     []x402.PaymentRequirements{required},
+		},
+	}
+
+	short, _ := new(big.Int).SetString(amount, 10)
+	short.Sub(short, big.NewInt(1))
+	specs := []struct {
+		name, nonceSeed, value, expect, description string
+		validBefore                                 int64
+	}{
+		{"valid", "valid", amount, "accept", "Correct payment; the server must accept it.", validBefore},
+		{"expired", "expired", amount, "reject", "Authorization window closed in 2000; the server must reject it.", vectorExpiredBefore},
+		{"wrong-amount", "wrong-amount", short.String(), "reject", "Signed for 1 atomic unit less than required; the server must reject it.", validBefore},
+		{"replayed", "valid", amount, "reject", "Identical to 'valid'; once 'valid' has been accepted the server must reject it.", validBefore},
+	}
+
+	for _, s := range specs {
+		payload, err := signEIP3009(signer, required, res, x402evm.ExactEIP3009Authorization{
+			From:        signer.Address(),
+			To:          payTo,
+			Value:       s.value,
+			ValidAfter:  "0",
+			ValidBefore: fmt.Sprint(s.validBefore),
+			Nonce:       x402evm.BytesToHex(crypto.Keccak256([]byte("x402-cli vectors:" + s.nonceSeed))),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign %s vector: %w", s.name, err)
+		}
+		encoded, _ := json.Marshal(payload)
+		set.Vectors = append(set.Vectors, testVector{
+			Name:        s.name,
+			Description: s.description,
+			Expect:      s.expect,
+			Header:      base64.StdEncoding.EncodeToString(encoded),
+			Payload:     payload,
+		})
+	}
+	return set, nil
+}
+
+// signEIP3009 signs auth as an "exact" payment for required. Unlike the SDK client it
+// signs the authorization exactly as given, so callers control the nonce and window.
+func signEIP3009(signer x402evm.ClientEvmSigner, required x402.PaymentRequirements, resource *x402.ResourceInfo,
+	auth x402evm.ExactEIP3009Authorization) (x402.PaymentPayload, error) {
+	chainID, err := x402evm.GetEvmChainId(required.Network)
+	if err != nil {
+		return x402.PaymentPayload{}, err
+	}
+	info, err := x402evm.GetAssetInfo(required.Network, required.Asset)
+	if err != nil {
+		return x402.PaymentPayload{}, err
+	}
+	asset := *info // GetAssetInfo may return the SDK's shared default
+	if name, ok := required.Extra["name"].(string); ok {
+		asset.Name = name
+	}
+	if ver, ok := required.Extra["version"].(string); ok {
+		asset.Version = ver
+	}
+
+	value, _ := new(big.Int).SetString(auth.Value, 10)
+	validAfter, _ := new(big.Int).SetString(auth.ValidAfter, 10)
+	validBefore, _ := new(big.Int).SetString(auth.ValidBefore, 10)
+	nonce, err := x402evm.HexToBytes(auth.Nonce)
+	if err != nil {
+		return x402.PaymentPayload{}, err
+	}
+	sig, err := signer.SignTypedData(context.Background(),
+		x402evm.TypedDataDomain{
+			Name:              asset.Name,
+			Version:           asset.Version,
+			ChainID:           chainID,
+			VerifyingContract: asset.Address,
+		},
+		map[string][]x402evm.TypedDataField{
+			"EIP712Domain": {
+				{Name: "name", Type: "string"},
+				{Name: "version", Type: "string"},
+				{Name: "chainId", Type: "uint256"},
+				{Name: "verifyingContract", Type: "address"},
+			},
+			"TransferWithAuthorization": {
+				{Name: "from", Type: "address"},
+				{Name: "to", Type: "address"},
+				{Name: "value", Type: "uint256"},
+				{Name: "validAfter", Type: "uint256"},
+				{Name: "validBefore", Type: "uint256"},
+				{Name: "nonce", Type: "bytes32"},
+			},
+		},
+		"TransferWithAuthorization",
+		map[string]interface{}{
+			"from":        auth.From,
+			"to":          auth.To,
+			"value":       value,
+			"validAfter":  validAfter,
+			"validBefore": validBefore,
+			"nonce":       nonce,
+		},
+	)
+	if err != nil {
+		return x402.PaymentPayload{}, err
+	}
+	return x402.PaymentPayload{
+		X402Version: 2,
+		Payload:     (&x402evm.ExactEIP3009Payload{Signature: x402evm.BytesToHex(sig), Authorization: auth}).ToMap(),
+		Accepted:    required,
+		Resource:    resource,
+	}, nil
+}
@@ -13,7 +13,6 @@ import (
 	"strings"
 	"time"
 
-	evmsigners "github.com/coinbase/x402/go/signers/evm"
 )
 
 // networkInfo holds RPC and USDC contract info for a network.
@@ -23,36 +22,66 @@ type networkInfo struct {
 	USDCContract string
 	Decimals     int
 	Name         string
+	NativeSymbol string
+	// OPStack networks charge an L1 data fee on top of L2 execution gas.
+	OPStack bool
+	// Confirmations is the default depth required before a settlement is trusted.
+	Confirmations uint64
+	// PriceFeed is the Chainlink <native>/USD aggregator used to show fees in USD (mainnets only).
+	PriceFeed string
+	// Testnet networks can be paid without --mainnet.
+	Testnet bool
+	// Faucets (testnets) and Bridges (mainnets) are where to get native token for gas.
+	Faucets []string
+	Bridges []string
 }
 
 var networks = map[string]networkInfo{
 	"base": {
-		ChainID:      "eip155:8453",
-		RPCURL:       "https://mainnet.base.org",
-		USDCContract: "0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913",
-		Decimals:     6,
-		Name:         "Base",
+		ChainID:       "eip155:8453",
+		RPCURL:        "https://mainnet.base.org",
+		USDCContract:  "0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913",
+		Decimals:      6,
+		Name:          "Base",
+		NativeSymbol:  "ETH",
+		OPStack:       true,
+		Confirmations: 3,
+		PriceFeed:     "0x71041dddad3595F9CEd3DcCFBe3D1F4b0a16Bb70",
+		Bridges:       []string{"https://superbridge.app/base", "https://relay.link/bridge/base"},
 	},
 	"base-sepolia": {
-		ChainID:      "eip155:84532",
-		RPCURL:       "https://sepolia.base.org",
-		USDCContract: "0x036CbD53842c5426634e7929541eC2318f3dCF7e",
-		Decimals:     6,
-		Name:         "Base Sepolia",
+		ChainID:       "eip155:84532",
+		RPCURL:        "https://sepolia.base.org",
+		USDCContract:  "0x036CbD53842c5426634e7929541eC2318f3dCF7e",
+		Decimals:      6,
+		Name:          "Base Sepolia",
+		NativeSymbol:  "ETH",
+		OPStack:       true,
+		Confirmations: 1,
+		Testnet:       true,
+		Faucets:       []string{"https://portal.cdp.coinbase.com/products/faucet", "https://www.alchemy.com/faucets/base-sepolia"},
 	},
 	"avalanche": {
-		ChainID:      "eip155:43114",
-		RPCURL:       "https://api.avax.network/ext/bc/C/rpc",
-		USDCContract: "0xB97EF9Ef8734C71904D8002F8b6Bc66Dd9c48a6E",
-		Decimals:     6,
-		Name:         "Avalanche",
+		ChainID:       "eip155:43114",
+		RPCURL:        "https://api.avax.network/ext/bc/C/rpc",
+		USDCContract:  "0xB97EF9Ef8734C71904D8002F8b6Bc66Dd9c48a6E",
+		Decimals:      6,
+		Name:          "Avalanche",
+		NativeSymbol:  "AVAX",
+		Confirmations: 1,
+		PriceFeed:     "0x0A77230d17318075983913bC2145DB16C7366156",
+		Bridges:       []string{"https://core.app/bridge/"},
 	},
 	"avalanche-fuji": {
-		ChainID:      "eip155:43113",
-		RPCURL:       "https://api.avax-test.network/ext/bc/C/rpc",
-		USDCContract: "0x5425890298aed601595a70AB815c96711a31Bc65",
-		Decimals:     6,
-		Name:         "Avalanche Fuji",
+		ChainID:       "eip155:43113",
+		RPCURL:        "https://api.avax-test.network/ext/bc/C/rpc",
+		USDCContract:  "0x5425890298aed601595a70AB815c96711a31Bc65",
+		Decimals:      6,
+		Name:          "Avalanche Fuji",
+		NativeSymbol:  "AVAX",
+		Confirmations: 1,
+		Testnet:       true,
+		Faucets:       []string{"https://core.app/tools/testnet-faucet/?subnet=c&token=c"},
 	},
 }
 
@@ -70,6 +99,8 @@ type balanceEntry struct {
 	Balance  string `json:"balance"`
 	Decimals int    `json:"decimals"`
 	Raw      string `json:"raw"`
+	// CachedAt is when a balance served from the cache was queried; absent for a live query.
+	CachedAt *time.Time `json:"cachedAt,omitempty"`
 }
 
 // runWallet shows wallet address and USDC balances.
@@ -84,7 +115,7 @@ func runWallet(address string, network string, jsonOutput bool) {
 		} else {
 			if jsonOutput {
 				result.Error = fmt.Sprintf("unknown network: %s", network)
-				out, _ := json.MarshalIndent(result, "", "  ")
+				out, _ := json.MarshalIndent(redactJSON(result), "", "  ")
 				fmt.Println(string(out))
 				return
 			}
@@ -99,7 +130,7 @@ func runWallet(address string, network string, jsonOutput bool) {
 	}
 
 	for name, info := range netsToQuery {
-		humanBalance, raw, err := queryUSDCBalance(info.RPCURL, info.USDCContract, address)
+		humanBalance, raw, cachedAt, err := usdcBalance(info, address, refreshBalances)
 		if err != nil {
 			entry := balanceEntry{
 				Network: name,
@@ -122,15 +153,22 @@ func runWallet(address string, network string, jsonOutput bool) {
 			Decimals: info.Decimals,
 			Raw:      raw,
 		}
+		if !cachedAt.IsZero() {
+			entry.CachedAt = &cachedAt
+		}
 		result.Balances = append(result.Balances, entry)
 
 		if !jsonOutput {
-			fmt.Printf("  %-18s  %s USDC\n", info.Name+":", humanBalance)
+			if entry.CachedAt != nil {
+				fmt.Printf("  %-18s  %s USDC  (%s)\n", info.Name+":", humanBalance, cacheAge(cachedAt))
+			} else {
+				fmt.Printf("  %-18s  %s USDC\n", info.Name+":", humanBalance)
+			}
 		}
 	}
 
 	if jsonOutput {
-		out, _ := json.MarshalIndent(result, "", "  ")
+		out, _ := json.MarshalIndent(redactJSON(result), "", "  ")
 		fmt.Println(string(out))
 	}
 }
@@ -142,77 +180,171 @@ func queryUSDCBalance(rpcURL, contractAddr, walletAddr string) (string, string,
 	addr := strings.TrimPrefix(strings.ToLower(walletAddr), "0x")
 	callData := "0x70a08231" + fmt.Sprintf("%064s", addr)
 
+	result, err := ethCall(rpcURL, contractAddr, callData)
+	if err != nil {
+		return "", "", err
+	}
+
+	raw, err := parseHexUint(result)
+	if err != nil {
+		return "", "", err
+	}
+	return atomicToHuman(raw.String(), 6), raw.String(), nil
+}
+
+// ethCall performs a read-only eth_call against the latest block.
+func ethCall(rpcURL, to, data string) (string, error) {
+	var result string
+	err := rpcCall(rpcURL, "eth_call", []any{
+		map[string]string{
+			"to":   to,
+			"data": data,
+		},
+		"latest",
+	}, &result)
+	return result, err
+}
+
+// rpcCall sends a JSON-RPC request and decodes its result into out.
+func rpcCall(rpcURL, method string, params []any, out any) error {
 	rpcReq := map[string]any{
 		"jsonrpc": "2.0",
 		"id":      1,
-		"method":  "eth_call",
-		"params": []any{
-			map[string]string{
-				"to":   contractAddr,
-				"data": callData,
-			},
-			"latest",
-		},
+		"method":  method,
+		"params":  params,
 	}
 
 	body, _ := json.Marshal(rpcReq)
 	client := &http.Client{Timeout: 10 * time.Second}
 	resp, err := client.Post(rpcURL, "application/json", bytes.NewReader(body))
 	if err != nil {
-		return "", "", fmt.Errorf("rpc call failed: %w", err)
+		return fmt.Errorf("rpc call failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	respBody, _ := io.ReadAll(resp.Body)
 
 	var rpcResp struct {
-		Result string `json:"result"`
+		Result json.RawMessage `json:"result"`
 		Error  *struct {
 			Message string `json:"message"`
 		} `json:"error"`
 	}
 	if err := json.Unmarshal(respBody, &rpcResp); err != nil {
-		return "", "", fmt.Errorf("invalid rpc response")
+		return fmt.Errorf("invalid rpc response")
 	}
 	if rpcResp.Error != nil {
-		return "", "", fmt.Errorf("rpc error: %s", rpcResp.Error.Message)
+		return fmt.Errorf("rpc error: %s", rpcResp.Error.Message)
+	}
+	if err := json.Unmarshal(rpcResp.Result, out); err != nil {
+		return fmt.Errorf("invalid rpc result: %w", err)
 	}
+	return nil
+}
 
-	// Parse hex result to big.Int.
-	hexStr := strings.TrimPrefix(rpcResp.Result, "0x")
+// parseHexUint parses a 0x-prefixed hex quantity or 32-byte word into a big.Int.
+func parseHexUint(s string) (*big.Int, error) {
+	hexStr := strings.TrimPrefix(s, "0x")
 	if hexStr == "" || hexStr == "0" {
-		return "0", "0", nil
+		return new(big.Int), nil
 	}
 
-	balanceBytes, err := hex.DecodeString(padHexLeft(hexStr))
+	b, err := hex.DecodeString(padHexLeft(hexStr))
 	if err != nil {
-		return "", "", fmt.Errorf("invalid hex: %s", hexStr)
+		return nil, fmt.Errorf("invalid hex: %s", hexStr)
 	}
+	return new(big.Int).SetBytes(b), nil
+}
 
-	raw := new(big.Int).SetBytes(balanceBytes).String()
-	return atomicToHuman(raw, 6), raw, nil
+// checkBalance compares the wallet's USDC balance against each accepted payment option.
+// It returns a description of the shortfall only when every option is on a known network
+// and none of them is affordable; unknown assets or RPC failures never block a payment.
+func checkBalance(address string, requirements []byte) string {
+	var payReq struct {
+		Accepts []struct {
+			Amount  string `json:"amount"`
+			Asset   string `json:"asset"`
+			Network string `json:"network"`
+		} `json:"accepts"`
+	}
+	if err := json.Unmarshal(requirements, &payReq); err != nil || len(payReq.Accepts) == 0 {
+		return ""
+	}
+
+	var shortfalls []string
+	for _, a := range payReq.Accepts {
+		info, ok := networkByChainID(a.Network)
+		if !ok || !strings.EqualFold(a.Asset, info.USDCContract) {
+			return ""
+		}
+		amount, ok := new(big.Int).SetString(a.Amount, 10)
+		if !ok {
+			return ""
+		}
+		payer := routedAddress(a.Network, address)
+		_, raw, cachedAt, err := usdcBalance(info, payer, false)
+		if err == nil && !cachedAt.IsZero() && balanceBelow(raw, amount) {
+			// Never refuse a payment on a cached balance: the wallet may have been funded since.
+			_, raw, _, err = usdcBalance(info, payer, true)
+		}
+		if err != nil {
+			return ""
+		}
+		balance, _ := new(big.Int).SetString(raw, 10)
+		if balance.Cmp(amount) >= 0 {
+			return ""
+		}
+		shortfalls = append(shortfalls, fmt.Sprintf("%s has %s USDC, needs %s",
+			info.Name, atomicToHuman(raw, info.Decimals), atomicToHuman(a.Amount, info.Decimals)))
+	}
+	return strings.Join(shortfalls, "; ")
 }
 
-// atomicToHuman converts atomic units (e.g., "1000") to human readable (e.g., "0.001").
-func atomicToHuman(raw string, decimals int) string {
-	bal := new(big.Int)
-	bal.SetString(raw, 10)
+// balanceBelow reports whether the atomic balance raw is less than amount.
+func balanceBelow(raw string, amount *big.Int) bool {
+	balance, ok := new(big.Int).SetString(raw, 10)
+	return ok && balance.Cmp(amount) < 0
+}
 
-	divisor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+// networkByChainID looks up a known network by its CAIP-2 chain ID.
+func networkByChainID(chainID string) (networkInfo, bool) {
+	for _, info := range networks {
+		if info.ChainID == chainID {
+			return info, true
+		}
+	}
+	return networkInfo{}, false
+}
 
-	whole := new(big.Int).Div(bal, divisor)
-	frac := new(big.Int).Mod(bal, divisor)
+// lookupNetwork finds a known network by CAIP-2 chain ID or by name (as used in x402 v1).
+func lookupNetwork(id string) (networkInfo, bool) {
+	if info, ok := networks[id]; ok {
+		return info, true
+	}
+	return networkByChainID(id)
+}
+
+// atomicToHuman converts atomic units (e.g., "1000") to a display amount (e.g., "0.001")
+// using the active --amount-format.
+func atomicToHuman(raw string, decimals int) string {
+	return amountFmt.format(raw, decimals)
+}
 
-	if frac.Sign() == 0 {
-		return whole.String() + ".000000"
+// humanToAtomic converts a human readable amount (e.g., "0.001") to atomic units (e.g., "1000").
+func humanToAtomic(amount string, decimals int) (string, error) {
+	whole, frac, _ := strings.Cut(strings.TrimSpace(amount), ".")
+	if whole == "" {
+		whole = "0"
+	}
+	if len(frac) > decimals {
+		return "", fmt.Errorf("amount %q has more than %d decimal places", amount, decimals)
 	}
-	fracStr := fmt.Sprintf("%0*s", decimals, frac.String())
-	// Trim trailing zeros but keep at least 2 decimal places.
-	trimmed := strings.TrimRight(fracStr, "0")
-	if len(trimmed) < 2 {
-		trimmed = fracStr[:2]
+	digits := whole + frac + strings.Repeat("0", decimals-len(frac))
+	raw, ok := new(big.Int).SetString(digits, 10)
+	if !ok || raw.Sign() < 0 || strings.ContainsAny(digits, "+-") {
+		return "", fmt.Errorf("invalid amount %q", amount)
 	}
-	return whole.String() + "." + trimmed
+	return raw.String(), nil
 }
 
 // padHexLeft pads a hex string to even length.
@@ -233,13 +365,60 @@ func availableNetworks() string {
 
 // runWalletCmd parses wallet subcommand flags and runs.
 func runWalletCmd(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "approve":
+			runWalletApproveCmd(args[1:])
+			return
+		case "delegate":
+			runWalletDelegateCmd(args[1:])
+			return
+		case "revoke":
+			runWalletRevokeCmd(args[1:])
+			return
+		case "allowances":
+			runWalletAllowancesCmd(args[1:])
+			return
+		case "permit":
+			runWalletPermitCmd(args[1:])
+			return
+		case "nonce":
+			runWalletNonceCmd(args[1:])
+			return
+		case "request":
+			runWalletRequestCmd(args[1:])
+			return
+		case "list":
+			runWalletListCmd(args[1:])
+			return
+		case "label":
+			runWalletLabelCmd(args[1:])
+			return
+		}
+	}
+
 	fs := flag.NewFlagSet("wallet", flag.ExitOnError)
 	var network string
 	var jsonOut bool
+	var addresses string
+	var all bool
 	fs.StringVar(&network, "network", "", "Query specific network (default: all)")
 	fs.BoolVar(&jsonOut, "json", false, "Output JSON")
+	fs.StringVar(&addresses, "addresses", "", "Comma-separated addresses to show as one combined table with totals")
+	fs.BoolVar(&all, "all", false, "Combined table for every profile wallet (EVM_PRIVATE_KEY and EVM_PRIVATE_KEY_<PROFILE>)")
+	fs.BoolVar(&refreshBalances, "refresh", false, "Query balances live instead of reusing ones cached within $X402_BALANCE_TTL (default 30s)")
 	fs.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: x402-cli wallet [--network <name>] [--json]\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli wallet [--network <name>] [--refresh] [--json]\n")
+		fmt.Fprintf(os.Stderr, "       x402-cli wallet --addresses 0x...,0x... | --all [--network <name>] [--refresh] [--json]\n")
+		fmt.Fprintf(os.Stderr, "       x402-cli wallet approve --network <name> --spender 0x... --amount <usdc>\n")
+		fmt.Fprintf(os.Stderr, "       x402-cli wallet allowances [--network <name>]\n")
+		fmt.Fprintf(os.Stderr, "       x402-cli wallet delegate --max <amount> [--expires 24h] [--hosts <host,...>] [--fund]\n")
+		fmt.Fprintf(os.Stderr, "       x402-cli wallet revoke --network <name> --spender 0x...\n")
+		fmt.Fprintf(os.Stderr, "       x402-cli wallet permit --network <name> --spender 0x... --amount <usdc> [--submit]\n")
+		fmt.Fprintf(os.Stderr, "       x402-cli wallet nonce --network <name> --nonce 0x... | --payment <base64>\n")
+		fmt.Fprintf(os.Stderr, "       x402-cli wallet request --network <name> [--amount <usdc>]\n")
+		fmt.Fprintf(os.Stderr, "       x402-cli wallet list\n")
+		fmt.Fprintf(os.Stderr, "       x402-cli wallet label [--label <name>] [--note <text>] [--network <name>]\n\n")
 		fmt.Fprintf(os.Stderr, "Shows wallet address and USDC balance from EVM_PRIVATE_KEY.\n\n")
 		fmt.Fprintf(os.Stderr, "Networks: %s\n\n", availableNetworks())
 		fmt.Fprintf(os.Stderr, "Flags:\n")
@@ -247,18 +426,39 @@ func runWalletCmd(args []string) {
 	}
 	fs.Parse(args)
 
-	privateKey := os.Getenv("EVM_PRIVATE_KEY")
+	if addresses != "" || all {
+		var wallets []aggregateWallet
+		if all {
+			wallets = profileWallets()
+		}
+		for _, a := range strings.Split(addresses, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				wallets = append(wallets, aggregateWallet{Address: a})
+			}
+		}
+		if len(wallets) == 0 {
+			fmt.Fprintln(os.Stderr, "Error: no wallets to show.")
+			os.Exit(ExitError)
+		}
+		runWalletAggregate(wallets, network, jsonOut)
+		return
+	}
+
+	privateKey := privateKeyFromEnv()
 	if privateKey == "" {
-		fmt.Fprintln(os.Stderr, "Error: EVM_PRIVATE_KEY is required.")
-		fmt.Fprintln(os.Stderr, "Set it with: export EVM_PRIVATE_KEY=0x...")
+		fmt.Fprintf(os.Stderr, "Error: %s is required.\n", privateKeyVar())
+		fmt.Fprintf(os.Stderr, "Set it with: export %s=0x...\n", privateKeyVar())
 		os.Exit(1)
 	}
 
-	signer, err := evmsigners.NewClientSignerFromPrivateKey(privateKey)
+	signer, err := signerFor(privateKey)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create signer: %v\n", err)
 		os.Exit(1)
 	}
 
+	if network == "" {
+		network = activeWalletMeta().Network
+	}
 	runWallet(signer.Address(), network, jsonOut)
 }
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// aggregateWallet is one address's balances in `wallet --addresses`.
+type aggregateWallet struct {
+	Address  string         `json:"address"`
+	Label    string         `json:"label,omitempty"`
+	Balances []balanceEntry `json:"balances"`
+}
+
+// aggregateTotal is the summed balance of one asset, on one network or (Network "all") overall.
+type aggregateTotal struct {
+	Asset   string `json:"asset"`
+	Network string `json:"network"`
+	Balance string `json:"balance"`
+	Raw     string `json:"raw"`
+}
+
+// aggregateResult is the JSON output for `x402-cli wallet --addresses`.
+type aggregateResult struct {
+	Wallets []aggregateWallet `json:"wallets"`
+	Totals  []aggregateTotal  `json:"totals"`
+	Errors  []string          `json:"errors,omitempty"`
+}
+
+// profileWallets returns the addresses of every wallet configured as a profile,
+// labelled with their metadata label or profile name.
+func profileWallets() []aggregateWallet {
+	meta, _ := loadWalletMeta()
+	saved := profile
+	defer func() { profile = saved }()
+
+	names := envProfiles()
+	sort.Strings(names)
+	var wallets []aggregateWallet
+	for _, p := range names {
+		profile = p
+		if p == defaultProfile {
+			profile = ""
+		}
+		key, err := loadPrivateKey()
+		if err != nil {
+			continue
+		}
+		label := meta[p].Label
+		if label == "" {
+			label = p
+		}
+		wallets = append(wallets, aggregateWallet{Address: crypto.PubkeyToAddress(key.PublicKey).Hex(), Label: label})
+	}
+	return wallets
+}
+
+// runWalletAggregate prints a combined USDC balance table for several addresses with per-network totals.
+func runWalletAggregate(wallets []aggregateWallet, network string, jsonOut bool) {
+	netNames := make([]string, 0, len(networks))
+	for name := range networks {
+		if network == "" || name == network {
+			netNames = append(netNames, name)
+		}
+	}
+	if len(netNames) == 0 {
+		fmt.Fprintf(os.Stderr, "Unknown network: %s\n", network)
+		fmt.Fprintf(os.Stderr, "Available: %s\n", availableNetworks())
+		os.Exit(ExitError)
+	}
+	sort.Strings(netNames)
+
+	result := &aggregateResult{Wallets: wallets}
+	sums := map[string]*big.Int{}
+	overall := new(big.Int)
+	var oldest time.Time // oldest cached balance shown
+	for i := range result.Wallets {
+		w := &result.Wallets[i]
+		addr, err := parseAddress(w.Address, "wallet")
+		if err != nil {
+			result.Errors = append(result.Errors, err.Error())
+			continue
+		}
+		w.Address = addr.Hex()
+		for _, name := range netNames {
+			info := networks[name]
+			human, raw, cachedAt, err := usdcBalance(info, w.Address, refreshBalances)
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s on %s: %v", w.Address, name, err))
+				continue
+			}
+			entry := balanceEntry{
+				Network:  name,
+				ChainID:  info.ChainID,
+				Asset:    "USDC",
+				Balance:  human,
+				Decimals: info.Decimals,
+				Raw:      raw,
+			}
+			if !cachedAt.IsZero() {
+				entry.CachedAt = &cachedAt
+				if oldest.IsZero() || cachedAt.Before(oldest) {
+					oldest = cachedAt
+				}
+			}
+			w.Balances = append(w.Balances, entry)
+			amount, _ := new(big.Int).SetString(raw, 10)
+			if sums[name] == nil {
+				sums[name] = new(big.Int)
+			}
+			sums[name].Add(sums[name], amount)
+			overall.Add(overall, amount)
+		}
+	}
+
+	// All supported networks use 6-decimal USDC, so per-network sums add up directly.
+	decimals := networks[netNames[0]].Decimals
+	for _, name := range netNames {
+		if sum := sums[name]; sum != nil {
+			result.Totals = append(result.Totals, aggregateTotal{
+				Asset: "USDC", Network: name, Balance: atomicToHuman(sum.String(), decimals), Raw: sum.String(),
+			})
+		}
+	}
+	result.Totals = append(result.Totals, aggregateTotal{
+		Asset: "USDC", Network: "all", Balance: atomicToHuman(overall.String(), decimals), Raw: overall.String(),
+	})
+
+	if jsonOut {
+		printJSON(result)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprint(w, "WALLET\t")
+	for _, name := range netNames {
+		fmt.Fprintf(w, "%s\t", name)
+	}
+	fmt.Fprintln(w)
+	for _, wallet := range result.Wallets {
+		label := wallet.Address
+		if wallet.Label != "" {
+			label = wallet.Label + " " + wallet.Address
+		}
+		fmt.Fprintf(w, "%s\t", label)
+		for _, name := range netNames {
+			fmt.Fprintf(w, "%s\t", walletBalanceOn(wallet, name))
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprint(w, "TOTAL (USDC)\t")
+	for _, name := range netNames {
+		if sum := sums[name]; sum != nil {
+			fmt.Fprintf(w, "%s\t", atomicToHuman(sum.String(), decimals))
+		} else {
+			fmt.Fprint(w, "-\t")
+		}
+	}
+	fmt.Fprintln(w)
+	w.Flush()
+	fmt.Printf("\nTotal across networks: %s USDC\n", atomicToHuman(overall.String(), decimals))
+	if !oldest.IsZero() {
+		fmt.Printf("Some balances %s (--refresh for live balances)\n", cacheAge(oldest))
+	}
+	for _, e := range result.Errors {
+		fmt.Printf("  error: %s\n", e)
+	}
+}
+
+// walletBalanceOn returns the formatted balance of a wallet on a network, or "-".
+func walletBalanceOn(w aggregateWallet, network string) string {
+	for _, b := range w.Balances {
+		if b.Network == network {
+			return b.Balance
+		}
+	}
+	return "-"
+}
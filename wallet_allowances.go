@@ -0,0 +1,205 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// approvalTopic is keccak256("Approval(address,address,uint256)").
+const approvalTopic = "0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925"
+
+// allowanceSelector is the ERC-20 allowance(address,address) function selector.
+const allowanceSelector = "0xdd62ed3e"
+
+// logChunkBlocks is the block range per eth_getLogs call; public RPCs cap ranges.
+const logChunkBlocks = 10000
+
+// allowanceEntry is one outstanding USDC approval.
+type allowanceEntry struct {
+	Network   string `json:"network"`
+	ChainID   string `json:"chainId"`
+	Spender   string `json:"spender"`
+	Allowance string `json:"allowance"`
+	Raw       string `json:"raw"`
+}
+
+// allowancesResult is the JSON output for `x402-cli wallet allowances`.
+type allowancesResult struct {
+	Owner      string           `json:"owner"`
+	Allowances []allowanceEntry `json:"allowances"`
+	Errors     []string         `json:"errors,omitempty"`
+}
+
+// runWalletAllowancesCmd lists non-zero USDC allowances granted by the signer.
+func runWalletAllowancesCmd(args []string) {
+	fs := flag.NewFlagSet("wallet allowances", flag.ExitOnError)
+	var (
+		network  string
+		blocks   uint64
+		spenders string
+		jsonOut  bool
+	)
+	fs.StringVar(&network, "network", "", "Query specific network (default: all)")
+	fs.Uint64Var(&blocks, "blocks", 100000, "Number of recent blocks to scan for Approval events")
+	fs.StringVar(&spenders, "spender", "", "Comma-separated spender addresses to always check, regardless of scan range")
+	fs.BoolVar(&jsonOut, "json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli wallet allowances [--network <name>] [--blocks N] [--spender 0x...] [--json]\n\n")
+		fmt.Fprintf(os.Stderr, "Lists outstanding USDC approvals granted by the EVM_PRIVATE_KEY wallet.\n")
+		fmt.Fprintf(os.Stderr, "Spenders are discovered from Approval events in the last --blocks blocks.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	key, err := loadPrivateKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	owner := crypto.PubkeyToAddress(key.PublicKey)
+
+	netsToQuery := networks
+	if network != "" {
+		info, ok := networks[network]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown network: %s\n", network)
+			fmt.Fprintf(os.Stderr, "Available: %s\n", availableNetworks())
+			os.Exit(ExitError)
+		}
+		netsToQuery = map[string]networkInfo{network: info}
+	}
+
+	var extra []common.Address
+	for _, s := range strings.Split(spenders, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		addr, err := parseAddress(s, "spender")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitError)
+		}
+		extra = append(extra, addr)
+	}
+
+	result := &allowancesResult{Owner: owner.Hex(), Allowances: []allowanceEntry{}}
+	for name, info := range netsToQuery {
+		found, err := approvalSpenders(info, owner, blocks)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", name, err))
+		}
+		for _, spender := range extra {
+			if !slices.Contains(found, spender) {
+				found = append(found, spender)
+			}
+		}
+		for _, spender := range found {
+			raw, err := queryAllowance(info, owner, spender)
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: %s: %v", name, spender.Hex(), err))
+				continue
+			}
+			if raw == "0" {
+				continue
+			}
+			result.Allowances = append(result.Allowances, allowanceEntry{
+				Network:   name,
+				ChainID:   info.ChainID,
+				Spender:   spender.Hex(),
+				Allowance: atomicToHuman(raw, info.Decimals),
+				Raw:       raw,
+			})
+		}
+	}
+
+	if jsonOut {
+		printJSON(result)
+		return
+	}
+
+	fmt.Printf("Owner:  %s\n\n", result.Owner)
+	if len(result.Allowances) == 0 {
+		fmt.Println("  No outstanding USDC allowances found.")
+	}
+	for _, a := range result.Allowances {
+		fmt.Printf("  %-16s %s  %s USDC\n", a.Network, a.Spender, a.Allowance)
+	}
+	for _, e := range result.Errors {
+		fmt.Printf("  error: %s\n", e)
+	}
+	if len(result.Allowances) > 0 {
+		fmt.Println("\nRevoke with: x402-cli wallet revoke --network <name> --spender <address>")
+	}
+}
+
+// approvalSpenders scans recent USDC Approval events emitted for owner and
+// returns the distinct spenders, oldest first.
+func approvalSpenders(info networkInfo, owner common.Address, blocks uint64) ([]common.Address, error) {
+	var latestHex string
+	if err := rpcCall(info.RPCURL, "eth_blockNumber", []any{}, &latestHex); err != nil {
+		return nil, err
+	}
+	latestBig, err := parseHexUint(latestHex)
+	if err != nil {
+		return nil, err
+	}
+	latest := latestBig.Uint64()
+
+	from := uint64(0)
+	if latest > blocks {
+		from = latest - blocks
+	}
+
+	ownerTopic := common.BytesToHash(owner.Bytes()).Hex()
+	seen := map[common.Address]bool{}
+	var spenders []common.Address
+	for start := from; start <= latest; start += logChunkBlocks {
+		end := min(start+logChunkBlocks-1, latest)
+		var logs []struct {
+			Topics []string `json:"topics"`
+		}
+		err := rpcCall(info.RPCURL, "eth_getLogs", []any{map[string]any{
+			"address":   info.USDCContract,
+			"fromBlock": fmt.Sprintf("0x%x", start),
+			"toBlock":   fmt.Sprintf("0x%x", end),
+			"topics":    []any{approvalTopic, ownerTopic},
+		}}, &logs)
+		if err != nil {
+			return spenders, err
+		}
+		for _, l := range logs {
+			if len(l.Topics) < 3 {
+				continue
+			}
+			spender := common.HexToAddress(l.Topics[2])
+			if !seen[spender] {
+				seen[spender] = true
+				spenders = append(spenders, spender)
+			}
+		}
+	}
+	return spenders, nil
+}
+
+// queryAllowance calls allowance(owner, spender) on the USDC contract.
+func queryAllowance(info networkInfo, owner, spender common.Address) (string, error) {
+	data := allowanceSelector +
+		common.Bytes2Hex(common.LeftPadBytes(owner.Bytes(), 32)) +
+		common.Bytes2Hex(common.LeftPadBytes(spender.Bytes(), 32))
+	result, err := ethCall(info.RPCURL, info.USDCContract, data)
+	if err != nil {
+		return "", err
+	}
+	raw, err := parseHexUint(result)
+	if err != nil {
+		return "", err
+	}
+	return raw.String(), nil
+}
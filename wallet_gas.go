@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Chainlink aggregator selectors.
+const (
+	latestRoundDataSelector = "0xfeaf968c"
+	feedDecimalsSelector    = "0x313ce567"
+	feedDescriptionSelector = "0x7284e416"
+)
+
+// maxPriceAge is how old a price feed answer may be before fees are shown without USD.
+const maxPriceAge = 24 * time.Hour
+
+// nativeUSDPrice reads the network's Chainlink <native>/USD feed. It checks the feed's
+// description, so a misconfigured address yields an error rather than a wrong price.
+func nativeUSDPrice(info networkInfo) (*big.Rat, error) {
+	if info.PriceFeed == "" {
+		return nil, fmt.Errorf("no %s/USD price feed on %s", info.NativeSymbol, info.Name)
+	}
+	desc, err := ethCall(info.RPCURL, info.PriceFeed, feedDescriptionSelector)
+	if err != nil {
+		return nil, err
+	}
+	if want := info.NativeSymbol + " / USD"; decodeABIString(desc) != want {
+		return nil, fmt.Errorf("price feed %s is %q, not %q", info.PriceFeed, decodeABIString(desc), want)
+	}
+	decHex, err := ethCall(info.RPCURL, info.PriceFeed, feedDecimalsSelector)
+	if err != nil {
+		return nil, err
+	}
+	decimals, err := parseHexUint(decHex)
+	if err != nil {
+		return nil, err
+	}
+	round, err := ethCall(info.RPCURL, info.PriceFeed, latestRoundDataSelector)
+	if err != nil {
+		return nil, err
+	}
+	return parseRoundData(round, decimals.Int64(), time.Now())
+}
+
+// parseRoundData extracts the answer of latestRoundData() (roundId, answer, startedAt,
+// updatedAt, answeredInRound) as a price, refusing stale or non-positive answers.
+func parseRoundData(result string, decimals int64, now time.Time) (*big.Rat, error) {
+	data := common.FromHex(result)
+	if len(data) < 5*32 {
+		return nil, fmt.Errorf("malformed price feed answer")
+	}
+	answer := new(big.Int).SetBytes(data[32:64])
+	updatedAt := new(big.Int).SetBytes(data[96:128]).Int64()
+	if data[32]&0x80 != 0 || answer.Sign() == 0 {
+		return nil, fmt.Errorf("price feed answer is not positive")
+	}
+	if now.Sub(time.Unix(updatedAt, 0)) > maxPriceAge {
+		return nil, fmt.Errorf("price feed answer is stale (updated %s)", time.Unix(updatedAt, 0).UTC().Format(time.RFC3339))
+	}
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(decimals), nil)
+	return new(big.Rat).SetFrac(answer, scale), nil
+}
+
+// decodeABIString decodes an ABI-encoded string return value.
+func decodeABIString(result string) string {
+	data := common.FromHex(result)
+	if len(data) < 64 {
+		return ""
+	}
+	n := new(big.Int).SetBytes(data[32:64]).Int64()
+	if n < 0 || 64+n > int64(len(data)) {
+		return ""
+	}
+	return string(data[64 : 64+n])
+}
+
+// weiToUSD converts a wei amount to dollars at price per native token, e.g. "0.0031".
+func weiToUSD(wei string, price *big.Rat) string {
+	amount, ok := new(big.Rat).SetString(wei)
+	if !ok || price == nil {
+		return ""
+	}
+	amount.Quo(amount, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)))
+	amount.Mul(amount, price)
+	s := strings.TrimRight(amount.FloatString(4), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// gasWarning reports a wallet whose native balance cannot pay a transaction's gas, with
+// how to top it up. It is the "warnings" entry of wallet transaction JSON output.
+type gasWarning struct {
+	Code    string `json:"code"` // "insufficient_gas"
+	Network string `json:"network"`
+	Address string `json:"address"`
+	Symbol  string `json:"symbol"`
+	// Balance, Required (the most the transaction may cost), and Shortfall (the minimum to
+	// add) are in whole native tokens, e.g. "0.00021".
+	Balance   string `json:"balance"`
+	Required  string `json:"required"`
+	Shortfall string `json:"shortfall"`
+	// Faucets (testnets) and Bridges (mainnets) are where to get the native token.
+	Faucets []string `json:"faucets,omitempty"`
+	Bridges []string `json:"bridges,omitempty"`
+}
+
+func (w *gasWarning) Error() string {
+	return fmt.Sprintf("insufficient %s for gas: balance %s %s, the transaction may cost up to %s %s",
+		w.Symbol, w.Balance, w.Symbol, w.Required, w.Symbol)
+}
+
+// checkGas returns a warning when address's native balance cannot cover the most the
+// transaction may cost; the node would reject it anyway, less clearly.
+func checkGas(info networkInfo, address string, fee *feeEstimate) *gasWarning {
+	if fee == nil || fee.Balance == "" {
+		return nil
+	}
+	balance, ok1 := new(big.Int).SetString(fee.Balance, 10)
+	maxCost, ok2 := new(big.Int).SetString(fee.Max, 10)
+	if !ok1 || !ok2 || balance.Cmp(maxCost) >= 0 {
+		return nil
+	}
+	return &gasWarning{
+		Code:      "insufficient_gas",
+		Network:   info.Name,
+		Address:   address,
+		Symbol:    fee.Symbol,
+		Balance:   weiToUnit(fee.Balance, 18),
+		Required:  weiToUnit(fee.Max, 18),
+		Shortfall: weiToUnit(new(big.Int).Sub(maxCost, balance).String(), 18),
+		Faucets:   info.Faucets,
+		Bridges:   info.Bridges,
+	}
+}
+
+// printGasTopUp tells how to fix a gas shortfall.
+func printGasTopUp(w io.Writer, g *gasWarning) {
+	fmt.Fprintf(w, "Top up:   send at least %s %s to %s on %s\n", g.Shortfall, g.Symbol, g.Address, g.Network)
+	for _, u := range g.Faucets {
+		fmt.Fprintf(w, "Faucet:   %s\n", u)
+	}
+	for _, u := range g.Bridges {
+		fmt.Fprintf(w, "Bridge:   %s\n", u)
+	}
+}
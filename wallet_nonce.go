@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// authorizationStateSelector is the EIP-3009 authorizationState(address,bytes32) function selector.
+const authorizationStateSelector = "0xe94a0102"
+
+// nonceResult is the JSON output for `x402-cli wallet nonce`.
+type nonceResult struct {
+	Network    string `json:"network"`
+	ChainID    string `json:"chainId"`
+	Token      string `json:"token"`
+	Authorizer string `json:"authorizer"`
+	Nonce      string `json:"nonce"`
+	Used       bool   `json:"used"`
+	Error      string `json:"error,omitempty"`
+}
+
+// runWalletNonceCmd checks whether an EIP-3009 transferWithAuthorization nonce was already used.
+func runWalletNonceCmd(args []string) {
+	fs := flag.NewFlagSet("wallet nonce", flag.ExitOnError)
+	var (
+		network string
+		nonce   string
+		address string
+		payment string
+		jsonOut bool
+	)
+	fs.StringVar(&network, "network", "", "Network of the token")
+	fs.StringVar(&nonce, "nonce", "", "32-byte authorization nonce (0x...)")
+	fs.StringVar(&address, "address", "", "Authorizer address (default: EVM_PRIVATE_KEY wallet)")
+	fs.StringVar(&payment, "payment", "", "Base64 PAYMENT-SIGNATURE header to take network, token, authorizer, and nonce from")
+	fs.BoolVar(&jsonOut, "json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli wallet nonce --network <name> --nonce 0x... [--address 0x...] [--json]\n")
+		fmt.Fprintf(os.Stderr, "       x402-cli wallet nonce --payment <base64> [--json]\n\n")
+		fmt.Fprintf(os.Stderr, "Checks on-chain whether an EIP-3009 authorization nonce has already been used,\n")
+		fmt.Fprintf(os.Stderr, "to tell replayed payments apart from other rejections.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	result := &nonceResult{Network: network, Nonce: nonce, Authorizer: address}
+	fail := func(msg string) {
+		if jsonOut {
+			result.Error = msg
+			printJSON(result)
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", msg)
+		}
+		os.Exit(ExitError)
+	}
+
+	var info networkInfo
+	if payment != "" {
+		p, err := decodePaymentAuthorization(payment)
+		if err != nil {
+			fail(err.Error())
+		}
+		var ok bool
+		if info, ok = lookupNetwork(p.Network); !ok {
+			fail(fmt.Sprintf("unsupported network in payment: %s", p.Network))
+		}
+		result.Network = p.Network
+		result.Authorizer = p.From
+		result.Nonce = p.Nonce
+		info.USDCContract = p.Asset
+	} else {
+		if network == "" || nonce == "" {
+			fs.Usage()
+			os.Exit(ExitError)
+		}
+		var ok bool
+		if info, ok = networks[network]; !ok {
+			fail(fmt.Sprintf("unknown network: %s (available: %s)", network, availableNetworks()))
+		}
+		if result.Authorizer == "" {
+			key, err := loadPrivateKey()
+			if err != nil {
+				fail(err.Error())
+			}
+			result.Authorizer = crypto.PubkeyToAddress(key.PublicKey).Hex()
+		}
+	}
+	result.ChainID = info.ChainID
+	result.Token = info.USDCContract
+
+	if _, err := parseAddress(result.Authorizer, "authorizer"); err != nil {
+		fail(err.Error())
+	}
+	nonceBytes := common.FromHex(result.Nonce)
+	if len(nonceBytes) != 32 {
+		fail(fmt.Sprintf("invalid nonce %q: expected 32 bytes", result.Nonce))
+	}
+
+	used, err := authorizationUsed(info.RPCURL, info.USDCContract, result.Authorizer, nonceBytes)
+	if err != nil {
+		fail(err.Error())
+	}
+	result.Used = used
+
+	if jsonOut {
+		printJSON(result)
+		return
+	}
+	writeNonceResult(os.Stdout, result)
+}
+
+// writeNonceResult prints the human output of `wallet nonce`.
+func writeNonceResult(w io.Writer, result *nonceResult) {
+	fmt.Fprintf(w, "Network:    %s\n", networkName(result.ChainID))
+	fmt.Fprintf(w, "Token:      %s\n", result.Token)
+	fmt.Fprintf(w, "Authorizer: %s\n", result.Authorizer)
+	fmt.Fprintf(w, "Nonce:      %s\n", result.Nonce)
+	if result.Used {
+		fmt.Fprintln(w, "\nNonce has been USED on-chain: a payment carrying it is a replay.")
+	} else {
+		fmt.Fprintln(w, "\nNonce is unused: a rejection with this payment was not caused by replay.")
+	}
+}
+
+// authorizationUsed reads the token's authorizationState(authorizer, nonce) on-chain.
+func authorizationUsed(rpcURL, token, authorizer string, nonce []byte) (bool, error) {
+	data := authorizationStateSelector +
+		common.Bytes2Hex(common.LeftPadBytes(common.HexToAddress(authorizer).Bytes(), 32)) +
+		common.Bytes2Hex(nonce)
+	word, err := ethCall(rpcURL, token, data)
+	if err != nil {
+		return false, err
+	}
+	used, err := parseHexUint(word)
+	if err != nil {
+		return false, err
+	}
+	return used.Sign() != 0, nil
+}
+
+// paymentAuthorization is the EIP-3009 authorization carried in an exact EVM payment.
+type paymentAuthorization struct {
+	Network string
+	Asset   string
+	From    string
+	Nonce   string
+}
+
+// decodePaymentAuthorization extracts the authorization from a base64 payment header (v2 or v1).
+func decodePaymentAuthorization(header string) (*paymentAuthorization, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(header))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 payment: %w", err)
+	}
+
+	var payload struct {
+		Network  string `json:"network"` // v1
+		Accepted struct {
+			Network string `json:"network"`
+			Asset   string `json:"asset"`
+		} `json:"accepted"` // v2
+		Payload struct {
+			Authorization struct {
+				From  string `json:"from"`
+				Nonce string `json:"nonce"`
+			} `json:"authorization"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(decoded, &payload); err != nil {
+		return nil, fmt.Errorf("invalid payment JSON: %w", err)
+	}
+
+	auth := &paymentAuthorization{
+		Network: payload.Accepted.Network,
+		Asset:   payload.Accepted.Asset,
+		From:    payload.Payload.Authorization.From,
+		Nonce:   payload.Payload.Authorization.Nonce,
+	}
+	if auth.Network == "" {
+		auth.Network = payload.Network
+	}
+	if auth.From == "" || auth.Nonce == "" {
+		return nil, fmt.Errorf("payment has no EIP-3009 authorization")
+	}
+	if info, ok := lookupNetwork(auth.Network); ok && auth.Asset == "" {
+		auth.Asset = info.USDCContract
+	}
+	return auth, nil
+}
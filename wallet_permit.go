@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	x402evm "github.com/coinbase/x402/go/mechanisms/evm"
+	evmsigners "github.com/coinbase/x402/go/signers/evm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ERC-20 / EIP-2612 function selectors.
+const (
+	nameSelector    = "0x06fdde03" // name()
+	versionSelector = "0x54fd4d50" // version()
+	noncesSelector  = "0x7ecebe00" // nonces(address)
+	permitSelector  = "d505accf"   // permit(address,address,uint256,uint256,uint8,bytes32,bytes32)
+)
+
+// permitResult is the JSON output for `x402-cli wallet permit`.
+type permitResult struct {
+	Network   string        `json:"network"`
+	ChainID   string        `json:"chainId"`
+	Token     string        `json:"token"`
+	Owner     string        `json:"owner"`
+	Spender   string        `json:"spender"`
+	Amount    string        `json:"amount"`
+	Raw       string        `json:"raw"`
+	Nonce     string        `json:"nonce"`
+	Deadline  int64         `json:"deadline"`
+	Signature string        `json:"signature"`
+	V         uint8         `json:"v"`
+	R         string        `json:"r"`
+	S         string        `json:"s"`
+	Fee       *feeEstimate  `json:"fee,omitempty"`
+	TxHash    string        `json:"txHash,omitempty"`
+	Warnings  []*gasWarning `json:"warnings,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// runWalletPermitCmd signs (and optionally submits) an EIP-2612 permit for USDC.
+func runWalletPermitCmd(args []string) {
+	fs := flag.NewFlagSet("wallet permit", flag.ExitOnError)
+	var (
+		network    string
+		spender    string
+		amount     string
+		deadline   time.Duration
+		nonce      string
+		domainName string
+		domainVer  string
+		submit     bool
+		autoYes    bool
+		jsonOut    bool
+	)
+	fs.StringVar(&network, "network", "", "Network of the token (required)")
+	fs.StringVar(&spender, "spender", "", "Spender address to permit (required)")
+	fs.StringVar(&amount, "amount", "", "Permit value in USDC, e.g. 10 or 0.5 (required)")
+	fs.DurationVar(&deadline, "deadline", time.Hour, "How long the permit stays valid")
+	fs.StringVar(&nonce, "nonce", "", "Permit nonce (default: read nonces(owner) from the token)")
+	fs.StringVar(&domainName, "name", "", "EIP-712 domain name (default: read name() from the token)")
+	fs.StringVar(&domainVer, "version", "", "EIP-712 domain version (default: read version() from the token)")
+	fs.BoolVar(&submit, "submit", false, "Submit the permit on-chain after signing")
+	fs.BoolVar(&autoYes, "yes", false, "Submit without prompting for confirmation")
+	fs.BoolVar(&autoYes, "y", false, "Submit without prompting for confirmation (shorthand)")
+	fs.BoolVar(&jsonOut, "json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli wallet permit --network <name> --spender 0x... --amount <usdc> [--deadline 1h] [--submit] [--json]\n\n")
+		fmt.Fprintf(os.Stderr, "Signs an EIP-2612 permit for USDC with the EVM_PRIVATE_KEY wallet and prints the signature.\n")
+		fmt.Fprintf(os.Stderr, "Pass --nonce, --name, and --version to sign without any RPC access.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if network == "" || spender == "" || amount == "" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+
+	result := &permitResult{Network: network, Spender: spender, Amount: amount}
+	fail := func(msg string) {
+		if jsonOut {
+			result.Error = msg
+			printJSON(result)
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", msg)
+		}
+		os.Exit(ExitError)
+	}
+
+	info, ok := networks[network]
+	if !ok {
+		fail(fmt.Sprintf("unknown network: %s (available: %s)", network, availableNetworks()))
+	}
+	result.ChainID = info.ChainID
+	result.Token = info.USDCContract
+	if _, err := parseAddress(spender, "spender"); err != nil {
+		fail(err.Error())
+	}
+	raw, err := humanToAtomic(amount, info.Decimals)
+	if err != nil {
+		fail(err.Error())
+	}
+	result.Raw = raw
+
+	key, err := loadPrivateKey()
+	if err != nil {
+		fail(err.Error())
+	}
+	signer, err := evmsigners.NewClientSignerFromPrivateKey(privateKeyFromEnv())
+	if err != nil {
+		fail(err.Error())
+	}
+	owner := common.HexToAddress(signer.Address())
+	result.Owner = owner.Hex()
+
+	if domainName == "" {
+		if domainName, err = queryTokenString(info, nameSelector); err != nil {
+			fail("failed to read token name (pass --name): " + err.Error())
+		}
+	}
+	if domainVer == "" {
+		if domainVer, err = queryTokenString(info, versionSelector); err != nil {
+			fail("failed to read token version (pass --version): " + err.Error())
+		}
+	}
+	permitNonce := new(big.Int)
+	if nonce != "" {
+		if _, ok := permitNonce.SetString(nonce, 10); !ok {
+			fail(fmt.Sprintf("invalid nonce: %s", nonce))
+		}
+	} else {
+		word, err := ethCall(info.RPCURL, info.USDCContract,
+			noncesSelector+common.Bytes2Hex(common.LeftPadBytes(owner.Bytes(), 32)))
+		if err != nil {
+			fail("failed to read permit nonce (pass --nonce): " + err.Error())
+		}
+		if permitNonce, err = parseHexUint(word); err != nil {
+			fail(err.Error())
+		}
+	}
+	result.Nonce = permitNonce.String()
+	result.Deadline = time.Now().Add(deadline).Unix()
+
+	value, _ := new(big.Int).SetString(raw, 10)
+	sig, err := signer.SignTypedData(context.Background(),
+		x402evm.TypedDataDomain{
+			Name:              domainName,
+			Version:           domainVer,
+			ChainID:           chainIDOf(info),
+			VerifyingContract: info.USDCContract,
+		},
+		map[string][]x402evm.TypedDataField{
+			"Permit": {
+				{Name: "owner", Type: "address"},
+				{Name: "spender", Type: "address"},
+				{Name: "value", Type: "uint256"},
+				{Name: "nonce", Type: "uint256"},
+				{Name: "deadline", Type: "uint256"},
+			},
+		},
+		"Permit",
+		map[string]interface{}{
+			"owner":    owner.Hex(),
+			"spender":  common.HexToAddress(spender).Hex(),
+			"value":    value,
+			"nonce":    permitNonce,
+			"deadline": big.NewInt(result.Deadline),
+		},
+	)
+	if err != nil {
+		fail("failed to sign permit: " + err.Error())
+	}
+	result.Signature = "0x" + hex.EncodeToString(sig)
+	result.R = "0x" + hex.EncodeToString(sig[:32])
+	result.S = "0x" + hex.EncodeToString(sig[32:64])
+	result.V = sig[64]
+
+	if !jsonOut {
+		fmt.Printf("Network:   %s (%s)\n", info.Name, info.ChainID)
+		fmt.Printf("Token:     %s (%s v%s)\n", info.USDCContract, domainName, domainVer)
+		fmt.Printf("Owner:     %s\n", result.Owner)
+		fmt.Printf("Spender:   %s\n", common.HexToAddress(spender).Hex())
+		fmt.Printf("Value:     %s USDC (%s atomic units)\n", atomicToHuman(raw, info.Decimals), raw)
+		fmt.Printf("Nonce:     %s\n", result.Nonce)
+		fmt.Printf("Deadline:  %d (%s)\n", result.Deadline, time.Unix(result.Deadline, 0).Format(time.RFC3339))
+		fmt.Printf("Signature: %s\n", result.Signature)
+		fmt.Printf("  v: %d\n  r: %s\n  s: %s\n", result.V, result.R, result.S)
+	}
+
+	if submit {
+		prepared, err := prepareTx(info, key, common.HexToAddress(info.USDCContract),
+			permitCalldata(owner, common.HexToAddress(spender), value, big.NewInt(result.Deadline), sig))
+		if err != nil {
+			fail(err.Error())
+		}
+		result.Fee = prepared.fee
+		if !jsonOut {
+			fmt.Println()
+			printFee(prepared.fee)
+		}
+		if gas := checkGas(info, owner.Hex(), prepared.fee); gas != nil {
+			result.Warnings = append(result.Warnings, gas)
+			if !jsonOut {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", gas)
+				printGasTopUp(os.Stderr, gas)
+				os.Exit(ExitError)
+			}
+			fail(gas.Error())
+		}
+		if !autoYes {
+			if jsonOut {
+				fail("confirmation required: pass -y to submit in JSON mode")
+			}
+			fmt.Print("\nSubmit permit on-chain? [y/N] ")
+			scanner := bufio.NewScanner(os.Stdin)
+			if !scanner.Scan() || !strings.HasPrefix(strings.ToLower(strings.TrimSpace(scanner.Text())), "y") {
+				fmt.Println("Aborted.")
+				return
+			}
+		}
+		txHash, err := broadcastTx(info, key, prepared)
+		if err != nil {
+			fail(err.Error())
+		}
+		result.TxHash = txHash
+		if !jsonOut {
+			fmt.Printf("\nTransaction sent: %s\n", txHash)
+		}
+	}
+
+	if jsonOut {
+		printJSON(result)
+	}
+}
+
+// permitCalldata ABI-encodes permit(owner, spender, value, deadline, v, r, s).
+func permitCalldata(owner, spender common.Address, value, deadline *big.Int, sig []byte) []byte {
+	data, _ := hex.DecodeString(permitSelector)
+	data = append(data, common.LeftPadBytes(owner.Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(spender.Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(value.Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(deadline.Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes([]byte{sig[64]}, 32)...)
+	data = append(data, sig[:32]...)
+	data = append(data, sig[32:64]...)
+	return data
+}
+
+// queryTokenString calls a no-argument view function returning an ABI-encoded string.
+func queryTokenString(info networkInfo, selector string) (string, error) {
+	result, err := ethCall(info.RPCURL, info.USDCContract, selector)
+	if err != nil {
+		return "", err
+	}
+	b, err := hex.DecodeString(strings.TrimPrefix(result, "0x"))
+	if err != nil || len(b) < 64 {
+		return "", fmt.Errorf("invalid string result: %s", result)
+	}
+	length := new(big.Int).SetBytes(b[32:64]).Int64()
+	if length < 0 || 64+length > int64(len(b)) {
+		return "", fmt.Errorf("invalid string result: %s", result)
+	}
+	return string(b[64 : 64+length]), nil
+}
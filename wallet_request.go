@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"rsc.io/qr"
+)
+
+// requestResult is the JSON output for `x402-cli wallet request`.
+type requestResult struct {
+	Network string `json:"network"`
+	ChainID string `json:"chainId"`
+	Token   string `json:"token"`
+	To      string `json:"to"`
+	Amount  string `json:"amount,omitempty"`
+	Raw     string `json:"raw,omitempty"`
+	URI     string `json:"uri"`
+	Error   string `json:"error,omitempty"`
+}
+
+// runWalletRequestCmd prints an EIP-681 URI requesting a USDC transfer to the wallet.
+func runWalletRequestCmd(args []string) {
+	fs := flag.NewFlagSet("wallet request", flag.ExitOnError)
+	var (
+		network string
+		amount  string
+		address string
+		noQR    bool
+		jsonOut bool
+	)
+	fs.StringVar(&network, "network", "", "Network to receive USDC on (required)")
+	fs.StringVar(&amount, "amount", "", "Amount of USDC to request, e.g. 5 or 0.25 (default: let the payer choose)")
+	fs.StringVar(&address, "address", "", "Recipient address (default: EVM_PRIVATE_KEY wallet)")
+	fs.BoolVar(&noQR, "no-qr", false, "Do not draw the URI as a QR code")
+	fs.BoolVar(&jsonOut, "json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli wallet request --network <name> [--amount <usdc>] [--address 0x...] [--no-qr] [--json]\n\n")
+		fmt.Fprintf(os.Stderr, "Prints an EIP-681 payment URI for a USDC transfer to the wallet, to request funding\n")
+		fmt.Fprintf(os.Stderr, "from a person or another wallet, and draws it as a QR code that mobile wallets can scan.\n\n")
+		fmt.Fprintf(os.Stderr, "Networks: %s\n\n", availableNetworks())
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if network == "" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+
+	result := &requestResult{Network: network, To: address, Amount: amount}
+	fail := func(msg string) {
+		if jsonOut {
+			result.Error = msg
+			printJSON(result)
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", msg)
+		}
+		os.Exit(ExitError)
+	}
+
+	info, ok := networks[network]
+	if !ok {
+		fail(fmt.Sprintf("unknown network: %s (available: %s)", network, availableNetworks()))
+	}
+	result.ChainID = info.ChainID
+	result.Token = info.USDCContract
+
+	if result.To == "" {
+		key, err := loadPrivateKey()
+		if err != nil {
+			fail(err.Error())
+		}
+		result.To = crypto.PubkeyToAddress(key.PublicKey).Hex()
+	} else if _, err := parseAddress(result.To, "recipient"); err != nil {
+		fail(err.Error())
+	}
+	result.To = common.HexToAddress(result.To).Hex()
+
+	if amount != "" {
+		raw, err := humanToAtomic(amount, info.Decimals)
+		if err != nil {
+			fail(err.Error())
+		}
+		result.Raw = raw
+	}
+	result.URI = eip681TransferURI(info, result.To, result.Raw)
+
+	if jsonOut {
+		printJSON(result)
+		return
+	}
+	fmt.Printf("Network: %s (%s)\n", info.Name, info.ChainID)
+	fmt.Printf("To:      %s\n", result.To)
+	if result.Raw != "" {
+		fmt.Printf("Amount:  %s USDC (%s atomic units)\n", atomicToHuman(result.Raw, info.Decimals), result.Raw)
+	}
+	fmt.Printf("\n%s\n", result.URI)
+	if !noQR {
+		fmt.Println()
+		if err := writeQR(os.Stdout, result.URI); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot draw the QR code: %v\n", err)
+		}
+	}
+}
+
+// qrQuietZone is the light border, in modules, drawn around a QR code so scanners find it.
+const qrQuietZone = 4
+
+// writeQR draws text as a QR code with block characters, two module rows per line. Light
+// modules are drawn and dark ones left blank, for a terminal with a dark background.
+func writeQR(w io.Writer, text string) error {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return err
+	}
+	light := func(x, y int) bool { return !code.Black(x-qrQuietZone, y-qrQuietZone) }
+	side := code.Size + 2*qrQuietZone
+	var b strings.Builder
+	for y := 0; y < side; y += 2 {
+		for x := 0; x < side; x++ {
+			// The row past the last one is light too: it is outside the code.
+			top, bottom := light(x, y), y+1 == side || light(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// eip681TransferURI builds an EIP-681 URI calling transfer(to, raw) on the network's
+// USDC contract. An empty raw amount leaves the value to the payer.
+func eip681TransferURI(info networkInfo, to, raw string) string {
+	uri := fmt.Sprintf("ethereum:%s@%s/transfer?address=%s",
+		info.USDCContract, strings.TrimPrefix(info.ChainID, "eip155:"), to)
+	if raw != "" {
+		uri += "&uint256=" + raw
+	}
+	return uri
+}
+
+// fundingLink is a wallet link that opens a prefilled USDC transfer to the signer.
+type fundingLink struct {
+	Wallet  string `json:"wallet"`
+	Network string `json:"network"`
+	Amount  string `json:"amount"`
+	URL     string `json:"url"`
+}
+
+// fundingLinks builds transfer links for every USDC option in the payment requirements,
+// each funding address with the required amount. MetaMask takes an EIP-681 path on its
+// universal link; Coinbase Wallet and most other wallets open the plain EIP-681 URI.
+func fundingLinks(address string, requirements []byte) []fundingLink {
+	var payReq struct {
+		Accepts []struct {
+			Amount  string `json:"amount"`
+			Asset   string `json:"asset"`
+			Network string `json:"network"`
+		} `json:"accepts"`
+	}
+	if err := json.Unmarshal(requirements, &payReq); err != nil || !common.IsHexAddress(address) {
+		return nil
+	}
+
+	var links []fundingLink
+	for _, a := range payReq.Accepts {
+		info, ok := networkByChainID(a.Network)
+		if !ok || !strings.EqualFold(a.Asset, info.USDCContract) {
+			continue
+		}
+		to := common.HexToAddress(routedAddress(a.Network, address)).Hex()
+		uri := eip681TransferURI(info, to, a.Amount)
+		amount := atomicToHuman(a.Amount, info.Decimals) + " USDC"
+		links = append(links,
+			fundingLink{Wallet: "MetaMask", Network: info.Name, Amount: amount,
+				URL: "https://metamask.app.link/send/" + strings.TrimPrefix(uri, "ethereum:")},
+			fundingLink{Wallet: "Coinbase Wallet / EIP-681", Network: info.Name, Amount: amount, URL: uri},
+		)
+	}
+	return links
+}
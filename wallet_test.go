@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	x402 "github.com/coinbase/x402/go"
+)
+
+func TestHumanToAtomic(t *testing.T) {
+	tests := []struct {
+		amount  string
+		want    string
+		wantErr bool
+	}{
+		{"10", "10000000", false},
+		{"0.5", "500000", false},
+		{".001", "1000", false},
+		{"1.123456", "1123456", false},
+		{"1.1234567", "", true},
+		{"-1", "", true},
+		{"abc", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := humanToAtomic(tt.amount, 6)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("humanToAtomic(%q) = (%q, %v), want %q (err %v)", tt.amount, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestEIP681TransferURI(t *testing.T) {
+	info := networks["base"]
+	to := "0x1111111111111111111111111111111111111111"
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"5000000", "ethereum:" + info.USDCContract + "@8453/transfer?address=" + to + "&uint256=5000000"},
+		{"", "ethereum:" + info.USDCContract + "@8453/transfer?address=" + to},
+	}
+
+	for _, tt := range tests {
+		if got := eip681TransferURI(info, to, tt.raw); got != tt.want {
+			t.Errorf("eip681TransferURI(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestWriteQR(t *testing.T) {
+	uri := eip681TransferURI(networks["base"], "0x1111111111111111111111111111111111111111", "5000000")
+	var out strings.Builder
+	if err := writeQR(&out, uri); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	side := len([]rune(lines[0]))
+	if side < 21+2*qrQuietZone || len(lines) != (side+1)/2 {
+		t.Fatalf("a %d-wide code has %d lines", side, len(lines))
+	}
+	for i, line := range lines {
+		if n := len([]rune(line)); n != side {
+			t.Fatalf("line %d is %d wide, want %d", i, n, side)
+		}
+	}
+	if lines[0] != strings.Repeat("█", side) {
+		t.Errorf("the quiet zone is not light: %q", lines[0])
+	}
+	// The top-left finder pattern starts with a dark row of 7 modules over a row that is
+	// dark only at both ends.
+	if got := string([]rune(lines[qrQuietZone/2])[qrQuietZone-1 : qrQuietZone+8]); got != "█ ▄▄▄▄▄ █" {
+		t.Errorf("finder pattern top = %q", got)
+	}
+}
+
+func TestFundingLinks(t *testing.T) {
+	info := networks["base-sepolia"]
+	to := "0x1111111111111111111111111111111111111111"
+	tests := []struct {
+		name         string
+		requirements string
+		want         int
+	}{
+		{"usdc option", `{"accepts":[{"amount":"1000","asset":"` + info.USDCContract + `","network":"` + info.ChainID + `"}]}`, 2},
+		{"unknown asset", `{"accepts":[{"amount":"1000","asset":"0x2222222222222222222222222222222222222222","network":"` + info.ChainID + `"}]}`, 0},
+		{"unknown network", `{"accepts":[{"amount":"1000","asset":"` + info.USDCContract + `","network":"eip155:1"}]}`, 0},
+		{"not json", `nope`, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			links := fundingLinks(to, []byte(tt.requirements))
+			if len(links) != tt.want {
+				t.Fatalf("got %d links, want %d", len(links), tt.want)
+			}
+			if tt.want > 0 && links[0].URL != "https://metamask.app.link/send/"+info.USDCContract+"@84532/transfer?address="+to+"&uint256=1000" {
+				t.Errorf("unexpected MetaMask link %q", links[0].URL)
+			}
+		})
+	}
+}
+
+func TestWeiToUnit(t *testing.T) {
+	tests := []struct {
+		wei      string
+		decimals int
+		want     string
+	}{
+		{"1000000000000000000", 18, "1"},
+		{"21000000000000", 18, "0.000021"},
+		{"1500000000", 9, "1.5"},
+		{"0", 9, "0"},
+	}
+
+	for _, tt := range tests {
+		if got := weiToUnit(tt.wei, tt.decimals); got != tt.want {
+			t.Errorf("weiToUnit(%q, %d) = %q, want %q", tt.wei, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestDescribeWallet(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	defer func(p string) { profile = p }(profile)
+
+	if err := saveWalletMeta(map[string]walletMeta{
+		"ci-bot": {Label: "ci-bot", Network: "base-sepolia"},
+		"prod":   {Note: "no label"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	const addr = "0x1111111111111111111111111111111111111111"
+	tests := []struct {
+		profile string
+		want    string
+	}{
+		{"", addr},
+		{"ci_bot", "ci-bot (base-sepolia) " + addr},
+		{"prod", "prod " + addr},
+		{"unknown", "unknown " + addr},
+	}
+
+	for _, tt := range tests {
+		profile = tt.profile
+		if got := describeWallet(addr); got != tt.want {
+			t.Errorf("describeWallet() with profile %q = %q, want %q", tt.profile, got, tt.want)
+		}
+	}
+}
+
+func TestAmountFormat(t *testing.T) {
+	tests := []struct {
+		spec     string
+		raw      string
+		decimals int
+		want     string
+	}{
+		{"", "10000000", 6, "10.00"},
+		{"", "1000", 6, "0.001"},
+		{"", "1234567", 6, "1.234567"},
+		{"", "0", 0, "0"},
+		{"decimals=2", "1234567", 6, "1.23"},
+		{"decimals=2", "1235000", 6, "1.24"},
+		{"decimals=0", "999500", 6, "1"},
+		{"decimals=8", "1000", 6, "0.00100000"},
+		{"locale=en", "1234567890000", 6, "1,234,567.89"},
+		{"locale=de", "1234567890000", 6, "1.234.567,89"},
+		{"locale=de-DE,decimals=2", "1234567890000", 6, "1.234.567,89"},
+		{"locale=fr", "1234000000", 6, "1 234,00"},
+		{"locale=ch", "123456000000", 6, "123'456.00"},
+		{"thousands=underscore", "1000000000000", 6, "1_000_000.00"},
+		{"locale=en,thousands=none", "1234000000", 6, "1234.00"},
+		{"", "-1500000", 6, "-1.50"},
+	}
+
+	for _, tt := range tests {
+		f, err := parseAmountFormat(tt.spec)
+		if err != nil {
+			t.Fatalf("parseAmountFormat(%q): %v", tt.spec, err)
+		}
+		if got := f.format(tt.raw, tt.decimals); got != tt.want {
+			t.Errorf("format(%q, %d) with %q = %q, want %q", tt.raw, tt.decimals, tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseAmountFormatErrors(t *testing.T) {
+	for _, spec := range []string{
+		"locale=xx",
+		"decimals=-1",
+		"decimals=many",
+		"thousands=tab",
+		"color=red",
+		"decimals",
+		"thousands=dot",
+	} {
+		if _, err := parseAmountFormat(spec); err == nil {
+			t.Errorf("parseAmountFormat(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestBalanceCache(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	var calls atomic.Int32
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":"0x%064x"}`, 1500000)
+	}))
+	defer rpc.Close()
+	info := networkInfo{ChainID: "eip155:1", RPCURL: rpc.URL, USDCContract: "0xUSDC", Decimals: 6}
+	addr := "0x00000000000000000000000000000000000000aa"
+
+	if human, _, cachedAt, err := usdcBalance(info, addr, false); err != nil || human != atomicToHuman("1500000", 6) || !cachedAt.IsZero() || calls.Load() != 1 {
+		t.Fatalf("first query = (%q, %v, %v), %d RPC calls", human, cachedAt, err, calls.Load())
+	}
+	if _, raw, cachedAt, err := usdcBalance(info, addr, false); err != nil || raw != "1500000" || cachedAt.IsZero() || calls.Load() != 1 {
+		t.Errorf("second query should come from the cache: raw %q, cachedAt %v, err %v, %d RPC calls", raw, cachedAt, err, calls.Load())
+	}
+	if _, _, cachedAt, _ := usdcBalance(info, addr, true); !cachedAt.IsZero() || calls.Load() != 2 {
+		t.Errorf("a live query must bypass the cache: cachedAt %v, %d RPC calls", cachedAt, calls.Load())
+	}
+
+	t.Setenv("X402_BALANCE_TTL", "0")
+	if _, _, cachedAt, _ := usdcBalance(info, addr, false); !cachedAt.IsZero() || calls.Load() != 3 {
+		t.Errorf("X402_BALANCE_TTL=0 must disable the cache: cachedAt %v, %d RPC calls", cachedAt, calls.Load())
+	}
+}
+
+func TestGasAndPriceHelpers(t *testing.T) {
+	word := func(v int64) string { return fmt.Sprintf("%064x", v) }
+	now := time.Unix(1_800_000_000, 0)
+	round := "0x" + word(1) + word(250000000000) + word(0) + word(now.Unix()-60) + word(1)
+	price, err := parseRoundData(round, 8, now)
+	if err != nil || price.FloatString(2) != "2500.00" {
+		t.Fatalf("parseRoundData = (%v, %v), want 2500", price, err)
+	}
+	if _, err := parseRoundData(round, 8, now.Add(48*time.Hour)); err == nil {
+		t.Error("parseRoundData should refuse a stale answer")
+	}
+	if got := weiToUSD("1000000000000000", price); got != "2.5" { // 0.001 ETH
+		t.Errorf("weiToUSD = %q, want 2.5", got)
+	}
+
+	desc := "0x" + word(32) + word(9) + fmt.Sprintf("%-64x", "ETH / USD")
+	desc = strings.ReplaceAll(desc, " ", "0")
+	if got := decodeABIString(desc); got != "ETH / USD" {
+		t.Errorf("decodeABIString = %q", got)
+	}
+
+	fee := &feeEstimate{Max: "2500000000000000", Balance: "1000000000000000", Symbol: "ETH"}
+	sepolia := networks["base-sepolia"]
+	gas := checkGas(sepolia, "0xabc", fee)
+	if gas == nil || !strings.Contains(gas.Error(), "insufficient ETH for gas") {
+		t.Fatalf("checkGas with too little balance: %v", gas)
+	}
+	if gas.Code != "insufficient_gas" || gas.Shortfall != "0.0015" || gas.Required != "0.0025" || gas.Balance != "0.001" {
+		t.Errorf("checkGas warning = %+v", gas)
+	}
+	if len(gas.Faucets) == 0 || len(gas.Bridges) != 0 {
+		t.Errorf("testnet warning: faucets %v, bridges %v", gas.Faucets, gas.Bridges)
+	}
+	if gas := checkGas(networks["base"], "0xabc", fee); gas == nil || len(gas.Bridges) == 0 || len(gas.Faucets) != 0 {
+		t.Errorf("mainnet warning: %+v", gas)
+	}
+	fee.Balance = fee.Max
+	if gas := checkGas(sepolia, "0xabc", fee); gas != nil {
+		t.Errorf("checkGas with enough balance: %v", gas)
+	}
+	if gas := checkGas(sepolia, "0xabc", &feeEstimate{Max: "2000"}); gas != nil {
+		t.Errorf("checkGas without a known balance must not block: %v", gas)
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	const checksummed = "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"
+	tests := []struct {
+		in      string
+		wantErr string
+	}{
+		{checksummed, ""},
+		{strings.ToLower(checksummed), ""},
+		{"0x" + strings.ToUpper(checksummed[2:]), ""},
+		{"0xF39Fd6e51aad88F6F4ce6aB8827279cffFb92266", "bad EIP-55 checksum"},
+		{"0x0000000000000000000000000000000000000000", "zero"},
+		{"f39Fd6e51aad88F6F4ce6aB8827279cffFb92266", "40 hex digits"},
+		{"0xf39Fd6e51aad88F6F4ce6aB8827279cffFb9226", "40 hex digits"},
+		{"0xg39Fd6e51aad88F6F4ce6aB8827279cffFb92266", "40 hex digits"},
+		{"", "40 hex digits"},
+	}
+	for _, tt := range tests {
+		addr, err := parseAddress(tt.in, "test")
+		if tt.wantErr == "" {
+			if err != nil || addr.Hex() != checksummed {
+				t.Errorf("parseAddress(%q) = (%s, %v), want %s", tt.in, addr.Hex(), err, checksummed)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("parseAddress(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+		}
+	}
+
+	if got := checksumAddress(strings.ToLower(checksummed)); got != checksummed {
+		t.Errorf("checksumAddress = %s, want %s", got, checksummed)
+	}
+	if got := checksumAddress("So1anaAddress"); got != "So1anaAddress" {
+		t.Errorf("checksumAddress changed a non-EVM address: %s", got)
+	}
+	if err := checkPayTo(x402.PaymentRequirements{Network: "eip155:84532", PayTo: "0x0000000000000000000000000000000000000000"}); err == nil {
+		t.Error("checkPayTo accepted the zero address")
+	}
+	if err := checkPayTo(x402.PaymentRequirements{Network: "solana:devnet", PayTo: "So1anaAddress"}); err != nil {
+		t.Errorf("checkPayTo on a non-EVM network: %v", err)
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// approveSelector is the ERC-20 approve(address,uint256) function selector.
+const approveSelector = "095ea7b3"
+
+// txResult is the JSON output for wallet commands that broadcast a transaction.
+type txResult struct {
+	Action   string        `json:"action"`
+	Network  string        `json:"network"`
+	ChainID  string        `json:"chainId"`
+	From     string        `json:"from"`
+	To       string        `json:"to"`
+	Spender  string        `json:"spender,omitempty"`
+	Amount   string        `json:"amount,omitempty"`
+	Raw      string        `json:"raw,omitempty"`
+	Calldata string        `json:"calldata"`
+	DryRun   bool          `json:"dryRun"`
+	Fee      *feeEstimate  `json:"fee,omitempty"`
+	TxHash   string        `json:"txHash,omitempty"`
+	Warnings []*gasWarning `json:"warnings,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// runWalletApproveCmd sets a USDC allowance for a spender.
+func runWalletApproveCmd(args []string) {
+	fs := flag.NewFlagSet("wallet approve", flag.ExitOnError)
+	var (
+		network string
+		spender string
+		amount  string
+		dryRun  bool
+		autoYes bool
+		jsonOut bool
+	)
+	fs.StringVar(&network, "network", "", "Network to approve on (required)")
+	fs.StringVar(&spender, "spender", "", "Spender address to approve (required)")
+	fs.StringVar(&amount, "amount", "", "Allowance in USDC, e.g. 10 or 0.5 (required)")
+	fs.BoolVar(&dryRun, "dry-run", false, "Print the transaction and calldata without sending")
+	fs.BoolVar(&autoYes, "yes", false, "Send without prompting for confirmation")
+	fs.BoolVar(&autoYes, "y", false, "Send without prompting for confirmation (shorthand)")
+	fs.BoolVar(&jsonOut, "json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli wallet approve --network <name> --spender 0x... --amount <usdc> [--dry-run] [-y] [--json]\n\n")
+		fmt.Fprintf(os.Stderr, "Sets the USDC allowance of a spender (ERC-20 approve) for the EVM_PRIVATE_KEY wallet.\n\n")
+		fmt.Fprintf(os.Stderr, "Networks: %s\n\n", availableNetworks())
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if network == "" || spender == "" || amount == "" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	runApprove("approve", network, spender, amount, dryRun, autoYes, jsonOut)
+}
+
+// runWalletRevokeCmd zeroes a spender's USDC allowance.
+func runWalletRevokeCmd(args []string) {
+	fs := flag.NewFlagSet("wallet revoke", flag.ExitOnError)
+	var (
+		network string
+		spender string
+		dryRun  bool
+		autoYes bool
+		jsonOut bool
+	)
+	fs.StringVar(&network, "network", "", "Network to revoke on (required)")
+	fs.StringVar(&spender, "spender", "", "Spender address to revoke (required)")
+	fs.BoolVar(&dryRun, "dry-run", false, "Print the transaction and calldata without sending")
+	fs.BoolVar(&autoYes, "yes", false, "Send without prompting for confirmation")
+	fs.BoolVar(&autoYes, "y", false, "Send without prompting for confirmation (shorthand)")
+	fs.BoolVar(&jsonOut, "json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli wallet revoke --network <name> --spender 0x... [--dry-run] [-y] [--json]\n\n")
+		fmt.Fprintf(os.Stderr, "Sets a spender's USDC allowance to zero. List allowances with: x402-cli wallet allowances\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if network == "" || spender == "" {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	runApprove("revoke", network, spender, "0", dryRun, autoYes, jsonOut)
+}
+
+// runApprove builds, confirms, and sends an approve transaction; revoke is approve(spender, 0).
+func runApprove(action, network, spender, amount string, dryRun, autoYes, jsonOut bool) {
+	result := &txResult{Action: action, Network: network, Spender: spender, Amount: amount, DryRun: dryRun}
+
+	info, ok := networks[network]
+	if !ok {
+		exitTx(result, jsonOut, fmt.Sprintf("unknown network: %s (available: %s)", network, availableNetworks()))
+	}
+	result.ChainID = info.ChainID
+	result.To = info.USDCContract
+
+	if _, err := parseAddress(spender, "spender"); err != nil {
+		exitTx(result, jsonOut, err.Error())
+	}
+	raw, err := humanToAtomic(amount, info.Decimals)
+	if err != nil {
+		exitTx(result, jsonOut, err.Error())
+	}
+	result.Raw = raw
+
+	key, err := loadPrivateKey()
+	if err != nil {
+		exitTx(result, jsonOut, err.Error())
+	}
+	result.From = crypto.PubkeyToAddress(key.PublicKey).Hex()
+
+	calldata := approveCalldata(common.HexToAddress(spender), raw)
+	result.Calldata = "0x" + hex.EncodeToString(calldata)
+
+	if !jsonOut {
+		fmt.Printf("Network:  %s (%s)\n", info.Name, info.ChainID)
+		fmt.Printf("From:     %s\n", result.From)
+		fmt.Printf("Token:    %s (USDC)\n", info.USDCContract)
+		fmt.Printf("Spender:  %s\n", common.HexToAddress(spender).Hex())
+		fmt.Printf("Amount:   %s USDC (%s atomic units)\n", atomicToHuman(raw, info.Decimals), raw)
+		fmt.Printf("Calldata: %s\n", result.Calldata)
+	}
+
+	prepared, err := prepareTx(info, key, common.HexToAddress(info.USDCContract), calldata)
+	if err == nil {
+		result.Fee = prepared.fee
+		if !jsonOut {
+			printFee(prepared.fee)
+		}
+		if gas := checkGas(info, result.From, prepared.fee); gas != nil {
+			result.Warnings = append(result.Warnings, gas)
+			if !dryRun && !jsonOut {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", gas)
+				printGasTopUp(os.Stderr, gas)
+				os.Exit(ExitError)
+			} else if !dryRun {
+				exitTx(result, jsonOut, gas.Error())
+			} else if !jsonOut {
+				fmt.Printf("Warning:  %v\n", gas)
+				printGasTopUp(os.Stdout, gas)
+			}
+		}
+	} else if !dryRun {
+		exitTx(result, jsonOut, err.Error())
+	} else if !jsonOut {
+		fmt.Printf("Fee:      unavailable (%v)\n", err)
+	}
+
+	if dryRun {
+		if jsonOut {
+			printJSON(result)
+		} else {
+			fmt.Println("\n--dry-run: transaction not sent.")
+		}
+		return
+	}
+
+	if !autoYes {
+		if jsonOut {
+			exitTx(result, jsonOut, "confirmation required: pass -y to send in JSON mode")
+		}
+		fmt.Print("\nSend transaction? [y/N] ")
+		scanner := bufio.NewScanner(os.Stdin)
+		if !scanner.Scan() || !strings.HasPrefix(strings.ToLower(strings.TrimSpace(scanner.Text())), "y") {
+			fmt.Println("Aborted.")
+			return
+		}
+	}
+
+	txHash, err := broadcastTx(info, key, prepared)
+	if err != nil {
+		exitTx(result, jsonOut, err.Error())
+	}
+	result.TxHash = txHash
+
+	if jsonOut {
+		printJSON(result)
+		return
+	}
+	fmt.Printf("\nTransaction sent: %s\n", txHash)
+}
+
+// approveCalldata ABI-encodes approve(spender, amount).
+func approveCalldata(spender common.Address, rawAmount string) []byte {
+	amount, _ := new(big.Int).SetString(rawAmount, 10)
+	data, _ := hex.DecodeString(approveSelector)
+	data = append(data, common.LeftPadBytes(spender.Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(amount.Bytes(), 32)...)
+	return data
+}
+
+// loadPrivateKey reads and parses the active profile's private key.
+func loadPrivateKey() (*ecdsa.PrivateKey, error) {
+	return loadProfileKey(profile)
+}
+
+// loadProfileKey parses the signing key of profile p.
+func loadProfileKey(p string) (*ecdsa.PrivateKey, error) {
+	privateKey := os.Getenv(profileKeyVar(p))
+	if privateKey == "" {
+		return nil, fmt.Errorf("%s is required", profileKeyVar(p))
+	}
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(privateKey, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	return key, nil
+}
+
+// chainIDOf extracts the numeric chain ID from a CAIP-2 "eip155:<id>" identifier.
+func chainIDOf(info networkInfo) *big.Int {
+	id, _ := new(big.Int).SetString(strings.TrimPrefix(info.ChainID, "eip155:"), 10)
+	return id
+}
+
+// gasPriceOracle is the OP-stack predeploy that prices L1 data fees.
+const gasPriceOracle = "0x420000000000000000000000000000000000000F"
+
+// getL1FeeSelector is the GasPriceOracle getL1Fee(bytes) function selector.
+const getL1FeeSelector = "49948e0e"
+
+// feeEstimate is the expected cost of a wallet transaction, in wei of the native token.
+type feeEstimate struct {
+	Gas       uint64 `json:"gas"`
+	BaseFee   string `json:"baseFee"`
+	Tip       string `json:"tip"`
+	L1Fee     string `json:"l1Fee,omitempty"`
+	Estimated string `json:"estimated"`
+	Max       string `json:"max"`
+	Symbol    string `json:"symbol"`
+	// EstimatedUSD and MaxUSD are the fee in dollars, when the network has a price feed.
+	EstimatedUSD string `json:"estimatedUsd,omitempty"`
+	MaxUSD       string `json:"maxUsd,omitempty"`
+	// Balance is the sender's native balance in wei, checked against Max before sending.
+	Balance string `json:"balance,omitempty"`
+}
+
+// preparedTx is an unsigned transaction together with its fee estimate.
+type preparedTx struct {
+	tx  *types.Transaction
+	fee *feeEstimate
+}
+
+// prepareTx builds an EIP-1559 contract call and estimates its fee. On OP-stack
+// networks the L1 data fee is added to the execution fee.
+func prepareTx(info networkInfo, key *ecdsa.PrivateKey, to common.Address, data []byte) (*preparedTx, error) {
+	from := crypto.PubkeyToAddress(key.PublicKey)
+
+	var nonceHex string
+	if err := rpcCall(info.RPCURL, "eth_getTransactionCount", []any{from.Hex(), "pending"}, &nonceHex); err != nil {
+		return nil, fmt.Errorf("failed to get nonce: %w", err)
+	}
+	nonce, err := parseHexUint(nonceHex)
+	if err != nil {
+		return nil, err
+	}
+
+	gas, err := estimateGas(info, from, to, data)
+	if err != nil {
+		return nil, err
+	}
+	baseFee, tip, err := feeCaps(info)
+	if err != nil {
+		return nil, err
+	}
+	maxFee := new(big.Int).Mul(baseFee, big.NewInt(2))
+	maxFee.Add(maxFee, tip)
+
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainIDOf(info),
+		Nonce:     nonce.Uint64(),
+		GasTipCap: tip,
+		GasFeeCap: maxFee,
+		Gas:       gas,
+		To:        &to,
+		Data:      data,
+	})
+
+	gasBig := new(big.Int).SetUint64(gas)
+	estimated := new(big.Int).Mul(gasBig, new(big.Int).Add(baseFee, tip))
+	maxCost := new(big.Int).Mul(gasBig, maxFee)
+	fee := &feeEstimate{
+		Gas:     gas,
+		BaseFee: baseFee.String(),
+		Tip:     tip.String(),
+		Symbol:  info.NativeSymbol,
+	}
+	if info.OPStack {
+		l1Fee, err := queryL1Fee(info, tx)
+		if err != nil {
+			return nil, err
+		}
+		fee.L1Fee = l1Fee.String()
+		estimated.Add(estimated, l1Fee)
+		maxCost.Add(maxCost, l1Fee)
+	}
+	fee.Estimated = estimated.String()
+	fee.Max = maxCost.String()
+
+	// The balance and USD price are informational; failing to read them never blocks a transaction.
+	var balanceHex string
+	if err := rpcCall(info.RPCURL, "eth_getBalance", []any{from.Hex(), "latest"}, &balanceHex); err == nil {
+		if balance, err := parseHexUint(balanceHex); err == nil {
+			fee.Balance = balance.String()
+		}
+	}
+	if price, err := nativeUSDPrice(info); err == nil {
+		fee.EstimatedUSD = weiToUSD(fee.Estimated, price)
+		fee.MaxUSD = weiToUSD(fee.Max, price)
+	}
+	return &preparedTx{tx: tx, fee: fee}, nil
+}
+
+// queryL1Fee asks the OP-stack GasPriceOracle for the L1 data fee of an unsigned transaction.
+func queryL1Fee(info networkInfo, tx *types.Transaction) (*big.Int, error) {
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode transaction: %w", err)
+	}
+	// ABI-encode the dynamic bytes argument: offset, length, right-padded data.
+	padded := make([]byte, (len(raw)+31)/32*32)
+	copy(padded, raw)
+	data := getL1FeeSelector +
+		common.Bytes2Hex(common.LeftPadBytes(big.NewInt(32).Bytes(), 32)) +
+		common.Bytes2Hex(common.LeftPadBytes(big.NewInt(int64(len(raw))).Bytes(), 32)) +
+		common.Bytes2Hex(padded)
+	result, err := ethCall(info.RPCURL, gasPriceOracle, "0x"+data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get L1 data fee: %w", err)
+	}
+	return parseHexUint(result)
+}
+
+// printFee shows the fee estimate before a transaction is confirmed.
+func printFee(fee *feeEstimate) {
+	fmt.Printf("Gas:      %d at %s gwei base + %s gwei tip\n", fee.Gas, weiToUnit(fee.BaseFee, 9), weiToUnit(fee.Tip, 9))
+	if fee.L1Fee != "" {
+		fmt.Printf("L1 fee:   %s %s\n", weiToUnit(fee.L1Fee, 18), fee.Symbol)
+	}
+	if fee.EstimatedUSD != "" {
+		fmt.Printf("Fee:      ~%s %s (~$%s; max %s %s, $%s)\n", weiToUnit(fee.Estimated, 18), fee.Symbol, fee.EstimatedUSD,
+			weiToUnit(fee.Max, 18), fee.Symbol, fee.MaxUSD)
+	} else {
+		fmt.Printf("Fee:      ~%s %s (max %s %s)\n", weiToUnit(fee.Estimated, 18), fee.Symbol, weiToUnit(fee.Max, 18), fee.Symbol)
+	}
+	if fee.Balance != "" {
+		fmt.Printf("Balance:  %s %s\n", weiToUnit(fee.Balance, 18), fee.Symbol)
+	}
+}
+
+// weiToUnit formats a wei amount with trailing zeros trimmed, e.g. 18 decimals for ETH or 9 for gwei.
+// Fees keep every significant digit, so only the separators of --amount-format apply.
+func weiToUnit(wei string, decimals int) string {
+	f := amountFmt
+	f.Decimals, f.MinDecimals = -1, 0
+	return f.format(wei, decimals)
+}
+
+// broadcastTx signs and sends a prepared transaction, returning its hash.
+func broadcastTx(info networkInfo, key *ecdsa.PrivateKey, p *preparedTx) (string, error) {
+	signed, err := types.SignTx(p.tx, types.LatestSignerForChainID(chainIDOf(info)), key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign transaction: %w", err)
+	}
+	rawTx, err := signed.MarshalBinary()
+	if err != nil {
+		return "", fmt.Errorf("failed to encode transaction: %w", err)
+	}
+
+	var txHash string
+	if err := rpcCall(info.RPCURL, "eth_sendRawTransaction", []any{"0x" + hex.EncodeToString(rawTx)}, &txHash); err != nil {
+		return "", fmt.Errorf("failed to send transaction: %w", err)
+	}
+	return txHash, nil
+}
+
+// estimateGas runs eth_estimateGas and adds a 20% safety margin.
+func estimateGas(info networkInfo, from, to common.Address, data []byte) (uint64, error) {
+	var gasHex string
+	err := rpcCall(info.RPCURL, "eth_estimateGas", []any{map[string]string{
+		"from": from.Hex(),
+		"to":   to.Hex(),
+		"data": "0x" + hex.EncodeToString(data),
+	}}, &gasHex)
+	if err != nil {
+		return 0, fmt.Errorf("failed to estimate gas: %w", err)
+	}
+	gas, err := parseHexUint(gasHex)
+	if err != nil {
+		return 0, err
+	}
+	return gas.Uint64() * 12 / 10, nil
+}
+
+// feeCaps returns the latest base fee and the suggested EIP-1559 priority fee.
+func feeCaps(info networkInfo) (*big.Int, *big.Int, error) {
+	var tipHex string
+	if err := rpcCall(info.RPCURL, "eth_maxPriorityFeePerGas", []any{}, &tipHex); err != nil {
+		return nil, nil, fmt.Errorf("failed to get priority fee: %w", err)
+	}
+	tip, err := parseHexUint(tipHex)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var block struct {
+		BaseFeePerGas string `json:"baseFeePerGas"`
+	}
+	if err := rpcCall(info.RPCURL, "eth_getBlockByNumber", []any{"latest", false}, &block); err != nil {
+		return nil, nil, fmt.Errorf("failed to get base fee: %w", err)
+	}
+	baseFee, err := parseHexUint(block.BaseFeePerGas)
+	if err != nil {
+		return nil, nil, err
+	}
+	return baseFee, tip, nil
+}
+
+// exitTx reports a wallet transaction error and exits.
+func exitTx(result *txResult, jsonOut bool, msg string) {
+	if jsonOut {
+		result.Error = msg
+		printJSON(result)
+	} else {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", msg)
+	}
+	os.Exit(ExitError)
+}
+
+// printJSON writes v to stdout as indented JSON.
+func printJSON(v any) {
+	out, _ := json.MarshalIndent(redactJSON(v), "", "  ")
+	fmt.Println(string(out))
+}
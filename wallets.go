@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// defaultProfile names the wallet keyed by plain EVM_PRIVATE_KEY.
+const defaultProfile = "default"
+
+// walletMeta is the user-assigned metadata for one profile's wallet.
+type walletMeta struct {
+	Label   string `json:"label,omitempty"`
+	Note    string `json:"note,omitempty"`
+	Network string `json:"network,omitempty"`
+}
+
+// walletListEntry is one row of `x402-cli wallet list`.
+type walletListEntry struct {
+	Profile string `json:"profile"`
+	EnvVar  string `json:"envVar"`
+	Address string `json:"address,omitempty"`
+	walletMeta
+}
+
+// walletsFile is where wallet metadata is stored, keyed by profile name.
+func walletsFile() (string, error) {
+	return stateFile("wallets.json")
+}
+
+// loadWalletMeta reads all wallet metadata; a missing file is empty.
+func loadWalletMeta() (map[string]walletMeta, error) {
+	meta := map[string]walletMeta{}
+	path, err := walletsFile()
+	if err != nil {
+		return meta, err
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return meta, nil
+	}
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return meta, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return meta, nil
+}
+
+// saveWalletMeta writes all wallet metadata.
+func saveWalletMeta(meta map[string]walletMeta) error {
+	path, err := walletsFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	out, _ := json.MarshalIndent(meta, "", "  ")
+	return os.WriteFile(path, append(out, '\n'), 0600)
+}
+
+// activeProfile is the metadata key of the wallet selected by --profile.
+func activeProfile() string {
+	if profile == "" {
+		return defaultProfile
+	}
+	return strings.ToLower(strings.ReplaceAll(profile, "_", "-"))
+}
+
+// activeWalletMeta returns the metadata of the active profile's wallet, if any.
+func activeWalletMeta() walletMeta {
+	meta, _ := loadWalletMeta()
+	return meta[activeProfile()]
+}
+
+// describeWallet renders the active wallet for confirmations, e.g.
+// "ci-bot (base-sepolia) 0xabc...". Unlabelled wallets use the profile name.
+func describeWallet(address string) string {
+	m := activeWalletMeta()
+	name := m.Label
+	if name == "" && profile != "" {
+		name = profile
+	}
+	if name == "" {
+		return address
+	}
+	if m.Network != "" {
+		name += " (" + m.Network + ")"
+	}
+	return name + " " + address
+}
+
+// envProfiles lists the profiles that have a private key in the environment.
+func envProfiles() []string {
+	var profiles []string
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if value == "" {
+			continue
+		}
+		if name == "EVM_PRIVATE_KEY" {
+			profiles = append(profiles, defaultProfile)
+		} else if suffix, ok := strings.CutPrefix(name, "EVM_PRIVATE_KEY_"); ok && suffix != "" {
+			profiles = append(profiles, strings.ToLower(strings.ReplaceAll(suffix, "_", "-")))
+		}
+	}
+	return profiles
+}
+
+// runWalletListCmd lists the wallets available as profiles, with their metadata.
+func runWalletListCmd(args []string) {
+	fs := flag.NewFlagSet("wallet list", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli wallet list [--json]\n\n")
+		fmt.Fprintf(os.Stderr, "Lists wallets from EVM_PRIVATE_KEY and EVM_PRIVATE_KEY_<PROFILE> with their labels,\n")
+		fmt.Fprintf(os.Stderr, "notes, and default networks. Set metadata with: x402-cli --profile <name> wallet label\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	meta, err := loadWalletMeta()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+
+	seen := map[string]bool{}
+	var names []string
+	for _, p := range envProfiles() {
+		seen[p] = true
+		names = append(names, p)
+	}
+	for p := range meta {
+		if !seen[p] {
+			names = append(names, p)
+		}
+	}
+	sort.Strings(names)
+
+	saved := profile
+	defer func() { profile = saved }()
+	entries := []walletListEntry{}
+	for _, p := range names {
+		profile = p
+		if p == defaultProfile {
+			profile = ""
+		}
+		entry := walletListEntry{Profile: p, EnvVar: privateKeyVar(), walletMeta: meta[p]}
+		if key, err := loadPrivateKey(); err == nil {
+			entry.Address = crypto.PubkeyToAddress(key.PublicKey).Hex()
+		}
+		entries = append(entries, entry)
+	}
+
+	if *jsonOut {
+		printJSON(entries)
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println("No wallets found. Set EVM_PRIVATE_KEY or EVM_PRIVATE_KEY_<PROFILE>.")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROFILE\tLABEL\tADDRESS\tNETWORK\tNOTE")
+	for _, e := range entries {
+		address := e.Address
+		if address == "" {
+			address = "(" + e.EnvVar + " not set)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Profile, dashIfEmpty(e.Label), address, dashIfEmpty(e.Network), e.Note)
+	}
+	w.Flush()
+}
+
+// runWalletLabelCmd sets the label, note, and default network of the active profile's wallet.
+func runWalletLabelCmd(args []string) {
+	fs := flag.NewFlagSet("wallet label", flag.ExitOnError)
+	var (
+		label   string
+		note    string
+		network string
+		clear   bool
+	)
+	fs.StringVar(&label, "label", "", "Short name shown in payment confirmations")
+	fs.StringVar(&note, "note", "", "Free-form note")
+	fs.StringVar(&network, "network", "", "Default network for this wallet")
+	fs.BoolVar(&clear, "clear", false, "Remove all metadata for this wallet")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: x402-cli [--profile <name>] wallet label [--label <name>] [--note <text>] [--network <name>] [--clear]\n\n")
+		fmt.Fprintf(os.Stderr, "Attaches metadata to the wallet of the active profile (EVM_PRIVATE_KEY without --profile).\n")
+		fmt.Fprintf(os.Stderr, "Only the given fields change.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if network != "" {
+		if _, ok := networks[network]; !ok {
+			fmt.Fprintf(os.Stderr, "Unknown network: %s\n", network)
+			fmt.Fprintf(os.Stderr, "Available: %s\n", availableNetworks())
+			os.Exit(ExitError)
+		}
+	}
+
+	meta, err := loadWalletMeta()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	key := activeProfile()
+	m := meta[key]
+	if clear {
+		delete(meta, key)
+	} else {
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "label":
+				m.Label = label
+			case "note":
+				m.Note = note
+			case "network":
+				m.Network = network
+			}
+		})
+		meta[key] = m
+	}
+	if err := saveWalletMeta(meta); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+
+	if clear {
+		fmt.Printf("Cleared metadata for profile %s.\n", key)
+		return
+	}
+	fmt.Printf("Profile: %s\nLabel:   %s\nNetwork: %s\nNote:    %s\n", key, dashIfEmpty(m.Label), dashIfEmpty(m.Network), m.Note)
+}
+
+// dashIfEmpty renders empty table cells as "-".
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}